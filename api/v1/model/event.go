@@ -23,18 +23,48 @@ const (
 
 	/* Additional event context is tracked by the following keys, depending on the event being generated:
 
-	+----------------+--------+-------------------------------------------------------------------+
-	| Event key name |  Type  |                            Description                            |
-	+----------------+--------+-------------------------------------------------------------------+
-	| uptime         | string | String formatted duration denoting how long the agent has been up |
-	| endpoint       | string | A network address                                                 |
-	| error          | string | An error string                                                   |
-	| node_id        | string | The last discovered blockchain node ID                            |
-	| node_type      | string | The last discovered blockchain node type                          |
-	| node_version   | string | The last discovered blockchain node version                       |
-	| offset_millis  | int64  | The agent's clock offset against NTP                              |
-	| ntp_server     | string | The NTP server used by the agent's clock                          |
-	+----------------+--------+-------------------------------------------------------------------+ */
+	+--------------------+--------+-------------------------------------------------------------------+
+	| Event key name     |  Type  |                            Description                            |
+	+--------------------+--------+-------------------------------------------------------------------+
+	| uptime             | string | String formatted duration denoting how long the agent has been up |
+	| endpoint           | string | A network address                                                 |
+	| error              | string | An error string                                                   |
+	| node_id            | string | The last discovered blockchain node ID                            |
+	| node_type          | string | The last discovered blockchain node type                          |
+	| node_version       | string | The last discovered blockchain node version                       |
+	| offset_millis      | int64  | The agent's clock offset against NTP                              |
+	| ntp_server         | string | The NTP server used by the agent's clock                          |
+	| reasons            | list   | Reasons that triggered a configuration rollback                   |
+	| backup_path        | string | Location of the newest backup artifact                            |
+	| backup_age         | string | String formatted duration denoting the newest backup's age        |
+	| threshold          | string | String formatted duration denoting the exceeded age threshold     |
+	| severity           | int    | Number of exceeded thresholds, increases as the condition worsens |
+	| watch              | string | Name of an agent watch                                            |
+	| cadence            | string | String formatted duration denoting a watch's expected cadence     |
+	| last_emit          | string | RFC3339 formatted time of a watch's last emission                 |
+	| attempt            | int    | Number of consecutive failures of a supervised watch              |
+	| backoff            | string | String formatted duration before a supervised watch is restarted  |
+	| suppressed         | int    | Number of messages suppressed by a watch's rate limit             |
+	| period             | string | String formatted duration the suppressed messages were counted in |
+	| shutdown_reason    | string | Reason of the agent's shutdown (signal, panic, command)           |
+	| shutdown_detail    | string | Shutdown reason details (i.e. the received signal)                |
+	| shutdown_at        | string | RFC3339 formatted time of the agent's shutdown                    |
+	| buffer_depths      | map    | Number of buffered messages per buffer at shutdown                |
+	| unflushed          | map    | Number of messages not yet handled per sink at shutdown           |
+	| node_status        | string | Name of the last node status event observed by the agent          |
+	| heartbeat          | map    | Context of the last agent.up event                                |
+	| last_week          | map    | Round time stats of the last 7 days: p50, p95, p99 in seconds,    |
+	|                    |        | samples, partial_days, from, to                                   |
+	| previous_week      | map    | Round time stats of the 7 days before last_week                   |
+	| change             | map    | Relative change of p50, p95, p99 from previous_week to last_week  |
+	| changed_facts      | string | Comma separated host facts that changed (machine_id, macs, ...)   |
+	| reset              | string | Comma separated components whose inherited state was reset        |
+	| quarantine         | string | Directory the inherited buffered state was moved to               |
+	| fingerprint        | string | The agent's host fingerprint                                      |
+	| prev_fingerprint   | string | The host fingerprint replaced by a rotation                       |
+	| <key>_offset       | string | Source zone offset of a timestamp normalized to UTC (i.e. +02:00) |
+	| <key>_zone_assumed | bool   | Set if a normalized timestamp had no zone and one was assumed     |
+	+--------------------+--------+-------------------------------------------------------------------+ */
 
 	// AgentUptimeKey used for indexing in Event.Values
 	AgentUptimeKey = "uptime"
@@ -59,6 +89,62 @@ const (
 	NTPServerKey = "ntp_server"
 	// NetworkKey used for indexing in Event.Values
 	NetworkKey = "network"
+	// ReasonsKey used for indexing in Event.Values
+	ReasonsKey = "reasons"
+	// BackupPathKey used for indexing in Event.Values
+	BackupPathKey = "backup_path"
+	// BackupAgeKey used for indexing in Event.Values
+	BackupAgeKey = "backup_age"
+	// ThresholdKey used for indexing in Event.Values
+	ThresholdKey = "threshold"
+	// SeverityKey used for indexing in Event.Values
+	SeverityKey = "severity"
+	// WatchKey used for indexing in Event.Values
+	WatchKey = "watch"
+	// CadenceKey used for indexing in Event.Values
+	CadenceKey = "cadence"
+	// LastEmitKey used for indexing in Event.Values
+	LastEmitKey = "last_emit"
+	// ShutdownReasonKey used for indexing in Event.Values
+	ShutdownReasonKey = "shutdown_reason"
+	// ShutdownDetailKey used for indexing in Event.Values
+	ShutdownDetailKey = "shutdown_detail"
+	// ShutdownAtKey used for indexing in Event.Values
+	ShutdownAtKey = "shutdown_at"
+	// BufferDepthsKey used for indexing in Event.Values
+	BufferDepthsKey = "buffer_depths"
+	// UnflushedKey used for indexing in Event.Values
+	UnflushedKey = "unflushed"
+	// NodeStatusKey used for indexing in Event.Values
+	NodeStatusKey = "node_status"
+	// HeartbeatKey used for indexing in Event.Values
+	HeartbeatKey = "heartbeat"
+	// LastWeekKey used for indexing in Event.Values
+	LastWeekKey = "last_week"
+	// PreviousWeekKey used for indexing in Event.Values
+	PreviousWeekKey = "previous_week"
+	// ChangeKey used for indexing in Event.Values
+	ChangeKey = "change"
+	// ChangedFactsKey used for indexing in Event.Values
+	ChangedFactsKey = "changed_facts"
+	// ResetKey used for indexing in Event.Values
+	ResetKey = "reset"
+	// QuarantineKey used for indexing in Event.Values
+	QuarantineKey = "quarantine"
+	// PIDKey used for indexing in Event.Values
+	PIDKey = "pid"
+	// AttemptKey used for indexing in Event.Values
+	AttemptKey = "attempt"
+	// BackoffKey used for indexing in Event.Values
+	BackoffKey = "backoff"
+	// SuppressedKey used for indexing in Event.Values
+	SuppressedKey = "suppressed"
+	// PeriodKey used for indexing in Event.Values
+	PeriodKey = "period"
+	// FingerprintKey used for indexing in Event.Values
+	FingerprintKey = "fingerprint"
+	// PreviousFingerprintKey used for indexing in Event.Values
+	PreviousFingerprintKey = "prev_fingerprint"
 
 	/* core specific events */
 
@@ -71,6 +157,29 @@ const (
 	// AgentNetErrorName The agent failed to send data to the backend. Ctx: error
 	AgentNetErrorName = "agent.net.error"
 
+	// AgentWatchStalledName An agent watch has not emitted within its expected cadence. Ctx: watch, cadence, last_emit
+	AgentWatchStalledName = "agent.watch.stalled"
+
+	// AgentWatchRestartName A supervised watch failed and is being restarted. Ctx: watch, error, attempt, backoff
+	AgentWatchRestartName = "agent.watch.restart"
+
+	// AgentWatchSuppressedName A watch suppressed messages over its rate limit. Ctx: suppressed, period
+	AgentWatchSuppressedName = "agent.watch.suppressed"
+
+	// AgentPreviousShutdownName The agent's previous run shut down gracefully.
+	// Ctx: shutdown_reason, shutdown_detail, shutdown_at, uptime, buffer_depths, unflushed, node_status, heartbeat
+	AgentPreviousShutdownName = "agent.previous_shutdown"
+
+	// AgentUncleanShutdownName The agent's previous run did not shut down gracefully
+	AgentUncleanShutdownName = "agent.unclean_shutdown"
+
+	// AgentHostClonedName The agent's state was inherited from the host this one was cloned
+	// from and was reset. Ctx: changed_facts, reset, quarantine
+	AgentHostClonedName = "agent.host.cloned"
+
+	// AgentFingerprintRotatedName The agent's host fingerprint was replaced on request. Ctx: fingerprint, prev_fingerprint
+	AgentFingerprintRotatedName = "agent.fingerprint.rotated"
+
 	// AgentHealthName The agent self-test results (not implemented)
 	AgentHealthName = "agent.health"
 
@@ -94,9 +203,24 @@ const (
 	// AgentNodeLogFoundName The node log file has been found. Ctx: node_id, node_type,  node_version
 	AgentNodeLogFoundName = "agent.node.log.found"
 
+	// AgentNodeBackupStaleName The newest node backup exceeds an age threshold. Ctx: backup_path, backup_age, threshold, severity
+	AgentNodeBackupStaleName = "agent.node.backup.stale"
+
+	// AgentNodeBackupFreshName The newest node backup no longer exceeds any age threshold. Ctx: backup_path, backup_age
+	AgentNodeBackupFreshName = "agent.node.backup.fresh"
+
+	// AgentNodeBackupMissingName No node backup artifact was found. Ctx: backup_path
+	AgentNodeBackupMissingName = "agent.node.backup.missing"
+
+	// AgentNodeRoundTimeWeeklyName Weekly summary of the node's round time. Ctx: last_week, previous_week, change
+	AgentNodeRoundTimeWeeklyName = "agent.node.round_time.weekly"
+
 	// AgentConfigMissingName The agent configuration has gone missing (not implemented)
 	AgentConfigMissingName = "agent.config.missing"
 
+	// AgentConfigRolledBackName The agent rolled back to its previous configuration. Ctx: reasons
+	AgentConfigRolledBackName = "agent.config.rolled_back"
+
 	// AgentClockSyncName The agent synchronized its clock to NTP. Ctx: offset_millis, ntp_server
 	AgentClockSyncName = "agent.clock.sync"
 
@@ -126,6 +250,13 @@ func NewWithFilteredCtx(ctx map[string]interface{}, name string, t time.Time, ke
 		if val, ok := ctx[key]; ok {
 			filtered[key] = val
 		}
+
+		// keep the details of normalized timestamps along with them
+		for _, suffix := range []string{TimeOffsetSuffix, TimeZoneAssumedSuffix} {
+			if val, ok := ctx[key+suffix]; ok {
+				filtered[key+suffix] = val
+			}
+		}
 	}
 
 	values, err := structpb.NewStruct(filtered)
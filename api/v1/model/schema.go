@@ -0,0 +1,224 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// SchemaVersion version of the encoding used for a batch of messages.
+type SchemaVersion uint16
+
+const (
+	// SchemaVersionJSON batches encoded as protobuf JSON.
+	SchemaVersionJSON SchemaVersion = 1
+
+	// SchemaVersionProtobuf batches encoded as protobuf binary.
+	SchemaVersionProtobuf SchemaVersion = 2
+
+	// CurrentSchemaVersion the preferred schema version of this agent.
+	CurrentSchemaVersion = SchemaVersionProtobuf
+)
+
+// batchMagic identifies an encoded batch. A batch header is the magic
+// followed by the big-endian schema version.
+var batchMagic = [2]byte{'M', 'A'}
+
+const batchHeaderLen = len(batchMagic) + 2
+
+var (
+	// ErrUnknownSchemaVersion returned when decoding a batch encoded with
+	// a schema version not present in the registry.
+	ErrUnknownSchemaVersion = errors.New("unknown schema version")
+
+	// ErrNoCommonSchemaVersion returned when negotiation finds no version
+	// supported by both sides.
+	ErrNoCommonSchemaVersion = errors.New("no common schema version")
+
+	// ErrInvalidBatchHeader returned when a batch does not start with a valid header.
+	ErrInvalidBatchHeader = errors.New("invalid batch header")
+)
+
+// SchemaCodec encodes and decodes batches for a single schema version.
+type SchemaCodec interface {
+	Encode(msg *PlatformMessage) ([]byte, error)
+	Decode(data []byte) (*PlatformMessage, error)
+}
+
+// schemaRegistry schema versions known to this agent.
+var schemaRegistry = map[SchemaVersion]SchemaCodec{
+	SchemaVersionJSON:     jsonCodec{},
+	SchemaVersionProtobuf: protobufCodec{},
+}
+
+// SupportedSchemaVersions returns the registered schema versions in ascending order.
+func SupportedSchemaVersions() []SchemaVersion {
+	versions := make([]SchemaVersion, 0, len(schemaRegistry))
+	for v := range schemaRegistry {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	return versions
+}
+
+// SchemaCodecFor returns the codec registered for version v.
+func SchemaCodecFor(v SchemaVersion) (SchemaCodec, error) {
+	codec, ok := schemaRegistry[v]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d (supported: %s)", ErrUnknownSchemaVersion, v, FormatSchemaVersions(SupportedSchemaVersions()))
+	}
+
+	return codec, nil
+}
+
+// EncodeBatch encodes msg with the codec of version v, prefixed by the batch header.
+func EncodeBatch(v SchemaVersion, msg *PlatformMessage) ([]byte, error) {
+	codec, err := SchemaCodecFor(v)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := codec.Encode(msg)
+	if err != nil {
+		return nil, fmt.Errorf("schema v%d encode: %w", v, err)
+	}
+
+	out := make([]byte, batchHeaderLen, batchHeaderLen+len(payload))
+	copy(out, batchMagic[:])
+	binary.BigEndian.PutUint16(out[len(batchMagic):], uint16(v))
+
+	return append(out, payload...), nil
+}
+
+// DecodeBatch decodes a batch using the codec of the version found in its header.
+func DecodeBatch(data []byte) (*PlatformMessage, SchemaVersion, error) {
+	if len(data) < batchHeaderLen || data[0] != batchMagic[0] || data[1] != batchMagic[1] {
+		return nil, 0, ErrInvalidBatchHeader
+	}
+	v := SchemaVersion(binary.BigEndian.Uint16(data[len(batchMagic):]))
+
+	codec, err := SchemaCodecFor(v)
+	if err != nil {
+		return nil, v, err
+	}
+
+	msg, err := codec.Decode(data[batchHeaderLen:])
+	if err != nil {
+		return nil, v, fmt.Errorf("schema v%d decode: %w", v, err)
+	}
+
+	return msg, v, nil
+}
+
+// NegotiateSchemaVersion returns the version to use given the versions
+// advertised by the agent and the version selected by the server. If the
+// server's selection is not supported by the agent, the lowest version
+// common to both sides is used.
+func NegotiateSchemaVersion(advertised []SchemaVersion, selected SchemaVersion, serverSupported []SchemaVersion) (SchemaVersion, error) {
+	common := map[SchemaVersion]bool{}
+	for _, v := range advertised {
+		common[v] = true
+	}
+
+	if common[selected] {
+		return selected, nil
+	}
+
+	var (
+		lowest SchemaVersion
+		found  bool
+	)
+	for _, v := range serverSupported {
+		if common[v] && (!found || v < lowest) {
+			lowest, found = v, true
+		}
+	}
+
+	if !found {
+		return 0, fmt.Errorf("%w: agent %s, server %s", ErrNoCommonSchemaVersion,
+			FormatSchemaVersions(advertised), FormatSchemaVersions(serverSupported))
+	}
+
+	return lowest, nil
+}
+
+// FormatSchemaVersions formats versions as a comma separated list.
+func FormatSchemaVersions(versions []SchemaVersion) string {
+	s := make([]string, 0, len(versions))
+	for _, v := range versions {
+		s = append(s, strconv.Itoa(int(v)))
+	}
+
+	return strings.Join(s, ",")
+}
+
+// ParseSchemaVersions parses a comma separated list of versions.
+func ParseSchemaVersions(s string) ([]SchemaVersion, error) {
+	versions := []SchemaVersion{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		v, err := strconv.ParseUint(field, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema version %q: %w", field, err)
+		}
+		versions = append(versions, SchemaVersion(v))
+	}
+
+	return versions, nil
+}
+
+// jsonCodec schema v1, protobuf JSON mapping.
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(msg *PlatformMessage) ([]byte, error) {
+	return protojson.Marshal(msg)
+}
+
+func (jsonCodec) Decode(data []byte) (*PlatformMessage, error) {
+	msg := &PlatformMessage{}
+	if err := protojson.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// protobufCodec schema v2, protobuf binary.
+type protobufCodec struct{}
+
+func (protobufCodec) Encode(msg *PlatformMessage) ([]byte, error) {
+	return proto.Marshal(msg)
+}
+
+func (protobufCodec) Decode(data []byte) (*PlatformMessage, error) {
+	msg := &PlatformMessage{}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func testPlatformMessage() *PlatformMessage {
+	return &PlatformMessage{
+		AgentUUID: "agent-uuid",
+		Protocol:  "algorand",
+		Network:   "mainnet",
+		NodeRole:  "relay",
+		Data: []*Message{
+			{
+				Name:  AgentUpName,
+				Value: &Message_Event{Event: &Event{Name: AgentUpName, Timestamp: 1660000000000}},
+			},
+		},
+	}
+}
+
+func TestEncodeDecodeBatch(t *testing.T) {
+	for _, v := range SupportedSchemaVersions() {
+		data, err := EncodeBatch(v, testPlatformMessage())
+		require.NoError(t, err)
+
+		got, gotVersion, err := DecodeBatch(data)
+		require.NoError(t, err)
+		require.Equal(t, v, gotVersion)
+		require.True(t, proto.Equal(testPlatformMessage(), got), "schema v%d", v)
+	}
+}
+
+func TestDecodeBatch_V1Payload(t *testing.T) {
+	// a v1 batch as produced by an agent predating v2
+	data := append([]byte{'M', 'A', 0, 1}, []byte(`{"agentUUID":"agent-uuid","protocol":"algorand","data":[{"name":"agent.up"}]}`)...)
+
+	got, v, err := DecodeBatch(data)
+	require.NoError(t, err)
+	require.Equal(t, SchemaVersionJSON, v)
+	require.Equal(t, "agent-uuid", got.AgentUUID)
+	require.Equal(t, "algorand", got.Protocol)
+	require.Len(t, got.Data, 1)
+	require.Equal(t, "agent.up", got.Data[0].Name)
+}
+
+func TestDecodeBatch_UnknownVersion(t *testing.T) {
+	data := []byte{'M', 'A', 0, 42, 1, 2, 3}
+
+	_, v, err := DecodeBatch(data)
+	require.ErrorIs(t, err, ErrUnknownSchemaVersion)
+	require.Equal(t, SchemaVersion(42), v)
+	require.Contains(t, err.Error(), "42")
+
+	_, err = EncodeBatch(42, testPlatformMessage())
+	require.ErrorIs(t, err, ErrUnknownSchemaVersion)
+
+	_, _, err = DecodeBatch([]byte{'X'})
+	require.ErrorIs(t, err, ErrInvalidBatchHeader)
+}
+
+func TestNegotiateSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name            string
+		advertised      []SchemaVersion
+		selected        SchemaVersion
+		serverSupported []SchemaVersion
+		exp             SchemaVersion
+		expErr          error
+	}{
+		{
+			name:       "server selection",
+			advertised: []SchemaVersion{1, 2},
+			selected:   2,
+			exp:        2,
+		},
+		{
+			name:            "unsupported selection falls back to lowest common",
+			advertised:      []SchemaVersion{1, 2},
+			selected:        3,
+			serverSupported: []SchemaVersion{3, 2, 1},
+			exp:             1,
+		},
+		{
+			name:            "no common version",
+			advertised:      []SchemaVersion{1, 2},
+			selected:        3,
+			serverSupported: []SchemaVersion{3},
+			expErr:          ErrNoCommonSchemaVersion,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NegotiateSchemaVersion(tt.advertised, tt.selected, tt.serverSupported)
+			if tt.expErr != nil {
+				require.ErrorIs(t, err, tt.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.exp, got)
+		})
+	}
+}
+
+func TestParseSchemaVersions(t *testing.T) {
+	got, err := ParseSchemaVersions("1, 2,")
+	require.NoError(t, err)
+	require.Equal(t, []SchemaVersion{1, 2}, got)
+	require.Equal(t, "1,2", FormatSchemaVersions(got))
+
+	_, err = ParseSchemaVersions("1,v2")
+	require.Error(t, err)
+}
@@ -0,0 +1,150 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// TimeOffsetSuffix suffix of the context key preserving the zone
+	// offset of a normalized timestamp, i.e. time_offset for time.
+	TimeOffsetSuffix = "_offset"
+
+	// TimeZoneAssumedSuffix suffix of the context key flagging a
+	// normalized timestamp that carried no zone information, i.e.
+	// time_zone_assumed for time.
+	TimeZoneAssumedSuffix = "_zone_assumed"
+)
+
+// TimeKeys context keys holding timestamps in node logs, normalized to
+// UTC before events are created from them.
+var TimeKeys = []string{"time", "timestamp", "ts", "block_time"}
+
+// ErrUnknownTimeFormat returned for timestamps in an unsupported format.
+var ErrUnknownTimeFormat = errors.New("unknown time format")
+
+var (
+	// zonedLayouts supported timestamp layouts carrying zone information.
+	zonedLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05.999999999Z0700",
+		"2006-01-02 15:04:05.999999999Z07:00",
+		"2006-01-02 15:04:05.999999999 -0700",
+		"2006-01-02 15:04:05.999999999 -07:00",
+		time.RFC1123Z,
+	}
+
+	// localLayouts supported timestamp layouts lacking zone information,
+	// interpreted in the assumed location.
+	localLayouts = []string{
+		"2006-01-02T15:04:05.999999999",
+		"2006-01-02 15:04:05.999999999",
+		"2006/01/02 15:04:05.999999999",
+	}
+
+	assumedLocation atomic.Value
+)
+
+func init() {
+	assumedLocation.Store(time.Local)
+}
+
+// AssumedLocation returns the location used for timestamps lacking zone
+// information, the host's local time by default.
+func AssumedLocation() *time.Location {
+	return assumedLocation.Load().(*time.Location)
+}
+
+// SetAssumedLocation sets the location used for timestamps lacking zone
+// information.
+func SetAssumedLocation(loc *time.Location) {
+	assumedLocation.Store(loc)
+}
+
+// NormalizedTime a timestamp converted to UTC.
+type NormalizedTime struct {
+	// Time the timestamp in UTC.
+	Time time.Time
+
+	// Offset the source zone offset (i.e. +02:00), empty if the source
+	// carried no zone information.
+	Offset string
+
+	// ZoneAssumed true if the source carried no zone information and was
+	// interpreted in the assumed location.
+	ZoneAssumed bool
+}
+
+// ParseTimestamp parses s in one of the supported layouts and converts it
+// to UTC. Timestamps lacking zone information are interpreted in loc.
+func ParseTimestamp(s string, loc *time.Location) (NormalizedTime, error) {
+	s = strings.TrimSpace(s)
+
+	for _, layout := range zonedLayouts {
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			continue
+		}
+
+		return NormalizedTime{Time: t.UTC(), Offset: t.Format("-07:00")}, nil
+	}
+
+	for _, layout := range localLayouts {
+		t, err := time.ParseInLocation(layout, s, loc)
+		if err != nil {
+			continue
+		}
+
+		return NormalizedTime{Time: t.UTC(), ZoneAssumed: true}, nil
+	}
+
+	return NormalizedTime{}, ErrUnknownTimeFormat
+}
+
+// FormatUTC formats t in UTC as RFC3339 with nanoseconds.
+func FormatUTC(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// NormalizeTimeKeys converts the timestamps found under keys in ctx to UTC
+// in place. The source zone offset is kept under key+TimeOffsetSuffix, or
+// key+TimeZoneAssumedSuffix is set for timestamps lacking zone information.
+// Values that are not strings or not timestamps are left as is.
+func NormalizeTimeKeys(ctx map[string]interface{}, keys ...string) {
+	loc := AssumedLocation()
+
+	for _, key := range keys {
+		s, ok := ctx[key].(string)
+		if !ok {
+			continue
+		}
+
+		nt, err := ParseTimestamp(s, loc)
+		if err != nil {
+			continue
+		}
+
+		ctx[key] = FormatUTC(nt.Time)
+		if nt.ZoneAssumed {
+			ctx[key+TimeZoneAssumedSuffix] = true
+
+			continue
+		}
+		ctx[key+TimeOffsetSuffix] = nt.Offset
+	}
+}
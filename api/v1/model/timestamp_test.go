@@ -0,0 +1,137 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// withHostLocation runs the test as if the host was in loc.
+func withHostLocation(t *testing.T, loc *time.Location) {
+	localWas, assumedWas := time.Local, AssumedLocation()
+	t.Cleanup(func() {
+		time.Local = localWas
+		SetAssumedLocation(assumedWas)
+	})
+
+	time.Local = loc
+	SetAssumedLocation(loc)
+}
+
+func TestNormalizeTimeKeys_NodeLog(t *testing.T) {
+	// host 2 hours ahead of UTC
+	withHostLocation(t, time.FixedZone("CEST", 2*60*60))
+
+	tests := []struct {
+		name     string
+		line     string
+		expTime  string
+		expExtra map[string]interface{}
+	}{
+		{
+			name:     "rfc3339 utc",
+			line:     `{"level":"info","time":"2022-02-16T18:58:03Z","message":"OnFinalizedBlock"}`,
+			expTime:  "2022-02-16T18:58:03Z",
+			expExtra: map[string]interface{}{"time_offset": "+00:00"},
+		},
+		{
+			name:     "rfc3339 with offset",
+			line:     `{"level":"info","time":"2022-02-16T20:58:03.123+02:00","message":"OnFinalizedBlock"}`,
+			expTime:  "2022-02-16T18:58:03.123Z",
+			expExtra: map[string]interface{}{"time_offset": "+02:00"},
+		},
+		{
+			name:     "numeric offset without colon",
+			line:     `{"level":"info","time":"2022-02-16T13:58:03-0500","message":"OnFinalizedBlock"}`,
+			expTime:  "2022-02-16T18:58:03Z",
+			expExtra: map[string]interface{}{"time_offset": "-05:00"},
+		},
+		{
+			name:     "space separated with offset",
+			line:     `{"level":"info","time":"2022-02-16 19:58:03.5 +0100","message":"OnFinalizedBlock"}`,
+			expTime:  "2022-02-16T18:58:03.5Z",
+			expExtra: map[string]interface{}{"time_offset": "+01:00"},
+		},
+		{
+			name:     "no zone, host local time assumed",
+			line:     `{"level":"info","time":"2022-02-16 20:58:03","message":"OnFinalizedBlock"}`,
+			expTime:  "2022-02-16T18:58:03Z",
+			expExtra: map[string]interface{}{"time_zone_assumed": true},
+		},
+		{
+			name:     "no zone, T separated",
+			line:     `{"level":"info","time":"2022-02-16T20:58:03.000001","message":"OnFinalizedBlock"}`,
+			expTime:  "2022-02-16T18:58:03.000001Z",
+			expExtra: map[string]interface{}{"time_zone_assumed": true},
+		},
+		{
+			name:    "unknown format left as is",
+			line:    `{"level":"info","time":"yesterday","message":"OnFinalizedBlock"}`,
+			expTime: "yesterday",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]interface{}{}
+			require.NoError(t, json.Unmarshal([]byte(tt.line), &body))
+
+			NormalizeTimeKeys(body, TimeKeys...)
+			require.Equal(t, tt.expTime, body["time"])
+
+			for k, v := range tt.expExtra {
+				require.Equal(t, v, body[k])
+			}
+			require.Len(t, body, 3+len(tt.expExtra))
+		})
+	}
+}
+
+func TestNormalizeTimeKeys_ConfiguredLocation(t *testing.T) {
+	withHostLocation(t, time.FixedZone("CEST", 2*60*60))
+
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	SetAssumedLocation(ny)
+
+	body := map[string]interface{}{"time": "2022-07-01 14:58:03", "block_time": "2022-07-01T18:58:00Z", "view": 424144}
+	NormalizeTimeKeys(body, TimeKeys...)
+
+	require.Equal(t, "2022-07-01T18:58:03Z", body["time"])
+	require.Equal(t, true, body["time_zone_assumed"])
+	require.Equal(t, "2022-07-01T18:58:00Z", body["block_time"])
+	require.Equal(t, "+00:00", body["block_time_offset"])
+	require.Equal(t, 424144, body["view"])
+}
+
+func TestNewWithFilteredCtx_NormalizedTime(t *testing.T) {
+	body := map[string]interface{}{
+		"time":        "2022-02-16T20:58:03+02:00",
+		"message":     "OnFinalizedBlock",
+		"ignored_key": "value",
+	}
+	NormalizeTimeKeys(body, TimeKeys...)
+
+	ev, err := NewWithFilteredCtx(body, "OnFinalizedBlock", time.Now(), "time", "message")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"time":        "2022-02-16T18:58:03Z",
+		"time_offset": "+02:00",
+		"message":     "OnFinalizedBlock",
+	}, ev.Values.AsMap())
+}
@@ -0,0 +1,126 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nodocker
+// +build !nodocker
+
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"agent/internal/pkg/discover/utils"
+	"agent/internal/pkg/global"
+	"agent/internal/pkg/watch"
+	"agent/pkg/collector"
+
+	"go.uber.org/zap"
+)
+
+func init() {
+	nodeSchemes[global.NodeDocker] = startDockerScheme
+	collector.SetCgroupContainerResolver(nodeContainers)
+}
+
+// nodeContainers returns the IDs of the running containers matching the
+// node's container regexes, keyed by container name.
+func nodeContainers() (map[string]string, error) {
+	patterns := global.AgentConf.Discovery.Docker.Regex
+	if len(patterns) == 0 && blockchain != nil {
+		patterns = blockchain.ContainerRegex()
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	containers, err := utils.GetRunningContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[string]string{}
+	for _, pattern := range patterns {
+		r, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, container := range containers {
+			if len(container.Names) == 0 {
+				continue
+			}
+
+			matched := r.MatchString(container.Image)
+			for _, name := range container.Names {
+				matched = matched || r.MatchString(name)
+			}
+			if matched {
+				res[strings.TrimPrefix(container.Names[0], "/")] = container.ID
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// startDockerScheme reconfigures the node from its container and returns
+// the docker watchers.
+func startDockerScheme() []watch.Watcher {
+	container := discoverer.DockerContainer()
+	if container == nil {
+		zap.S().Fatal("got docker scheme but container is nil")
+	}
+
+	reader, err := utils.NewDockerLogsReader(container.Names[0])
+	if err != nil {
+		zap.S().Warnw("error creating docker log reader", zap.Error(err))
+	} else {
+		if err := blockchain.ReconfigureByDockerContainer(container, reader); err != nil {
+			zap.S().Warnw("node metadata configuration failed for docker", zap.Error(err))
+		}
+		reader.Close()
+	}
+
+	zap.S().Infow("starting docker watchers")
+
+	return defaultDockerWatchers()
+}
+
+func defaultDockerWatchers() []watch.Watcher {
+	dw := []watch.Watcher{}
+
+	// Log watch for event generation
+	logEvs := blockchain.LogEventsList()
+
+	// Docker container watch
+	conf := watch.ContainerWatchConf{Discoverer: discoverer}
+
+	w, err := watch.NewContainerWatch(conf)
+	if err != nil {
+		zap.S().Fatalw("failed to create watcher", zap.Error(err))
+	}
+	dw = append(dw, w)
+
+	// Docker container watch (logs)
+	logWatch := watch.NewDockerLogWatch(watch.DockerLogWatchConf{
+		ContainerName: discoverer.DockerContainer().Names[0],
+		Events:        logEvs,
+	})
+	// start log watcher independently if conditions for it are met
+	go logWatch.PendingStart(subscriptions...)
+
+	zap.S().Debugf("watching containers %v", logWatch.ContainerName)
+
+	return dw
+}
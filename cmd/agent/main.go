@@ -15,17 +15,21 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"agent/api/v1/model"
+	"agent/internal/pkg/bus"
 	"agent/internal/pkg/contrib"
 	"agent/internal/pkg/discover"
 	"agent/internal/pkg/discover/utils"
@@ -33,6 +37,7 @@ import (
 	"agent/internal/pkg/global"
 	"agent/internal/pkg/mahttp"
 	"agent/internal/pkg/publisher"
+	"agent/internal/pkg/rollup"
 	"agent/internal/pkg/watch"
 	"agent/internal/pkg/watch/factory"
 	"agent/pkg/collector"
@@ -49,10 +54,15 @@ var (
 	reset         bool
 	configureOnly bool
 	showVersion   bool
+	validateOnly  bool
 	flags         = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 
-	ch            = newSubscriptionChan()
-	subscriptions = []chan<- interface{}{}
+	// eventBus fans out messages emitted by watchers to exporters.
+	eventBus = bus.New(bus.DefaultQueueSize)
+
+	// subscriptions channels watchers emit to, watchers publish to the
+	// event bus through its inlet.
+	subscriptions = []chan<- interface{}{eventBus.Inlet()}
 
 	wg = &sync.WaitGroup{}
 
@@ -61,11 +71,12 @@ var (
 	promHandler       = promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})
 	discoverer        *utils.NodeDiscoverer
 	blockchain        global.Chain
-)
 
-func newSubscriptionChan() chan interface{} {
-	return make(chan interface{}, 1000)
-}
+	// nodeSchemes reconfigures the node and returns its watchers for each
+	// supported run scheme. Integrations register their scheme on init,
+	// unless left out of the build (nodocker, nosystemd).
+	nodeSchemes = map[global.NodeRunScheme]func() []watch.Watcher{}
+)
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
@@ -75,7 +86,20 @@ func parseFlags(args []string) error {
 	flags.BoolVar(&reset, "reset", false, "Remove existing protocol-related configuration. Restarts the discovery process.")
 	flags.BoolVar(&configureOnly, "configure-only", false, "Exit agent after automatic discovery and validation process.")
 	flags.BoolVar(&showVersion, "version", false, "Show the metrika agent version and exit.")
+	flags.BoolVar(&validateOnly, "validate", false, "Validate the agent configuration, including conf.d fragments, and exit.")
+	flags.BoolVar(&global.ForceNewFingerprint, "force-new-fingerprint", false, "Replace the cached host fingerprint, archiving the previous one. Use after intentionally cloning the host or replacing its hardware.")
 	collector.DefineFsPathFlags(flags)
+	collector.DefineSyntheticDeviceFlag(flags)
+	collector.DefineConstLabelsFlag(flags)
+	collector.DefineVMStatFlags(flags)
+	collector.DefineInterruptsFlags(flags)
+	collector.DefineFileFDFlags(flags)
+	collector.DefineProcessesFlags(flags)
+	collector.DefineSystemdFlags(flags)
+	collector.DefineTextFileFlags(flags)
+	collector.DefineCgroupFlags(flags)
+	collector.DefineNetClassFlags(flags)
+	collector.DefineIPVSFlags(flags)
 
 	if err := flags.Parse(args); err != nil {
 		return err
@@ -109,86 +133,60 @@ func setupZapLogger() zap.AtomicLevel {
 	return zapLevelHandler
 }
 
-func defaultSystemdWatchers() []watch.Watcher {
-	sdwConf := watch.SystemdServiceWatchConf{Discoverer: discoverer}
-	sdw, err := watch.NewSystemdServiceWatch(sdwConf)
-	if err != nil {
-		zap.S().Fatalw("cannot start node systemd watcher without regular expression or discoverer", zap.Error(err))
-	}
-
-	svc := discoverer.SystemdService()
-	if svc == nil || svc.Name == "" {
-		zap.S().Fatal("got nil systemd service object or empty unit name")
-	}
-
-	dw := []watch.Watcher{sdw}
-
-	// Log watch for event generation
-	logEvs := blockchain.LogEventsList()
-
-	// Docker container watch (logs)
-	logWatch, err := watch.NewJournaldLogWatch(watch.JournaldLogWatchConf{
-		UnitName: svc.Name,
-		Events:   logEvs,
-	})
-	if err != nil {
-		zap.S().Fatalw("cannot build journald log watch, this is probably a configuration error", zap.Error(err))
-	}
-
-	// start log watcher independently if conditions for it are met
-	go logWatch.PendingStart(subscriptions...)
-
-	return dw
-}
-
-func defaultDockerWatchers() []watch.Watcher {
-	dw := []watch.Watcher{}
-
-	// Log watch for event generation
-	logEvs := blockchain.LogEventsList()
-
-	// Docker container watch
-	conf := watch.ContainerWatchConf{Discoverer: discoverer}
-
-	w, err := watch.NewContainerWatch(conf)
-	if err != nil {
-		zap.S().Fatalw("failed to create watcher", zap.Error(err))
-	}
-	dw = append(dw, w)
-
-	// Docker container watch (logs)
-	logWatch := watch.NewDockerLogWatch(watch.DockerLogWatchConf{
-		ContainerName: discoverer.DockerContainer().Names[0],
-		Events:        logEvs,
-	})
-	// start log watcher independently if conditions for it are met
-	go logWatch.PendingStart(subscriptions...)
-
-	zap.S().Debugf("watching containers %v", logWatch.ContainerName)
-
-	return dw
-}
-
 func registerWatchers(ctx context.Context, cupdStream *global.ConfigUpdateStream) error {
 	watchersEnabled := []watch.Watcher{}
 
-	for _, watcherConf := range global.AgentConf.Runtime.Watchers {
+	watcherConfs := global.AgentConf.Runtime.Watchers
+	if nodeExporter := global.AgentConf.Runtime.NodeExporter; nodeExporter.Enabled {
+		w, remaining, err := factory.NewNodeExporterWatch(nodeExporter, global.AgentConf.Runtime.SamplingInterval, watcherConfs)
+		if err != nil {
+			if !recordStartupFailure("node_exporter", global.FailureConfig, err) {
+				zap.S().Fatalw("node_exporter passthrough setup error", zap.Error(err))
+			}
+			zap.S().Errorw("node_exporter passthrough setup error", zap.Error(err))
+		} else {
+			cadence := watch.ExpectedCadence(global.AgentConf.Runtime.SamplingInterval)
+			if err := watch.DefaultWatchRegistry.RegisterWithCadence(cadence, w); err != nil {
+				return err
+			}
+			watcherConfs = remaining
+		}
+	}
+
+	for _, watcherConf := range watcherConfs {
 		w, err := factory.NewWatcherByType(*watcherConf)
 		if err != nil {
-			zap.S().Fatalw("watcher factory returned error", "type", watcherConf.Type, zap.Error(err))
+			kind := global.FailureCapability
+			if errors.Is(err, factory.ErrUnknownWatchType) {
+				kind = global.FailureConfig
+			}
+			if !recordStartupFailure(string(watcherConf.Type), kind, err) {
+				zap.S().Fatalw("watcher factory returned error", "type", watcherConf.Type, zap.Error(err))
+			}
+			zap.S().Errorw("watcher factory returned error", "type", watcherConf.Type, zap.Error(err))
+
+			continue
 		}
 		if w == nil {
 			zap.S().Fatalw("watcher factory returned nil", "type", watcherConf.Type)
 		}
+		probeWatcher(string(watcherConf.Type), w)
 
-		watchersEnabled = append(watchersEnabled, w)
-	}
-
-	if global.AgentConf.Discovery.Deactivated {
-		if err := watch.DefaultWatchRegistry.Register(watchersEnabled...); err != nil {
+		if err := watch.DefaultWatchRegistry.RegisterWithCadence(factory.Cadence(*watcherConf), w); err != nil {
 			return err
 		}
+	}
 
+	// watchdog for watchers that stopped emitting
+	watchdog := watch.NewWatchdog(watch.WatchdogConf{
+		Registry: watch.DefaultWatchRegistry,
+		Interval: global.AgentConf.Runtime.SamplingInterval,
+	})
+	if err := watch.DefaultWatchRegistry.Register(watchdog); err != nil {
+		return err
+	}
+
+	if global.AgentConf.Discovery.Deactivated {
 		return nil
 	}
 
@@ -214,11 +212,20 @@ func registerWatchers(ctx context.Context, cupdStream *global.ConfigUpdateStream
 		}
 
 		filter := &openmetrics.PEFFilter{ToMatch: ep.Filters}
-		pefConf := watch.PEFWatchConf{Filter: filter}
+		pefConf := watch.PEFWatchConf{Filter: filter, Interval: global.AgentConf.Runtime.SamplingInterval}
+		if lkg := global.AgentConf.Runtime.LastKnownGood; lkg.Enabled {
+			lkgConf := watch.LastKnownGoodConf{Metrics: lkg.Metrics, MaxStale: lkg.MaxStale}
+			if global.AgentCacheDir != "" {
+				lkgConf.Path = filepath.Join(global.AgentCacheDir, fmt.Sprintf("last_known_good_%d.json", i))
+			}
+			pefConf.LastKnownGood = watch.NewLastKnownGood(lkgConf)
+			global.RegisterMemoryReporter(fmt.Sprintf("last_known_good.%d", i), pefConf.LastKnownGood)
+		}
 		watchersEnabled = append(watchersEnabled, watch.NewPEFWatch(pefConf, httpWatch))
 	}
 
-	if err := watch.DefaultWatchRegistry.Register(watchersEnabled...); err != nil {
+	cadence := watch.ExpectedCadence(global.AgentConf.Runtime.SamplingInterval)
+	if err := watch.DefaultWatchRegistry.RegisterWithCadence(cadence, watchersEnabled...); err != nil {
 		return err
 	}
 	watchersEnabled = watchersEnabled[:0]
@@ -239,9 +246,14 @@ func registerWatchers(ctx context.Context, cupdStream *global.ConfigUpdateStream
 
 	var err error
 	discoverer, err = utils.NewNodeDiscoverer(c)
-	if err != nil {
+	if errors.Is(err, utils.ErrNodeDiscoveryUnsupported) {
+		recordStartupFailure("discovery", global.FailureCapability, err)
+		zap.S().Warnw("node discovery is not supported by this build, the agent will start without monitoring a node", zap.Error(err))
+		return nil
+	} else if err != nil {
 		return err
 	}
+	probeNodeDiscovery(ctx)
 
 	go func() {
 		for {
@@ -255,48 +267,13 @@ func registerWatchers(ctx context.Context, cupdStream *global.ConfigUpdateStream
 			startWatchers := []watch.Watcher{}
 			scheme := discoverer.DetectScheme(ctx)
 
-			switch scheme {
-			case global.NodeDocker:
-				container := discoverer.DockerContainer()
-				if container == nil {
-					zap.S().Fatal("got docker scheme but container is nil")
-				}
-
-				reader, err := utils.NewDockerLogsReader(container.Names[0])
-				if err != nil {
-					zap.S().Warnw("error creating docker log reader", zap.Error(err))
-				} else {
-					if err := blockchain.ReconfigureByDockerContainer(container, reader); err != nil {
-						zap.S().Warnw("node metadata configuration failed for docker", zap.Error(err))
-					}
-					reader.Close()
-				}
-
-				zap.S().Infow("starting docker watchers")
-				startWatchers = defaultDockerWatchers()
-			case global.NodeSystemd:
-				unit := discoverer.SystemdService()
-				if unit == nil {
-					zap.S().Fatal("got systemd scheme but systemd unit is nil")
-				}
-
-				reader, err := utils.NewJournalReader(unit.Name)
-				if err != nil {
-					zap.S().Warnw("error creating journald log reader", zap.Error(err))
-				} else {
-					if err := blockchain.ReconfigureBySystemdUnit(unit, reader); err != nil {
-						zap.S().Warnw("node metadata configuration failed for systemd", zap.Error(err))
-					}
-					reader.Close()
-				}
-
-				zap.S().Infow("starting systemd watchers")
-				startWatchers = defaultSystemdWatchers()
-			default:
+			startScheme, ok := nodeSchemes[scheme]
+			if !ok {
 				zap.S().Warnw("node discovery returned no errors but scheme is unknown, retrying in 2s", "scheme", scheme)
 				<-time.After(2 * time.Second)
 				continue
 			}
+			startWatchers = startScheme()
 			blockchain.SetRunScheme(scheme)
 
 			watchersEnabled = append(watchersEnabled, startWatchers...)
@@ -319,6 +296,10 @@ func registerWatchers(ctx context.Context, cupdStream *global.ConfigUpdateStream
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == replayCommand {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+
 	if err := parseFlags(os.Args[1:]); err != nil {
 		if err == flag.ErrHelp {
 			os.Exit(2)
@@ -331,17 +312,48 @@ func main() {
 		os.Exit(0)
 	}
 
+	if validateOnly {
+		sources, err := global.ValidateAgentConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+
+			os.Exit(1)
+		}
+		fmt.Printf("configuration is valid, sources: %s\n", strings.Join(sources, ", "))
+		os.Exit(0)
+	}
+
 	if err := global.LoadAgentConfig(&global.AgentConf); err != nil {
+		recordStartupFailure("config", global.FailureConfig, err)
+		exitOnStartupFailures()
 		fmt.Fprintf(os.Stderr, "%v", err)
 
 		os.Exit(1)
 	}
+	rolledBack, rollbackErr := restoreRolledBackConfig()
 	ctx := context.Background()
 	timesync.SetDefault(timesync.NewTimeSync(ctx, global.AgentConf.Runtime.NTPServer, 0))
 	zapLevelHandler := setupZapLogger()
+	zap.S().Infow("loaded agent configuration", "sources", global.AgentConfigSources)
+	if rollbackErr != nil {
+		zap.S().Errorw("error checking for a rolled back configuration", zap.Error(rollbackErr))
+	}
+	if rolledBack != nil {
+		zap.S().Warnw("the configuration was rolled back by its probation, starting with the previous configuration until conf.d fragments change",
+			"rolled_back_at", rolledBack.At, "reasons", rolledBack.Reasons)
+	}
+	if loc, err := global.AgentConf.Runtime.LogLocation(); err == nil {
+		model.SetAssumedLocation(loc)
+	}
+	if collector.SyntheticDeviceEnabled() {
+		zap.S().Warnw("synthetic network device enabled, network metrics include fake series for verification",
+			"device", collector.SyntheticDeviceName, "label", collector.SyntheticLabel+"=\"true\"")
+	}
 
 	chain, err := discover.AutoConfig(&global.AgentConf, reset)
 	if err != nil {
+		recordStartupFailure("discovery", global.FailureConfig, err)
+		exitOnStartupFailures()
 		zap.S().Fatalw("configuration error", zap.Error(err))
 	}
 	global.SetBlockchainNode(chain)
@@ -354,12 +366,35 @@ func main() {
 	blockchain = global.BlockchainNode()
 
 	if err := global.AgentPrepareStartup(); err != nil {
+		recordStartupFailure("state", global.FailureCapability, err)
+		exitOnStartupFailures()
 		fmt.Fprintf(os.Stderr, "%v", err)
 
 		os.Exit(1)
 	}
 
+	// check how the previous run shut down, cleared on graceful shutdown
+	agentStartedAt = timesync.Now()
+	prevShutdown, uncleanShutdown, err := global.RecoverShutdownState(global.AgentCacheDir)
+	if err != nil {
+		zap.S().Errorw("error recovering previous shutdown state", zap.Error(err))
+	}
+	global.SetPanicHandler(func(r interface{}) {
+		writeShutdownReport(newShutdownReport(global.ShutdownReasonPanic, fmt.Sprint(r)))
+	})
+	defer global.ReportPanic()
+
 	ctx, cancel = context.WithCancel(context.Background())
+	setupConfigProbation(zapLevelHandler)
+	if global.AgentConfigDir != "" {
+		go func() {
+			defer global.ReportPanic()
+			global.WatchConfigDir(ctx, global.AgentConfigDir, global.DefaultConfigDirPollInterval, func(fragments []string) {
+				onConfigFragmentsChange(ctx, fragments)
+			})
+		}()
+	}
+
 	// setup config update stream
 	updCh := blockchain.ConfigUpdateCh()
 	var cupdStream *global.ConfigUpdateStream
@@ -368,9 +403,10 @@ func main() {
 		cupdStream.Run(ctx)
 	}
 
-	timesync.Default.Start(ch)
+	timesync.Default.Start(eventBus.Inlet())
 	if err := timesync.Default.SyncNow(); err != nil {
 		zap.S().Errorw("could not sync with NTP server", zap.Error(err))
+		recordStartupFailure("ntp", global.FailureNetwork, err)
 
 		ctx := map[string]interface{}{model.ErrorKey: err.Error()}
 		timesync.EmitEventWithCtx(timesync.Default, ctx, model.AgentClockNoSyncName)
@@ -390,12 +426,15 @@ func main() {
 			netdev, err := collector.NewNetDevCollector()
 			if err != nil {
 				zap.S().Errorw("failed to initialize netdev collector for tracking network metrics", zap.Error(err))
+				recordStartupFailure("self_metrics.netdev", global.FailureCapability, err)
 			} else {
 				prometheus.MustRegister(netdev)
 			}
+			prometheus.MustRegister(watch.MetricsCollector())
 			mux.Handle("/metrics", mahttp.ValidationMiddleware(promHandler))
 		}
 		mux.Handle("/loglvl", mahttp.ValidationMiddleware(zapLevelHandler))
+		mux.Handle("/memory", mahttp.ValidationMiddleware(mahttp.MemoryReportHandler(global.DefaultMemoryAccounting)))
 	}
 
 	log := zap.S()
@@ -404,47 +443,81 @@ func main() {
 	if global.AgentConf.Platform.IsEnabled() {
 		pub, err := publisher.NewPlatformPublisher(global.AgentHostname, global.AgentConf.Platform, global.AgentConf.Buffer)
 		if err != nil {
+			recordStartupFailure("platform", global.FailureConfig, err)
+			exitOnStartupFailures()
 			log.Fatalw("failed to initialize metrika platform exporter", zap.Error(err))
 		}
+		platformPublisher = pub
 		pubCtx, pubCancel = context.WithCancel(context.Background())
 		pub.Start(pubCtx, wg)
-		subCh := newSubscriptionChan()
-		subscriptions = append(subscriptions, subCh)
+		subCh := eventBus.Subscribe(bus.SubscriptionConf{}).C()
 		global.DefaultExporterRegisterer.Register(pub, subCh)
+		probePlatform()
 	}
 
 	if len(global.AgentConf.Runtime.Exporters) > 0 {
 		exporters := contrib.SetupEnabledExporters(global.AgentConf.Runtime.Exporters)
 		for i := range exporters {
-			subCh := newSubscriptionChan()
-			subscriptions = append(subscriptions, subCh)
+			subCh := eventBus.Subscribe(bus.SubscriptionConf{}).C()
 			if err := global.DefaultExporterRegisterer.Register(exporters[i], subCh); err != nil {
 				log.Errorw("failed to register an exporter", zap.Error(err))
+				recordStartupFailure(fmt.Sprintf("exporter.%d", i), global.FailureCapability, err)
 				continue
 			}
 		}
 	}
 
-	multiEmitter := emit.NewMultiEmitter(subscriptions)
+	var roundRollup *rollup.DailyRollup
+	if rc := global.AgentConf.Runtime.RoundRollup; rc.Enabled {
+		if re, ok := blockchain.(global.RoundEventer); ok {
+			roundRollup = rollup.NewDailyRollup(rollup.DailyRollupConf{Days: rc.Days, MaxGap: rc.MaxGap})
+			if global.AgentCacheDir != "" {
+				roundRollup.Path = filepath.Join(global.AgentCacheDir, rollup.DefaultRoundTimeFilename)
+			}
+			if err := roundRollup.Load(); err != nil {
+				log.Errorw("error loading round time rollup, starting over", zap.Error(err))
+			}
+
+			eventName, roundKey := re.RoundEvent()
+			tracker := rollup.NewRoundTimeTracker(rollup.RoundTimeTrackerConf{
+				Rollup:    roundRollup,
+				EventName: eventName,
+				RoundKey:  roundKey,
+				Emitter:   eventBus,
+			})
+			prometheus.MustRegister(tracker)
+			subCh := eventBus.Subscribe(bus.SubscriptionConf{Topics: []bus.Topic{bus.TopicChainEvents}}).C()
+			global.DefaultExporterRegisterer.Register(tracker, subCh)
+		}
+	}
 
 	global.DefaultExporterRegisterer.Start(ctx, wg)
+	eventBus.Start()
+	emitPreviousShutdown(eventBus, prevShutdown, uncleanShutdown)
+	emitHostCloned(eventBus, global.HostClone)
+	if rolledBack != nil {
+		emitConfigRolledBack(eventBus, rolledBack.Reasons)
+	}
+	emitFingerprintRotated(eventBus, global.FingerprintRotation)
 
 	// we should be (almost) ready to publish at this point
 	// start default and enabled watchers
 	if err := registerWatchers(ctx, cupdStream); err != nil {
 		log.Fatal(err)
 	}
+	// in strict mode, abort before starting with degraded capabilities
+	exitOnStartupFailures()
 	if discoverer != nil {
 		defer discoverer.Close()
 	}
 
-	if err := watch.DefaultWatchRegistry.Start(subscriptions...); err != nil {
+	if err := watch.DefaultWatchRegistry.StartWithContext(ctx, subscriptions...); err != nil {
 		log.Fatal(err)
 	}
 
-	log.Infof("finished agent setup")
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	log.Infof("finished agent setup")
 	sig := <-sigs
 	log.Infof("received OS signal %v", sig)
 	log.Debug("agent is shutting down...")
@@ -465,7 +538,7 @@ func main() {
 		log.Error("error creating event: ", err)
 	}
 
-	if err := emit.Ev(multiEmitter, ev); err != nil {
+	if err := emit.Ev(eventBus, ev); err != nil {
 		log.Error("error emitting event: ", err)
 	}
 
@@ -480,13 +553,19 @@ func main() {
 	// wait for goroutine started in startHttpServer() to stop
 	httpwg.Wait()
 
-	// stop watchers and wait for goroutine cleanup
+	// stop watchers and wait for goroutine cleanup, watchers that don't
+	// stop in time are left behind
 	watch.DefaultWatchRegistry.Stop()
-	watch.DefaultWatchRegistry.Wait()
 
 	// stop docker client
 	utils.DefaultDockerAdapter.Close()
 
+	// capture the agent state before draining buffers
+	shutdownReport := newShutdownReport(global.ShutdownReasonSignal, sig.String())
+
+	// hand over everything emitted so far to the exporters
+	drainEventBus(defaultDrainTimeout)
+
 	// stop platform publisher if running
 	if pubCancel != nil {
 		pubCancel()
@@ -496,5 +575,13 @@ func main() {
 	cancel()
 	wg.Wait()
 
+	if roundRollup != nil {
+		if err := roundRollup.Save(); err != nil {
+			log.Errorw("error saving round time rollup", zap.Error(err))
+		}
+	}
+
+	writeShutdownReport(shutdownReport)
+
 	log.Info("shutdown complete, goodbye")
 }
@@ -0,0 +1,112 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// minimalTags must match MINIMAL_TAGS in the Makefile. Solana is used
+	// since it doesn't require protocol files under /etc to start.
+	minimalTags = "solana,nodocker,nosystemd"
+
+	// minimalBinaryBudget max size of the stripped minimal binary.
+	minimalBinaryBudget = 18 << 20
+)
+
+func TestMinimalBinary(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping minimal binary build in short mode")
+	}
+
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "metrikad-minimal")
+
+	build := exec.Command(gobin, "build", "-o", bin, "-tags", minimalTags, "-ldflags", "-s -w", ".")
+	build.Env = append(os.Environ(), "CGO_ENABLED=0", "GOFLAGS=")
+	out, err := build.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	info, err := os.Stat(bin)
+	require.NoError(t, err)
+	require.LessOrEqualf(t, info.Size(), int64(minimalBinaryBudget),
+		"minimal binary is %d bytes, over the %d bytes budget", info.Size(), minimalBinaryBudget)
+
+	// host-only mode: no platform, no node discovery
+	conf, err := ioutil.ReadFile("../../configs/agent.yml")
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "agent.yml"), conf, 0o644))
+
+	agent := exec.Command(bin)
+	agent.Dir = dir
+	agent.Env = append(os.Environ(),
+		"XDG_CACHE_HOME="+filepath.Join(dir, "cache"),
+		"MA_PLATFORM_ENABLED=false",
+		"MA_DISCOVERY_DEACTIVATED=true",
+		"MA_RUNTIME_HTTP_ADDR=127.0.0.1:0",
+		"MA_RUNTIME_LOGGING_OUTPUTS=stdout",
+		"MA_RUNTIME_WATCHERS=prometheus.proc.cpu,prometheus.proc.stat_linux",
+	)
+	stdout, err := agent.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, agent.Start())
+
+	started := make(chan struct{})
+	go func() {
+		scan := bufio.NewScanner(stdout)
+		for scan.Scan() {
+			if strings.Contains(scan.Text(), "finished agent setup") {
+				close(started)
+			}
+		}
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(time.Minute):
+		agent.Process.Kill()
+		t.Fatal("timed out waiting for the minimal agent to start")
+	}
+
+	require.NoError(t, agent.Process.Signal(syscall.SIGTERM))
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- agent.Wait()
+	}()
+
+	select {
+	case err := <-exited:
+		require.NoError(t, err)
+	case <-time.After(time.Minute):
+		agent.Process.Kill()
+		t.Fatal("timed out waiting for the minimal agent to shut down")
+	}
+}
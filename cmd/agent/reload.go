@@ -0,0 +1,147 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+
+	"agent/api/v1/model"
+	"agent/internal/pkg/emit"
+	"agent/internal/pkg/global"
+	"agent/pkg/timesync"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// logLevel the running log level, hot reloaded from conf.d fragments.
+	logLevel zap.AtomicLevel
+
+	// configProbation judges the agent health after a configuration reload
+	// and rolls it back if needed.
+	configProbation *global.ConfigProbation
+	agentHealth     *global.AgentHealth
+
+	// runtimeConfigMu serializes reloads and rollbacks of the running
+	// configuration.
+	runtimeConfigMu = &sync.Mutex{}
+)
+
+// setupConfigProbation sets up the health probation applied after a
+// configuration reload.
+func setupConfigProbation(level zap.AtomicLevel) {
+	logLevel = level
+	agentHealth = global.NewAgentHealth(global.AgentRuntimeState, global.AgentConf.Platform.IsEnabled())
+
+	conf := global.ConfigProbationConf{
+		ProbationConfig: global.AgentConf.Runtime.ConfigProbation,
+		Probe:           agentHealth.Probe,
+		Apply:           applyRuntimeConfig,
+		OnRollback: func(reasons []string) {
+			emitConfigRolledBack(eventBus, reasons)
+		},
+	}
+	if global.AgentCacheDir != "" {
+		conf.PreviousConfigPath = filepath.Join(global.AgentCacheDir, global.DefaultPreviousConfigFilename)
+		conf.RollbackRecordPath = filepath.Join(global.AgentCacheDir, global.DefaultRollbackRecordFilename)
+	}
+	configProbation = global.NewConfigProbation(conf)
+}
+
+// restoreRolledBackConfig replaces the loaded configuration with the one
+// preceding it if it was rolled back by the configuration probation, so
+// that a restart doesn't apply it again until conf.d fragments change.
+func restoreRolledBackConfig() (*global.RollbackRecord, error) {
+	cacheDir, err := global.DefaultAgentCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return global.RestoreRolledBackConfig(&global.AgentConf,
+		filepath.Join(cacheDir, global.DefaultRollbackRecordFilename),
+		filepath.Join(cacheDir, global.DefaultPreviousConfigFilename))
+}
+
+// onConfigFragmentsChange reloads the layered configuration when conf.d
+// fragments are added, removed or modified. Its hot reloadable settings
+// (the log level) are applied under a health probation, other changes are
+// applied on restart. On rollback, only the log level is reverted at
+// runtime, the full previous configuration is restored on restart.
+func onConfigFragmentsChange(ctx context.Context, _ []string) {
+	next := global.AgentConfig{}
+	sources, err := global.ReadAgentConfig(&next)
+	if err != nil {
+		zap.S().Errorw("configuration fragments changed but the layered configuration is invalid, keeping the running configuration", zap.Error(err))
+
+		return
+	}
+
+	runtimeConfigMu.Lock()
+	previous := global.AgentConf
+	runtimeConfigMu.Unlock()
+
+	if next.Runtime.Log.Lvl == previous.Runtime.Log.Lvl {
+		zap.S().Warnw("configuration fragments changed, restart the agent to apply them", "sources", sources)
+
+		return
+	}
+
+	if err := applyRuntimeConfig(next); err != nil {
+		zap.S().Errorw("error applying the reloaded configuration", zap.Error(err))
+
+		return
+	}
+
+	agentHealth.Reset()
+	if err := configProbation.Begin(ctx, previous, next); err != nil {
+		zap.S().Errorw("error starting the configuration probation", zap.Error(err))
+	}
+
+	zap.S().Warnw("configuration fragments changed, log level applied, restart the agent to apply the other settings",
+		"sources", sources, "log_level", next.Runtime.Log.Lvl)
+}
+
+// applyRuntimeConfig applies the hot reloadable settings of c to the
+// running agent, on reload and on rollback.
+func applyRuntimeConfig(c global.AgentConfig) error {
+	runtimeConfigMu.Lock()
+	defer runtimeConfigMu.Unlock()
+
+	global.AgentConf.Runtime.Log.Lvl = c.Runtime.Log.Lvl
+	logLevel.SetLevel(c.Runtime.Log.Level())
+
+	return nil
+}
+
+// emitConfigRolledBack emits the reasons the configuration was rolled back.
+func emitConfigRolledBack(emitter emit.Emitter, reasons []string) {
+	list := make([]interface{}, 0, len(reasons))
+	for _, reason := range reasons {
+		list = append(list, reason)
+	}
+
+	ctx := map[string]interface{}{model.ReasonsKey: list}
+	ev, err := model.NewWithCtx(ctx, model.AgentConfigRolledBackName, timesync.Now())
+	if err != nil {
+		zap.S().Errorw("error creating event", zap.Error(err))
+
+		return
+	}
+
+	if err := emit.Ev(emitter, ev); err != nil {
+		zap.S().Errorw("error emitting event", zap.Error(err))
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"agent/api/v1/model"
+	"agent/internal/pkg/global"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type chanEmitter chan interface{}
+
+func (c chanEmitter) Emit(message interface{}) {
+	c <- message
+}
+
+func TestApplyRuntimeConfig(t *testing.T) {
+	prevLevel, prevConf := logLevel, global.AgentConf
+	t.Cleanup(func() {
+		logLevel, global.AgentConf = prevLevel, prevConf
+	})
+
+	logLevel = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	global.AgentConf.Runtime.Log.Lvl = "info"
+
+	next := global.AgentConfig{}
+	next.Runtime.Log.Lvl = "debug"
+	require.NoError(t, applyRuntimeConfig(next))
+	require.Equal(t, zapcore.DebugLevel, logLevel.Level())
+	require.Equal(t, "debug", global.AgentConf.Runtime.Log.Lvl)
+}
+
+func TestEmitConfigRolledBack(t *testing.T) {
+	emitter := make(chanEmitter, 1)
+	emitConfigRolledBack(emitter, []string{"6 export failures (max 5)"})
+
+	msg, ok := (<-emitter).(*model.Message)
+	require.True(t, ok)
+	require.Equal(t, model.AgentConfigRolledBackName, msg.Name)
+
+	ev := msg.GetEvent()
+	require.NotNil(t, ev)
+	require.Equal(t, []interface{}{"6 export failures (max 5)"}, ev.Values.AsMap()[model.ReasonsKey])
+}
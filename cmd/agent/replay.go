@@ -0,0 +1,108 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"agent/internal/pkg/global"
+	"agent/internal/pkg/replay"
+)
+
+// replayCommand name of the replay subcommand.
+const replayCommand = "replay"
+
+// runReplay implements "agent replay", feeding a captured debug export or
+// offline bundle through the buffer into a sink. It does not load the
+// agent configuration nor access the node or the host. Returns the
+// process exit code.
+func runReplay(args []string) int {
+	var (
+		input    string
+		speed    string
+		sinkName string
+		rebase   bool
+		batchN   int
+	)
+
+	sinks := make([]string, 0, len(replay.SinkFactory))
+	for name := range replay.SinkFactory {
+		sinks = append(sinks, name)
+	}
+	sort.Strings(sinks)
+
+	fs := flag.NewFlagSet(os.Args[0]+" "+replayCommand, flag.ContinueOnError)
+	fs.StringVar(&input, "input", "", "Captured JSON-lines export (.jsonl, .gz) or offline bundle (.tgz) to replay.")
+	fs.StringVar(&speed, "speed", "1x", "Replay speed factor applied to the original inter-arrival times, 0 for as fast as possible.")
+	fs.StringVar(&sinkName, "sink", "stdout", "Sink receiving the replayed batches, one of: "+strings.Join(sinks, ", ")+".")
+	fs.BoolVar(&rebase, "rebase", false, "Rebase timestamps so that the first envelope happened now.")
+	fs.IntVar(&batchN, "batch-n", global.DefaultPlatformBatchN, "Max number of messages per batch.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if input == "" {
+		fmt.Fprintln(os.Stderr, "--input is required")
+
+		return 2
+	}
+
+	speedFactor, err := replay.ParseSpeed(speed)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+
+		return 2
+	}
+
+	newSink, ok := replay.SinkFactory[sinkName]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown sink %q\n", sinkName)
+
+		return 2
+	}
+
+	src, err := replay.Open(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+
+		return 1
+	}
+	defer src.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	stats, err := replay.Run(ctx, src, replay.Config{
+		Speed:  speedFactor,
+		Rebase: rebase,
+		BatchN: batchN,
+		Sink:   newSink(os.Stdout),
+	})
+	if stats != nil {
+		stats.WriteTo(os.Stderr)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay failed: %v\n", err)
+
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,168 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"agent/api/v1/model"
+	"agent/internal/pkg/emit"
+	"agent/internal/pkg/fingerprint"
+	"agent/internal/pkg/global"
+	"agent/internal/pkg/publisher"
+	"agent/pkg/timesync"
+
+	"go.uber.org/zap"
+)
+
+// defaultDrainTimeout max time to hand over buffered messages to the
+// exporters on shutdown.
+const defaultDrainTimeout = 10 * time.Second
+
+var (
+	// agentStartedAt time the agent finished its startup preparation.
+	agentStartedAt time.Time
+
+	// platformPublisher the platform publisher, if enabled.
+	platformPublisher *publisher.Publisher
+)
+
+// emitPreviousShutdown emits the report of the previous agent run, or an
+// agent.unclean_shutdown event if it did not shut down gracefully.
+func emitPreviousShutdown(emitter emit.Emitter, report *global.ShutdownReport, unclean bool) {
+	var (
+		ev  *model.Event
+		err error
+	)
+
+	switch {
+	case unclean:
+		zap.S().Warnw("previous agent run did not shut down gracefully")
+		ev, err = model.New(model.AgentUncleanShutdownName, timesync.Now())
+	case report != nil:
+		zap.S().Infow("previous agent run shut down gracefully", "reason", report.Reason, "detail", report.Detail, "stopped_at", report.StoppedAt)
+		ev, err = model.NewWithCtx(report.EventContext(), model.AgentPreviousShutdownName, timesync.Now())
+	default:
+		return
+	}
+
+	if err != nil {
+		zap.S().Errorw("error creating event", zap.Error(err))
+
+		return
+	}
+
+	if err := emit.Ev(emitter, ev); err != nil {
+		zap.S().Errorw("error emitting event", zap.Error(err))
+	}
+}
+
+// newShutdownReport returns a report of the agent's current state.
+func newShutdownReport(reason global.ShutdownReason, detail string) *global.ShutdownReport {
+	report := &global.ShutdownReport{
+		Reason:     reason,
+		Detail:     detail,
+		Version:    global.Version,
+		StartedAt:  agentStartedAt,
+		StoppedAt:  timesync.Now(),
+		Unflushed:  global.DefaultExporterRegisterer.Pending(),
+		NodeStatus: global.AgentRuntimeState.NodeStatus(),
+	}
+
+	if platformPublisher != nil {
+		report.BufferDepths = map[string]int{"platform": platformPublisher.BufferLen()}
+		report.Heartbeat = platformPublisher.LastHeartbeat()
+	}
+
+	return report
+}
+
+// writeShutdownReport persists the report, marking the shutdown as clean.
+func writeShutdownReport(report *global.ShutdownReport) {
+	if global.AgentCacheDir == "" {
+		return
+	}
+
+	if err := global.WriteShutdownReport(global.AgentCacheDir, report); err != nil {
+		zap.S().Errorw("error writing shutdown report", zap.Error(err))
+
+		return
+	}
+
+	zap.S().Infow("shutdown report written", "path", global.ShutdownReportPath(global.AgentCacheDir))
+}
+
+// drainEventBus dispatches all messages still queued in the event bus, in
+// topic priority order, and waits for the exporters to handle them.
+func drainEventBus(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	eventBus.Drain(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		global.DefaultExporterRegisterer.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		zap.S().Warnw("timed out waiting for exporters to handle buffered messages", "timeout", timeout)
+	}
+}
+
+// emitHostCloned emits the report of the inherited state reset at startup,
+// if the host was found to be a clone.
+func emitHostCloned(emitter emit.Emitter, report *global.CloneReport) {
+	if report == nil {
+		return
+	}
+
+	ev, err := model.NewWithCtx(report.EventContext(), model.AgentHostClonedName, timesync.Now())
+	if err != nil {
+		zap.S().Errorw("error creating event", zap.Error(err))
+
+		return
+	}
+
+	if err := emit.Ev(emitter, ev); err != nil {
+		zap.S().Errorw("error emitting event", zap.Error(err))
+	}
+}
+
+// emitFingerprintRotated emits the host identity change if the fingerprint
+// was replaced at startup.
+func emitFingerprintRotated(emitter emit.Emitter, rotation *fingerprint.Rotation) {
+	if rotation == nil {
+		return
+	}
+
+	ctx := map[string]interface{}{
+		model.FingerprintKey:         rotation.Current.Hash(),
+		model.PreviousFingerprintKey: rotation.Previous,
+	}
+	ev, err := model.NewWithCtx(ctx, model.AgentFingerprintRotatedName, timesync.Now())
+	if err != nil {
+		zap.S().Errorw("error creating event", zap.Error(err))
+
+		return
+	}
+
+	if err := emit.Ev(emitter, ev); err != nil {
+		zap.S().Errorw("error emitting event", zap.Error(err))
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"agent/internal/pkg/global"
+	"agent/internal/pkg/watch"
+
+	"go.uber.org/zap"
+)
+
+// recordStartupFailure records a degraded capability in the startup report.
+// Returns true in strict mode, where the caller carries on to collect the
+// remaining failures before exitOnStartupFailures aborts the startup.
+func recordStartupFailure(component string, kind global.FailureKind, err error) bool {
+	global.DefaultStartupReport.Record(component, kind, err)
+
+	return global.StrictStartup()
+}
+
+// exitOnStartupFailures exits in strict mode if any failure was recorded,
+// writing the startup report to stderr and to the state directory.
+func exitOnStartupFailures() {
+	if !global.StrictStartup() || !global.DefaultStartupReport.Failed() {
+		return
+	}
+
+	code := global.DefaultStartupReport.Finalize(time.Now())
+
+	fmt.Fprintln(os.Stderr, "strict startup: the agent failed to fully initialize")
+	if err := global.DefaultStartupReport.Write(os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing startup report: %v\n", err)
+	}
+
+	if global.AgentCacheDir != "" {
+		if err := global.DefaultStartupReport.WriteFile(global.AgentCacheDir); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+
+	os.Exit(code)
+}
+
+// probeWatcher records the failed probe of a watcher implementing
+// watch.Prober. Probes only run in strict mode.
+func probeWatcher(component string, w watch.Watcher) {
+	if !global.StrictStartup() {
+		return
+	}
+
+	p, ok := w.(watch.Prober)
+	if !ok {
+		return
+	}
+
+	if err := p.Probe(); err != nil {
+		zap.S().Errorw("watcher probe failed", "watcher", component, zap.Error(err))
+		recordStartupFailure(component, global.FailureCapability, err)
+	}
+}
+
+// probeNodeDiscovery records the configured discovery backends that are
+// unreachable, i.e. docker socket absent. Probes only run in strict mode.
+func probeNodeDiscovery(ctx context.Context) {
+	if !global.StrictStartup() || discoverer == nil {
+		return
+	}
+
+	errs := discoverer.Probe(ctx)
+	schemes := make([]string, 0, len(errs))
+	for scheme := range errs {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+
+	for _, scheme := range schemes {
+		zap.S().Errorw("node discovery backend unreachable", "scheme", scheme, zap.Error(errs[scheme]))
+		recordStartupFailure("discovery."+scheme, global.FailureCapability, errs[scheme])
+	}
+}
+
+// probePlatform records a failure if the platform address is unreachable.
+// The publisher retries on its own, the probe only runs in strict mode.
+func probePlatform() {
+	if !global.StrictStartup() || !global.AgentConf.Platform.IsEnabled() {
+		return
+	}
+
+	conn, err := net.DialTimeout("tcp", global.AgentConf.Platform.Addr, global.AgentConf.Platform.TransportTimeout)
+	if err != nil {
+		zap.S().Errorw("platform unreachable", "addr", global.AgentConf.Platform.Addr, zap.Error(err))
+		recordStartupFailure("platform", global.FailureNetwork, err)
+
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,84 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nosystemd
+// +build !nosystemd
+
+package main
+
+import (
+	"agent/internal/pkg/discover/utils"
+	"agent/internal/pkg/global"
+	"agent/internal/pkg/watch"
+
+	"go.uber.org/zap"
+)
+
+func init() {
+	nodeSchemes[global.NodeSystemd] = startSystemdScheme
+}
+
+// startSystemdScheme reconfigures the node from its unit's journal and
+// returns the systemd watchers.
+func startSystemdScheme() []watch.Watcher {
+	unit := discoverer.SystemdService()
+	if unit == nil {
+		zap.S().Fatal("got systemd scheme but systemd unit is nil")
+	}
+
+	reader, err := utils.NewJournalReader(unit.Name)
+	if err != nil {
+		zap.S().Warnw("error creating journald log reader", zap.Error(err))
+	} else {
+		if err := blockchain.ReconfigureBySystemdUnit(unit, reader); err != nil {
+			zap.S().Warnw("node metadata configuration failed for systemd", zap.Error(err))
+		}
+		reader.Close()
+	}
+
+	zap.S().Infow("starting systemd watchers")
+
+	return defaultSystemdWatchers()
+}
+
+func defaultSystemdWatchers() []watch.Watcher {
+	sdwConf := watch.SystemdServiceWatchConf{Discoverer: discoverer}
+	sdw, err := watch.NewSystemdServiceWatch(sdwConf)
+	if err != nil {
+		zap.S().Fatalw("cannot start node systemd watcher without regular expression or discoverer", zap.Error(err))
+	}
+
+	svc := discoverer.SystemdService()
+	if svc == nil || svc.Name == "" {
+		zap.S().Fatal("got nil systemd service object or empty unit name")
+	}
+
+	dw := []watch.Watcher{sdw}
+
+	// Log watch for event generation
+	logEvs := blockchain.LogEventsList()
+
+	// Docker container watch (logs)
+	logWatch, err := watch.NewJournaldLogWatch(watch.JournaldLogWatchConf{
+		UnitName: svc.Name,
+		Events:   logEvs,
+	})
+	if err != nil {
+		zap.S().Fatalw("cannot build journald log watch, this is probably a configuration error", zap.Error(err))
+	}
+
+	// start log watcher independently if conditions for it are met
+	go logWatch.PendingStart(subscriptions...)
+
+	return dw
+}
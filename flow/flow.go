@@ -295,6 +295,11 @@ func (d *Flow) LogEventsList() map[string]model.FromContext {
 	return eventsFromContext
 }
 
+// RoundEvent the finalized block event, flow rounds are views.
+func (d *Flow) RoundEvent() (string, string) {
+	return onFinalizedBlockName, "view"
+}
+
 // NodeLogPath Note: to be implemented with linux process discovery.
 func (d *Flow) NodeLogPath() string {
 	return ""
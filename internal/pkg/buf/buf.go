@@ -102,6 +102,15 @@ func (p *PriorityBuffer) Len() int {
 	return total
 }
 
+// MemoryBytes returns the encoded size of the buffered items, implements
+// global.MemoryReporter.
+func (p *PriorityBuffer) MemoryBytes() int64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.q.Bytes()
+}
+
 // NewPriorityBuffer PriorityBuffer constructor. TTL is used
 // to discard buffered data that were buffered for too long.
 func NewPriorityBuffer(ttl time.Duration) *PriorityBuffer {
@@ -21,6 +21,7 @@ import (
 	"agent/api/v1/model"
 
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
 )
 
 func newTestItem(priority uint, m *model.Message) Item {
@@ -186,3 +187,24 @@ func TestItemBatchClear(t *testing.T) {
 
 	require.Equal(t, 0, len(*b))
 }
+
+func TestPriorityBufferMemoryBytes(t *testing.T) {
+	pb := NewPriorityBuffer(0)
+
+	m := newTestMetric(0)
+	size := int64(proto.Size(&m))
+	require.NotZero(t, size)
+
+	err := pb.Insert(Item{Priority: low, Data: &m}, Item{Priority: high, Data: &m}, Item{Data: "untracked"})
+	require.NoError(t, err)
+	require.Equal(t, 2*size+int64(len("untracked")), pb.MemoryBytes())
+
+	got, err := pb.Get(1)
+	require.NoError(t, err)
+	require.Equal(t, Item{Priority: high, Data: &m}, got[0])
+	require.Equal(t, size+int64(len("untracked")), pb.MemoryBytes())
+
+	_, err = pb.Get(2)
+	require.NoError(t, err)
+	require.Zero(t, pb.MemoryBytes())
+}
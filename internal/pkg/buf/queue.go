@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 )
 
 // An Item is something we manage in a priority queue.
@@ -27,10 +28,28 @@ type Item struct {
 	Data      interface{}
 }
 
+// itemSize returns the encoded size of the item's data, an approximation
+// of the memory it retains. Data of unknown types is not accounted for.
+func itemSize(item Item) int64 {
+	switch data := item.Data.(type) {
+	case proto.Message:
+		return int64(proto.Size(data))
+	case []byte:
+		return int64(len(data))
+	case string:
+		return int64(len(data))
+	default:
+		return 0
+	}
+}
+
 // A priorityQueue implements heap.Interface and holds Items.
 type priorityQueue struct {
 	items []Item
 	ttl   time.Duration
+
+	// bytes encoded size of the queued items.
+	bytes int64
 }
 
 func (pq priorityQueue) Len() int { return len(pq.items) }
@@ -54,6 +73,7 @@ func (pq priorityQueue) Peek() interface{} {
 func (pq *priorityQueue) Push(x interface{}) {
 	itemQ := x.(Item)
 	pq.items = append(pq.items, itemQ)
+	pq.bytes += itemSize(itemQ)
 }
 
 func (pq *priorityQueue) Pop() interface{} {
@@ -65,9 +85,12 @@ func (pq *priorityQueue) Pop() interface{} {
 	n := len(old)
 	itemQ := old[n-1]
 	pq.items = old[0 : n-1]
+	pq.bytes -= itemSize(itemQ)
 
 	if len(pq.items) == 0 {
 		pq.items = nil
+		// items mutated while buffered may have drifted the count
+		pq.bytes = 0
 	}
 
 	return itemQ
@@ -133,6 +156,15 @@ func (m multiQueue) Len() int {
 	return cnt
 }
 
+func (m multiQueue) Bytes() int64 {
+	var total int64
+	for i := 0; i < len(m); i++ {
+		total += m[i].bytes
+	}
+
+	return total
+}
+
 func (m multiQueue) Push(x interface{}) {
 	itemQ, ok := x.(Item)
 	if !ok {
@@ -0,0 +1,399 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bus implements the agent's internal event bus. Watchers publish
+// messages to topics and exporters subscribe to the topics they are
+// interested in, each with its own buffering policy.
+package bus
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"agent/api/v1/model"
+	"agent/internal/pkg/global"
+
+	"go.uber.org/zap"
+)
+
+// Topic a class of messages published to the bus.
+type Topic string
+
+const (
+	// TopicAgentInternal events about the agent itself (agent.*).
+	TopicAgentInternal Topic = "agent-internal"
+
+	// TopicChainEvents events emitted by the monitored node.
+	TopicChainEvents Topic = "chain-events"
+
+	// TopicMetrics metric families.
+	TopicMetrics Topic = "metrics"
+)
+
+// Topics all topics, in priority order. Higher priority topics are
+// dispatched and drained first.
+var Topics = []Topic{TopicAgentInternal, TopicChainEvents, TopicMetrics}
+
+const (
+	// DefaultQueueSize default number of messages queued per topic.
+	DefaultQueueSize = 1000
+
+	// DefaultSubscriptionBuffer default subscription channel size.
+	DefaultSubscriptionBuffer = 1000
+
+	// DefaultBlockTimeout default max time to wait for room in a
+	// subscription using the Block policy.
+	DefaultBlockTimeout = 5 * time.Second
+)
+
+// TopicOf returns the topic msg belongs to.
+func TopicOf(msg interface{}) Topic {
+	m, ok := msg.(*model.Message)
+	if !ok {
+		return TopicAgentInternal
+	}
+
+	if _, ok := m.Value.(*model.Message_MetricFamily); ok {
+		return TopicMetrics
+	}
+
+	if strings.HasPrefix(m.Name, "agent.") {
+		return TopicAgentInternal
+	}
+
+	return TopicChainEvents
+}
+
+// Policy what to do with a message when a subscription's buffer is full.
+type Policy int
+
+const (
+	// DropNewest discards the incoming message.
+	DropNewest Policy = iota
+
+	// DropOldest evicts the oldest buffered message to make room.
+	DropOldest
+
+	// Block waits up to BlockTimeout for room in the buffer, holding back
+	// dispatching to every other subscriber meanwhile.
+	Block
+)
+
+// SubscriptionConf Subscription configuration.
+type SubscriptionConf struct {
+	// Topics subscribed to, all topics if empty.
+	Topics []Topic
+
+	// Buffer size of the subscription channel.
+	Buffer int
+
+	// Policy applied when the buffer is full.
+	Policy Policy
+
+	// BlockTimeout max time to wait for room when using Block.
+	BlockTimeout time.Duration
+}
+
+// Subscription receives the messages published to its topics.
+type Subscription struct {
+	SubscriptionConf
+
+	ch chan interface{}
+}
+
+// C returns the channel messages are delivered to. It is closed once the
+// bus is drained.
+func (s *Subscription) C() chan interface{} {
+	return s.ch
+}
+
+func (s *Subscription) deliver(msg interface{}) {
+	select {
+	case s.ch <- msg:
+		return
+	default:
+	}
+
+	switch s.Policy {
+	case DropOldest:
+		for {
+			select {
+			case s.ch <- msg:
+				return
+			default:
+			}
+
+			select {
+			case <-s.ch:
+				global.MetricsDropCnt.WithLabelValues("channel_blocked").Inc()
+			default:
+			}
+		}
+	case Block:
+		timer := time.NewTimer(s.BlockTimeout)
+		defer timer.Stop()
+
+		select {
+		case s.ch <- msg:
+			return
+		case <-timer.C:
+		}
+	}
+
+	zap.S().Warn("subscription channel blocked a message, discarding it")
+	global.MetricsDropCnt.WithLabelValues("channel_blocked").Inc()
+}
+
+// Bus dispatches the messages published to each topic to the topic's
+// subscriptions.
+type Bus struct {
+	mu     *sync.RWMutex
+	queues map[Topic]chan interface{}
+	subs   map[Topic][]*Subscription
+	closed bool
+
+	inlet     chan interface{}
+	inletStop chan struct{}
+	inletDone chan struct{}
+
+	startOnce *sync.Once
+	drainOnce *sync.Once
+	stop      chan context.Context
+	done      chan struct{}
+}
+
+// New returns a new Bus, queueing up to queueSize messages per topic.
+func New(queueSize int) *Bus {
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+
+	b := &Bus{
+		mu:        &sync.RWMutex{},
+		queues:    make(map[Topic]chan interface{}, len(Topics)),
+		subs:      make(map[Topic][]*Subscription, len(Topics)),
+		inlet:     make(chan interface{}, queueSize),
+		inletStop: make(chan struct{}),
+		inletDone: make(chan struct{}),
+		startOnce: &sync.Once{},
+		drainOnce: &sync.Once{},
+		stop:      make(chan context.Context),
+		done:      make(chan struct{}),
+	}
+
+	for _, topic := range Topics {
+		b.queues[topic] = make(chan interface{}, queueSize)
+	}
+
+	go b.forwardInlet()
+
+	return b
+}
+
+// Subscribe returns a new subscription. Subscriptions should be
+// registered before the bus is started, messages published to a topic
+// with no subscriptions are discarded.
+func (b *Bus) Subscribe(conf SubscriptionConf) *Subscription {
+	if len(conf.Topics) == 0 {
+		conf.Topics = Topics
+	}
+
+	if conf.Buffer <= 0 {
+		conf.Buffer = DefaultSubscriptionBuffer
+	}
+
+	if conf.BlockTimeout <= 0 {
+		conf.BlockTimeout = DefaultBlockTimeout
+	}
+
+	s := &Subscription{SubscriptionConf: conf, ch: make(chan interface{}, conf.Buffer)}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, topic := range conf.Topics {
+		b.subs[topic] = append(b.subs[topic], s)
+	}
+
+	return s
+}
+
+// Publish queues msg to topic. Returns false if msg was discarded because
+// the topic queue is full or the bus is drained.
+func (b *Bus) Publish(topic Topic, msg interface{}) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		zap.S().Debugw("bus drained, discarding message", "topic", topic)
+
+		return false
+	}
+
+	queue, ok := b.queues[topic]
+	if !ok {
+		zap.S().Errorw("unknown bus topic, discarding message", "topic", topic)
+
+		return false
+	}
+
+	select {
+	case queue <- msg:
+		return true
+	default:
+		zap.S().Warnw("bus topic queue full, discarding message", "topic", topic)
+		global.MetricsDropCnt.WithLabelValues("bus_full").Inc()
+
+		return false
+	}
+}
+
+// Emit publishes message to its topic, implements emit.Emitter.
+func (b *Bus) Emit(message interface{}) {
+	b.Publish(TopicOf(message), message)
+}
+
+// Inlet returns a channel whose messages are published to their topic. It
+// adapts producers emitting to a channel, such as watchers, to the bus.
+func (b *Bus) Inlet() chan<- interface{} {
+	return b.inlet
+}
+
+func (b *Bus) forwardInlet() {
+	defer close(b.inletDone)
+	defer global.ReportPanic()
+
+	for {
+		select {
+		case msg := <-b.inlet:
+			b.Emit(msg)
+		case <-b.inletStop:
+			for {
+				select {
+				case msg := <-b.inlet:
+					b.Emit(msg)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Start starts dispatching published messages to subscriptions.
+func (b *Bus) Start() {
+	b.startOnce.Do(func() {
+		go b.dispatch()
+	})
+}
+
+func (b *Bus) dispatch() {
+	defer close(b.done)
+	defer global.ReportPanic()
+
+	for {
+		// always favor higher priority topics
+		if topic, msg, ok := b.next(); ok {
+			b.deliver(topic, msg)
+
+			continue
+		}
+
+		select {
+		case msg := <-b.queues[TopicAgentInternal]:
+			b.deliver(TopicAgentInternal, msg)
+		case msg := <-b.queues[TopicChainEvents]:
+			b.deliver(TopicChainEvents, msg)
+		case msg := <-b.queues[TopicMetrics]:
+			b.deliver(TopicMetrics, msg)
+		case ctx := <-b.stop:
+			b.drain(ctx)
+
+			return
+		}
+	}
+}
+
+// next returns the next queued message in topic priority order.
+func (b *Bus) next() (Topic, interface{}, bool) {
+	for _, topic := range Topics {
+		select {
+		case msg := <-b.queues[topic]:
+			return topic, msg, true
+		default:
+		}
+	}
+
+	return "", nil, false
+}
+
+func (b *Bus) deliver(topic Topic, msg interface{}) {
+	b.mu.RLock()
+	subs := b.subs[topic]
+	b.mu.RUnlock()
+
+	for _, s := range subs {
+		s.deliver(msg)
+	}
+}
+
+// drain dispatches every queued message, topic by topic in priority
+// order, then closes all subscription channels.
+func (b *Bus) drain(ctx context.Context) {
+	for _, topic := range Topics {
+		queue := b.queues[topic]
+		for len(queue) > 0 {
+			msg := <-queue
+			if ctx.Err() != nil {
+				global.MetricsDropCnt.WithLabelValues("bus_drain_timeout").Inc()
+
+				continue
+			}
+			b.deliver(topic, msg)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	closed := map[*Subscription]struct{}{}
+	for _, subs := range b.subs {
+		for _, s := range subs {
+			if _, ok := closed[s]; !ok {
+				close(s.ch)
+				closed[s] = struct{}{}
+			}
+		}
+	}
+}
+
+// Drain stops accepting new messages, dispatches all queued messages in
+// topic priority order and closes subscription channels. Messages still
+// queued when ctx is done are discarded. The bus is started if it wasn't.
+func (b *Bus) Drain(ctx context.Context) {
+	b.drainOnce.Do(func() {
+		// flush producers first, they publish through the inlet
+		close(b.inletStop)
+		<-b.inletDone
+
+		b.mu.Lock()
+		b.closed = true
+		b.mu.Unlock()
+
+		b.Start()
+		b.stop <- ctx
+		<-b.done
+	})
+}
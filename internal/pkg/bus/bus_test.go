@@ -0,0 +1,153 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"agent/api/v1/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newEventMessage(name string) *model.Message {
+	return &model.Message{Name: name, Value: &model.Message_Event{Event: &model.Event{Name: name}}}
+}
+
+func newMetricMessage(name string) *model.Message {
+	return &model.Message{Name: name, Value: &model.Message_MetricFamily{MetricFamily: &model.MetricFamily{Name: name}}}
+}
+
+// receiveAll returns all messages delivered to s until its channel is closed.
+func receiveAll(t *testing.T, s *Subscription) []string {
+	var names []string
+	for {
+		select {
+		case msg, ok := <-s.C():
+			if !ok {
+				return names
+			}
+			names = append(names, msg.(*model.Message).Name)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for subscription channel to close")
+		}
+	}
+}
+
+func TestTopicOf(t *testing.T) {
+	require.Equal(t, TopicMetrics, TopicOf(newMetricMessage("pef.last_round")))
+	require.Equal(t, TopicAgentInternal, TopicOf(newEventMessage(model.AgentDownName)))
+	require.Equal(t, TopicChainEvents, TopicOf(newEventMessage("OnFinalizedBlock")))
+	require.Equal(t, TopicAgentInternal, TopicOf("unexpected"))
+}
+
+func TestBus_DrainPriorityOrder(t *testing.T) {
+	b := New(10)
+	s := b.Subscribe(SubscriptionConf{})
+
+	b.Emit(newMetricMessage("metric_1"))
+	b.Emit(newEventMessage("OnFinalizedBlock"))
+	b.Emit(newMetricMessage("metric_2"))
+	b.Emit(newEventMessage(model.AgentDownName))
+
+	b.Drain(context.Background())
+
+	require.Equal(t, []string{model.AgentDownName, "OnFinalizedBlock", "metric_1", "metric_2"}, receiveAll(t, s))
+	require.False(t, b.Publish(TopicMetrics, newMetricMessage("metric_3")))
+
+	// draining twice is a no-op
+	b.Drain(context.Background())
+}
+
+func TestBus_Topics(t *testing.T) {
+	b := New(10)
+	metrics := b.Subscribe(SubscriptionConf{Topics: []Topic{TopicMetrics}})
+	events := b.Subscribe(SubscriptionConf{Topics: []Topic{TopicChainEvents, TopicAgentInternal}})
+	all := b.Subscribe(SubscriptionConf{})
+	b.Start()
+
+	b.Emit(newMetricMessage("metric_1"))
+	b.Emit(newEventMessage("OnFinalizedBlock"))
+
+	b.Drain(context.Background())
+
+	require.Equal(t, []string{"metric_1"}, receiveAll(t, metrics))
+	require.Equal(t, []string{"OnFinalizedBlock"}, receiveAll(t, events))
+	require.ElementsMatch(t, []string{"metric_1", "OnFinalizedBlock"}, receiveAll(t, all))
+}
+
+func TestBus_Policies(t *testing.T) {
+	b := New(10)
+	dropNewest := b.Subscribe(SubscriptionConf{Buffer: 1, Policy: DropNewest})
+	dropOldest := b.Subscribe(SubscriptionConf{Buffer: 1, Policy: DropOldest})
+	block := b.Subscribe(SubscriptionConf{Buffer: 1, Policy: Block, BlockTimeout: 5 * time.Second})
+
+	b.Emit(newMetricMessage("metric_1"))
+	b.Emit(newMetricMessage("metric_2"))
+
+	// only the blocking subscription holds back dispatching
+	received := make(chan []string)
+	go func() {
+		received <- receiveAll(t, block)
+	}()
+
+	b.Drain(context.Background())
+
+	require.Equal(t, []string{"metric_1"}, receiveAll(t, dropNewest))
+	require.Equal(t, []string{"metric_2"}, receiveAll(t, dropOldest))
+	require.Equal(t, []string{"metric_1", "metric_2"}, <-received)
+}
+
+func TestBus_BlockTimeout(t *testing.T) {
+	b := New(10)
+	s := b.Subscribe(SubscriptionConf{Buffer: 1, Policy: Block, BlockTimeout: 10 * time.Millisecond})
+
+	b.Emit(newMetricMessage("metric_1"))
+	b.Emit(newMetricMessage("metric_2"))
+	b.Drain(context.Background())
+
+	require.Equal(t, []string{"metric_1"}, receiveAll(t, s))
+}
+
+func TestBus_Inlet(t *testing.T) {
+	b := New(10)
+	s := b.Subscribe(SubscriptionConf{})
+	b.Start()
+
+	b.Inlet() <- newEventMessage("OnFinalizedBlock")
+	require.Eventually(t, func() bool { return len(s.C()) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// messages still in the inlet are flushed on drain
+	b.Inlet() <- newEventMessage(model.AgentDownName)
+	b.Drain(context.Background())
+
+	require.Equal(t, []string{"OnFinalizedBlock", model.AgentDownName}, receiveAll(t, s))
+}
+
+func TestBus_DrainTimeout(t *testing.T) {
+	b := New(10)
+	s := b.Subscribe(SubscriptionConf{})
+
+	b.Emit(newMetricMessage("metric_1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// not started, the message is still queued when draining
+	b.drain(ctx)
+
+	require.Empty(t, receiveAll(t, s))
+}
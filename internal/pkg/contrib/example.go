@@ -15,12 +15,12 @@ package contrib
 
 import (
 	"context"
-	"encoding/json"
 	"os"
 	"path/filepath"
 
 	"agent/api/v1/model"
 	"agent/internal/pkg/global"
+	"agent/internal/pkg/replay"
 
 	"github.com/mitchellh/mapstructure"
 	"go.uber.org/zap"
@@ -31,34 +31,12 @@ import (
 // of data generated by the agent. It will marshal all messages
 // consumed to JSON and write them under $HOME/.cache/agent_stream.log.
 
-type logLine struct {
-	Hostname string              `json:"hostname"`
-	Mf       *model.MetricFamily `json:"mf,omitempty"`
-	Ev       *model.Event        `json:"ev,omitempty"`
-}
-
 type jsonProcessor struct{}
 
+// Process encodes msg as a JSON-lines envelope, the format expected by
+// agent replay.
 func (m *jsonProcessor) Process(msg *model.Message) ([]byte, error) {
-	var err error
-	line := &logLine{Hostname: global.AgentHostname}
-
-	if msg.GetMetricFamily() != nil {
-		line.Mf = msg.GetMetricFamily()
-	} else if msg.GetEvent() != nil {
-		line.Ev = msg.GetEvent()
-	} else {
-		zap.S().Errorf("dropping message without a value")
-
-		return nil, err
-	}
-
-	bytes, err := json.Marshal(&line)
-	if err != nil {
-		return nil, err
-	}
-
-	return bytes, nil
+	return replay.EncodeEnvelope(global.AgentHostname, msg)
 }
 
 type fileStream struct {
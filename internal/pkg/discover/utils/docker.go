@@ -0,0 +1,167 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nodocker
+// +build !nodocker
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	dt "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+)
+
+func init() {
+	DefaultDockerAdapter = &DockerProductionAdapter{climu: &sync.Mutex{}}
+}
+
+// DockerProductionAdapter adapter for accessing the host docker daemon
+type DockerProductionAdapter struct {
+	cli   *client.Client
+	climu *sync.Mutex
+}
+
+// Close closes the underlying http connection
+func (a *DockerProductionAdapter) Close() error {
+	a.climu.Lock()
+	defer a.climu.Unlock()
+
+	if a.cli != nil {
+		cli := a.cli
+		a.cli = nil
+		return cli.Close()
+	}
+	return nil
+}
+
+func (a *DockerProductionAdapter) resetClient() error {
+	cli, err := getDockerClient()
+	if err != nil {
+		a.cli = nil
+
+		return err
+	}
+	a.cli = cli
+	return nil
+}
+
+// GetRunningContainers returns a slice of all
+// currently running Docker containers
+func (a *DockerProductionAdapter) GetRunningContainers() ([]dt.Container, error) {
+	a.climu.Lock()
+	if a.cli == nil {
+		if err := a.resetClient(); err != nil {
+			return nil, err
+		}
+	}
+	a.climu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	containers, err := a.cli.ContainerList(ctx, dt.ContainerListOptions{})
+	if err != nil {
+		if !errors.Is(ErrContainerNotFound, err) {
+			a.climu.Lock()
+			a.cli.Close()
+			a.cli = nil
+			a.climu.Unlock()
+		}
+		return nil, err
+	}
+
+	return containers, nil
+}
+
+// MatchContainer takes a slice of containers and regex strings.
+// It returns the first running container to match any of the identifiers.
+// If no matches are found, ErrContainerNotFound is returned.
+func (a *DockerProductionAdapter) MatchContainer(containers []dt.Container, identifiers []string) (dt.Container, error) {
+	return matchContainer(containers, identifiers)
+}
+
+// DockerLogs returns a container's logs
+func (a *DockerProductionAdapter) DockerLogs(ctx context.Context, container string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	a.climu.Lock()
+	if a.cli == nil {
+		if err := a.resetClient(); err != nil {
+			return nil, err
+		}
+	}
+	a.climu.Unlock()
+
+	reader, err := a.cli.ContainerLogs(ctx, container, options)
+	if err != nil {
+		if !strings.Contains(err.Error(), "No such container") {
+			a.climu.Lock()
+			a.cli.Close()
+			a.cli = nil
+			a.climu.Unlock()
+		}
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+// DockerEvents gets channels for consuming docker events subscription messages and errors
+func (a *DockerProductionAdapter) DockerEvents(ctx context.Context, options types.EventsOptions) (
+	<-chan events.Message, <-chan error, error,
+) {
+	a.climu.Lock()
+	if a.cli == nil {
+		if err := a.resetClient(); err != nil {
+			return nil, nil, err
+		}
+	}
+	a.climu.Unlock()
+
+	msgchan, errchan := a.cli.Events(ctx, options)
+	return msgchan, errchan, nil
+}
+
+func getDockerClient() (*client.Client, error) {
+	defaultOpts := []client.Opt{
+		client.FromEnv,
+		client.WithAPIVersionNegotiation(),
+	}
+
+	if DefaultDockerHost != "" {
+		defaultOpts = append(defaultOpts, client.WithHTTPClient(
+			&http.Client{
+				Transport: &http.Transport{
+					Dial: func(network, addr string) (net.Conn, error) {
+						return net.DialTimeout(network, addr, time.Second)
+					},
+				},
+			}))
+	}
+
+	dockerCLI, err := client.NewClientWithOpts(defaultOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return dockerCLI, nil
+}
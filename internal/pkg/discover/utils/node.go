@@ -24,7 +24,6 @@ import (
 	"agent/internal/pkg/global"
 
 	"github.com/coreos/go-systemd/v22/dbus"
-	"github.com/coreos/go-systemd/v22/sdjournal"
 	"github.com/docker/docker/api/types"
 	"go.uber.org/zap"
 )
@@ -42,6 +41,17 @@ var (
 	// ErrNodeDiscoveryCancelled node discovery cancelled error
 	ErrNodeDiscoveryCancelled = errors.New("node discovery cancelled")
 
+	// ErrSystemdUnsupported agent built without systemd support (nosystemd)
+	ErrSystemdUnsupported = errors.New("systemd support not compiled in")
+
+	// ErrNodeDiscoveryUnsupported none of the configured discovery schemes
+	// are supported by this build
+	ErrNodeDiscoveryUnsupported = errors.New("agent built without support for the configured discovery schemes")
+
+	// DefaultSystemdAdapter default systemd adapter for service discovery.
+	// Set to SystemdProductionAdapter unless built with the nosystemd tag.
+	DefaultSystemdAdapter = SystemdAdapter(unsupportedSystemdAdapter{})
+
 	// supportedSchemes list of supported running schemes
 	supportedSchemes = map[global.NodeRunScheme]bool{global.NodeDocker: true, global.NodeSystemd: true}
 )
@@ -55,6 +65,41 @@ type NodeDiscovery interface {
 	DetectSystemdService(ctx context.Context) (*dbus.UnitStatus, error)
 }
 
+// SystemdAdapter systemd service discovery interface.
+type SystemdAdapter interface {
+	// ListRunningUnits returns the running units matching any of the globs.
+	ListRunningUnits(ctx context.Context, globs []string) ([]dbus.UnitStatus, error)
+
+	// JournalReader returns a reader over the last tail journal entries
+	// of a unit.
+	JournalReader(unit string, tail uint64) (io.ReadCloser, error)
+
+	Close() error
+}
+
+// unsupportedSystemdAdapter SystemdAdapter used when the agent is built
+// without systemd support.
+type unsupportedSystemdAdapter struct{}
+
+func (unsupportedSystemdAdapter) ListRunningUnits(context.Context, []string) ([]dbus.UnitStatus, error) {
+	return nil, ErrSystemdUnsupported
+}
+
+func (unsupportedSystemdAdapter) JournalReader(string, uint64) (io.ReadCloser, error) {
+	return nil, ErrSystemdUnsupported
+}
+
+func (unsupportedSystemdAdapter) Close() error {
+	return nil
+}
+
+// SystemdSupported returns true if the agent is built with systemd support.
+func SystemdSupported() bool {
+	_, unsupported := DefaultSystemdAdapter.(unsupportedSystemdAdapter)
+
+	return !unsupported
+}
+
 // NodeDiscovererConfig used to configure NodeDiscoverer
 type NodeDiscovererConfig struct {
 	ContainerRegex []string
@@ -66,10 +111,8 @@ type NodeDiscovererConfig struct {
 type NodeDiscoverer struct {
 	NodeDiscovererConfig
 
-	container      *types.Container
-	service        *dbus.UnitStatus
-	dbusConn       *dbus.Conn
-	dbusConnRepair bool
+	container *types.Container
+	service   *dbus.UnitStatus
 }
 
 // NewNodeDiscoverer builds a new node discoverer object useful at agent startup
@@ -79,6 +122,21 @@ func NewNodeDiscoverer(c NodeDiscovererConfig) (*NodeDiscoverer, error) {
 		return nil, ErrNodeDiscovererConfig
 	}
 
+	// skip schemes left out of the build
+	if len(c.ContainerRegex) > 0 && !DockerSupported() {
+		zap.S().Warnw("agent built without docker support, ignoring docker discovery", "regex", c.ContainerRegex)
+		c.ContainerRegex = nil
+	}
+
+	if len(c.UnitGlob) > 0 && !SystemdSupported() {
+		zap.S().Warnw("agent built without systemd support, ignoring systemd discovery", "glob", c.UnitGlob)
+		c.UnitGlob = nil
+	}
+
+	if len(c.UnitGlob) == 0 && len(c.ContainerRegex) == 0 {
+		return nil, ErrNodeDiscoveryUnsupported
+	}
+
 	return &NodeDiscoverer{NodeDiscovererConfig: c}, nil
 }
 
@@ -123,21 +181,11 @@ func (n *NodeDiscoverer) DetectDockerContainer(ctx context.Context) (*types.Cont
 // DetectSystemdService lists all systemd units and matches its names against a configured
 // regular expression and updates the cached service object.
 func (n *NodeDiscoverer) DetectSystemdService(ctx context.Context) (*dbus.UnitStatus, error) {
-	if n.dbusConn == nil {
-		conn, err := dbus.NewWithContext(ctx)
-		if err != nil {
-			return nil, err
-		}
-		n.dbusConn = conn
-	}
-
 	zap.S().Debugw("listing systemd units", "glob", n.UnitGlob)
 
-	units, err := n.dbusConn.ListUnitsByPatternsContext(ctx, []string{"running"}, n.UnitGlob)
+	units, err := DefaultSystemdAdapter.ListRunningUnits(ctx, n.UnitGlob)
 	if err != nil {
 		n.service = nil
-		n.dbusConn.Close()
-		n.dbusConn = nil // repair connection on next call
 		return nil, err
 	}
 
@@ -158,36 +206,35 @@ func (n *NodeDiscoverer) DetectSystemdService(ctx context.Context) (*dbus.UnitSt
 
 // Close releases underlying resources
 func (n *NodeDiscoverer) Close() {
-	if n.dbusConn != nil {
-		n.dbusConn.Close()
-	}
+	DefaultSystemdAdapter.Close()
 }
 
-var tailLines = uint64(100)
+// Probe checks the configured discovery backends are reachable, i.e. the
+// docker socket is accessible, and returns their errors by scheme. A
+// backend is reachable even if no node matches yet.
+func (n *NodeDiscoverer) Probe(ctx context.Context) map[string]error {
+	errs := map[string]error{}
 
-// NewJournalReader returns an io.Reader to read journald logs for the discovered systemd unit.
-func NewJournalReader(glob string) (io.ReadCloser, error) {
-	formatter := func(entry *sdjournal.JournalEntry) (string, error) {
-		v, ok := entry.Fields[sdjournal.SD_JOURNAL_FIELD_MESSAGE]
-		if !ok {
-			return "", fmt.Errorf("journal entry without SD_JOURNAL_FIELD_MESSAGE field")
+	if len(n.ContainerRegex) > 0 {
+		if _, err := GetRunningContainers(); err != nil {
+			errs["docker"] = err
 		}
-		return v + "\n", nil
 	}
 
-	jrc := sdjournal.JournalReaderConfig{}
-	jrc.Formatter = formatter
-	jrc.Matches = []sdjournal.Match{
-		{Field: sdjournal.SD_JOURNAL_FIELD_SYSTEMD_UNIT, Value: glob},
+	if len(n.UnitGlob) > 0 {
+		if _, err := DefaultSystemdAdapter.ListRunningUnits(ctx, n.UnitGlob); err != nil {
+			errs["systemd"] = err
+		}
 	}
-	jrc.NumFromTail = tailLines
 
-	reader, err := sdjournal.NewJournalReader(jrc)
-	if err != nil {
-		return nil, err
-	}
+	return errs
+}
 
-	return reader, nil
+var tailLines = uint64(100)
+
+// NewJournalReader returns an io.Reader to read journald logs for the discovered systemd unit.
+func NewJournalReader(glob string) (io.ReadCloser, error) {
+	return DefaultSystemdAdapter.JournalReader(glob, tailLines)
 }
 
 // NewDockerLogsReader returns an io.Reader to read docker logs of the discovered container.
@@ -302,10 +349,7 @@ func (n *NodeDiscoverer) DetectScheme(ctx context.Context) global.NodeRunScheme
 
 		switch scheme {
 		case global.NodeDocker:
-			if n.dbusConn != nil {
-				n.dbusConn.Close()
-				n.dbusConn = nil
-			}
+			DefaultSystemdAdapter.Close()
 			n.service = nil
 		}
 
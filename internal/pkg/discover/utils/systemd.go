@@ -0,0 +1,99 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nosystemd
+// +build !nosystemd
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	"github.com/coreos/go-systemd/v22/sdjournal"
+)
+
+func init() {
+	DefaultSystemdAdapter = &SystemdProductionAdapter{connmu: &sync.Mutex{}}
+}
+
+// SystemdProductionAdapter adapter for accessing the host systemd over D-Bus
+// and its journal.
+type SystemdProductionAdapter struct {
+	conn   *dbus.Conn
+	connmu *sync.Mutex
+}
+
+// ListRunningUnits returns the running units matching any of the globs.
+func (a *SystemdProductionAdapter) ListRunningUnits(ctx context.Context, globs []string) ([]dbus.UnitStatus, error) {
+	a.connmu.Lock()
+	defer a.connmu.Unlock()
+
+	if a.conn == nil {
+		conn, err := dbus.NewWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		a.conn = conn
+	}
+
+	units, err := a.conn.ListUnitsByPatternsContext(ctx, []string{"running"}, globs)
+	if err != nil {
+		a.conn.Close()
+		a.conn = nil // repair connection on next call
+		return nil, err
+	}
+
+	return units, nil
+}
+
+// JournalReader returns a reader over the last tail journal entries of a unit.
+func (a *SystemdProductionAdapter) JournalReader(unit string, tail uint64) (io.ReadCloser, error) {
+	formatter := func(entry *sdjournal.JournalEntry) (string, error) {
+		v, ok := entry.Fields[sdjournal.SD_JOURNAL_FIELD_MESSAGE]
+		if !ok {
+			return "", fmt.Errorf("journal entry without SD_JOURNAL_FIELD_MESSAGE field")
+		}
+		return v + "\n", nil
+	}
+
+	jrc := sdjournal.JournalReaderConfig{}
+	jrc.Formatter = formatter
+	jrc.Matches = []sdjournal.Match{
+		{Field: sdjournal.SD_JOURNAL_FIELD_SYSTEMD_UNIT, Value: unit},
+	}
+	jrc.NumFromTail = tail
+
+	reader, err := sdjournal.NewJournalReader(jrc)
+	if err != nil {
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+// Close closes the underlying D-Bus connection
+func (a *SystemdProductionAdapter) Close() error {
+	a.connmu.Lock()
+	defer a.connmu.Unlock()
+
+	if a.conn != nil {
+		a.conn.Close()
+		a.conn = nil
+	}
+
+	return nil
+}
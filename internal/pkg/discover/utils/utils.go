@@ -18,17 +18,12 @@ import (
 	"context"
 	"errors"
 	"io"
-	"net"
-	"net/http"
 	"regexp"
 	"strings"
-	"sync"
-	"time"
 
 	"github.com/docker/docker/api/types"
 	dt "github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/events"
-	"github.com/docker/docker/client"
 	"github.com/joho/godotenv"
 )
 
@@ -42,8 +37,12 @@ var (
 	// DefaultDockerHost host docker daemon address to connect to
 	DefaultDockerHost = ""
 
+	// ErrDockerUnsupported agent built without docker support (nodocker)
+	ErrDockerUnsupported = errors.New("docker support not compiled in")
+
 	// DefaultDockerAdapter default docker adapter for container discovery.
-	DefaultDockerAdapter = DockerAdapter(&DockerProductionAdapter{climu: &sync.Mutex{}})
+	// Set to DockerProductionAdapter unless built with the nodocker tag.
+	DefaultDockerAdapter = DockerAdapter(unsupportedDockerAdapter{})
 )
 
 // DockerAdapter container discovery interface.
@@ -65,68 +64,42 @@ type DockerAdapter interface {
 	Close() error
 }
 
-// DockerProductionAdapter adapter for accessing the host docker daemon
-type DockerProductionAdapter struct {
-	cli   *client.Client
-	climu *sync.Mutex
+// unsupportedDockerAdapter DockerAdapter used when the agent is built
+// without docker support. Only matching containers is supported.
+type unsupportedDockerAdapter struct{}
+
+func (unsupportedDockerAdapter) GetRunningContainers() ([]dt.Container, error) {
+	return nil, ErrDockerUnsupported
 }
 
-// Close closes the underlying http connection
-func (a *DockerProductionAdapter) Close() error {
-	a.climu.Lock()
-	defer a.climu.Unlock()
+func (unsupportedDockerAdapter) MatchContainer(containers []dt.Container, identifiers []string) (dt.Container, error) {
+	return matchContainer(containers, identifiers)
+}
 
-	if a.cli != nil {
-		cli := a.cli
-		a.cli = nil
-		return cli.Close()
-	}
-	return nil
+func (unsupportedDockerAdapter) DockerLogs(context.Context, string, types.ContainerLogsOptions) (io.ReadCloser, error) {
+	return nil, ErrDockerUnsupported
 }
 
-func (a *DockerProductionAdapter) resetClient() error {
-	cli, err := getDockerClient()
-	if err != nil {
-		a.cli = nil
+func (unsupportedDockerAdapter) DockerEvents(context.Context, types.EventsOptions) (
+	<-chan events.Message, <-chan error, error,
+) {
+	return nil, nil, ErrDockerUnsupported
+}
 
-		return err
-	}
-	a.cli = cli
+func (unsupportedDockerAdapter) Close() error {
 	return nil
 }
 
-// GetRunningContainers returns a slice of all
-// currently running Docker containers
-func (a *DockerProductionAdapter) GetRunningContainers() ([]dt.Container, error) {
-	a.climu.Lock()
-	if a.cli == nil {
-		if err := a.resetClient(); err != nil {
-			return nil, err
-		}
-	}
-	a.climu.Unlock()
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	containers, err := a.cli.ContainerList(ctx, dt.ContainerListOptions{})
-	if err != nil {
-		if !errors.Is(ErrContainerNotFound, err) {
-			a.climu.Lock()
-			a.cli.Close()
-			a.cli = nil
-			a.climu.Unlock()
-		}
-		return nil, err
-	}
+// DockerSupported returns true if the agent is built with docker support.
+func DockerSupported() bool {
+	_, unsupported := DefaultDockerAdapter.(unsupportedDockerAdapter)
 
-	return containers, nil
+	return !unsupported
 }
 
-// MatchContainer takes a slice of containers and regex strings.
-// It returns the first running container to match any of the identifiers.
+// matchContainer returns the first running container to match any of the identifiers.
 // If no matches are found, ErrContainerNotFound is returned.
-func (a *DockerProductionAdapter) MatchContainer(containers []dt.Container, identifiers []string) (dt.Container, error) {
+func matchContainer(containers []dt.Container, identifiers []string) (dt.Container, error) {
 	for _, container := range containers {
 		for _, rStr := range identifiers {
 			r, err := regexp.Compile(rStr)
@@ -150,46 +123,6 @@ func (a *DockerProductionAdapter) MatchContainer(containers []dt.Container, iden
 	return dt.Container{}, ErrContainerNotFound
 }
 
-// DockerLogs returns a container's logs
-func (a *DockerProductionAdapter) DockerLogs(ctx context.Context, container string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
-	a.climu.Lock()
-	if a.cli == nil {
-		if err := a.resetClient(); err != nil {
-			return nil, err
-		}
-	}
-	a.climu.Unlock()
-
-	reader, err := a.cli.ContainerLogs(ctx, container, options)
-	if err != nil {
-		if !strings.Contains(err.Error(), "No such container") {
-			a.climu.Lock()
-			a.cli.Close()
-			a.cli = nil
-			a.climu.Unlock()
-		}
-		return nil, err
-	}
-
-	return reader, nil
-}
-
-// DockerEvents gets channels for consuming docker events subscription messages and errors
-func (a *DockerProductionAdapter) DockerEvents(ctx context.Context, options types.EventsOptions) (
-	<-chan events.Message, <-chan error, error,
-) {
-	a.climu.Lock()
-	if a.cli == nil {
-		if err := a.resetClient(); err != nil {
-			return nil, nil, err
-		}
-	}
-	a.climu.Unlock()
-
-	msgchan, errchan := a.cli.Events(ctx, options)
-	return msgchan, errchan, nil
-}
-
 // GetRunningContainers convenience wrapper to the default adapter for
 // getting running containers.
 func GetRunningContainers() ([]dt.Container, error) {
@@ -233,31 +166,6 @@ func GetLogLine(scan *bufio.Scanner) ([]byte, error) {
 	return scan.Bytes(), nil
 }
 
-func getDockerClient() (*client.Client, error) {
-	defaultOpts := []client.Opt{
-		client.FromEnv,
-		client.WithAPIVersionNegotiation(),
-	}
-
-	if DefaultDockerHost != "" {
-		defaultOpts = append(defaultOpts, client.WithHTTPClient(
-			&http.Client{
-				Transport: &http.Transport{
-					Dial: func(network, addr string) (net.Conn, error) {
-						return net.DialTimeout(network, addr, time.Second)
-					},
-				},
-			}))
-	}
-
-	dockerCLI, err := client.NewClientWithOpts(defaultOpts...)
-	if err != nil {
-		return nil, err
-	}
-
-	return dockerCLI, nil
-}
-
 const (
 	networkMainnet   = "mainnet"
 	networkLocalnet  = "localnet"
@@ -0,0 +1,245 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprint
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DefaultMetadataTimeout the timeout of a cloud metadata source if none is
+// configured, short enough not to hold up the agent off the cloud.
+const DefaultMetadataTimeout = time.Second
+
+const (
+	linkLocalEndpoint   = "http://169.254.169.254"
+	gceMetadataEndpoint = "http://metadata.google.internal"
+
+	ec2TokenTTL = "60"
+
+	// maxMetadataSize upper bound of a metadata value read.
+	maxMetadataSize = 4096
+)
+
+// ErrNoCloudSource none of the probed cloud metadata sources responded.
+var ErrNoCloudSource = errors.New("no cloud metadata source available")
+
+// SourceUnavailableError a fingerprint source could not be reached, i.e.
+// the agent doesn't run on the matching cloud provider.
+type SourceUnavailableError struct {
+	Source string
+	err    error
+}
+
+func (s *SourceUnavailableError) Error() string {
+	return fmt.Sprintf("fingerprint source %s unavailable: %v", s.Source, s.err)
+}
+
+func (s *SourceUnavailableError) Unwrap() error {
+	return s.err
+}
+
+// EC2Source the AWS EC2 instance ID, read from the instance metadata
+// service with a session token (IMDSv2).
+type EC2Source struct {
+	// Endpoint defaults to http://169.254.169.254.
+	Endpoint string
+
+	// Timeout defaults to DefaultMetadataTimeout.
+	Timeout time.Duration
+
+	Optional bool
+}
+
+func (s EC2Source) name() string {
+	return "ec2_instance_id"
+}
+
+func (s EC2Source) bytes() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), metadataTimeout(s.Timeout))
+	defer cancel()
+
+	endpoint := metadataEndpoint(s.Endpoint, linkLocalEndpoint)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", ec2TokenTTL)
+
+	token, err := metadataGet(s.name(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/latest/meta-data/instance-id", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
+
+	return metadataGet(s.name(), req)
+}
+
+func (s EC2Source) optional() bool {
+	return s.Optional
+}
+
+// GCESource the Google Compute Engine instance ID.
+type GCESource struct {
+	// Endpoint defaults to http://metadata.google.internal.
+	Endpoint string
+
+	// Timeout defaults to DefaultMetadataTimeout.
+	Timeout time.Duration
+
+	Optional bool
+}
+
+func (s GCESource) name() string {
+	return "gce_instance_id"
+}
+
+func (s GCESource) bytes() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), metadataTimeout(s.Timeout))
+	defer cancel()
+
+	u := metadataEndpoint(s.Endpoint, gceMetadataEndpoint) + "/computeMetadata/v1/instance/id"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	return metadataGet(s.name(), req)
+}
+
+func (s GCESource) optional() bool {
+	return s.Optional
+}
+
+// AzureSource the Azure virtual machine ID.
+type AzureSource struct {
+	// Endpoint defaults to http://169.254.169.254.
+	Endpoint string
+
+	// Timeout defaults to DefaultMetadataTimeout.
+	Timeout time.Duration
+
+	Optional bool
+}
+
+func (s AzureSource) name() string {
+	return "azure_vm_id"
+}
+
+func (s AzureSource) bytes() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), metadataTimeout(s.Timeout))
+	defer cancel()
+
+	u := metadataEndpoint(s.Endpoint, linkLocalEndpoint) +
+		"/metadata/instance/compute/vmId?api-version=2017-08-01&format=text"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata", "true")
+
+	return metadataGet(s.name(), req)
+}
+
+func (s AzureSource) optional() bool {
+	return s.Optional
+}
+
+// ProbeCloudSource queries the cloud metadata sources, EC2, GCE and Azure
+// with the default settings if none is given, and returns the first one in
+// order that responds. They are probed concurrently, the probe takes as long
+// as the slowest source.
+func ProbeCloudSource(srcs ...source) (source, error) {
+	if len(srcs) == 0 {
+		srcs = []source{EC2Source{}, GCESource{}, AzureSource{}}
+	}
+
+	errs := make([]error, len(srcs))
+	done := make(chan struct{})
+	for i := range srcs {
+		go func(i int) {
+			_, errs[i] = srcs[i].bytes()
+			done <- struct{}{}
+		}(i)
+	}
+	for range srcs {
+		<-done
+	}
+
+	for i, err := range errs {
+		if err == nil {
+			return srcs[i], nil
+		}
+	}
+
+	return nil, ErrNoCloudSource
+}
+
+// metadataGet does a metadata request, any failure to get a value means the
+// source is unavailable.
+func metadataGet(name string, req *http.Request) ([]byte, error) {
+	unavailable := func(err error) error {
+		return &SourceUnavailableError{Source: name, err: err}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, unavailable(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, unavailable(fmt.Errorf("non-200 response from metadata store: %s", resp.Status))
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxMetadataSize))
+	if err != nil {
+		return nil, unavailable(err)
+	}
+
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return nil, unavailable(ErrEmptySource)
+	}
+
+	return b, nil
+}
+
+func metadataEndpoint(endpoint, def string) string {
+	if endpoint == "" {
+		return def
+	}
+
+	return endpoint
+}
+
+func metadataTimeout(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return DefaultMetadataTimeout
+	}
+
+	return timeout
+}
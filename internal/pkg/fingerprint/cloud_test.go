@@ -0,0 +1,189 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprint
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const ec2TestToken = "AQAEAFoo"
+
+func newEC2Server(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, ec2TestToken)
+	})
+	mux.HandleFunc("/latest/meta-data/instance-id", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-aws-ec2-metadata-token") != ec2TestToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, "i-0123456789abcdef0")
+	})
+
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func newGCEServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/computeMetadata/v1/instance/id" || r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Metadata-Flavor", "Google")
+		io.WriteString(w, "4520031799277581759")
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func newAzureServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metadata/instance/compute/vmId" ||
+			r.URL.Query().Get("api-version") == "" ||
+			r.Header.Get("Metadata") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, "02aab8a4-74ef-476e-8182-f6d2ba4166a6\n")
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+// newSilentServer a server that never answers within the timeout.
+func newSilentServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		close(done)
+		ts.Close()
+	})
+
+	return ts
+}
+
+func TestCloudSources(t *testing.T) {
+	ec2 := newEC2Server(t)
+	gce := newGCEServer(t)
+	azure := newAzureServer(t)
+
+	tests := []struct {
+		name string
+		src  source
+		exp  string
+	}{
+		{name: "ec2", src: EC2Source{Endpoint: ec2.URL}, exp: "i-0123456789abcdef0"},
+		{name: "gce", src: GCESource{Endpoint: gce.URL}, exp: "4520031799277581759"},
+		{name: "azure", src: AzureSource{Endpoint: azure.URL}, exp: "02aab8a4-74ef-476e-8182-f6d2ba4166a6"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.src.bytes()
+			require.NoError(t, err)
+			require.Equal(t, tt.exp, string(b))
+		})
+	}
+}
+
+func TestCloudSources_Unavailable(t *testing.T) {
+	gce := newGCEServer(t)
+	silent := newSilentServer(t)
+
+	tests := []struct {
+		name string
+		src  source
+	}{
+		// the GCE server rejects the token request
+		{name: "wrong provider", src: EC2Source{Endpoint: gce.URL}},
+		{name: "timeout", src: AzureSource{Endpoint: silent.URL, Timeout: 50 * time.Millisecond}},
+		{name: "connection refused", src: GCESource{Endpoint: "http://127.0.0.1:1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.src.bytes()
+
+			var unavailable *SourceUnavailableError
+			require.ErrorAs(t, err, &unavailable)
+			require.Equal(t, tt.src.name(), unavailable.Source)
+		})
+	}
+}
+
+func TestProbeCloudSource(t *testing.T) {
+	ec2 := newEC2Server(t)
+	gce := newGCEServer(t)
+	silent := newSilentServer(t)
+
+	src, err := ProbeCloudSource(
+		EC2Source{Endpoint: gce.URL},
+		AzureSource{Endpoint: silent.URL, Timeout: 50 * time.Millisecond},
+		GCESource{Endpoint: gce.URL},
+		EC2Source{Endpoint: ec2.URL},
+	)
+	require.NoError(t, err)
+	require.Equal(t, GCESource{Endpoint: gce.URL}, src)
+
+	_, err = ProbeCloudSource(
+		EC2Source{Endpoint: gce.URL},
+		AzureSource{Endpoint: silent.URL, Timeout: 50 * time.Millisecond},
+	)
+	require.ErrorIs(t, err, ErrNoCloudSource)
+}
+
+func TestNewFromSources_OptionalCloudSource(t *testing.T) {
+	ec2 := newEC2Server(t)
+	machineID := fakeSource{n: "machine_id", b: []byte("c0ffee")}
+
+	onEC2, err := NewFromSources(io.Discard, machineID, EC2Source{Endpoint: ec2.URL, Optional: true})
+	require.NoError(t, err)
+
+	offCloud, err := NewFromSources(io.Discard, machineID, EC2Source{Endpoint: "http://127.0.0.1:1", Optional: true})
+	require.NoError(t, err)
+	require.NotEqual(t, onEC2.Hash(), offCloud.Hash())
+
+	_, err = NewFromSources(io.Discard, machineID, EC2Source{Endpoint: "http://127.0.0.1:1"})
+	var unavailable *SourceUnavailableError
+	require.ErrorAs(t, err, &unavailable)
+}
@@ -0,0 +1,130 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprint
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrInvalidEnvelope a stored fingerprint envelope can't be parsed.
+var ErrInvalidEnvelope = errors.New("invalid fingerprint envelope")
+
+// Meta describes how a fingerprint was generated, for diagnostics.
+type Meta struct {
+	// CreatedAt defaults to now.
+	CreatedAt time.Time
+
+	AgentVersion string
+
+	// Sources defaults to the sources of a fingerprint created with
+	// NewFromSources.
+	Sources []string
+}
+
+// Envelope a fingerprint stored along with its metadata.
+type Envelope struct {
+	Hash         string    `json:"hash"`
+	Algo         string    `json:"algo"`
+	KeyID        string    `json:"key_id,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	AgentVersion string    `json:"agent_version"`
+	Sources      []string  `json:"sources"`
+}
+
+// encoded returns the fingerprint of the envelope in the plain format.
+func (e *Envelope) encoded() string {
+	if e.KeyID != "" {
+		return e.Algo + ":" + e.KeyID + ":" + e.Hash
+	}
+
+	return e.Algo + ":" + e.Hash
+}
+
+func (e *Envelope) String() string {
+	return fmt.Sprintf("created at %s by agent %s from sources [%s]",
+		e.CreatedAt.Format(time.RFC3339), e.AgentVersion, strings.Join(e.Sources, ","))
+}
+
+// Sources returns the names of the sources the fingerprint was computed
+// from, if it was created with NewFromSources.
+func (f Fingerprint) Sources() []string {
+	return f.sources
+}
+
+// Envelope returns the fingerprint with its metadata.
+func (f Fingerprint) Envelope(meta Meta) *Envelope {
+	env := &Envelope{
+		Hash:         f.hash,
+		Algo:         string(f.alg),
+		CreatedAt:    meta.CreatedAt,
+		AgentVersion: meta.AgentVersion,
+		Sources:      meta.Sources,
+	}
+
+	if f.key != nil {
+		env.Algo = hmacPrefix + env.Algo
+		env.KeyID = f.keyID
+	}
+
+	if env.CreatedAt.IsZero() {
+		env.CreatedAt = time.Now()
+	}
+	env.CreatedAt = env.CreatedAt.UTC()
+
+	if env.Sources == nil {
+		env.Sources = f.sources
+	}
+	if env.Sources == nil {
+		env.Sources = []string{}
+	}
+
+	return env
+}
+
+// WriteEnvelope writes the fingerprint with its metadata to out, as JSON.
+func (f Fingerprint) WriteEnvelope(out io.Writer, meta Meta) error {
+	b, err := json.MarshalIndent(f.Envelope(meta), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = out.Write(append(b, '\n'))
+
+	return err
+}
+
+// isEnvelope whether a stored fingerprint is an envelope rather than a
+// plain hash.
+func isEnvelope(stored []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(stored), []byte("{"))
+}
+
+func parseEnvelope(stored []byte) (*Envelope, error) {
+	env := &Envelope{}
+	if err := json.Unmarshal(stored, env); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+
+	if env.Hash == "" || env.Algo == "" {
+		return nil, fmt.Errorf("%w: missing hash or algo", ErrInvalidEnvelope)
+	}
+
+	return env, nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprint
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var goldenMeta = Meta{
+	CreatedAt:    time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC),
+	AgentVersion: "v0.9.0",
+	Sources:      []string{"hostname", "machine_id"},
+}
+
+func TestWriteEnvelope(t *testing.T) {
+	fp, err := New(ioutil.Discard, []byte("foobar"))
+	require.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, fp.WriteEnvelope(buf, goldenMeta))
+
+	golden, err := os.ReadFile("fixtures/envelope.json")
+	require.NoError(t, err)
+	require.Equal(t, string(golden), buf.String())
+}
+
+func TestWriteEnvelope_Defaults(t *testing.T) {
+	fp, err := NewFromSources(ioutil.Discard,
+		fakeSource{n: "machine_id", b: []byte("c0ffee")},
+		fakeSource{n: "dmi_product_uuid", opt: true},
+		fakeSource{n: "hostname", b: []byte("node-1")},
+	)
+	require.NoError(t, err)
+
+	env := fp.Envelope(Meta{AgentVersion: "v0.9.0"})
+	require.Equal(t, []string{"hostname", "machine_id"}, env.Sources)
+	require.WithinDuration(t, time.Now(), env.CreatedAt, time.Minute)
+	require.Equal(t, time.UTC, env.CreatedAt.Location())
+
+	fp, err = NewHMAC(ioutil.Discard, []byte("foobar"), []byte("s3cr3t"))
+	require.NoError(t, err)
+
+	env = fp.Envelope(Meta{})
+	require.Equal(t, "hmac-sha256", env.Algo)
+	require.Equal(t, keyID([]byte("s3cr3t")), env.KeyID)
+	require.Equal(t, []string{}, env.Sources)
+	require.Equal(t, fp.Encoded(), env.encoded())
+}
+
+func TestValidate_Golden(t *testing.T) {
+	tests := []struct {
+		name        string
+		fixture     string
+		val         string
+		expMismatch bool
+		expPrevious *Envelope
+		expErr      error
+	}{
+		{name: "plain", fixture: "plain_fingerprint", val: "foobar"},
+		{name: "plain mismatch", fixture: "plain_fingerprint", val: "other", expMismatch: true},
+		{name: "envelope", fixture: "envelope.json", val: "foobar"},
+		{
+			name:        "envelope mismatch",
+			fixture:     "envelope.json",
+			val:         "other",
+			expMismatch: true,
+			expPrevious: &Envelope{
+				Hash:         "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2",
+				Algo:         "sha256",
+				CreatedAt:    goldenMeta.CreatedAt,
+				AgentVersion: goldenMeta.AgentVersion,
+				Sources:      goldenMeta.Sources,
+			},
+		},
+		{name: "corrupted envelope", fixture: "corrupted_envelope.json", val: "foobar", expErr: ErrInvalidEnvelope},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev, err := os.Open(filepath.Join("fixtures", tt.fixture))
+			require.NoError(t, err)
+			defer prev.Close()
+
+			_, err = NewWithValidation([]byte(tt.val), ioutil.Discard, prev)
+
+			switch {
+			case tt.expErr != nil:
+				var verr *ValidationError
+				require.ErrorAs(t, err, &verr)
+				require.Equal(t, ReasonMalformedPrevious, verr.Reason)
+				require.ErrorIs(t, err, tt.expErr)
+				require.NotErrorIs(t, err, ErrHashMismatch)
+			case tt.expMismatch:
+				var verr *ValidationError
+				require.ErrorAs(t, err, &verr)
+				require.ErrorIs(t, err, ErrValueMismatch)
+				require.ErrorIs(t, err, ErrHashMismatch)
+				require.Equal(t, "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2", verr.Expected)
+				require.Equal(t, tt.expPrevious, verr.Previous)
+				if tt.expPrevious != nil {
+					require.Contains(t, err.Error(), "created at 2022-06-01T10:00:00Z by agent v0.9.0 from sources [hostname,machine_id]")
+				}
+			default:
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNewFromFile_Envelope(t *testing.T) {
+	golden, err := os.ReadFile("fixtures/envelope.json")
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "host_fingerprint")
+	require.NoError(t, ioutil.WriteFile(path, golden, 0o644))
+
+	fp, err := NewFromFile([]byte("foobar"), path)
+	require.NoError(t, err)
+	require.Equal(t, "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2", fp.Hash())
+
+	// the envelope is kept
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, golden, content)
+
+	_, err = NewFromFile([]byte("other"), path)
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	require.Equal(t, "v0.9.0", verr.Previous.AgentVersion)
+}
@@ -0,0 +1,120 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprint
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"strings"
+
+	"agent/internal/pkg/fsutil"
+)
+
+// DirNotWritableError the directory of a fingerprint file can't be written
+// to, the fingerprint can't be persisted.
+type DirNotWritableError struct {
+	Dir string
+	err error
+}
+
+func (d *DirNotWritableError) Error() string {
+	return fmt.Sprintf("fingerprint directory %s is not writable: %v", d.Dir, d.err)
+}
+
+func (d *DirNotWritableError) Unwrap() error {
+	return d.err
+}
+
+// fileWriter writes the fingerprint to a file atomically, a crash leaves
+// either the previous or the new fingerprint.
+type fileWriter struct {
+	path string
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	if err := writeFileAtomic(w.path, p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// NewFromFile creates a new fingerprint, validates it against the previous
+// one stored at path, if any, and persists it there. A previous fingerprint
+// truncated by an interrupted write, holding at least half of the digest,
+// is replaced rather than failing the validation. The returned fingerprint writes to path.
+func NewFromFile(val []byte, path string) (Fingerprint, error) {
+	prev, err := ioutil.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return zerofp, err
+	}
+	prev = bytes.TrimSpace(prev)
+
+	newfp, err := New(&fileWriter{path: path}, val)
+	if err != nil {
+		return zerofp, err
+	}
+
+	if string(prev) == newfp.Encoded() {
+		return newfp, nil
+	}
+
+	// an envelope is kept along with its metadata
+	if isEnvelope(prev) {
+		if err := validate(newfp, bytes.NewReader(prev)); err != nil {
+			return zerofp, err
+		}
+
+		return newfp, nil
+	}
+
+	// a legacy fingerprint is rewritten in the prefixed format
+	if !truncated(string(prev), newfp.Encoded(), newfp.hash) && !truncated(string(prev), newfp.hash, newfp.hash) {
+		if err := validate(newfp, bytes.NewReader(prev)); err != nil {
+			return zerofp, err
+		}
+	}
+
+	if err := newfp.Write(); err != nil {
+		return zerofp, err
+	}
+
+	return newfp, nil
+}
+
+// truncated whether prev is the beginning of stored, the stored form of a
+// fingerprint whose hex digest is hash, left by an interrupted write of the
+// same fingerprint. prev must hold at least half of the digest, shorter
+// values are validated, and rejected, as malformed.
+func truncated(prev, stored, hash string) bool {
+	min := len(stored) - len(hash)/2
+
+	return len(prev) >= min && len(prev) < len(stored) && strings.HasPrefix(stored, prev)
+}
+
+// writeFileAtomic writes content to path atomically, failing with a
+// DirNotWritableError if its directory can't be written to.
+func writeFileAtomic(path string, content []byte) error {
+	err := fsutil.WriteFileAtomic(path, content)
+
+	var dirErr *fsutil.DirError
+	if errors.As(err, &dirErr) {
+		return &DirNotWritableError{Dir: dirErr.Dir, err: dirErr.Err}
+	}
+
+	return err
+}
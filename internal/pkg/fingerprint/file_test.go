@@ -0,0 +1,119 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprint
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFromFile(t *testing.T) {
+	val := []byte("foobar")
+	expHash := fmt.Sprintf("%x", sha256.Sum256(val))
+	otherHash := fmt.Sprintf("%x", sha256.Sum256([]byte("other")))
+
+	tests := []struct {
+		name    string
+		prev    *string
+		kept    bool
+		wantErr bool
+	}{
+		{name: "missing file"},
+		{name: "empty file", prev: strPtr("")},
+		{name: "matching", prev: strPtr(expHash)},
+		{name: "matching with line break", prev: strPtr(expHash + "\n")},
+		{name: "partial previous", prev: strPtr(expHash[:32])},
+		{name: "partial prefixed previous", prev: strPtr("sha256:" + expHash[:32])},
+		{name: "short partial previous", prev: strPtr(expHash[:31]), wantErr: true},
+		{name: "single character previous", prev: strPtr(expHash[:1]), wantErr: true},
+		{name: "algorithm only previous", prev: strPtr("sha256:"), wantErr: true},
+		{name: "prefixed", prev: strPtr("sha256:" + expHash), kept: true},
+		{name: "prefixed with line break", prev: strPtr("sha256:" + expHash + "\n"), kept: true},
+		{name: "mismatching", prev: strPtr(otherHash), wantErr: true},
+		{name: "partial mismatching", prev: strPtr(otherHash[:20]), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "host_fingerprint")
+			if tt.prev != nil {
+				require.NoError(t, ioutil.WriteFile(path, []byte(*tt.prev), 0o644))
+			}
+
+			fp, err := NewFromFile(val, path)
+			content, readErr := ioutil.ReadFile(path)
+
+			if tt.wantErr {
+				require.IsType(t, &ValidationError{}, err)
+				require.Equal(t, "", fp.Hash())
+
+				// the previous fingerprint is kept
+				require.NoError(t, readErr)
+				require.Equal(t, *tt.prev, string(content))
+
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, expHash, fp.Hash())
+			require.NoError(t, readErr)
+			if tt.kept {
+				// a prefixed fingerprint is not rewritten
+				require.Equal(t, *tt.prev, string(content))
+			} else {
+				// legacy fingerprints are upgraded to the prefixed format
+				require.Equal(t, "sha256:"+expHash, string(content))
+			}
+
+			// no temporary file left behind
+			entries, err := os.ReadDir(filepath.Dir(path))
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+		})
+	}
+}
+
+func TestNewFromFile_Write(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "host_fingerprint")
+
+	fp, err := NewFromFile([]byte("foobar"), path)
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("garbage"), 0o644))
+	require.NoError(t, fp.Write())
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, fp.Encoded(), string(content))
+}
+
+func TestNewFromFile_DirNotWritable(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+
+	_, err := NewFromFile([]byte("foobar"), filepath.Join(dir, "host_fingerprint"))
+
+	var dirErr *DirNotWritableError
+	require.ErrorAs(t, err, &dirErr)
+	require.Equal(t, dir, dirErr.Dir)
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -14,25 +14,168 @@
 package fingerprint
 
 import (
+	"bytes"
+	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
 )
 
 var zerofp = Fingerprint{}
 
+var (
+	// ErrUnknownAlgorithm the hash algorithm is not supported.
+	ErrUnknownAlgorithm = errors.New("unknown fingerprint hash algorithm")
+
+	// ErrEmptyKey the HMAC key of a fingerprint is empty.
+	ErrEmptyKey = errors.New("empty fingerprint key")
+
+	// ErrValueMismatch the fingerprint was computed from another value.
+	ErrValueMismatch = errors.New("hash mismatch detected")
+
+	// ErrKeyMismatch the fingerprint was computed with another HMAC key, or
+	// with none: the value may be unchanged.
+	ErrKeyMismatch = errors.New("hmac key mismatch detected")
+
+	// ErrMalformedPrevious the previous fingerprint is not the hex encoded
+	// hash of a known algorithm, matched by ValidationError if it is
+	// malformed.
+	ErrMalformedPrevious = errors.New("malformed previous fingerprint")
+)
+
+// Algorithm the hash algorithm of a fingerprint.
+type Algorithm string
+
+const (
+	// SHA256 the default algorithm, the only one of the fingerprints
+	// stored without an algorithm prefix.
+	SHA256 Algorithm = "sha256"
+
+	// SHA512 the SHA-512 algorithm.
+	SHA512 Algorithm = "sha512"
+
+	// BLAKE2b the BLAKE2b-512 algorithm.
+	BLAKE2b Algorithm = "blake2b"
+)
+
+// hmacPrefix prefixes the algorithm of keyed fingerprints.
+const hmacPrefix = "hmac-"
+
+// keyIDLen length of the key ID stored along keyed fingerprints, in bytes.
+const keyIDLen = 8
+
+func (a Algorithm) new() (hash.Hash, error) {
+	switch a {
+	case SHA256:
+		return sha256.New(), nil
+	case SHA512:
+		return sha512.New(), nil
+	case BLAKE2b:
+		return blake2b.New512(nil)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, a)
+	}
+}
+
+// Options of a fingerprint.
+type Options struct {
+	// Algorithm defaults to SHA256.
+	Algorithm Algorithm
+
+	// Key makes the fingerprint an HMAC of the value, which can't be
+	// computed without the key.
+	Key []byte
+
+	// AllowRotation accepts a fingerprint that doesn't match the previous
+	// one on validation, the previous hash is then returned by Previous.
+	AllowRotation bool
+}
+
+// ErrHashMismatch the fingerprint doesn't match the previous one, matched by
+// ValidationError if it is a mismatch.
+var ErrHashMismatch = errors.New("fingerprint hash mismatch")
+
+// Reason why a fingerprint failed validation.
+type Reason int
+
+const (
+	// ReasonMismatch the fingerprint doesn't match the previous one.
+	ReasonMismatch Reason = iota
+
+	// ReasonUnreadablePrevious the previous fingerprint could not be read.
+	ReasonUnreadablePrevious
+
+	// ReasonMalformedPrevious the previous fingerprint could not be parsed.
+	ReasonMalformedPrevious
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonMismatch:
+		return "mismatch"
+	case ReasonUnreadablePrevious:
+		return "unreadable-previous"
+	case ReasonMalformedPrevious:
+		return "malformed-previous"
+	default:
+		return fmt.Sprintf("Reason(%d)", int(r))
+	}
+}
+
 // ValidationError fingerprint validation error
 type ValidationError struct {
 	err error
+
+	Reason Reason
+
+	// Expected the hash of the previous fingerprint, empty if it could not
+	// be read or parsed.
+	Expected string
+
+	// Got the hash of the new fingerprint.
+	Got string
+
+	// Previous the metadata of the previous fingerprint, if it was stored
+	// in an envelope.
+	Previous *Envelope
 }
 
 func (v *ValidationError) Error() string {
+	if v.Previous != nil {
+		return fmt.Sprintf("%v, previous fingerprint %s", v.err, v.Previous)
+	}
+
 	return fmt.Sprintf("%v", v.err)
 }
 
-func validationError(err error) *ValidationError {
-	return &ValidationError{err: err}
+func (v *ValidationError) Unwrap() error {
+	return v.err
+}
+
+// Is matches ErrHashMismatch if the validation failed on a mismatch,
+// ErrMalformedPrevious if the previous fingerprint is malformed.
+func (v *ValidationError) Is(target error) bool {
+	switch target {
+	case ErrHashMismatch:
+		return v.Reason == ReasonMismatch
+	case ErrMalformedPrevious:
+		return v.Reason == ReasonMalformedPrevious
+	default:
+		return false
+	}
+}
+
+func validationError(reason Reason, err error) *ValidationError {
+	return &ValidationError{err: err, Reason: reason}
 }
 
 type source interface {
@@ -40,10 +183,18 @@ type source interface {
 	bytes() ([]byte, error)
 }
 
-// Fingerprint computes a SHA256 hash and writes it to a configured writer.
+// Fingerprint computes a hash, SHA256 by default, and writes it to a
+// configured writer.
 type Fingerprint struct {
-	out  io.Writer
-	hash string
+	out     io.Writer
+	alg     Algorithm
+	val     []byte
+	key     []byte
+	keyID   string
+	hash    string
+	sources []string
+
+	previous string
 }
 
 // Hash returns the fingerprint value.
@@ -51,16 +202,179 @@ func (f Fingerprint) Hash() string {
 	return f.hash
 }
 
-// Write writes the computed hash to a writer.
+// Previous returns the hash of the previous fingerprint, if the
+// fingerprint replaced it on validation with AllowRotation.
+func (f Fingerprint) Previous() (string, bool) {
+	return f.previous, f.previous != ""
+}
+
+// Algorithm returns the hash algorithm of the fingerprint.
+func (f Fingerprint) Algorithm() Algorithm {
+	return f.alg
+}
+
+// Encoded returns the fingerprint value as stored, prefixed with its
+// algorithm, i.e. sha256:<hex>. Keyed fingerprints also carry the ID of
+// their key, i.e. hmac-sha256:<key ID>:<hex>.
+func (f Fingerprint) Encoded() string {
+	if f.key != nil {
+		return hmacPrefix + string(f.alg) + ":" + f.keyID + ":" + f.hash
+	}
+
+	return string(f.alg) + ":" + f.hash
+}
+
+// Equal whether other is the same fingerprint, computed with the same
+// algorithm and key from the same value.
+func (f Fingerprint) Equal(other Fingerprint) bool {
+	return f.alg == other.alg && f.keyID == other.keyID && constantTimeEqual(f.hash, other.hash)
+}
+
+// Verify validates the fingerprint against the previous one, in the
+// prefixed or the legacy bare hex format, ignoring surrounding whitespace
+// and the case of the hex. Returns a ValidationError if they don't match or
+// the previous one is malformed, nil if there is no previous fingerprint.
+func (f Fingerprint) Verify(prevHash string) error {
+	prevHash = strings.TrimSpace(prevHash)
+	if prevHash == "" {
+		return nil
+	}
+
+	prev, err := parseStored(prevHash)
+	if err != nil {
+		verr := validationError(ReasonMalformedPrevious, fmt.Errorf("%w: %v", ErrMalformedPrevious, err))
+		verr.Got = f.hash
+
+		return verr
+	}
+
+	if err := f.compare(prev); err != nil {
+		verr := validationError(ReasonMismatch, fmt.Errorf("%w, expected %s, got %s", err, prevHash, f.Encoded()))
+		verr.Expected = prev.hash
+		verr.Got = f.hash
+
+		return verr
+	}
+
+	return nil
+}
+
+// Matches whether stored, in the prefixed or the legacy bare hex format, is
+// the same fingerprint. A fingerprint stored with another algorithm is
+// compared against the value hashed with that algorithm.
+func (f Fingerprint) Matches(stored string) bool {
+	prev, err := parseStored(strings.TrimSpace(stored))
+
+	return err == nil && f.compare(prev) == nil
+}
+
+// compare returns ErrKeyMismatch if prev was computed with another key,
+// ErrValueMismatch if it was computed from another value. An unkeyed
+// previous fingerprint is compared against the unkeyed hash of the value.
+func (f Fingerprint) compare(prev storedFingerprint) error {
+	var key []byte
+	if prev.keyed {
+		if f.key == nil {
+			return fmt.Errorf("%w: previous fingerprint is keyed", ErrKeyMismatch)
+		}
+		if prev.keyID != f.keyID {
+			return fmt.Errorf("%w: previous key %s, current key %s", ErrKeyMismatch, prev.keyID, f.keyID)
+		}
+		key = f.key
+	}
+
+	hash := f.hash
+	if prev.alg != f.alg || prev.keyed != (f.key != nil) {
+		var err error
+		if hash, err = digest(prev.alg, key, f.val); err != nil {
+			return err
+		}
+	}
+
+	if !constantTimeEqual(prev.hash, hash) {
+		return ErrValueMismatch
+	}
+
+	return nil
+}
+
+// Write writes the encoded hash to a writer.
 func (f Fingerprint) Write() error {
-	n, err := f.out.Write([]byte(f.hash))
+	encoded := f.Encoded()
+
+	n, err := f.out.Write([]byte(encoded))
 	if err != nil {
 		return err
 	}
 
-	if n != len(f.hash) {
+	if n != len(encoded) {
 		err := fmt.Errorf("unexpected number of bytes written: %d/%d",
-			n, len(f.hash))
+			n, len(encoded))
+		return err
+	}
+
+	return nil
+}
+
+// storedFingerprint a fingerprint as read back.
+type storedFingerprint struct {
+	alg   Algorithm
+	keyed bool
+	keyID string
+	hash  string
+}
+
+// parse splits a stored fingerprint into its algorithm, key ID and hash,
+// legacy fingerprints without prefix are SHA256.
+func parse(stored string) storedFingerprint {
+	parts := strings.SplitN(stored, ":", 3)
+	switch {
+	case len(parts) == 3 && strings.HasPrefix(parts[0], hmacPrefix):
+		return storedFingerprint{
+			alg:   Algorithm(strings.TrimPrefix(parts[0], hmacPrefix)),
+			keyed: true,
+			keyID: parts[1],
+			hash:  parts[2],
+		}
+	case len(parts) >= 2:
+		return storedFingerprint{alg: Algorithm(parts[0]), hash: strings.Join(parts[1:], ":")}
+	default:
+		return storedFingerprint{alg: SHA256, hash: stored}
+	}
+}
+
+// parseStored parses a stored fingerprint, checking it is the hex encoded
+// hash of a known algorithm.
+func parseStored(stored string) (storedFingerprint, error) {
+	prev := parse(stored)
+	prev.hash = strings.ToLower(prev.hash)
+	prev.keyID = strings.ToLower(prev.keyID)
+
+	h, err := prev.alg.new()
+	if err != nil {
+		return prev, err
+	}
+
+	if err := checkHex(prev.hash, h.Size()); err != nil {
+		return prev, fmt.Errorf("%s hash: %w", prev.alg, err)
+	}
+
+	if prev.keyed {
+		if err := checkHex(prev.keyID, keyIDLen); err != nil {
+			return prev, fmt.Errorf("key ID: %w", err)
+		}
+	}
+
+	return prev, nil
+}
+
+// checkHex checks s is the hex encoding of size bytes.
+func checkHex(s string, size int) error {
+	if len(s) != 2*size {
+		return fmt.Errorf("expected %d hex characters, got %d", 2*size, len(s))
+	}
+
+	if _, err := hex.DecodeString(s); err != nil {
 		return err
 	}
 
@@ -68,58 +382,165 @@ func (f Fingerprint) Write() error {
 }
 
 func validate(newfp Fingerprint, prev io.Reader) error {
-	prevHashBytes, err := ioutil.ReadAll(prev)
+	stored, err := readPrevious(prev)
 	if err != nil {
 		return err
 	}
 
-	prevHash := string(prevHashBytes)
-	if len(prevHash) > 1 && prevHash != newfp.hash {
-		err := fmt.Errorf("hash mismatch detected, expected %s, got %s",
-			prevHash, newfp.hash)
+	return validateStored(newfp, stored)
+}
+
+// readPrevious reads the previous fingerprint, failing with a
+// ValidationError.
+func readPrevious(prev io.Reader) ([]byte, error) {
+	stored, err := ioutil.ReadAll(prev)
+	if err != nil {
+		return nil, validationError(ReasonUnreadablePrevious,
+			fmt.Errorf("error reading previous fingerprint: %w", err))
+	}
+
+	return stored, nil
+}
 
-		return validationError(err)
+func validateStored(newfp Fingerprint, stored []byte) error {
+	stored = bytes.TrimSpace(stored)
+	if len(stored) == 0 {
+		return nil
 	}
 
-	return nil
+	var env *Envelope
+	if isEnvelope(stored) {
+		var err error
+		if env, err = parseEnvelope(stored); err != nil {
+			return validationError(ReasonMalformedPrevious, err)
+		}
+		stored = []byte(env.encoded())
+	}
+
+	err := newfp.Verify(string(stored))
+	if verr, ok := err.(*ValidationError); ok {
+		verr.Previous = env
+	}
+
+	return err
 }
 
 // NewWithValidation creates a new fingerprint with writer next
 // and validates it against previous fingerprint located under p.
 func NewWithValidation(val []byte, out io.Writer, prev io.Reader) (Fingerprint, error) {
-	newfp, err := New(out, val)
+	return NewWithValidationOptions(val, out, prev, Options{})
+}
+
+// NewWithValidationOptions is NewWithValidation with options, i.e. the
+// HMAC key of a keyed fingerprint.
+func NewWithValidationOptions(val []byte, out io.Writer, prev io.Reader, opts Options) (Fingerprint, error) {
+	newfp, err := NewWithOptions(out, val, opts)
 	if err != nil {
 		return zerofp, err
 	}
 
-	if err := validate(newfp, prev); err != nil {
+	stored, err := readPrevious(prev)
+	if err != nil {
 		return zerofp, err
 	}
 
+	if err := validateStored(newfp, stored); err != nil {
+		if !opts.AllowRotation || !errors.Is(err, ErrHashMismatch) {
+			return zerofp, err
+		}
+
+		newfp.previous = storedHash(stored)
+	}
+
 	return newfp, nil
 }
 
 // New returns a Fingerprint that is initialized by computing a
 // SHA256 hash from a list of default sources.
 func New(out io.Writer, val []byte) (Fingerprint, error) {
-	h := sha256.New()
+	return NewWithOptions(out, val, Options{})
+}
 
-	n, err := h.Write(val)
+// NewHMAC returns a Fingerprint that is initialized by computing an
+// HMAC-SHA256 of val with key.
+func NewHMAC(out io.Writer, val, key []byte) (Fingerprint, error) {
+	if len(key) == 0 {
+		return zerofp, ErrEmptyKey
+	}
+
+	return NewWithOptions(out, val, Options{Key: key})
+}
+
+// NewWithOptions returns a Fingerprint that is initialized by computing a
+// hash of val with the algorithm of opts, an HMAC if opts has a key.
+func NewWithOptions(out io.Writer, val []byte, opts Options) (Fingerprint, error) {
+	alg := opts.Algorithm
+	if alg == "" {
+		alg = SHA256
+	}
+
+	if opts.Key != nil && len(opts.Key) == 0 {
+		return zerofp, ErrEmptyKey
+	}
+
+	hstr, err := digest(alg, opts.Key, val)
 	if err != nil {
 		return zerofp, err
 	}
 
+	fp := Fingerprint{out: out, alg: alg, val: val, hash: hstr}
+	if opts.Key != nil {
+		fp.key = opts.Key
+		fp.keyID = keyID(opts.Key)
+	}
+
+	return fp, nil
+}
+
+// keyID identifies a key without disclosing it, to tell a key rotation
+// from a value change.
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+
+	return fmt.Sprintf("%x", sum[:keyIDLen])
+}
+
+// constantTimeEqual compares hashes in a time independent of their
+// content.
+func constantTimeEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// digest returns the hex encoded hash of val, its HMAC if key is not nil.
+func digest(alg Algorithm, key, val []byte) (string, error) {
+	h, err := alg.new()
+	if err != nil {
+		return "", err
+	}
+
+	if key != nil {
+		h = hmac.New(func() hash.Hash {
+			h, _ := alg.new()
+			return h
+		}, key)
+	}
+
+	n, err := h.Write(val)
+	if err != nil {
+		return "", err
+	}
+
 	if n != len(val) {
 		err := fmt.Errorf("unexpected number of bytes written: %d/%d", n, len(val))
-		return zerofp, err
+		return "", err
 	}
 
 	hstr := fmt.Sprintf("%x", h.Sum(nil))
-	if len(hstr) < sha256.BlockSize {
+	if len(hstr) != 2*h.Size() {
 		err := fmt.Errorf("unexpected hash length, expected %d, got %d",
-			sha256.BlockSize, len(hstr))
-		return zerofp, err
+			2*h.Size(), len(hstr))
+		return "", err
 	}
 
-	return Fingerprint{out, hstr}, nil
+	return hstr, nil
 }
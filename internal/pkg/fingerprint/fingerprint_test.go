@@ -14,13 +14,19 @@
 package fingerprint
 
 import (
+	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/sha512"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
 )
 
 func TestNew(t *testing.T) {
@@ -59,7 +65,7 @@ func TestNew_Write(t *testing.T) {
 	require.Nil(t, err)
 
 	gotHashOut := string(gotHashOutBytes)
-	require.Equal(t, expHash, gotHashOut)
+	require.Equal(t, "sha256:"+expHash, gotHashOut)
 }
 
 func TestNewWithValidation_Bootstrap(t *testing.T) {
@@ -136,5 +142,363 @@ func TestNewWithValidation_Regression(t *testing.T) {
 	errfpv, err := NewWithValidation([]byte(""), ioutil.Discard, fpr)
 	require.NotNil(t, err)
 
+	// not a hash, rather than a different one
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	require.ErrorIs(t, err, ErrMalformedPrevious)
+	require.NotErrorIs(t, err, ErrHashMismatch)
+	require.Equal(t, ReasonMalformedPrevious, verr.Reason)
+	require.Equal(t, "", verr.Expected)
+	require.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte(""))), verr.Got)
+
 	require.Equal(t, "", errfpv.Hash())
 }
+
+func TestValidationError(t *testing.T) {
+	val := []byte("foobar")
+	other := fmt.Sprintf("%x", sha256.Sum256([]byte("other")))
+
+	fp, err := New(ioutil.Discard, val)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		prev        io.Reader
+		expReason   Reason
+		expExpected string
+		expCause    error
+	}{
+		{
+			name:        "mismatch",
+			prev:        strings.NewReader("sha256:" + other),
+			expReason:   ReasonMismatch,
+			expExpected: other,
+			expCause:    ErrValueMismatch,
+		},
+		{
+			name:      "unreadable previous",
+			prev:      iotest.ErrReader(io.ErrUnexpectedEOF),
+			expReason: ReasonUnreadablePrevious,
+			expCause:  io.ErrUnexpectedEOF,
+		},
+		{
+			name:      "malformed previous",
+			prev:      strings.NewReader(`{"hash": `),
+			expReason: ReasonMalformedPrevious,
+			expCause:  ErrInvalidEnvelope,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewWithValidation(val, ioutil.Discard, tt.prev)
+
+			var verr *ValidationError
+			require.ErrorAs(t, err, &verr)
+			require.Equal(t, tt.expReason, verr.Reason)
+			require.Equal(t, tt.expExpected, verr.Expected)
+			require.ErrorIs(t, err, tt.expCause)
+
+			if tt.expReason == ReasonMismatch {
+				require.ErrorIs(t, err, ErrHashMismatch)
+				require.Equal(t, fp.Hash(), verr.Got)
+			} else {
+				require.NotErrorIs(t, err, ErrHashMismatch)
+			}
+		})
+	}
+}
+
+func TestReason_String(t *testing.T) {
+	require.Equal(t, "mismatch", ReasonMismatch.String())
+	require.Equal(t, "unreadable-previous", ReasonUnreadablePrevious.String())
+	require.Equal(t, "malformed-previous", ReasonMalformedPrevious.String())
+}
+
+func TestNewWithOptions(t *testing.T) {
+	val := []byte("foobar")
+
+	tests := []struct {
+		name    string
+		opts    Options
+		expAlg  Algorithm
+		expHash string
+	}{
+		{name: "default", expAlg: SHA256, expHash: fmt.Sprintf("%x", sha256.Sum256(val))},
+		{name: "sha256", opts: Options{Algorithm: SHA256}, expAlg: SHA256, expHash: fmt.Sprintf("%x", sha256.Sum256(val))},
+		{name: "sha512", opts: Options{Algorithm: SHA512}, expAlg: SHA512, expHash: fmt.Sprintf("%x", sha512.Sum512(val))},
+		{name: "blake2b", opts: Options{Algorithm: BLAKE2b}, expAlg: BLAKE2b, expHash: fmt.Sprintf("%x", blake2b.Sum512(val))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := new(strings.Builder)
+			fp, err := NewWithOptions(out, val, tt.opts)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expAlg, fp.Algorithm())
+			require.Equal(t, tt.expHash, fp.Hash())
+			require.Equal(t, string(tt.expAlg)+":"+tt.expHash, fp.Encoded())
+
+			require.NoError(t, fp.Write())
+			require.Equal(t, fp.Encoded(), out.String())
+		})
+	}
+}
+
+func TestNewWithOptions_UnknownAlgorithm(t *testing.T) {
+	fp, err := NewWithOptions(ioutil.Discard, []byte("foobar"), Options{Algorithm: "md5"})
+	require.ErrorIs(t, err, ErrUnknownAlgorithm)
+	require.Equal(t, "", fp.Hash())
+}
+
+func TestValidate_Formats(t *testing.T) {
+	val := []byte("foobar")
+	legacy := fmt.Sprintf("%x", sha256.Sum256(val))
+	other := fmt.Sprintf("%x", sha256.Sum256([]byte("other")))
+
+	tests := []struct {
+		name      string
+		alg       Algorithm
+		prev      string
+		wantErr   bool
+		expReason Reason
+	}{
+		{name: "legacy format", alg: SHA256, prev: legacy},
+		{name: "legacy format mismatch", alg: SHA256, prev: other, wantErr: true},
+		{name: "prefixed format", alg: SHA256, prev: "sha256:" + legacy},
+		{name: "prefixed format mismatch", alg: SHA256, prev: "sha256:" + other, wantErr: true},
+		{name: "legacy format, new algorithm", alg: BLAKE2b, prev: legacy},
+		{name: "legacy format mismatch, new algorithm", alg: BLAKE2b, prev: other, wantErr: true},
+		{name: "previous algorithm", alg: SHA256, prev: fmt.Sprintf("sha512:%x", sha512.Sum512(val))},
+		{name: "unknown previous algorithm", alg: SHA256, prev: "md5:3858f62230ac3c915f300c664312c63f", wantErr: true, expReason: ReasonMalformedPrevious},
+		{name: "empty", alg: SHA512},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fp, err := NewWithOptions(ioutil.Discard, val, Options{Algorithm: tt.alg})
+			require.NoError(t, err)
+
+			err = validate(fp, strings.NewReader(tt.prev))
+			if tt.wantErr {
+				var verr *ValidationError
+				require.ErrorAs(t, err, &verr)
+				require.Equal(t, tt.expReason, verr.Reason)
+				require.Equal(t, fp.Hash(), verr.Got)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestNewHMAC(t *testing.T) {
+	val := []byte("foobar")
+	key := []byte("s3cr3t")
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(val)
+	expHash := fmt.Sprintf("%x", mac.Sum(nil))
+
+	fp, err := NewHMAC(ioutil.Discard, val, key)
+	require.NoError(t, err)
+	require.Equal(t, expHash, fp.Hash())
+	require.Equal(t, SHA256, fp.Algorithm())
+	require.Equal(t, "hmac-sha256:"+keyID(key)+":"+expHash, fp.Encoded())
+	require.Len(t, keyID(key), 2*keyIDLen)
+
+	plain, err := New(ioutil.Discard, val)
+	require.NoError(t, err)
+	require.NotEqual(t, plain.Hash(), fp.Hash())
+
+	_, err = NewHMAC(ioutil.Discard, val, nil)
+	require.ErrorIs(t, err, ErrEmptyKey)
+	_, err = NewWithOptions(ioutil.Discard, val, Options{Key: []byte{}})
+	require.ErrorIs(t, err, ErrEmptyKey)
+}
+
+func TestNewWithValidationOptions_HMAC(t *testing.T) {
+	val := []byte("foobar")
+	key := []byte("s3cr3t")
+	rotated := []byte("n3w-s3cr3t")
+
+	encoded := func(val, key []byte) string {
+		fp, err := NewWithOptions(ioutil.Discard, val, Options{Key: key})
+		require.NoError(t, err)
+		return fp.Encoded()
+	}
+
+	tests := []struct {
+		name   string
+		prev   string
+		key    []byte
+		expErr error
+	}{
+		{name: "same key", prev: encoded(val, key), key: key},
+		{name: "key rotation", prev: encoded(val, key), key: rotated, expErr: ErrKeyMismatch},
+		{name: "value change", prev: encoded([]byte("other"), key), key: key, expErr: ErrValueMismatch},
+		{name: "key removed", prev: encoded(val, key), expErr: ErrKeyMismatch},
+		{name: "key added to unkeyed", prev: encoded(val, nil), key: key},
+		{name: "key added to legacy", prev: fmt.Sprintf("%x", sha256.Sum256(val)), key: key},
+		{name: "key added, value change", prev: encoded([]byte("other"), nil), key: key, expErr: ErrValueMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fp, err := NewWithValidationOptions(val, ioutil.Discard, strings.NewReader(tt.prev), Options{Key: tt.key})
+			if tt.expErr == nil {
+				require.NoError(t, err)
+				return
+			}
+
+			require.IsType(t, &ValidationError{}, err)
+			require.ErrorIs(t, err, tt.expErr)
+			require.Equal(t, "", fp.Hash())
+
+			// a key rotation is not reported as a value change and vice versa
+			for _, other := range []error{ErrKeyMismatch, ErrValueMismatch} {
+				if other != tt.expErr {
+					require.NotErrorIs(t, err, other)
+				}
+			}
+		})
+	}
+}
+
+func TestFingerprint_Equal(t *testing.T) {
+	fp, err := New(ioutil.Discard, []byte("foobar"))
+	require.NoError(t, err)
+
+	same, err := New(ioutil.Discard, []byte("foobar"))
+	require.NoError(t, err)
+	other, err := New(ioutil.Discard, []byte("other"))
+	require.NoError(t, err)
+	sha512fp, err := NewWithOptions(ioutil.Discard, []byte("foobar"), Options{Algorithm: SHA512})
+	require.NoError(t, err)
+	keyed, err := NewHMAC(ioutil.Discard, []byte("foobar"), []byte("s3cr3t"))
+	require.NoError(t, err)
+	rotated, err := NewHMAC(ioutil.Discard, []byte("foobar"), []byte("n3w-s3cr3t"))
+	require.NoError(t, err)
+
+	require.True(t, fp.Equal(same))
+	require.True(t, keyed.Equal(keyed))
+	require.False(t, fp.Equal(other))
+	require.False(t, fp.Equal(sha512fp))
+	require.False(t, fp.Equal(keyed))
+	require.False(t, keyed.Equal(rotated))
+	require.False(t, fp.Equal(zerofp))
+	require.True(t, zerofp.Equal(Fingerprint{}))
+}
+
+func TestFingerprint_Verify(t *testing.T) {
+	fp, err := New(ioutil.Discard, []byte("foobar"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		prevHash    string
+		expReason   Reason
+		expExpected string
+		wantErr     bool
+	}{
+		{name: "empty"},
+		{name: "equal", prevHash: fp.Hash()},
+		{name: "equal prefixed", prevHash: fp.Encoded()},
+		{
+			name:      "different length",
+			prevHash:  fp.Hash()[:20],
+			wantErr:   true,
+			expReason: ReasonMalformedPrevious,
+		},
+		{
+			name:        "different value",
+			prevHash:    strings.Repeat("0", len(fp.Hash())),
+			wantErr:     true,
+			expReason:   ReasonMismatch,
+			expExpected: strings.Repeat("0", len(fp.Hash())),
+		},
+		{
+			name:      "unknown algorithm",
+			prevHash:  "md5:3858f62230ac3c915f300c664312c63f",
+			wantErr:   true,
+			expReason: ReasonMalformedPrevious,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := fp.Verify(tt.prevHash)
+			if !tt.wantErr {
+				require.NoError(t, err)
+				return
+			}
+
+			var verr *ValidationError
+			require.ErrorAs(t, err, &verr)
+			require.Equal(t, tt.expReason, verr.Reason)
+			require.Equal(t, tt.expExpected, verr.Expected)
+			require.Equal(t, fp.Hash(), verr.Got)
+			require.Nil(t, verr.Previous)
+		})
+	}
+}
+
+func TestValidate_TolerantParsing(t *testing.T) {
+	val := []byte("foobar")
+	hash := fmt.Sprintf("%x", sha256.Sum256(val))
+	other := fmt.Sprintf("%x", sha256.Sum256([]byte("other")))
+
+	keyed, err := NewHMAC(ioutil.Discard, val, []byte("s3cr3t"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		prev      string
+		key       []byte
+		wantErr   bool
+		expReason Reason
+	}{
+		{name: "empty"},
+		{name: "whitespace only", prev: " \n\t\n"},
+		{name: "single line break", prev: "\n"},
+		{name: "newline suffixed", prev: hash + "\n"},
+		{name: "crlf suffixed", prev: hash + "\r\n"},
+		{name: "surrounding whitespace", prev: "  sha256:" + hash + " \n"},
+		{name: "uppercase", prev: strings.ToUpper(hash)},
+		{name: "uppercase prefixed", prev: "sha256:" + strings.ToUpper(hash) + "\n"},
+		{name: "uppercase keyed", prev: strings.ToUpper(keyed.Encoded()), key: []byte("s3cr3t"), wantErr: true, expReason: ReasonMalformedPrevious},
+		{name: "uppercase key ID and hash", prev: "hmac-sha256:" + strings.ToUpper(keyed.keyID) + ":" + strings.ToUpper(keyed.Hash()), key: []byte("s3cr3t")},
+		{name: "newline suffixed mismatch", prev: other + "\n", wantErr: true, expReason: ReasonMismatch},
+		{name: "truncated", prev: hash[:40], wantErr: true, expReason: ReasonMalformedPrevious},
+		{name: "truncated prefixed", prev: "sha256:" + hash[:40], wantErr: true, expReason: ReasonMalformedPrevious},
+		{name: "too long", prev: hash + "00", wantErr: true, expReason: ReasonMalformedPrevious},
+		{name: "single character", prev: "a", wantErr: true, expReason: ReasonMalformedPrevious},
+		{name: "garbage", prev: "not a fingerprint", wantErr: true, expReason: ReasonMalformedPrevious},
+		{name: "non hex", prev: strings.Repeat("g", len(hash)), wantErr: true, expReason: ReasonMalformedPrevious},
+		{name: "malformed key ID", prev: "hmac-sha256:xyz:" + keyed.Hash(), key: []byte("s3cr3t"), wantErr: true, expReason: ReasonMalformedPrevious},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewWithValidationOptions(val, ioutil.Discard, strings.NewReader(tt.prev), Options{Key: tt.key})
+			if !tt.wantErr {
+				require.NoError(t, err)
+				return
+			}
+
+			var verr *ValidationError
+			require.ErrorAs(t, err, &verr)
+			require.Equal(t, tt.expReason, verr.Reason)
+
+			if tt.expReason == ReasonMalformedPrevious {
+				require.ErrorIs(t, err, ErrMalformedPrevious)
+				require.NotErrorIs(t, err, ErrHashMismatch)
+				require.Contains(t, err.Error(), "malformed previous fingerprint")
+			} else {
+				require.ErrorIs(t, err, ErrHashMismatch)
+				require.NotErrorIs(t, err, ErrMalformedPrevious)
+			}
+		})
+	}
+}
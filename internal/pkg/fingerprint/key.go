@@ -0,0 +1,65 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprint
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// KeyPermissionsError the key file can be accessed by other users than its
+// owner.
+type KeyPermissionsError struct {
+	Path string
+	Mode fs.FileMode
+}
+
+func (k *KeyPermissionsError) Error() string {
+	return fmt.Sprintf("fingerprint key file %s has permissions %#o, expected 0600", k.Path, k.Mode.Perm())
+}
+
+// ReadKeyFile reads the HMAC key of a fingerprint from path, which must
+// only be accessible to its owner. A trailing line break is not part of the
+// key.
+func ReadKeyFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Mode().Perm()&0o077 != 0 {
+		return nil, &KeyPermissionsError{Path: path, Mode: info.Mode()}
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(f); err != nil {
+		return nil, err
+	}
+
+	key := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	key = bytes.TrimSuffix(key, []byte("\r"))
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrEmptyKey, path)
+	}
+
+	return key, nil
+}
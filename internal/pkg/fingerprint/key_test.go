@@ -0,0 +1,73 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadKeyFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		perm    os.FileMode
+		expKey  string
+		expErr  error
+	}{
+		{name: "key", content: "s3cr3t", perm: 0o600, expKey: "s3cr3t"},
+		{name: "trailing line break", content: "s3cr3t\r\n", perm: 0o600, expKey: "s3cr3t"},
+		{name: "owner read only", content: "s3cr3t\n", perm: 0o400, expKey: "s3cr3t"},
+		{name: "empty", perm: 0o600, expErr: ErrEmptyKey},
+		{name: "line break only", content: "\n", perm: 0o600, expErr: ErrEmptyKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "fingerprint.key")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), tt.perm))
+
+			key, err := ReadKeyFile(path)
+			if tt.expErr != nil {
+				require.ErrorIs(t, err, tt.expErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expKey, string(key))
+		})
+	}
+}
+
+func TestReadKeyFile_Permissions(t *testing.T) {
+	for _, perm := range []os.FileMode{0o644, 0o640, 0o604, 0o660} {
+		path := filepath.Join(t.TempDir(), "fingerprint.key")
+		require.NoError(t, os.WriteFile(path, []byte("s3cr3t"), 0o600))
+		require.NoError(t, os.Chmod(path, perm))
+
+		_, err := ReadKeyFile(path)
+
+		var permErr *KeyPermissionsError
+		require.ErrorAs(t, err, &permErr)
+		require.Equal(t, perm, permErr.Mode.Perm())
+	}
+}
+
+func TestReadKeyFile_Missing(t *testing.T) {
+	_, err := ReadKeyFile(filepath.Join(t.TempDir(), "missing"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,96 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprint
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+)
+
+// PreviousSuffix suffix of the file RotateFile archives the previous
+// fingerprint to, next to the current one.
+const PreviousSuffix = ".previous"
+
+// Rotation a fingerprint replaced regardless of the previous one, i.e.
+// after intentionally cloning the host or replacing its hardware.
+type Rotation struct {
+	// Previous the hash of the previous fingerprint, empty if there was
+	// none.
+	Previous string
+
+	// Current the new fingerprint, already written.
+	Current Fingerprint
+}
+
+// Rotate computes a new fingerprint from val and writes it to out without
+// validating it against the previous one read from prev.
+func Rotate(val []byte, out io.Writer, prev io.Reader) (*Rotation, error) {
+	stored, err := ioutil.ReadAll(prev)
+	if err != nil {
+		return nil, err
+	}
+
+	return rotate(val, out, stored)
+}
+
+// RotateFile computes a new fingerprint from val and writes it to path
+// without validating it against the previous one stored there, which is
+// archived to path with the PreviousSuffix.
+func RotateFile(val []byte, path string) (*Rotation, error) {
+	stored, err := ioutil.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if len(bytes.TrimSpace(stored)) > 0 {
+		if err := writeFileAtomic(path+PreviousSuffix, stored); err != nil {
+			return nil, err
+		}
+	}
+
+	return rotate(val, &fileWriter{path: path}, stored)
+}
+
+func rotate(val []byte, out io.Writer, stored []byte) (*Rotation, error) {
+	fp, err := New(out, val)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fp.Write(); err != nil {
+		return nil, err
+	}
+
+	return &Rotation{Previous: storedHash(stored), Current: fp}, nil
+}
+
+// storedHash returns the hash of a stored fingerprint, in any format, empty
+// if it can't be parsed.
+func storedHash(stored []byte) string {
+	stored = bytes.TrimSpace(stored)
+
+	if isEnvelope(stored) {
+		env, err := parseEnvelope(stored)
+		if err != nil {
+			return ""
+		}
+
+		return env.Hash
+	}
+
+	return parse(string(stored)).hash
+}
@@ -0,0 +1,128 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprint
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "host_fingerprint")
+
+	old, err := NewFromFile([]byte("old-host"), path)
+	require.NoError(t, err)
+
+	// the new host doesn't validate against the cached fingerprint
+	_, err = NewFromFile([]byte("new-host"), path)
+	require.IsType(t, &ValidationError{}, err)
+
+	rotation, err := RotateFile([]byte("new-host"), path)
+	require.NoError(t, err)
+	require.Equal(t, old.Hash(), rotation.Previous)
+	require.NotEqual(t, old.Hash(), rotation.Current.Hash())
+
+	// the previous fingerprint is archived
+	archived, err := ioutil.ReadFile(path + PreviousSuffix)
+	require.NoError(t, err)
+	require.Equal(t, old.Encoded(), string(archived))
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, rotation.Current.Encoded(), string(content))
+
+	// the new fingerprint validates on subsequent runs
+	fp, err := NewFromFile([]byte("new-host"), path)
+	require.NoError(t, err)
+	require.Equal(t, rotation.Current.Hash(), fp.Hash())
+
+	_, err = NewFromFile([]byte("old-host"), path)
+	require.IsType(t, &ValidationError{}, err)
+}
+
+func TestRotateFile_NoPrevious(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "host_fingerprint")
+
+	rotation, err := RotateFile([]byte("new-host"), path)
+	require.NoError(t, err)
+	require.Equal(t, "", rotation.Previous)
+	require.NoFileExists(t, path+PreviousSuffix)
+
+	_, err = NewFromFile([]byte("new-host"), path)
+	require.NoError(t, err)
+}
+
+func TestRotate(t *testing.T) {
+	tests := []struct {
+		name    string
+		prev    string
+		expPrev string
+	}{
+		{name: "none"},
+		{name: "legacy", prev: "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2\n", expPrev: "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2"},
+		{name: "prefixed", prev: "sha256:c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2", expPrev: "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2"},
+		{name: "envelope", prev: readFixture(t, "envelope.json"), expPrev: "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := new(bytes.Buffer)
+			rotation, err := Rotate([]byte("new-host"), out, strings.NewReader(tt.prev))
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expPrev, rotation.Previous)
+			require.Equal(t, rotation.Current.Encoded(), out.String())
+		})
+	}
+}
+
+func TestNewWithValidationOptions_AllowRotation(t *testing.T) {
+	old, err := New(ioutil.Discard, []byte("old-host"))
+	require.NoError(t, err)
+
+	_, err = NewWithValidationOptions([]byte("new-host"), ioutil.Discard, strings.NewReader(old.Encoded()), Options{})
+	require.IsType(t, &ValidationError{}, err)
+
+	fp, err := NewWithValidationOptions([]byte("new-host"), ioutil.Discard, strings.NewReader(old.Encoded()), Options{AllowRotation: true})
+	require.NoError(t, err)
+	previous, rotated := fp.Previous()
+	require.True(t, rotated)
+	require.Equal(t, old.Hash(), previous)
+
+	// not rotated if the fingerprint matches
+	fp, err = NewWithValidationOptions([]byte("old-host"), ioutil.Discard, strings.NewReader(old.Encoded()), Options{AllowRotation: true})
+	require.NoError(t, err)
+	_, rotated = fp.Previous()
+	require.False(t, rotated)
+
+	// a previous fingerprint that can't be read is not rotated
+	_, err = NewWithValidationOptions([]byte("new-host"), ioutil.Discard,
+		strings.NewReader(readFixture(t, "corrupted_envelope.json")), Options{AllowRotation: true})
+	require.ErrorIs(t, err, ErrInvalidEnvelope)
+}
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join("fixtures", name))
+	require.NoError(t, err)
+
+	return string(b)
+}
@@ -0,0 +1,294 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprint
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrNoRequiredSource none of the sources of a fingerprint is required,
+	// it could be computed from nothing.
+	ErrNoRequiredSource = errors.New("no required fingerprint source")
+
+	// ErrDuplicateSource two sources of a fingerprint have the same name.
+	ErrDuplicateSource = errors.New("duplicate fingerprint source")
+
+	// ErrEmptySource a source has no value.
+	ErrEmptySource = errors.New("empty fingerprint source")
+)
+
+const (
+	defaultDMIProductUUIDPath = "/sys/class/dmi/id/product_uuid"
+	defaultRoutePath          = "/proc/net/route"
+)
+
+var defaultMachineIDPaths = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// optionalSource is implemented by sources that may fail, they are then
+// left out of the fingerprint.
+type optionalSource interface {
+	optional() bool
+}
+
+// MachineIDSource the systemd machine ID.
+type MachineIDSource struct {
+	// Paths tried in turn, defaults to /etc/machine-id then
+	// /var/lib/dbus/machine-id.
+	Paths []string
+
+	Optional bool
+}
+
+func (s MachineIDSource) name() string {
+	return "machine_id"
+}
+
+func (s MachineIDSource) bytes() ([]byte, error) {
+	paths := s.Paths
+	if len(paths) == 0 {
+		paths = defaultMachineIDPaths
+	}
+
+	var err error
+	for _, path := range paths {
+		var b []byte
+		if b, err = readTrimmed(path); err == nil {
+			return b, nil
+		}
+	}
+
+	return nil, err
+}
+
+func (s MachineIDSource) optional() bool {
+	return s.Optional
+}
+
+// DMIProductUUIDSource the product UUID of the machine, as reported by its
+// firmware.
+type DMIProductUUIDSource struct {
+	// Path defaults to /sys/class/dmi/id/product_uuid.
+	Path string
+
+	Optional bool
+}
+
+func (s DMIProductUUIDSource) name() string {
+	return "dmi_product_uuid"
+}
+
+func (s DMIProductUUIDSource) bytes() ([]byte, error) {
+	path := s.Path
+	if path == "" {
+		path = defaultDMIProductUUIDPath
+	}
+
+	b, err := readTrimmed(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.ToLower(b), nil
+}
+
+func (s DMIProductUUIDSource) optional() bool {
+	return s.Optional
+}
+
+// MACSource the MAC address of the primary network interface, the one of
+// the default route, else the first one with a MAC address by name.
+type MACSource struct {
+	// RoutePath defaults to /proc/net/route.
+	RoutePath string
+
+	Optional bool
+
+	// interfaces lists the network interfaces, net.Interfaces if nil.
+	interfaces func() ([]net.Interface, error)
+}
+
+func (s MACSource) name() string {
+	return "mac"
+}
+
+func (s MACSource) bytes() ([]byte, error) {
+	list := s.interfaces
+	if list == nil {
+		list = net.Interfaces
+	}
+
+	ifaces, err := list()
+	if err != nil {
+		return nil, err
+	}
+
+	routePath := s.RoutePath
+	if routePath == "" {
+		routePath = defaultRoutePath
+	}
+	primary, _ := defaultRouteInterface(routePath)
+
+	var candidates []net.Interface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) == 0 {
+			continue
+		}
+		if iface.Name == primary {
+			return []byte(iface.HardwareAddr.String()), nil
+		}
+		candidates = append(candidates, iface)
+	}
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w: no network interface with a MAC address", ErrEmptySource)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Name < candidates[j].Name })
+
+	return []byte(candidates[0].HardwareAddr.String()), nil
+}
+
+func (s MACSource) optional() bool {
+	return s.Optional
+}
+
+// defaultRouteInterface returns the interface of the default route in the
+// routing table at path, in the /proc/net/route format.
+func defaultRouteInterface(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 2 && fields[1] == "00000000" {
+			return fields[0], nil
+		}
+	}
+
+	return "", scanner.Err()
+}
+
+// HostnameSource the host name reported by the kernel.
+type HostnameSource struct {
+	Optional bool
+}
+
+func (s HostnameSource) name() string {
+	return "hostname"
+}
+
+func (s HostnameSource) bytes() ([]byte, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(hostname), nil
+}
+
+func (s HostnameSource) optional() bool {
+	return s.Optional
+}
+
+func isOptional(src source) bool {
+	o, ok := src.(optionalSource)
+
+	return ok && o.optional()
+}
+
+// NewFromSources returns a Fingerprint computed from the values of the
+// sources. They are hashed by name order, each name and value prefixed with
+// its length, so that neither the order the sources are passed in nor the
+// boundaries between the values change the hash. Optional sources that fail
+// are left out, at least one source must be required.
+func NewFromSources(out io.Writer, srcs ...source) (Fingerprint, error) {
+	sorted := append([]source(nil), srcs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].name() < sorted[j].name() })
+
+	required := false
+	val := new(bytes.Buffer)
+	var names []string
+	for i, src := range sorted {
+		if i > 0 && src.name() == sorted[i-1].name() {
+			return zerofp, fmt.Errorf("%w: %s", ErrDuplicateSource, src.name())
+		}
+
+		optional := isOptional(src)
+		required = required || !optional
+
+		b, err := src.bytes()
+		if err == nil && len(b) == 0 {
+			err = ErrEmptySource
+		}
+		if err != nil {
+			if optional {
+				continue
+			}
+
+			return zerofp, fmt.Errorf("fingerprint source %s: %w", src.name(), err)
+		}
+
+		writeLengthPrefixed(val, []byte(src.name()))
+		writeLengthPrefixed(val, b)
+		names = append(names, src.name())
+	}
+
+	if !required {
+		return zerofp, ErrNoRequiredSource
+	}
+
+	fp, err := New(out, val.Bytes())
+	if err != nil {
+		return zerofp, err
+	}
+	fp.sources = names
+
+	return fp, nil
+}
+
+func writeLengthPrefixed(buf *bytes.Buffer, b []byte) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(b)))
+	buf.Write(n[:])
+	buf.Write(b)
+}
+
+func readTrimmed(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrEmptySource, path)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,208 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprint
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSource struct {
+	n   string
+	b   []byte
+	err error
+	opt bool
+}
+
+func (f fakeSource) name() string {
+	return f.n
+}
+
+func (f fakeSource) bytes() ([]byte, error) {
+	return f.b, f.err
+}
+
+func (f fakeSource) optional() bool {
+	return f.opt
+}
+
+func TestNewFromSources(t *testing.T) {
+	errMissing := errors.New("missing")
+
+	tests := []struct {
+		name    string
+		srcs    []source
+		expHash string
+		expErr  error
+	}{
+		{
+			name:    "single source",
+			srcs:    []source{fakeSource{n: "a", b: []byte("foo")}},
+			expHash: "271860c25fc056b4f41f1580245f07a4d9e25099cbda0bf14376b26b3b8448f8",
+		},
+		{
+			name: "several sources",
+			srcs: []source{
+				fakeSource{n: "a", b: []byte("foo")},
+				fakeSource{n: "b", b: []byte("bar")},
+			},
+			expHash: "39b067d7ef4fee2c1729157cd4642909811cdebf4dc32a487eff39fa406db703",
+		},
+		{
+			name: "order independent",
+			srcs: []source{
+				fakeSource{n: "b", b: []byte("bar")},
+				fakeSource{n: "a", b: []byte("foo")},
+			},
+			expHash: "39b067d7ef4fee2c1729157cd4642909811cdebf4dc32a487eff39fa406db703",
+		},
+		{
+			name: "no concatenation ambiguity",
+			srcs: []source{
+				fakeSource{n: "a", b: []byte("foob")},
+				fakeSource{n: "b", b: []byte("ar")},
+			},
+			expHash: "30e6b8a3626d46286e0e17b0e810429596ad7563fe04b6b4fb937f961200ac4c",
+		},
+		{
+			name: "failing optional source skipped",
+			srcs: []source{
+				fakeSource{n: "a", b: []byte("foo")},
+				fakeSource{n: "c", err: errMissing, opt: true},
+				fakeSource{n: "d", opt: true},
+			},
+			expHash: "271860c25fc056b4f41f1580245f07a4d9e25099cbda0bf14376b26b3b8448f8",
+		},
+		{
+			name: "failing required source",
+			srcs: []source{
+				fakeSource{n: "a", b: []byte("foo")},
+				fakeSource{n: "c", err: errMissing},
+			},
+			expErr: errMissing,
+		},
+		{
+			name: "empty required source",
+			srcs: []source{
+				fakeSource{n: "a", b: []byte("foo")},
+				fakeSource{n: "c"},
+			},
+			expErr: ErrEmptySource,
+		},
+		{
+			name:   "only optional sources",
+			srcs:   []source{fakeSource{n: "a", b: []byte("foo"), opt: true}},
+			expErr: ErrNoRequiredSource,
+		},
+		{
+			name:   "no sources",
+			expErr: ErrNoRequiredSource,
+		},
+		{
+			name: "duplicate sources",
+			srcs: []source{
+				fakeSource{n: "a", b: []byte("foo")},
+				fakeSource{n: "a", b: []byte("bar")},
+			},
+			expErr: ErrDuplicateSource,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fp, err := NewFromSources(io.Discard, tt.srcs...)
+			if tt.expErr != nil {
+				require.ErrorIs(t, err, tt.expErr)
+				require.Equal(t, "", fp.Hash())
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expHash, fp.Hash())
+		})
+	}
+}
+
+func TestMachineIDSource(t *testing.T) {
+	dir := t.TempDir()
+	etc := filepath.Join(dir, "etc-machine-id")
+	dbus := filepath.Join(dir, "dbus-machine-id")
+	require.NoError(t, ioutil.WriteFile(dbus, []byte("c0ffee\n"), 0o644))
+
+	// falls back to the dbus machine ID
+	src := MachineIDSource{Paths: []string{etc, dbus}}
+	b, err := src.bytes()
+	require.NoError(t, err)
+	require.Equal(t, "c0ffee", string(b))
+
+	require.NoError(t, ioutil.WriteFile(etc, []byte("beef\n"), 0o644))
+	b, err = src.bytes()
+	require.NoError(t, err)
+	require.Equal(t, "beef", string(b))
+
+	_, err = MachineIDSource{Paths: []string{filepath.Join(dir, "missing")}}.bytes()
+	require.Error(t, err)
+}
+
+func TestDMIProductUUIDSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "product_uuid")
+	require.NoError(t, ioutil.WriteFile(path, []byte("EC2A-1B\n"), 0o644))
+
+	b, err := DMIProductUUIDSource{Path: path}.bytes()
+	require.NoError(t, err)
+	require.Equal(t, "ec2a-1b", string(b))
+}
+
+func TestMACSource(t *testing.T) {
+	mac := func(s string) net.HardwareAddr {
+		addr, err := net.ParseMAC(s)
+		require.NoError(t, err)
+		return addr
+	}
+	ifaces := []net.Interface{
+		{Name: "lo", Flags: net.FlagLoopback, HardwareAddr: mac("00:00:00:00:00:01")},
+		{Name: "wlan0", HardwareAddr: mac("00:00:00:00:00:03")},
+		{Name: "eth1", HardwareAddr: mac("00:00:00:00:00:02")},
+		{Name: "tun0"},
+	}
+	list := func() ([]net.Interface, error) { return ifaces, nil }
+
+	route := filepath.Join(t.TempDir(), "route")
+	require.NoError(t, ioutil.WriteFile(route, []byte(
+		"Iface\tDestination\tGateway\tFlags\n"+
+			"eth1\t0000A8C0\t00000000\t0001\n"+
+			"wlan0\t00000000\t0100A8C0\t0003\n"), 0o644))
+
+	// the interface of the default route
+	b, err := MACSource{RoutePath: route, interfaces: list}.bytes()
+	require.NoError(t, err)
+	require.Equal(t, "00:00:00:00:00:03", string(b))
+
+	// else the first one by name
+	missing := filepath.Join(t.TempDir(), "missing")
+	b, err = MACSource{RoutePath: missing, interfaces: list}.bytes()
+	require.NoError(t, err)
+	require.Equal(t, "00:00:00:00:00:02", string(b))
+
+	_, err = MACSource{RoutePath: missing, interfaces: func() ([]net.Interface, error) {
+		return ifaces[:1], nil
+	}}.bytes()
+	require.ErrorIs(t, err, ErrEmptySource)
+}
@@ -0,0 +1,53 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsutil
+
+import (
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state")
+
+	require.NoError(t, WriteFileAtomic(path, []byte("first")))
+	require.NoError(t, WriteFileAtomic(path, []byte("second")))
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "second", string(content))
+
+	// no temporary file left behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestWriteFileAtomic_MissingDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+
+	err := WriteFileAtomic(filepath.Join(dir, "state"), []byte("content"))
+
+	var dirErr *DirError
+	require.True(t, errors.As(err, &dirErr))
+	require.Equal(t, dir, dirErr.Dir)
+	require.True(t, errors.Is(err, fs.ErrNotExist))
+}
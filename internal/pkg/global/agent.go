@@ -157,6 +157,14 @@ type Chain interface {
 	RuntimeWatchersInflux() *WatchConfig
 }
 
+// RoundEventer is implemented by chains whose node logs an event on every
+// round (or view) advance.
+type RoundEventer interface {
+	// RoundEvent returns the name of the event and the key of its context
+	// holding the round number.
+	RoundEvent() (name, roundKey string)
+}
+
 // PEFEndpoint is a configuration for a single HTTP endpoint
 // that exposes metrics in Prometheus Exposition Format.
 type PEFEndpoint struct {
@@ -178,25 +186,15 @@ const (
 // ErrNodeRunSchemeNotSet error used when the node run scheme is required for operational reasons
 var ErrNodeRunSchemeNotSet = errors.New("node run scheme has not been set")
 
-// NewFingerprintWriter opens a file for writing fingerprint values.
-func NewFingerprintWriter(path string) *os.File {
-	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
-	if err != nil {
-		zap.S().Fatalw("failed opening a fingerprint file for writing", zap.Error(err))
-	}
-
-	return file
-}
-
-// NewFingerprintReader returns a ReadCloser
-func NewFingerprintReader(path string) io.ReadCloser {
-	file, err := os.OpenFile(path, os.O_RDONLY, 0o644)
-	if err != nil {
-		zap.S().Fatalw("failed opening fingerprint file for reading", zap.Error(err))
-	}
+var (
+	// ForceNewFingerprint replaces the cached fingerprint instead of
+	// validating against it, i.e. after intentionally cloning the host.
+	ForceNewFingerprint bool
 
-	return file
-}
+	// FingerprintRotation the fingerprint replaced at startup, if forced
+	// and different from the cached one.
+	FingerprintRotation *fingerprint.Rotation
+)
 
 // FingerprintSetup sets up a new fingerpint and validates it against
 // cached fingerpint, if any. If a fingerpint has not been previously
@@ -218,24 +216,32 @@ func FingerprintSetup() (string, error) {
 	}
 
 	fpp := filepath.Join(AgentCacheDir, DefaultFingerprintFilename)
-	fpw := NewFingerprintWriter(fpp)
-	defer fpw.Close()
+	if ForceNewFingerprint {
+		rotation, err := fingerprint.RotateFile(fingerprintValue(AgentCacheDir, AgentHostname), fpp)
+		if err != nil {
+			return "", err
+		}
 
-	fpr := NewFingerprintReader(fpp)
-	defer fpr.Close()
+		if rotation.Current.Verify(rotation.Previous) != nil {
+			zap.S().Warnw("fingerprint rotated", "previous", rotation.Previous, "fingerprint", rotation.Current.Hash())
+			FingerprintRotation = rotation
+		}
+
+		return rotation.Current.Hash(), nil
+	}
 
-	fp, err := fingerprint.NewWithValidation([]byte(AgentHostname), fpw, fpr)
+	fp, err := fingerprint.NewFromFile(fingerprintValue(AgentCacheDir, AgentHostname), fpp)
 	if err != nil {
-		if _, ok := err.(*fingerprint.ValidationError); ok {
+		var verr *fingerprint.ValidationError
+		if errors.As(err, &verr) {
+			zap.S().Errorw("fingerprint validation failed", "reason", verr.Reason.String(),
+				"expected", verr.Expected, "got", verr.Got)
+
 			return "", fmt.Errorf("cached [%s]: %w", fpp, err)
 		}
 		return "", err
 	}
 
-	if err := fp.Write(); err != nil {
-		return "", err
-	}
-
 	zap.S().Info("fingerprint ", fp.Hash())
 
 	return fp.Hash(), nil
@@ -278,20 +284,53 @@ func setAgentHostname(providers []cloudproviders.MetadataSearch) error {
 	return err
 }
 
+// agentStateDir state directory owned by this agent instance.
+var agentStateDir *StateDir
+
+func init() {
+	RegisterStateMigration(StateMigration{
+		Component: "fingerprint",
+		Version:   1,
+		Migrate: func(dir string) error {
+			return ImportLegacyStateFile(dir, DefaultFingerprintFilename)
+		},
+	})
+	RegisterCloneReset(CloneReset{Component: "fingerprint", Patterns: []string{DefaultFingerprintFilename}})
+}
+
+// DefaultAgentCacheDir returns the agent cache directory (i.e $HOME/.cache/metrikad).
+func DefaultAgentCacheDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.Wrapf(err, "user cache directory error: %v", err)
+	}
+
+	return filepath.Join(cacheDir, AppName), nil
+}
+
 // AgentPrepareStartup sets up cache directory, agent hostname and fingerpint.
 func AgentPrepareStartup() error {
 	var err error
 
-	// Agent cache directory (i.e $HOME/.cache/metrikad)
-	AgentCacheDir, err = os.UserCacheDir()
+	AgentCacheDir, err = DefaultAgentCacheDir()
 	if err != nil {
-		return errors.Wrapf(err, "user cache directory error: %v", err)
+		return err
 	}
 
-	if err := os.Mkdir(AgentCacheDir, 0o755); err != nil &&
-		!errors.Is(err, os.ErrNotExist) && !errors.Is(err, os.ErrExist) {
+	// the state directory stays locked until the agent exits
+	if agentStateDir == nil || agentStateDir.Path != AgentCacheDir {
+		if agentStateDir != nil {
+			agentStateDir.Close()
+		}
 
-		return errors.Wrapf(err, "error creating cache directory: %s", AgentCacheDir)
+		agentStateDir, err = OpenStateDir(AgentCacheDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := agentStateDir.Migrate(); err != nil {
+		return errors.Wrap(err, "agent state error")
 	}
 
 	// Set the agent hostname by one of the supported providers
@@ -307,6 +346,12 @@ func AgentPrepareStartup() error {
 		return errors.Wrap(err, "error setting agent hostname")
 	}
 
+	if !AgentConf.Runtime.CloneDetection.Disabled {
+		if err := checkHostClone(AgentCacheDir); err != nil {
+			return errors.Wrap(err, "clone detection error")
+		}
+	}
+
 	if !AgentConf.Runtime.DisableFingerprintValidation {
 		// Fingerprint validation and caching persisted in the cache directory
 		_, err = FingerprintSetup()
@@ -57,13 +57,19 @@ func TestAgentPrepareStartup(t *testing.T) {
 	files, err = ioutil.ReadDir(filepath.Join(tmpdir, gotFile.Name()))
 	require.Nil(t, err)
 
+	require.Len(t, files, 1)
+	require.Equal(t, AppName, files[0].Name())
+
+	// and the agent state directory .cache/metrikad/
+	files, err = ioutil.ReadDir(filepath.Join(tmpdir, gotFile.Name(), AppName))
+	require.Nil(t, err)
+
 	gotFiles = []string{}
 	for _, file := range files {
 		gotFiles = append(gotFiles, file.Name())
 	}
 
-	require.Len(t, files, 1)
-	require.Equal(t, "ma_fingerprint", files[0].Name())
+	require.Equal(t, []string{StateVersionFilename, DefaultHostFactsFilename, "ma_fingerprint", StateLockFilename}, gotFiles)
 }
 
 func TestAgentPrepareStartup_FingerpintMismatch(t *testing.T) {
@@ -76,7 +82,7 @@ func TestAgentPrepareStartup_FingerpintMismatch(t *testing.T) {
 
 	// now rewrite cached fingerpint to play out the mismatch scenario
 	fakeFingerpint := []byte("fingerprint_mismatch")
-	fingerprintPath := filepath.Join(tmpdir, ".cache/ma_fingerprint")
+	fingerprintPath := filepath.Join(tmpdir, ".cache", AppName, "ma_fingerprint")
 	err = ioutil.WriteFile(fingerprintPath, fakeFingerpint, fs.ModePerm)
 	require.Nil(t, err)
 
@@ -0,0 +1,362 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"agent/api/v1/model"
+	"agent/internal/pkg/fingerprint"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultHostFactsFilename file under the state directory holding the
+	// facts of the host the state was written on.
+	DefaultHostFactsFilename = "host_facts.json"
+
+	// DefaultIdentitySaltFilename file under the state directory holding
+	// the salt of a regenerated fingerprint.
+	DefaultIdentitySaltFilename = "identity_salt"
+
+	// DefaultQuarantineDirname directory under the state directory state
+	// inherited from a cloned host is moved to.
+	DefaultQuarantineDirname = "quarantine"
+)
+
+// Host fact sources.
+const (
+	HostFactMachineID  = "machine_id"
+	HostFactMACs       = "macs"
+	HostFactInstanceID = "instance_id"
+)
+
+var (
+	// hostFactsRoot root of the filesystem host facts are read from.
+	hostFactsRoot = "/"
+
+	cloneResetsMu = &sync.Mutex{}
+	cloneResets   []CloneReset
+)
+
+// HostFacts facts identifying the host the agent runs on. Empty facts are
+// unknown, i.e. unreadable by the agent user, and never compared.
+type HostFacts struct {
+	MachineID  string   `json:"machine_id,omitempty"`
+	MACs       []string `json:"macs,omitempty"`
+	InstanceID string   `json:"instance_id,omitempty"`
+}
+
+// CurrentHostFacts returns the facts of the current host.
+func CurrentHostFacts() HostFacts {
+	facts := HostFacts{
+		MachineID: readHostFact("etc/machine-id", "var/lib/dbus/machine-id"),
+
+		// cloud-init's instance id is world-readable, DMI's may not be
+		InstanceID: readHostFact("var/lib/cloud/data/instance-id", "sys/class/dmi/id/product_uuid"),
+	}
+
+	// only NICs backed by a device, virtual interfaces (i.e. docker
+	// bridges, veth) get random addresses
+	ifaces, _ := os.ReadDir(filepath.Join(hostFactsRoot, "sys/class/net"))
+	for _, iface := range ifaces {
+		dir := filepath.Join(hostFactsRoot, "sys/class/net", iface.Name())
+		if _, err := os.Stat(filepath.Join(dir, "device")); err != nil {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, "address"))
+		if err != nil {
+			continue
+		}
+
+		if mac := strings.TrimSpace(string(b)); mac != "" && mac != "00:00:00:00:00:00" {
+			facts.MACs = append(facts.MACs, mac)
+		}
+	}
+	sort.Strings(facts.MACs)
+
+	return facts
+}
+
+// readHostFact returns the content of the first readable path.
+func readHostFact(paths ...string) string {
+	for _, path := range paths {
+		b, err := os.ReadFile(filepath.Join(hostFactsRoot, path))
+		if err != nil {
+			continue
+		}
+
+		if v := strings.TrimSpace(string(b)); v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// Diff returns the sources known to both facts that differ. The MAC sets
+// differ only if they have no address in common, so a NIC swap on a host
+// with several NICs goes unnoticed.
+func (h HostFacts) Diff(other HostFacts) []string {
+	var changed []string
+
+	if h.MachineID != "" && other.MachineID != "" && h.MachineID != other.MachineID {
+		changed = append(changed, HostFactMachineID)
+	}
+
+	if len(h.MACs) > 0 && len(other.MACs) > 0 && !intersects(h.MACs, other.MACs) {
+		changed = append(changed, HostFactMACs)
+	}
+
+	if h.InstanceID != "" && other.InstanceID != "" && h.InstanceID != other.InstanceID {
+		changed = append(changed, HostFactInstanceID)
+	}
+
+	return changed
+}
+
+func intersects(a, b []string) bool {
+	set := make(map[string]bool, len(a))
+	for _, v := range a {
+		set[strings.ToLower(v)] = true
+	}
+
+	for _, v := range b {
+		if set[strings.ToLower(v)] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ReadHostFacts reads the facts persisted under dir, nil if there are none.
+func ReadHostFacts(dir string) (*HostFacts, error) {
+	b, err := os.ReadFile(filepath.Join(dir, DefaultHostFactsFilename))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "error reading host facts")
+	}
+
+	facts := new(HostFacts)
+	if err := json.Unmarshal(b, facts); err != nil {
+		return nil, errors.Wrap(err, "error parsing host facts")
+	}
+
+	return facts, nil
+}
+
+// WriteHostFacts persists facts under dir.
+func WriteHostFacts(dir string, facts HostFacts) error {
+	b, err := json.Marshal(facts)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, DefaultHostFactsFilename)
+	if err := os.WriteFile(path+".tmp", b, 0o644); err != nil {
+		return errors.Wrap(err, "error writing host facts")
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// CloneReset state a component resets when the agent finds out its state
+// directory was inherited from the host it was cloned from.
+type CloneReset struct {
+	// Component owning the state, i.e. fingerprint.
+	Component string
+
+	// Patterns glob patterns of the state files, relative to the state
+	// directory.
+	Patterns []string
+
+	// Quarantine moves the files to the quarantine directory instead of
+	// removing them, for state holding data, i.e. buffered metrics.
+	Quarantine bool
+}
+
+// RegisterCloneReset registers state to reset on clones, components
+// register theirs on init.
+func RegisterCloneReset(r CloneReset) {
+	cloneResetsMu.Lock()
+	defer cloneResetsMu.Unlock()
+
+	cloneResets = append(cloneResets, r)
+	sort.SliceStable(cloneResets, func(i, j int) bool { return cloneResets[i].Component < cloneResets[j].Component })
+}
+
+// CloneReport what was detected and reset on a cloned host.
+type CloneReport struct {
+	// Changed host fact sources that differ from the persisted ones.
+	Changed []string
+
+	// Reset components whose state was reset.
+	Reset []string
+
+	// Quarantine directory inherited data was moved to, if any.
+	Quarantine string
+}
+
+// EventContext returns the agent.host.cloned event context.
+func (r *CloneReport) EventContext() map[string]interface{} {
+	ctx := map[string]interface{}{
+		model.ChangedFactsKey: strings.Join(r.Changed, ","),
+		model.ResetKey:        strings.Join(r.Reset, ","),
+	}
+
+	if r.Quarantine != "" {
+		ctx[model.QuarantineKey] = r.Quarantine
+	}
+
+	return ctx
+}
+
+// HostClone report of the reset done at startup if the host was found to
+// be a clone, nil otherwise.
+var HostClone *CloneReport
+
+// DetectClone compares the host facts persisted under dir to cur. The
+// host is a clone if at least threshold sources differ while the cached
+// fingerprint still matches the hostname: the state directory was copied
+// along with the image. Returns the sources that changed.
+func DetectClone(dir, hostname string, cur HostFacts, threshold int) (bool, []string, error) {
+	prev, err := ReadHostFacts(dir)
+	if err != nil || prev == nil {
+		// first run, or state written before host facts were kept
+		return false, nil, err
+	}
+
+	changed := prev.Diff(cur)
+	if len(changed) == 0 || len(changed) < threshold {
+		return false, changed, nil
+	}
+
+	cached, err := os.ReadFile(filepath.Join(dir, DefaultFingerprintFilename))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, changed, nil
+	} else if err != nil {
+		return false, changed, errors.Wrap(err, "error reading cached fingerprint")
+	}
+
+	fp, err := fingerprint.New(io.Discard, fingerprintValue(dir, hostname))
+	if err != nil {
+		return false, changed, err
+	}
+
+	return fp.Matches(string(cached)), changed, nil
+}
+
+// ResetClonedState resets the state of a clone under dir: the identity is
+// regenerated and the registered state removed or quarantined.
+func ResetClonedState(dir string, changed []string, now time.Time) (*CloneReport, error) {
+	report := &CloneReport{Changed: changed}
+
+	// a new salt makes the fingerprint unique to this clone
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, DefaultIdentitySaltFilename), []byte(hex.EncodeToString(salt)), 0o644); err != nil {
+		return nil, errors.Wrap(err, "error writing identity salt")
+	}
+
+	quarantine := filepath.Join(dir, DefaultQuarantineDirname, now.UTC().Format("20060102T150405Z"))
+
+	cloneResetsMu.Lock()
+	resets := append([]CloneReset(nil), cloneResets...)
+	cloneResetsMu.Unlock()
+
+	for _, r := range resets {
+		reset := false
+		for _, pattern := range r.Patterns {
+			paths, err := filepath.Glob(filepath.Join(dir, pattern))
+			if err != nil {
+				return nil, err
+			}
+
+			for _, path := range paths {
+				if r.Quarantine {
+					if err := os.MkdirAll(quarantine, 0o755); err != nil {
+						return nil, errors.Wrap(err, "error creating quarantine directory")
+					}
+					err = os.Rename(path, filepath.Join(quarantine, filepath.Base(path)))
+					report.Quarantine = quarantine
+				} else {
+					err = os.Remove(path)
+				}
+				if err != nil {
+					return nil, errors.Wrapf(err, "error resetting %s state", r.Component)
+				}
+				reset = true
+			}
+		}
+
+		if reset {
+			report.Reset = append(report.Reset, r.Component)
+		}
+	}
+
+	return report, nil
+}
+
+// fingerprintValue returns the value the fingerprint is computed from:
+// the hostname, salted once the host was found to be a clone.
+func fingerprintValue(dir, hostname string) []byte {
+	salt, err := os.ReadFile(filepath.Join(dir, DefaultIdentitySaltFilename))
+	if err != nil || len(salt) == 0 {
+		return []byte(hostname)
+	}
+
+	return []byte(hostname + "/" + strings.TrimSpace(string(salt)))
+}
+
+// checkHostClone detects if the state directory was inherited from the
+// host this one was cloned from and resets it, then persists the current
+// host facts.
+func checkHostClone(dir string) error {
+	cur := CurrentHostFacts()
+
+	cloned, changed, err := DetectClone(dir, AgentHostname, cur, AgentConf.Runtime.CloneDetection.Threshold)
+	if err != nil {
+		return err
+	}
+
+	if cloned {
+		zap.S().Warnw("host cloned from an image including the agent state, resetting the inherited state", "changed", changed)
+
+		HostClone, err = ResetClonedState(dir, changed, time.Now())
+		if err != nil {
+			return err
+		}
+	} else if len(changed) > 0 {
+		zap.S().Infow("host facts changed, below the clone detection threshold", "changed", changed)
+	}
+
+	return WriteHostFacts(dir, cur)
+}
@@ -0,0 +1,168 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"agent/api/v1/model"
+	"agent/internal/pkg/fingerprint"
+
+	"github.com/stretchr/testify/require"
+)
+
+var templateFacts = HostFacts{
+	MachineID:  "4c4c4544004d3510804bc4c04f4e3232",
+	MACs:       []string{"02:42:ac:11:00:02", "52:54:00:12:34:56"},
+	InstanceID: "i-0123456789abcdef0",
+}
+
+func init() {
+	RegisterCloneReset(CloneReset{Component: "buffer", Patterns: []string{"buffer_*.json"}, Quarantine: true})
+}
+
+// newTemplateStateDir returns a state directory as left by an agent that
+// ran on the template host.
+func newTemplateStateDir(t *testing.T, hostname string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	fp, err := fingerprint.New(io.Discard, []byte(hostname))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, DefaultFingerprintFilename), []byte(fp.Hash()), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, DefaultShutdownReportFilename), []byte("{}"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "buffer_0.json"), []byte("{}"), 0o644))
+	require.NoError(t, WriteHostFacts(dir, templateFacts))
+
+	return dir
+}
+
+func TestDetectClone_Clone(t *testing.T) {
+	dir := newTemplateStateDir(t, "node-1")
+
+	clone := HostFacts{
+		MachineID:  "9f1c2a6e3b7d4e0f8a5b6c7d8e9f0a1b",
+		MACs:       []string{"52:54:00:ab:cd:ef"},
+		InstanceID: "i-0fedcba9876543210",
+	}
+
+	cloned, changed, err := DetectClone(dir, "node-1", clone, DefaultRuntimeCloneDetectionThreshold)
+	require.NoError(t, err)
+	require.True(t, cloned)
+	require.Equal(t, []string{HostFactMachineID, HostFactMACs, HostFactInstanceID}, changed)
+
+	now := time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC)
+	report, err := ResetClonedState(dir, changed, now)
+	require.NoError(t, err)
+	require.Equal(t, []string{"buffer", "fingerprint", "shutdown"}, report.Reset)
+
+	// the inherited buffer is quarantined, not replayed
+	quarantine := filepath.Join(dir, DefaultQuarantineDirname, "20220601T100000Z")
+	require.Equal(t, quarantine, report.Quarantine)
+	require.FileExists(t, filepath.Join(quarantine, "buffer_0.json"))
+	require.NoFileExists(t, filepath.Join(dir, "buffer_0.json"))
+	require.NoFileExists(t, filepath.Join(dir, DefaultFingerprintFilename))
+	require.NoFileExists(t, filepath.Join(dir, DefaultShutdownReportFilename))
+
+	// the regenerated identity differs from the template's
+	fp, err := fingerprint.New(io.Discard, []byte("node-1"))
+	require.NoError(t, err)
+	regenerated, err := fingerprint.New(io.Discard, fingerprintValue(dir, "node-1"))
+	require.NoError(t, err)
+	require.NotEqual(t, fp.Hash(), regenerated.Hash())
+
+	require.Equal(t, map[string]interface{}{
+		model.ChangedFactsKey: "machine_id,macs,instance_id",
+		model.ResetKey:        "buffer,fingerprint,shutdown",
+		model.QuarantineKey:   quarantine,
+	}, report.EventContext())
+}
+
+func TestDetectClone_RestoreFromBackup(t *testing.T) {
+	dir := newTemplateStateDir(t, "node-1")
+
+	// restored in place, facts read back in a different order
+	restored := HostFacts{
+		MachineID:  templateFacts.MachineID,
+		MACs:       []string{"52:54:00:12:34:56", "02:42:AC:11:00:02"},
+		InstanceID: templateFacts.InstanceID,
+	}
+
+	cloned, changed, err := DetectClone(dir, "node-1", restored, DefaultRuntimeCloneDetectionThreshold)
+	require.NoError(t, err)
+	require.False(t, cloned)
+	require.Empty(t, changed)
+}
+
+func TestDetectClone_NICSwap(t *testing.T) {
+	dir := newTemplateStateDir(t, "node-1")
+
+	swapped := templateFacts
+	swapped.MACs = []string{"a0:36:9f:00:00:01"}
+
+	cloned, changed, err := DetectClone(dir, "node-1", swapped, DefaultRuntimeCloneDetectionThreshold)
+	require.NoError(t, err)
+	require.False(t, cloned)
+	require.Equal(t, []string{HostFactMACs}, changed)
+}
+
+func TestDetectClone_UnknownFacts(t *testing.T) {
+	dir := newTemplateStateDir(t, "node-1")
+
+	// machine-id and instance ID unreadable, only the MACs are compared
+	cloned, changed, err := DetectClone(dir, "node-1", HostFacts{MACs: []string{"52:54:00:ab:cd:ef"}}, DefaultRuntimeCloneDetectionThreshold)
+	require.NoError(t, err)
+	require.False(t, cloned)
+	require.Equal(t, []string{HostFactMACs}, changed)
+}
+
+func TestDetectClone_FingerprintMismatch(t *testing.T) {
+	// the hostname changed too, the fingerprint validation reports it
+	dir := newTemplateStateDir(t, "node-1")
+
+	cloned, _, err := DetectClone(dir, "node-2", HostFacts{MachineID: "other", InstanceID: "other"}, DefaultRuntimeCloneDetectionThreshold)
+	require.NoError(t, err)
+	require.False(t, cloned)
+}
+
+func TestDetectClone_FirstRun(t *testing.T) {
+	cloned, changed, err := DetectClone(t.TempDir(), "node-1", templateFacts, DefaultRuntimeCloneDetectionThreshold)
+	require.NoError(t, err)
+	require.False(t, cloned)
+	require.Empty(t, changed)
+}
+
+func TestCurrentHostFacts(t *testing.T) {
+	root := t.TempDir()
+	defer func(prev string) { hostFactsRoot = prev }(hostFactsRoot)
+	hostFactsRoot = root
+
+	write := func(path, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(root, path)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, path), []byte(content), 0o644))
+	}
+	write("var/lib/dbus/machine-id", "4c4c4544004d3510804bc4c04f4e3232\n")
+	write("var/lib/cloud/data/instance-id", "i-0123456789abcdef0\n")
+	write("sys/class/net/eth1/address", "52:54:00:12:34:56\n")
+	write("sys/class/net/eth1/device/vendor", "0x1af4")
+	write("sys/class/net/eth0/address", "02:42:ac:11:00:02\n")
+	write("sys/class/net/eth0/device/vendor", "0x1af4")
+	write("sys/class/net/docker0/address", "02:42:5e:8f:1a:2b\n")
+
+	require.Equal(t, templateFacts, CurrentHostFacts())
+}
@@ -121,9 +121,55 @@ var (
 	// DefaultRuntimeWatchersInfluxUpstreamURL default URL to push InfluxDB metrics to
 	DefaultRuntimeWatchersInfluxUpstreamURL = ""
 
+	// DefaultRuntimeWatchersBackupInterval default interval for checking backup freshness
+	DefaultRuntimeWatchersBackupInterval = 15 * time.Minute
+
+	// DefaultRuntimeWatchersBackupAgeThresholds default backup age thresholds, each exceeded threshold
+	// escalates the emitted event severity.
+	DefaultRuntimeWatchersBackupAgeThresholds = []time.Duration{26 * time.Hour, 50 * time.Hour, 7 * 24 * time.Hour}
+
+	// DefaultRuntimeWatchersBackupS3MaxPages default max number of S3 list pages fetched per check
+	DefaultRuntimeWatchersBackupS3MaxPages = 10
+
 	// DefaultNTPServer default NTP server
 	DefaultNTPServer = "pool.ntp.org"
 
+	// DefaultRuntimeConfigProbationPeriod default period to monitor agent health after a config reload
+	DefaultRuntimeConfigProbationPeriod = 5 * time.Minute
+
+	// DefaultRuntimeConfigProbationMaxPipelineStall default max time without any data leaving the pipeline
+	DefaultRuntimeConfigProbationMaxPipelineStall = 2 * time.Minute
+
+	// DefaultRuntimeConfigProbationMaxExportFailures default max export failures during probation
+	DefaultRuntimeConfigProbationMaxExportFailures = 10
+
+	// DefaultRuntimeConfigProbationMaxSelfCPU default max agent CPU usage (1.0 = one core)
+	DefaultRuntimeConfigProbationMaxSelfCPU = 0.5
+
+	// DefaultRuntimeLastKnownGoodMaxStale default max time a cached node gauge is served for
+	DefaultRuntimeLastKnownGoodMaxStale = 15 * time.Minute
+
+	// DefaultRuntimeNodeExporterURL default node_exporter endpoint scraped in passthrough mode
+	DefaultRuntimeNodeExporterURL = "http://127.0.0.1:9100/metrics"
+
+	// DefaultRuntimeNodeExporterTimeout default node_exporter scrape timeout
+	DefaultRuntimeNodeExporterTimeout = 5 * time.Second
+
+	// DefaultRuntimeNodeExporterFallbackAfter default number of consecutive failed
+	// node_exporter scrapes before falling back to the internal collectors
+	DefaultRuntimeNodeExporterFallbackAfter = 3
+
+	// DefaultRuntimeRoundRollupDays default number of days of round time rollups kept
+	DefaultRuntimeRoundRollupDays = 14
+
+	// DefaultRuntimeRoundRollupMaxGap default max time without round events
+	// before a day of round time rollups is flagged as partial
+	DefaultRuntimeRoundRollupMaxGap = 5 * time.Minute
+
+	// DefaultRuntimeCloneDetectionThreshold default number of host facts
+	// that must differ to consider the host a clone
+	DefaultRuntimeCloneDetectionThreshold = 2
+
 	// ConfigEnvPrefix prefix used for agent specific env vars
 	ConfigEnvPrefix = "MA"
 )
@@ -134,6 +180,9 @@ const (
 
 	// InfluxWatchPrefix prefix used for tagging messages collected by the influx watcher
 	InfluxWatchPrefix = "influx"
+
+	// BackupWatchPrefix prefix used for tagging messages collected by the backup freshness watcher
+	BackupWatchPrefix = "backup"
 )
 
 // WatchType used for determining is data originates by
@@ -150,6 +199,11 @@ func (w WatchType) IsInflux() bool {
 	return strings.HasPrefix(string(w), InfluxWatchPrefix)
 }
 
+// IsBackup returns true if watch checks backup freshness
+func (w WatchType) IsBackup() bool {
+	return strings.HasPrefix(string(w), BackupWatchPrefix)
+}
+
 var (
 	// DefaultRuntimeLoggingOutputs default log outputs
 	DefaultRuntimeLoggingOutputs = []string{"stdout"}
@@ -161,19 +215,38 @@ var (
 		{Type: "prometheus.proc.net.arp_linux"},
 		{Type: "prometheus.proc.stat_linux"},
 		{Type: "prometheus.proc.conntrack_linux"},
+		{Type: "prometheus.proc.bonding"},
+		{Type: "prometheus.proc.buddyinfo"},
+		{Type: "prometheus.proc.cgroup"},
 		{Type: "prometheus.proc.diskstats"},
 		{Type: "prometheus.proc.entropy"},
 		{Type: "prometheus.proc.filefd"},
 		{Type: "prometheus.proc.filesystem"},
+		{Type: "prometheus.proc.hwmon"},
+		{Type: "prometheus.proc.infiniband"},
+		{Type: "prometheus.proc.ipvs"},
 		{Type: "prometheus.proc.loadavg"},
+		{Type: "prometheus.proc.mdadm"},
 		{Type: "prometheus.proc.meminfo"},
 		{Type: "prometheus.proc.netclass"},
 		{Type: "prometheus.proc.netdev"},
+		{Type: "prometheus.proc.power_supply"},
+		{Type: "prometheus.proc.processes"},
+		{Type: "prometheus.proc.rapl"},
+		{Type: "prometheus.proc.schedstat"},
+		{Type: "prometheus.proc.softirqs"},
 		{Type: "prometheus.proc.sockstat"},
+		{Type: "prometheus.proc.tcpstat"},
 		{Type: "prometheus.proc.textfile"},
+		{Type: "prometheus.proc.thermal_zone"},
+		{Type: "prometheus.proc.udp_queues"},
+		{Type: "prometheus.os_release"},
+		{Type: "prometheus.systemd"},
 		{Type: "prometheus.time"},
+		{Type: "prometheus.timex"},
 		{Type: "prometheus.uname"},
 		{Type: "prometheus.vmstat"},
+		{Type: "prometheus.proc.wifi"},
 	}
 )
 
@@ -205,6 +278,29 @@ type WatchConfig struct {
 	ListenAddr        string `yaml:"listen_addr"`
 	UpstreamURL       string `yaml:"upstream_url"`
 	ExporterActivated bool   `yaml:"exporter_activated"`
+
+	// backup watch
+	Glob          string          `yaml:"glob"`
+	S3            *S3Config       `yaml:"s3"`
+	AgeThresholds []time.Duration `yaml:"age_thresholds"`
+}
+
+// S3Config S3-compatible object storage location and credentials.
+type S3Config struct {
+	Endpoint        string `yaml:"endpoint"`
+	Region          string `yaml:"region"`
+	Bucket          string `yaml:"bucket"`
+	Prefix          string `yaml:"prefix"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	SessionToken    string `yaml:"session_token"`
+	MaxPages        int    `yaml:"max_pages"`
+}
+
+// String returns the S3 location, credentials are redacted.
+func (s S3Config) String() string {
+	return fmt.Sprintf("s3://%s/%s (endpoint: %s, region: %s, credentials: <redacted>)",
+		s.Bucket, s.Prefix, s.Endpoint, s.Region)
 }
 
 // RuntimeConfig configuration related to the agent runtime.
@@ -219,6 +315,85 @@ type RuntimeConfig struct {
 	DisableFingerprintValidation bool                   `yaml:"disable_fingerprint_validation"`
 	Exporters                    map[string]interface{} `yaml:"exporters"`
 	NTPServer                    string                 `yaml:"ntp_server"`
+	LogTimezone                  string                 `yaml:"log_timezone"`
+	ConfigProbation              ProbationConfig        `yaml:"config_probation"`
+	LastKnownGood                LastKnownGoodConfig    `yaml:"last_known_good"`
+	NodeExporter                 NodeExporterConfig     `yaml:"node_exporter"`
+	StrictStartup                bool                   `yaml:"strict_startup"`
+	RoundRollup                  RoundRollupConfig      `yaml:"round_rollup"`
+	CloneDetection               CloneDetectionConfig   `yaml:"clone_detection"`
+}
+
+// CloneDetectionConfig configuration of the detection of hosts cloned from
+// an image including the agent's state directory.
+type CloneDetectionConfig struct {
+	Disabled bool `yaml:"disabled"`
+
+	// Threshold number of host facts (machine-id, MAC addresses, instance
+	// ID) that must differ from the persisted ones.
+	Threshold int `yaml:"threshold"`
+}
+
+// RoundRollupConfig configuration of the daily rollups of the node's round
+// time, for chains logging round advances.
+type RoundRollupConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Days number of UTC days kept, including the current one.
+	Days int `yaml:"days"`
+
+	// MaxGap max time without round events before a day is flagged as
+	// partial.
+	MaxGap time.Duration `yaml:"max_gap"`
+}
+
+// NodeExporterConfig configuration of the passthrough mode, scraping an
+// existing node_exporter instead of running the overlapping internal
+// collectors.
+type NodeExporterConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	URL     string        `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout"`
+
+	// FallbackAfter consecutive failed scrapes before falling back to
+	// the internal collectors.
+	FallbackAfter int `yaml:"fallback_after"`
+
+	Mappings []NodeExporterMapping `yaml:"mappings"`
+}
+
+// NodeExporterMapping node_exporter metric families shipped in place of
+// an internal collector.
+type NodeExporterMapping struct {
+	// Collector internal collector replaced, i.e. prometheus.proc.cpu.
+	// Families are shipped under its name and it runs as a fallback.
+	Collector string `yaml:"collector"`
+
+	// Families node_exporter metric families shipped.
+	Families []string `yaml:"families"`
+
+	// Rename maps node_exporter family names to the agent's, for
+	// families named differently.
+	Rename map[string]string `yaml:"rename"`
+}
+
+// LastKnownGoodConfig configuration of the cache serving the last observed
+// value of node gauges while the node is down. Off by default since it
+// changes metric semantics.
+type LastKnownGoodConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Metrics  []string      `yaml:"metrics"`
+	MaxStale time.Duration `yaml:"max_stale"`
+}
+
+// ProbationConfig configuration of the health probation window
+// applied after a configuration reload.
+type ProbationConfig struct {
+	DisableAutoRollback bool          `yaml:"disable_auto_rollback"`
+	Period              time.Duration `yaml:"period"`
+	MaxPipelineStall    time.Duration `yaml:"max_pipeline_stall"`
+	MaxExportFailures   int           `yaml:"max_export_failures"`
+	MaxSelfCPU          float64       `yaml:"max_self_cpu"`
 }
 
 // Hints node discovery hints
@@ -454,6 +629,70 @@ func overloadFromEnv(c *AgentConfig) error {
 		c.Runtime.NTPServer = v
 	}
 
+	v = os.Getenv(strings.ToUpper(ConfigEnvPrefix + "_" + "runtime_log_timezone"))
+	if v != "" {
+		c.Runtime.LogTimezone = v
+	}
+
+	v = os.Getenv(strings.ToUpper(ConfigEnvPrefix + "_" + "runtime_config_probation_disable_auto_rollback"))
+	if v != "" {
+		vBool, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.Wrapf(err, "runtime_config_probation_disable_auto_rollback env parse error")
+		}
+		c.Runtime.ConfigProbation.DisableAutoRollback = vBool
+	}
+
+	v = os.Getenv(strings.ToUpper(ConfigEnvPrefix + "_" + "runtime_last_known_good_enabled"))
+	if v != "" {
+		vBool, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.Wrapf(err, "runtime_last_known_good_enabled env parse error")
+		}
+		c.Runtime.LastKnownGood.Enabled = vBool
+	}
+
+	v = os.Getenv(strings.ToUpper(ConfigEnvPrefix + "_" + "runtime_strict_startup"))
+	if v != "" {
+		vBool, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.Wrapf(err, "runtime_strict_startup env parse error")
+		}
+		c.Runtime.StrictStartup = vBool
+	}
+
+	v = os.Getenv(strings.ToUpper(ConfigEnvPrefix + "_" + "runtime_node_exporter_enabled"))
+	if v != "" {
+		vBool, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.Wrapf(err, "runtime_node_exporter_enabled env parse error")
+		}
+		c.Runtime.NodeExporter.Enabled = vBool
+	}
+
+	v = os.Getenv(strings.ToUpper(ConfigEnvPrefix + "_" + "runtime_node_exporter_url"))
+	if v != "" {
+		c.Runtime.NodeExporter.URL = v
+	}
+
+	v = os.Getenv(strings.ToUpper(ConfigEnvPrefix + "_" + "runtime_round_rollup_enabled"))
+	if v != "" {
+		vBool, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.Wrapf(err, "runtime_round_rollup_enabled env parse error")
+		}
+		c.Runtime.RoundRollup.Enabled = vBool
+	}
+
+	v = os.Getenv(strings.ToUpper(ConfigEnvPrefix + "_" + "runtime_clone_detection_disabled"))
+	if v != "" {
+		vBool, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.Wrapf(err, "runtime_clone_detection_disabled env parse error")
+		}
+		c.Runtime.CloneDetection.Disabled = vBool
+	}
+
 	return nil
 }
 
@@ -464,6 +703,10 @@ func ensureDefaults(c *AgentConfig) {
 	}
 
 	for _, watchConf := range c.Runtime.Watchers {
+		if WatchType(watchConf.Type).IsBackup() {
+			ensureBackupWatchDefaults(watchConf)
+		}
+
 		if watchConf.SamplingInterval == 0*time.Second {
 			watchConf.SamplingInterval = c.Runtime.SamplingInterval
 		}
@@ -541,39 +784,140 @@ func ensureDefaults(c *AgentConfig) {
 	if len(c.Runtime.NTPServer) == 0 {
 		c.Runtime.NTPServer = DefaultNTPServer
 	}
+
+	if c.Runtime.ConfigProbation.Period == 0 {
+		c.Runtime.ConfigProbation.Period = DefaultRuntimeConfigProbationPeriod
+	}
+
+	if c.Runtime.ConfigProbation.MaxPipelineStall == 0 {
+		c.Runtime.ConfigProbation.MaxPipelineStall = DefaultRuntimeConfigProbationMaxPipelineStall
+	}
+
+	if c.Runtime.ConfigProbation.MaxExportFailures == 0 {
+		c.Runtime.ConfigProbation.MaxExportFailures = DefaultRuntimeConfigProbationMaxExportFailures
+	}
+
+	if c.Runtime.ConfigProbation.MaxSelfCPU == 0 {
+		c.Runtime.ConfigProbation.MaxSelfCPU = DefaultRuntimeConfigProbationMaxSelfCPU
+	}
+
+	if c.Runtime.LastKnownGood.MaxStale == 0 {
+		c.Runtime.LastKnownGood.MaxStale = DefaultRuntimeLastKnownGoodMaxStale
+	}
+
+	if c.Runtime.NodeExporter.URL == "" {
+		c.Runtime.NodeExporter.URL = DefaultRuntimeNodeExporterURL
+	}
+
+	if c.Runtime.NodeExporter.Timeout == 0 {
+		c.Runtime.NodeExporter.Timeout = DefaultRuntimeNodeExporterTimeout
+	}
+
+	if c.Runtime.NodeExporter.FallbackAfter == 0 {
+		c.Runtime.NodeExporter.FallbackAfter = DefaultRuntimeNodeExporterFallbackAfter
+	}
+
+	if c.Runtime.RoundRollup.Days == 0 {
+		c.Runtime.RoundRollup.Days = DefaultRuntimeRoundRollupDays
+	}
+
+	if c.Runtime.RoundRollup.MaxGap == 0 {
+		c.Runtime.RoundRollup.MaxGap = DefaultRuntimeRoundRollupMaxGap
+	}
+
+	if c.Runtime.CloneDetection.Threshold == 0 {
+		c.Runtime.CloneDetection.Threshold = DefaultRuntimeCloneDetectionThreshold
+	}
 }
 
 // LoadAgentConfig loads agent configuration in the following priority:
 // 1. Load configuration from the first file found in ConfigFilePriority.
-// 2. Override any configuration key if an environment variable is set.
+// 2. Merge any fragments found in the conf.d directory next to it.
+// 3. Override any configuration key if an environment variable is set.
 func LoadAgentConfig(c *AgentConfig) error {
+	sources, err := readAgentConfig(c)
+	if err != nil {
+		return err
+	}
+
+	if err := createLogFolders(c); err != nil {
+		return err
+	}
+
+	AgentConfigSources = sources
+
+	return nil
+}
+
+// ValidateAgentConfig loads the configuration, including its fragments,
+// without applying it. Returns the files that contributed to it.
+func ValidateAgentConfig() ([]string, error) {
+	return readAgentConfig(&AgentConfig{})
+}
+
+// ReadAgentConfig loads the configuration, including its fragments, into c
+// without applying it. Returns the files that contributed to it.
+func ReadAgentConfig(c *AgentConfig) ([]string, error) {
+	return readAgentConfig(c)
+}
+
+func readAgentConfig(c *AgentConfig) ([]string, error) {
 	var (
-		content []byte
-		err     error
+		content  []byte
+		mainFile string
+		err      error
 	)
 
 	for _, fn := range ConfigFilePriority {
 		content, err = ioutil.ReadFile(fn)
 		if err == nil {
+			mainFile = fn
 			break
 		}
 	}
 
+	var sources []string
+	if mainFile != "" {
+		AgentConfigDir = filepath.Join(filepath.Dir(mainFile), ConfigDirName)
+
+		var fragments []string
+		content, fragments, err = layerConfig(content, AgentConfigDir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error while merging %s", AgentConfigDir)
+		}
+		sources = append([]string{mainFile}, fragments...)
+	}
+
 	if err := yaml.Unmarshal(content, c); err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := overloadFromEnv(c); err != nil {
-		return errors.Wrapf(err, "error while loading config from env")
+		return nil, errors.Wrapf(err, "error while loading config from env")
 	}
 
 	ensureDefaults(c)
 
-	if err := createLogFolders(c); err != nil {
-		return err
+	if _, err := c.Runtime.LogLocation(); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return sources, nil
+}
+
+// LogLocation returns the location assumed for node log timestamps lacking
+// zone information, the host's local time if log_timezone is not set.
+func (r *RuntimeConfig) LogLocation() (*time.Location, error) {
+	if r.LogTimezone == "" || strings.EqualFold(r.LogTimezone, "local") {
+		return time.Local, nil
+	}
+
+	loc, err := time.LoadLocation(r.LogTimezone)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid runtime.log_timezone %q", r.LogTimezone)
+	}
+
+	return loc, nil
 }
 
 func createLogFolders(c *AgentConfig) error {
@@ -619,3 +963,19 @@ func (p *PlatformConfig) IsEnabled() bool {
 	}
 	return *p.Enabled
 }
+
+// ensureBackupWatchDefaults backup checks are expensive, so they use
+// a slow interval instead of the runtime sampling interval.
+func ensureBackupWatchDefaults(wc *WatchConfig) {
+	if wc.SamplingInterval == 0 {
+		wc.SamplingInterval = DefaultRuntimeWatchersBackupInterval
+	}
+
+	if len(wc.AgeThresholds) == 0 {
+		wc.AgeThresholds = DefaultRuntimeWatchersBackupAgeThresholds
+	}
+
+	if wc.S3 != nil && wc.S3.MaxPages == 0 {
+		wc.S3.MaxPages = DefaultRuntimeWatchersBackupS3MaxPages
+	}
+}
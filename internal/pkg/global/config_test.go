@@ -14,6 +14,7 @@
 package global
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"strings"
@@ -96,6 +97,7 @@ discovery:
 	err = os.Setenv("MA_RUNTIME_WATCHERS_INFLUX_UPSTREAM_URL", "influx-upstream-url")
 	err = os.Setenv("MA_DISCOVERY_DOCKER_REGEX", "container-name,foobar")
 	err = os.Setenv("MA_DISCOVERY_SYSTEMD_GLOB", "node.service foobar")
+	err = os.Setenv("MA_RUNTIME_LAST_KNOWN_GOOD_ENABLED", "true")
 
 	c := &AgentConfig{}
 	err = LoadAgentConfig(c)
@@ -122,6 +124,8 @@ discovery:
 	require.Equal(t, "foobar", c.Discovery.Docker.Regex[1])
 	require.Equal(t, "node.service", c.Discovery.Systemd.Glob[0])
 	require.Equal(t, "foobar", c.Discovery.Systemd.Glob[1])
+	require.Equal(t, true, c.Runtime.LastKnownGood.Enabled)
+	require.Equal(t, DefaultRuntimeLastKnownGoodMaxStale, c.Runtime.LastKnownGood.MaxStale)
 
 	for _, wc := range c.Runtime.Watchers {
 		if wc.Type == "influx" {
@@ -130,3 +134,34 @@ discovery:
 		}
 	}
 }
+
+func TestEnsureDefaults_BackupWatch(t *testing.T) {
+	conf := &AgentConfig{}
+	conf.Runtime.Watchers = []*WatchConfig{
+		{Type: "backup", S3: &S3Config{Bucket: "b", SecretAccessKey: "secret"}},
+		{Type: "backup", Glob: "/backups/*", SamplingInterval: time.Hour},
+		{Type: "prometheus.proc.cpu"},
+	}
+	ensureDefaults(conf)
+
+	require.Equal(t, DefaultRuntimeWatchersBackupInterval, conf.Runtime.Watchers[0].SamplingInterval)
+	require.Equal(t, DefaultRuntimeWatchersBackupAgeThresholds, conf.Runtime.Watchers[0].AgeThresholds)
+	require.Equal(t, DefaultRuntimeWatchersBackupS3MaxPages, conf.Runtime.Watchers[0].S3.MaxPages)
+	require.NotContains(t, fmt.Sprintf("%+v", *conf.Runtime.Watchers[0]), "secret")
+
+	require.Equal(t, time.Hour, conf.Runtime.Watchers[1].SamplingInterval)
+	require.Equal(t, DefaultRuntimeSamplingInterval, conf.Runtime.Watchers[2].SamplingInterval)
+}
+
+func TestRuntimeConfig_LogLocation(t *testing.T) {
+	loc, err := (&RuntimeConfig{}).LogLocation()
+	require.NoError(t, err)
+	require.Equal(t, time.Local, loc)
+
+	loc, err = (&RuntimeConfig{LogTimezone: "Europe/Berlin"}).LogLocation()
+	require.NoError(t, err)
+	require.Equal(t, "Europe/Berlin", loc.String())
+
+	_, err = (&RuntimeConfig{LogTimezone: "Mars/Olympus_Mons"}).LogLocation()
+	require.Error(t, err)
+}
@@ -15,6 +15,7 @@ package global
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
@@ -48,7 +49,8 @@ type ExporterHandler struct {
 
 // ExporterRegisterer exporter handlers registry.
 type ExporterRegisterer struct {
-	handlers []ExporterHandler
+	handlers  []ExporterHandler
+	listeners sync.WaitGroup
 }
 
 // Register registers a new exporter and its channel.
@@ -58,26 +60,51 @@ func (e *ExporterRegisterer) Register(exporter Exporter, subCh chan interface{})
 	return nil
 }
 
+// Pending returns the number of messages not yet handled by each
+// registered exporter, by exporter type.
+func (e *ExporterRegisterer) Pending() map[string]int {
+	pending := make(map[string]int, len(e.handlers))
+	for _, h := range e.handlers {
+		pending[fmt.Sprintf("%T", h.exporter)] += len(h.subscriptionCh)
+	}
+
+	return pending
+}
+
 // Start starts a goroutine for each configured handler.
 func (e *ExporterRegisterer) Start(ctx context.Context, wg *sync.WaitGroup) error {
 	for i := range e.handlers {
 		wg.Add(1)
-		go func(e ExporterHandler) {
-			MessageListener(ctx, wg, e.subscriptionCh, e.exporter)
+		e.listeners.Add(1)
+		go func(h ExporterHandler) {
+			defer e.listeners.Done()
+			defer ReportPanic()
+			MessageListener(ctx, wg, h.subscriptionCh, h.exporter)
 		}(e.handlers[i])
 	}
 
 	return nil
 }
 
+// Wait blocks until all listeners returned, either because their
+// channel was closed or their context is done.
+func (e *ExporterRegisterer) Wait() {
+	e.listeners.Wait()
+}
+
 // MessageListener reads from one Watcher emit channel
 // and sequentially passes received messages to the exporter's
-// HandleMessage method.
+// HandleMessage method, until the channel is closed.
 func MessageListener(ctx context.Context, wg *sync.WaitGroup, ch <-chan interface{}, e Exporter) {
 	defer wg.Done()
 	for {
 		select {
-		case m := <-ch:
+		case m, ok := <-ch:
+			if !ok {
+				zap.S().Info("exporter channel closed, exiting listener")
+				return
+			}
+
 			message, ok := m.(*model.Message)
 			if !ok {
 				zap.S().Warnf("Unexpected type %T, skipping item", m)
@@ -0,0 +1,112 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/procfs"
+)
+
+// AgentHealth samples the agent health indicators judged by
+// ConfigProbation: the time since the last export, the export failures
+// since Reset and the agent CPU usage since the previous sample.
+type AgentHealth struct {
+	state *AgentState
+
+	// trackStall the pipeline stall is only tracked if data leaves the
+	// agent through the tracked exports (i.e. the platform is enabled).
+	trackStall bool
+
+	now     func() time.Time
+	cpuTime func() (float64, error)
+
+	mu       *sync.Mutex
+	since    time.Time
+	failures uint64
+	cpu      float64
+	cpuAt    time.Time
+}
+
+// NewAgentHealth returns a new AgentHealth reading the exports recorded in
+// state and the agent CPU time from procfs.
+func NewAgentHealth(state *AgentState, trackStall bool) *AgentHealth {
+	h := &AgentHealth{
+		state:      state,
+		trackStall: trackStall,
+		now:        time.Now,
+		cpuTime:    selfCPUTime,
+		mu:         &sync.Mutex{},
+	}
+	h.Reset()
+
+	return h
+}
+
+// Reset starts counting export failures and the pipeline stall over,
+// called when a probation window begins.
+func (h *AgentHealth) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.since = h.now()
+	h.failures = h.state.ExportFailures()
+	h.cpu, _ = h.cpuTime()
+	h.cpuAt = h.since
+}
+
+// Probe returns the current health indicators, it implements HealthProbe.
+func (h *AgentHealth) Probe() HealthIndicators {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	indicators := HealthIndicators{
+		ExportFailures: int(h.state.ExportFailures() - h.failures),
+	}
+
+	if h.trackStall {
+		last := h.state.LastExport()
+		if last.Before(h.since) {
+			last = h.since
+		}
+		indicators.PipelineStall = now.Sub(last)
+	}
+
+	if cpu, err := h.cpuTime(); err == nil {
+		if elapsed := now.Sub(h.cpuAt).Seconds(); elapsed > 0 {
+			indicators.SelfCPU = (cpu - h.cpu) / elapsed
+		}
+		h.cpu, h.cpuAt = cpu, now
+	}
+
+	return indicators
+}
+
+// selfCPUTime returns the user and system CPU time of the agent process
+// in seconds.
+func selfCPUTime() (float64, error) {
+	p, err := procfs.Self()
+	if err != nil {
+		return 0, err
+	}
+
+	stat, err := p.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	return stat.CPUTime(), nil
+}
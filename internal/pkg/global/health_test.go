@@ -0,0 +1,87 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeHealthClock struct {
+	now time.Time
+	cpu float64
+}
+
+func newTestAgentHealth(state *AgentState, trackStall bool) (*AgentHealth, *fakeHealthClock) {
+	clock := &fakeHealthClock{now: time.Unix(1650000000, 0)}
+	h := NewAgentHealth(state, trackStall)
+	h.now = func() time.Time { return clock.now }
+	h.cpuTime = func() (float64, error) { return clock.cpu, nil }
+	h.Reset()
+
+	return h, clock
+}
+
+func TestAgentHealth(t *testing.T) {
+	state := new(AgentState)
+	state.RecordExportFailure()
+	h, clock := newTestAgentHealth(state, true)
+
+	// failures before Reset don't count, the stall runs from Reset
+	clock.now = clock.now.Add(10 * time.Second)
+	clock.cpu = 2
+	require.Equal(t, HealthIndicators{PipelineStall: 10 * time.Second, SelfCPU: 0.2}, h.Probe())
+
+	state.RecordExport(clock.now)
+	state.RecordExportFailure()
+	state.RecordExportFailure()
+	clock.now = clock.now.Add(4 * time.Second)
+	clock.cpu = 6
+	require.Equal(t, HealthIndicators{PipelineStall: 4 * time.Second, ExportFailures: 2, SelfCPU: 1}, h.Probe())
+
+	h.Reset()
+	require.Equal(t, HealthIndicators{}, h.Probe())
+}
+
+func TestAgentHealth_NoStallTracking(t *testing.T) {
+	h, clock := newTestAgentHealth(new(AgentState), false)
+
+	clock.now = clock.now.Add(time.Hour)
+	require.Zero(t, h.Probe().PipelineStall)
+}
+
+func TestConfigProbation_AgentHealthRollback(t *testing.T) {
+	state := new(AgentState)
+	h, _ := newTestAgentHealth(state, false)
+	p, applied, rolledBack := newTestProbation(t, h.Probe, false)
+
+	prev := AgentConfig{}
+	prev.Runtime.Log.Lvl = "info"
+	require.NoError(t, p.Begin(context.Background(), prev, AgentConfig{}))
+
+	for i := 0; i < 6; i++ {
+		state.RecordExportFailure()
+	}
+
+	select {
+	case reasons := <-rolledBack:
+		require.Equal(t, []string{"6 export failures (max 5)"}, reasons)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for rollback")
+	}
+	require.Equal(t, "info", (<-applied).Runtime.Log.Lvl)
+}
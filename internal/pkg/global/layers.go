@@ -0,0 +1,255 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Configuration layering
+//
+// Fragments found in the conf.d directory next to the main configuration
+// file are merged over it in lexical order of their filenames. Only files
+// with a .yml or .yaml extension are considered, hidden files are ignored.
+// Merge semantics:
+//   - maps are merged recursively, keys present in a fragment override
+//     the same keys of previous layers.
+//   - lists and scalars replace the value of previous layers.
+//   - lists keyed with a "+=" suffix (i.e. "watchers+=") are appended to
+//     the list of previous layers instead of replacing it.
+//
+// The merged document is validated as a whole, an invalid fragment fails
+// the entire configuration load.
+
+const (
+	// ConfigAppendSuffix suffix of list keys appending to the list of previous layers.
+	ConfigAppendSuffix = "+="
+
+	// DefaultConfigDirPollInterval default interval for checking conf.d for changes.
+	DefaultConfigDirPollInterval = 10 * time.Second
+)
+
+var (
+	// ConfigDirName directory containing configuration fragments,
+	// relative to the main configuration file.
+	ConfigDirName = "conf.d"
+
+	// AgentConfigSources files that contributed to the loaded
+	// configuration, in the order they were merged.
+	AgentConfigSources []string
+
+	// AgentConfigDir conf.d directory of the loaded configuration.
+	AgentConfigDir string
+)
+
+// ConfigFragments returns the configuration fragments of dir in lexical
+// order. A missing directory has no fragments.
+func ConfigFragments(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	fragments := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		if ext := filepath.Ext(name); ext != ".yml" && ext != ".yaml" {
+			continue
+		}
+
+		fragments = append(fragments, filepath.Join(dir, name))
+	}
+	sort.Strings(fragments)
+
+	return fragments, nil
+}
+
+// layerConfig merges the fragments of dir over the main configuration
+// content. Returns the merged document and the fragments that were merged.
+func layerConfig(content []byte, dir string) ([]byte, []string, error) {
+	fragments, err := ConfigFragments(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(fragments) == 0 {
+		return content, nil, nil
+	}
+
+	merged := map[string]interface{}{}
+	if err := mergeConfigDocument(merged, content); err != nil {
+		return nil, nil, fmt.Errorf("main configuration: %w", err)
+	}
+
+	for _, fragment := range fragments {
+		fragmentContent, err := ioutil.ReadFile(fragment)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := mergeConfigDocument(merged, fragmentContent); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", fragment, err)
+		}
+	}
+
+	out, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return out, fragments, nil
+}
+
+func mergeConfigDocument(dst map[string]interface{}, content []byte) error {
+	var src interface{}
+	if err := yaml.Unmarshal(content, &src); err != nil {
+		return err
+	}
+
+	if src == nil {
+		// empty document
+		return nil
+	}
+
+	srcMap, ok := src.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("expected a mapping at the document root, got %T", src)
+	}
+
+	return mergeConfigMaps(dst, srcMap, "")
+}
+
+// mergeConfigMaps merges src into dst according to the layering semantics.
+func mergeConfigMaps(dst, src map[string]interface{}, path string) error {
+	keys := make([]string, 0, len(src))
+	for k := range src {
+		keys = append(keys, k)
+	}
+
+	// replace before appending, so a fragment can both reset and extend a list
+	sort.Slice(keys, func(i, j int) bool {
+		ai, aj := strings.HasSuffix(keys[i], ConfigAppendSuffix), strings.HasSuffix(keys[j], ConfigAppendSuffix)
+		if ai != aj {
+			return aj
+		}
+
+		return keys[i] < keys[j]
+	})
+
+	for _, k := range keys {
+		v := src[k]
+
+		if strings.HasSuffix(k, ConfigAppendSuffix) {
+			key := strings.TrimSpace(strings.TrimSuffix(k, ConfigAppendSuffix))
+			list, ok := v.([]interface{})
+			if !ok {
+				return fmt.Errorf("%s%s: %q must be a list", path, key, k)
+			}
+
+			switch existing := dst[key].(type) {
+			case nil:
+				dst[key] = list
+			case []interface{}:
+				dst[key] = append(existing, list...)
+			default:
+				return fmt.Errorf("%s%s: cannot append to a non-list value", path, key)
+			}
+
+			continue
+		}
+
+		if srcMap, ok := v.(map[string]interface{}); ok {
+			dstMap, ok := dst[k].(map[string]interface{})
+			if !ok {
+				dstMap = map[string]interface{}{}
+				dst[k] = dstMap
+			}
+
+			if err := mergeConfigMaps(dstMap, srcMap, path+k+"."); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		dst[k] = v
+	}
+
+	return nil
+}
+
+// WatchConfigDir polls dir every interval and calls onChange with the
+// current fragments whenever a fragment is added, removed or modified.
+// Blocks until ctx is done.
+func WatchConfigDir(ctx context.Context, dir string, interval time.Duration, onChange func(fragments []string)) {
+	if interval <= 0 {
+		interval = DefaultConfigDirPollInterval
+	}
+
+	last, _ := configDirState(dir)
+	for {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+
+		state, fragments := configDirState(dir)
+		if state == last {
+			continue
+		}
+		last = state
+
+		onChange(fragments)
+	}
+}
+
+// configDirState returns a string identifying the current fragments of
+// dir and their modification times.
+func configDirState(dir string) (string, []string) {
+	fragments, err := ConfigFragments(dir)
+	if err != nil {
+		return "error: " + err.Error(), nil
+	}
+
+	var b strings.Builder
+	for _, fragment := range fragments {
+		info, err := os.Stat(fragment)
+		if err != nil {
+			fmt.Fprintf(&b, "%s:err\n", fragment)
+
+			continue
+		}
+		fmt.Fprintf(&b, "%s:%d:%d\n", fragment, info.ModTime().UnixNano(), info.Size())
+	}
+
+	return b.String(), fragments
+}
@@ -0,0 +1,180 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeLayeredConfig(t *testing.T, main string, fragments map[string]string) string {
+	t.Helper()
+
+	// ignore env overrides leaked by other tests
+	for _, kv := range os.Environ() {
+		if k := strings.SplitN(kv, "=", 2)[0]; strings.HasPrefix(k, ConfigEnvPrefix+"_") {
+			t.Setenv(k, "")
+		}
+	}
+
+	dir := t.TempDir()
+	mainFile := filepath.Join(dir, DefaultAgentConfigName)
+	require.NoError(t, os.WriteFile(mainFile, []byte(main), 0o600))
+
+	confDir := filepath.Join(dir, ConfigDirName)
+	require.NoError(t, os.Mkdir(confDir, 0o700))
+	for name, content := range fragments {
+		require.NoError(t, os.WriteFile(filepath.Join(confDir, name), []byte(content), 0o600))
+	}
+
+	configFilePriorityWas := ConfigFilePriority
+	ConfigFilePriority = []string{mainFile}
+	t.Cleanup(func() { ConfigFilePriority = configFilePriorityWas })
+
+	return dir
+}
+
+func TestLoadAgentConfig_Layered(t *testing.T) {
+	dir := writeLayeredConfig(t, `
+platform:
+  api_key: main-key
+  addr: main.addr:443
+  batch_n: 10
+runtime:
+  sampling_interval: 30s
+  allowed_hosts: [127.0.0.1]
+  watchers:
+    - type: prometheus.proc.cpu
+`, map[string]string{
+		"10-platform.yml": `
+platform:
+  addr: fragment.addr:443
+runtime:
+  allowed_hosts: [10.0.0.1]
+`,
+		"20-watchers.yaml": `
+runtime:
+  watchers+=:
+    - type: prometheus.proc.meminfo
+`,
+		"30-more-watchers.yml": `
+runtime:
+  watchers+=:
+    - type: prometheus.proc.loadavg
+`,
+		"README.md":   "not a fragment",
+		".hidden.yml": "platform: [",
+	})
+
+	c := &AgentConfig{}
+	require.NoError(t, LoadAgentConfig(c))
+
+	// maps deep merge
+	require.Equal(t, "main-key", c.Platform.APIKey)
+	require.Equal(t, "fragment.addr:443", c.Platform.Addr)
+	require.Equal(t, 10, c.Platform.BatchN)
+	require.Equal(t, 30*time.Second, c.Runtime.SamplingInterval)
+
+	// lists replace unless appended, in lexical order
+	require.Equal(t, []string{"10.0.0.1"}, c.Runtime.AllowedHosts)
+	types := []string{}
+	for _, w := range c.Runtime.Watchers {
+		types = append(types, w.Type)
+	}
+	require.Equal(t, []string{"prometheus.proc.cpu", "prometheus.proc.meminfo", "prometheus.proc.loadavg"}, types)
+
+	require.Equal(t, []string{
+		filepath.Join(dir, DefaultAgentConfigName),
+		filepath.Join(dir, ConfigDirName, "10-platform.yml"),
+		filepath.Join(dir, ConfigDirName, "20-watchers.yaml"),
+		filepath.Join(dir, ConfigDirName, "30-more-watchers.yml"),
+	}, AgentConfigSources)
+}
+
+func TestLoadAgentConfig_LayeredInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		fragment string
+	}{
+		{name: "invalid yaml", fragment: "platform: ["},
+		{name: "not a mapping", fragment: "- foo"},
+		{name: "append non-list", fragment: "runtime:\n  watchers+=: foo\n"},
+		{name: "append to non-list", fragment: "platform+=: [foo]\n"},
+		{name: "invalid type", fragment: "platform:\n  batch_n: many\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeLayeredConfig(t, "platform:\n  batch_n: 10\n", map[string]string{
+				"10-valid.yml":   "platform:\n  addr: foo:443\n",
+				"20-invalid.yml": tt.fragment,
+			})
+
+			_, err := ValidateAgentConfig()
+			require.Error(t, err)
+
+			c := &AgentConfig{}
+			require.Error(t, LoadAgentConfig(c))
+		})
+	}
+}
+
+func TestMergeConfigMaps_ReplaceThenAppend(t *testing.T) {
+	dst := map[string]interface{}{"list": []interface{}{"a"}}
+	src := map[string]interface{}{
+		"list+=": []interface{}{"c"},
+		"list":   []interface{}{"b"},
+		"nested": map[string]interface{}{"list+=": []interface{}{"d"}},
+	}
+
+	require.NoError(t, mergeConfigMaps(dst, src, ""))
+	require.Equal(t, []interface{}{"b", "c"}, dst["list"])
+	require.Equal(t, map[string]interface{}{"list": []interface{}{"d"}}, dst["nested"])
+}
+
+func TestWatchConfigDir(t *testing.T) {
+	dir := t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan []string, 10)
+	go WatchConfigDir(ctx, dir, 10*time.Millisecond, func(fragments []string) {
+		changes <- fragments
+	})
+	time.Sleep(30 * time.Millisecond)
+
+	fragment := filepath.Join(dir, "10-foo.yml")
+	require.NoError(t, os.WriteFile(fragment, []byte("platform: {}\n"), 0o600))
+	select {
+	case got := <-changes:
+		require.Equal(t, []string{fragment}, got)
+	case <-time.After(time.Second):
+		t.Fatal("fragment addition not detected")
+	}
+
+	require.NoError(t, os.Remove(fragment))
+	select {
+	case got := <-changes:
+		require.Empty(t, got)
+	case <-time.After(time.Second):
+		t.Fatal("fragment removal not detected")
+	}
+}
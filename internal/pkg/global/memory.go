@@ -0,0 +1,241 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"fmt"
+	"io"
+	"runtime/metrics"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// heapAllocsMetric runtime/metrics cumulative heap allocated bytes.
+const heapAllocsMetric = "/gc/heap/allocs:bytes"
+
+// MemoryReporter is implemented by stateful components (buffers, caches)
+// to report the bytes they retain.
+type MemoryReporter interface {
+	MemoryBytes() int64
+}
+
+// MemoryReporterFunc adapts a function to a MemoryReporter.
+type MemoryReporterFunc func() int64
+
+// MemoryBytes returns f().
+func (f MemoryReporterFunc) MemoryBytes() int64 {
+	return f()
+}
+
+// ComponentMemory memory attributed to a single component.
+type ComponentMemory struct {
+	Component string
+
+	// RetainedBytes bytes currently held by the component, as reported
+	// by its MemoryReporter.
+	RetainedBytes int64
+
+	// AllocatedBytes bytes allocated while the component was executing.
+	AllocatedBytes uint64
+}
+
+// MemoryAccounting attributes memory to agent components. Retained bytes
+// come from each component's MemoryReporter, allocated bytes are sampled
+// around the component's execution.
+type MemoryAccounting struct {
+	mu        *sync.Mutex
+	reporters map[string]*memoryReporter
+	allocs    map[string]uint64
+
+	retainedDesc  *prometheus.Desc
+	allocatedDesc *prometheus.Desc
+}
+
+// memoryReporter registration entry, compared by identity on unregister
+// since reporters may be uncomparable (i.e. MemoryReporterFunc).
+type memoryReporter struct {
+	MemoryReporter
+}
+
+// DefaultMemoryAccounting the agent-wide memory accounting, exposed as
+// self-metrics.
+var DefaultMemoryAccounting = NewMemoryAccounting()
+
+func init() {
+	prometheus.MustRegister(DefaultMemoryAccounting)
+}
+
+// NewMemoryAccounting MemoryAccounting constructor.
+func NewMemoryAccounting() *MemoryAccounting {
+	return &MemoryAccounting{
+		mu:        new(sync.Mutex),
+		reporters: map[string]*memoryReporter{},
+		allocs:    map[string]uint64{},
+		retainedDesc: prometheus.NewDesc(
+			"agent_component_retained_bytes",
+			"Bytes retained by an agent component, i.e. buffered data or caches.",
+			[]string{"component"}, nil,
+		),
+		allocatedDesc: prometheus.NewDesc(
+			"agent_component_allocated_bytes_total",
+			"Bytes allocated while an agent component was executing, approximated from process-wide allocations.",
+			[]string{"component"}, nil,
+		),
+	}
+}
+
+// RegisterMemoryReporter registers r as the reporter of component's
+// retained memory, replacing any previous one. The returned function
+// unregisters it.
+func (m *MemoryAccounting) RegisterMemoryReporter(component string, r MemoryReporter) func() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := &memoryReporter{r}
+	m.reporters[component] = entry
+
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if m.reporters[component] == entry {
+			delete(m.reporters, component)
+		}
+	}
+}
+
+// AccountAllocations runs fn and attributes the bytes allocated meanwhile
+// to component. The Go runtime does not track allocations per goroutine,
+// the sample is process-wide: allocations made concurrently by other
+// goroutines are attributed as well, which evens out over many samples.
+func (m *MemoryAccounting) AccountAllocations(component string, fn func()) {
+	before := heapAllocatedBytes()
+	fn()
+	after := heapAllocatedBytes()
+
+	if after <= before {
+		return
+	}
+
+	m.mu.Lock()
+	m.allocs[component] += after - before
+	m.mu.Unlock()
+}
+
+func heapAllocatedBytes() uint64 {
+	sample := []metrics.Sample{{Name: heapAllocsMetric}}
+	metrics.Read(sample)
+
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+
+	return sample[0].Value.Uint64()
+}
+
+// Report returns the memory attributed to each component, ranked by
+// retained then allocated bytes.
+func (m *MemoryAccounting) Report() []ComponentMemory {
+	m.mu.Lock()
+	reporters := make(map[string]MemoryReporter, len(m.reporters))
+	for component, r := range m.reporters {
+		reporters[component] = r
+	}
+	report := make(map[string]*ComponentMemory, len(m.reporters)+len(m.allocs))
+	for component, allocated := range m.allocs {
+		report[component] = &ComponentMemory{Component: component, AllocatedBytes: allocated}
+	}
+	m.mu.Unlock()
+
+	// reporters take their own locks, call them unlocked
+	for component, r := range reporters {
+		cm, ok := report[component]
+		if !ok {
+			cm = &ComponentMemory{Component: component}
+			report[component] = cm
+		}
+		cm.RetainedBytes = r.MemoryBytes()
+	}
+
+	res := make([]ComponentMemory, 0, len(report))
+	for _, cm := range report {
+		res = append(res, *cm)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].RetainedBytes != res[j].RetainedBytes {
+			return res[i].RetainedBytes > res[j].RetainedBytes
+		}
+		if res[i].AllocatedBytes != res[j].AllocatedBytes {
+			return res[i].AllocatedBytes > res[j].AllocatedBytes
+		}
+
+		return res[i].Component < res[j].Component
+	})
+
+	return res
+}
+
+// WriteReport writes the ranked memory report as a table.
+func (m *MemoryAccounting) WriteReport(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "RANK\tRETAINED\tALLOCATED\tCOMPONENT\t")
+	for i, cm := range m.Report() {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t\n", i+1, formatBytes(cm.RetainedBytes), formatBytes(int64(cm.AllocatedBytes)), cm.Component)
+	}
+
+	return tw.Flush()
+}
+
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+// Describe implements prometheus.Collector.
+func (m *MemoryAccounting) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.retainedDesc
+	ch <- m.allocatedDesc
+}
+
+// Collect implements prometheus.Collector.
+func (m *MemoryAccounting) Collect(ch chan<- prometheus.Metric) {
+	for _, cm := range m.Report() {
+		ch <- prometheus.MustNewConstMetric(m.retainedDesc, prometheus.GaugeValue, float64(cm.RetainedBytes), cm.Component)
+		ch <- prometheus.MustNewConstMetric(m.allocatedDesc, prometheus.CounterValue, float64(cm.AllocatedBytes), cm.Component)
+	}
+}
+
+// RegisterMemoryReporter registers r with DefaultMemoryAccounting.
+func RegisterMemoryReporter(component string, r MemoryReporter) func() {
+	return DefaultMemoryAccounting.RegisterMemoryReporter(component, r)
+}
+
+// AccountAllocations runs fn, accounted with DefaultMemoryAccounting.
+func AccountAllocations(component string, fn func()) {
+	DefaultMemoryAccounting.AccountAllocations(component, fn)
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+var memorySink []byte
+
+func TestMemoryAccounting(t *testing.T) {
+	m := NewMemoryAccounting()
+
+	m.RegisterMemoryReporter("buffer", MemoryReporterFunc(func() int64 { return 4096 }))
+	unregister := m.RegisterMemoryReporter("cache", MemoryReporterFunc(func() int64 { return 1 << 20 }))
+	m.AccountAllocations("collector", func() {
+		memorySink = make([]byte, 1<<16)
+	})
+
+	report := m.Report()
+	require.Len(t, report, 3)
+	require.Equal(t, ComponentMemory{Component: "cache", RetainedBytes: 1 << 20}, report[0])
+	require.Equal(t, ComponentMemory{Component: "buffer", RetainedBytes: 4096}, report[1])
+	require.Equal(t, "collector", report[2].Component)
+	require.GreaterOrEqual(t, report[2].AllocatedBytes, uint64(1<<16))
+
+	var out bytes.Buffer
+	require.NoError(t, m.WriteReport(&out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 4)
+	require.Contains(t, lines[0], "RETAINED")
+	require.Contains(t, lines[1], "1.0MiB")
+	require.True(t, strings.HasSuffix(lines[1], "cache"))
+	require.Contains(t, lines[2], "4.0KiB")
+
+	require.Equal(t, 6, testutil.CollectAndCount(m))
+
+	unregister()
+	require.Len(t, m.Report(), 2)
+}
+
+func TestFormatBytes(t *testing.T) {
+	require.Equal(t, "0B", formatBytes(0))
+	require.Equal(t, "1023B", formatBytes(1023))
+	require.Equal(t, "1.5KiB", formatBytes(1536))
+	require.Equal(t, "2.0GiB", formatBytes(2<<30))
+}
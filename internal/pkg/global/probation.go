@@ -0,0 +1,422 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"agent/internal/pkg/fsutil"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	yaml "gopkg.in/yaml.v3"
+)
+
+var (
+	// DefaultPreviousConfigFilename filename used to persist the last known
+	// good configuration under the agent cache directory.
+	DefaultPreviousConfigFilename = "agent.prev.yml"
+
+	// DefaultRollbackRecordFilename filename used to record the last
+	// rollback under the agent cache directory.
+	DefaultRollbackRecordFilename = "agent.rollback.yml"
+
+	// DefaultConfigProbationCheckInterval how often health indicators
+	// are checked during a probation window.
+	DefaultConfigProbationCheckInterval = 10 * time.Second
+
+	// ErrNoPreviousConfig no configuration is available to roll back to.
+	ErrNoPreviousConfig = errors.New("no previous configuration available")
+
+	// ErrNoRollbackRecord no rollback has been recorded.
+	ErrNoRollbackRecord = errors.New("no rollback recorded")
+)
+
+// HealthIndicators the agent's self-reported health used to
+// judge a newly applied configuration.
+type HealthIndicators struct {
+	// PipelineStall time since data last left the pipeline.
+	PipelineStall time.Duration
+
+	// ExportFailures failed export attempts since the probation started.
+	ExportFailures int
+
+	// SelfCPU agent CPU usage, where 1.0 is a full core.
+	SelfCPU float64
+}
+
+// HealthProbe returns the current agent health indicators.
+type HealthProbe func() HealthIndicators
+
+// ConfigProbationConf ConfigProbation configuration.
+type ConfigProbationConf struct {
+	ProbationConfig
+
+	// Probe samples the agent health indicators.
+	Probe HealthProbe
+
+	// Apply applies a configuration to the running agent. Used
+	// for rolling back to the previous configuration.
+	Apply func(AgentConfig) error
+
+	// OnRollback is called after a successful rollback with the
+	// reasons that triggered it (i.e. to emit an event).
+	OnRollback func(reasons []string)
+
+	// PreviousConfigPath file to persist the previous configuration to.
+	// Persistence is disabled if empty.
+	PreviousConfigPath string
+
+	// RollbackRecordPath file to record rollbacks to, so that a restart
+	// doesn't apply the rejected configuration again. Recording is
+	// disabled if empty.
+	RollbackRecordPath string
+
+	// CheckInterval how often the health probe is checked.
+	CheckInterval time.Duration
+}
+
+// ConfigProbation monitors the agent health for a period of time after a
+// configuration reload and rolls back to the previous configuration if
+// health degrades beyond the configured thresholds.
+//
+// Only the settings Apply knows how to change are rolled back at runtime.
+// The rejected configuration is recorded, and RestoreRolledBackConfig
+// replaces it with the full previous configuration on the next start.
+type ConfigProbation struct {
+	ConfigProbationConf
+
+	mu          *sync.Mutex
+	previous    *AgentConfig
+	applied     *AgentConfig
+	cancel      context.CancelFunc
+	rollingBack bool
+}
+
+// NewConfigProbation returns a new ConfigProbation instance.
+func NewConfigProbation(conf ConfigProbationConf) *ConfigProbation {
+	if conf.CheckInterval == 0 {
+		conf.CheckInterval = DefaultConfigProbationCheckInterval
+	}
+
+	return &ConfigProbation{ConfigProbationConf: conf, mu: &sync.Mutex{}}
+}
+
+// Begin starts a probation window for the newly applied configuration. The
+// previous configuration is kept in memory and on disk for rolling back.
+// Begin is a no-op while a rollback is in progress, so reapplying the
+// previous configuration never triggers a new probation.
+func (p *ConfigProbation) Begin(ctx context.Context, previous, applied AgentConfig) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.rollingBack {
+		return nil
+	}
+
+	if p.cancel != nil {
+		// a reload during probation, previous config is still
+		// the last one that passed probation.
+		p.cancel()
+	} else {
+		if err := p.persist(previous); err != nil {
+			return err
+		}
+		p.previous = &previous
+	}
+	p.applied = &applied
+
+	ctx, p.cancel = context.WithCancel(ctx)
+	go p.run(ctx)
+
+	return nil
+}
+
+// Previous returns the configuration kept for rolling back, if any.
+func (p *ConfigProbation) Previous() (*AgentConfig, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.previous == nil {
+		return nil, ErrNoPreviousConfig
+	}
+
+	return p.previous, nil
+}
+
+// Active returns true if a probation window is in progress.
+func (p *ConfigProbation) Active() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.cancel != nil
+}
+
+// Stop ends the current probation window without rolling back.
+func (p *ConfigProbation) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stop()
+}
+
+func (p *ConfigProbation) stop() {
+	if p.cancel != nil {
+		p.cancel()
+		p.cancel = nil
+	}
+}
+
+func (p *ConfigProbation) run(ctx context.Context) {
+	deadline := time.After(p.Period)
+	ticker := time.NewTicker(p.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline:
+			p.mu.Lock()
+			if ctx.Err() == nil {
+				zap.S().Infow("configuration passed probation", "period", p.Period)
+				p.stop()
+				p.clearRecord()
+			}
+			p.mu.Unlock()
+
+			return
+		case <-ticker.C:
+			reasons := p.check(p.Probe())
+			if len(reasons) == 0 {
+				continue
+			}
+
+			if p.DisableAutoRollback {
+				zap.S().Warnw("agent health degraded after configuration reload, auto-rollback is disabled", "reasons", reasons)
+				continue
+			}
+
+			p.rollback(ctx, reasons)
+
+			return
+		}
+	}
+}
+
+// check returns the list of thresholds exceeded by h.
+func (p *ConfigProbation) check(h HealthIndicators) []string {
+	reasons := []string{}
+
+	if p.MaxPipelineStall > 0 && h.PipelineStall > p.MaxPipelineStall {
+		reasons = append(reasons, fmt.Sprintf("pipeline stalled for %v (max %v)", h.PipelineStall, p.MaxPipelineStall))
+	}
+
+	if p.MaxExportFailures > 0 && h.ExportFailures > p.MaxExportFailures {
+		reasons = append(reasons, fmt.Sprintf("%d export failures (max %d)", h.ExportFailures, p.MaxExportFailures))
+	}
+
+	if p.MaxSelfCPU > 0 && h.SelfCPU > p.MaxSelfCPU {
+		reasons = append(reasons, fmt.Sprintf("self cpu usage %.2f (max %.2f)", h.SelfCPU, p.MaxSelfCPU))
+	}
+
+	return reasons
+}
+
+func (p *ConfigProbation) rollback(ctx context.Context, reasons []string) {
+	p.mu.Lock()
+	if ctx.Err() != nil {
+		// probation ended or restarted by a newer reload
+		p.mu.Unlock()
+		return
+	}
+	previous, applied := p.previous, p.applied
+	p.stop()
+	p.rollingBack = true
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		p.rollingBack = false
+		p.mu.Unlock()
+	}()
+
+	if previous == nil {
+		zap.S().Errorw("agent health degraded after configuration reload, cannot roll back", "reasons", reasons, zap.Error(ErrNoPreviousConfig))
+		return
+	}
+
+	zap.S().Warnw("agent health degraded after configuration reload, rolling back", "reasons", reasons)
+	if err := p.Apply(*previous); err != nil {
+		zap.S().Errorw("configuration rollback failed", zap.Error(err))
+		return
+	}
+
+	if err := p.record(applied, reasons); err != nil {
+		zap.S().Errorw("error recording configuration rollback", zap.Error(err))
+	}
+
+	if p.OnRollback != nil {
+		p.OnRollback(reasons)
+	}
+}
+
+// persist writes c to PreviousConfigPath, replacing any existing file atomically.
+func (p *ConfigProbation) persist(c AgentConfig) error {
+	if p.PreviousConfigPath == "" {
+		return nil
+	}
+
+	return errors.Wrap(writeYAMLAtomic(p.PreviousConfigPath, c), "error persisting previous configuration")
+}
+
+// record writes a RollbackRecord of the rejected configuration to RollbackRecordPath.
+func (p *ConfigProbation) record(rejected *AgentConfig, reasons []string) error {
+	if p.RollbackRecordPath == "" || rejected == nil {
+		return nil
+	}
+
+	digest, err := ConfigDigest(*rejected)
+	if err != nil {
+		return err
+	}
+
+	r := RollbackRecord{At: time.Now(), Reasons: reasons, Rejected: digest}
+
+	return writeYAMLAtomic(p.RollbackRecordPath, r)
+}
+
+// clearRecord removes the rollback record, the current configuration
+// having passed probation.
+func (p *ConfigProbation) clearRecord() {
+	if p.RollbackRecordPath == "" {
+		return
+	}
+
+	if err := os.Remove(p.RollbackRecordPath); err != nil && !os.IsNotExist(err) {
+		zap.S().Warnw("error removing configuration rollback record", zap.Error(err))
+	}
+}
+
+// writeYAMLAtomic writes v as YAML to path, replacing any existing file atomically.
+func writeYAMLAtomic(path string, v interface{}) error {
+	content, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return fsutil.WriteFileAtomic(path, content)
+}
+
+// LoadPreviousConfig loads a configuration persisted by ConfigProbation.
+func LoadPreviousConfig(path string) (*AgentConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoPreviousConfig
+		}
+		return nil, err
+	}
+
+	c := &AgentConfig{}
+	if err := yaml.Unmarshal(content, c); err != nil {
+		return nil, errors.Wrap(err, "error parsing previous configuration")
+	}
+
+	return c, nil
+}
+
+// RollbackRecord a configuration rejected by ConfigProbation.
+type RollbackRecord struct {
+	// At time of the rollback.
+	At time.Time `yaml:"at"`
+
+	// Reasons health thresholds exceeded by the rejected configuration.
+	Reasons []string `yaml:"reasons"`
+
+	// Rejected ConfigDigest of the rejected configuration.
+	Rejected string `yaml:"rejected"`
+}
+
+// LoadRollbackRecord loads a rollback recorded by ConfigProbation.
+func LoadRollbackRecord(path string) (*RollbackRecord, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoRollbackRecord
+		}
+		return nil, err
+	}
+
+	r := &RollbackRecord{}
+	if err := yaml.Unmarshal(content, r); err != nil {
+		return nil, errors.Wrap(err, "error parsing rollback record")
+	}
+
+	return r, nil
+}
+
+// ConfigDigest returns a digest identifying the configuration c.
+func ConfigDigest(c AgentConfig) (string, error) {
+	content, err := yaml.Marshal(c)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshaling configuration")
+	}
+	sum := sha256.Sum256(content)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// RestoreRolledBackConfig replaces c with the previous configuration
+// persisted at previousPath if c is the configuration last rejected by
+// ConfigProbation, as recorded at recordPath, so that a restart doesn't
+// apply it again. The record is removed once the configuration changed.
+// Returns the rollback record if c was replaced.
+func RestoreRolledBackConfig(c *AgentConfig, recordPath, previousPath string) (*RollbackRecord, error) {
+	record, err := LoadRollbackRecord(recordPath)
+	if err != nil {
+		if errors.Is(err, ErrNoRollbackRecord) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	digest, err := ConfigDigest(*c)
+	if err != nil {
+		return nil, err
+	}
+
+	if digest != record.Rejected {
+		// configuration was changed since the rollback
+		if err := os.Remove(recordPath); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	previous, err := LoadPreviousConfig(previousPath)
+	if err != nil {
+		return nil, err
+	}
+	*c = *previous
+
+	return record, nil
+}
@@ -0,0 +1,200 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestProbation(t *testing.T, probe HealthProbe, disableRollback bool) (*ConfigProbation, chan AgentConfig, chan []string) {
+	applied := make(chan AgentConfig, 10)
+	rolledBack := make(chan []string, 10)
+
+	dir := t.TempDir()
+	var p *ConfigProbation
+	p = NewConfigProbation(ConfigProbationConf{
+		ProbationConfig: ProbationConfig{
+			DisableAutoRollback: disableRollback,
+			Period:              500 * time.Millisecond,
+			MaxPipelineStall:    time.Minute,
+			MaxExportFailures:   5,
+			MaxSelfCPU:          0.5,
+		},
+		Probe: probe,
+		Apply: func(c AgentConfig) error {
+			// a reload path would start a new probation on every applied
+			// configuration, including rollbacks.
+			require.NoError(t, p.Begin(context.Background(), c, c))
+			applied <- c
+			return nil
+		},
+		OnRollback: func(reasons []string) {
+			rolledBack <- reasons
+		},
+		PreviousConfigPath: filepath.Join(dir, DefaultPreviousConfigFilename),
+		RollbackRecordPath: filepath.Join(dir, DefaultRollbackRecordFilename),
+		CheckInterval:      10 * time.Millisecond,
+	})
+
+	return p, applied, rolledBack
+}
+
+func TestConfigProbation_Rollback(t *testing.T) {
+	probe := func() HealthIndicators {
+		return HealthIndicators{PipelineStall: 2 * time.Minute, ExportFailures: 10}
+	}
+	p, applied, rolledBack := newTestProbation(t, probe, false)
+
+	prev := AgentConfig{}
+	prev.Runtime.SamplingInterval = 15 * time.Second
+	rejected := AgentConfig{}
+	rejected.Runtime.SamplingInterval = time.Second
+	require.NoError(t, p.Begin(context.Background(), prev, rejected))
+
+	select {
+	case reasons := <-rolledBack:
+		require.Len(t, reasons, 2)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for rollback")
+	}
+
+	c := <-applied
+	require.Equal(t, 15*time.Second, c.Runtime.SamplingInterval)
+
+	// applying the previous config must not start a new probation
+	require.False(t, p.Active())
+	require.Len(t, applied, 0)
+
+	persisted, err := LoadPreviousConfig(p.PreviousConfigPath)
+	require.NoError(t, err)
+	require.Equal(t, 15*time.Second, persisted.Runtime.SamplingInterval)
+
+	// a restart with the rejected configuration starts with the previous one
+	record, err := RestoreRolledBackConfig(&rejected, p.RollbackRecordPath, p.PreviousConfigPath)
+	require.NoError(t, err)
+	require.NotNil(t, record)
+	require.Len(t, record.Reasons, 2)
+	require.Equal(t, 15*time.Second, rejected.Runtime.SamplingInterval)
+}
+
+func TestConfigProbation_Passed(t *testing.T) {
+	p, _, rolledBack := newTestProbation(t, func() HealthIndicators { return HealthIndicators{} }, false)
+
+	require.NoError(t, p.Begin(context.Background(), AgentConfig{}, AgentConfig{}))
+	require.True(t, p.Active())
+
+	require.Eventually(t, func() bool { return !p.Active() }, time.Second, 10*time.Millisecond)
+	require.Len(t, rolledBack, 0)
+	_, err := LoadRollbackRecord(p.RollbackRecordPath)
+	require.ErrorIs(t, err, ErrNoRollbackRecord)
+}
+
+func TestConfigProbation_AutoRollbackDisabled(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		samples int
+	)
+	probe := func() HealthIndicators {
+		mu.Lock()
+		defer mu.Unlock()
+		samples++
+
+		return HealthIndicators{SelfCPU: 1}
+	}
+	p, applied, rolledBack := newTestProbation(t, probe, true)
+
+	require.NoError(t, p.Begin(context.Background(), AgentConfig{}, AgentConfig{}))
+	require.Eventually(t, func() bool { return !p.Active() }, time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	require.Greater(t, samples, 0)
+	mu.Unlock()
+	require.Len(t, applied, 0)
+	require.Len(t, rolledBack, 0)
+}
+
+func TestConfigProbation_ReloadKeepsLastGood(t *testing.T) {
+	p, _, _ := newTestProbation(t, func() HealthIndicators { return HealthIndicators{} }, false)
+	defer p.Stop()
+
+	good := AgentConfig{}
+	good.Runtime.NTPServer = "good"
+	unproven := AgentConfig{}
+	unproven.Runtime.NTPServer = "unproven"
+	require.NoError(t, p.Begin(context.Background(), good, unproven))
+
+	next := AgentConfig{}
+	next.Runtime.NTPServer = "next"
+	require.NoError(t, p.Begin(context.Background(), unproven, next))
+
+	prev, err := p.Previous()
+	require.NoError(t, err)
+	require.Equal(t, "good", prev.Runtime.NTPServer)
+}
+
+func TestLoadPreviousConfig_Missing(t *testing.T) {
+	_, err := LoadPreviousConfig(filepath.Join(t.TempDir(), "missing.yml"))
+	require.ErrorIs(t, err, ErrNoPreviousConfig)
+}
+
+func TestRestoreRolledBackConfig(t *testing.T) {
+	rejected := AgentConfig{}
+	rejected.Runtime.NTPServer = "rejected"
+	previous := AgentConfig{}
+	previous.Runtime.NTPServer = "previous"
+	changed := AgentConfig{}
+	changed.Runtime.NTPServer = "changed"
+
+	tests := []struct {
+		name       string
+		config     AgentConfig
+		record     bool
+		expNTP     string
+		expRecord  bool
+		expRemoved bool
+	}{
+		{name: "no rollback recorded", config: rejected, expNTP: "rejected"},
+		{name: "rejected configuration", config: rejected, record: true, expNTP: "previous", expRecord: true},
+		{name: "configuration changed", config: changed, record: true, expNTP: "changed", expRemoved: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			recordPath := filepath.Join(dir, DefaultRollbackRecordFilename)
+			previousPath := filepath.Join(dir, DefaultPreviousConfigFilename)
+			require.NoError(t, writeYAMLAtomic(previousPath, previous))
+			if tt.record {
+				digest, err := ConfigDigest(rejected)
+				require.NoError(t, err)
+				require.NoError(t, writeYAMLAtomic(recordPath, RollbackRecord{At: time.Now(), Rejected: digest}))
+			}
+
+			c := tt.config
+			record, err := RestoreRolledBackConfig(&c, recordPath, previousPath)
+			require.NoError(t, err)
+			require.Equal(t, tt.expRecord, record != nil)
+			require.Equal(t, tt.expNTP, c.Runtime.NTPServer)
+			if tt.expRemoved {
+				require.NoFileExists(t, recordPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"agent/api/v1/model"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultShutdownReportFilename file under the agent cache directory
+	// holding the report of the last graceful shutdown.
+	DefaultShutdownReportFilename = "shutdown.json"
+
+	// DefaultRunningFlagFilename file under the agent cache directory
+	// flagging a running agent. Left behind by unclean shutdowns.
+	DefaultRunningFlagFilename = "running"
+)
+
+var (
+	panicHandler   func(recovered interface{})
+	panicHandlerMu = &sync.RWMutex{}
+)
+
+func init() {
+	RegisterStateMigration(StateMigration{
+		Component: "shutdown",
+		Version:   1,
+		Migrate: func(dir string) error {
+			if err := ImportLegacyStateFile(dir, DefaultShutdownReportFilename); err != nil {
+				return err
+			}
+
+			return ImportLegacyStateFile(dir, DefaultRunningFlagFilename)
+		},
+	})
+	RegisterCloneReset(CloneReset{
+		Component: "shutdown",
+		Patterns:  []string{DefaultShutdownReportFilename, DefaultRunningFlagFilename},
+	})
+}
+
+// SetPanicHandler sets the function ReportPanic calls with the value of a
+// recovered panic, i.e. to write the shutdown report.
+func SetPanicHandler(f func(recovered interface{})) {
+	panicHandlerMu.Lock()
+	defer panicHandlerMu.Unlock()
+
+	panicHandler = f
+}
+
+// ReportPanic is deferred by long-lived goroutines to pass a panic to the
+// handler set with SetPanicHandler. It panics again, so the agent still
+// crashes with the original stack trace.
+func ReportPanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	panicHandlerMu.RLock()
+	handler := panicHandler
+	panicHandlerMu.RUnlock()
+
+	if handler != nil {
+		handler(r)
+	}
+
+	panic(r)
+}
+
+// ShutdownReason reason of an agent shutdown.
+type ShutdownReason string
+
+const (
+	// ShutdownReasonSignal the agent received a termination signal.
+	ShutdownReasonSignal ShutdownReason = "signal"
+
+	// ShutdownReasonPanic the agent panicked, in its main goroutine or in
+	// a goroutine deferring ReportPanic. A panic in any other goroutine
+	// leaves no report and shows as an unclean shutdown on the next start.
+	ShutdownReasonPanic ShutdownReason = "panic"
+
+	// ShutdownReasonCommand the agent was asked to exit by a command.
+	ShutdownReasonCommand ShutdownReason = "command"
+)
+
+// ShutdownReport state of the agent on a graceful shutdown, persisted
+// for postmortems.
+type ShutdownReport struct {
+	Reason    ShutdownReason `json:"reason"`
+	Detail    string         `json:"detail,omitempty"`
+	Version   string         `json:"version"`
+	StartedAt time.Time      `json:"started_at"`
+	StoppedAt time.Time      `json:"stopped_at"`
+
+	// BufferDepths number of buffered messages per buffer.
+	BufferDepths map[string]int `json:"buffer_depths,omitempty"`
+
+	// Unflushed number of messages not yet handled per sink.
+	Unflushed map[string]int `json:"unflushed,omitempty"`
+
+	// NodeStatus last node status event observed.
+	NodeStatus string `json:"node_status,omitempty"`
+
+	// Heartbeat context of the last agent.up event.
+	Heartbeat map[string]interface{} `json:"heartbeat,omitempty"`
+}
+
+// Uptime returns how long the agent had been up.
+func (r *ShutdownReport) Uptime() time.Duration {
+	return r.StoppedAt.Sub(r.StartedAt)
+}
+
+// EventContext returns the report as agent.previous_shutdown event context.
+func (r *ShutdownReport) EventContext() map[string]interface{} {
+	ctx := map[string]interface{}{
+		model.ShutdownReasonKey: string(r.Reason),
+		model.ShutdownAtKey:     r.StoppedAt.UTC().Format(time.RFC3339),
+		model.AgentUptimeKey:    r.Uptime().String(),
+		model.AgentVersionKey:   r.Version,
+	}
+
+	if r.Detail != "" {
+		ctx[model.ShutdownDetailKey] = r.Detail
+	}
+
+	if r.NodeStatus != "" {
+		ctx[model.NodeStatusKey] = r.NodeStatus
+	}
+
+	if len(r.BufferDepths) > 0 {
+		ctx[model.BufferDepthsKey] = countsToCtx(r.BufferDepths)
+	}
+
+	if len(r.Unflushed) > 0 {
+		ctx[model.UnflushedKey] = countsToCtx(r.Unflushed)
+	}
+
+	if len(r.Heartbeat) > 0 {
+		ctx[model.HeartbeatKey] = r.Heartbeat
+	}
+
+	return ctx
+}
+
+// countsToCtx converts counts to a map supported by event values.
+func countsToCtx(counts map[string]int) map[string]interface{} {
+	ctx := make(map[string]interface{}, len(counts))
+	for k, v := range counts {
+		ctx[k] = v
+	}
+
+	return ctx
+}
+
+// ShutdownReportPath returns the path of the shutdown report under dir.
+func ShutdownReportPath(dir string) string {
+	return filepath.Join(dir, DefaultShutdownReportFilename)
+}
+
+// WriteShutdownReport persists the report under dir and clears the
+// running flag, marking the shutdown as clean.
+func WriteShutdownReport(dir string, report *ShutdownReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first, a partially written report
+	// must not replace the previous one.
+	path := ShutdownReportPath(dir)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return errors.Wrap(err, "error writing shutdown report")
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.Wrap(err, "error writing shutdown report")
+	}
+
+	if err := os.Remove(filepath.Join(dir, DefaultRunningFlagFilename)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return errors.Wrap(err, "error clearing running flag")
+	}
+
+	return nil
+}
+
+// ReadShutdownReport reads the shutdown report persisted under dir.
+func ReadShutdownReport(dir string) (*ShutdownReport, error) {
+	data, err := os.ReadFile(ShutdownReportPath(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ShutdownReport{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, errors.Wrap(err, "error parsing shutdown report")
+	}
+
+	return report, nil
+}
+
+// RecoverShutdownState checks how the previous agent run under dir shut
+// down and sets the running flag for the current one. It returns the
+// previous run's report if it shut down gracefully, or unclean true if it
+// left its running flag behind. Both are empty on the first run.
+func RecoverShutdownState(dir string) (report *ShutdownReport, unclean bool, err error) {
+	flag := filepath.Join(dir, DefaultRunningFlagFilename)
+
+	_, err = os.Stat(flag)
+	switch {
+	case err == nil:
+		// the report on disk, if any, belongs to an older run
+		unclean = true
+	case errors.Is(err, fs.ErrNotExist):
+		report, err = ReadShutdownReport(dir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, false, err
+		}
+	default:
+		return nil, false, err
+	}
+
+	if err := os.WriteFile(flag, []byte(time.Now().UTC().Format(time.RFC3339)), 0o644); err != nil {
+		return nil, false, errors.Wrap(err, "error setting running flag")
+	}
+
+	return report, unclean, nil
+}
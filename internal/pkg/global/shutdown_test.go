@@ -0,0 +1,126 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"agent/api/v1/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoverShutdownState(t *testing.T) {
+	dir := t.TempDir()
+	flag := filepath.Join(dir, DefaultRunningFlagFilename)
+
+	// first run
+	report, unclean, err := RecoverShutdownState(dir)
+	require.NoError(t, err)
+	require.Nil(t, report)
+	require.False(t, unclean)
+	require.FileExists(t, flag)
+
+	// graceful shutdown
+	started := time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC)
+	written := &ShutdownReport{
+		Reason:       ShutdownReasonSignal,
+		Detail:       "terminated",
+		Version:      "v1.0.0",
+		StartedAt:    started,
+		StoppedAt:    started.Add(90 * time.Minute),
+		BufferDepths: map[string]int{"platform": 12},
+		Unflushed:    map[string]int{"*publisher.Publisher": 3},
+		NodeStatus:   model.AgentNodeUpName,
+		Heartbeat:    map[string]interface{}{model.AgentVersionKey: "v1.0.0"},
+	}
+	require.NoError(t, WriteShutdownReport(dir, written))
+	require.NoFileExists(t, flag)
+
+	report, unclean, err = RecoverShutdownState(dir)
+	require.NoError(t, err)
+	require.False(t, unclean)
+	require.Equal(t, written, report)
+	require.Equal(t, 90*time.Minute, report.Uptime())
+
+	// unclean shutdown, the flag is left behind
+	report, unclean, err = RecoverShutdownState(dir)
+	require.NoError(t, err)
+	require.True(t, unclean)
+	require.Nil(t, report)
+	require.FileExists(t, flag)
+}
+
+func TestRecoverShutdownState_CorruptReport(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(ShutdownReportPath(dir), []byte("{"), 0o644))
+
+	_, _, err := RecoverShutdownState(dir)
+	require.Error(t, err)
+}
+
+func TestShutdownReport_EventContext(t *testing.T) {
+	started := time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC)
+	report := &ShutdownReport{
+		Reason:       ShutdownReasonSignal,
+		Detail:       "interrupt",
+		Version:      "v1.0.0",
+		StartedAt:    started,
+		StoppedAt:    started.Add(time.Hour),
+		BufferDepths: map[string]int{"platform": 12},
+		NodeStatus:   model.AgentNodeDownName,
+	}
+
+	ev, err := model.NewWithCtx(report.EventContext(), model.AgentPreviousShutdownName, time.Now())
+	require.NoError(t, err)
+
+	values := ev.Values.AsMap()
+	require.Equal(t, "signal", values[model.ShutdownReasonKey])
+	require.Equal(t, "interrupt", values[model.ShutdownDetailKey])
+	require.Equal(t, "2022-06-01T11:00:00Z", values[model.ShutdownAtKey])
+	require.Equal(t, "1h0m0s", values[model.AgentUptimeKey])
+	require.Equal(t, model.AgentNodeDownName, values[model.NodeStatusKey])
+	require.Equal(t, map[string]interface{}{"platform": float64(12)}, values[model.BufferDepthsKey])
+	require.NotContains(t, values, model.UnflushedKey)
+	require.NotContains(t, values, model.HeartbeatKey)
+}
+
+func TestReportPanic(t *testing.T) {
+	var reported interface{}
+	SetPanicHandler(func(r interface{}) { reported = r })
+	defer SetPanicHandler(nil)
+
+	var recovered interface{}
+	func() {
+		defer func() { recovered = recover() }()
+
+		func() {
+			defer ReportPanic()
+			panic("boom")
+		}()
+	}()
+
+	require.Equal(t, "boom", reported)
+	// the panic goes on once reported
+	require.Equal(t, "boom", recovered)
+
+	reported = nil
+	func() {
+		defer ReportPanic()
+	}()
+	require.Nil(t, reported)
+}
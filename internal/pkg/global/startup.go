@@ -0,0 +1,176 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FailureKind category of a startup failure.
+type FailureKind string
+
+const (
+	// FailureConfig the configuration is invalid.
+	FailureConfig FailureKind = "config"
+
+	// FailureCapability a configured capability cannot work on this host,
+	// i.e. missing permission or docker socket.
+	FailureCapability FailureKind = "capability"
+
+	// FailureNetwork a remote endpoint is unreachable, possibly transient.
+	FailureNetwork FailureKind = "network"
+)
+
+const (
+	// ExitConfigError exit code of a strict startup failing on a config error.
+	ExitConfigError = 3
+
+	// ExitCapabilityError exit code of a strict startup failing on a
+	// capability error, and no config error.
+	ExitCapabilityError = 4
+
+	// ExitNetworkError exit code of a strict startup failing on network
+	// errors only.
+	ExitNetworkError = 5
+
+	// DefaultStartupReportFilename file under the state directory the
+	// strict startup report is written to.
+	DefaultStartupReportFilename = "startup_report.json"
+)
+
+// StartupFailure a capability that could not fully initialize.
+type StartupFailure struct {
+	Component string      `json:"component"`
+	Kind      FailureKind `json:"kind"`
+	Error     string      `json:"error"`
+}
+
+// StartupReport failures recorded while the agent starts. In strict mode
+// (runtime.strict_startup) any failure aborts the startup.
+type StartupReport struct {
+	Version  string           `json:"version"`
+	Hostname string           `json:"hostname,omitempty"`
+	Time     time.Time        `json:"time"`
+	ExitCode int              `json:"exit_code"`
+	Failures []StartupFailure `json:"failures"`
+
+	mu sync.Mutex
+}
+
+// DefaultStartupReport the agent's startup report.
+var DefaultStartupReport = &StartupReport{}
+
+// Record records a failure of component.
+func (r *StartupReport) Record(component string, kind FailureKind, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Failures = append(r.Failures, StartupFailure{Component: component, Kind: kind, Error: err.Error()})
+}
+
+// Failed returns true if any failure was recorded.
+func (r *StartupReport) Failed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.Failures) > 0
+}
+
+// exitCode returns the exit code for the recorded failures, the most
+// actionable kind wins: config, then capability, then network.
+func (r *StartupReport) exitCode() int {
+	code := 0
+	for _, f := range r.Failures {
+		switch f.Kind {
+		case FailureConfig:
+			return ExitConfigError
+		case FailureCapability:
+			code = ExitCapabilityError
+		case FailureNetwork:
+			if code == 0 {
+				code = ExitNetworkError
+			}
+		}
+	}
+
+	return code
+}
+
+// Finalize timestamps the report and sets its exit code, which it returns.
+func (r *StartupReport) Finalize(now time.Time) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Version = Version
+	r.Hostname = AgentHostname
+	r.Time = now.UTC()
+	r.ExitCode = r.exitCode()
+
+	return r.ExitCode
+}
+
+// Write writes the report as JSON.
+func (r *StartupReport) Write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(r)
+}
+
+// WriteFile writes the report to DefaultStartupReportFilename under dir.
+func (r *StartupReport) WriteFile(dir string) error {
+	path := filepath.Join(dir, DefaultStartupReportFilename)
+	tmp := path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "error writing startup report")
+	}
+
+	if err := r.Write(f); err != nil {
+		f.Close()
+		return errors.Wrap(err, "error writing startup report")
+	}
+
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "error writing startup report")
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// StrictStartup returns true if the agent must exit on any startup failure.
+// The environment is checked too, since it is known even when the
+// configuration fails to load.
+func StrictStartup() bool {
+	if AgentConf.Runtime.StrictStartup {
+		return true
+	}
+
+	v, err := strconv.ParseBool(os.Getenv(strings.ToUpper(ConfigEnvPrefix + "_" + "runtime_strict_startup")))
+
+	return err == nil && v
+}
@@ -0,0 +1,90 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartupReportExitCode(t *testing.T) {
+	tests := []struct {
+		name  string
+		kinds []FailureKind
+		exp   int
+	}{
+		{"no failure", nil, 0},
+		{"network", []FailureKind{FailureNetwork}, ExitNetworkError},
+		{"capability over network", []FailureKind{FailureNetwork, FailureCapability, FailureNetwork}, ExitCapabilityError},
+		{"config over capability", []FailureKind{FailureCapability, FailureConfig, FailureNetwork}, ExitConfigError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &StartupReport{}
+			for _, kind := range tt.kinds {
+				r.Record("component", kind, errors.New("failed"))
+			}
+
+			require.Equal(t, len(tt.kinds) > 0, r.Failed())
+			require.Equal(t, tt.exp, r.Finalize(time.Now()))
+			require.Equal(t, tt.exp, r.ExitCode)
+		})
+	}
+}
+
+func TestStartupReportWriteFile(t *testing.T) {
+	r := &StartupReport{}
+	r.Record("prometheus.proc.mdadm", FailureCapability, errors.New("permission denied"))
+	r.Record("ntp", FailureNetwork, errors.New("i/o timeout"))
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	r.Finalize(now)
+
+	dir := t.TempDir()
+	require.NoError(t, r.WriteFile(dir))
+
+	b, err := os.ReadFile(filepath.Join(dir, DefaultStartupReportFilename))
+	require.NoError(t, err)
+
+	var got StartupReport
+	require.NoError(t, json.Unmarshal(b, &got))
+	require.Equal(t, ExitCapabilityError, got.ExitCode)
+	require.True(t, now.Equal(got.Time))
+	require.Equal(t, []StartupFailure{
+		{Component: "prometheus.proc.mdadm", Kind: FailureCapability, Error: "permission denied"},
+		{Component: "ntp", Kind: FailureNetwork, Error: "i/o timeout"},
+	}, got.Failures)
+}
+
+func TestStrictStartup(t *testing.T) {
+	prev := AgentConf.Runtime.StrictStartup
+	defer func() { AgentConf.Runtime.StrictStartup = prev }()
+
+	AgentConf.Runtime.StrictStartup = false
+	t.Setenv("MA_RUNTIME_STRICT_STARTUP", "")
+	require.False(t, StrictStartup())
+
+	t.Setenv("MA_RUNTIME_STRICT_STARTUP", "true")
+	require.True(t, StrictStartup())
+
+	t.Setenv("MA_RUNTIME_STRICT_STARTUP", "")
+	AgentConf.Runtime.StrictStartup = true
+	require.True(t, StrictStartup())
+}
@@ -13,7 +13,10 @@
 
 package global
 
-import "sync/atomic"
+import (
+	"sync/atomic"
+	"time"
+)
 
 type (
 	platformState  int32
@@ -47,8 +50,13 @@ func init() {
 
 // AgentState maintains available state.
 type AgentState struct {
-	platState int32
-	discState int32
+	platState  int32
+	discState  int32
+	nodeStatus atomic.Value
+
+	// lastExport unix nanoseconds of the last successful export, 0 if none.
+	lastExport     int64
+	exportFailures uint64
 }
 
 // PublishState returns current platform publish state.
@@ -71,8 +79,47 @@ func (a *AgentState) SetDiscoveryState(st int32) {
 	atomic.StoreInt32((*int32)(&a.discState), int32(st))
 }
 
+// NodeStatus returns the name of the last node status event observed.
+func (a *AgentState) NodeStatus() string {
+	st, _ := a.nodeStatus.Load().(string)
+
+	return st
+}
+
+// SetNodeStatus sets the name of the last node status event observed.
+func (a *AgentState) SetNodeStatus(st string) {
+	a.nodeStatus.Store(st)
+}
+
+// RecordExport records a successful export of data at t.
+func (a *AgentState) RecordExport(t time.Time) {
+	atomic.StoreInt64(&a.lastExport, t.UnixNano())
+}
+
+// LastExport returns the time of the last successful export, the zero
+// time if none.
+func (a *AgentState) LastExport() time.Time {
+	ns := atomic.LoadInt64(&a.lastExport)
+	if ns == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, ns)
+}
+
+// RecordExportFailure records a failed export attempt.
+func (a *AgentState) RecordExportFailure() {
+	atomic.AddUint64(&a.exportFailures, 1)
+}
+
+// ExportFailures returns the number of failed export attempts so far.
+func (a *AgentState) ExportFailures() uint64 {
+	return atomic.LoadUint64(&a.exportFailures)
+}
+
 // Reset sets default values for all maintained state values.
 func (a *AgentState) Reset() {
 	atomic.StoreInt32((*int32)(&a.platState), PlatformStateUp)
 	atomic.StoreInt32((*int32)(&a.discState), NodeDiscoveryError)
+	a.nodeStatus.Store("")
 }
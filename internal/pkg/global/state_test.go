@@ -15,6 +15,7 @@ package global
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -30,4 +31,17 @@ func TestAgentState(t *testing.T) {
 
 	testState.SetPublishState(PlatformStateUp)
 	require.Equal(t, PlatformStateUp, testState.PublishState())
+
+	require.Empty(t, testState.NodeStatus())
+	testState.SetNodeStatus("agent.node.down")
+	require.Equal(t, "agent.node.down", testState.NodeStatus())
+
+	require.True(t, testState.LastExport().IsZero())
+	exportedAt := time.Unix(1650000000, 0)
+	testState.RecordExport(exportedAt)
+	require.True(t, exportedAt.Equal(testState.LastExport()))
+
+	testState.RecordExportFailure()
+	testState.RecordExportFailure()
+	require.Equal(t, uint64(2), testState.ExportFailures())
 }
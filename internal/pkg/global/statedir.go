@@ -0,0 +1,377 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// StateVersionFilename file under the state directory holding its
+	// format version.
+	StateVersionFilename = "VERSION"
+
+	// StateLockFilename file under the state directory locked by the agent
+	// instance owning it.
+	StateLockFilename = "state.lock"
+
+	// stateBackupSuffix suffix of the pre-migration copy of the state
+	// directory, kept next to it until migrations succeed.
+	stateBackupSuffix = ".backup"
+)
+
+var (
+	// ErrStateDirNewer the state directory was written by a newer agent.
+	ErrStateDirNewer = errors.New("state directory is newer than this agent")
+
+	// ErrStateDirLocked the state directory is owned by another agent instance.
+	ErrStateDirLocked = errors.New("state directory is locked by another agent instance")
+
+	stateMigrationsMu = &sync.Mutex{}
+	stateMigrations   []StateMigration
+)
+
+// StateMigration upgrades a component's state from Version-1 to Version.
+type StateMigration struct {
+	// Component owning the migrated state, i.e. fingerprint.
+	Component string
+
+	// Version state directory version after the migration.
+	Version int
+
+	// Migrate migrates the component's state under dir.
+	Migrate func(dir string) error
+}
+
+// RegisterStateMigration registers a state migration, components register
+// theirs on init. The highest registered version is the state version this
+// agent writes.
+func RegisterStateMigration(m StateMigration) {
+	stateMigrationsMu.Lock()
+	defer stateMigrationsMu.Unlock()
+
+	stateMigrations = append(stateMigrations, m)
+	sort.SliceStable(stateMigrations, func(i, j int) bool {
+		if stateMigrations[i].Version != stateMigrations[j].Version {
+			return stateMigrations[i].Version < stateMigrations[j].Version
+		}
+
+		return stateMigrations[i].Component < stateMigrations[j].Component
+	})
+}
+
+// StateVersion returns the state directory version written by this agent.
+func StateVersion() int {
+	stateMigrationsMu.Lock()
+	defer stateMigrationsMu.Unlock()
+
+	if len(stateMigrations) == 0 {
+		return 0
+	}
+
+	return stateMigrations[len(stateMigrations)-1].Version
+}
+
+// StateDir the agent's versioned state directory, locked for as long as it
+// is open.
+type StateDir struct {
+	Path string
+
+	lock *os.File
+}
+
+// OpenStateDir creates the state directory if needed and locks it.
+func OpenStateDir(path string) (*StateDir, error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, errors.Wrapf(err, "error creating state directory: %s", path)
+	}
+
+	lock, err := os.OpenFile(filepath.Join(path, StateLockFilename), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening state lock")
+	}
+
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		lock.Close()
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: %s", ErrStateDirLocked, path)
+		}
+
+		return nil, errors.Wrap(err, "error locking state directory")
+	}
+
+	return &StateDir{Path: path, lock: lock}, nil
+}
+
+// Close releases the state directory lock.
+func (s *StateDir) Close() error {
+	return s.lock.Close()
+}
+
+// Version returns the state directory version, 0 if it was never set.
+func (s *StateDir) Version() (int, error) {
+	data, err := os.ReadFile(filepath.Join(s.Path, StateVersionFilename))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.Wrap(err, "error reading state version")
+	}
+
+	v, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid state version in %s", filepath.Join(s.Path, StateVersionFilename))
+	}
+
+	return v, nil
+}
+
+func (s *StateDir) setVersion(v int) error {
+	path := filepath.Join(s.Path, StateVersionFilename)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(v)+"\n"), 0o644); err != nil {
+		return errors.Wrap(err, "error writing state version")
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.Wrap(err, "error writing state version")
+	}
+
+	return nil
+}
+
+// Migrate brings the state directory to StateVersion. The directory is
+// backed up first and restored if any migration fails. A backup left
+// behind by a migration interrupted mid-way is restored before retrying.
+// Refuses state written by a newer agent.
+func (s *StateDir) Migrate() error {
+	backup := s.Path + stateBackupSuffix
+
+	if err := s.recover(backup); err != nil {
+		return err
+	}
+
+	current, err := s.Version()
+	if err != nil {
+		return err
+	}
+
+	target := StateVersion()
+	if current > target {
+		return fmt.Errorf("%w: %s is at version %d but this agent (%s) supports up to version %d, upgrade the agent or restore a previous state directory",
+			ErrStateDirNewer, s.Path, current, Version, target)
+	}
+
+	if current == target {
+		return nil
+	}
+
+	if err := s.backup(backup); err != nil {
+		return err
+	}
+
+	stateMigrationsMu.Lock()
+	migrations := append([]StateMigration{}, stateMigrations...)
+	stateMigrationsMu.Unlock()
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		zap.S().Infow("migrating agent state", "component", m.Component, "version", m.Version, "dir", s.Path)
+		if err := m.Migrate(s.Path); err != nil {
+			if rerr := s.restore(backup); rerr != nil {
+				return errors.Wrapf(rerr, "state migration %s to version %d failed (%v) and rolling back failed, the pre-migration state is kept in %s",
+					m.Component, m.Version, err, backup)
+			}
+
+			return errors.Wrapf(err, "state migration %s to version %d failed, rolled back to version %d", m.Component, m.Version, current)
+		}
+	}
+
+	if err := s.setVersion(target); err != nil {
+		if rerr := s.restore(backup); rerr != nil {
+			return errors.Wrapf(rerr, "%v and rolling back failed, the pre-migration state is kept in %s", err, backup)
+		}
+
+		return err
+	}
+
+	if err := os.RemoveAll(backup); err != nil {
+		zap.S().Warnw("error removing state backup", "path", backup, zap.Error(err))
+	}
+
+	return nil
+}
+
+// recover handles a backup left behind by an interrupted migration.
+func (s *StateDir) recover(backup string) error {
+	if _, err := os.Stat(backup); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "error checking state backup")
+	}
+
+	backupVersion, err := (&StateDir{Path: backup}).Version()
+	if err != nil {
+		return err
+	}
+
+	current, err := s.Version()
+	if err != nil {
+		// a failing migration may have left anything behind
+		current = backupVersion
+	}
+
+	if current != backupVersion {
+		// the version is written last, the migration completed
+		return os.RemoveAll(backup)
+	}
+
+	zap.S().Warnw("previous state migration was interrupted, restoring state backup", "dir", s.Path, "backup", backup)
+
+	return s.restore(backup)
+}
+
+// backup copies the state directory to backup. The copy is renamed in
+// place once complete, a partial copy is never mistaken for a backup.
+func (s *StateDir) backup(backup string) error {
+	tmp := backup + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return errors.Wrap(err, "error backing up state directory")
+	}
+
+	if err := copyStateDir(s.Path, tmp); err != nil {
+		return errors.Wrap(err, "error backing up state directory")
+	}
+
+	if err := os.Rename(tmp, backup); err != nil {
+		return errors.Wrap(err, "error backing up state directory")
+	}
+
+	return nil
+}
+
+// restore replaces the state directory contents with backup, keeping the
+// lock in place, and removes the backup.
+func (s *StateDir) restore(backup string) error {
+	entries, err := os.ReadDir(s.Path)
+	if err != nil {
+		return errors.Wrap(err, "error restoring state directory")
+	}
+
+	for _, entry := range entries {
+		if entry.Name() == StateLockFilename {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(s.Path, entry.Name())); err != nil {
+			return errors.Wrap(err, "error restoring state directory")
+		}
+	}
+
+	if err := copyStateDir(backup, s.Path); err != nil {
+		return errors.Wrap(err, "error restoring state directory")
+	}
+
+	return os.RemoveAll(backup)
+}
+
+// copyStateDir recursively copies the regular files and directories under
+// src to dst, the state lock excluded.
+func copyStateDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case rel == StateLockFilename || !d.Type().IsRegular():
+			return nil
+		default:
+			return copyStateFile(path, target)
+		}
+	})
+}
+
+func copyStateFile(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// ImportLegacyStateFile copies name from the directory agent state was kept
+// in before the state directory was introduced, the state directory's
+// parent, unless already present. The legacy file is left in place for
+// older agents.
+func ImportLegacyStateFile(dir, name string) error {
+	src := filepath.Join(filepath.Dir(dir), name)
+	dst := filepath.Join(dir, name)
+
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+
+	if _, err := os.Stat(src); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return copyStateFile(src, dst)
+}
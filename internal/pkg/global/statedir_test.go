@@ -0,0 +1,197 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// withStateMigrations replaces the registered migrations for the duration
+// of a test.
+func withStateMigrations(t *testing.T, migrations ...StateMigration) {
+	was := stateMigrations
+	t.Cleanup(func() {
+		stateMigrations = was
+	})
+
+	stateMigrations = nil
+	for _, m := range migrations {
+		RegisterStateMigration(m)
+	}
+}
+
+func openTestStateDir(t *testing.T) *StateDir {
+	s, err := OpenStateDir(filepath.Join(t.TempDir(), AppName))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func writeStateFile(t *testing.T, dir, name, content string) {
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+}
+
+func requireStateFile(t *testing.T, dir, name, content string) {
+	got, err := os.ReadFile(filepath.Join(dir, name))
+	require.NoError(t, err)
+	require.Equal(t, content, string(got))
+}
+
+func renameMigration(version int, from, to string) StateMigration {
+	return StateMigration{
+		Component: to,
+		Version:   version,
+		Migrate: func(dir string) error {
+			return os.Rename(filepath.Join(dir, from), filepath.Join(dir, to))
+		},
+	}
+}
+
+func TestStateDir_Migrate(t *testing.T) {
+	var order []string
+	record := func(m StateMigration) StateMigration {
+		migrate := m.Migrate
+		m.Migrate = func(dir string) error {
+			order = append(order, m.Component)
+			return migrate(dir)
+		}
+
+		return m
+	}
+	withStateMigrations(t,
+		record(renameMigration(2, "v1", "v2")),
+		record(renameMigration(1, "v0", "v1")),
+		record(StateMigration{Component: "buffer", Version: 2, Migrate: func(string) error { return nil }}),
+	)
+	require.Equal(t, 2, StateVersion())
+
+	s := openTestStateDir(t)
+	writeStateFile(t, s.Path, "v0", "state")
+
+	require.NoError(t, s.Migrate())
+	require.Equal(t, []string{"v1", "buffer", "v2"}, order)
+	requireStateFile(t, s.Path, "v2", "state")
+
+	v, err := s.Version()
+	require.NoError(t, err)
+	require.Equal(t, 2, v)
+	require.NoDirExists(t, s.Path+stateBackupSuffix)
+
+	// already up to date
+	order = nil
+	require.NoError(t, s.Migrate())
+	require.Empty(t, order)
+}
+
+func TestStateDir_MigrateRollback(t *testing.T) {
+	errMigration := errors.New("migration failed")
+	withStateMigrations(t,
+		renameMigration(1, "v0", "v1"),
+		StateMigration{
+			Component: "broken",
+			Version:   2,
+			Migrate: func(dir string) error {
+				writeStateFile(t, dir, "garbage", "")
+				return errMigration
+			},
+		},
+	)
+
+	s := openTestStateDir(t)
+	writeStateFile(t, s.Path, "v0", "state")
+
+	require.ErrorIs(t, s.Migrate(), errMigration)
+
+	// pre-migration state restored
+	entries, err := os.ReadDir(s.Path)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	requireStateFile(t, s.Path, "v0", "state")
+	require.FileExists(t, filepath.Join(s.Path, StateLockFilename))
+	require.NoDirExists(t, s.Path+stateBackupSuffix)
+
+	v, err := s.Version()
+	require.NoError(t, err)
+	require.Equal(t, 0, v)
+}
+
+func TestStateDir_MigrateInterrupted(t *testing.T) {
+	withStateMigrations(t, renameMigration(1, "v0", "v1"))
+
+	s := openTestStateDir(t)
+
+	// the previous agent crashed mid-migration, after backing up
+	backup := s.Path + stateBackupSuffix
+	require.NoError(t, os.Mkdir(backup, 0o755))
+	writeStateFile(t, backup, "v0", "state")
+	writeStateFile(t, s.Path, "partial", "")
+
+	require.NoError(t, s.Migrate())
+	requireStateFile(t, s.Path, "v1", "state")
+	require.NoFileExists(t, filepath.Join(s.Path, "partial"))
+	require.NoDirExists(t, backup)
+
+	// the previous agent crashed after completing its migration
+	require.NoError(t, os.Mkdir(backup, 0o755))
+	writeStateFile(t, backup, "v0", "stale")
+
+	require.NoError(t, s.Migrate())
+	requireStateFile(t, s.Path, "v1", "state")
+	require.NoDirExists(t, backup)
+}
+
+func TestStateDir_Newer(t *testing.T) {
+	withStateMigrations(t, renameMigration(1, "v0", "v1"))
+
+	s := openTestStateDir(t)
+	writeStateFile(t, s.Path, StateVersionFilename, "2\n")
+
+	err := s.Migrate()
+	require.ErrorIs(t, err, ErrStateDirNewer)
+	require.Contains(t, err.Error(), "is at version 2 but this agent")
+	requireStateFile(t, s.Path, StateVersionFilename, "2\n")
+}
+
+func TestStateDir_Locked(t *testing.T) {
+	s := openTestStateDir(t)
+
+	_, err := OpenStateDir(s.Path)
+	require.ErrorIs(t, err, ErrStateDirLocked)
+
+	require.NoError(t, s.Close())
+	other, err := OpenStateDir(s.Path)
+	require.NoError(t, err)
+	require.NoError(t, other.Close())
+}
+
+func TestStateDir_ImportLegacyState(t *testing.T) {
+	s := openTestStateDir(t)
+	legacy := filepath.Dir(s.Path)
+	writeStateFile(t, legacy, DefaultFingerprintFilename, "fingerprint")
+	writeStateFile(t, legacy, DefaultRunningFlagFilename, "2022-05-01T00:00:00Z")
+
+	require.NoError(t, s.Migrate())
+	requireStateFile(t, s.Path, DefaultFingerprintFilename, "fingerprint")
+	requireStateFile(t, s.Path, DefaultRunningFlagFilename, "2022-05-01T00:00:00Z")
+	require.NoFileExists(t, filepath.Join(s.Path, DefaultShutdownReportFilename))
+
+	// left in place for older agents
+	require.FileExists(t, filepath.Join(legacy, DefaultFingerprintFilename))
+}
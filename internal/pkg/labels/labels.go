@@ -0,0 +1,318 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package labels provides an immutable, sorted label set with a stable
+// 64-bit hash, used as the canonical identity of a labeled series.
+package labels
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// MetricName label name used for the metric name when it is part of the series identity.
+const MetricName = "__name__"
+
+// sep separates names and values in the encoding of a label set, it can
+// not occur in valid UTF-8.
+const sep = 0xff
+
+// emptyHash hash of an empty label set.
+var emptyHash = hash(nil)
+
+// Label a single label name/value pair.
+type Label struct {
+	Name, Value string
+}
+
+// Labels an immutable label set sorted by name. The zero value is an
+// empty label set.
+type Labels struct {
+	ls []Label
+
+	// enc names and values separated by sep, built once so that hashing
+	// is a single pass over contiguous bytes.
+	enc  []byte
+	hash uint64
+}
+
+// New returns a label set from the given labels. Labels with empty values
+// are dropped and for duplicate names the last value wins.
+func New(ls ...Label) Labels {
+	cp := make([]Label, 0, len(ls))
+	for _, l := range ls {
+		cp = append(cp, l)
+	}
+
+	return fromUnsorted(cp)
+}
+
+// FromMap returns a label set from a map of label names to values.
+func FromMap(m map[string]string) Labels {
+	ls := make([]Label, 0, len(m))
+	for name, value := range m {
+		ls = append(ls, Label{Name: name, Value: value})
+	}
+
+	return fromUnsorted(ls)
+}
+
+// FromStrings returns a label set from a list of name, value pairs.
+// It panics on an odd number of arguments.
+func FromStrings(ss ...string) Labels {
+	if len(ss)%2 != 0 {
+		panic("labels: odd number of arguments")
+	}
+
+	ls := make([]Label, 0, len(ss)/2)
+	for i := 0; i < len(ss); i += 2 {
+		ls = append(ls, Label{Name: ss[i], Value: ss[i+1]})
+	}
+
+	return fromUnsorted(ls)
+}
+
+// fromUnsorted takes ownership of ls.
+func fromUnsorted(ls []Label) Labels {
+	// stable sort, so the last duplicate is the last one in its run
+	sort.SliceStable(ls, func(i, j int) bool { return ls[i].Name < ls[j].Name })
+
+	out := ls[:0]
+	for i, l := range ls {
+		if i+1 < len(ls) && ls[i+1].Name == l.Name {
+			continue
+		}
+		if l.Value == "" {
+			continue
+		}
+		out = append(out, l)
+	}
+
+	enc := encode(out)
+
+	return Labels{ls: out, enc: enc, hash: hash(enc)}
+}
+
+// encode returns the names and values of ls, each followed by sep.
+func encode(ls []Label) []byte {
+	n := 0
+	for _, l := range ls {
+		n += len(l.Name) + len(l.Value) + 2
+	}
+
+	enc := make([]byte, 0, n)
+	for _, l := range ls {
+		enc = append(enc, l.Name...)
+		enc = append(enc, sep)
+		enc = append(enc, l.Value...)
+		enc = append(enc, sep)
+	}
+
+	return enc
+}
+
+func hash(enc []byte) uint64 {
+	return xxhash.Sum64(enc)
+}
+
+// Len returns the number of labels.
+func (l Labels) Len() int {
+	return len(l.ls)
+}
+
+// Hash returns the cached hash of the label set.
+func (l Labels) Hash() uint64 {
+	if len(l.ls) == 0 {
+		return emptyHash
+	}
+
+	return l.hash
+}
+
+// Get returns the value of the label with the given name, or an
+// empty string if it does not exist.
+func (l Labels) Get(name string) string {
+	i := sort.Search(len(l.ls), func(i int) bool { return l.ls[i].Name >= name })
+	if i < len(l.ls) && l.ls[i].Name == name {
+		return l.ls[i].Value
+	}
+
+	return ""
+}
+
+// Has returns true if a label with the given name exists.
+func (l Labels) Has(name string) bool {
+	return l.Get(name) != ""
+}
+
+// Equal returns true if both label sets contain the same labels.
+func (l Labels) Equal(o Labels) bool {
+	if len(l.ls) != len(o.ls) || l.Hash() != o.Hash() {
+		return false
+	}
+
+	for i := range l.ls {
+		if l.ls[i] != o.ls[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Range calls f for each label in name order.
+func (l Labels) Range(f func(Label)) {
+	for _, lbl := range l.ls {
+		f(lbl)
+	}
+}
+
+// Slice returns a copy of the labels in name order.
+func (l Labels) Slice() []Label {
+	cp := make([]Label, len(l.ls))
+	copy(cp, l.ls)
+
+	return cp
+}
+
+// Map returns the labels as a map of names to values.
+func (l Labels) Map() map[string]string {
+	m := make(map[string]string, len(l.ls))
+	for _, lbl := range l.ls {
+		m[lbl.Name] = lbl.Value
+	}
+
+	return m
+}
+
+// WithExtra returns a new label set with the given labels added,
+// overriding existing labels with the same name.
+func (l Labels) WithExtra(extra ...Label) Labels {
+	return NewBuilder(l).Set(extra...).Labels()
+}
+
+// String returns the label set in the {name="value", ...} format.
+func (l Labels) String() string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, lbl := range l.ls {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(lbl.Name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(lbl.Value))
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+// Builder builds a new label set by modifying a base one.
+type Builder struct {
+	base Labels
+	add  []Label
+	del  []string
+}
+
+// NewBuilder returns a builder for modifying base.
+func NewBuilder(base Labels) *Builder {
+	return &Builder{base: base}
+}
+
+// Set adds or overrides labels. Setting an empty value deletes the label.
+func (b *Builder) Set(ls ...Label) *Builder {
+	for _, l := range ls {
+		if l.Value == "" {
+			b.Del(l.Name)
+			continue
+		}
+		b.del = removeName(b.del, l.Name)
+		b.add = append(b.add, l)
+	}
+
+	return b
+}
+
+// Del removes labels by name.
+func (b *Builder) Del(names ...string) *Builder {
+	for _, name := range names {
+		b.add = removeLabel(b.add, name)
+		b.del = append(b.del, name)
+	}
+
+	return b
+}
+
+// Labels returns the resulting label set. The builder can be reused.
+func (b *Builder) Labels() Labels {
+	if len(b.add) == 0 && len(b.del) == 0 {
+		return b.base
+	}
+
+	ls := make([]Label, 0, len(b.base.ls)+len(b.add))
+	for _, l := range b.base.ls {
+		if containsName(b.del, l.Name) || containsLabel(b.add, l.Name) {
+			continue
+		}
+		ls = append(ls, l)
+	}
+	ls = append(ls, b.add...)
+
+	return fromUnsorted(ls)
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsLabel(ls []Label, name string) bool {
+	for _, l := range ls {
+		if l.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func removeName(names []string, name string) []string {
+	out := names[:0]
+	for _, n := range names {
+		if n != name {
+			out = append(out, n)
+		}
+	}
+
+	return out
+}
+
+func removeLabel(ls []Label, name string) []Label {
+	out := ls[:0]
+	for _, l := range ls {
+		if l.Name != name {
+			out = append(out, l)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,161 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package labels
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelsOrderAndHash(t *testing.T) {
+	a := FromMap(map[string]string{"job": "node", "instance": "a", "env": "prod"})
+	b := FromStrings("env", "prod", "job", "node", "instance", "a")
+	c := New(Label{"instance", "a"}, Label{"env", "prod"}, Label{"job", "node"})
+
+	require.Equal(t, `{env="prod", instance="a", job="node"}`, a.String())
+	require.True(t, a.Equal(b))
+	require.True(t, a.Equal(c))
+	require.Equal(t, a.Hash(), b.Hash())
+	require.Equal(t, a.Hash(), c.Hash())
+
+	// hashing must not be ambiguous across name/value boundaries
+	require.NotEqual(t, FromStrings("a", "bc").Hash(), FromStrings("ab", "c").Hash())
+	require.False(t, FromStrings("a", "b").Equal(FromStrings("a", "c")))
+
+	require.Equal(t, New().Hash(), Labels{}.Hash())
+	require.True(t, New().Equal(Labels{}))
+}
+
+func TestLabelsDuplicatesAndEmpty(t *testing.T) {
+	l := New(Label{"a", "1"}, Label{"b", ""}, Label{"a", "2"})
+
+	require.Equal(t, 1, l.Len())
+	require.Equal(t, "2", l.Get("a"))
+	require.False(t, l.Has("b"))
+	require.Equal(t, "", l.Get("missing"))
+}
+
+func TestLabelsImmutable(t *testing.T) {
+	in := []Label{{"b", "2"}, {"a", "1"}}
+	l := New(in...)
+	in[0].Value = "changed"
+
+	require.Equal(t, "2", l.Get("b"))
+
+	s := l.Slice()
+	s[0].Value = "changed"
+	require.Equal(t, "1", l.Get("a"))
+}
+
+func TestBuilder(t *testing.T) {
+	base := FromStrings("a", "1", "b", "2", "c", "3")
+
+	l := NewBuilder(base).
+		Set(Label{"b", "20"}, Label{"d", "4"}).
+		Del("c").
+		Labels()
+	require.Equal(t, `{a="1", b="20", d="4"}`, l.String())
+	require.Equal(t, FromStrings("a", "1", "b", "20", "d", "4").Hash(), l.Hash())
+
+	// base is left untouched
+	require.Equal(t, `{a="1", b="2", c="3"}`, base.String())
+
+	// setting after deleting restores the label
+	l = NewBuilder(base).Del("a").Set(Label{"a", "10"}).Labels()
+	require.Equal(t, "10", l.Get("a"))
+
+	// deleting after setting removes the label
+	l = NewBuilder(base).Set(Label{"e", "5"}).Del("e").Labels()
+	require.True(t, l.Equal(base))
+
+	// empty values delete
+	l = NewBuilder(base).Set(Label{"a", ""}).Labels()
+	require.False(t, l.Has("a"))
+
+	extra := base.WithExtra(Label{MetricName, "up"})
+	require.Equal(t, "up", extra.Get(MetricName))
+	require.Equal(t, 3, base.Len())
+}
+
+func TestLabelsMap(t *testing.T) {
+	m := map[string]string{"a": "1", "b": "2"}
+	require.Equal(t, m, FromMap(m).Map())
+}
+
+func benchLabels(n int) Labels {
+	ss := make([]string, 0, 2*n)
+	for i := 0; i < n; i++ {
+		ss = append(ss, fmt.Sprintf("label_name_%d", i), fmt.Sprintf("some_label_value_%d", i))
+	}
+
+	return FromStrings(ss...)
+}
+
+func BenchmarkHash10(b *testing.B) {
+	l := benchLabels(10)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hash(l.enc)
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	l := benchLabels(10)
+	series := map[uint64]Labels{}
+	for i := 0; i < 1000; i++ {
+		s := l.WithExtra(Label{"series", fmt.Sprint(i)})
+		series[s.Hash()] = s
+	}
+	key := l.WithExtra(Label{"series", "500"})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s, ok := series[key.Hash()]
+		if !ok || !s.Equal(key) || s.Get("label_name_5") == "" {
+			b.Fatal("lookup failed")
+		}
+	}
+}
+
+func BenchmarkNew10(b *testing.B) {
+	ls := benchLabels(10).Slice()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		New(ls...)
+	}
+}
+
+func TestLabelsEncoding(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 100} {
+		l := benchLabels(n)
+
+		var d xxhash.Digest
+		d.Reset()
+		l.Range(func(lbl Label) {
+			d.WriteString(lbl.Name)
+			d.Write([]byte{sep})
+			d.WriteString(lbl.Value)
+			d.Write([]byte{sep})
+		})
+		require.Equal(t, d.Sum64(), l.Hash(), "%d labels", n)
+	}
+}
@@ -70,3 +70,13 @@ func StartHTTPServer(wg *sync.WaitGroup, addr string, mux http.Handler) *http.Se
 
 	return s
 }
+
+// MemoryReportHandler serves the ranked per-component memory report.
+func MemoryReportHandler(m *global.MemoryAccounting) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := m.WriteReport(w); err != nil {
+			zap.S().Warnw("error writing memory report", zap.Error(err))
+		}
+	})
+}
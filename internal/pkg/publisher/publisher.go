@@ -50,6 +50,10 @@ type Publisher struct {
 	once       sync.Once
 	lastErr    error
 	blockchain global.Chain
+
+	// heartbeat context of the last agent.up event
+	heartbeat   map[string]interface{}
+	heartbeatMu sync.RWMutex
 }
 
 func init() {
@@ -95,6 +99,7 @@ func NewPlatformPublisher(hostname string, platformConfig global.PlatformConfig,
 	}
 
 	buffer := buf.NewPriorityBuffer(bufferConfig.TTL)
+	global.RegisterMemoryReporter("publisher.buffer", buffer)
 	bufCtrl := buf.NewController(bufCtrlConf, buffer)
 
 	publisher := newPublisher(Config{}, bufCtrl)
@@ -114,6 +119,7 @@ func (t *Publisher) forceSendAgentUp(uptime time.Time) {
 		if err := t.bufCtrl.EmitEvent(agentUpCtx, model.AgentUpName); err != nil {
 			log.Warnw("error emitting startup event", "event", model.AgentUpName, zap.Error(err))
 		}
+		t.setHeartbeat(agentUpCtx)
 		log.Infow("started publishing with agent up", "event_name", model.AgentUpName, "ctx", agentUpCtx)
 
 		// do a manual drain first to send all startup events
@@ -146,6 +152,7 @@ func (t *Publisher) Start(ctx context.Context, wg *sync.WaitGroup) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		defer global.ReportPanic()
 
 		log.Debug("starting metric ingestion")
 
@@ -159,6 +166,7 @@ func (t *Publisher) Start(ctx context.Context, wg *sync.WaitGroup) {
 				if err := t.bufCtrl.EmitEvent(agentUpCtx, model.AgentUpName); err != nil {
 					log.Warnw("error emitting event", "event", model.AgentUpName, zap.Error(err))
 				}
+				t.setHeartbeat(agentUpCtx)
 			case <-ctx.Done():
 				log.Debug("stopping buf controller, ingestion goroutine exiting")
 
@@ -188,6 +196,30 @@ func (t *Publisher) HandleMessage(ctx context.Context, message *model.Message) {
 	t.lastErr = nil
 }
 
+func (t *Publisher) setHeartbeat(ctx map[string]interface{}) {
+	heartbeat := make(map[string]interface{}, len(ctx))
+	for k, v := range ctx {
+		heartbeat[k] = v
+	}
+
+	t.heartbeatMu.Lock()
+	t.heartbeat = heartbeat
+	t.heartbeatMu.Unlock()
+}
+
+// LastHeartbeat returns the context of the last agent.up event sent.
+func (t *Publisher) LastHeartbeat() map[string]interface{} {
+	t.heartbeatMu.RLock()
+	defer t.heartbeatMu.RUnlock()
+
+	return t.heartbeat
+}
+
+// BufferLen returns the number of messages waiting to be published.
+func (t *Publisher) BufferLen() int {
+	return t.bufCtrl.B.Len()
+}
+
 // Stop stops the publisher.
 func (t *Publisher) Stop() {
 	close(t.closeCh)
@@ -0,0 +1,265 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package replay feeds captured envelopes through the agent's buffering
+// and batching stages into a sink, for reproducing issues locally. It
+// requires no chain or host access.
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"agent/api/v1/model"
+	"agent/internal/pkg/buf"
+	"agent/internal/pkg/global"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Config replay configuration.
+type Config struct {
+	// Speed factor applied to the original inter-arrival times, 0 replays
+	// as fast as possible.
+	Speed float64
+
+	// Rebase shifts all timestamps so that the first envelope happened now.
+	Rebase bool
+
+	// BatchN max number of messages per batch sent to the sink.
+	BatchN int
+
+	// Sink receives the batches drained from the buffer.
+	Sink Sink
+
+	// Now and Sleep are overridden by tests.
+	Now   func() time.Time
+	Sleep func(ctx context.Context, d time.Duration)
+}
+
+// Sink consumes batches of replayed messages.
+type Sink interface {
+	Write(batch []*model.Message) error
+}
+
+// StageStats statistics of a single pipeline stage.
+type StageStats struct {
+	In      int
+	Out     int
+	Dropped int
+	Errors  int
+}
+
+// Stats per stage statistics of a replay.
+type Stats struct {
+	Decode  StageStats
+	Buffer  StageStats
+	Sink    StageStats
+	Batches int
+	Elapsed time.Duration
+}
+
+// WriteTo writes a human readable report of the statistics to w.
+func (s *Stats) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STAGE\tIN\tOUT\tDROPPED\tERRORS")
+	for _, stage := range []struct {
+		name string
+		s    StageStats
+	}{{"decode", s.Decode}, {"buffer", s.Buffer}, {"sink", s.Sink}} {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n", stage.name, stage.s.In, stage.s.Out, stage.s.Dropped, stage.s.Errors)
+	}
+	tw.Flush()
+	fmt.Fprintf(&b, "batches: %d, elapsed: %v\n", s.Batches, s.Elapsed)
+
+	n, err := io.WriteString(w, b.String())
+
+	return int64(n), err
+}
+
+// ParseSpeed parses a speed factor such as "10x", "0.5x" or "10".
+func ParseSpeed(s string) (float64, error) {
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "x"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid speed %q: %w", s, err)
+	}
+
+	if speed < 0 {
+		return 0, fmt.Errorf("invalid speed %q: must not be negative", s)
+	}
+
+	return speed, nil
+}
+
+// messageTimestamp returns the capture timestamp of msg in milliseconds,
+// 0 if it has none.
+func messageTimestamp(msg *model.Message) int64 {
+	if ev := msg.GetEvent(); ev != nil {
+		return ev.Timestamp
+	}
+
+	for _, m := range msg.GetMetricFamily().GetMetrics() {
+		for _, mp := range m.GetMetricPoints() {
+			if mp.Timestamp != nil {
+				return mp.Timestamp.AsTime().UnixMilli()
+			}
+		}
+	}
+
+	return 0
+}
+
+// shiftTimestamps shifts all timestamps of msg by offset milliseconds.
+func shiftTimestamps(msg *model.Message, offset int64) {
+	if ev := msg.GetEvent(); ev != nil && ev.Timestamp != 0 {
+		ev.Timestamp += offset
+	}
+
+	for _, m := range msg.GetMetricFamily().GetMetrics() {
+		for _, mp := range m.GetMetricPoints() {
+			if mp.Timestamp != nil {
+				mp.Timestamp = timestamppb.New(mp.Timestamp.AsTime().Add(time.Duration(offset) * time.Millisecond))
+			}
+		}
+	}
+}
+
+// Run replays src through the buffer into conf.Sink. Inter-arrival times
+// of the captured envelopes are honored, scaled by conf.Speed. Timestamps
+// are preserved unless conf.Rebase is set.
+func Run(ctx context.Context, src *Source, conf Config) (*Stats, error) {
+	if conf.Sink == nil {
+		return nil, errors.New("replay sink is required")
+	}
+
+	if conf.BatchN <= 0 {
+		conf.BatchN = global.DefaultPlatformBatchN
+	}
+
+	if conf.Now == nil {
+		conf.Now = time.Now
+	}
+
+	if conf.Sleep == nil {
+		conf.Sleep = func(ctx context.Context, d time.Duration) {
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	stats := &Stats{}
+	start := conf.Now()
+
+	bufCtrl := buf.NewController(buf.ControllerConf{
+		BufLenLimit: conf.BatchN,
+		OnBufRemoveCallback: func(items buf.ItemBatch) error {
+			batch := make([]*model.Message, 0, len(items))
+			for _, item := range items {
+				if m, ok := item.Data.(*model.Message); ok {
+					batch = append(batch, m)
+				}
+			}
+			stats.Buffer.Out += len(batch)
+			stats.Sink.In += len(batch)
+
+			if err := conf.Sink.Write(batch); err != nil {
+				// replay does not retry, count and move on
+				stats.Sink.Errors++
+				stats.Sink.Dropped += len(batch)
+				zap.S().Warnw("replay sink write failed", zap.Error(err))
+
+				return nil
+			}
+			stats.Sink.Out += len(batch)
+			stats.Batches++
+
+			return nil
+		},
+	}, buf.NewPriorityBuffer(0))
+
+	var (
+		prevTs int64
+		offset int64
+		first  = true
+	)
+	err := src.Each(func(line []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		stats.Decode.In++
+		env, err := DecodeEnvelope(line)
+		if err != nil {
+			stats.Decode.Errors++
+			zap.S().Debugw("skipping envelope", zap.Error(err))
+
+			return nil
+		}
+		stats.Decode.Out++
+
+		ts := messageTimestamp(env.Message)
+		if first && ts != 0 {
+			if conf.Rebase {
+				offset = conf.Now().UnixMilli() - ts
+			}
+			first = false
+		} else if ts > prevTs && prevTs != 0 && conf.Speed > 0 {
+			conf.Sleep(ctx, time.Duration(float64(time.Duration(ts-prevTs)*time.Millisecond)/conf.Speed))
+		}
+		if ts != 0 {
+			prevTs = ts
+		}
+
+		if offset != 0 {
+			shiftTimestamps(env.Message, offset)
+		}
+
+		// order the buffer by capture time, arrival times collide when
+		// replaying faster than captured.
+		itemTs := conf.Now().UnixMilli()
+		if ts != 0 {
+			itemTs = ts + offset
+		}
+
+		stats.Buffer.In++
+		if err := bufCtrl.BufInsert(buf.Item{Timestamp: itemTs, Data: env.Message}); err != nil {
+			stats.Buffer.Dropped++
+			stats.Buffer.Errors++
+
+			return nil
+		}
+
+		if bufCtrl.B.Len() >= conf.BatchN {
+			return bufCtrl.BufDrain()
+		}
+
+		return nil
+	})
+
+	if drainErr := bufCtrl.BufDrain(); err == nil {
+		err = drainErr
+	}
+	stats.Elapsed = conf.Now().Sub(start)
+
+	return stats, err
+}
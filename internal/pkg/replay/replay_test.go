@@ -0,0 +1,190 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"agent/api/v1/model"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type mockSink struct {
+	batches [][]*model.Message
+}
+
+func (m *mockSink) Write(batch []*model.Message) error {
+	m.batches = append(m.batches, batch)
+
+	return nil
+}
+
+func testCapture(t *testing.T) []byte {
+	var b bytes.Buffer
+	for i, ts := range []int64{1660000000000, 1660000001000, 1660000003000} {
+		var msg *model.Message
+		if i == 1 {
+			msg = &model.Message{Value: &model.Message_MetricFamily{MetricFamily: &model.MetricFamily{
+				Name: "node_load1",
+				Type: model.MetricType_GAUGE,
+				Metrics: []*model.Metric{{MetricPoints: []*model.MetricPoint{{
+					Timestamp: timestamppb.New(time.UnixMilli(ts)),
+					Value:     &model.MetricPoint_GaugeValue{GaugeValue: &model.GaugeValue{Value: &model.GaugeValue_DoubleValue{DoubleValue: 0.5}}},
+				}}}},
+			}}}
+		} else {
+			msg = &model.Message{Value: &model.Message_Event{Event: &model.Event{Name: model.AgentUpName, Timestamp: ts}}}
+		}
+
+		line, err := EncodeEnvelope("host", msg)
+		require.NoError(t, err)
+		b.Write(append(line, '\n'))
+	}
+	b.WriteString("not an envelope\n\n")
+
+	return b.Bytes()
+}
+
+func writeBundle(t *testing.T, path string, files map[string][]byte) {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	require.NoError(t, os.WriteFile(path, b.Bytes(), 0o600))
+}
+
+func TestParseSpeed(t *testing.T) {
+	for s, exp := range map[string]float64{"10x": 10, "0.5x": 0.5, "2": 2, "0": 0} {
+		got, err := ParseSpeed(s)
+		require.NoError(t, err)
+		require.Equal(t, exp, got)
+	}
+
+	for _, s := range []string{"fast", "-1x"} {
+		_, err := ParseSpeed(s)
+		require.Error(t, err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "agent_stream.jsonl")
+	require.NoError(t, os.WriteFile(path, testCapture(t), 0o600))
+
+	src, err := Open(path)
+	require.NoError(t, err)
+	defer src.Close()
+
+	sink := &mockSink{}
+	var slept []time.Duration
+	stats, err := Run(context.Background(), src, Config{
+		Speed:  10,
+		BatchN: 2,
+		Sink:   sink,
+		Sleep:  func(_ context.Context, d time.Duration) { slept = append(slept, d) },
+	})
+	require.NoError(t, err)
+
+	// inter-arrival times scaled by speed
+	require.Equal(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, slept)
+
+	require.Equal(t, StageStats{In: 4, Out: 3, Errors: 1}, stats.Decode)
+	require.Equal(t, StageStats{In: 3, Out: 3}, stats.Buffer)
+	require.Equal(t, StageStats{In: 3, Out: 3}, stats.Sink)
+	require.Equal(t, 2, stats.Batches)
+
+	// timestamps are preserved
+	var got []int64
+	for _, batch := range sink.batches {
+		for _, msg := range batch {
+			got = append(got, messageTimestamp(msg))
+		}
+	}
+	require.Equal(t, []int64{1660000000000, 1660000001000, 1660000003000}, got)
+
+	var report strings.Builder
+	_, err = stats.WriteTo(&report)
+	require.NoError(t, err)
+	require.Contains(t, report.String(), "decode")
+	require.Contains(t, report.String(), "batches: 2")
+}
+
+func TestRun_Rebase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bundle.tgz")
+	writeBundle(t, path, map[string][]byte{"capture/agent_stream.jsonl": testCapture(t)})
+
+	src, err := Open(path)
+	require.NoError(t, err)
+	defer src.Close()
+
+	now := time.UnixMilli(1670000000000)
+	sink := &mockSink{}
+	stats, err := Run(context.Background(), src, Config{
+		Rebase: true,
+		Sink:   sink,
+		Now:    func() time.Time { return now },
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Batches)
+
+	var got []int64
+	for _, msg := range sink.batches[0] {
+		got = append(got, messageTimestamp(msg))
+	}
+	require.Equal(t, []int64{1670000000000, 1670000001000, 1670000003000}, got)
+}
+
+func TestWriterSinkRoundTrip(t *testing.T) {
+	var out bytes.Buffer
+	sink := &WriterSink{W: &out, Hostname: "host"}
+	msg := &model.Message{Value: &model.Message_Event{Event: &model.Event{Name: model.AgentUpName, Timestamp: 1}}}
+	require.NoError(t, sink.Write([]*model.Message{msg}))
+
+	env, err := DecodeEnvelope(bytes.TrimSpace(out.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, "host", env.Hostname)
+	require.Equal(t, model.AgentUpName, env.Message.GetEvent().Name)
+	require.Equal(t, int64(1), env.Message.GetEvent().Timestamp)
+
+	_, err = DecodeEnvelope([]byte(`{"hostname":"host"}`))
+	require.ErrorIs(t, err, ErrMalformedEnvelope)
+}
+
+func TestEncodeEnvelope_UTCTime(t *testing.T) {
+	msg := &model.Message{Value: &model.Message_Event{Event: &model.Event{Name: model.AgentUpName, Timestamp: 1660000000123}}}
+
+	line, err := EncodeEnvelope("host", msg)
+	require.NoError(t, err)
+	require.Contains(t, string(line), `"time":"2022-08-08T23:06:40.123Z"`)
+
+	env, err := DecodeEnvelope(line)
+	require.NoError(t, err)
+	require.Equal(t, int64(1660000000123), env.Message.GetEvent().Timestamp)
+}
@@ -0,0 +1,81 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"agent/api/v1/model"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// SinkFactory constructors of the available sinks, keyed by name.
+var SinkFactory = map[string]func(w io.Writer) Sink{
+	"stdout":  func(w io.Writer) Sink { return &WriterSink{W: w} },
+	"discard": func(io.Writer) Sink { return discardSink{} },
+}
+
+// WriterSink writes replayed messages to W as JSON-lines envelopes, in
+// the same format they were captured.
+type WriterSink struct {
+	W        io.Writer
+	Hostname string
+}
+
+// Write implements Sink.
+func (s *WriterSink) Write(batch []*model.Message) error {
+	for _, msg := range batch {
+		line, err := EncodeEnvelope(s.Hostname, msg)
+		if err != nil {
+			return err
+		}
+
+		if _, err := s.W.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type discardSink struct{}
+
+func (discardSink) Write([]*model.Message) error { return nil }
+
+// EncodeEnvelope encodes msg as a JSON-lines envelope.
+func EncodeEnvelope(hostname string, msg *model.Message) ([]byte, error) {
+	line := envelopeLine{Hostname: hostname}
+	if ts := messageTimestamp(msg); ts != 0 {
+		line.Time = model.FormatUTC(time.UnixMilli(ts))
+	}
+
+	var err error
+	switch {
+	case msg.GetMetricFamily() != nil:
+		line.Mf, err = protojson.Marshal(msg.GetMetricFamily())
+	case msg.GetEvent() != nil:
+		line.Ev, err = protojson.Marshal(msg.GetEvent())
+	default:
+		return nil, fmt.Errorf("message %q without a value", msg.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&line)
+}
@@ -0,0 +1,169 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"agent/api/v1/model"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// maxLineSize max size of a single captured envelope.
+const maxLineSize = 16 * 1024 * 1024
+
+// Envelope a captured message, as written by the file_stream_exporter.
+type Envelope struct {
+	Hostname string
+	Message  *model.Message
+}
+
+// envelopeLine JSON-lines representation of an envelope.
+type envelopeLine struct {
+	Hostname string          `json:"hostname"`
+	Time     string          `json:"time,omitempty"`
+	Mf       json.RawMessage `json:"mf,omitempty"`
+	Ev       json.RawMessage `json:"ev,omitempty"`
+}
+
+// ErrMalformedEnvelope returned for lines that are not valid envelopes.
+var ErrMalformedEnvelope = errors.New("malformed envelope")
+
+// DecodeEnvelope decodes a single JSON-lines envelope.
+func DecodeEnvelope(line []byte) (*Envelope, error) {
+	var l envelopeLine
+	if err := json.Unmarshal(line, &l); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedEnvelope, err)
+	}
+
+	msg := &model.Message{}
+	switch {
+	case len(l.Mf) > 0:
+		mf := &model.MetricFamily{}
+		if err := protojson.Unmarshal(l.Mf, mf); err != nil {
+			return nil, fmt.Errorf("%w: mf: %v", ErrMalformedEnvelope, err)
+		}
+		msg.Name = mf.Name
+		msg.Value = &model.Message_MetricFamily{MetricFamily: mf}
+	case len(l.Ev) > 0:
+		ev := &model.Event{}
+		if err := protojson.Unmarshal(l.Ev, ev); err != nil {
+			return nil, fmt.Errorf("%w: ev: %v", ErrMalformedEnvelope, err)
+		}
+		msg.Name = ev.Name
+		msg.Value = &model.Message_Event{Event: ev}
+	default:
+		return nil, fmt.Errorf("%w: no mf or ev value", ErrMalformedEnvelope)
+	}
+
+	return &Envelope{Hostname: l.Hostname, Message: msg}, nil
+}
+
+// Source reads captured envelopes in capture order.
+type Source struct {
+	each func(fn func(line []byte) error) error
+	io.Closer
+}
+
+// Each calls fn for every captured line in order, until fn returns an
+// error or the capture is exhausted.
+func (s *Source) Each(fn func(line []byte) error) error {
+	return s.each(fn)
+}
+
+// Open opens a capture, either a JSON-lines debug export (optionally
+// gzipped) or an offline bundle (.tgz/.tar.gz) of JSON-lines files which
+// are read in archive order.
+func Open(path string) (*Source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.ToLower(filepath.Base(path))
+	switch {
+	case strings.HasSuffix(name, ".tgz"), strings.HasSuffix(name, ".tar.gz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+
+			return nil, err
+		}
+
+		return &Source{each: func(fn func([]byte) error) error { return eachBundleLine(tar.NewReader(gz), fn) }, Closer: f}, nil
+	case strings.HasSuffix(name, ".gz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+
+			return nil, err
+		}
+
+		return &Source{each: func(fn func([]byte) error) error { return eachLine(gz, fn) }, Closer: f}, nil
+	default:
+		return &Source{each: func(fn func([]byte) error) error { return eachLine(f, fn) }, Closer: f}, nil
+	}
+}
+
+func eachBundleLine(tr *tar.Reader, fn func([]byte) error) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if ext := filepath.Ext(hdr.Name); ext != ".jsonl" && ext != ".log" && ext != ".json" {
+			continue
+		}
+
+		if err := eachLine(tr, fn); err != nil {
+			return fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+	}
+}
+
+func eachLine(r io.Reader, fn func([]byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,287 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rollup keeps bounded daily summaries of high-volume samples.
+package rollup
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// dateLayout layout of the UTC day a summary covers.
+	dateLayout = "2006-01-02"
+
+	// day length of a day in UTC.
+	day = 24 * time.Hour
+
+	// DefaultDays default number of days kept.
+	DefaultDays = 14
+
+	// DefaultMaxGap default max time without samples before a day is
+	// flagged as partial.
+	DefaultMaxGap = 5 * time.Minute
+)
+
+// DailyRollupConf DailyRollup configuration.
+type DailyRollupConf struct {
+	// Days number of days kept, including the current one.
+	Days int
+
+	// MaxGap max time without samples, including from the day's start
+	// and until its end, before the day is flagged as partial.
+	MaxGap time.Duration
+
+	// Path file the rollup is persisted to, not persisted if empty.
+	Path string
+
+	// Compression of the daily digests.
+	Compression float64
+}
+
+// Day samples of a single UTC day.
+type Day struct {
+	Date   string    `json:"date"`
+	First  time.Time `json:"first"`
+	Last   time.Time `json:"last"`
+	Gap    bool      `json:"gap"`
+	Digest *Digest   `json:"digest"`
+}
+
+// partial returns true if samples are missing for more than maxGap: the
+// day's observation started late, stopped early or was interrupted.
+func (d *Day) partial(maxGap time.Duration) bool {
+	start, err := time.Parse(dateLayout, d.Date)
+	if err != nil {
+		return true
+	}
+
+	return d.Gap || d.First.Sub(start) > maxGap || start.Add(day).Sub(d.Last) > maxGap
+}
+
+// Stats quantiles of the samples of a range of days.
+type Stats struct {
+	From        string
+	To          string
+	Samples     uint64
+	PartialDays int
+	P50         float64
+	P95         float64
+	P99         float64
+}
+
+// DailyRollup keeps a digest of the samples of each UTC day, for a bounded
+// number of days.
+type DailyRollup struct {
+	DailyRollupConf
+
+	mu          *sync.Mutex
+	days        []*Day
+	lastSummary string
+}
+
+// rollupJSON persisted DailyRollup.
+type rollupJSON struct {
+	Days        []*Day `json:"days"`
+	LastSummary string `json:"last_summary,omitempty"`
+}
+
+// NewDailyRollup DailyRollup constructor.
+func NewDailyRollup(conf DailyRollupConf) *DailyRollup {
+	if conf.Days <= 0 {
+		conf.Days = DefaultDays
+	}
+
+	if conf.MaxGap <= 0 {
+		conf.MaxGap = DefaultMaxGap
+	}
+
+	return &DailyRollup{DailyRollupConf: conf, mu: new(sync.Mutex)}
+}
+
+// Observe adds the sample v observed at t. Returns true if t starts a new
+// day. Samples older than the current day are ignored.
+func (r *DailyRollup) Observe(t time.Time, v float64) bool {
+	t = t.UTC()
+	date := t.Format(dateLayout)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var cur *Day
+	if len(r.days) > 0 {
+		cur = r.days[len(r.days)-1]
+	}
+
+	newDay := false
+	switch {
+	case cur == nil || date > cur.Date:
+		cur = &Day{Date: date, First: t, Digest: NewDigest(r.Compression)}
+		r.days = append(r.days, cur)
+		r.evict()
+		newDay = true
+	case date < cur.Date:
+		return false
+	case t.Sub(cur.Last) > r.MaxGap:
+		cur.Gap = true
+	}
+
+	if t.After(cur.Last) {
+		cur.Last = t
+	}
+	cur.Digest.Add(v)
+
+	return newDay
+}
+
+// evict drops the days that fell out of the kept window.
+func (r *DailyRollup) evict() {
+	newest, _ := time.Parse(dateLayout, r.days[len(r.days)-1].Date)
+	oldest := newest.AddDate(0, 0, 1-r.Days).Format(dateLayout)
+
+	i := sort.Search(len(r.days), func(i int) bool { return r.days[i].Date >= oldest })
+	r.days = r.days[i:]
+}
+
+// Stats returns the stats of the days in [from, to], ok is false if there
+// is no sample.
+func (r *DailyRollup) Stats(from, to time.Time) (Stats, bool) {
+	s := Stats{From: from.UTC().Format(dateLayout), To: to.UTC().Format(dateLayout)}
+	merged := NewDigest(r.Compression)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// days without samples are partial too
+	s.PartialDays = int(to.UTC().Truncate(day).Sub(from.UTC().Truncate(day))/day) + 1
+	for _, d := range r.days {
+		if d.Date < s.From || d.Date > s.To {
+			continue
+		}
+
+		merged.Merge(d.Digest)
+		if !d.partial(r.MaxGap) {
+			s.PartialDays--
+		}
+	}
+
+	s.Samples = merged.Count()
+	if s.Samples == 0 {
+		return s, false
+	}
+
+	s.P50, s.P95, s.P99 = merged.Quantile(0.5), merged.Quantile(0.95), merged.Quantile(0.99)
+
+	return s, true
+}
+
+// Yesterday returns the stats of the UTC day before now.
+func (r *DailyRollup) Yesterday(now time.Time) (Stats, bool) {
+	yesterday := now.UTC().Truncate(day).Add(-day)
+
+	return r.Stats(yesterday, yesterday)
+}
+
+// WeeklySummary returns the stats of the last 7 complete UTC days and of
+// the 7 days before, once every 7 days. ok is false if a summary was
+// returned less than 7 days ago, or there is no sample in the last 7 days.
+func (r *DailyRollup) WeeklySummary(now time.Time) (last, previous Stats, ok bool) {
+	today := now.UTC().Truncate(day)
+
+	r.mu.Lock()
+	if r.lastSummary == "" {
+		// first run, summarize once a full week was observed
+		r.lastSummary = today.Format(dateLayout)
+	}
+	lastSummary, _ := time.Parse(dateLayout, r.lastSummary)
+	r.mu.Unlock()
+
+	if today.Sub(lastSummary) < 7*day {
+		return Stats{}, Stats{}, false
+	}
+
+	last, ok = r.Stats(today.Add(-7*day), today.Add(-day))
+	if !ok {
+		return Stats{}, Stats{}, false
+	}
+	previous, _ = r.Stats(today.Add(-14*day), today.Add(-8*day))
+
+	r.mu.Lock()
+	r.lastSummary = today.Format(dateLayout)
+	r.mu.Unlock()
+
+	return last, previous, true
+}
+
+// Load loads the rollup from Path, if it exists.
+func (r *DailyRollup) Load() error {
+	if r.Path == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(r.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var v rollupJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	sort.Slice(v.Days, func(i, j int) bool { return v.Days[i].Date < v.Days[j].Date })
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.days = v.Days[:0]
+	for _, d := range v.Days {
+		if d.Digest != nil {
+			r.days = append(r.days, d)
+		}
+	}
+	if len(r.days) > 0 {
+		r.evict()
+	}
+	r.lastSummary = v.LastSummary
+
+	return nil
+}
+
+// Save persists the rollup to Path.
+func (r *DailyRollup) Save() error {
+	if r.Path == "" {
+		return nil
+	}
+
+	r.mu.Lock()
+	b, err := json.Marshal(rollupJSON{Days: r.days, LastSummary: r.lastSummary})
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := r.Path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, r.Path)
+}
@@ -0,0 +1,120 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rollup
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// observeDay observes a sample every minute over the UTC day of t, from
+// the given offsets.
+func observeDay(r *DailyRollup, t time.Time, from, to time.Duration, v float64) {
+	start := t.UTC().Truncate(day)
+	for off := from; off < to; off += time.Minute {
+		r.Observe(start.Add(off), v)
+	}
+}
+
+func TestDailyRollup(t *testing.T) {
+	r := NewDailyRollup(DailyRollupConf{Days: 3})
+	d1 := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	// day boundaries are UTC, whatever the sample's zone
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	require.True(t, r.Observe(time.Date(2022, 6, 1, 1, 0, 0, 0, loc), 1))
+	require.Equal(t, "2022-05-31", r.days[0].Date)
+
+	observeDay(r, d1, 0, day, 1)
+	observeDay(r, d1.Add(day), 2*time.Hour, day, 2)
+
+	s, ok := r.Yesterday(d1.Add(day))
+	require.True(t, ok)
+	require.Equal(t, "2022-06-01", s.From)
+	require.Equal(t, uint64(24*60), s.Samples)
+	require.Equal(t, 0, s.PartialDays)
+	require.Equal(t, 1.0, s.P50)
+
+	// started observing late
+	s, ok = r.Yesterday(d1.Add(2 * day))
+	require.True(t, ok)
+	require.Equal(t, 1, s.PartialDays)
+	require.Equal(t, 2.0, s.P99)
+
+	// older than the current day
+	require.False(t, r.Observe(d1, 3))
+
+	// only the last 3 days are kept
+	observeDay(r, d1.Add(2*day), 0, day, 3)
+	observeDay(r, d1.Add(3*day), 0, time.Hour, 4)
+	require.Len(t, r.days, 3)
+	require.Equal(t, "2022-06-02", r.days[0].Date)
+
+	_, ok = r.Yesterday(d1.Add(day))
+	require.False(t, ok)
+}
+
+func TestDailyRollupGap(t *testing.T) {
+	r := NewDailyRollup(DailyRollupConf{MaxGap: 5 * time.Minute})
+	d1 := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	observeDay(r, d1, 0, 12*time.Hour, 1)
+	observeDay(r, d1, 13*time.Hour, day, 1)
+
+	s, ok := r.Yesterday(d1.Add(day))
+	require.True(t, ok)
+	require.Equal(t, 1, s.PartialDays)
+}
+
+func TestDailyRollupWeeklySummary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rollup.json")
+	r := NewDailyRollup(DailyRollupConf{Path: path})
+	d1 := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	_, _, ok := r.WeeklySummary(d1)
+	require.False(t, ok)
+
+	for i := 0; i < 14; i++ {
+		v := 1.0
+		if i >= 7 {
+			v = 1.5
+		}
+		observeDay(r, d1.Add(time.Duration(i)*day), 0, day, v)
+	}
+	require.NoError(t, r.Save())
+
+	// reloaded from the state dir
+	r = NewDailyRollup(DailyRollupConf{Path: path})
+	require.NoError(t, r.Load())
+	require.Len(t, r.days, 14)
+
+	_, _, ok = r.WeeklySummary(d1.Add(6 * day))
+	require.False(t, ok)
+
+	last, previous, ok := r.WeeklySummary(d1.Add(14 * day))
+	require.True(t, ok)
+	require.Equal(t, "2022-06-08", last.From)
+	require.Equal(t, "2022-06-14", last.To)
+	require.Equal(t, 1.5, last.P95)
+	require.Equal(t, 0, last.PartialDays)
+	require.Equal(t, "2022-06-01", previous.From)
+	require.Equal(t, 1.0, previous.P95)
+
+	// once every 7 days
+	_, _, ok = r.WeeklySummary(d1.Add(15 * day))
+	require.False(t, ok)
+}
@@ -0,0 +1,231 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rollup
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"agent/api/v1/model"
+	"agent/internal/pkg/emit"
+	"agent/internal/pkg/global"
+	"agent/pkg/timesync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultSaveInterval default min time between two saves of the rollup,
+	// it is saved on every day rollover too.
+	DefaultSaveInterval = 5 * time.Minute
+
+	// DefaultRoundTimeFilename file under the state directory the round
+	// time rollup is persisted to.
+	DefaultRoundTimeFilename = "round_time_rollup.json"
+)
+
+func init() {
+	global.RegisterCloneReset(global.CloneReset{Component: "round_rollup", Patterns: []string{DefaultRoundTimeFilename}})
+}
+
+// RoundTimeTrackerConf RoundTimeTracker configuration.
+type RoundTimeTrackerConf struct {
+	Rollup *DailyRollup
+
+	// EventName name of the event logged on every round advance.
+	EventName string
+
+	// RoundKey event context key holding the round number.
+	RoundKey string
+
+	// Emitter where the weekly summaries are emitted.
+	Emitter emit.Emitter
+
+	SaveInterval time.Duration
+}
+
+// RoundTimeTracker rolls up the node's round time, computed from the
+// events logged on round advances. It implements global.Exporter to
+// receive the node events, and prometheus.Collector to expose yesterday's
+// quantiles.
+type RoundTimeTracker struct {
+	RoundTimeTrackerConf
+
+	mu        *sync.Mutex
+	lastRound float64
+	lastTime  time.Time
+	lastSave  time.Time
+	now       func() time.Time
+
+	quantileDesc *prometheus.Desc
+	samplesDesc  *prometheus.Desc
+	partialDesc  *prometheus.Desc
+}
+
+// NewRoundTimeTracker RoundTimeTracker constructor.
+func NewRoundTimeTracker(conf RoundTimeTrackerConf) *RoundTimeTracker {
+	if conf.SaveInterval <= 0 {
+		conf.SaveInterval = DefaultSaveInterval
+	}
+
+	return &RoundTimeTracker{
+		RoundTimeTrackerConf: conf,
+		mu:                   new(sync.Mutex),
+		now:                  timesync.Now,
+		quantileDesc: prometheus.NewDesc(
+			"agent_node_round_time_seconds",
+			"Quantiles of the node's round time over the previous UTC day.",
+			[]string{"quantile"}, nil,
+		),
+		samplesDesc: prometheus.NewDesc(
+			"agent_node_round_time_samples",
+			"Number of rounds observed over the previous UTC day.",
+			nil, nil,
+		),
+		partialDesc: prometheus.NewDesc(
+			"agent_node_round_time_partial",
+			"1 if rounds were not observed over the whole previous UTC day.",
+			nil, nil,
+		),
+	}
+}
+
+// HandleMessage implements global.Exporter.
+func (r *RoundTimeTracker) HandleMessage(ctx context.Context, msg *model.Message) {
+	ev := msg.GetEvent()
+	if ev == nil || ev.GetName() != r.EventName {
+		return
+	}
+
+	round, ok := ev.GetValues().AsMap()[r.RoundKey].(float64)
+	if !ok {
+		return
+	}
+
+	r.observe(time.UnixMilli(ev.GetTimestamp()), round)
+}
+
+// observe adds the time per round elapsed since the previous round event.
+func (r *RoundTimeTracker) observe(t time.Time, round float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if round <= r.lastRound || !t.After(r.lastTime) {
+		// out of order or the node restarted from an older round
+		if round < r.lastRound || r.lastTime.IsZero() {
+			r.lastRound, r.lastTime = round, t
+		}
+
+		return
+	}
+
+	elapsed, rounds := t.Sub(r.lastTime), round-r.lastRound
+	r.lastRound, r.lastTime = round, t
+	if elapsed > r.Rollup.MaxGap {
+		// not observed meanwhile, flagged as a gap by the next sample
+		return
+	}
+
+	if r.Rollup.Observe(t, elapsed.Seconds()/rounds) {
+		r.summarize(t)
+		r.save(t)
+	} else if t.Sub(r.lastSave) >= r.SaveInterval {
+		r.save(t)
+	}
+}
+
+func (r *RoundTimeTracker) save(t time.Time) {
+	r.lastSave = t
+	if err := r.Rollup.Save(); err != nil {
+		zap.S().Errorw("error saving round time rollup", zap.Error(err))
+	}
+}
+
+// summarize emits the weekly summary, if due.
+func (r *RoundTimeTracker) summarize(now time.Time) {
+	last, previous, ok := r.Rollup.WeeklySummary(now)
+	if !ok || r.Emitter == nil {
+		return
+	}
+
+	ctx := map[string]interface{}{model.LastWeekKey: statsContext(last)}
+	if previous.Samples > 0 {
+		ctx[model.PreviousWeekKey] = statsContext(previous)
+		ctx[model.ChangeKey] = map[string]interface{}{
+			"p50": change(previous.P50, last.P50),
+			"p95": change(previous.P95, last.P95),
+			"p99": change(previous.P99, last.P99),
+		}
+	}
+
+	ev, err := model.NewWithCtx(ctx, model.AgentNodeRoundTimeWeeklyName, now)
+	if err != nil {
+		zap.S().Errorw("error creating event", zap.Error(err))
+
+		return
+	}
+
+	if err := emit.Ev(r.Emitter, ev); err != nil {
+		zap.S().Errorw("error emitting event", zap.Error(err))
+	}
+}
+
+func statsContext(s Stats) map[string]interface{} {
+	return map[string]interface{}{
+		"from":         s.From,
+		"to":           s.To,
+		"samples":      s.Samples,
+		"partial_days": s.PartialDays,
+		"p50":          s.P50,
+		"p95":          s.P95,
+		"p99":          s.P99,
+	}
+}
+
+// change returns the relative change from prev to cur.
+func change(prev, cur float64) float64 {
+	if prev == 0 {
+		return 0
+	}
+
+	return (cur - prev) / prev
+}
+
+// Describe implements prometheus.Collector.
+func (r *RoundTimeTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- r.quantileDesc
+	ch <- r.samplesDesc
+	ch <- r.partialDesc
+}
+
+// Collect implements prometheus.Collector.
+func (r *RoundTimeTracker) Collect(ch chan<- prometheus.Metric) {
+	s, ok := r.Rollup.Yesterday(r.now())
+	if !ok {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(r.quantileDesc, prometheus.GaugeValue, s.P50, "0.5")
+	ch <- prometheus.MustNewConstMetric(r.quantileDesc, prometheus.GaugeValue, s.P95, "0.95")
+	ch <- prometheus.MustNewConstMetric(r.quantileDesc, prometheus.GaugeValue, s.P99, "0.99")
+	ch <- prometheus.MustNewConstMetric(r.samplesDesc, prometheus.GaugeValue, float64(s.Samples))
+
+	partial := 0.0
+	if s.PartialDays > 0 {
+		partial = 1
+	}
+	ch <- prometheus.MustNewConstMetric(r.partialDesc, prometheus.GaugeValue, partial)
+}
@@ -0,0 +1,94 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rollup
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"agent/api/v1/model"
+	"agent/internal/pkg/emit"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func roundEvent(t *testing.T, name string, round int, at time.Time) *model.Message {
+	ev, err := model.NewWithCtx(map[string]interface{}{"view": round}, name, at)
+	require.NoError(t, err)
+
+	return &model.Message{Name: name, Value: &model.Message_Event{Event: ev}}
+}
+
+func TestRoundTimeTracker(t *testing.T) {
+	emitch := make(chan interface{}, 10)
+	tracker := NewRoundTimeTracker(RoundTimeTrackerConf{
+		Rollup:    NewDailyRollup(DailyRollupConf{}),
+		EventName: "OnFinalizedBlock",
+		RoundKey:  "view",
+		Emitter:   emit.NewSimpleEmitter(emitch),
+	})
+	d1 := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	// a 30 rounds advance every minute, 2s per round the first week and
+	// 3s per round the next one
+	round := 1000
+	for at := d1; at.Before(d1.Add(15 * day)); at = at.Add(time.Minute) {
+		round += 30
+		if at.Sub(d1) >= 7*day {
+			round -= 10
+		}
+		tracker.HandleMessage(ctx, roundEvent(t, "OnFinalizedBlock", round, at))
+
+		// ignored
+		tracker.HandleMessage(ctx, roundEvent(t, "OnVoting", round+1, at))
+	}
+
+	tracker.now = func() time.Time { return d1.Add(8*day + time.Hour) }
+	exp := `
+# HELP agent_node_round_time_partial 1 if rounds were not observed over the whole previous UTC day.
+# TYPE agent_node_round_time_partial gauge
+agent_node_round_time_partial 0
+# HELP agent_node_round_time_samples Number of rounds observed over the previous UTC day.
+# TYPE agent_node_round_time_samples gauge
+agent_node_round_time_samples 1440
+# HELP agent_node_round_time_seconds Quantiles of the node's round time over the previous UTC day.
+# TYPE agent_node_round_time_seconds gauge
+agent_node_round_time_seconds{quantile="0.5"} 3
+agent_node_round_time_seconds{quantile="0.95"} 3
+agent_node_round_time_seconds{quantile="0.99"} 3
+`
+	require.NoError(t, testutil.CollectAndCompare(tracker, strings.NewReader(exp)))
+
+	// summarized every 7 days, the first time without a previous week
+	require.Len(t, emitch, 2)
+	ev := (<-emitch).(*model.Message).GetEvent()
+	require.Equal(t, model.AgentNodeRoundTimeWeeklyName, ev.Name)
+	values := ev.Values.AsMap()
+	require.Equal(t, "2022-06-01", values[model.LastWeekKey].(map[string]interface{})["from"])
+	require.NotContains(t, values, model.PreviousWeekKey)
+
+	ev = (<-emitch).(*model.Message).GetEvent()
+	values = ev.Values.AsMap()
+	last := values[model.LastWeekKey].(map[string]interface{})
+	require.Equal(t, "2022-06-08", last["from"])
+	require.Equal(t, "2022-06-14", last["to"])
+	require.Equal(t, 3.0, last["p50"])
+	require.Equal(t, 0.0, last["partial_days"])
+	require.Equal(t, 2.0, values[model.PreviousWeekKey].(map[string]interface{})["p50"])
+	require.Equal(t, 0.5, values[model.ChangeKey].(map[string]interface{})["p95"])
+}
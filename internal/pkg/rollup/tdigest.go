@@ -0,0 +1,210 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rollup
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+)
+
+// DefaultCompression default digest compression. The number of centroids
+// is bounded by roughly the compression, a digest takes about 2KB once
+// serialized.
+const DefaultCompression = 50
+
+// centroid a cluster of samples, serialized as [mean, count].
+type centroid struct {
+	mean  float64
+	count float64
+}
+
+// Digest merging t-digest estimating quantiles of a stream of samples in
+// bounded space. Not thread-safe.
+type Digest struct {
+	compression float64
+	centroids   []centroid
+	unmerged    []centroid
+	count       float64
+	min, max    float64
+}
+
+// NewDigest Digest constructor.
+func NewDigest(compression float64) *Digest {
+	if compression <= 0 {
+		compression = DefaultCompression
+	}
+
+	return &Digest{compression: compression, min: math.Inf(1), max: math.Inf(-1)}
+}
+
+// Add adds a sample.
+func (d *Digest) Add(x float64) {
+	d.add(centroid{mean: x, count: 1})
+}
+
+// Merge adds all samples of o.
+func (d *Digest) Merge(o *Digest) {
+	for _, c := range o.centroids {
+		d.add(c)
+	}
+	for _, c := range o.unmerged {
+		d.add(c)
+	}
+}
+
+func (d *Digest) add(c centroid) {
+	if math.IsNaN(c.mean) || c.count <= 0 {
+		return
+	}
+
+	d.unmerged = append(d.unmerged, c)
+	d.count += c.count
+	d.min = math.Min(d.min, c.mean)
+	d.max = math.Max(d.max, c.mean)
+
+	if len(d.unmerged) >= int(5*d.compression) {
+		d.compress()
+	}
+}
+
+// Count returns the number of samples.
+func (d *Digest) Count() uint64 {
+	return uint64(d.count)
+}
+
+// compress merges the unmerged samples into the centroids, bounding each
+// centroid's weight with the k1 scale function: centroids are small near
+// the tails, where quantiles need precision, and large around the median.
+func (d *Digest) compress() {
+	if len(d.unmerged) == 0 {
+		return
+	}
+
+	all := append(d.centroids, d.unmerged...)
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+
+	merged := []centroid{all[0]}
+	soFar := 0.0
+	limit := d.count * d.kInv(d.k(0)+1)
+	for _, c := range all[1:] {
+		last := &merged[len(merged)-1]
+		if soFar+last.count+c.count <= limit {
+			last.count += c.count
+			last.mean += (c.mean - last.mean) * c.count / last.count
+
+			continue
+		}
+
+		soFar += last.count
+		limit = d.count * d.kInv(d.k(soFar/d.count)+1)
+		merged = append(merged, c)
+	}
+
+	d.centroids = merged
+	d.unmerged = nil
+}
+
+func (d *Digest) k(q float64) float64 {
+	return d.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+func (d *Digest) kInv(k float64) float64 {
+	if k >= d.compression/4 {
+		return 1
+	}
+
+	return (math.Sin(k*2*math.Pi/d.compression) + 1) / 2
+}
+
+// Quantile returns the estimated q-quantile, NaN if there is no sample.
+func (d *Digest) Quantile(q float64) float64 {
+	d.compress()
+
+	switch {
+	case len(d.centroids) == 0:
+		return math.NaN()
+	case q <= 0:
+		return d.min
+	case q >= 1:
+		return d.max
+	case len(d.centroids) == 1:
+		return d.centroids[0].mean
+	}
+
+	// interpolate between the centers of the centroids around the target
+	target := q * d.count
+	prevMean, prevCenter := d.min, 0.0
+	cumulative := 0.0
+	for _, c := range d.centroids {
+		center := cumulative + c.count/2
+		if target < center {
+			return interpolate(target, prevCenter, center, prevMean, c.mean)
+		}
+
+		prevMean, prevCenter = c.mean, center
+		cumulative += c.count
+	}
+
+	return interpolate(target, prevCenter, d.count, prevMean, d.max)
+}
+
+func interpolate(x, x0, x1, y0, y1 float64) float64 {
+	if x1 <= x0 {
+		return y1
+	}
+
+	return y0 + (y1-y0)*(x-x0)/(x1-x0)
+}
+
+type digestJSON struct {
+	Compression float64      `json:"compression"`
+	Min         float64      `json:"min"`
+	Max         float64      `json:"max"`
+	Centroids   [][2]float64 `json:"centroids"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d *Digest) MarshalJSON() ([]byte, error) {
+	d.compress()
+
+	v := digestJSON{Compression: d.compression, Centroids: make([][2]float64, 0, len(d.centroids))}
+	if len(d.centroids) > 0 {
+		v.Min, v.Max = d.min, d.max
+	}
+	for _, c := range d.centroids {
+		v.Centroids = append(v.Centroids, [2]float64{c.mean, c.count})
+	}
+
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Digest) UnmarshalJSON(b []byte) error {
+	var v digestJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	*d = *NewDigest(v.Compression)
+	for _, c := range v.Centroids {
+		d.centroids = append(d.centroids, centroid{mean: c[0], count: c[1]})
+		d.count += c[1]
+	}
+	if len(d.centroids) > 0 {
+		d.min, d.max = v.Min, v.Max
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rollup
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestQuantile(t *testing.T) {
+	d := NewDigest(DefaultCompression)
+	require.True(t, math.IsNaN(d.Quantile(0.5)))
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		d.Add(rnd.Float64())
+	}
+
+	require.Equal(t, uint64(100000), d.Count())
+	require.InDelta(t, 0.5, d.Quantile(0.5), 0.01)
+	require.InDelta(t, 0.95, d.Quantile(0.95), 0.005)
+	require.InDelta(t, 0.99, d.Quantile(0.99), 0.002)
+	require.LessOrEqual(t, len(d.centroids), 2*DefaultCompression)
+}
+
+func TestDigestMerge(t *testing.T) {
+	a, b := NewDigest(DefaultCompression), NewDigest(DefaultCompression)
+	for i := 0; i < 1000; i++ {
+		a.Add(float64(i))
+		b.Add(float64(i + 1000))
+	}
+
+	a.Merge(b)
+	require.Equal(t, uint64(2000), a.Count())
+	require.InDelta(t, 1000, a.Quantile(0.5), 20)
+	require.Equal(t, 0.0, a.Quantile(0))
+	require.Equal(t, 1999.0, a.Quantile(1))
+}
+
+func TestDigestJSON(t *testing.T) {
+	d := NewDigest(DefaultCompression)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		d.Add(rnd.ExpFloat64())
+	}
+
+	b, err := json.Marshal(d)
+	require.NoError(t, err)
+	require.Less(t, len(b), 4096)
+
+	got := new(Digest)
+	require.NoError(t, json.Unmarshal(b, got))
+	require.Equal(t, d.Count(), got.Count())
+	for _, q := range []float64{0, 0.5, 0.95, 0.99, 1} {
+		require.Equal(t, d.Quantile(q), got.Quantile(q))
+	}
+}
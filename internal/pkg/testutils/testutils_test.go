@@ -42,19 +42,39 @@ func TestGenerateCollectorSamples(t *testing.T) {
 		"prometheus.proc.net.arp_linux",
 		"prometheus.proc.stat_linux",
 		"prometheus.proc.conntrack_linux",
+		"prometheus.proc.bonding",
+		"prometheus.proc.buddyinfo",
+		"prometheus.proc.cgroup",
 		"prometheus.proc.diskstats",
 		"prometheus.proc.entropy",
 		"prometheus.proc.filefd",
 		"prometheus.proc.filesystem",
+		"prometheus.proc.hwmon",
+		"prometheus.proc.infiniband",
+		"prometheus.proc.interrupts",
+		"prometheus.proc.ipvs",
 		"prometheus.proc.loadavg",
+		"prometheus.proc.mdadm",
 		"prometheus.proc.meminfo",
 		"prometheus.proc.netclass",
 		"prometheus.proc.netdev",
+		"prometheus.proc.power_supply",
+		"prometheus.proc.processes",
+		"prometheus.proc.rapl",
+		"prometheus.proc.schedstat",
+		"prometheus.proc.softirqs",
 		"prometheus.proc.sockstat",
+		"prometheus.proc.tcpstat",
 		"prometheus.proc.textfile",
+		"prometheus.proc.thermal_zone",
+		"prometheus.proc.udp_queues",
+		"prometheus.os_release",
+		"prometheus.systemd",
 		"prometheus.time",
+		"prometheus.timex",
 		"prometheus.uname",
 		"prometheus.vmstat",
+		"prometheus.proc.wifi",
 	}
 
 	collectorConfigs := []*global.WatchConfig{}
@@ -18,6 +18,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 
@@ -39,6 +40,14 @@ var (
 
 	// AgentAPIKeyHeaderName GRPC metadata key name for platform API key
 	AgentAPIKeyHeaderName = "x-api-key"
+
+	// SchemaVersionsHeaderName GRPC metadata key name for the schema
+	// versions supported by the agent (request) or the platform (response).
+	SchemaVersionsHeaderName = "x-schema-versions"
+
+	// SchemaVersionHeaderName GRPC metadata key name for the schema version
+	// of the transmitted batch (request) or the one selected by the platform (response).
+	SchemaVersionHeaderName = "x-schema-version"
 )
 
 const (
@@ -83,12 +92,16 @@ type PlatformGRPC struct {
 	metadata   metadata.MD
 	lock       *sync.RWMutex
 	blockchain global.Chain
+
+	// schemaVersion schema version negotiated with the platform.
+	schemaVersion model.SchemaVersion
 }
 
 // NewPlatformGRPC platform transport constructor.
 func NewPlatformGRPC(conf PlatformGRPCConf) (*PlatformGRPC, error) {
 	// Anything put here will be transmitted as request headers.
-	md := metadata.Pairs(AgentUUIDHeaderName, conf.UUID, AgentAPIKeyHeaderName, conf.APIKey)
+	md := metadata.Pairs(AgentUUIDHeaderName, conf.UUID, AgentAPIKeyHeaderName, conf.APIKey,
+		SchemaVersionsHeaderName, model.FormatSchemaVersions(model.SupportedSchemaVersions()))
 
 	if conf.UUID == "" || conf.APIKey == "" || conf.URL == "" {
 		return nil, fmt.Errorf("invalid platform configuration (check uuid, api key or url): %+v", conf)
@@ -102,7 +115,12 @@ func NewPlatformGRPC(conf PlatformGRPCConf) (*PlatformGRPC, error) {
 		conf.TransmitTimeout = defaultTransmitTimeout
 	}
 
-	p := &PlatformGRPC{PlatformGRPCConf: conf, metadata: md, lock: &sync.RWMutex{}}
+	p := &PlatformGRPC{
+		PlatformGRPCConf: conf,
+		metadata:         md,
+		lock:             &sync.RWMutex{},
+		schemaVersion:    model.CurrentSchemaVersion,
+	}
 
 	p.GrpcErrHandler = p.grpcErrorHandler
 	p.blockchain = global.BlockchainNode()
@@ -133,10 +151,13 @@ func (t *PlatformGRPC) Publish(data []*model.Message) (int64, error) {
 		}
 	}
 
-	ctx = metadata.NewOutgoingContext(ctx, t.metadata)
+	md := t.metadata.Copy()
+	md.Set(SchemaVersionHeaderName, strconv.Itoa(int(t.schemaVersion)))
+	ctx = metadata.NewOutgoingContext(ctx, md)
 
 	// Transmit to platform. Failure here signifies transient error.
-	resp, err := t.AgentService.Transmit(ctx, &metrikaMsg)
+	var header metadata.MD
+	resp, err := t.AgentService.Transmit(ctx, &metrikaMsg, grpc.Header(&header))
 	if err != nil {
 		zap.S().Errorw("failed to transmit to the platform", zap.Error(err), "addr", t.URL)
 
@@ -148,9 +169,55 @@ func (t *PlatformGRPC) Publish(data []*model.Message) (int64, error) {
 		return 0, err
 	}
 
+	t.negotiateSchemaVersion(header)
+
 	return resp.Timestamp, nil
 }
 
+// negotiateSchemaVersion updates the schema version used for subsequent
+// batches from the platform's response headers. Platforms not taking part
+// in negotiation leave the current version unchanged.
+func (t *PlatformGRPC) negotiateSchemaVersion(header metadata.MD) {
+	selected := header.Get(SchemaVersionHeaderName)
+	if len(selected) == 0 {
+		return
+	}
+
+	v, err := strconv.ParseUint(selected[0], 10, 16)
+	if err != nil {
+		zap.S().Warnw("invalid schema version selected by the platform", "version", selected[0])
+		v = 0
+	}
+
+	var serverSupported []model.SchemaVersion
+	if supported := header.Get(SchemaVersionsHeaderName); len(supported) > 0 {
+		serverSupported, err = model.ParseSchemaVersions(supported[0])
+		if err != nil {
+			zap.S().Warnw("invalid schema versions advertised by the platform", zap.Error(err))
+		}
+	}
+
+	version, err := model.NegotiateSchemaVersion(model.SupportedSchemaVersions(), model.SchemaVersion(v), serverSupported)
+	if err != nil {
+		zap.S().Errorw("schema version negotiation failed", zap.Error(err), "current", t.schemaVersion)
+
+		return
+	}
+
+	if version != t.schemaVersion {
+		zap.S().Infow("schema version negotiated with the platform", "version", version)
+		t.schemaVersion = version
+	}
+}
+
+// SchemaVersion returns the schema version negotiated with the platform.
+func (t *PlatformGRPC) SchemaVersion() model.SchemaVersion {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.schemaVersion
+}
+
 func (t *PlatformGRPC) grpcErrorHandler() error {
 	t.AgentService = nil
 	return t.grpcConn.Close()
@@ -200,6 +267,7 @@ func (t *PlatformGRPC) PublishFunc(b buf.ItemBatch) error {
 		if err != nil {
 			platformPublishErrors.Inc()
 			global.AgentRuntimeState.SetPublishState(global.PlatformStateDown)
+			global.AgentRuntimeState.RecordExportFailure()
 
 			errCh <- err
 			return
@@ -209,6 +277,7 @@ func (t *PlatformGRPC) PublishFunc(b buf.ItemBatch) error {
 		}
 		metricsPublishedCnt.Add(float64(len(batch)))
 		global.AgentRuntimeState.SetPublishState(global.PlatformStateUp)
+		global.AgentRuntimeState.RecordExport(time.Now())
 
 		errCh <- nil
 	}()
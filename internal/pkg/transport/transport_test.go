@@ -13,6 +13,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/test/bufconn"
 )
 
@@ -28,11 +29,19 @@ type server struct {
 	model.UnimplementedAgentServer
 
 	gotPlatformMessage *model.PlatformMessage
+	gotMetadata        metadata.MD
+	respHeader         metadata.MD
 }
 
 // Transmit implements metrika.AgentServer
 func (s *server) Transmit(ctx context.Context, in *model.PlatformMessage) (*model.PlatformResponse, error) {
 	s.gotPlatformMessage = in
+	s.gotMetadata, _ = metadata.FromIncomingContext(ctx)
+	if s.respHeader != nil {
+		if err := grpc.SetHeader(ctx, s.respHeader); err != nil {
+			return nil, err
+		}
+	}
 	return &model.PlatformResponse{Timestamp: time.Now().UnixMilli()}, nil
 }
 
@@ -87,3 +96,42 @@ func TestPlatformGRPC_Publish(t *testing.T) {
 	require.Equal(t, global.BlockchainNode().Network(), mockServer.gotPlatformMessage.Network)
 	require.Equal(t, global.BlockchainNode().Protocol(), mockServer.gotPlatformMessage.Protocol)
 }
+
+func TestPlatformGRPC_SchemaNegotiation(t *testing.T) {
+	global.SetBlockchainNode(&discover.MockBlockchain{})
+	defer func() { mockServer.respHeader = nil }()
+
+	conf := PlatformGRPCConf{
+		UUID:           "agent-uuid",
+		APIKey:         "agent-apikey",
+		URL:            "bufnet",
+		Dialer:         bufDialer,
+		ConnectTimeout: 10 * time.Second,
+	}
+	transp, err := NewPlatformGRPC(conf)
+	require.Nil(t, err)
+	require.Equal(t, model.CurrentSchemaVersion, transp.SchemaVersion())
+
+	// platform not taking part in negotiation
+	_, err = transp.Publish(nil)
+	require.Nil(t, err)
+	require.Equal(t, []string{"1,2"}, mockServer.gotMetadata.Get(SchemaVersionsHeaderName))
+	require.Equal(t, []string{"2"}, mockServer.gotMetadata.Get(SchemaVersionHeaderName))
+	require.Equal(t, model.CurrentSchemaVersion, transp.SchemaVersion())
+
+	// platform selects a supported version
+	mockServer.respHeader = metadata.Pairs(SchemaVersionHeaderName, "1")
+	_, err = transp.Publish(nil)
+	require.Nil(t, err)
+	require.Equal(t, model.SchemaVersionJSON, transp.SchemaVersion())
+
+	_, err = transp.Publish(nil)
+	require.Nil(t, err)
+	require.Equal(t, []string{"1"}, mockServer.gotMetadata.Get(SchemaVersionHeaderName))
+
+	// platform selects an unknown version, fall back to the lowest common
+	mockServer.respHeader = metadata.Pairs(SchemaVersionHeaderName, "3", SchemaVersionsHeaderName, "3,2")
+	_, err = transp.Publish(nil)
+	require.Nil(t, err)
+	require.Equal(t, model.SchemaVersionProtobuf, transp.SchemaVersion())
+}
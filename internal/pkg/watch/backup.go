@@ -0,0 +1,451 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"agent/api/v1/model"
+	"agent/internal/pkg/global"
+	"agent/pkg/timesync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// MinBackupWatchS3Interval min interval between two S3 listings.
+	MinBackupWatchS3Interval = time.Minute
+
+	// backupCheckTimeout max duration of a single backup check.
+	backupCheckTimeout = time.Minute
+
+	// s3MaxKeys number of objects requested per S3 list page.
+	s3MaxKeys = 1000
+
+	// s3MaxResponseSize max bytes read from a single S3 list response.
+	s3MaxResponseSize = 16 << 20
+
+	// s3EmptyPayloadHash SHA256 of an empty request body.
+	s3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// errS3ListTruncated returned along with the newest artifact seen, when
+// the S3 listing exceeds the configured max pages.
+var errS3ListTruncated = errors.New("s3 listing truncated, newest backup may be inaccurate")
+
+// backupArtifact a single backup file or object.
+type backupArtifact struct {
+	Path    string
+	ModTime time.Time
+	Size    int64
+}
+
+// backupSource finds the newest backup artifact. A nil artifact with a nil
+// error means no backup exists. A non-nil error may be returned along with
+// an artifact if only part of the source could be inspected.
+type backupSource interface {
+	Newest(ctx context.Context) (*backupArtifact, error)
+	String() string
+}
+
+// BackupWatchConf BackupWatch configuration.
+type BackupWatchConf struct {
+	Type     global.WatchType
+	Interval time.Duration
+
+	// Glob local path pattern matching backup files, ignored if S3 is set.
+	Glob string
+
+	// S3 S3-compatible bucket/prefix containing backup objects.
+	S3 *global.S3Config
+
+	// AgeThresholds backup age thresholds, each exceeded
+	// threshold escalates the emitted event severity.
+	AgeThresholds []time.Duration
+
+	// Client HTTP client used for S3 requests. Defaults to a
+	// client honoring the HTTP(S)_PROXY environment variables.
+	Client *http.Client
+}
+
+// BackupWatch checks the freshness of node data directory backups.
+// Emits the newest backup's age and size as gauges and escalating
+// events when its age exceeds the configured thresholds.
+type BackupWatch struct {
+	BackupWatchConf
+	Watch
+
+	source   backupSource
+	severity int
+	missing  bool
+}
+
+// NewBackupWatch BackupWatch constructor.
+func NewBackupWatch(conf BackupWatchConf) (*BackupWatch, error) {
+	w := new(BackupWatch)
+	w.BackupWatchConf = conf
+	w.Watch = NewWatch()
+	w.Name = "backup"
+
+	switch {
+	case w.S3 != nil:
+		if w.S3.Bucket == "" {
+			return nil, errors.New("backup watch: s3 bucket is required")
+		}
+
+		if w.Client == nil {
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.Proxy = http.ProxyFromEnvironment
+			w.Client = &http.Client{Transport: transport, Timeout: 30 * time.Second}
+		}
+
+		if w.Interval < MinBackupWatchS3Interval {
+			w.Log.Warnw("backup watch interval too short for s3, using min interval",
+				"interval", w.Interval, "min_interval", MinBackupWatchS3Interval)
+			w.Interval = MinBackupWatchS3Interval
+		}
+
+		w.source = newS3Source(*w.S3, w.Client)
+	case w.Glob != "":
+		if _, err := filepath.Match(w.Glob, ""); err != nil {
+			return nil, fmt.Errorf("backup watch: invalid glob %q: %w", w.Glob, err)
+		}
+
+		w.source = globSource{pattern: w.Glob}
+	default:
+		return nil, errors.New("backup watch: one of glob or s3 is required")
+	}
+
+	if w.Interval < 1 {
+		w.Interval = global.DefaultRuntimeWatchersBackupInterval
+	}
+
+	w.AgeThresholds = append([]time.Duration(nil), w.AgeThresholds...)
+	sort.Slice(w.AgeThresholds, func(i, j int) bool { return w.AgeThresholds[i] < w.AgeThresholds[j] })
+
+	w.Log = w.Log.With("watch", w.Type, "source", w.source.String())
+
+	return w, nil
+}
+
+// StartUnsafe starts the goroutine checking backup freshness.
+func (w *BackupWatch) StartUnsafe() {
+	w.Watch.StartUnsafe()
+
+	w.wg.Add(1)
+	go w.checkLoop()
+}
+
+func (w *BackupWatch) checkLoop() {
+	defer w.wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-w.StopKey
+		cancel()
+	}()
+
+	w.check(ctx)
+	for {
+		select {
+		case <-time.After(w.Interval):
+			w.check(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *BackupWatch) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, backupCheckTimeout)
+	defer cancel()
+
+	artifact, err := w.source.Newest(ctx)
+	if err != nil {
+		w.Log.Warnw("backup check incomplete", zap.Error(err))
+		w.ReportError(err)
+	}
+
+	if artifact == nil {
+		if err == nil && !w.missing {
+			w.missing = true
+			w.emitAgentNodeEventWithCtx(model.AgentNodeBackupMissingName, map[string]interface{}{
+				model.BackupPathKey: w.source.String(),
+			})
+		}
+
+		return
+	}
+	w.missing = false
+
+	now := timesync.Now()
+	age := now.Sub(artifact.ModTime)
+	if age < 0 {
+		age = 0
+	}
+
+	w.emitMetrics(now, age, artifact)
+	w.escalate(age, artifact)
+}
+
+// escalate emits an event every time the backup exceeds a higher age
+// threshold, and once when it becomes fresh again.
+func (w *BackupWatch) escalate(age time.Duration, artifact *backupArtifact) {
+	severity := 0
+	for _, threshold := range w.AgeThresholds {
+		if age > threshold {
+			severity++
+		}
+	}
+
+	switch {
+	case severity > w.severity:
+		w.emitAgentNodeEventWithCtx(model.AgentNodeBackupStaleName, map[string]interface{}{
+			model.BackupPathKey: artifact.Path,
+			model.BackupAgeKey:  age.String(),
+			model.ThresholdKey:  w.AgeThresholds[severity-1].String(),
+			model.SeverityKey:   severity,
+		})
+	case severity == 0 && w.severity > 0:
+		w.emitAgentNodeEventWithCtx(model.AgentNodeBackupFreshName, map[string]interface{}{
+			model.BackupPathKey: artifact.Path,
+			model.BackupAgeKey:  age.String(),
+		})
+	}
+
+	w.severity = severity
+}
+
+func (w *BackupWatch) emitMetrics(now time.Time, age time.Duration, artifact *backupArtifact) {
+	labels := []*dto.LabelPair{{Name: proto.String("source"), Value: proto.String(w.source.String())}}
+
+	metricFams := []*dto.MetricFamily{
+		{
+			Name:   proto.String(namespace + "_backup_age_seconds"),
+			Help:   proto.String("Age of the newest backup artifact in seconds."),
+			Type:   dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{Label: labels, Gauge: &dto.Gauge{Value: proto.Float64(age.Seconds())}}},
+		},
+		{
+			Name:   proto.String(namespace + "_backup_size_bytes"),
+			Help:   proto.String("Size of the newest backup artifact in bytes."),
+			Type:   dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{Label: labels, Gauge: &dto.Gauge{Value: proto.Float64(float64(artifact.Size))}}},
+		},
+	}
+	setDTOMetriFamilyTimestamp(now, metricFams...)
+
+	for _, metricFam := range metricFams {
+		openMetricFam, err := dtoToOpenMetrics(metricFam)
+		if err != nil {
+			w.Log.Errorw("failed to convert metric to openmetrics", zap.Error(err))
+
+			continue
+		}
+
+		w.Emit(&model.Message{
+			Name:  string(w.Type),
+			Value: &model.Message_MetricFamily{MetricFamily: openMetricFam},
+		})
+	}
+}
+
+// globSource finds backups on the local filesystem.
+type globSource struct {
+	pattern string
+}
+
+func (g globSource) String() string {
+	return g.pattern
+}
+
+// Newest returns the most recently modified regular file matching the
+// pattern. Files that can not be inspected (i.e. permission denied) are
+// skipped and reported in the returned error.
+func (g globSource) Newest(ctx context.Context) (*backupArtifact, error) {
+	matches, err := filepath.Glob(g.pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		newest *backupArtifact
+		errs   []string
+	)
+	for _, match := range matches {
+		if ctx.Err() != nil {
+			return newest, ctx.Err()
+		}
+
+		info, err := os.Stat(match)
+		if err != nil {
+			errs = append(errs, err.Error())
+
+			continue
+		}
+
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		if newest == nil || info.ModTime().After(newest.ModTime) {
+			newest = &backupArtifact{Path: match, ModTime: info.ModTime(), Size: info.Size()}
+		}
+	}
+
+	if len(errs) > 0 {
+		return newest, fmt.Errorf("failed to inspect %d backup files: %s", len(errs), strings.Join(errs, "; "))
+	}
+
+	return newest, nil
+}
+
+// s3Source finds backups in an S3-compatible bucket.
+type s3Source struct {
+	conf     global.S3Config
+	client   *http.Client
+	signer   *v4.Signer
+	endpoint string
+}
+
+func newS3Source(conf global.S3Config, client *http.Client) *s3Source {
+	if conf.Region == "" {
+		conf.Region = "us-east-1"
+	}
+
+	if conf.MaxPages < 1 {
+		conf.MaxPages = global.DefaultRuntimeWatchersBackupS3MaxPages
+	}
+
+	endpoint := strings.TrimSuffix(conf.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = "https://s3." + conf.Region + ".amazonaws.com"
+	}
+
+	return &s3Source{conf: conf, client: client, signer: v4.NewSigner(), endpoint: endpoint}
+}
+
+// String returns the bucket and prefix, it must never include credentials.
+func (s *s3Source) String() string {
+	return "s3://" + s.conf.Bucket + "/" + s.conf.Prefix
+}
+
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+// Newest returns the most recently modified object under the prefix,
+// listing at most conf.MaxPages pages.
+func (s *s3Source) Newest(ctx context.Context) (*backupArtifact, error) {
+	var (
+		newest *backupArtifact
+		token  string
+	)
+	for page := 0; page < s.conf.MaxPages; page++ {
+		res, err := s.list(ctx, token)
+		if err != nil {
+			return newest, err
+		}
+
+		for _, obj := range res.Contents {
+			if newest == nil || obj.LastModified.After(newest.ModTime) {
+				newest = &backupArtifact{
+					Path:    "s3://" + s.conf.Bucket + "/" + obj.Key,
+					ModTime: obj.LastModified,
+					Size:    obj.Size,
+				}
+			}
+		}
+
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return newest, nil
+		}
+		token = res.NextContinuationToken
+	}
+
+	return newest, errS3ListTruncated
+}
+
+// list fetches a single ListObjectsV2 page.
+func (s *s3Source) list(ctx context.Context, token string) (*s3ListResult, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("max-keys", strconv.Itoa(s3MaxKeys))
+	if s.conf.Prefix != "" {
+		query.Set("prefix", s.conf.Prefix)
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+
+	u := s.endpoint + "/" + url.PathEscape(s.conf.Bucket) + "?" + strings.ReplaceAll(query.Encode(), "+", "%20")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", s3EmptyPayloadHash)
+
+	// anonymous requests for public buckets are left unsigned
+	if s.conf.AccessKeyID != "" {
+		creds := aws.Credentials{
+			AccessKeyID:     s.conf.AccessKeyID,
+			SecretAccessKey: s.conf.SecretAccessKey,
+			SessionToken:    s.conf.SessionToken,
+		}
+		if err := s.signer.SignHTTP(ctx, creds, req, s3EmptyPayloadHash, "s3", s.conf.Region, time.Now().UTC()); err != nil {
+			return nil, fmt.Errorf("failed to sign s3 request: %w", err)
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("s3 list objects failed: %s", resp.Status)
+	}
+
+	res := &s3ListResult{}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, s3MaxResponseSize)).Decode(res); err != nil {
+		return nil, fmt.Errorf("failed to decode s3 list objects response: %w", err)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,251 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"agent/api/v1/model"
+	"agent/internal/pkg/global"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mockBackupSource struct {
+	artifact *backupArtifact
+	err      error
+}
+
+func (m *mockBackupSource) Newest(ctx context.Context) (*backupArtifact, error) {
+	return m.artifact, m.err
+}
+
+func (m *mockBackupSource) String() string {
+	return "mock"
+}
+
+func TestGlobSourceNewest(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	for i, age := range []time.Duration{3 * time.Hour, time.Hour, 2 * time.Hour} {
+		path := filepath.Join(dir, fmt.Sprintf("snapshot-%d.tar.gz", i))
+		require.NoError(t, os.WriteFile(path, make([]byte, 10*(i+1)), 0o600))
+		require.NoError(t, os.Chtimes(path, now.Add(-age), now.Add(-age)))
+	}
+
+	// directories and non-matching files are ignored
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "snapshot-dir.tar.gz"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.log"), nil, 0o600))
+
+	src := globSource{pattern: filepath.Join(dir, "snapshot-*.tar.gz")}
+	got, err := src.Newest(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	require.Equal(t, filepath.Join(dir, "snapshot-1.tar.gz"), got.Path)
+	require.Equal(t, int64(20), got.Size)
+
+	src = globSource{pattern: filepath.Join(dir, "missing-*")}
+	got, err = src.Newest(context.Background())
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
+
+func TestS3SourceNewest(t *testing.T) {
+	var (
+		requests int
+		tokens   []string
+	)
+	pages := []string{
+		`<ListBucketResult><IsTruncated>true</IsTruncated><NextContinuationToken>page 2</NextContinuationToken>
+		<Contents><Key>backups/a.tar</Key><LastModified>2022-01-01T00:00:00.000Z</LastModified><Size>1</Size></Contents>
+		</ListBucketResult>`,
+		`<ListBucketResult><IsTruncated>true</IsTruncated><NextContinuationToken>page3</NextContinuationToken>
+		<Contents><Key>backups/c.tar</Key><LastModified>2022-01-03T00:00:00.000Z</LastModified><Size>3</Size></Contents>
+		<Contents><Key>backups/b.tar</Key><LastModified>2022-01-02T00:00:00.000Z</LastModified><Size>2</Size></Contents>
+		</ListBucketResult>`,
+		`<ListBucketResult><IsTruncated>false</IsTruncated>
+		<Contents><Key>backups/d.tar</Key><LastModified>2021-12-01T00:00:00.000Z</LastModified><Size>4</Size></Contents>
+		</ListBucketResult>`,
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/node-backups", r.URL.Path)
+		require.Equal(t, "2", r.URL.Query().Get("list-type"))
+		require.Equal(t, "backups/", r.URL.Query().Get("prefix"))
+		require.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/"))
+		require.NotContains(t, r.Header.Get("Authorization"), "SECRET")
+		require.NotContains(t, r.URL.String(), "SECRET")
+
+		tokens = append(tokens, r.URL.Query().Get("continuation-token"))
+		fmt.Fprint(w, pages[requests])
+		requests++
+	}))
+	defer ts.Close()
+
+	conf := global.S3Config{
+		Endpoint:        ts.URL,
+		Bucket:          "node-backups",
+		Prefix:          "backups/",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "SECRET",
+		MaxPages:        10,
+	}
+	require.NotContains(t, conf.String(), "SECRET")
+
+	src := newS3Source(conf, ts.Client())
+	got, err := src.Newest(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "s3://node-backups/backups/c.tar", got.Path)
+	require.Equal(t, int64(3), got.Size)
+	require.Equal(t, []string{"", "page 2", "page3"}, tokens)
+
+	// listing is bounded by max pages
+	requests, tokens = 0, nil
+	conf.MaxPages = 1
+	src = newS3Source(conf, ts.Client())
+	got, err = src.Newest(context.Background())
+	require.ErrorIs(t, err, errS3ListTruncated)
+	require.Equal(t, "s3://node-backups/backups/a.tar", got.Path)
+	require.Equal(t, 1, requests)
+}
+
+func TestS3SourceError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	src := newS3Source(global.S3Config{Endpoint: ts.URL, Bucket: "b", AccessKeyID: "AKID", SecretAccessKey: "SECRET"}, ts.Client())
+	_, err := src.Newest(context.Background())
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "SECRET")
+}
+
+func TestNewBackupWatch(t *testing.T) {
+	_, err := NewBackupWatch(BackupWatchConf{})
+	require.Error(t, err)
+
+	_, err = NewBackupWatch(BackupWatchConf{Glob: "[a-"})
+	require.Error(t, err)
+
+	_, err = NewBackupWatch(BackupWatchConf{S3: &global.S3Config{}})
+	require.Error(t, err)
+
+	w, err := NewBackupWatch(BackupWatchConf{S3: &global.S3Config{Bucket: "b"}, Interval: time.Second})
+	require.NoError(t, err)
+	require.Equal(t, MinBackupWatchS3Interval, w.Interval)
+	require.NotNil(t, w.Client)
+}
+
+func TestBackupWatchEscalation(t *testing.T) {
+	blockchainNodeWas := global.BlockchainNode()
+	global.SetBlockchainNode(&mockBlockchain{})
+	defer func() { global.SetBlockchainNode(blockchainNodeWas) }()
+
+	w, err := NewBackupWatch(BackupWatchConf{
+		Type:          "backup",
+		Glob:          "unused",
+		AgeThresholds: []time.Duration{48 * time.Hour, 24 * time.Hour},
+	})
+	require.NoError(t, err)
+
+	src := &mockBackupSource{}
+	w.source = src
+
+	ch := make(chan interface{}, 100)
+	w.Subscribe(ch)
+
+	events := func() []*model.Event {
+		var evs []*model.Event
+		for {
+			select {
+			case msg := <-ch:
+				if ev := msg.(*model.Message).GetEvent(); ev != nil {
+					evs = append(evs, ev)
+				}
+			default:
+				return evs
+			}
+		}
+	}
+
+	// missing backup is reported once
+	w.check(context.Background())
+	w.check(context.Background())
+	evs := events()
+	require.Len(t, evs, 1)
+	require.Equal(t, model.AgentNodeBackupMissingName, evs[0].Name)
+
+	// errors without an artifact are only logged
+	src.err = errS3ListTruncated
+	w.check(context.Background())
+	require.Empty(t, events())
+	src.err = nil
+
+	src.artifact = &backupArtifact{Path: "snapshot", ModTime: time.Now().Add(-time.Hour), Size: 10}
+	w.check(context.Background())
+	require.Empty(t, events())
+
+	src.artifact.ModTime = time.Now().Add(-30 * time.Hour)
+	w.check(context.Background())
+	w.check(context.Background())
+	evs = events()
+	require.Len(t, evs, 1)
+	require.Equal(t, model.AgentNodeBackupStaleName, evs[0].Name)
+	require.Equal(t, float64(1), evs[0].Values.AsMap()[model.SeverityKey])
+	require.Equal(t, "24h0m0s", evs[0].Values.AsMap()[model.ThresholdKey])
+
+	src.artifact.ModTime = time.Now().Add(-50 * time.Hour)
+	w.check(context.Background())
+	evs = events()
+	require.Len(t, evs, 1)
+	require.Equal(t, float64(2), evs[0].Values.AsMap()[model.SeverityKey])
+	require.Equal(t, "snapshot", evs[0].Values.AsMap()[model.BackupPathKey])
+
+	src.artifact.ModTime = time.Now()
+	w.check(context.Background())
+	evs = events()
+	require.Len(t, evs, 1)
+	require.Equal(t, model.AgentNodeBackupFreshName, evs[0].Name)
+}
+
+func TestBackupWatchMetrics(t *testing.T) {
+	w, err := NewBackupWatch(BackupWatchConf{Type: "backup", Glob: "unused"})
+	require.NoError(t, err)
+	w.source = &mockBackupSource{artifact: &backupArtifact{Path: "snapshot", ModTime: time.Now().Add(-time.Minute), Size: 42}}
+
+	ch := make(chan interface{}, 10)
+	w.Subscribe(ch)
+	w.check(context.Background())
+
+	got := map[string]float64{}
+	for len(ch) > 0 {
+		mf := (<-ch).(*model.Message).GetMetricFamily()
+		require.NotNil(t, mf)
+		require.Len(t, mf.Metrics, 1)
+		got[mf.Name] = mf.Metrics[0].MetricPoints[0].GetGaugeValue().GetDoubleValue()
+	}
+
+	require.Equal(t, float64(42), got["node_backup_size_bytes"])
+	require.InDelta(t, 60, got["node_backup_age_seconds"], 5)
+}
@@ -14,6 +14,8 @@
 package watch
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"agent/api/v1/model"
@@ -27,6 +29,9 @@ import (
 
 const namespace = "node"
 
+// scrapeErrorsMetric metric the collectors report their scrape errors with.
+const scrapeErrorsMetric = namespace + "_scrape_collector_errors"
+
 // CollectorWatchConf CollectorWatch configuration.
 type CollectorWatchConf struct {
 	Type      global.WatchType
@@ -35,6 +40,13 @@ type CollectorWatchConf struct {
 	Interval  time.Duration
 }
 
+// metricRenamer is implemented by collectors that expose metric names
+// which must be rewritten before being streamed (i.e. textfile collector
+// in passthrough mode).
+type metricRenamer interface {
+	StreamMetricName(name string) string
+}
+
 // CollectorWatch implements a wrapper watch for node exporter collectors.
 type CollectorWatch struct {
 	CollectorWatchConf
@@ -49,6 +61,7 @@ func NewCollectorWatch(conf CollectorWatchConf) *CollectorWatch {
 	w := new(CollectorWatch)
 	w.CollectorWatchConf = conf
 	w.Watch = NewWatch()
+	w.Name = "collector"
 	w.Log = w.Log.With("collector", w.Type)
 
 	w.handlerch = make(chan []*dto.MetricFamily, 1000)
@@ -68,9 +81,16 @@ func (c *CollectorWatch) StartUnsafe() {
 		for {
 			select {
 			case <-time.After(c.Interval):
-				metricFamilies, err := c.Gatherer.Gather()
+				var (
+					metricFamilies []*dto.MetricFamily
+					err            error
+				)
+				global.AccountAllocations(string(c.Type), func() {
+					metricFamilies, err = c.Gatherer.Gather()
+				})
 				if err != nil {
 					c.Log.Errorw("Failed to gather", zap.Error(err))
+					c.ReportError(err)
 
 					continue
 				}
@@ -91,6 +111,40 @@ func (c *CollectorWatch) StartUnsafe() {
 	go c.handlePrometheusMetric()
 }
 
+// Probe gathers the collector once, returning an error if it fails to
+// gather or reports scrape errors (i.e. missing permission).
+func (c *CollectorWatch) Probe() error {
+	metricFamilies, err := c.Gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	var reasons []string
+	for _, mf := range metricFamilies {
+		if mf.GetName() != scrapeErrorsMetric {
+			continue
+		}
+
+		for _, m := range mf.GetMetric() {
+			if m.GetGauge().GetValue() == 0 {
+				continue
+			}
+
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "reason" {
+					reasons = append(reasons, label.GetValue())
+				}
+			}
+		}
+	}
+
+	if len(reasons) > 0 {
+		return fmt.Errorf("collector reported scrape errors: %s", strings.Join(reasons, ", "))
+	}
+
+	return nil
+}
+
 func (c *CollectorWatch) handlePrometheusMetric() {
 	defer c.wg.Done()
 
@@ -103,6 +157,10 @@ func (c *CollectorWatch) handlePrometheusMetric() {
 					c.Log.Errorw("failed to convert metric to openmetrics", err)
 				}
 
+				if renamer, ok := c.Collector.(metricRenamer); ok && openMetricFam != nil {
+					openMetricFam.Name = renamer.StreamMetricName(openMetricFam.Name)
+				}
+
 				// Create & emit the metric
 				metricInternal := &model.Message{
 					Name:  string(c.Type),
@@ -0,0 +1,131 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"fmt"
+)
+
+// SourcedMessage a message emitted by a child of a CompositeWatch, tagged
+// with the child's source name.
+type SourcedMessage struct {
+	Source  string
+	Message interface{}
+}
+
+// CompositeWatch implements Watcher interface.
+// Runs several watchers as one: the children are started and stopped along
+// with it, and their messages are emitted to its subscribers, wrapped in a
+// SourcedMessage if Tagged is set. The errors of the children, and their
+// stopping by themselves, are reported to its OnError hooks prefixed with
+// their source name.
+type CompositeWatch struct {
+	Watch
+
+	// Tagged wrap the messages in a SourcedMessage, set before the watch is
+	// started.
+	Tagged bool
+
+	children []Watcher
+
+	// sources names of the children, the name of their watch type with a
+	// suffix for duplicates.
+	sources []string
+}
+
+// NewCompositeWatch CompositeWatch constructor. The children must not be
+// started on their own.
+func NewCompositeWatch(ws ...Watcher) *CompositeWatch {
+	w := new(CompositeWatch)
+	w.Watch = NewWatch()
+	w.Name = "composite"
+	w.children = ws
+
+	seen := map[string]int{}
+	for _, child := range ws {
+		source := watcherName(child)
+		seen[source]++
+		if n := seen[source]; n > 1 {
+			source = fmt.Sprintf("%s-%d", source, n)
+		}
+		w.sources = append(w.sources, source)
+
+		child.base().subscribeFunc(func(msg interface{}) int {
+			if w.Tagged {
+				msg = SourcedMessage{Source: source, Message: msg}
+			}
+			w.Emit(msg)
+
+			return 0
+		})
+
+		child.OnError(func(err error) {
+			w.ReportError(fmt.Errorf("%s: %w", source, err))
+		})
+	}
+
+	return w
+}
+
+// StartUnsafe starts the children and the goroutines stopping them along
+// with the watch.
+func (w *CompositeWatch) StartUnsafe() {
+	w.Watch.StartUnsafe()
+
+	ctx, stop := w.runContext(), w.StopKey
+	for i, child := range w.children {
+		child, source := child, w.sources[i]
+
+		StartWithContext(ctx, child)
+		exited := child.stopKey()
+		w.Go(func() { w.superviseChild(ctx, stop, child, source, exited) })
+	}
+}
+
+// superviseChild stops the child once ctx is done or stop, the StopKey of
+// the run it was started for, is closed, and waits for it. A child stopping
+// by itself is reported, the other children keep running.
+func (w *CompositeWatch) superviseChild(ctx context.Context, stop <-chan bool, child Watcher, source string, exited <-chan bool) {
+	select {
+	case <-exited:
+		select {
+		case <-stop:
+		case <-ctx.Done():
+		default:
+			w.Log.Warnw("composite watch child exited", "source", source)
+			w.ReportError(fmt.Errorf("%s: %w", source, ErrWatchExited))
+		}
+	case <-stop:
+	case <-ctx.Done():
+	}
+
+	child.Stop()
+}
+
+// Sources returns the source names of the children, in the order they were
+// passed to NewCompositeWatch.
+func (w *CompositeWatch) Sources() []string {
+	return append([]string(nil), w.sources...)
+}
+
+// ChildStatuses returns the status of every child by source name.
+func (w *CompositeWatch) ChildStatuses() map[string]WatchStatus {
+	statuses := make(map[string]WatchStatus, len(w.children))
+	for i, child := range w.children {
+		statuses[w.sources[i]] = child.Status()
+	}
+
+	return statuses
+}
@@ -0,0 +1,136 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func startCompositeWatch(t *testing.T, tagged bool, ws ...Watcher) (*CompositeWatch, chan interface{}) {
+	t.Helper()
+
+	w := NewCompositeWatch(ws...)
+	w.Tagged = tagged
+
+	ch := make(chan interface{}, 100)
+	w.Subscribe(ch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	StartWithContext(ctx, w)
+	t.Cleanup(func() {
+		cancel()
+		w.Wait()
+	})
+
+	return w, ch
+}
+
+func TestCompositeWatch_Merge(t *testing.T) {
+	children := []Watcher{
+		newScriptedWatch(false, nil, 1, 2, 3),
+		newScriptedWatch(false, nil, 4, 5, 6),
+		newScriptedWatch(false, nil, 7, 8, 9),
+	}
+	w, ch := startCompositeWatch(t, true, children...)
+
+	sources := []string{"*watch.scriptedWatch", "*watch.scriptedWatch-2", "*watch.scriptedWatch-3"}
+	require.Equal(t, sources, w.Sources())
+
+	// interleaved, in order for each source
+	bySource := map[string][]interface{}{}
+	for _, msg := range receiveN(t, ch, 9) {
+		sourced := msg.(SourcedMessage)
+		bySource[sourced.Source] = append(bySource[sourced.Source], sourced.Message)
+	}
+	require.Equal(t, map[string][]interface{}{
+		sources[0]: {1, 2, 3},
+		sources[1]: {4, 5, 6},
+		sources[2]: {7, 8, 9},
+	}, bySource)
+
+	statuses := w.ChildStatuses()
+	require.Len(t, statuses, 3)
+	for _, source := range sources {
+		require.True(t, statuses[source].Running, source)
+		require.Equal(t, uint64(3), statuses[source].EmitCount, source)
+	}
+
+	// stopping the composite stops and waits for the children
+	w.Stop()
+	for _, child := range children {
+		require.False(t, child.Status().Running)
+	}
+}
+
+func TestCompositeWatch_ChildFailure(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+
+	w := NewCompositeWatch(
+		newScriptedWatch(false, nil, "foo"),
+		newScriptedWatch(true, nil),
+		newScriptedWatch(false, errors.New("read failed")),
+	)
+	w.OnError(func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	})
+
+	ch := make(chan interface{}, 10)
+	w.Subscribe(ch)
+	ctx, cancel := context.WithCancel(context.Background())
+	StartWithContext(ctx, w)
+	t.Cleanup(func() {
+		cancel()
+		w.Wait()
+	})
+
+	// untagged
+	require.Equal(t, "foo", <-ch)
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return len(errs) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	mu.Lock()
+	msgs := []string{errs[0].Error(), errs[1].Error()}
+	exited := errors.Is(errs[0], ErrWatchExited) || errors.Is(errs[1], ErrWatchExited)
+	mu.Unlock()
+
+	require.ElementsMatch(t, []string{
+		"*watch.scriptedWatch-2: watch exited",
+		"*watch.scriptedWatch-3: read failed",
+	}, msgs)
+	require.True(t, exited)
+	require.NotEmpty(t, w.Status().LastError)
+
+	// the other children keep running
+	statuses := w.ChildStatuses()
+	require.True(t, statuses["*watch.scriptedWatch"].Running)
+	require.False(t, statuses["*watch.scriptedWatch-2"].Running)
+	require.True(t, statuses["*watch.scriptedWatch-3"].Running)
+	require.True(t, w.Status().Running)
+}
@@ -0,0 +1,437 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrCronSchedule indicates a cron expression that can't be scheduled.
+var ErrCronSchedule = errors.New("invalid cron schedule")
+
+// *** CronWatch ***
+
+// CronWatchConf CronWatch configuration struct.
+type CronWatchConf struct {
+	// Schedule standard 5 fields cron expression (minute, hour, day of
+	// month, month, day of week), optionally preceded by a seconds field,
+	// or one of the @yearly, @monthly, @weekly, @daily and @hourly
+	// descriptors.
+	Schedule string
+
+	// Location time zone the schedule is evaluated in, defaults to the
+	// local time zone.
+	Location *time.Location
+}
+
+// CronWatch implements Watcher interface.
+// Emits the activation time, a time.Time, on every activation of a cron
+// schedule.
+//
+// Activations are computed on the wall clock of the configured location:
+// an activation falling in the hour skipped when DST starts doesn't happen
+// that day, one falling in the hour repeated when DST ends happens once.
+type CronWatch struct {
+	CronWatchConf
+	Watch
+
+	schedule *cronSchedule
+	clock    clock
+}
+
+// NewCronWatch cron watch constructor, returns an error if the schedule
+// can't be parsed or never activates.
+func NewCronWatch(conf CronWatchConf) (*CronWatch, error) {
+	if conf.Location == nil {
+		conf.Location = time.Local
+	}
+
+	schedule, err := parseCronSchedule(conf.Schedule, conf.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	w := new(CronWatch)
+	w.Watch = NewWatch()
+	w.Name = "cron"
+	w.CronWatchConf = conf
+	w.schedule = schedule
+	w.clock = realClock{}
+
+	if w.Next(w.clock.Now()).IsZero() {
+		return nil, fmt.Errorf("%w: %q never activates", ErrCronSchedule, conf.Schedule)
+	}
+
+	return w, nil
+}
+
+// StartUnsafe sets watch running state to true
+// and starts the schedule goroutine.
+func (w *CronWatch) StartUnsafe() {
+	w.Watch.StartUnsafe()
+
+	w.wg.Add(1)
+	go w.cronLoop(w.runContext(), w.StopKey)
+}
+
+// Next returns the first activation strictly after t, in the configured
+// location, or the zero time if there is none in the next years.
+func (w *CronWatch) Next(t time.Time) time.Time {
+	return w.schedule.next(t)
+}
+
+// cronLoop emits on every activation until ctx is done or stop, the StopKey
+// of the run it was started for, is closed.
+func (w *CronWatch) cronLoop(ctx context.Context, stop <-chan bool) {
+	defer w.wg.Done()
+
+	var last time.Time
+	for {
+		from := w.clock.Now()
+		if from.Before(last) {
+			// the timer fired a bit early, don't activate twice
+			from = last
+		}
+
+		next := w.Next(from)
+		if next.IsZero() {
+			w.Log.Warnw("cron schedule has no upcoming activation, stopping", "schedule", w.Schedule)
+
+			return
+		}
+
+		select {
+		case <-w.clock.After(next.Sub(from)):
+			last = next
+			w.Emit(next)
+
+		case <-stop:
+			return
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// clock time source of the watches scheduling on the wall clock.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// cronSearchYears how far ahead activations are searched for.
+const cronSearchYears = 5
+
+// cronDescriptors shorthands for common schedules.
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronField bounds and value names of a cron expression field.
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+// last the last value of the field, Sunday is 0 rather than 7 for the day
+// of week.
+func (f cronField) last() int {
+	if f.name == cronDow.name {
+		return 6
+	}
+
+	return f.max
+}
+
+var (
+	cronSecond = cronField{name: "second", min: 0, max: 59}
+	cronMinute = cronField{name: "minute", min: 0, max: 59}
+	cronHour   = cronField{name: "hour", min: 0, max: 23}
+	cronDom    = cronField{name: "day of month", min: 1, max: 31}
+	cronMonth  = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is accepted for Sunday and folded onto 0.
+	cronDow = cronField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// cronSchedule a parsed cron expression, each field is a bit set of the
+// values it matches.
+type cronSchedule struct {
+	second, minute, hour, dom, month, dow uint64
+
+	// domStar, dowStar whether the day fields are unrestricted, when both
+	// are restricted a day matching either of them matches.
+	domStar, dowStar bool
+
+	loc *time.Location
+}
+
+func parseCronSchedule(spec string, loc *time.Location) (*cronSchedule, error) {
+	expr := strings.TrimSpace(spec)
+	if strings.HasPrefix(expr, "@") {
+		d, ok := cronDescriptors[strings.ToLower(expr)]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown descriptor %q", ErrCronSchedule, expr)
+		}
+		expr = d
+	}
+
+	fields := strings.Fields(expr)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("%w: %q has %d fields, expected 5 or 6", ErrCronSchedule, spec, len(fields))
+	}
+
+	s := &cronSchedule{loc: loc}
+	var err error
+	targets := []struct {
+		bits  *uint64
+		field cronField
+	}{
+		{&s.second, cronSecond},
+		{&s.minute, cronMinute},
+		{&s.hour, cronHour},
+		{&s.dom, cronDom},
+		{&s.month, cronMonth},
+		{&s.dow, cronDow},
+	}
+	for i, target := range targets {
+		if *target.bits, err = parseCronField(fields[i], target.field); err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrCronSchedule, spec, err)
+		}
+	}
+
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	s.domStar = strings.HasPrefix(fields[3], "*")
+	s.dowStar = strings.HasPrefix(fields[5], "*")
+
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of *, values and ranges,
+// each optionally followed by a /step.
+func parseCronField(expr string, field cronField) (uint64, error) {
+	var bits uint64
+	for _, term := range strings.Split(expr, ",") {
+		rng, stepExpr, hasStep := strings.Cut(term, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepExpr); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid %s step %q", field.name, stepExpr)
+			}
+		}
+
+		var lo, hi int
+		switch {
+		case rng == "*":
+			lo, hi = field.min, field.last()
+		case strings.Contains(rng, "-"):
+			loExpr, hiExpr, _ := strings.Cut(rng, "-")
+			var err error
+			if lo, err = parseCronValue(loExpr, field); err != nil {
+				return 0, err
+			}
+			if hi, err = parseCronValue(hiExpr, field); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid %s range %q", field.name, rng)
+			}
+		default:
+			var err error
+			if lo, err = parseCronValue(rng, field); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if hasStep {
+				// a/n is a shorthand for a-last/n
+				hi = field.last()
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func parseCronValue(expr string, field cronField) (int, error) {
+	if v, ok := field.names[strings.ToLower(expr)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(expr)
+	if err != nil || v < field.min || v > field.max {
+		return 0, fmt.Errorf("invalid %s %q, expected %d-%d", field.name, expr, field.min, field.max)
+	}
+
+	return v, nil
+}
+
+// next returns the first activation strictly after t, or the zero time if
+// there is none within cronSearchYears. Wall clock times repeated when DST
+// ends only activate once.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	from := t.In(s.loc)
+
+	candidate := from
+	for {
+		candidate = s.nextInstant(candidate)
+		if candidate.IsZero() || wallClock(candidate).After(wallClock(from)) {
+			return candidate
+		}
+	}
+}
+
+// nextInstant returns the first instant strictly after t whose wall clock
+// time in the schedule location matches. Fields are advanced on the wall
+// clock, from the largest to the smallest, restarting from the month when
+// one of them wraps around.
+func (s *cronSchedule) nextInstant(t time.Time) time.Time {
+	t = t.In(s.loc)
+	t = t.Add(time.Second - time.Duration(t.Nanosecond()))
+
+	yearLimit := t.Year() + cronSearchYears
+	// added whether a field was advanced, the smaller ones start from 0
+	added := false
+
+wrap:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for s.month&(1<<uint(t.Month())) == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, s.loc)
+		}
+		t = t.AddDate(0, 1, 0)
+
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+
+	for !s.dayMatches(t) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.loc)
+		}
+		t = t.AddDate(0, 0, 1)
+
+		// midnight doesn't exist on days DST starts at midnight
+		if t.Hour() != 0 {
+			if t.Hour() > 12 {
+				t = t.Add(time.Duration(24-t.Hour()) * time.Hour)
+			} else {
+				t = t.Add(-time.Duration(t.Hour()) * time.Hour)
+			}
+		}
+
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+
+	for s.hour&(1<<uint(t.Hour())) == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, s.loc)
+		}
+		// hours are added on the absolute clock to step over DST changes
+		t = t.Add(time.Hour)
+
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+
+	for s.minute&(1<<uint(t.Minute())) == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Minute)
+		}
+		t = t.Add(time.Minute)
+
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+
+	for s.second&(1<<uint(t.Second())) == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Second)
+		}
+		t = t.Add(time.Second)
+
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+
+	return t
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+// wallClock t's wall clock time, comparable regardless of the UTC offset.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
@@ -0,0 +1,305 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock clock advanced by the test, signalling every After call on
+// waiting.
+type fakeClock struct {
+	sync.Mutex
+	now     time.Time
+	timers  []fakeTimer
+	waiting chan time.Duration
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, waiting: make(chan time.Duration, 100)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	ch := make(chan time.Time, 1)
+	c.timers = append(c.timers, fakeTimer{at: c.now.Add(d), ch: ch})
+	c.waiting <- d
+
+	return ch
+}
+
+// advance moves the clock forward by d, firing the expired timers.
+func (c *fakeClock) advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			pending = append(pending, timer)
+
+			continue
+		}
+		timer.ch <- c.now
+	}
+	c.timers = pending
+}
+
+func loadLocation(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	require.NoError(t, err)
+
+	return loc
+}
+
+func TestNewCronWatch_Invalid(t *testing.T) {
+	for _, schedule := range []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"foo * * * *",
+		"1,,2 * * * *",
+		"@every 1m",
+		"0 0 31 2 *",
+	} {
+		w, err := NewCronWatch(CronWatchConf{Schedule: schedule, Location: time.UTC})
+		require.ErrorIs(t, err, ErrCronSchedule, schedule)
+		require.Nil(t, w)
+	}
+}
+
+func TestCronWatch_Next(t *testing.T) {
+	ny := loadLocation(t, "America/New_York")
+
+	tests := []struct {
+		name     string
+		schedule string
+		loc      *time.Location
+		from     time.Time
+		want     []time.Time
+	}{
+		{
+			name:     "daily",
+			schedule: "5 0 * * *",
+			loc:      time.UTC,
+			from:     time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC),
+			want: []time.Time{
+				time.Date(2023, 6, 2, 0, 5, 0, 0, time.UTC),
+				time.Date(2023, 6, 3, 0, 5, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "strictly after",
+			schedule: "5 0 * * *",
+			loc:      time.UTC,
+			from:     time.Date(2023, 6, 1, 0, 5, 0, 0, time.UTC),
+			want:     []time.Time{time.Date(2023, 6, 2, 0, 5, 0, 0, time.UTC)},
+		},
+		{
+			name:     "seconds",
+			schedule: "*/20 * * * * *",
+			loc:      time.UTC,
+			from:     time.Date(2023, 6, 1, 12, 0, 5, 500, time.UTC),
+			want: []time.Time{
+				time.Date(2023, 6, 1, 12, 0, 20, 0, time.UTC),
+				time.Date(2023, 6, 1, 12, 0, 40, 0, time.UTC),
+				time.Date(2023, 6, 1, 12, 1, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "weekly by name",
+			schedule: "0 9 * * mon",
+			loc:      time.UTC,
+			from:     time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC), // Thursday
+			want: []time.Time{
+				time.Date(2023, 6, 5, 9, 0, 0, 0, time.UTC),
+				time.Date(2023, 6, 12, 9, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "sunday as 7",
+			schedule: "0 0 * * 7",
+			loc:      time.UTC,
+			from:     time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+			want:     []time.Time{time.Date(2023, 6, 4, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			name:     "day of month or day of week",
+			schedule: "0 0 13 * FRI",
+			loc:      time.UTC,
+			from:     time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC),
+			want: []time.Time{
+				time.Date(2023, 10, 6, 0, 0, 0, 0, time.UTC),
+				time.Date(2023, 10, 13, 0, 0, 0, 0, time.UTC),
+				time.Date(2023, 10, 20, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "lists ranges and steps",
+			schedule: "0 8-18/5,23 * jan-mar/2 *",
+			loc:      time.UTC,
+			from:     time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC),
+			want: []time.Time{
+				time.Date(2023, 3, 1, 8, 0, 0, 0, time.UTC),
+				time.Date(2023, 3, 1, 13, 0, 0, 0, time.UTC),
+				time.Date(2023, 3, 1, 18, 0, 0, 0, time.UTC),
+				time.Date(2023, 3, 1, 23, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "leap day",
+			schedule: "0 0 29 2 *",
+			loc:      time.UTC,
+			from:     time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+			want:     []time.Time{time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			name:     "location",
+			schedule: "0 12 * * *",
+			loc:      ny,
+			from:     time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+			want:     []time.Time{time.Date(2023, 6, 1, 16, 0, 0, 0, time.UTC)},
+		},
+		{
+			name:     "dst start skips the missing hour",
+			schedule: "30 2 * * *",
+			loc:      ny,
+			from:     time.Date(2023, 3, 11, 12, 0, 0, 0, ny),
+			want: []time.Time{
+				time.Date(2023, 3, 13, 2, 30, 0, 0, ny),
+			},
+		},
+		{
+			name:     "dst start hourly",
+			schedule: "@hourly",
+			loc:      ny,
+			from:     time.Date(2023, 3, 12, 0, 30, 0, 0, ny),
+			want: []time.Time{
+				time.Date(2023, 3, 12, 1, 0, 0, 0, ny),
+				time.Date(2023, 3, 12, 3, 0, 0, 0, ny),
+				time.Date(2023, 3, 12, 4, 0, 0, 0, ny),
+			},
+		},
+		{
+			name:     "dst end activates once in the repeated hour",
+			schedule: "30 1 * * *",
+			loc:      ny,
+			from:     time.Date(2023, 11, 4, 12, 0, 0, 0, ny),
+			want: []time.Time{
+				time.Date(2023, 11, 5, 5, 30, 0, 0, time.UTC), // 01:30 EDT
+				time.Date(2023, 11, 6, 6, 30, 0, 0, time.UTC), // 01:30 EST
+			},
+		},
+		{
+			name:     "dst end hourly",
+			schedule: "0 * * * *",
+			loc:      ny,
+			from:     time.Date(2023, 11, 5, 0, 30, 0, 0, ny),
+			want: []time.Time{
+				time.Date(2023, 11, 5, 5, 0, 0, 0, time.UTC), // 01:00 EDT
+				time.Date(2023, 11, 5, 7, 0, 0, 0, time.UTC), // 02:00 EST
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := NewCronWatch(CronWatchConf{Schedule: tt.schedule, Location: tt.loc})
+			require.NoError(t, err)
+
+			from := tt.from
+			for _, want := range tt.want {
+				next := w.Next(from)
+				require.True(t, want.Equal(next), "want %v, got %v", want, next)
+				require.Equal(t, tt.loc, next.Location())
+				from = next
+			}
+		})
+	}
+}
+
+func TestCronWatch_Emit(t *testing.T) {
+	w, err := NewCronWatch(CronWatchConf{Schedule: "*/15 * * * *", Location: time.UTC})
+	require.NoError(t, err)
+
+	clk := newFakeClock(time.Date(2023, 6, 1, 12, 7, 30, 0, time.UTC))
+	w.clock = clk
+
+	ch := make(chan interface{}, 10)
+	w.Subscribe(ch)
+	StartWithContext(context.Background(), w)
+
+	for _, want := range []time.Time{
+		time.Date(2023, 6, 1, 12, 15, 0, 0, time.UTC),
+		time.Date(2023, 6, 1, 12, 30, 0, 0, time.UTC),
+		time.Date(2023, 6, 1, 12, 45, 0, 0, time.UTC),
+	} {
+		d := <-clk.waiting
+		require.Equal(t, want.Sub(clk.Now()), d)
+
+		// not due yet
+		clk.advance(d - time.Second)
+		select {
+		case msg := <-ch:
+			t.Fatalf("early emission %v", msg)
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		clk.advance(time.Second)
+		select {
+		case msg := <-ch:
+			require.Equal(t, want, msg)
+		case <-time.After(time.Second):
+			t.Fatalf("no emission at %v", want)
+		}
+	}
+
+	w.Stop()
+	w.Wait()
+
+	clk.advance(time.Hour)
+	select {
+	case msg := <-ch:
+		t.Fatalf("emission after stop %v", msg)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
@@ -11,6 +11,9 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !nodocker
+// +build !nodocker
+
 package watch
 
 import (
@@ -71,6 +74,7 @@ var ErrContainerWatchConf = errors.New("container watch configuration error, mis
 func NewContainerWatch(conf ContainerWatchConf) (*ContainerWatch, error) {
 	w := new(ContainerWatch)
 	w.Watch = NewWatch()
+	w.Name = "docker_container"
 	w.ContainerWatchConf = conf
 	w.watchCh = make(chan interface{}, 1)
 	w.stopListCh = make(chan interface{}, 1)
@@ -210,6 +214,7 @@ func (w *ContainerWatch) StartUnsafe() {
 			w.Log.Debugw("repairing docker event stream")
 			if msgchan, errchan, err = w.repairEventStream(ctx); err != nil {
 				w.Log.Warnw("getting docker event stream failed", zap.Error(err))
+				w.ReportError(err)
 				w.blockchain.SetDockerContainer(nil)
 				global.AgentRuntimeState.SetDiscoveryState(global.NodeDiscoveryError)
 				w.emitAgentNodeEvent(model.AgentNodeDownName)
@@ -250,6 +255,7 @@ func (w *ContainerWatch) StartUnsafe() {
 				evName, err := w.parseDockerEvent(m)
 				if err != nil {
 					w.Log.Error("error parsing docker event", err)
+					w.ReportError(err)
 
 					continue
 				}
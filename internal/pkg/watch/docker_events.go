@@ -0,0 +1,288 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nodocker
+// +build !nodocker
+
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	dt "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"go.uber.org/zap"
+)
+
+// ErrDockerEventsWatchConf docker events watch configuration error.
+var ErrDockerEventsWatchConf = errors.New("docker events watch configuration error")
+
+const defaultDockerEventsMaxBackoff = 30 * time.Second
+
+// defaultDockerEventsActions container lifecycle actions emitted when none
+// are configured.
+var defaultDockerEventsActions = []string{
+	"create", "start", "restart", "stop", "kill", "die", "oom", "destroy",
+}
+
+// DockerContainerEvent a container lifecycle event, emitted by
+// DockerEventsWatch.
+type DockerContainerEvent struct {
+	ID     string
+	Name   string
+	Image  string
+	Action string
+
+	// ExitCode the container exit code, set for die events only.
+	ExitCode *int
+
+	Time time.Time
+}
+
+// DockerEventsWatchConf DockerEventsWatch configuration struct.
+type DockerEventsWatchConf struct {
+	// Host docker daemon address, i.e. unix:///var/run/docker.sock.
+	// Defaults to DOCKER_HOST or the default socket.
+	Host string
+
+	// ContainerRegex regexps matched against the container name and
+	// image, i.e. the ContainerRegex() of the protocol. Every container
+	// matches if empty.
+	ContainerRegex []string
+
+	// Actions container actions to emit, defaults to the lifecycle ones:
+	// create, start, restart, stop, kill, die, oom and destroy.
+	Actions []string
+
+	// RetryIntv delay before the first reconnection to the daemon, it
+	// doubles on every failed attempt up to MaxBackoff.
+	RetryIntv  time.Duration
+	MaxBackoff time.Duration
+}
+
+// DockerEventsWatch implements Watcher interface.
+// Streams the docker daemon events of the matching containers and emits
+// them as DockerContainerEvent. The stream is reestablished when the daemon
+// restarts, resuming from the last event received.
+type DockerEventsWatch struct {
+	DockerEventsWatchConf
+	Watch
+
+	regexps []*regexp.Regexp
+}
+
+// NewDockerEventsWatch DockerEventsWatch constructor, returns an error if a
+// container regexp is invalid.
+func NewDockerEventsWatch(conf DockerEventsWatchConf) (*DockerEventsWatch, error) {
+	w := new(DockerEventsWatch)
+	w.Watch = NewWatch()
+	w.Name = "docker_events"
+	w.DockerEventsWatchConf = conf
+	w.Log = w.Log.With("watch", "docker_events")
+
+	for _, expr := range conf.ContainerRegex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid container regexp %q: %v", ErrDockerEventsWatchConf, expr, err)
+		}
+		w.regexps = append(w.regexps, re)
+	}
+
+	if len(w.Actions) == 0 {
+		w.Actions = defaultDockerEventsActions
+	}
+	if w.RetryIntv == 0 {
+		w.RetryIntv = defaultRetryIntv
+	}
+	if w.MaxBackoff == 0 {
+		w.MaxBackoff = defaultDockerEventsMaxBackoff
+	}
+	if w.MaxBackoff < w.RetryIntv {
+		w.MaxBackoff = w.RetryIntv
+	}
+
+	return w, nil
+}
+
+// StartUnsafe starts the goroutine streaming the docker events.
+func (w *DockerEventsWatch) StartUnsafe() {
+	w.Watch.StartUnsafe()
+
+	w.wg.Add(1)
+	go w.eventsLoop(w.runContext(), w.StopKey)
+}
+
+// eventsLoop streams the events until ctx is done or stop, the StopKey of
+// the run it was started for, is closed.
+func (w *DockerEventsWatch) eventsLoop(ctx context.Context, stop <-chan bool) {
+	defer w.wg.Done()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	backof := backoff.NewExponentialBackOff()
+	backof.InitialInterval = w.RetryIntv
+	backof.MaxInterval = w.MaxBackoff
+	backof.MaxElapsedTime = 0 // never expire
+	backof.Reset()
+
+	var since time.Time
+	for {
+		err := w.stream(ctx, &since, backof)
+		if ctx.Err() != nil {
+			return
+		}
+
+		retry := backof.NextBackOff()
+		w.Log.Warnw("docker event stream interrupted, reconnecting", zap.Error(err), "retry_timer", retry)
+		w.ReportError(err)
+
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// stream connects to the daemon and emits the events received after since
+// until the stream fails, since is updated with each event.
+func (w *DockerEventsWatch) stream(ctx context.Context, since *time.Time, backof backoff.BackOff) error {
+	cli, err := newDockerClient(w.Host)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	filter := filters.NewArgs()
+	filter.Add("type", string(events.ContainerEventType))
+	for _, action := range w.Actions {
+		filter.Add("event", action)
+	}
+
+	options := dt.EventsOptions{Filters: filter}
+	if !since.IsZero() {
+		// the events missed while disconnected are replayed
+		options.Since = fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond())
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	msgs, errs := cli.Events(ctx, options)
+	w.Log.Debugw("subscribed to docker event stream", "filter", filter)
+
+	for {
+		select {
+		case m := <-msgs:
+			backof.Reset()
+
+			ev := newDockerContainerEvent(m)
+			// the since boundary is inclusive
+			if !ev.Time.After(*since) {
+				continue
+			}
+			*since = ev.Time
+
+			if w.matches(ev) {
+				w.Emit(ev)
+			}
+
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+// matches whether the container name or image matches a regexp.
+func (w *DockerEventsWatch) matches(ev DockerContainerEvent) bool {
+	// container names are listed with a leading slash
+	return matchDockerContainer(w.regexps, []string{ev.Name, "/" + ev.Name}, ev.Image)
+}
+
+// matchDockerContainer whether any of the container names or its image
+// matches a regexp, true if there is none.
+func matchDockerContainer(regexps []*regexp.Regexp, names []string, image string) bool {
+	if len(regexps) == 0 {
+		return true
+	}
+
+	for _, re := range regexps {
+		for _, name := range names {
+			if re.MatchString(name) {
+				return true
+			}
+		}
+
+		if re.MatchString(image) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newDockerClient a docker client connecting to host, or to DOCKER_HOST or
+// the default socket if empty.
+func newDockerClient(host string) (*client.Client, error) {
+	opts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if host != "" {
+		opts = append(opts, client.WithHost(host))
+	}
+
+	return client.NewClientWithOpts(opts...)
+}
+
+func newDockerContainerEvent(m events.Message) DockerContainerEvent {
+	action := m.Action
+	if action == "" {
+		action = m.Status
+	}
+
+	image := m.Actor.Attributes["image"]
+	if image == "" {
+		image = m.From
+	}
+
+	ev := DockerContainerEvent{
+		ID:     m.Actor.ID,
+		Name:   m.Actor.Attributes["name"],
+		Image:  image,
+		Action: action,
+		Time:   time.Unix(0, m.TimeNano),
+	}
+	if m.TimeNano == 0 {
+		ev.Time = time.Unix(m.Time, 0)
+	}
+
+	if code, err := strconv.Atoi(m.Actor.Attributes["exitCode"]); err == nil {
+		ev.ExitCode = &code
+	}
+
+	return ev
+}
@@ -0,0 +1,183 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nodocker
+// +build !nodocker
+
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/stretchr/testify/require"
+)
+
+func newDockerEventMessage(action, name, image string, ts time.Time, attrs map[string]string) events.Message {
+	attributes := map[string]string{"name": name, "image": image}
+	for k, v := range attrs {
+		attributes[k] = v
+	}
+
+	return events.Message{
+		Type:     events.ContainerEventType,
+		Action:   action,
+		Actor:    events.Actor{ID: name + "-id", Attributes: attributes},
+		Time:     ts.Unix(),
+		TimeNano: ts.UnixNano(),
+	}
+}
+
+// newMockDockerEventsDaemon serves the docker events endpoint, streaming
+// the messages of the nth connection from the nth handler call.
+func newMockDockerEventsDaemon(t *testing.T, conns ...func(r *http.Request) []events.Message) *httptest.Server {
+	var (
+		mu sync.Mutex
+		n  int
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/_ping") {
+			w.Header().Set("API-Version", "1.41")
+			w.WriteHeader(http.StatusOK)
+
+			return
+		}
+
+		if !strings.HasSuffix(r.URL.Path, "/events") {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		mu.Lock()
+		i := n
+		n++
+		mu.Unlock()
+
+		if i >= len(conns) {
+			<-r.Context().Done()
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		for _, m := range conns[i](r) {
+			require.NoError(t, enc.Encode(m))
+		}
+		w.(http.Flusher).Flush()
+		// returning ends the stream as a daemon restart does
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func requireDockerEvent(t *testing.T, ch chan interface{}) DockerContainerEvent {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		ev, ok := msg.(DockerContainerEvent)
+		require.True(t, ok, "unexpected message %T", msg)
+
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no docker event")
+	}
+
+	return DockerContainerEvent{}
+}
+
+func TestNewDockerEventsWatch_Invalid(t *testing.T) {
+	_, err := NewDockerEventsWatch(DockerEventsWatchConf{ContainerRegex: []string{"("}})
+	require.ErrorIs(t, err, ErrDockerEventsWatchConf)
+}
+
+func TestDockerEventsWatch(t *testing.T) {
+	t0 := time.Now().Add(-time.Minute).Truncate(time.Second)
+	started := newDockerEventMessage("start", "flow-private-network_consensus_3_1", "gcr.io/flow-node-consensus:v0.28", t0, nil)
+	unrelated := newDockerEventMessage("start", "redis", "redis:7", t0.Add(time.Second), nil)
+
+	since, filter := make(chan string, 1), make(chan string, 1)
+	ts := newMockDockerEventsDaemon(t,
+		func(r *http.Request) []events.Message {
+			args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+			require.NoError(t, err)
+			filter <- strings.Join(args.Get("type"), ",")
+
+			return []events.Message{started, unrelated}
+		},
+		func(r *http.Request) []events.Message {
+			since <- r.URL.Query().Get("since")
+
+			return []events.Message{
+				// replayed from the inclusive since boundary
+				unrelated,
+				newDockerEventMessage("die", "flow-private-network_consensus_3_1", "gcr.io/flow-node-consensus:v0.28",
+					t0.Add(2*time.Second), map[string]string{"exitCode": "137"}),
+			}
+		},
+	)
+
+	w, err := NewDockerEventsWatch(DockerEventsWatchConf{
+		Host:           "tcp://" + strings.TrimPrefix(ts.URL, "http://"),
+		ContainerRegex: []string{"^/flow-private-network_consensus"},
+		RetryIntv:      10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	ch := make(chan interface{}, 10)
+	w.Subscribe(ch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	StartWithContext(ctx, w)
+	t.Cleanup(func() {
+		cancel()
+		w.Wait()
+	})
+
+	ev := requireDockerEvent(t, ch)
+	require.Equal(t, DockerContainerEvent{
+		ID:     "flow-private-network_consensus_3_1-id",
+		Name:   "flow-private-network_consensus_3_1",
+		Image:  "gcr.io/flow-node-consensus:v0.28",
+		Action: "start",
+		Time:   t0,
+	}, ev)
+	require.Equal(t, string(events.ContainerEventType), <-filter)
+
+	// the stream resumes after the daemon restart
+	ev = requireDockerEvent(t, ch)
+	require.Equal(t, "die", ev.Action)
+	require.NotNil(t, ev.ExitCode)
+	require.Equal(t, 137, *ev.ExitCode)
+	require.Equal(t, t0.Add(2*time.Second), ev.Time)
+	require.Equal(t, fmt.Sprintf("%d.000000000", t0.Unix()+1), <-since)
+
+	select {
+	case msg := <-ch:
+		t.Fatalf("unexpected message %v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -11,6 +11,9 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !nodocker
+// +build !nodocker
+
 package watch
 
 import (
@@ -57,6 +60,7 @@ func NewDockerLogWatch(conf DockerLogWatchConf) *DockerLogWatch {
 	w := new(DockerLogWatch)
 	w.DockerLogWatchConf = conf
 	w.Watch = NewWatch()
+	w.Name = "docker_logs"
 	w.Log = w.Log.With("watch", "docker_logs")
 	if w.RetryIntv == 0 {
 		w.RetryIntv = defaultRetryIntv
@@ -126,6 +130,7 @@ func (w *DockerLogWatch) StartUnsafe() {
 			rc, err = w.repairLogStream(ctx)
 			if err != nil {
 				w.Log.Warnw("error getting stream", zap.Error(err))
+				w.ReportError(err)
 				continue
 			}
 
@@ -134,7 +139,7 @@ func (w *DockerLogWatch) StartUnsafe() {
 	}
 
 	if stopped := newEventStream(); stopped {
-		w.Stop()
+		w.signalStop()
 		return
 	}
 
@@ -161,11 +166,13 @@ func (w *DockerLogWatch) StartUnsafe() {
 				lastErr = err
 				if err != io.EOF && err != io.ErrUnexpectedEOF {
 					w.Log.Errorw("error reading header", zap.Error(err), "reader", rc)
+					w.ReportError(err)
 
 					continue
 				}
 
 				w.Log.Error("EOF error while reading header, will try to recover in 5s")
+				w.ReportError(err)
 				time.Sleep(5 * time.Second)
 
 				w.emitAgentNodeEvent(model.AgentNodeLogMissingName)
@@ -176,7 +183,7 @@ func (w *DockerLogWatch) StartUnsafe() {
 				}
 
 				if stopped := newEventStream(); stopped {
-					w.Stop()
+					w.signalStop()
 					return
 				}
 
@@ -205,11 +212,13 @@ func (w *DockerLogWatch) StartUnsafe() {
 				lastErr = err
 				if err != io.EOF && err != io.ErrUnexpectedEOF {
 					w.Log.Errorw("error reading data", zap.Error(err), "reader", rc)
+					w.ReportError(err)
 
 					continue
 				}
 
 				w.Log.Error("EOF error while reading log data, will try to recover log streaming immediately")
+				w.ReportError(err)
 				w.emitAgentNodeEvent(model.AgentNodeLogMissingName)
 
 				cancel()
@@ -218,7 +227,7 @@ func (w *DockerLogWatch) StartUnsafe() {
 				}
 
 				if stopped := newEventStream(); stopped {
-					w.Stop()
+					w.signalStop()
 					return
 				}
 
@@ -239,6 +248,7 @@ func (w *DockerLogWatch) StartUnsafe() {
 			jsonMap, err := w.parseJSON(buf)
 			if err != nil {
 				w.Log.Errorw("error parsing events from log line:", zap.Error(err))
+				w.ReportError(err)
 
 				continue
 			}
@@ -0,0 +1,320 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nodocker
+// +build !nodocker
+
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	dt "github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"go.uber.org/zap"
+)
+
+// ErrDockerStatsWatchConf docker stats watch configuration error.
+var ErrDockerStatsWatchConf = errors.New("docker stats watch configuration error")
+
+const defaultDockerStatsInterval = 10 * time.Second
+
+// DockerStats the resource usage of a container, emitted by
+// DockerStatsWatch.
+type DockerStats struct {
+	ID   string
+	Name string
+
+	// CPUPercent CPU usage since the previous sample, 100 per fully used
+	// core.
+	CPUPercent float64
+
+	// MemoryUsage memory used in bytes, without the page cache.
+	MemoryUsage   uint64
+	MemoryLimit   uint64
+	MemoryPercent float64
+
+	// NetworkRx, NetworkTx, BlockRead and BlockWrite bytes transferred
+	// since the previous sample, 0 for the first sample of a container.
+	NetworkRx  uint64
+	NetworkTx  uint64
+	BlockRead  uint64
+	BlockWrite uint64
+
+	Time time.Time
+}
+
+// DockerStatsWatchConf DockerStatsWatch configuration struct.
+type DockerStatsWatchConf struct {
+	// Host docker daemon address, i.e. unix:///var/run/docker.sock.
+	// Defaults to DOCKER_HOST or the default socket.
+	Host string
+
+	// ContainerRegex regexps matched against the running containers names
+	// and images, i.e. the ContainerRegex() of the protocol. The first
+	// matching container is watched.
+	ContainerRegex []string
+
+	// Interval how often the stats are sampled. Defaults to 10s.
+	Interval time.Duration
+}
+
+// DockerStatsWatch implements Watcher interface.
+// Samples the resource usage of a container on an interval and emits it as
+// DockerStats. The container is resolved again at each interval, so that a
+// restarted or recreated container is picked up.
+type DockerStatsWatch struct {
+	DockerStatsWatchConf
+	Watch
+
+	regexps []*regexp.Regexp
+
+	// prev previous sample of the watched container, owned by the poll
+	// goroutine while running.
+	prev *dt.StatsJSON
+}
+
+// NewDockerStatsWatch DockerStatsWatch constructor, returns an error if no
+// container regexp is configured or one is invalid.
+func NewDockerStatsWatch(conf DockerStatsWatchConf) (*DockerStatsWatch, error) {
+	if len(conf.ContainerRegex) == 0 {
+		return nil, fmt.Errorf("%w: container regexp is required", ErrDockerStatsWatchConf)
+	}
+
+	w := new(DockerStatsWatch)
+	w.Watch = NewWatch()
+	w.Name = "docker_stats"
+	w.DockerStatsWatchConf = conf
+	w.Log = w.Log.With("watch", "docker_stats")
+
+	for _, expr := range conf.ContainerRegex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid container regexp %q: %v", ErrDockerStatsWatchConf, expr, err)
+		}
+		w.regexps = append(w.regexps, re)
+	}
+
+	if w.Interval < 1 {
+		w.Interval = defaultDockerStatsInterval
+	}
+
+	return w, nil
+}
+
+// StartUnsafe starts the goroutine sampling the container stats.
+func (w *DockerStatsWatch) StartUnsafe() {
+	w.Watch.StartUnsafe()
+
+	w.wg.Add(1)
+	go w.pollLoop(w.runContext(), w.StopKey)
+}
+
+// pollLoop samples the stats right away and then on every interval, until
+// ctx is done or stop, the StopKey of the run it was started for, is closed.
+func (w *DockerStatsWatch) pollLoop(ctx context.Context, stop <-chan bool) {
+	defer w.wg.Done()
+
+	cli, err := newDockerClient(w.Host)
+	if err != nil {
+		w.Log.Errorw("failed to create docker client", zap.Error(err))
+		w.ReportError(err)
+
+		return
+	}
+	defer cli.Close()
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.poll(ctx, cli); err != nil && ctx.Err() == nil {
+			w.Log.Warnw("failed to sample container stats", zap.Error(err))
+			w.ReportError(err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// poll resolves the container and emits its stats.
+func (w *DockerStatsWatch) poll(ctx context.Context, cli client.APIClient) error {
+	ctx, cancel := context.WithTimeout(ctx, w.Interval)
+	defer cancel()
+
+	containers, err := cli.ContainerList(ctx, dt.ContainerListOptions{})
+	if err != nil {
+		return err
+	}
+
+	var container *dt.Container
+	for i := range containers {
+		if matchDockerContainer(w.regexps, containers[i].Names, containers[i].Image) {
+			container = &containers[i]
+
+			break
+		}
+	}
+
+	if container == nil {
+		if w.prev != nil {
+			w.Log.Infow("container not running, waiting for it")
+			w.prev = nil
+		}
+
+		return nil
+	}
+
+	if w.prev != nil && w.prev.ID != container.ID {
+		w.Log.Infow("container replaced, resetting stats", "id", container.ID)
+		w.prev = nil
+	}
+
+	resp, err := cli.ContainerStats(ctx, container.ID, false)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	cur := new(dt.StatsJSON)
+	if err := json.NewDecoder(resp.Body).Decode(cur); err != nil {
+		return err
+	}
+	// not set by every daemon version
+	cur.ID = container.ID
+
+	stats := newDockerStats(cur, w.prev)
+	if len(container.Names) > 0 {
+		stats.Name = strings.TrimPrefix(container.Names[0], "/")
+	}
+	w.Emit(stats)
+	w.prev = cur
+
+	return nil
+}
+
+// newDockerStats the stats of the cur sample, the deltas are computed from
+// prev, the previous sample of the same container, nil for the first one.
+func newDockerStats(cur, prev *dt.StatsJSON) DockerStats {
+	stats := DockerStats{
+		ID:          cur.ID,
+		MemoryUsage: memoryUsage(cur.MemoryStats),
+		MemoryLimit: cur.MemoryStats.Limit,
+		Time:        cur.Read,
+	}
+	if stats.Time.IsZero() {
+		stats.Time = time.Now()
+	}
+
+	if stats.MemoryLimit > 0 {
+		stats.MemoryPercent = float64(stats.MemoryUsage) / float64(stats.MemoryLimit) * 100
+	}
+
+	// the daemon sends the sample preceding this one on the first sample
+	pre := cur.PreCPUStats
+	if prev != nil {
+		pre = prev.CPUStats
+	}
+	stats.CPUPercent = cpuPercent(pre, cur.CPUStats)
+
+	if prev != nil {
+		rx, tx := networkBytes(cur)
+		prevRx, prevTx := networkBytes(prev)
+		stats.NetworkRx, stats.NetworkTx = counterDelta(prevRx, rx), counterDelta(prevTx, tx)
+
+		read, write := blockBytes(cur)
+		prevRead, prevWrite := blockBytes(prev)
+		stats.BlockRead, stats.BlockWrite = counterDelta(prevRead, read), counterDelta(prevWrite, write)
+	}
+
+	return stats
+}
+
+// cpuPercent the CPU usage between two samples, following the docker CLI
+// formula. It is 0 if pre is unset.
+func cpuPercent(pre, cur dt.CPUStats) float64 {
+	if pre.SystemUsage == 0 || cur.SystemUsage <= pre.SystemUsage || cur.CPUUsage.TotalUsage <= pre.CPUUsage.TotalUsage {
+		return 0
+	}
+
+	cpuDelta := float64(cur.CPUUsage.TotalUsage - pre.CPUUsage.TotalUsage)
+	systemDelta := float64(cur.SystemUsage - pre.SystemUsage)
+
+	onlineCPUs := float64(cur.OnlineCPUs)
+	if onlineCPUs == 0 {
+		onlineCPUs = float64(len(cur.CPUUsage.PercpuUsage))
+	}
+
+	return cpuDelta / systemDelta * onlineCPUs * 100
+}
+
+// memoryUsage the memory usage without the inactive page cache, as the
+// docker CLI reports it.
+func memoryUsage(mem dt.MemoryStats) uint64 {
+	// cgroup v1
+	if v, ok := mem.Stats["total_inactive_file"]; ok && v < mem.Usage {
+		return mem.Usage - v
+	}
+
+	// cgroup v2
+	if v, ok := mem.Stats["inactive_file"]; ok && v < mem.Usage {
+		return mem.Usage - v
+	}
+
+	return mem.Usage
+}
+
+// networkBytes the bytes received and transmitted on all the interfaces.
+func networkBytes(s *dt.StatsJSON) (rx, tx uint64) {
+	for _, n := range s.Networks {
+		rx += n.RxBytes
+		tx += n.TxBytes
+	}
+
+	return rx, tx
+}
+
+// blockBytes the bytes read from and written to all the block devices.
+func blockBytes(s *dt.StatsJSON) (read, write uint64) {
+	for _, e := range s.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(e.Op) {
+		case "read":
+			read += e.Value
+		case "write":
+			write += e.Value
+		}
+	}
+
+	return read, write
+}
+
+// counterDelta the increase of a counter, 0 if it was reset.
+func counterDelta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return 0
+	}
+
+	return cur - prev
+}
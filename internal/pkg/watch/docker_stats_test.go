@@ -0,0 +1,195 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nodocker
+// +build !nodocker
+
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dt "github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+)
+
+// newMockDockerStatsDaemon serves the docker container list and stats
+// endpoints. The first container is listed until its samples are all
+// served, the next one afterwards, as if it were recreated. The last sample
+// of the last container is repeated.
+func newMockDockerStatsDaemon(t *testing.T, containers []dt.Container, samples map[string][]dt.StatsJSON) *httptest.Server {
+	var (
+		mu     sync.Mutex
+		served = map[string]int{}
+	)
+
+	current := func() dt.Container {
+		for _, c := range containers[:len(containers)-1] {
+			if served[c.ID] < len(samples[c.ID]) {
+				return c
+			}
+		}
+
+		return containers[len(containers)-1]
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_ping"):
+			w.Header().Set("API-Version", "1.41")
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			list := []dt.Container{{ID: "other", Names: []string{"/redis"}, Image: "redis:7"}, current()}
+			require.NoError(t, json.NewEncoder(w).Encode(list))
+		case strings.HasSuffix(r.URL.Path, "/stats"):
+			id := strings.Split(r.URL.Path, "/")[3]
+			require.Equal(t, current().ID, id)
+			require.Equal(t, "0", r.URL.Query().Get("stream"))
+
+			i := served[id]
+			if i >= len(samples[id]) {
+				i = len(samples[id]) - 1
+			}
+			served[id]++
+			require.NoError(t, json.NewEncoder(w).Encode(samples[id][i]))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func newStatsSample(read time.Time, total, pretotal, system, presystem uint64, rx, tx, blkRead, blkWrite uint64) dt.StatsJSON {
+	s := dt.StatsJSON{}
+	s.Read = read
+	s.CPUStats = dt.CPUStats{CPUUsage: dt.CPUUsage{TotalUsage: total}, SystemUsage: system, OnlineCPUs: 4}
+	s.PreCPUStats = dt.CPUStats{CPUUsage: dt.CPUUsage{TotalUsage: pretotal}, SystemUsage: presystem}
+	s.MemoryStats = dt.MemoryStats{Usage: 600, Limit: 1000, Stats: map[string]uint64{"inactive_file": 100}}
+	s.Networks = map[string]dt.NetworkStats{"eth0": {RxBytes: rx, TxBytes: tx}}
+	s.BlkioStats.IoServiceBytesRecursive = []dt.BlkioStatEntry{
+		{Major: 8, Op: "Read", Value: blkRead},
+		{Major: 8, Op: "Write", Value: blkWrite},
+		{Major: 8, Op: "Total", Value: blkRead + blkWrite},
+	}
+
+	return s
+}
+
+func TestNewDockerStatsWatch_Invalid(t *testing.T) {
+	for _, conf := range []DockerStatsWatchConf{
+		{},
+		{ContainerRegex: []string{"("}},
+	} {
+		_, err := NewDockerStatsWatch(conf)
+		require.ErrorIs(t, err, ErrDockerStatsWatchConf)
+	}
+}
+
+func TestDockerStatsWatch(t *testing.T) {
+	t0 := time.Date(2022, 10, 4, 12, 0, 0, 0, time.UTC)
+	ts := newMockDockerStatsDaemon(t,
+		[]dt.Container{
+			{ID: "a", Names: []string{"/algod"}, Image: "algorand/stable"},
+			{ID: "b", Names: []string{"/algod"}, Image: "algorand/stable"},
+		},
+		map[string][]dt.StatsJSON{
+			"a": {
+				newStatsSample(t0, 1_500_000_000, 1_000_000_000, 12_000_000_000, 10_000_000_000, 1000, 2000, 4096, 0),
+				newStatsSample(t0.Add(time.Second), 1_750_000_000, 1_700_000_000, 14_000_000_000, 13_900_000_000, 1500, 2600, 8192, 512),
+			},
+			// recreated, without a preceding sample
+			"b": {newStatsSample(t0.Add(2*time.Second), 100_000_000, 0, 15_000_000_000, 0, 10, 20, 0, 0)},
+		},
+	)
+
+	w, err := NewDockerStatsWatch(DockerStatsWatchConf{
+		Host:           "tcp://" + strings.TrimPrefix(ts.URL, "http://"),
+		ContainerRegex: []string{"^/algod$"},
+		Interval:       20 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	ch := make(chan interface{}, 100)
+	w.Subscribe(ch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	StartWithContext(ctx, w)
+	t.Cleanup(func() {
+		cancel()
+		w.Wait()
+	})
+
+	next := func() DockerStats {
+		t.Helper()
+
+		select {
+		case msg := <-ch:
+			stats, ok := msg.(DockerStats)
+			require.True(t, ok, "unexpected message %T", msg)
+
+			return stats
+		case <-time.After(5 * time.Second):
+			t.Fatal("no docker stats")
+		}
+
+		return DockerStats{}
+	}
+
+	// the first sample uses the daemon preceding sample for the CPU:
+	// 0.5s / 2s of system time on 4 cores
+	require.Equal(t, DockerStats{
+		ID:            "a",
+		Name:          "algod",
+		CPUPercent:    100,
+		MemoryUsage:   500,
+		MemoryLimit:   1000,
+		MemoryPercent: 50,
+		Time:          t0,
+	}, next())
+
+	// the deltas are computed from the previous sample: 0.25s / 2s
+	require.Equal(t, DockerStats{
+		ID:            "a",
+		Name:          "algod",
+		CPUPercent:    50,
+		MemoryUsage:   500,
+		MemoryLimit:   1000,
+		MemoryPercent: 50,
+		NetworkRx:     500,
+		NetworkTx:     600,
+		BlockRead:     4096,
+		BlockWrite:    512,
+		Time:          t0.Add(time.Second),
+	}, next())
+
+	// the recreated container starts over
+	require.Equal(t, DockerStats{
+		ID:            "b",
+		Name:          "algod",
+		MemoryUsage:   500,
+		MemoryLimit:   1000,
+		MemoryPercent: 50,
+		Time:          t0.Add(2 * time.Second),
+	}, next())
+}
@@ -0,0 +1,362 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrExecWatchConf exec watch configuration error.
+	ErrExecWatchConf = errors.New("exec watch configuration error")
+
+	// ErrExecTimeout the command didn't exit within the timeout and was
+	// killed.
+	ErrExecTimeout = errors.New("command timed out")
+
+	// ErrExecOutputTruncated the command output exceeded the maximum size
+	// and couldn't be parsed.
+	ErrExecOutputTruncated = errors.New("command output truncated")
+)
+
+const (
+	defaultExecInterval       = 30 * time.Second
+	defaultExecTimeout        = 10 * time.Second
+	defaultExecMaxOutputBytes = 1 << 20
+
+	// execStderrTailBytes stderr kept for the ExecError, the end of it.
+	execStderrTailBytes = 4 << 10
+)
+
+// ExecOutput format of the command output.
+type ExecOutput string
+
+const (
+	// ExecOutputRaw the output is emitted as a string, without its
+	// trailing line break.
+	ExecOutputRaw ExecOutput = "raw"
+
+	// ExecOutputJSON the output is decoded as JSON and emitted as the
+	// decoded value.
+	ExecOutputJSON ExecOutput = "json"
+
+	// ExecOutputKeyValue the output lines are parsed as key=value or
+	// key: value pairs, i.e. goal node status, and emitted as a
+	// map[string]string. Lines without a separator are skipped.
+	ExecOutputKeyValue ExecOutput = "kv"
+)
+
+// ExecWatchConf ExecWatch configuration struct.
+type ExecWatchConf struct {
+	// Argv the command and its arguments, run without a shell.
+	Argv []string
+
+	// Interval how often the command is run. Defaults to 30s.
+	Interval time.Duration
+
+	// Timeout after which the command and its children are killed.
+	// Defaults to 10s.
+	Timeout time.Duration
+
+	// Output format of the command output. Defaults to ExecOutputRaw.
+	Output ExecOutput
+
+	// MaxOutputBytes output kept, the rest is dropped. Defaults to 1 MiB.
+	MaxOutputBytes int
+}
+
+// ExecError failure of an ExecWatch run, emitted in place of the output.
+type ExecError struct {
+	Argv []string
+
+	// ExitCode the command exit code, -1 if it didn't exit by itself.
+	ExitCode int
+
+	// Stderr the end of the command error output.
+	Stderr string
+
+	Err error
+}
+
+func (e *ExecError) Error() string {
+	return fmt.Sprintf("%s: exit code %d: %v", strings.Join(e.Argv, " "), e.ExitCode, e.Err)
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// ExecWatch implements Watcher interface.
+// Runs a command on an interval and emits its parsed output, or an
+// *ExecError if it fails. A run lasting longer than the interval delays the
+// next one, runs never overlap.
+type ExecWatch struct {
+	ExecWatchConf
+	Watch
+}
+
+// NewExecWatch ExecWatch constructor, returns an error if the command or
+// the output format is invalid.
+func NewExecWatch(conf ExecWatchConf) (*ExecWatch, error) {
+	if len(conf.Argv) == 0 || conf.Argv[0] == "" {
+		return nil, fmt.Errorf("%w: command is required", ErrExecWatchConf)
+	}
+
+	w := new(ExecWatch)
+	w.Watch = NewWatch()
+	w.Name = "exec"
+	w.ExecWatchConf = conf
+	w.Log = w.Log.With("command", conf.Argv[0])
+
+	switch w.Output {
+	case "":
+		w.Output = ExecOutputRaw
+	case ExecOutputRaw, ExecOutputJSON, ExecOutputKeyValue:
+	default:
+		return nil, fmt.Errorf("%w: unknown output format %q", ErrExecWatchConf, w.Output)
+	}
+
+	if w.Interval < 1 {
+		w.Interval = defaultExecInterval
+	}
+	if w.Timeout < 1 {
+		w.Timeout = defaultExecTimeout
+	}
+	if w.MaxOutputBytes < 1 {
+		w.MaxOutputBytes = defaultExecMaxOutputBytes
+	}
+
+	return w, nil
+}
+
+// StartUnsafe starts the goroutine running the command.
+func (w *ExecWatch) StartUnsafe() {
+	w.Watch.StartUnsafe()
+
+	w.wg.Add(1)
+	go w.execLoop(w.runContext(), w.StopKey)
+}
+
+// execLoop runs the command right away and then on every interval, until
+// ctx is done or stop, the StopKey of the run it was started for, is closed.
+func (w *ExecWatch) execLoop(ctx context.Context, stop <-chan bool) {
+	defer w.wg.Done()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// the ticks elapsing during a run are dropped
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		started := time.Now()
+		msg := w.run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if took := time.Since(started); took > w.Interval {
+			w.Log.Warnw("command run exceeded the interval, skipping runs", "took", took, "interval", w.Interval)
+		}
+		if execErr, ok := msg.(*ExecError); ok {
+			w.ReportError(execErr)
+		}
+		w.Emit(msg)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// run runs the command and returns its parsed output or an *ExecError.
+func (w *ExecWatch) run(ctx context.Context) interface{} {
+	cmd := exec.Command(w.Argv[0], w.Argv[1:]...)
+	// its own process group, so that its children are killed along
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	stdout := &headBuffer{max: w.MaxOutputBytes}
+	stderr := &tailBuffer{max: execStderrTailBytes}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	execErr := func(err error) *ExecError {
+		return &ExecError{Argv: w.Argv, ExitCode: -1, Stderr: string(stderr.buf), Err: err}
+	}
+
+	if err := cmd.Start(); err != nil {
+		return execErr(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	timeout := time.NewTimer(w.Timeout)
+	defer timeout.Stop()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-timeout.C:
+		w.killGroup(cmd)
+		<-done
+
+		return execErr(ErrExecTimeout)
+	case <-ctx.Done():
+		w.killGroup(cmd)
+		<-done
+
+		return execErr(ctx.Err())
+	}
+
+	if err != nil {
+		e := execErr(err)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			e.ExitCode = exitErr.ExitCode()
+		}
+
+		return e
+	}
+
+	if stdout.truncated {
+		w.Log.Warnw("command output truncated", "max_output_bytes", w.MaxOutputBytes)
+	}
+
+	out, err := w.parse(stdout.buf, stdout.truncated)
+	if err != nil {
+		e := execErr(err)
+		e.ExitCode = 0
+
+		return e
+	}
+
+	return out
+}
+
+// killGroup kills the process group of the command.
+func (w *ExecWatch) killGroup(cmd *exec.Cmd) {
+	if err := killProcessGroup(cmd); err != nil {
+		w.Log.Warnw("failed to kill command process group", "pid", cmd.Process.Pid, zap.Error(err))
+	}
+}
+
+// killProcessGroup kills the process group of a command started with
+// Setpgid, its children included.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+func (w *ExecWatch) parse(out []byte, truncated bool) (interface{}, error) {
+	switch w.Output {
+	case ExecOutputJSON:
+		if truncated {
+			return nil, ErrExecOutputTruncated
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(out, &v); err != nil {
+			return nil, fmt.Errorf("failed to decode JSON output: %w", err)
+		}
+
+		return v, nil
+
+	case ExecOutputKeyValue:
+		return parseKeyValues(out), nil
+
+	default:
+		return strings.TrimRight(string(out), "\r\n"), nil
+	}
+}
+
+// parseKeyValues parses the key=value and key: value lines, whichever
+// separator comes first.
+func parseKeyValues(out []byte) map[string]string {
+	values := map[string]string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, len(out)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		i := strings.IndexAny(line, "=:")
+		if i < 1 {
+			continue
+		}
+
+		key := strings.TrimSpace(line[:i])
+		if key == "" {
+			continue
+		}
+		values[key] = strings.TrimSpace(line[i+1:])
+	}
+
+	return values
+}
+
+// headBuffer keeps the first max bytes written to it.
+type headBuffer struct {
+	buf       []byte
+	max       int
+	truncated bool
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if n := b.max - len(b.buf); n < len(p) {
+		b.buf = append(b.buf, p[:n]...)
+		b.truncated = true
+	} else {
+		b.buf = append(b.buf, p...)
+	}
+
+	// the rest is drained so that the command doesn't block
+	return len(p), nil
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	buf []byte
+	max int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = append([]byte(nil), b.buf[len(b.buf)-b.max:]...)
+	}
+
+	return len(p), nil
+}
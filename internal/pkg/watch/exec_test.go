@@ -0,0 +1,242 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func startExecWatch(t *testing.T, conf ExecWatchConf) (*ExecWatch, chan interface{}) {
+	t.Helper()
+
+	if conf.Interval == 0 {
+		conf.Interval = time.Hour
+	}
+	w, err := NewExecWatch(conf)
+	require.NoError(t, err)
+
+	ch := make(chan interface{}, 100)
+	w.Subscribe(ch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	StartWithContext(ctx, w)
+	t.Cleanup(func() {
+		cancel()
+		w.Wait()
+	})
+
+	return w, ch
+}
+
+func requireExecMessage(t *testing.T, ch chan interface{}) interface{} {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no command output")
+	}
+
+	return nil
+}
+
+func requireExecError(t *testing.T, ch chan interface{}) *ExecError {
+	t.Helper()
+
+	msg := requireExecMessage(t, ch)
+	execErr, ok := msg.(*ExecError)
+	require.True(t, ok, "unexpected message %v", msg)
+
+	return execErr
+}
+
+func TestNewExecWatch_Invalid(t *testing.T) {
+	for _, conf := range []ExecWatchConf{
+		{},
+		{Argv: []string{""}},
+		{Argv: []string{"true"}, Output: "xml"},
+	} {
+		_, err := NewExecWatch(conf)
+		require.ErrorIs(t, err, ErrExecWatchConf, "%+v", conf)
+	}
+}
+
+func TestExecWatch_Output(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		output ExecOutput
+		want   interface{}
+	}{
+		{
+			name:   "raw",
+			script: `df_avail=123456; printf "%s\n" $df_avail`,
+			want:   "123456",
+		},
+		{
+			name:   "json",
+			script: `echo '{"round": 23456789, "catchup": false}'`,
+			output: ExecOutputJSON,
+			want:   map[string]interface{}{"round": float64(23456789), "catchup": false},
+		},
+		{
+			name:   "key value",
+			script: `printf "Last committed block: 23456789\nTime since last block: 2.1s\nsync_time=0.0s\n\nGenesis ID: mainnet-v1.0\n"`,
+			output: ExecOutputKeyValue,
+			want: map[string]string{
+				"Last committed block":  "23456789",
+				"Time since last block": "2.1s",
+				"sync_time":             "0.0s",
+				"Genesis ID":            "mainnet-v1.0",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ch := startExecWatch(t, ExecWatchConf{Argv: []string{"sh", "-c", tt.script}, Output: tt.output})
+			require.Equal(t, tt.want, requireExecMessage(t, ch))
+		})
+	}
+}
+
+func TestExecWatch_Failure(t *testing.T) {
+	_, ch := startExecWatch(t, ExecWatchConf{
+		Argv: []string{"sh", "-c", `echo partial; echo "algod not running" >&2; exit 3`},
+	})
+
+	execErr := requireExecError(t, ch)
+	require.Equal(t, 3, execErr.ExitCode)
+	require.Equal(t, "algod not running\n", execErr.Stderr)
+
+	_, ch = startExecWatch(t, ExecWatchConf{Argv: []string{"/nonexistent/goal"}})
+	execErr = requireExecError(t, ch)
+	require.Equal(t, -1, execErr.ExitCode)
+
+	_, ch = startExecWatch(t, ExecWatchConf{Argv: []string{"echo", "not json"}, Output: ExecOutputJSON})
+	execErr = requireExecError(t, ch)
+	require.Equal(t, 0, execErr.ExitCode)
+}
+
+func TestExecWatch_ReportError(t *testing.T) {
+	w, err := NewExecWatch(ExecWatchConf{Argv: []string{"sh", "-c", "exit 3"}, Interval: time.Hour})
+	require.NoError(t, err)
+
+	reported := make(chan error, 1)
+	w.OnError(func(err error) { reported <- err })
+
+	ch := make(chan interface{}, 1)
+	w.Subscribe(ch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	StartWithContext(ctx, w)
+	t.Cleanup(func() {
+		cancel()
+		w.Wait()
+	})
+
+	execErr := requireExecError(t, ch)
+	require.Equal(t, execErr, <-reported)
+}
+
+func TestExecWatch_StderrTail(t *testing.T) {
+	_, ch := startExecWatch(t, ExecWatchConf{
+		Argv: []string{"sh", "-c", `head -c 10000 /dev/zero | tr '\0' a >&2; echo tail >&2; exit 1`},
+	})
+
+	execErr := requireExecError(t, ch)
+	require.Len(t, execErr.Stderr, execStderrTailBytes)
+	require.True(t, strings.HasSuffix(execErr.Stderr, "aaatail\n"))
+}
+
+// processGone whether the process exited, zombies included.
+func processGone(pid int) bool {
+	stat, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return true
+	}
+
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+
+	return len(fields) > 0 && fields[0] == "Z"
+}
+
+func TestExecWatch_Timeout(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+
+	_, ch := startExecWatch(t, ExecWatchConf{
+		Argv:    []string{"sh", "-c", `sleep 30 & echo $! > ` + pidFile + `; echo started >&2; wait`},
+		Timeout: 200 * time.Millisecond,
+	})
+
+	execErr := requireExecError(t, ch)
+	require.True(t, errors.Is(execErr, ErrExecTimeout))
+	require.Equal(t, -1, execErr.ExitCode)
+	require.Equal(t, "started\n", execErr.Stderr)
+
+	// the children are killed along
+	content, err := ioutil.ReadFile(pidFile)
+	require.NoError(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return processGone(pid) }, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestExecWatch_Truncated(t *testing.T) {
+	script := `head -c 100000 /dev/zero | tr '\0' a`
+
+	_, ch := startExecWatch(t, ExecWatchConf{Argv: []string{"sh", "-c", script}, MaxOutputBytes: 1000})
+	require.Equal(t, strings.Repeat("a", 1000), requireExecMessage(t, ch))
+
+	_, ch = startExecWatch(t, ExecWatchConf{Argv: []string{"sh", "-c", script}, MaxOutputBytes: 1000, Output: ExecOutputJSON})
+	require.ErrorIs(t, requireExecError(t, ch), ErrExecOutputTruncated)
+}
+
+func TestExecWatch_NoOverlap(t *testing.T) {
+	trace := filepath.Join(t.TempDir(), "trace")
+
+	w, ch := startExecWatch(t, ExecWatchConf{
+		Argv:     []string{"sh", "-c", `echo start >> ` + trace + `; sleep 0.1; echo end >> ` + trace},
+		Interval: 20 * time.Millisecond,
+	})
+
+	for i := 0; i < 3; i++ {
+		requireExecMessage(t, ch)
+	}
+	w.Stop()
+	w.Wait()
+
+	content, err := ioutil.ReadFile(trace)
+	require.NoError(t, err)
+
+	lines := strings.Fields(string(content))
+	require.GreaterOrEqual(t, len(lines), 6)
+	for i, line := range lines {
+		if i%2 == 0 {
+			require.Equal(t, "start", line, "%v", lines)
+		} else {
+			require.Equal(t, "end", line, "%v", lines)
+		}
+	}
+}
@@ -14,16 +14,21 @@
 package factory
 
 import (
+	"errors"
+	"fmt"
 	"net/url"
+	"time"
 
 	"agent/internal/pkg/global"
 	"agent/internal/pkg/watch"
 	"agent/pkg/collector"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"go.uber.org/zap"
 )
 
+// ErrUnknownWatchType the configured watch type does not exist.
+var ErrUnknownWatchType = errors.New("specified watch type not found")
+
 // NewWatcherByType builds and registers a new watcher to the
 // default watcher registry.
 func NewWatcherByType(conf global.WatchConfig) (watch.Watcher, error) {
@@ -31,16 +36,16 @@ func NewWatcherByType(conf global.WatchConfig) (watch.Watcher, error) {
 	wt := global.WatchType(conf.Type)
 	switch {
 	case wt.IsPrometheus(): // prometheus
-		var clr prometheus.Collector
-		clr = prometheusCollectorsFactory(collector.Name(wt))
-		registry := prometheus.NewPedanticRegistry()
+		clr, registry, err := newCollectorGatherer(wt)
+		if err != nil {
+			return nil, err
+		}
 		w = watch.NewCollectorWatch(watch.CollectorWatchConf{
 			Type:      global.WatchType(conf.Type),
 			Collector: clr,
 			Gatherer:  registry,
 			Interval:  conf.SamplingInterval,
 		})
-		registry.MustRegister(clr)
 	case wt.IsInflux(): // influx
 		influxdbURL, err := url.Parse(conf.UpstreamURL)
 		if err != nil {
@@ -54,24 +59,105 @@ func NewWatcherByType(conf global.WatchConfig) (watch.Watcher, error) {
 			PlatformEnabled:   *global.AgentConf.Platform.Enabled,
 			ExporterActivated: conf.ExporterActivated,
 		})
+	case wt.IsBackup(): // backup freshness
+		backupWatch, err := watch.NewBackupWatch(watch.BackupWatchConf{
+			Type:          wt,
+			Interval:      conf.SamplingInterval,
+			Glob:          conf.Glob,
+			S3:            conf.S3,
+			AgeThresholds: conf.AgeThresholds,
+		})
+		if err != nil {
+			return nil, err
+		}
+		w = backupWatch
 	default:
-		zap.S().Fatalw("specified collector type not found", "collector", conf.Type)
+		return nil, fmt.Errorf("%w: %s", ErrUnknownWatchType, conf.Type)
 	}
 
 	return w, nil
 }
 
+// NewNodeExporterWatch builds the watch scraping node_exporter in place of
+// the internal collectors replaced by conf's mappings, which are gathered
+// as a fallback. Returns the watcher configurations left to build, the
+// replaced collectors removed.
+func NewNodeExporterWatch(conf global.NodeExporterConfig, interval time.Duration, watchers []*global.WatchConfig) (watch.Watcher, []*global.WatchConfig, error) {
+	fallback := make(map[global.WatchType]prometheus.Gatherer, len(conf.Mappings))
+	for _, m := range conf.Mappings {
+		wt := global.WatchType(m.Collector)
+		if _, ok := fallback[wt]; ok {
+			continue
+		}
+
+		_, registry, err := newCollectorGatherer(wt)
+		if err != nil {
+			return nil, nil, err
+		}
+		fallback[wt] = registry
+	}
+
+	w, err := watch.NewNodeExporterWatch(watch.NodeExporterWatchConf{
+		URL:           conf.URL,
+		Interval:      interval,
+		Timeout:       conf.Timeout,
+		FallbackAfter: conf.FallbackAfter,
+		Mappings:      conf.Mappings,
+		Fallback:      fallback,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	remaining := make([]*global.WatchConfig, 0, len(watchers))
+	for _, watcherConf := range watchers {
+		if _, ok := fallback[global.WatchType(watcherConf.Type)]; ok {
+			continue
+		}
+		remaining = append(remaining, watcherConf)
+	}
+
+	return w, remaining, nil
+}
+
+// Cadence returns the expected emission cadence of the watcher built for
+// conf. Only collector watchers emit periodically, other types are exempt
+// from stall detection.
+func Cadence(conf global.WatchConfig) time.Duration {
+	if global.WatchType(conf.Type).IsPrometheus() {
+		return watch.ExpectedCadence(conf.SamplingInterval)
+	}
+
+	return watch.NoCadence
+}
+
+// newCollectorGatherer creates the collector of type wt, registered to a
+// dedicated registry.
+func newCollectorGatherer(wt global.WatchType) (prometheus.Collector, *prometheus.Registry, error) {
+	clr, err := prometheusCollectorsFactory(collector.Name(wt))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	if err := collector.Register(registry, collector.Name(wt), clr); err != nil {
+		return nil, nil, err
+	}
+
+	return clr, registry, nil
+}
+
 // prometheusCollectorsFactory creates watchers backed by pkg/collector.
-func prometheusCollectorsFactory(c collector.Name) prometheus.Collector {
+func prometheusCollectorsFactory(c collector.Name) (prometheus.Collector, error) {
 	clrFunc, ok := collector.CollectorsFactory[c]
 	if !ok {
-		zap.S().Fatalw("specified prometheus collector constructor not found", "collector", c)
+		return nil, fmt.Errorf("%w: %s", ErrUnknownWatchType, c)
 	}
 
 	clr, err := clrFunc()
 	if err != nil {
-		zap.S().Fatalw("collector constructor error", zap.Error(err))
+		return nil, fmt.Errorf("collector %s constructor error: %w", c, err)
 	}
 
-	return clr
+	return clr, nil
 }
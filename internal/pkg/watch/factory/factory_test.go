@@ -22,6 +22,7 @@ import (
 	"agent/internal/pkg/global"
 	"agent/internal/pkg/watch"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 )
 
@@ -41,19 +42,26 @@ var tests = []string{
 	"prometheus.proc.net.netstat_linux",
 	"prometheus.proc.net.arp_linux",
 	"prometheus.proc.stat_linux",
+	"prometheus.proc.buddyinfo",
 	"prometheus.proc.conntrack_linux",
 	"prometheus.proc.cpu",
 	"prometheus.proc.diskstats",
 	"prometheus.proc.entropy",
 	"prometheus.proc.filefd",
 	"prometheus.proc.filesystem",
+	"prometheus.proc.interrupts",
 	"prometheus.proc.loadavg",
 	"prometheus.proc.meminfo",
 	"prometheus.proc.netclass",
 	"prometheus.proc.netdev",
+	"prometheus.proc.processes",
+	"prometheus.proc.softirqs",
 	"prometheus.proc.sockstat",
+	"prometheus.proc.tcpstat",
 	"prometheus.proc.textfile",
+	"prometheus.os_release",
 	"prometheus.time",
+	"prometheus.timex",
 	"prometheus.uname",
 	"prometheus.vmstat",
 }
@@ -103,3 +111,52 @@ func TestNewWatcherByType(t *testing.T) {
 		})
 	}
 }
+
+func TestNewWatcherByType_Unknown(t *testing.T) {
+	_, err := NewWatcherByType(global.WatchConfig{Type: "prometheus.foobar"})
+	require.ErrorIs(t, err, ErrUnknownWatchType)
+
+	_, err = NewWatcherByType(global.WatchConfig{Type: "foobar"})
+	require.ErrorIs(t, err, ErrUnknownWatchType)
+}
+
+func TestCollectorWatchProbe(t *testing.T) {
+	scrapeErrors := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "node_scrape_collector_errors",
+		Help: "Scrape errors by reason.",
+	}, []string{"reason"})
+	scrapeErrors.WithLabelValues("permission").Set(0)
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(scrapeErrors)
+
+	w := watch.NewCollectorWatch(watch.CollectorWatchConf{Type: "prometheus.proc.mdadm", Gatherer: registry})
+	require.NoError(t, w.Probe())
+
+	scrapeErrors.WithLabelValues("permission").Set(1)
+	err := w.Probe()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "permission")
+}
+
+func TestNewNodeExporterWatch(t *testing.T) {
+	conf := global.NodeExporterConfig{
+		URL: global.DefaultRuntimeNodeExporterURL,
+		Mappings: []global.NodeExporterMapping{
+			{Collector: "prometheus.proc.loadavg", Families: []string{"node_load1", "node_load5"}},
+		},
+	}
+	watchers := []*global.WatchConfig{
+		{Type: "prometheus.proc.cpu"},
+		{Type: "prometheus.proc.loadavg"},
+	}
+
+	w, remaining, err := NewNodeExporterWatch(conf, time.Second, watchers)
+	require.NoError(t, err)
+	require.IsType(t, &watch.NodeExporterWatch{}, w)
+	require.Equal(t, []*global.WatchConfig{{Type: "prometheus.proc.cpu"}}, remaining)
+
+	conf.Mappings[0].Collector = "prometheus.proc.unknown"
+	_, _, err = NewNodeExporterWatch(conf, time.Second, watchers)
+	require.Error(t, err)
+}
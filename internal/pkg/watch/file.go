@@ -0,0 +1,351 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// ErrFileWatchConf file watch configuration error.
+var ErrFileWatchConf = errors.New("file watch configuration error")
+
+var (
+	// defaultFileDebounce window used when none is configured.
+	defaultFileDebounce = 100 * time.Millisecond
+
+	// fileRewatchInterval how often a watch on a missing directory is
+	// attempted again.
+	fileRewatchInterval = time.Second
+)
+
+// FileOp operation on a watched file.
+type FileOp int
+
+const (
+	// FileCreated the file appeared.
+	FileCreated FileOp = iota + 1
+
+	// FileModified the file was written to or replaced.
+	FileModified
+
+	// FileDeleted the file disappeared, removed or renamed away.
+	FileDeleted
+)
+
+func (o FileOp) String() string {
+	switch o {
+	case FileCreated:
+		return "created"
+	case FileModified:
+		return "modified"
+	case FileDeleted:
+		return "deleted"
+	default:
+		return fmt.Sprintf("FileOp(%d)", int(o))
+	}
+}
+
+// FileEvent a change of a watched file, emitted by FileWatch.
+type FileEvent struct {
+	Path      string
+	Op        FileOp
+	Timestamp time.Time
+}
+
+// *** FileWatch ***
+
+// FileWatchConf FileWatch configuration struct.
+type FileWatchConf struct {
+	// Path file or directory to watch.
+	Path string
+
+	// Glob pattern the base names of the files in the Path directory must
+	// match, i.e. *.json. Setting it implies Path is a directory.
+	Glob string
+
+	// Debounce quiet period ending a burst of changes, a single event is
+	// emitted per file changed during the burst. Defaults to 100ms.
+	Debounce time.Duration
+}
+
+// FileWatch implements Watcher interface.
+// Emits a FileEvent for every file created, modified or deleted.
+//
+// The parent directory of a watched file is watched rather than the file
+// itself, so that files replaced by a rename, i.e. by editors saving
+// atomically, are still watched. Events are computed by comparing the files
+// existing before and after a burst of changes: a file replaced by a rename
+// is reported as modified, a temporary file created and renamed away within
+// a burst isn't reported.
+type FileWatch struct {
+	FileWatchConf
+	Watch
+
+	// dir directory watched, Path or its parent.
+	dir string
+	// file whether Path is a file rather than a directory.
+	file bool
+
+	// notify and known are set on start and owned by the watch goroutine.
+	notify *fsnotify.Watcher
+	// known the matching files existing as of the last flush.
+	known map[string]bool
+}
+
+// NewFileWatch file watch constructor.
+func NewFileWatch(conf FileWatchConf) (*FileWatch, error) {
+	if conf.Path == "" {
+		return nil, fmt.Errorf("%w: path is required", ErrFileWatchConf)
+	}
+	if _, err := filepath.Match(conf.Glob, ""); err != nil {
+		return nil, fmt.Errorf("%w: invalid glob %q: %v", ErrFileWatchConf, conf.Glob, err)
+	}
+
+	w := new(FileWatch)
+	w.Watch = NewWatch()
+	w.Name = "file"
+	w.FileWatchConf = conf
+	w.Path = filepath.Clean(conf.Path)
+
+	if w.Debounce < 1 {
+		w.Debounce = defaultFileDebounce
+	}
+
+	// a path that doesn't exist yet is expected to be a file, unless a glob
+	// is set
+	info, err := os.Stat(w.Path)
+	w.file = w.Glob == "" && (err != nil || !info.IsDir())
+	w.dir = w.Path
+	if w.file {
+		w.dir = filepath.Dir(w.Path)
+	}
+
+	w.Log = w.Log.With("path", w.Path)
+
+	return w, nil
+}
+
+// StartUnsafe sets watch running state to true, establishes the watch
+// and starts the goroutine emitting the changes.
+func (w *FileWatch) StartUnsafe() {
+	w.Watch.StartUnsafe()
+
+	notify, err := fsnotify.NewWatcher()
+	if err != nil {
+		w.Log.Errorw("failed to create file watcher", zap.Error(err))
+		w.ReportError(err)
+
+		return
+	}
+	w.notify = notify
+	w.known = w.scan()
+
+	// the watch is established before returning, later changes are caught
+	watching := w.add()
+
+	w.wg.Add(1)
+	go w.fileLoop(w.runContext(), w.StopKey, watching)
+}
+
+// fileLoop emits the changes until ctx is done or stop, the StopKey of the
+// run it was started for, is closed.
+func (w *FileWatch) fileLoop(ctx context.Context, stop <-chan bool, watching bool) {
+	defer w.wg.Done()
+	defer w.notify.Close()
+
+	changed := map[string]struct{}{}
+	debounce := time.NewTimer(w.Debounce)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	rewatch := time.NewTicker(fileRewatchInterval)
+	defer rewatch.Stop()
+
+	for {
+		select {
+		case ev, ok := <-w.notify.Events:
+			if !ok {
+				return
+			}
+
+			if ev.Name == w.dir && ev.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				w.Log.Warn("watched directory removed, waiting for it to be recreated")
+				watching = false
+				_ = w.notify.Remove(w.dir)
+
+				// no event is received for the files it contained
+				for path := range w.known {
+					changed[path] = struct{}{}
+				}
+				resetTimer(debounce, w.Debounce)
+
+				continue
+			}
+			if !w.matches(ev.Name) {
+				continue
+			}
+
+			changed[ev.Name] = struct{}{}
+			resetTimer(debounce, w.Debounce)
+
+		case err, ok := <-w.notify.Errors:
+			if !ok {
+				return
+			}
+			w.Log.Warnw("file watcher error", zap.Error(err))
+			w.ReportError(err)
+
+		case <-debounce.C:
+			w.flush(changed)
+			changed = map[string]struct{}{}
+
+		case <-rewatch.C:
+			if watching || !w.add() {
+				continue
+			}
+			watching = true
+
+			// the directory was replaced, compare everything
+			for path := range w.known {
+				changed[path] = struct{}{}
+			}
+			for path := range w.scan() {
+				changed[path] = struct{}{}
+			}
+			resetTimer(debounce, w.Debounce)
+
+		case <-stop:
+			return
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// add watches the directory, returns whether it could.
+func (w *FileWatch) add() bool {
+	// a removed directory may still be registered
+	_ = w.notify.Remove(w.dir)
+
+	if err := w.notify.Add(w.dir); err != nil {
+		w.Log.Debugw("failed to watch directory, will retry", "dir", w.dir, zap.Error(err))
+
+		return false
+	}
+
+	return true
+}
+
+// flush emits an event for each changed file whose existence or content
+// changed since the previous flush.
+func (w *FileWatch) flush(changed map[string]struct{}) {
+	paths := make([]string, 0, len(changed))
+	for path := range changed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	now := time.Now()
+	for _, path := range paths {
+		existed := w.known[path]
+		exists := fileExists(path)
+
+		var op FileOp
+		switch {
+		case exists && existed:
+			op = FileModified
+		case exists:
+			op = FileCreated
+			w.known[path] = true
+		case existed:
+			op = FileDeleted
+			delete(w.known, path)
+		default:
+			// created and removed within the burst
+			continue
+		}
+
+		w.Emit(FileEvent{Path: path, Op: op, Timestamp: now})
+	}
+}
+
+// scan returns the matching files currently existing.
+func (w *FileWatch) scan() map[string]bool {
+	known := map[string]bool{}
+
+	if w.file {
+		if fileExists(w.Path) {
+			known[w.Path] = true
+		}
+
+		return known
+	}
+
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return known
+	}
+	for _, entry := range entries {
+		path := filepath.Join(w.dir, entry.Name())
+		if !entry.IsDir() && w.matches(path) {
+			known[path] = true
+		}
+	}
+
+	return known
+}
+
+// matches whether path is watched.
+func (w *FileWatch) matches(path string) bool {
+	if w.file {
+		return path == w.Path
+	}
+	if filepath.Dir(path) != w.dir {
+		return false
+	}
+	if w.Glob == "" {
+		return true
+	}
+	ok, _ := filepath.Match(w.Glob, filepath.Base(path))
+
+	return ok
+}
+
+// resetTimer resets timer to d, discarding a pending expiration.
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
+
+// fileExists whether path exists and is not a directory.
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+
+	return err == nil && !info.IsDir()
+}
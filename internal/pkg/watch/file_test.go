@@ -0,0 +1,216 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testFileDebounce = 50 * time.Millisecond
+
+func startFileWatch(t *testing.T, conf FileWatchConf) (*FileWatch, chan interface{}) {
+	t.Helper()
+
+	if conf.Debounce == 0 {
+		conf.Debounce = testFileDebounce
+	}
+	w, err := NewFileWatch(conf)
+	require.NoError(t, err)
+
+	ch := make(chan interface{}, 100)
+	w.Subscribe(ch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	StartWithContext(ctx, w)
+	t.Cleanup(func() {
+		cancel()
+		w.Wait()
+	})
+
+	return w, ch
+}
+
+func requireFileEvent(t *testing.T, ch chan interface{}, path string, op FileOp) {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		ev, ok := msg.(FileEvent)
+		require.True(t, ok, "unexpected message %v", msg)
+		require.Equal(t, path, ev.Path)
+		require.Equal(t, op, ev.Op, "got %v, want %v", ev.Op, op)
+		require.WithinDuration(t, time.Now(), ev.Timestamp, time.Second)
+	case <-time.After(3 * time.Second):
+		t.Fatalf("no %v event for %s", op, path)
+	}
+}
+
+func requireNoFileEvent(t *testing.T, ch chan interface{}) {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		t.Fatalf("unexpected event %+v", msg)
+	case <-time.After(4 * testFileDebounce):
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+}
+
+func TestNewFileWatch_Invalid(t *testing.T) {
+	_, err := NewFileWatch(FileWatchConf{})
+	require.ErrorIs(t, err, ErrFileWatchConf)
+
+	_, err = NewFileWatch(FileWatchConf{Path: t.TempDir(), Glob: "[*.json"})
+	require.ErrorIs(t, err, ErrFileWatchConf)
+}
+
+func TestFileWatch_File(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "genesis.json")
+	other := filepath.Join(dir, "other.json")
+
+	_, ch := startFileWatch(t, FileWatchConf{Path: path})
+
+	writeFile(t, path, "{}")
+	requireFileEvent(t, ch, path, FileCreated)
+
+	writeFile(t, path, `{"a": 1}`)
+	requireFileEvent(t, ch, path, FileModified)
+
+	// other files of the directory are ignored
+	writeFile(t, other, "{}")
+	requireNoFileEvent(t, ch)
+
+	require.NoError(t, os.Remove(path))
+	requireFileEvent(t, ch, path, FileDeleted)
+}
+
+func TestFileWatch_AtomicSave(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yml")
+	writeFile(t, path, "a: 1")
+
+	_, ch := startFileWatch(t, FileWatchConf{Path: path})
+
+	for i := 0; i < 3; i++ {
+		// editors write a temporary file then rename it over the original
+		tmp := filepath.Join(dir, ".config.yml.swp")
+		writeFile(t, tmp, "a: 2")
+		require.NoError(t, os.Rename(tmp, path))
+
+		requireFileEvent(t, ch, path, FileModified)
+		requireNoFileEvent(t, ch)
+	}
+
+	// the file renamed away
+	require.NoError(t, os.Rename(path, filepath.Join(dir, "config.yml.bak")))
+	requireFileEvent(t, ch, path, FileDeleted)
+}
+
+func TestFileWatch_Debounce(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ledger.log")
+	writeFile(t, path, "")
+
+	_, ch := startFileWatch(t, FileWatchConf{Path: path, Debounce: 200 * time.Millisecond})
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	defer f.Close()
+
+	for i := 0; i < 10; i++ {
+		_, err := f.WriteString("line\n")
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	requireFileEvent(t, ch, path, FileModified)
+	requireNoFileEvent(t, ch)
+}
+
+func TestFileWatch_DirectoryGlob(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "a.json")
+	writeFile(t, existing, "{}")
+
+	_, ch := startFileWatch(t, FileWatchConf{Path: dir, Glob: "*.json"})
+
+	created := filepath.Join(dir, "b.json")
+	writeFile(t, created, "{}")
+	requireFileEvent(t, ch, created, FileCreated)
+
+	writeFile(t, filepath.Join(dir, "b.txt"), "")
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "c.json"), 0o700))
+	requireNoFileEvent(t, ch)
+
+	require.NoError(t, os.Remove(existing))
+	requireFileEvent(t, ch, existing, FileDeleted)
+}
+
+func TestFileWatch_DirectoryReplaced(t *testing.T) {
+	defer func(interval time.Duration) { fileRewatchInterval = interval }(fileRewatchInterval)
+	fileRewatchInterval = 20 * time.Millisecond
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "config")
+	require.NoError(t, os.Mkdir(dir, 0o700))
+	path := filepath.Join(dir, "node.toml")
+	writeFile(t, path, "a = 1")
+
+	_, ch := startFileWatch(t, FileWatchConf{Path: path})
+
+	require.NoError(t, os.Rename(dir, filepath.Join(root, "config.old")))
+	requireFileEvent(t, ch, path, FileDeleted)
+
+	require.NoError(t, os.Mkdir(dir, 0o700))
+	writeFile(t, path, "a = 2")
+	requireFileEvent(t, ch, path, FileCreated)
+
+	// changes are caught in the new directory
+	writeFile(t, path, "a = 3")
+	requireFileEvent(t, ch, path, FileModified)
+}
+
+func TestFileWatch_Stop(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "genesis.json")
+
+	w, ch := startFileWatch(t, FileWatchConf{Path: path})
+
+	writeFile(t, path, "{}")
+	requireFileEvent(t, ch, path, FileCreated)
+
+	w.Stop()
+	w.Wait()
+
+	writeFile(t, path, `{"a": 1}`)
+	requireNoFileEvent(t, ch)
+
+	// restarting picks up from the current state
+	StartWithContext(context.Background(), w)
+	writeFile(t, path, `{"a": 2}`)
+	requireFileEvent(t, ch, path, FileModified)
+	w.Stop()
+}
@@ -15,15 +15,27 @@ package watch
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"time"
 
 	"agent/internal/pkg/global"
 
+	"github.com/cenkalti/backoff"
 	"go.uber.org/zap"
 )
 
+const (
+	defaultHTTPInterval   = 10 * time.Second
+	defaultHTTPTimeout    = 10 * time.Second
+	defaultHTTPMaxBackoff = time.Minute
+)
+
 // *** HttpGetWatch ***
 
 // HTTPWatchConf HttpGetWatch configuration struct.
@@ -34,10 +46,77 @@ type HTTPWatchConf struct {
 	Interval    time.Duration
 	Headers     map[string]string
 	Timeout     time.Duration
+
+	// BearerToken sent in the Authorization header, if set.
+	BearerToken string
+
+	// JSON decode the response body as JSON and emit the decoded value
+	// rather than the raw body bytes.
+	JSON bool
+
+	// TLSConfig TLS configuration of the client, i.e. built with
+	// NewHTTPTLSConfig to trust a custom CA.
+	TLSConfig *tls.Config
+
+	// MaxBackoff longest delay between two attempts while the endpoint
+	// fails. Defaults to one minute, or Interval if longer.
+	MaxBackoff time.Duration
+}
+
+// HTTPError failure of an HTTPWatch request, emitted in place of the
+// response.
+type HTTPError struct {
+	URL string
+
+	// StatusCode the response status code, 0 if no response was received.
+	StatusCode int
+
+	Err error
+}
+
+func (e *HTTPError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("GET %s: %v", e.URL, e.Err)
+	}
+
+	return fmt.Sprintf("GET %s: status %d: %v", e.URL, e.StatusCode, e.Err)
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// NewHTTPTLSConfig returns a TLS configuration trusting the PEM encoded
+// certificates of caFile on top of the system ones, if set.
+// insecureSkipVerify disables the verification of the server certificate,
+// i.e. for a node serving a self-signed certificate on localhost.
+func NewHTTPTLSConfig(caFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	conf := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+
+	if caFile == "" {
+		return conf, nil
+	}
+
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in CA file %s", caFile)
+	}
+	conf.RootCAs = pool
+
+	return conf, nil
 }
 
 // HTTPWatch implements the Watcher interface for collecting
-// response body from an HTTP endpoint.
+// response body from an HTTP endpoint. Failed requests emit an
+// *HTTPError and are retried with an exponential backoff.
 type HTTPWatch struct {
 	HTTPWatchConf
 	Watch
@@ -55,6 +134,21 @@ func NewHTTPWatch(conf HTTPWatchConf) *HTTPWatch {
 		httpDataCh:    make(chan []byte, 10),
 	}
 
+	w.Name = "http"
+
+	if w.Interval < 1 {
+		w.Interval = defaultHTTPInterval
+	}
+	if w.Timeout < 1 {
+		w.Timeout = defaultHTTPTimeout
+	}
+	if w.MaxBackoff < 1 {
+		w.MaxBackoff = defaultHTTPMaxBackoff
+	}
+	if w.MaxBackoff < w.Interval {
+		w.MaxBackoff = w.Interval
+	}
+
 	w.Log = w.Log.With("url", w.URL)
 
 	return w
@@ -68,86 +162,136 @@ func (h *HTTPWatch) StartUnsafe() {
 
 	if h.client == nil {
 		h.client = &http.Client{}
+		if h.TLSConfig != nil {
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.TLSClientConfig = h.TLSConfig
+			h.client.Transport = transport
+		}
 	}
 
 	h.wg.Add(1)
-	go func() {
-		defer h.wg.Done()
-
-		for {
-			select {
-			case ui := <-h.URLUpdateCh:
-				eps, ok := ui.Val.([]global.PEFEndpoint)
-				if !ok {
-					zap.S().Error("type assertion failed for url update")
-				}
-
-				if len(eps) == 0 {
-					zap.S().Warnw("got empty pef endpoints slice, will ignore", "url", h.URL)
-					continue
-				}
-
-				if len(eps)+1 <= h.URLIndex {
-					zap.S().Warnw("endpoints slice smaller than watch index, will ignore", "len", len(eps), "index", h.URLIndex)
-
-					continue
-				}
-
-				// We don't have a way to dynamically schedule HTTP
-				// watchers depending on the length of discovered endpoints.
-				// Use the watcher's assigned index to pick the corresponding
-				// index from the endpoints slice.
-				ep := eps[h.URLIndex]
-
-				if ep.URL != h.URL {
-					zap.S().Infow("updating HTTP watch URL", "prev", h.URL, "new", ep.URL)
-					h.URL = ep.URL
-					h.Log = h.Log.With("url", h.URL)
-				}
-			case <-time.After(h.Interval):
-				ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
-				req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL, nil)
-				if err != nil {
-					cancel()
-					h.Log.Errorw("invalid http request", zap.Error(err))
-					continue
-				}
-
-				for k, v := range h.Headers {
-					req.Header.Add(k, v)
-				}
-
-				resp, err := h.client.Do(req)
-				if err != nil {
-					cancel()
-					h.Log.Errorw("http request failed", zap.Error(err))
-					continue
-				}
-				if resp.StatusCode > 299 {
-					cancel()
-					h.Log.Errorw("http request failed", "status_code", resp.StatusCode)
-					resp.Body.Close()
-					continue
-				}
-
-				out, err := io.ReadAll(resp.Body)
-				cancel()
-				if err != nil {
-					h.Log.Errorw("failed to read PEF body", zap.Error(err))
-					resp.Body.Close()
-					continue
-				}
-
-				if err := resp.Body.Close(); err != nil {
-					h.Log.Errorw("failed to close http body", zap.Error(err))
-				}
-
-				h.Emit(out)
-			case <-h.StopKey:
-				return
+	go h.pollLoop(h.runContext(), h.StopKey)
+}
+
+// pollLoop polls the endpoint until ctx is done or stop, the StopKey of
+// the run it was started for, is closed.
+func (h *HTTPWatch) pollLoop(ctx context.Context, stop <-chan bool) {
+	defer h.wg.Done()
+
+	backof := backoff.NewExponentialBackOff()
+	backof.InitialInterval = h.Interval
+	backof.MaxInterval = h.MaxBackoff
+	backof.MaxElapsedTime = 0 // never expire
+	backof.Reset()
+
+	wait := h.Interval
+	for {
+		select {
+		case ui := <-h.URLUpdateCh:
+			h.updateURL(ui)
+
+		case <-time.After(wait):
+			if err := h.poll(ctx); err != nil {
+				wait = backof.NextBackOff()
+				h.Log.Errorw("http request failed", zap.Error(err), "retry_timer", wait)
+				h.ReportError(err)
+				h.Emit(err)
+
+				continue
 			}
+
+			// no errors, reset to periodic requests
+			backof.Reset()
+			wait = h.Interval
+
+		case <-stop:
+			return
+
+		case <-ctx.Done():
+			return
 		}
-	}()
+	}
+}
+
+func (h *HTTPWatch) updateURL(ui global.ConfigUpdate) {
+	eps, ok := ui.Val.([]global.PEFEndpoint)
+	if !ok {
+		zap.S().Error("type assertion failed for url update")
+	}
+
+	if len(eps) == 0 {
+		zap.S().Warnw("got empty pef endpoints slice, will ignore", "url", h.URL)
+
+		return
+	}
+
+	if len(eps)+1 <= h.URLIndex {
+		zap.S().Warnw("endpoints slice smaller than watch index, will ignore", "len", len(eps), "index", h.URLIndex)
+
+		return
+	}
+
+	// We don't have a way to dynamically schedule HTTP
+	// watchers depending on the length of discovered endpoints.
+	// Use the watcher's assigned index to pick the corresponding
+	// index from the endpoints slice.
+	ep := eps[h.URLIndex]
+
+	if ep.URL != h.URL {
+		zap.S().Infow("updating HTTP watch URL", "prev", h.URL, "new", ep.URL)
+		h.URL = ep.URL
+		h.Log = h.Log.With("url", h.URL)
+	}
+}
+
+// poll sends a GET request and emits the response, returns the error to
+// emit instead if the request failed.
+func (h *HTTPWatch) poll(ctx context.Context) *HTTPError {
+	ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL, nil)
+	if err != nil {
+		return &HTTPError{URL: h.URL, Err: fmt.Errorf("invalid http request: %w", err)}
+	}
+
+	for k, v := range h.Headers {
+		req.Header.Add(k, v)
+	}
+	if h.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.BearerToken)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return &HTTPError{URL: h.URL, Err: err}
+	}
+
+	out, err := io.ReadAll(resp.Body)
+	if cerr := resp.Body.Close(); cerr != nil {
+		h.Log.Errorw("failed to close http body", zap.Error(cerr))
+	}
+
+	if resp.StatusCode > 299 {
+		return &HTTPError{URL: h.URL, StatusCode: resp.StatusCode, Err: fmt.Errorf("unexpected status %q", resp.Status)}
+	}
+	if err != nil {
+		return &HTTPError{URL: h.URL, StatusCode: resp.StatusCode, Err: fmt.Errorf("failed to read body: %w", err)}
+	}
+
+	if !h.JSON {
+		h.Emit(out)
+
+		return nil
+	}
+
+	var body interface{}
+	if err := json.Unmarshal(out, &body); err != nil {
+		return &HTTPError{URL: h.URL, StatusCode: resp.StatusCode, Err: fmt.Errorf("malformed JSON body: %w", err)}
+	}
+	h.Emit(body)
+
+	return nil
 }
 
 // Stop stops the watch.
@@ -2,6 +2,13 @@ package watch
 
 import (
 	"context"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -36,3 +43,162 @@ func TestHTTPWatch_URLUpdates(t *testing.T) {
 
 	require.Equal(t, "newurl", w.URL)
 }
+
+func startHTTPWatch(t *testing.T, conf HTTPWatchConf) chan interface{} {
+	t.Helper()
+
+	if conf.Interval == 0 {
+		conf.Interval = 10 * time.Millisecond
+	}
+	w := NewHTTPWatch(conf)
+
+	ch := make(chan interface{}, 100)
+	w.Subscribe(ch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	StartWithContext(ctx, w)
+	t.Cleanup(func() {
+		cancel()
+		w.Wait()
+	})
+
+	return ch
+}
+
+func nextHTTPMessage(t *testing.T, ch chan interface{}) interface{} {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(3 * time.Second):
+		t.Fatal("no message")
+	}
+
+	return nil
+}
+
+func requireHTTPError(t *testing.T, ch chan interface{}, statusCode int) *HTTPError {
+	t.Helper()
+
+	msg := nextHTTPMessage(t, ch)
+	herr, ok := msg.(*HTTPError)
+	require.True(t, ok, "expected an *HTTPError, got %T %v", msg, msg)
+	require.Equal(t, statusCode, herr.StatusCode)
+
+	return herr
+}
+
+func TestHTTPWatch_JSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("X-Algo-API-Token") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+		w.Write([]byte(`{"last-round": 23456789, "catchup-time": 0, "stopped-at-unsupported-round": false}`))
+	}))
+	defer ts.Close()
+
+	ch := startHTTPWatch(t, HTTPWatchConf{
+		URL:         ts.URL + "/v2/status",
+		Headers:     map[string]string{"X-Algo-API-Token": "token"},
+		BearerToken: "secret",
+		JSON:        true,
+	})
+
+	require.Equal(t, map[string]interface{}{
+		"last-round":                   float64(23456789),
+		"catchup-time":                 float64(0),
+		"stopped-at-unsupported-round": false,
+	}, nextHTTPMessage(t, ch))
+}
+
+func TestHTTPWatch_Raw(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("node_up 1\n"))
+	}))
+	defer ts.Close()
+
+	ch := startHTTPWatch(t, HTTPWatchConf{URL: ts.URL})
+
+	require.Equal(t, []byte("node_up 1\n"), nextHTTPMessage(t, ch))
+}
+
+func TestHTTPWatch_ServerError(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+		w.Write([]byte(`{"last-round": 1}`))
+	}))
+	defer ts.Close()
+
+	ch := startHTTPWatch(t, HTTPWatchConf{URL: ts.URL, JSON: true, MaxBackoff: 50 * time.Millisecond})
+
+	// retried until the server recovers
+	for i := 0; i < 3; i++ {
+		herr := requireHTTPError(t, ch, http.StatusInternalServerError)
+		require.Equal(t, ts.URL, herr.URL)
+	}
+	require.Equal(t, map[string]interface{}{"last-round": float64(1)}, nextHTTPMessage(t, ch))
+}
+
+func TestHTTPWatch_Timeout(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-done:
+		}
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	ch := startHTTPWatch(t, HTTPWatchConf{URL: ts.URL, Timeout: 20 * time.Millisecond})
+
+	herr := requireHTTPError(t, ch, 0)
+	require.True(t, errors.Is(herr, context.DeadlineExceeded), herr.Error())
+}
+
+func TestHTTPWatch_MalformedJSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"last-round": `))
+	}))
+	defer ts.Close()
+
+	ch := startHTTPWatch(t, HTTPWatchConf{URL: ts.URL, JSON: true})
+
+	herr := requireHTTPError(t, ch, http.StatusOK)
+	require.Contains(t, herr.Error(), "malformed JSON body")
+}
+
+func TestHTTPWatch_TLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0o600))
+
+	// the self-signed certificate isn't trusted by default
+	ch := startHTTPWatch(t, HTTPWatchConf{URL: ts.URL, JSON: true})
+	requireHTTPError(t, ch, 0)
+
+	caConf, err := NewHTTPTLSConfig(caFile, false)
+	require.NoError(t, err)
+	ch = startHTTPWatch(t, HTTPWatchConf{URL: ts.URL, JSON: true, TLSConfig: caConf})
+	require.Equal(t, map[string]interface{}{}, nextHTTPMessage(t, ch))
+
+	skipConf, err := NewHTTPTLSConfig("", true)
+	require.NoError(t, err)
+	ch = startHTTPWatch(t, HTTPWatchConf{URL: ts.URL, JSON: true, TLSConfig: skipConf})
+	require.Equal(t, map[string]interface{}{}, nextHTTPMessage(t, ch))
+
+	_, err = NewHTTPTLSConfig(filepath.Join(t.TempDir(), "missing.pem"), false)
+	require.Error(t, err)
+}
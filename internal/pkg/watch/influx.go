@@ -82,6 +82,8 @@ func NewInfluxExporterWatch(conf InfluxExporterWatchConf) *InfluxExporterWatch {
 		httpwg:                  &sync.WaitGroup{},
 	}
 
+	w.Name = "influx"
+
 	ctx := context.Background()
 	collector := newInfluxDBCollector(ctx, w.wg)
 	registry := prometheus.NewRegistry()
@@ -119,6 +121,7 @@ func (w *InfluxExporterWatch) StartUnsafe() {
 				points, err := models.ParsePointsWithPrecision(wr.body, timesync.Now().UTC(), wr.precision)
 				if err != nil {
 					w.Log.Errorw("error parsing influx line", zap.Error(err))
+					w.ReportError(err)
 				}
 				if len(points) > 0 {
 					w.Log.Infow("influx points parsed", "len", len(points))
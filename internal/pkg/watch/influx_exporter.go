@@ -15,12 +15,11 @@ package watch
 
 import (
 	"context"
-	"sort"
-	"strings"
 	"sync"
 	"time"
 
 	"agent/api/v1/model"
+	"agent/internal/pkg/labels"
 
 	"github.com/influxdata/influxdb/models"
 	"github.com/prometheus/client_golang/prometheus"
@@ -38,7 +37,7 @@ var lastPush = prometheus.NewGauge(
 )
 
 type influxDBCollector struct {
-	samples map[string]*influxDBSample
+	samples map[uint64]*influxDBSample
 	mu      sync.Mutex
 	ch      chan *influxDBSample
 }
@@ -46,7 +45,7 @@ type influxDBCollector struct {
 func newInfluxDBCollector(ctx context.Context, wg *sync.WaitGroup) *influxDBCollector {
 	c := &influxDBCollector{
 		ch:      make(chan *influxDBSample, 1000),
-		samples: map[string]*influxDBSample{},
+		samples: map[uint64]*influxDBSample{},
 	}
 	go c.processSamples(ctx, wg)
 	return c
@@ -108,7 +107,7 @@ func (c *influxDBCollector) Collect(ch chan<- prometheus.Metric) {
 		metric := prometheus.NewMetricWithTimestamp(
 			sample.Timestamp,
 			prometheus.MustNewConstMetric(
-				prometheus.NewDesc(sample.Name, promDesc, []string{}, sample.Labels),
+				prometheus.NewDesc(sample.Name, promDesc, []string{}, sample.Labels.Map()),
 				prometheus.UntypedValue,
 				sample.Value,
 			),
@@ -123,9 +122,10 @@ func (c *influxDBCollector) Describe(ch chan<- *prometheus.Desc) {
 }
 
 type influxDBSample struct {
-	ID        string
+	// ID hash of the sample name and labels.
+	ID        uint64
 	Name      string
-	Labels    map[string]string
+	Labels    labels.Labels
 	Value     float64
 	Timestamp time.Time
 }
@@ -183,33 +183,25 @@ func parsePointsToSamples(points []models.Point) []influxDBSample {
 			}
 
 			ReplaceInvalidChars(&name)
-			sample := influxDBSample{
-				Name:      name,
-				Timestamp: s.Time(),
-				Value:     value,
-				Labels:    map[string]string{},
-			}
-			for _, v := range s.Tags() {
+			tags := s.Tags()
+			lbls := make([]labels.Label, 0, len(tags))
+			for _, v := range tags {
 				key := string(v.Key)
-				if key == "__name__" {
+				if key == labels.MetricName {
 					continue
 				}
 				ReplaceInvalidChars(&key)
-				sample.Labels[key] = string(v.Value)
+				lbls = append(lbls, labels.Label{Name: key, Value: string(v.Value)})
 			}
 
-			// Calculate a consistent unique ID for the sample.
-			labelnames := make([]string, 0, len(sample.Labels))
-			for k := range sample.Labels {
-				labelnames = append(labelnames, k)
-			}
-			sort.Strings(labelnames)
-			parts := make([]string, 0, len(sample.Labels)*2+1)
-			parts = append(parts, name)
-			for _, l := range labelnames {
-				parts = append(parts, l, sample.Labels[l])
+			sample := influxDBSample{
+				Name:      name,
+				Timestamp: s.Time(),
+				Value:     value,
+				Labels:    labels.New(lbls...),
 			}
-			sample.ID = strings.Join(parts, ".")
+			// Calculate a consistent unique ID for the sample.
+			sample.ID = sample.Labels.WithExtra(labels.Label{Name: labels.MetricName, Value: name}).Hash()
 			samples = append(samples, sample)
 		}
 	}
@@ -225,13 +217,10 @@ func parseSamplesToOpenMetrics(samples []influxDBSample) []*model.MetricFamily {
 		mf.Type = model.MetricType_UNKNOWN
 
 		metric := &model.Metric{}
-		metric.Labels = make([]*model.Label, len(smpl.Labels))
-		lblIdx := 0
-		for k, v := range smpl.Labels {
-			lbl := &model.Label{Name: k, Value: v}
-			metric.Labels[lblIdx] = lbl
-			lblIdx++
-		}
+		metric.Labels = make([]*model.Label, 0, smpl.Labels.Len())
+		smpl.Labels.Range(func(l labels.Label) {
+			metric.Labels = append(metric.Labels, &model.Label{Name: l.Name, Value: l.Value})
+		})
 		metric.MetricPoints = []*model.MetricPoint{
 			{
 				Timestamp: timestamppb.New(smpl.Timestamp),
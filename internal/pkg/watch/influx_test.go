@@ -14,6 +14,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/influxdata/influxdb/models"
 	"github.com/ory/dockertest"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
@@ -277,3 +278,24 @@ func TestNewInfluxExporterWatch_UpstreamDisabled(t *testing.T) {
 	expContains := []byte(fmt.Sprintf("# HELP testmetric_field1 Solana InfluxDB metric\n# TYPE testmetric_field1 untyped\ntestmetric_field1 42 %d\n", ts.UnixMilli()))
 	require.Contains(t, string(gotPef), string(expContains))
 }
+
+func TestParsePointsToSamples_StableIdentity(t *testing.T) {
+	points, err := models.ParsePointsString(
+		"cpu,host=a,region=eu usage=1 1000000000\n" +
+			"cpu,region=eu,host=a usage=2 2000000000\n" +
+			"cpu,host=b,region=eu usage=3 3000000000\n")
+	require.Nil(t, err)
+
+	samples := parsePointsToSamples(points)
+	require.Len(t, samples, 3)
+	require.Equal(t, samples[0].ID, samples[1].ID)
+	require.NotEqual(t, samples[0].ID, samples[2].ID)
+
+	for _, mf := range parseSamplesToOpenMetrics(samples) {
+		require.Equal(t, "cpu_usage", mf.Name)
+		lbls := mf.Metrics[0].Labels
+		require.Len(t, lbls, 2)
+		require.Equal(t, "host", lbls[0].Name)
+		require.Equal(t, "region", lbls[1].Name)
+	}
+}
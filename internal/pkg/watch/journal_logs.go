@@ -11,6 +11,9 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !nosystemd
+// +build !nosystemd
+
 package watch
 
 import (
@@ -61,6 +64,7 @@ type JournaldLogWatch struct {
 func NewJournaldLogWatch(conf JournaldLogWatchConf) (*JournaldLogWatch, error) {
 	w := new(JournaldLogWatch)
 	w.Watch = NewWatch()
+	w.Name = "journal_logs"
 	w.JournaldLogWatchConf = conf
 	w.watchCh = make(chan interface{}, 1)
 	w.logMissing = true
@@ -207,6 +211,7 @@ func (w *JournaldLogWatch) StartUnsafe() {
 			jsonMap, err := w.parseJSON(v)
 			if err != nil {
 				w.Log.Warnw("error parsing log line:", zap.Error(err), "msg", string(v))
+				w.ReportError(err)
 
 				continue
 			}
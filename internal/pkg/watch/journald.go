@@ -0,0 +1,564 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux && !nosystemd
+// +build linux,!nosystemd
+
+package watch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"agent/internal/pkg/fsutil"
+
+	"github.com/cenkalti/backoff"
+	"github.com/coreos/go-systemd/v22/sdjournal"
+
+	"go.uber.org/zap"
+)
+
+// ErrJournaldWatchConf journald watch configuration error.
+var ErrJournaldWatchConf = errors.New("journald watch configuration error")
+
+const (
+	defaultJournaldRetryIntv  = time.Second
+	defaultJournaldMaxBackoff = 30 * time.Second
+
+	// journalCursorPersistInterval how often the cursor is persisted while
+	// entries are read, it is persisted on stop too.
+	journalCursorPersistInterval = time.Second
+
+	// sdJournalWaitInterval how long the sd-journal backend waits for new
+	// entries before checking whether it is closed.
+	sdJournalWaitInterval = 250 * time.Millisecond
+
+	journalCursorField     = "__CURSOR"
+	journalRealtimeField   = "__REALTIME_TIMESTAMP"
+	journalMessageField    = "MESSAGE"
+	journalPriorityField   = "PRIORITY"
+	journalUnitField       = "_SYSTEMD_UNIT"
+	journalIdentifierField = "SYSLOG_IDENTIFIER"
+)
+
+// JournalFilter the journal entries to follow, those of any of the units or
+// syslog identifiers.
+type JournalFilter struct {
+	Units       []string
+	Identifiers []string
+}
+
+// matches the journal matches of the filter, i.e. _SYSTEMD_UNIT=algod.service.
+func (f JournalFilter) matches() []string {
+	var matches []string
+	for _, unit := range f.Units {
+		matches = append(matches, journalUnitField+"="+unit)
+	}
+	for _, id := range f.Identifiers {
+		matches = append(matches, journalIdentifierField+"="+id)
+	}
+
+	return matches
+}
+
+// JournalReader follows the journal entries, as opened by a JournalBackend.
+type JournalReader interface {
+	// Next blocks until the next entry and returns its fields, the
+	// __CURSOR and __REALTIME_TIMESTAMP ones included.
+	Next() (map[string]string, error)
+	Close() error
+}
+
+// JournalBackend opens the journal for a JournaldWatch.
+type JournalBackend interface {
+	// Open follows the entries matching filter, the ones after cursor if
+	// set, or the new ones otherwise. The reader fails once ctx is done.
+	Open(ctx context.Context, filter JournalFilter, cursor string) (JournalReader, error)
+}
+
+// JournalEntry a journal entry, emitted by JournaldWatch.
+type JournalEntry struct {
+	Message string
+
+	// Priority syslog priority, 0 (emerg) to 7 (debug), -1 if unset.
+	Priority int
+
+	Timestamp time.Time
+
+	// Fields the fields selected by JournaldWatchConf.Fields, if present.
+	Fields map[string]string
+
+	Cursor string
+}
+
+// JournaldWatchConf JournaldWatch configuration struct.
+type JournaldWatchConf struct {
+	// Units and Identifiers the systemd units, i.e. algod.service, and
+	// syslog identifiers whose entries are followed.
+	Units       []string
+	Identifiers []string
+
+	// Fields journal fields added to the entries, i.e. _PID.
+	Fields []string
+
+	// CursorPath file the cursor of the last entry is persisted to, so
+	// that the following entries are emitted after a restart. The new
+	// entries only are followed if empty.
+	CursorPath string
+
+	// Backend defaults to a JournalctlBackend.
+	Backend JournalBackend
+
+	// RetryIntv delay before the journal is reopened after a failure, it
+	// doubles on every failed attempt up to MaxBackoff.
+	RetryIntv  time.Duration
+	MaxBackoff time.Duration
+}
+
+// JournaldWatch implements Watcher interface.
+// Follows the systemd journal entries of units or syslog identifiers and
+// emits each of them as a JournalEntry, resuming from the persisted cursor.
+type JournaldWatch struct {
+	JournaldWatchConf
+	Watch
+
+	// cursor of the last entry, owned by the follow goroutine while
+	// running.
+	cursor    string
+	persisted string
+}
+
+// NewJournaldWatch JournaldWatch constructor, returns an error if no unit
+// nor identifier is configured. Restores the cursor persisted to
+// conf.CursorPath, if any.
+func NewJournaldWatch(conf JournaldWatchConf) (*JournaldWatch, error) {
+	if len(conf.Units) == 0 && len(conf.Identifiers) == 0 {
+		return nil, fmt.Errorf("%w: unit or identifier is required", ErrJournaldWatchConf)
+	}
+
+	w := new(JournaldWatch)
+	w.Watch = NewWatch()
+	w.Name = "journald"
+	w.JournaldWatchConf = conf
+	w.Log = w.Log.With("units", conf.Units, "identifiers", conf.Identifiers)
+
+	if w.Backend == nil {
+		w.Backend = &JournalctlBackend{}
+	}
+	if w.RetryIntv < 1 {
+		w.RetryIntv = defaultJournaldRetryIntv
+	}
+	if w.MaxBackoff < 1 {
+		w.MaxBackoff = defaultJournaldMaxBackoff
+	}
+	if w.MaxBackoff < w.RetryIntv {
+		w.MaxBackoff = w.RetryIntv
+	}
+
+	if w.CursorPath != "" {
+		content, err := ioutil.ReadFile(w.CursorPath)
+		switch {
+		case err == nil:
+			w.cursor = strings.TrimSpace(string(content))
+			w.persisted = w.cursor
+		case !os.IsNotExist(err):
+			w.Log.Warnw("failed to load journal cursor, following new entries", "cursor_path", w.CursorPath, zap.Error(err))
+		}
+	}
+
+	return w, nil
+}
+
+// StartUnsafe starts the goroutine following the journal.
+func (w *JournaldWatch) StartUnsafe() {
+	w.Watch.StartUnsafe()
+
+	w.wg.Add(1)
+	go w.followLoop(w.runContext(), w.StopKey)
+}
+
+// followLoop (re)opens the journal until ctx is done or stop, the StopKey
+// of the run it was started for, is closed.
+func (w *JournaldWatch) followLoop(ctx context.Context, stop <-chan bool) {
+	defer w.wg.Done()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	backof := backoff.NewExponentialBackOff()
+	backof.InitialInterval = w.RetryIntv
+	backof.MaxInterval = w.MaxBackoff
+	backof.MaxElapsedTime = 0 // never expire
+	backof.Reset()
+
+	for {
+		err := w.follow(ctx, backof)
+		if ctx.Err() != nil {
+			return
+		}
+
+		retry := backof.NextBackOff()
+		w.Log.Warnw("journal follow failed, reopening", zap.Error(err), "retry_timer", retry)
+		w.ReportError(err)
+
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// follow opens the journal and emits its entries until it fails.
+func (w *JournaldWatch) follow(ctx context.Context, backof backoff.BackOff) error {
+	filter := JournalFilter{Units: w.Units, Identifiers: w.Identifiers}
+	reader, err := w.Backend.Open(ctx, filter, w.cursor)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	defer w.persist()
+
+	lastPersist := time.Now()
+	for {
+		fields, err := reader.Next()
+		if err != nil {
+			return err
+		}
+		backof.Reset()
+
+		entry := w.newEntry(fields)
+		if entry.Cursor != "" {
+			w.cursor = entry.Cursor
+		}
+		w.Emit(entry)
+
+		if time.Since(lastPersist) > journalCursorPersistInterval {
+			w.persist()
+			lastPersist = time.Now()
+		}
+	}
+}
+
+func (w *JournaldWatch) newEntry(fields map[string]string) JournalEntry {
+	entry := JournalEntry{
+		Message:  fields[journalMessageField],
+		Priority: -1,
+		Cursor:   fields[journalCursorField],
+	}
+
+	if p, err := strconv.Atoi(fields[journalPriorityField]); err == nil {
+		entry.Priority = p
+	}
+
+	if us, err := strconv.ParseInt(fields[journalRealtimeField], 10, 64); err == nil {
+		entry.Timestamp = time.UnixMicro(us)
+	}
+
+	for _, name := range w.Fields {
+		if v, ok := fields[name]; ok {
+			if entry.Fields == nil {
+				entry.Fields = map[string]string{}
+			}
+			entry.Fields[name] = v
+		}
+	}
+
+	return entry
+}
+
+// persist writes the cursor to CursorPath, it is a no-op if it didn't
+// change.
+func (w *JournaldWatch) persist() {
+	if w.CursorPath == "" || w.cursor == w.persisted {
+		return
+	}
+
+	if err := fsutil.WriteFileAtomic(w.CursorPath, []byte(w.cursor+"\n")); err != nil {
+		w.Log.Warnw("failed to persist journal cursor", "cursor_path", w.CursorPath, zap.Error(err))
+		w.ReportError(err)
+
+		return
+	}
+	w.persisted = w.cursor
+}
+
+// *** JournalctlBackend ***
+
+// JournalctlBackend implements JournalBackend with a journalctl --follow
+// subprocess, decoding its JSON output.
+type JournalctlBackend struct {
+	// Path of the journalctl binary, looked up in PATH if empty.
+	Path string
+}
+
+// Open starts journalctl, it is killed once ctx is done or the reader is
+// closed.
+func (b *JournalctlBackend) Open(ctx context.Context, filter JournalFilter, cursor string) (JournalReader, error) {
+	path := b.Path
+	if path == "" {
+		path = "journalctl"
+	}
+
+	args := []string{"--follow", "--output=json", "--all", "--no-pager"}
+	if cursor != "" {
+		args = append(args, "--after-cursor="+cursor)
+	} else {
+		args = append(args, "--lines=0")
+	}
+	for i, match := range filter.matches() {
+		if i > 0 {
+			// disjunction
+			args = append(args, "+")
+		}
+		args = append(args, match)
+	}
+
+	cmd := exec.Command(path, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &tailBuffer{max: execStderrTailBytes}
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	r := &journalctlReader{
+		cmd:    cmd,
+		stdout: bufio.NewReader(stdout),
+		stderr: stderr,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.Close()
+		case <-r.done:
+		}
+	}()
+
+	return r, nil
+}
+
+type journalctlReader struct {
+	cmd    *exec.Cmd
+	stdout *bufio.Reader
+	stderr *tailBuffer
+
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+func (r *journalctlReader) Next() (map[string]string, error) {
+	for {
+		line, err := r.stdout.ReadBytes('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				// the stderr tail is complete once exited
+				r.Close()
+				err = fmt.Errorf("journalctl exited: %v: %s", r.err, strings.TrimSpace(string(r.stderr.buf)))
+			}
+
+			return nil, err
+		}
+
+		fields, err := parseJournalJSON(line)
+		if err != nil {
+			zap.S().Warnw("failed to decode journalctl entry, skipping it", zap.Error(err))
+
+			continue
+		}
+
+		return fields, nil
+	}
+}
+
+// Close kills journalctl and waits for it to exit.
+func (r *journalctlReader) Close() error {
+	r.once.Do(func() {
+		close(r.done)
+		_ = killProcessGroup(r.cmd)
+		r.err = r.cmd.Wait()
+	})
+
+	return nil
+}
+
+// parseJournalJSON decodes a journalctl JSON entry. The values are strings,
+// byte arrays if not printable, or arrays of them if the field has several
+// values, in which case the first one is kept. Null values, too large ones,
+// are skipped.
+func parseJournalJSON(line []byte) (map[string]string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string, len(raw))
+	for name, value := range raw {
+		if v, ok := journalFieldValue(value); ok {
+			fields[name] = v
+		}
+	}
+
+	return fields, nil
+}
+
+func journalFieldValue(value json.RawMessage) (string, bool) {
+	if string(value) == "null" {
+		return "", false
+	}
+
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		return s, true
+	}
+
+	var b []byte
+	var ints []int
+	if err := json.Unmarshal(value, &ints); err == nil && len(ints) > 0 {
+		for _, i := range ints {
+			b = append(b, byte(i))
+		}
+
+		return string(b), true
+	}
+
+	var values []json.RawMessage
+	if err := json.Unmarshal(value, &values); err == nil && len(values) > 0 {
+		return journalFieldValue(values[0])
+	}
+
+	return "", false
+}
+
+// *** SDJournalBackend ***
+
+// SDJournalBackend implements JournalBackend with the sd-journal library,
+// which is loaded at runtime.
+type SDJournalBackend struct{}
+
+// Open opens the journal.
+func (SDJournalBackend) Open(ctx context.Context, filter JournalFilter, cursor string) (JournalReader, error) {
+	j, err := sdjournal.NewJournal()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &sdJournalReader{ctx: ctx, j: j}
+	if err := r.seek(filter, cursor); err != nil {
+		j.Close()
+
+		return nil, err
+	}
+
+	return r, nil
+}
+
+type sdJournalReader struct {
+	ctx context.Context
+	j   *sdjournal.Journal
+}
+
+func (r *sdJournalReader) seek(filter JournalFilter, cursor string) error {
+	for i, match := range filter.matches() {
+		if i > 0 {
+			if err := r.j.AddDisjunction(); err != nil {
+				return fmt.Errorf("journal match OR error: %w", err)
+			}
+		}
+		if err := r.j.AddMatch(match); err != nil {
+			return fmt.Errorf("journal add match error: %w", err)
+		}
+	}
+
+	if cursor == "" {
+		if err := r.j.SeekTail(); err != nil {
+			return fmt.Errorf("journal seek tail error: %w", err)
+		}
+		// on the last entry, the next one is new
+		_, err := r.j.Previous()
+
+		return err
+	}
+
+	if err := r.j.SeekCursor(cursor); err != nil {
+		return fmt.Errorf("journal seek cursor error: %w", err)
+	}
+	// on the cursor entry, already emitted
+	_, err := r.j.Next()
+
+	return err
+}
+
+func (r *sdJournalReader) Next() (map[string]string, error) {
+	for {
+		if err := r.ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		n, err := r.j.Next()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			r.j.Wait(sdJournalWaitInterval)
+
+			continue
+		}
+
+		entry, err := r.j.GetEntry()
+		if err != nil {
+			return nil, err
+		}
+
+		fields := make(map[string]string, len(entry.Fields)+2)
+		for k, v := range entry.Fields {
+			fields[k] = v
+		}
+		fields[journalCursorField] = entry.Cursor
+		fields[journalRealtimeField] = strconv.FormatUint(entry.RealtimeTimestamp, 10)
+
+		return fields, nil
+	}
+}
+
+func (r *sdJournalReader) Close() error {
+	return r.j.Close()
+}
@@ -0,0 +1,194 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux && !nosystemd
+// +build linux,!nosystemd
+
+package watch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeJournalctl returns a journalctl stand-in printing the canned
+// testdata/journalctl.json entries and following forever, and the file its
+// arguments are recorded to, one per line.
+func newFakeJournalctl(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	canned, err := filepath.Abs("testdata/journalctl.json")
+	require.NoError(t, err)
+
+	argsPath := filepath.Join(dir, "args")
+	script := "#!/bin/sh\n" +
+		`printf "%s\n" "$@" > ` + argsPath + "\n" +
+		"cat " + canned + "\n" +
+		"exec sleep 60\n"
+
+	path := filepath.Join(dir, "journalctl")
+	require.NoError(t, ioutil.WriteFile(path, []byte(script), 0o755))
+
+	return path, argsPath
+}
+
+func startJournaldWatch(t *testing.T, conf JournaldWatchConf) (*JournaldWatch, chan interface{}) {
+	t.Helper()
+
+	w, err := NewJournaldWatch(conf)
+	require.NoError(t, err)
+
+	ch := make(chan interface{}, 100)
+	w.Subscribe(ch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	StartWithContext(ctx, w)
+	t.Cleanup(func() {
+		cancel()
+		w.Wait()
+	})
+
+	return w, ch
+}
+
+func requireJournalEntry(t *testing.T, ch chan interface{}) JournalEntry {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		entry, ok := msg.(JournalEntry)
+		require.True(t, ok, "unexpected message %v", msg)
+
+		return entry
+	case <-time.After(5 * time.Second):
+		t.Fatal("no journal entry")
+	}
+
+	return JournalEntry{}
+}
+
+func requireJournalctlArgs(t *testing.T, argsPath string) []string {
+	t.Helper()
+
+	var args []string
+	require.Eventually(t, func() bool {
+		content, err := ioutil.ReadFile(argsPath)
+		if err != nil || len(content) == 0 {
+			return false
+		}
+		args = strings.Split(strings.TrimSpace(string(content)), "\n")
+
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return args
+}
+
+func TestNewJournaldWatch_Invalid(t *testing.T) {
+	_, err := NewJournaldWatch(JournaldWatchConf{Fields: []string{"_PID"}})
+	require.ErrorIs(t, err, ErrJournaldWatchConf)
+}
+
+func TestJournaldWatch_Journalctl(t *testing.T) {
+	path, argsPath := newFakeJournalctl(t)
+	cursorPath := filepath.Join(t.TempDir(), "journal.cursor")
+
+	conf := JournaldWatchConf{
+		Units:       []string{"algod.service"},
+		Identifiers: []string{"goal"},
+		Fields:      []string{"_PID", "_CMDLINE", "_HOSTNAME"},
+		CursorPath:  cursorPath,
+		Backend:     &JournalctlBackend{Path: path},
+	}
+	w, ch := startJournaldWatch(t, conf)
+
+	require.Equal(t, JournalEntry{
+		Message:   "catchup complete, round 23456789",
+		Priority:  6,
+		Timestamp: time.Date(2022, 10, 17, 0, 0, 0, 0, time.UTC).Local(),
+		Fields:    map[string]string{"_PID": "4242"},
+		Cursor:    "s=1;i=100",
+	}, requireJournalEntry(t, ch))
+
+	// not printable, as a byte array
+	entry := requireJournalEntry(t, ch)
+	require.Equal(t, "peer \x1b[31mlost\x1b[0m", entry.Message)
+	require.Equal(t, 3, entry.Priority)
+	require.Equal(t, time.Date(2022, 10, 17, 0, 0, 1, 500e6, time.UTC).Local(), entry.Timestamp)
+
+	// the malformed one is skipped, the first of several values is kept
+	require.Equal(t, JournalEntry{
+		Message:   "node status ok",
+		Priority:  -1,
+		Timestamp: time.Date(2022, 10, 17, 0, 0, 2, 0, time.UTC).Local(),
+		Fields:    map[string]string{"_PID": "4243"},
+		Cursor:    "s=1;i=103",
+	}, requireJournalEntry(t, ch))
+
+	require.Equal(t, []string{
+		"--follow", "--output=json", "--all", "--no-pager", "--lines=0",
+		"_SYSTEMD_UNIT=algod.service", "+", "SYSLOG_IDENTIFIER=goal",
+	}, requireJournalctlArgs(t, argsPath))
+
+	w.Stop()
+	w.Wait()
+
+	content, err := ioutil.ReadFile(cursorPath)
+	require.NoError(t, err)
+	require.Equal(t, "s=1;i=103\n", string(content))
+
+	// resumed after the persisted cursor
+	require.NoError(t, os.Remove(argsPath))
+	startJournaldWatch(t, conf)
+
+	args := requireJournalctlArgs(t, argsPath)
+	require.Contains(t, args, "--after-cursor=s=1;i=103")
+	require.NotContains(t, args, "--lines=0")
+}
+
+func TestJournaldWatch_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	countPath := filepath.Join(dir, "count")
+	script := "#!/bin/sh\n" +
+		"echo run >> " + countPath + "\n" +
+		`echo '{"__CURSOR":"c","MESSAGE":"hello"}'` + "\n" +
+		"echo 'Failed to open journal' >&2\n" +
+		"exit 1\n"
+	path := filepath.Join(dir, "journalctl")
+	require.NoError(t, ioutil.WriteFile(path, []byte(script), 0o755))
+
+	_, ch := startJournaldWatch(t, JournaldWatchConf{
+		Units:     []string{"algod.service"},
+		Backend:   &JournalctlBackend{Path: path},
+		RetryIntv: 10 * time.Millisecond,
+	})
+
+	for i := 0; i < 3; i++ {
+		require.Equal(t, "hello", requireJournalEntry(t, ch).Message)
+	}
+}
+
+func TestParseJournalJSON(t *testing.T) {
+	fields, err := parseJournalJSON([]byte(`{"MESSAGE":[104,105],"A":"a","B":[[1,2],"b"],"C":null,"D":[]}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"MESSAGE": "hi", "A": "a", "B": "\x01\x02"}, fields)
+
+	_, err = parseJournalJSON([]byte(`{"MESSAGE":`))
+	require.Error(t, err)
+}
@@ -0,0 +1,322 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"agent/api/v1/model"
+	"agent/internal/pkg/global"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// StaleLabel label added to metrics served from the last-known-good cache.
+	StaleLabel = "stale"
+
+	// StaleAgeSuffix suffix of the companion metric exposing how long ago a
+	// cached metric family was last observed.
+	StaleAgeSuffix = "_stale_age_seconds"
+)
+
+func init() {
+	global.RegisterStateMigration(global.StateMigration{
+		Component: "last_known_good",
+		Version:   1,
+		Migrate: func(dir string) error {
+			legacy, err := filepath.Glob(filepath.Join(filepath.Dir(dir), "last_known_good_*.json"))
+			if err != nil {
+				return err
+			}
+
+			for _, path := range legacy {
+				if err := global.ImportLegacyStateFile(dir, filepath.Base(path)); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	})
+
+	// the cached gauges are replayed on startup, a clone must not serve
+	// the ones of the host it was cloned from
+	global.RegisterCloneReset(global.CloneReset{
+		Component:  "last_known_good",
+		Patterns:   []string{"last_known_good_*.json"},
+		Quarantine: true,
+	})
+}
+
+// LastKnownGoodConf LastKnownGood configuration.
+type LastKnownGoodConf struct {
+	// Metrics names of the gauge metric families to cache.
+	Metrics []string
+
+	// MaxStale how long a cached metric family is served after it was last
+	// observed.
+	MaxStale time.Duration
+
+	// Path file the cache is persisted to, not persisted if empty.
+	Path string
+}
+
+// LastKnownGood caches the last observed value of a set of node gauges, so
+// they can be served while the node is down. Counters are never cached,
+// since replaying a counter value breaks rate computations.
+type LastKnownGood struct {
+	LastKnownGoodConf
+
+	mu       sync.Mutex
+	metrics  map[string]struct{}
+	families map[string]*lastKnownGoodFamily
+	warned   map[string]struct{}
+	dirty    bool
+}
+
+type lastKnownGoodFamily struct {
+	family     *model.MetricFamily
+	observedAt time.Time
+}
+
+// lastKnownGoodFile on-disk representation of the cache.
+type lastKnownGoodFile struct {
+	Families map[string]lastKnownGoodFileEntry `json:"families"`
+}
+
+type lastKnownGoodFileEntry struct {
+	Family     json.RawMessage `json:"family"`
+	ObservedAt time.Time       `json:"observed_at"`
+}
+
+// NewLastKnownGood LastKnownGood constructor. Loads any cache previously
+// persisted to conf.Path.
+func NewLastKnownGood(conf LastKnownGoodConf) *LastKnownGood {
+	l := &LastKnownGood{
+		LastKnownGoodConf: conf,
+		metrics:           make(map[string]struct{}, len(conf.Metrics)),
+		families:          map[string]*lastKnownGoodFamily{},
+		warned:            map[string]struct{}{},
+	}
+
+	for _, name := range conf.Metrics {
+		l.metrics[name] = struct{}{}
+	}
+
+	if err := l.load(); err != nil {
+		zap.S().Warnw("failed to load last-known-good cache, starting empty", "path", l.Path, zap.Error(err))
+	}
+
+	return l
+}
+
+// Observe caches mf if it is one of the configured gauges.
+func (l *LastKnownGood) Observe(mf *model.MetricFamily, t time.Time) {
+	if _, ok := l.metrics[mf.Name]; !ok {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if mf.Type != model.MetricType_GAUGE {
+		if _, ok := l.warned[mf.Name]; !ok {
+			zap.S().Warnw("last-known-good cache only applies to gauges, ignoring metric", "metric", mf.Name, "type", mf.Type.String())
+			l.warned[mf.Name] = struct{}{}
+		}
+
+		return
+	}
+
+	l.families[mf.Name] = &lastKnownGoodFamily{
+		family:     proto.Clone(mf).(*model.MetricFamily),
+		observedAt: t,
+	}
+	l.dirty = true
+}
+
+// MemoryBytes returns the encoded size of the cached metric families,
+// implements global.MemoryReporter.
+func (l *LastKnownGood) MemoryBytes() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var total int64
+	for _, cached := range l.families {
+		total += int64(proto.Size(cached.family))
+	}
+
+	return total
+}
+
+// Stale returns the cached metric families observed within MaxStale of
+// now, labeled with stale="true" and timestamped now, each followed by a
+// companion gauge with its age in seconds. Expired families are evicted.
+func (l *LastKnownGood) Stale(now time.Time) []*model.MetricFamily {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var out []*model.MetricFamily
+	ts := timestamppb.New(now.UTC())
+	for name, cached := range l.families {
+		age := now.Sub(cached.observedAt)
+		if age > l.MaxStale {
+			delete(l.families, name)
+			l.dirty = true
+
+			continue
+		}
+
+		mf := proto.Clone(cached.family).(*model.MetricFamily)
+		for _, m := range mf.Metrics {
+			m.Labels = setStaleLabel(m.Labels)
+			for _, mp := range m.MetricPoints {
+				mp.Timestamp = ts
+			}
+		}
+
+		ageFamily := &model.MetricFamily{
+			Name: name + StaleAgeSuffix,
+			Type: model.MetricType_GAUGE,
+			Help: fmt.Sprintf("Seconds since %s was last observed.", name),
+			Metrics: []*model.Metric{{
+				MetricPoints: []*model.MetricPoint{{
+					Timestamp: ts,
+					Value: &model.MetricPoint_GaugeValue{
+						GaugeValue: &model.GaugeValue{
+							Value: &model.GaugeValue_DoubleValue{DoubleValue: age.Seconds()},
+						},
+					},
+				}},
+			}},
+		}
+
+		out = append(out, mf, ageFamily)
+	}
+
+	return out
+}
+
+func setStaleLabel(labels []*model.Label) []*model.Label {
+	for _, label := range labels {
+		if label.Name == StaleLabel {
+			label.Value = "true"
+
+			return labels
+		}
+	}
+
+	return append(labels, &model.Label{Name: StaleLabel, Value: "true"})
+}
+
+// Persist writes the cache to Path, replacing any existing file
+// atomically. It is a no-op if nothing changed since the last call.
+func (l *LastKnownGood) Persist() error {
+	if l.Path == "" {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.dirty {
+		return nil
+	}
+
+	file := lastKnownGoodFile{Families: make(map[string]lastKnownGoodFileEntry, len(l.families))}
+	for name, cached := range l.families {
+		b, err := protojson.Marshal(cached.family)
+		if err != nil {
+			return fmt.Errorf("error marshaling %s: %w", name, err)
+		}
+		file.Families[name] = lastKnownGoodFileEntry{Family: b, ObservedAt: cached.observedAt}
+	}
+
+	content, err := json.Marshal(file)
+	if err != nil {
+		return fmt.Errorf("error marshaling last-known-good cache: %w", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(l.Path), filepath.Base(l.Path))
+	if err != nil {
+		return fmt.Errorf("error persisting last-known-good cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error persisting last-known-good cache: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error persisting last-known-good cache: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), l.Path); err != nil {
+		return fmt.Errorf("error persisting last-known-good cache: %w", err)
+	}
+	l.dirty = false
+
+	return nil
+}
+
+func (l *LastKnownGood) load() error {
+	if l.Path == "" {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(l.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	var file lastKnownGoodFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return err
+	}
+
+	for name, entry := range file.Families {
+		// the configured set may have changed since the cache was persisted
+		if _, ok := l.metrics[name]; !ok {
+			continue
+		}
+
+		mf := &model.MetricFamily{}
+		if err := protojson.Unmarshal(entry.Family, mf); err != nil {
+			return fmt.Errorf("error unmarshaling %s: %w", name, err)
+		}
+
+		if mf.Type != model.MetricType_GAUGE {
+			continue
+		}
+
+		l.families[name] = &lastKnownGoodFamily{family: mf, observedAt: entry.ObservedAt}
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"agent/api/v1/model"
+	"agent/pkg/parse/openmetrics"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMetricFamily(name string, typ model.MetricType, value float64) *model.MetricFamily {
+	mp := &model.MetricPoint{}
+	switch typ {
+	case model.MetricType_COUNTER:
+		mp.Value = &model.MetricPoint_CounterValue{CounterValue: &model.CounterValue{
+			Total: &model.CounterValue_DoubleValue{DoubleValue: value},
+		}}
+	default:
+		mp.Value = &model.MetricPoint_GaugeValue{GaugeValue: &model.GaugeValue{
+			Value: &model.GaugeValue_DoubleValue{DoubleValue: value},
+		}}
+	}
+
+	return &model.MetricFamily{
+		Name: name,
+		Type: typ,
+		Metrics: []*model.Metric{{
+			Labels:       []*model.Label{{Name: "node", Value: "a"}},
+			MetricPoints: []*model.MetricPoint{mp},
+		}},
+	}
+}
+
+func TestLastKnownGood_Stale(t *testing.T) {
+	lkg := NewLastKnownGood(LastKnownGoodConf{
+		Metrics:  []string{"last_round", "blocks_total"},
+		MaxStale: time.Minute,
+	})
+
+	observed := time.Unix(1650000000, 0)
+	lkg.Observe(newTestMetricFamily("last_round", model.MetricType_GAUGE, 42), observed)
+	lkg.Observe(newTestMetricFamily("blocks_total", model.MetricType_COUNTER, 10), observed)
+	lkg.Observe(newTestMetricFamily("peers", model.MetricType_GAUGE, 3), observed)
+
+	now := observed.Add(30 * time.Second)
+	stale := lkg.Stale(now)
+	require.Len(t, stale, 2)
+
+	mf := stale[0]
+	require.Equal(t, "last_round", mf.Name)
+	require.Equal(t, []*model.Label{{Name: "node", Value: "a"}, {Name: StaleLabel, Value: "true"}}, mf.Metrics[0].Labels)
+	require.Equal(t, 42.0, mf.Metrics[0].MetricPoints[0].GetGaugeValue().GetDoubleValue())
+	require.Equal(t, now.UTC(), mf.Metrics[0].MetricPoints[0].Timestamp.AsTime())
+
+	age := stale[1]
+	require.Equal(t, "last_round"+StaleAgeSuffix, age.Name)
+	require.Equal(t, model.MetricType_GAUGE, age.Type)
+	require.Equal(t, 30.0, age.Metrics[0].MetricPoints[0].GetGaugeValue().GetDoubleValue())
+
+	// serving stale values doesn't alter the cache
+	require.Len(t, lkg.Stale(now)[0].Metrics[0].Labels, 2)
+
+	// expired past max stale
+	require.Empty(t, lkg.Stale(observed.Add(2*time.Minute)))
+	require.Empty(t, lkg.Stale(now))
+}
+
+func TestLastKnownGood_Persist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "last_known_good_0.json")
+	conf := LastKnownGoodConf{
+		Metrics:  []string{"last_round"},
+		MaxStale: time.Hour,
+		Path:     path,
+	}
+
+	observed := time.Unix(1650000000, 0).UTC()
+	lkg := NewLastKnownGood(conf)
+	lkg.Observe(newTestMetricFamily("last_round", model.MetricType_GAUGE, 42), observed)
+	require.NoError(t, lkg.Persist())
+
+	// agent restart
+	restarted := NewLastKnownGood(conf)
+	stale := restarted.Stale(observed.Add(time.Minute))
+	require.Len(t, stale, 2)
+	require.Equal(t, 42.0, stale[0].Metrics[0].MetricPoints[0].GetGaugeValue().GetDoubleValue())
+	require.Equal(t, 60.0, stale[1].Metrics[0].MetricPoints[0].GetGaugeValue().GetDoubleValue())
+
+	// no longer configured
+	conf.Metrics = []string{"peers"}
+	require.Empty(t, NewLastKnownGood(conf).Stale(observed.Add(time.Minute)))
+}
+
+// emittingHTTPWatch emits the configured bodies once started.
+type emittingHTTPWatch struct {
+	Watch
+	bodies [][]byte
+}
+
+func (e *emittingHTTPWatch) StartUnsafe() {
+	e.Watch.StartUnsafe()
+	for _, body := range e.bodies {
+		e.Emit(body)
+	}
+}
+
+func TestPEFWatch_LastKnownGood(t *testing.T) {
+	httpWatch := &emittingHTTPWatch{
+		Watch: NewWatch(),
+		bodies: [][]byte{[]byte(`# TYPE last_round gauge
+last_round 42
+# TYPE blocks_total counter
+blocks_total 10
+`)},
+	}
+
+	lkg := NewLastKnownGood(LastKnownGoodConf{
+		Metrics:  []string{"last_round", "blocks_total"},
+		MaxStale: time.Hour,
+		Path:     filepath.Join(t.TempDir(), "last_known_good_0.json"),
+	})
+	w := NewPEFWatch(PEFWatchConf{
+		Filter:        &openmetrics.PEFFilter{ToMatch: []string{"last_round", "blocks_total"}},
+		LastKnownGood: lkg,
+		Interval:      10 * time.Millisecond,
+	}, httpWatch)
+
+	ch := make(chan interface{}, 100)
+	w.Subscribe(ch)
+	Start(w)
+	defer w.Stop()
+
+	names := map[string]bool{}
+	timeout := time.After(5 * time.Second)
+	for !names["pef.last_round"+StaleAgeSuffix] {
+		select {
+		case msg := <-ch:
+			m := msg.(*model.Message)
+			names[m.Name] = true
+
+			if m.Name == "pef.last_round" && len(m.GetMetricFamily().Metrics[0].Labels) > 0 {
+				require.Equal(t, StaleLabel, m.GetMetricFamily().Metrics[0].Labels[0].Name)
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for stale metrics")
+		}
+	}
+
+	require.True(t, names["pef.blocks_total"])
+	require.False(t, names["pef.blocks_total"+StaleAgeSuffix])
+}
@@ -0,0 +1,376 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"time"
+
+	"agent/internal/pkg/fsutil"
+
+	"go.uber.org/zap"
+)
+
+// ErrLogWatchConf log watch configuration error.
+var ErrLogWatchConf = errors.New("log watch configuration error")
+
+const (
+	// defaultLogInterval how often the log file is polled when no interval
+	// is configured.
+	defaultLogInterval = 250 * time.Millisecond
+
+	// maxLogLineBytes longest line kept pending a newline, longer lines are
+	// emitted in chunks.
+	maxLogLineBytes = 1 << 20
+
+	logReadBufferBytes = 32 << 10
+)
+
+// *** LogWatch ***
+
+// LogWatchConf LogWatch configuration struct.
+type LogWatchConf struct {
+	// Path log file to tail.
+	Path string
+
+	// FromBeginning read the lines already in the file the first time it
+	// is opened, instead of only the lines written afterwards.
+	FromBeginning bool
+
+	// Interval how often the file is polled for new lines, truncation and
+	// rotation. Defaults to 250ms.
+	Interval time.Duration
+
+	// OffsetPath file the read offset is persisted to, so that the lines
+	// read before a restart aren't emitted again. Not persisted if empty.
+	OffsetPath string
+}
+
+// LogWatch implements Watcher interface.
+// Emits each line appended to a log file as a string, without its line
+// ending. A partial last line is only emitted once its newline is written,
+// or once the file is rotated.
+//
+// The file is reopened when it is rotated, i.e. renamed and replaced by a
+// new file, and read from the beginning when it is truncated in place.
+type LogWatch struct {
+	LogWatchConf
+	Watch
+
+	// f, info and pending are owned by the tail goroutine while running.
+	f    *os.File
+	info os.FileInfo
+	// pending read bytes not terminated by a newline yet.
+	pending []byte
+
+	// pos position of the next line to emit, persisted to OffsetPath.
+	pos logPosition
+	// positioned whether pos was restored or set by a previous start.
+	positioned bool
+	persisted  logPosition
+}
+
+// logPosition the file and offset the watch resumes from.
+type logPosition struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+// NewLogWatch log watch constructor. Restores the offset persisted to
+// conf.OffsetPath, if any.
+func NewLogWatch(conf LogWatchConf) (*LogWatch, error) {
+	if conf.Path == "" {
+		return nil, fmt.Errorf("%w: path is required", ErrLogWatchConf)
+	}
+
+	w := new(LogWatch)
+	w.Watch = NewWatch()
+	w.Name = "log"
+	w.LogWatchConf = conf
+
+	if w.Interval < 1 {
+		w.Interval = defaultLogInterval
+	}
+
+	w.Log = w.Log.With("path", w.Path)
+
+	if err := w.load(); err != nil {
+		w.Log.Warnw("failed to load log offset, tailing from scratch", "offset_path", w.OffsetPath, zap.Error(err))
+	}
+
+	return w, nil
+}
+
+// StartUnsafe sets watch running state to true, opens the log file
+// and starts the tail goroutine.
+func (w *LogWatch) StartUnsafe() {
+	w.Watch.StartUnsafe()
+
+	// the position is taken before returning, later lines are emitted
+	w.open()
+	// a file appearing later is read from its beginning
+	w.positioned = true
+
+	w.wg.Add(1)
+	go w.tailLoop(w.runContext(), w.StopKey)
+}
+
+// tailLoop emits the new lines until ctx is done or stop, the StopKey of
+// the run it was started for, is closed.
+func (w *LogWatch) tailLoop(ctx context.Context, stop <-chan bool) {
+	defer w.wg.Done()
+	defer w.close()
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.poll()
+			w.persist()
+
+		case <-stop:
+			return
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// open opens the log file, positioned at the offset to resume from,
+// returns whether it could.
+func (w *LogWatch) open() bool {
+	f, err := os.Open(w.Path)
+	if err != nil {
+		w.Log.Debugw("failed to open log file, will retry", zap.Error(err))
+
+		return false
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		w.Log.Warnw("failed to stat log file, will retry", zap.Error(err))
+		w.ReportError(err)
+
+		return false
+	}
+
+	inode := fileInode(info)
+	switch {
+	case w.positioned && w.pos.Inode == inode && w.pos.Offset <= info.Size():
+		// resume
+	case !w.positioned && !w.FromBeginning:
+		w.pos.Offset = info.Size()
+	default:
+		// a new file or one truncated while not watched
+		w.pos.Offset = 0
+	}
+	w.pos.Inode = inode
+
+	if _, err := f.Seek(w.pos.Offset, io.SeekStart); err != nil {
+		f.Close()
+		w.Log.Warnw("failed to seek log file, will retry", zap.Error(err))
+		w.ReportError(err)
+
+		return false
+	}
+
+	w.f, w.info, w.pending = f, info, nil
+
+	return true
+}
+
+// poll emits the lines written since the last poll, handling truncation
+// and rotation.
+func (w *LogWatch) poll() {
+	if w.f == nil && !w.open() {
+		return
+	}
+
+	if info, err := w.f.Stat(); err == nil && info.Size() < w.pos.Offset+int64(len(w.pending)) {
+		w.Log.Infow("log file truncated, reading from the beginning", "size", info.Size(), "offset", w.pos.Offset)
+
+		if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+			w.Log.Warnw("failed to seek truncated log file, reopening", zap.Error(err))
+			w.ReportError(err)
+			w.closeFile()
+
+			return
+		}
+		w.pos.Offset = 0
+		w.pending = nil
+	}
+
+	w.read()
+
+	info, err := os.Stat(w.Path)
+	if err == nil && os.SameFile(info, w.info) {
+		return
+	}
+
+	// lines written before the rotation
+	w.read()
+	w.flushPending()
+	w.closeFile()
+
+	if err != nil {
+		w.Log.Infow("log file removed, waiting for it to be recreated")
+
+		return
+	}
+
+	w.Log.Infow("log file rotated, reopening")
+	if w.open() {
+		w.read()
+	}
+}
+
+// read reads the file to its end, emitting the complete lines.
+func (w *LogWatch) read() {
+	buf := make([]byte, logReadBufferBytes)
+	for {
+		n, err := w.f.Read(buf)
+		if n > 0 {
+			w.pending = append(w.pending, buf[:n]...)
+			w.emitLines()
+		}
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				w.Log.Warnw("failed to read log file", zap.Error(err))
+				w.ReportError(err)
+			}
+
+			return
+		}
+	}
+}
+
+func (w *LogWatch) emitLines() {
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+
+		w.Emit(string(bytes.TrimSuffix(w.pending[:i], []byte("\r"))))
+		w.pos.Offset += int64(i + 1)
+		w.pending = w.pending[i+1:]
+	}
+
+	if len(w.pending) >= maxLogLineBytes {
+		w.flushPending()
+	}
+
+	// don't hold on to the emitted lines
+	w.pending = append([]byte(nil), w.pending...)
+}
+
+// flushPending emits the partial last line, if any.
+func (w *LogWatch) flushPending() {
+	if len(w.pending) == 0 {
+		return
+	}
+
+	w.Emit(string(w.pending))
+	w.pos.Offset += int64(len(w.pending))
+	w.pending = nil
+}
+
+func (w *LogWatch) closeFile() {
+	if w.f == nil {
+		return
+	}
+
+	if err := w.f.Close(); err != nil {
+		w.Log.Warnw("failed to close log file", zap.Error(err))
+	}
+	w.f, w.info, w.pending = nil, nil, nil
+}
+
+// close closes the file and persists the offset, the watch resumes from it
+// when started again.
+func (w *LogWatch) close() {
+	w.closeFile()
+	w.persist()
+}
+
+// persist writes the offset to OffsetPath, replacing any existing file
+// atomically. It is a no-op if the offset didn't change.
+func (w *LogWatch) persist() {
+	if w.OffsetPath == "" || w.pos == w.persisted {
+		return
+	}
+
+	if err := w.save(); err != nil {
+		w.Log.Warnw("failed to persist log offset", "offset_path", w.OffsetPath, zap.Error(err))
+		w.ReportError(err)
+
+		return
+	}
+	w.persisted = w.pos
+}
+
+func (w *LogWatch) save() error {
+	content, err := json.Marshal(w.pos)
+	if err != nil {
+		return err
+	}
+
+	return fsutil.WriteFileAtomic(w.OffsetPath, content)
+}
+
+func (w *LogWatch) load() error {
+	if w.OffsetPath == "" {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(w.OffsetPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	var pos logPosition
+	if err := json.Unmarshal(content, &pos); err != nil {
+		return err
+	}
+
+	w.pos, w.persisted, w.positioned = pos, pos, true
+
+	return nil
+}
+
+// fileInode the inode number of a file, 0 if unknown.
+func fileInode(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+
+	return 0
+}
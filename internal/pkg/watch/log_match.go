@@ -0,0 +1,391 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"agent/api/v1/model"
+
+	"go.uber.org/zap"
+)
+
+// ErrLogMatchWatchConf log match watch configuration error.
+var ErrLogMatchWatchConf = errors.New("log match watch configuration error")
+
+const (
+	// LogMatcherKey key of the name of the matcher in the emitted fields.
+	LogMatcherKey = "matcher"
+
+	// LogLineKey key of the matched line in the emitted fields, followed
+	// by its continuation lines, if any.
+	LogLineKey = "line"
+
+	// defaultContinuationTimeout how long an entry waits for continuation
+	// lines when no timeout is configured.
+	defaultContinuationTimeout = 500 * time.Millisecond
+
+	// maxContinuationLines continuation lines kept per entry, the following
+	// ones are dropped.
+	maxContinuationLines = 1000
+)
+
+// LogMatcher selects the log lines of interest and extracts their fields.
+// It is either a regexp matcher, or a JSON matcher if JSON is set.
+type LogMatcher struct {
+	// Name the matcher name, set to LogMatcherKey in the emitted fields.
+	Name string
+
+	// Regexp the lines must match, its named capture groups are the
+	// extracted fields.
+	Regexp string
+
+	// JSON match the lines that are JSON objects.
+	JSON bool
+
+	// Match JSON mode only, dotted paths into the object, i.e.
+	// details.round, mapped to a regexp their value must match.
+	Match map[string]string
+
+	// Fields JSON mode only, extracted field names mapped to their dotted
+	// path into the object. The whole object is extracted if empty.
+	Fields map[string]string
+}
+
+// LogMatchWatchConf LogMatchWatch configuration struct.
+type LogMatchWatchConf struct {
+	// Matchers tried in order on each line, the first one matching wins.
+	Matchers []LogMatcher
+
+	// Continuation regexp of the lines continuing the previous one, i.e.
+	// stack traces. They are appended to the matched line they follow,
+	// and dropped if it didn't match.
+	Continuation string
+
+	// ContinuationTimeout how long a matched line waits for continuation
+	// lines before being emitted. Defaults to 500ms.
+	ContinuationTimeout time.Duration
+
+	// Events node events built from the extracted fields. If set, the
+	// events are emitted instead of the fields.
+	Events map[string]model.FromContext
+}
+
+// LogMatchWatch implements Watcher interface.
+// Matches the lines emitted as strings by a watch, i.e. a LogWatch, and
+// emits the fields extracted from each matching one as a
+// map[string]interface{}, or the node events built from them.
+type LogMatchWatch struct {
+	LogMatchWatchConf
+	Watch
+
+	linesWatch Watcher
+	linesCh    chan interface{}
+	subscribed bool
+
+	matchers     []*logMatcher
+	continuation *regexp.Regexp
+}
+
+type logMatcher struct {
+	LogMatcher
+
+	re    *regexp.Regexp
+	match map[string]*regexp.Regexp
+}
+
+// NewLogMatchWatch LogMatchWatch constructor, returns an error if a matcher
+// is invalid.
+func NewLogMatchWatch(conf LogMatchWatchConf, linesWatch Watcher) (*LogMatchWatch, error) {
+	w := &LogMatchWatch{
+		Watch:             NewWatch(),
+		LogMatchWatchConf: conf,
+		linesWatch:        linesWatch,
+		linesCh:           make(chan interface{}, 1024),
+	}
+
+	w.Name = "log_match"
+
+	if len(conf.Matchers) == 0 {
+		return nil, fmt.Errorf("%w: no matcher", ErrLogMatchWatchConf)
+	}
+
+	names := map[string]struct{}{}
+	for _, mc := range conf.Matchers {
+		if _, ok := names[mc.Name]; ok {
+			return nil, fmt.Errorf("%w: duplicate matcher %q", ErrLogMatchWatchConf, mc.Name)
+		}
+		names[mc.Name] = struct{}{}
+
+		m, err := newLogMatcher(mc)
+		if err != nil {
+			return nil, err
+		}
+		w.matchers = append(w.matchers, m)
+	}
+
+	if conf.Continuation != "" {
+		re, err := regexp.Compile(conf.Continuation)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid continuation regexp: %v", ErrLogMatchWatchConf, err)
+		}
+		w.continuation = re
+	}
+
+	if w.ContinuationTimeout < 1 {
+		w.ContinuationTimeout = defaultContinuationTimeout
+	}
+
+	return w, nil
+}
+
+func newLogMatcher(conf LogMatcher) (*logMatcher, error) {
+	m := &logMatcher{LogMatcher: conf, match: map[string]*regexp.Regexp{}}
+
+	switch {
+	case conf.Name == "":
+		return nil, fmt.Errorf("%w: matcher name is required", ErrLogMatchWatchConf)
+	case conf.JSON && conf.Regexp != "":
+		return nil, fmt.Errorf("%w: matcher %q: regexp and JSON are exclusive", ErrLogMatchWatchConf, conf.Name)
+	case conf.JSON:
+		for path, expr := range conf.Match {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("%w: matcher %q: invalid regexp for %s: %v", ErrLogMatchWatchConf, conf.Name, path, err)
+			}
+			m.match[path] = re
+		}
+	case conf.Regexp == "":
+		return nil, fmt.Errorf("%w: matcher %q: one of regexp or JSON is required", ErrLogMatchWatchConf, conf.Name)
+	case len(conf.Match) > 0 || len(conf.Fields) > 0:
+		return nil, fmt.Errorf("%w: matcher %q: match and fields only apply to JSON", ErrLogMatchWatchConf, conf.Name)
+	default:
+		re, err := regexp.Compile(conf.Regexp)
+		if err != nil {
+			return nil, fmt.Errorf("%w: matcher %q: invalid regexp: %v", ErrLogMatchWatchConf, conf.Name, err)
+		}
+		m.re = re
+	}
+
+	return m, nil
+}
+
+// StartUnsafe subscribes to and starts the lines watch
+// and starts a goroutine for matching the lines.
+func (w *LogMatchWatch) StartUnsafe() {
+	w.Watch.StartUnsafe()
+
+	if !w.subscribed {
+		w.linesWatch.Subscribe(w.linesCh)
+		w.subscribed = true
+	}
+	StartWithContext(w.runContext(), w.linesWatch)
+
+	w.wg.Add(1)
+	go w.matchLoop(w.runContext(), w.StopKey)
+}
+
+// Stop stops the watch and the lines watch.
+func (w *LogMatchWatch) Stop() {
+	w.Watch.Stop()
+	w.linesWatch.Stop()
+}
+
+// matchLoop matches the lines until ctx is done or stop, the StopKey of the
+// run it was started for, is closed. The entry waiting for continuation
+// lines is emitted on exit.
+func (w *LogMatchWatch) matchLoop(ctx context.Context, stop <-chan bool) {
+	defer w.wg.Done()
+
+	var (
+		entry map[string]interface{}
+		lines []string
+	)
+	flush := func() {
+		if entry == nil {
+			return
+		}
+		entry[LogLineKey] = strings.Join(lines, "\n")
+		w.emit(entry)
+		entry, lines = nil, nil
+	}
+	defer flush()
+
+	timeout := time.NewTimer(w.ContinuationTimeout)
+	timeout.Stop()
+	defer timeout.Stop()
+
+	for {
+		select {
+		case msg := <-w.linesCh:
+			line, ok := msg.(string)
+			if !ok {
+				w.Log.Errorw("type assertion failed, expected a log line", "type", fmt.Sprintf("%T", msg))
+
+				continue
+			}
+
+			if w.continuation != nil && w.continuation.MatchString(line) {
+				if entry != nil && len(lines) <= maxContinuationLines {
+					lines = append(lines, line)
+					resetTimer(timeout, w.ContinuationTimeout)
+				}
+
+				continue
+			}
+
+			flush()
+			if entry = w.match(line); entry == nil {
+				continue
+			}
+			lines = []string{line}
+
+			if w.continuation == nil {
+				flush()
+			} else {
+				resetTimer(timeout, w.ContinuationTimeout)
+			}
+
+		case <-timeout.C:
+			flush()
+
+		case <-stop:
+			return
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// match returns the fields extracted by the first matcher matching line,
+// nil if none does.
+func (w *LogMatchWatch) match(line string) map[string]interface{} {
+	var (
+		obj    map[string]interface{}
+		parsed bool
+	)
+
+	for _, m := range w.matchers {
+		var fields map[string]interface{}
+		if m.JSON {
+			if !parsed {
+				parsed = true
+				if strings.HasPrefix(strings.TrimSpace(line), "{") {
+					if err := json.Unmarshal([]byte(line), &obj); err != nil {
+						w.Log.Debugw("failed to parse JSON log line", zap.Error(err))
+					}
+				}
+			}
+			fields = m.matchJSON(obj)
+		} else {
+			fields = m.matchRegexp(line)
+		}
+
+		if fields != nil {
+			fields[LogMatcherKey] = m.Name
+
+			return fields
+		}
+	}
+
+	return nil
+}
+
+func (m *logMatcher) matchRegexp(line string) map[string]interface{} {
+	sub := m.re.FindStringSubmatch(line)
+	if sub == nil {
+		return nil
+	}
+
+	fields := map[string]interface{}{}
+	for i, name := range m.re.SubexpNames() {
+		if name != "" {
+			fields[name] = sub[i]
+		}
+	}
+
+	return fields
+}
+
+func (m *logMatcher) matchJSON(obj map[string]interface{}) map[string]interface{} {
+	if obj == nil {
+		return nil
+	}
+
+	for path, re := range m.match {
+		v, ok := lookupPath(obj, path)
+		if !ok || !re.MatchString(fmt.Sprint(v)) {
+			return nil
+		}
+	}
+
+	fields := map[string]interface{}{}
+	if len(m.Fields) == 0 {
+		for k, v := range obj {
+			fields[k] = v
+		}
+
+		return fields
+	}
+
+	for name, path := range m.Fields {
+		if v, ok := lookupPath(obj, path); ok {
+			fields[name] = v
+		}
+	}
+
+	return fields
+}
+
+func (w *LogMatchWatch) emit(fields map[string]interface{}) {
+	if w.Events != nil {
+		w.emitNodeLogEvents(w.Events, fields)
+
+		return
+	}
+
+	w.Emit(fields)
+}
+
+// lookupPath returns the value at a dotted path into a decoded JSON value,
+// array elements are selected by their index.
+func lookupPath(v interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = node[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return v, true
+}
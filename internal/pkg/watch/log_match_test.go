@@ -0,0 +1,263 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"agent/api/v1/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	algodRoundConcluded = `{"Context":"Agreement","Hash":"OEXXTF4U","ObjectPeriod":0,"ObjectRound":23456789,"Period":0,"Round":23456789,"Step":0,"Type":"RoundConcluded","file":"trace.go","function":"github.com/algorand/go-algorand/agreement.(*tracer).logRoundConcluded","level":"info","msg":"agreement: round 23456789 concluded","time":"2022-10-04T12:00:00.000000Z"}`
+	algodPeerError      = `{"details":{"peers":[{"addr":"r-aa.algorand-mainnet.network:4160"}]},"file":"wsNetwork.go","function":"github.com/algorand/go-algorand/network.(*WebsocketNetwork).tryConnect","level":"warn","msg":"ws connect failed","time":"2022-10-04T12:00:01.000000Z"}`
+	algodDebug          = `{"Type":"RoundStart","level":"debug","msg":"agreement: round started","time":"2022-10-04T12:00:02.000000Z"}`
+)
+
+func startLogMatchWatch(t *testing.T, conf LogMatchWatchConf) (*Watch, chan interface{}) {
+	t.Helper()
+
+	lines := new(Watch)
+	*lines = NewWatch()
+
+	w, err := NewLogMatchWatch(conf, lines)
+	require.NoError(t, err)
+
+	ch := make(chan interface{}, 100)
+	w.Subscribe(ch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	StartWithContext(ctx, w)
+	t.Cleanup(func() {
+		cancel()
+		w.Wait()
+	})
+
+	return lines, ch
+}
+
+func requireMatch(t *testing.T, ch chan interface{}, want map[string]interface{}) {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		require.Equal(t, want, msg)
+	case <-time.After(3 * time.Second):
+		t.Fatalf("no match %v", want)
+	}
+}
+
+func requireNoMatch(t *testing.T, ch chan interface{}) {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		t.Fatalf("unexpected match %v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNewLogMatchWatch_Invalid(t *testing.T) {
+	lines := new(Watch)
+	*lines = NewWatch()
+
+	for _, conf := range []LogMatchWatchConf{
+		{},
+		{Matchers: []LogMatcher{{Regexp: "a"}}},
+		{Matchers: []LogMatcher{{Name: "a"}}},
+		{Matchers: []LogMatcher{{Name: "a", Regexp: "("}}},
+		{Matchers: []LogMatcher{{Name: "a", Regexp: "a", JSON: true}}},
+		{Matchers: []LogMatcher{{Name: "a", Regexp: "a", Fields: map[string]string{"b": "b"}}}},
+		{Matchers: []LogMatcher{{Name: "a", JSON: true, Match: map[string]string{"b": "("}}}},
+		{Matchers: []LogMatcher{{Name: "a", Regexp: "a"}, {Name: "a", Regexp: "b"}}},
+		{Matchers: []LogMatcher{{Name: "a", Regexp: "a"}}, Continuation: "("},
+	} {
+		_, err := NewLogMatchWatch(conf, lines)
+		require.ErrorIs(t, err, ErrLogMatchWatchConf, "%+v", conf)
+	}
+}
+
+func TestLogMatchWatch_Regexp(t *testing.T) {
+	lines, ch := startLogMatchWatch(t, LogMatchWatchConf{
+		Matchers: []LogMatcher{
+			{Name: "peer_connected", Regexp: `^(?P<level>[A-Z]+) peer (?P<peer>\S+) connected$`},
+			{Name: "peer_any", Regexp: `peer (?P<peer>\S+)`},
+		},
+	})
+
+	lines.Emit("INFO peer 10.0.0.1:4160 connected")
+	requireMatch(t, ch, map[string]interface{}{
+		LogMatcherKey: "peer_connected",
+		LogLineKey:    "INFO peer 10.0.0.1:4160 connected",
+		"level":       "INFO",
+		"peer":        "10.0.0.1:4160",
+	})
+
+	// the first matching matcher wins
+	lines.Emit("WARN peer 10.0.0.2:4160 disconnected")
+	requireMatch(t, ch, map[string]interface{}{
+		LogMatcherKey: "peer_any",
+		LogLineKey:    "WARN peer 10.0.0.2:4160 disconnected",
+		"peer":        "10.0.0.2:4160",
+	})
+
+	lines.Emit("INFO catchup complete")
+	lines.Emit(algodRoundConcluded)
+	requireNoMatch(t, ch)
+}
+
+func TestLogMatchWatch_JSON(t *testing.T) {
+	lines, ch := startLogMatchWatch(t, LogMatchWatchConf{
+		Matchers: []LogMatcher{
+			{
+				Name:   "round_concluded",
+				JSON:   true,
+				Match:  map[string]string{"Type": "^RoundConcluded$"},
+				Fields: map[string]string{"round": "Round", "time": "time", "missing": "not.there"},
+			},
+			{
+				Name:  "problem",
+				JSON:  true,
+				Match: map[string]string{"level": "^(warn|error)$", "details.peers.0.addr": "algorand"},
+			},
+		},
+	})
+
+	lines.Emit(algodRoundConcluded)
+	requireMatch(t, ch, map[string]interface{}{
+		LogMatcherKey: "round_concluded",
+		LogLineKey:    algodRoundConcluded,
+		"round":       float64(23456789),
+		"time":        "2022-10-04T12:00:00.000000Z",
+	})
+
+	// the whole object without fields
+	lines.Emit(algodPeerError)
+	requireMatch(t, ch, map[string]interface{}{
+		LogMatcherKey: "problem",
+		LogLineKey:    algodPeerError,
+		"details": map[string]interface{}{
+			"peers": []interface{}{map[string]interface{}{"addr": "r-aa.algorand-mainnet.network:4160"}},
+		},
+		"file":     "wsNetwork.go",
+		"function": "github.com/algorand/go-algorand/network.(*WebsocketNetwork).tryConnect",
+		"level":    "warn",
+		"msg":      "ws connect failed",
+		"time":     "2022-10-04T12:00:01.000000Z",
+	})
+
+	lines.Emit(algodDebug)
+	lines.Emit("not json")
+	lines.Emit(`{"Type": "RoundConcluded"`)
+	requireNoMatch(t, ch)
+}
+
+func TestLogMatchWatch_Multiline(t *testing.T) {
+	lines, ch := startLogMatchWatch(t, LogMatchWatchConf{
+		Matchers: []LogMatcher{
+			{Name: "panic", Regexp: `^panic: (?P<reason>.*)$`},
+		},
+		Continuation:        `^(\s|goroutine |$)`,
+		ContinuationTimeout: 20 * time.Millisecond,
+	})
+
+	lines.Emit("panic: runtime error: invalid memory address")
+	lines.Emit("")
+	lines.Emit("goroutine 1 [running]:")
+	lines.Emit("\tgithub.com/algorand/go-algorand/node.(*AlgorandFullNode).Start()")
+	lines.Emit("INFO restarting")
+	requireMatch(t, ch, map[string]interface{}{
+		LogMatcherKey: "panic",
+		LogLineKey: "panic: runtime error: invalid memory address\n" +
+			"\n" +
+			"goroutine 1 [running]:\n" +
+			"\tgithub.com/algorand/go-algorand/node.(*AlgorandFullNode).Start()",
+		"reason": "runtime error: invalid memory address",
+	})
+
+	// continuation lines of an unmatched line are dropped
+	lines.Emit("goroutine 2 [select]:")
+	requireNoMatch(t, ch)
+
+	// the last entry is emitted once no continuation line follows
+	lines.Emit("panic: boom")
+	lines.Emit("goroutine 1 [running]:")
+	requireMatch(t, ch, map[string]interface{}{
+		LogMatcherKey: "panic",
+		LogLineKey:    "panic: boom\ngoroutine 1 [running]:",
+		"reason":      "boom",
+	})
+}
+
+// roundConcluded builds an event from the fields of the round_concluded
+// matcher.
+type roundConcluded struct{}
+
+func (roundConcluded) New(v map[string]interface{}, t time.Time) (*model.Event, error) {
+	if v[LogMatcherKey] != "round_concluded" {
+		return nil, nil
+	}
+
+	return model.NewWithFilteredCtx(v, "round_concluded", t, "round")
+}
+
+func TestLogMatchWatch_Events(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.log")
+	appendLog(t, path, "")
+
+	lines, err := NewLogWatch(LogWatchConf{Path: path, Interval: testLogInterval})
+	require.NoError(t, err)
+
+	w, err := NewLogMatchWatch(LogMatchWatchConf{
+		Matchers: []LogMatcher{{
+			Name:   "round_concluded",
+			JSON:   true,
+			Match:  map[string]string{"Type": "^RoundConcluded$"},
+			Fields: map[string]string{"round": "Round"},
+		}},
+		Events: map[string]model.FromContext{"round_concluded": roundConcluded{}},
+	}, lines)
+	require.NoError(t, err)
+
+	ch := make(chan interface{}, 10)
+	w.Subscribe(ch)
+	StartWithContext(context.Background(), w)
+	defer w.Wait()
+	defer w.Stop()
+
+	appendLog(t, path, algodDebug+"\n"+algodRoundConcluded+"\n")
+
+	select {
+	case msg := <-ch:
+		message, ok := msg.(*model.Message)
+		require.True(t, ok)
+		require.Equal(t, "round_concluded", message.Name)
+		require.Equal(t, map[string]interface{}{"round": float64(23456789)}, message.GetEvent().Values.AsMap())
+	case <-time.After(3 * time.Second):
+		t.Fatal("no event")
+	}
+
+	// stopping the watch stops the lines watch
+	w.Stop()
+	w.Wait()
+	lines.Wait()
+	lines.Lock()
+	require.False(t, lines.Running)
+	lines.Unlock()
+}
@@ -0,0 +1,230 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testLogInterval = 10 * time.Millisecond
+
+func startLogWatch(t *testing.T, conf LogWatchConf) (*LogWatch, chan interface{}) {
+	t.Helper()
+
+	conf.Interval = testLogInterval
+	w, err := NewLogWatch(conf)
+	require.NoError(t, err)
+
+	ch := make(chan interface{}, 100)
+	w.Subscribe(ch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	StartWithContext(ctx, w)
+	t.Cleanup(func() {
+		cancel()
+		w.Wait()
+	})
+
+	return w, ch
+}
+
+func requireLines(t *testing.T, ch chan interface{}, lines ...string) {
+	t.Helper()
+
+	for _, want := range lines {
+		select {
+		case msg := <-ch:
+			require.Equal(t, want, msg)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("no line %q", want)
+		}
+	}
+}
+
+func requireNoLine(t *testing.T, ch chan interface{}) {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		t.Fatalf("unexpected line %q", msg)
+	case <-time.After(10 * testLogInterval):
+	}
+}
+
+// appendLog appends content to the file at path, creating it if needed.
+func appendLog(t *testing.T, path, content string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = f.WriteString(content)
+	require.NoError(t, err)
+}
+
+func TestNewLogWatch_Invalid(t *testing.T) {
+	_, err := NewLogWatch(LogWatchConf{})
+	require.ErrorIs(t, err, ErrLogWatchConf)
+}
+
+func TestLogWatch_FromEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.log")
+	appendLog(t, path, "old 1\nold 2\n")
+
+	_, ch := startLogWatch(t, LogWatchConf{Path: path})
+
+	appendLog(t, path, "new 1\r\nnew 2\n")
+	requireLines(t, ch, "new 1", "new 2")
+	requireNoLine(t, ch)
+}
+
+func TestLogWatch_FromBeginning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.log")
+	appendLog(t, path, "old 1\nold 2\n")
+
+	_, ch := startLogWatch(t, LogWatchConf{Path: path, FromBeginning: true})
+	requireLines(t, ch, "old 1", "old 2")
+
+	appendLog(t, path, "new 1\n")
+	requireLines(t, ch, "new 1")
+}
+
+func TestLogWatch_PartialLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.log")
+	appendLog(t, path, "")
+
+	_, ch := startLogWatch(t, LogWatchConf{Path: path})
+
+	appendLog(t, path, "first\npar")
+	requireLines(t, ch, "first")
+	requireNoLine(t, ch)
+
+	appendLog(t, path, "tial\n")
+	requireLines(t, ch, "partial")
+}
+
+func TestLogWatch_NotExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.log")
+
+	_, ch := startLogWatch(t, LogWatchConf{Path: path})
+	requireNoLine(t, ch)
+
+	// a file created after the start is new, read from its beginning
+	appendLog(t, path, "first\n")
+	requireLines(t, ch, "first")
+}
+
+func TestLogWatch_CopyTruncate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "node.log")
+	appendLog(t, path, "")
+
+	_, ch := startLogWatch(t, LogWatchConf{Path: path})
+
+	appendLog(t, path, "a long line before the rotation\n")
+	requireLines(t, ch, "a long line before the rotation")
+
+	// logrotate copytruncate: copy the content then truncate in place
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path+".1", content, 0o600))
+	require.NoError(t, os.Truncate(path, 0))
+	requireNoLine(t, ch)
+
+	appendLog(t, path, "after\n")
+	requireLines(t, ch, "after")
+	requireNoLine(t, ch)
+}
+
+func TestLogWatch_RenameRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "node.log")
+	appendLog(t, path, "")
+
+	_, ch := startLogWatch(t, LogWatchConf{Path: path})
+
+	// the node keeps writing to its file descriptor until it reopens
+	old, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	defer old.Close()
+
+	_, err = old.WriteString("before\n")
+	require.NoError(t, err)
+	requireLines(t, ch, "before")
+
+	_, err = old.WriteString("last\nunterminated")
+	require.NoError(t, err)
+	require.NoError(t, os.Rename(path, path+".1"))
+	appendLog(t, path, "first\n")
+
+	// the rest of the rotated file, its partial last line included, then
+	// the new file
+	requireLines(t, ch, "last", "unterminated", "first")
+
+	appendLog(t, path, "second\n")
+	requireLines(t, ch, "second")
+}
+
+func TestLogWatch_Offset(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "node.log")
+	offsetPath := filepath.Join(dir, "node.log.offset")
+	appendLog(t, path, "old\n")
+
+	w, ch := startLogWatch(t, LogWatchConf{Path: path, OffsetPath: offsetPath})
+	appendLog(t, path, "line 1\npartial")
+	requireLines(t, ch, "line 1")
+
+	w.Stop()
+	w.Wait()
+
+	// written while the agent is down
+	appendLog(t, path, " line\nline 2\n")
+
+	// a new agent resumes from the persisted offset, without re-emitting
+	_, ch = startLogWatch(t, LogWatchConf{Path: path, OffsetPath: offsetPath})
+	requireLines(t, ch, "partial line", "line 2")
+	requireNoLine(t, ch)
+}
+
+func TestLogWatch_OffsetRotated(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "node.log")
+	offsetPath := filepath.Join(dir, "node.log.offset")
+	appendLog(t, path, strings.Repeat("old\n", 10))
+
+	w, ch := startLogWatch(t, LogWatchConf{Path: path, OffsetPath: offsetPath})
+	appendLog(t, path, "line 1\n")
+	requireLines(t, ch, "line 1")
+
+	w.Stop()
+	w.Wait()
+
+	// rotated while the agent is down
+	require.NoError(t, os.Rename(path, path+".1"))
+	appendLog(t, path, "new 1\n")
+
+	_, ch = startLogWatch(t, LogWatchConf{Path: path, OffsetPath: offsetPath})
+	requireLines(t, ch, "new 1")
+	requireNoLine(t, ch)
+}
@@ -0,0 +1,129 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsCollector returns a collector of the self-metrics of the watchers
+// registered with DefaultWatchRegistry. It isn't registered by default.
+func MetricsCollector() prometheus.Collector {
+	return NewMetricsCollector(DefaultWatchRegistry)
+}
+
+// metricsCollector reports the self-metrics of the watchers of a registry,
+// labelled with their name. Watchers sharing a name are told apart by a
+// suffix, in registration order.
+type metricsCollector struct {
+	registry WatchersRegisterer
+
+	emittedDesc     *prometheus.Desc
+	droppedDesc     *prometheus.Desc
+	suppressedDesc  *prometheus.Desc
+	errorsDesc      *prometheus.Desc
+	subscribersDesc *prometheus.Desc
+	lastEmitAgeDesc *prometheus.Desc
+	runningDesc     *prometheus.Desc
+}
+
+// NewMetricsCollector returns a collector of the self-metrics of the
+// watchers registered with registry.
+func NewMetricsCollector(registry WatchersRegisterer) prometheus.Collector {
+	return &metricsCollector{
+		registry: registry,
+		emittedDesc: prometheus.NewDesc(
+			"agent_watch_emitted_total",
+			"Messages emitted by an agent watch.",
+			[]string{"watch"}, nil,
+		),
+		droppedDesc: prometheus.NewDesc(
+			"agent_watch_dropped_total",
+			"Messages of an agent watch discarded because a subscriber was full.",
+			[]string{"watch"}, nil,
+		),
+		suppressedDesc: prometheus.NewDesc(
+			"agent_watch_suppressed_total",
+			"Messages of an agent watch suppressed by a rate limit.",
+			[]string{"watch"}, nil,
+		),
+		errorsDesc: prometheus.NewDesc(
+			"agent_watch_errors_total",
+			"Runtime errors reported by an agent watch.",
+			[]string{"watch"}, nil,
+		),
+		subscribersDesc: prometheus.NewDesc(
+			"agent_watch_subscribers",
+			"Subscriptions of an agent watch.",
+			[]string{"watch"}, nil,
+		),
+		lastEmitAgeDesc: prometheus.NewDesc(
+			"agent_watch_last_emit_age_seconds",
+			"Seconds since an agent watch last emitted, absent if it never did.",
+			[]string{"watch"}, nil,
+		),
+		runningDesc: prometheus.NewDesc(
+			"agent_watch_running",
+			"1 if an agent watch is running.",
+			[]string{"watch"}, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *metricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.emittedDesc
+	ch <- c.droppedDesc
+	ch <- c.suppressedDesc
+	ch <- c.errorsDesc
+	ch <- c.subscribersDesc
+	ch <- c.lastEmitAgeDesc
+	ch <- c.runningDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	seen := map[string]int{}
+
+	for _, h := range c.registry.Health() {
+		name := watcherName(h.Watcher)
+		seen[name]++
+		if n := seen[name]; n > 1 {
+			name = fmt.Sprintf("%s-%d", name, n)
+		}
+
+		w := h.Watcher.base()
+		status := h.Watcher.Status()
+
+		ch <- prometheus.MustNewConstMetric(c.emittedDesc, prometheus.CounterValue, float64(status.EmitCount), name)
+		ch <- prometheus.MustNewConstMetric(c.droppedDesc, prometheus.CounterValue, float64(w.Dropped()), name)
+		ch <- prometheus.MustNewConstMetric(c.suppressedDesc, prometheus.CounterValue, float64(w.Suppressed()), name)
+		ch <- prometheus.MustNewConstMetric(c.errorsDesc, prometheus.CounterValue, float64(h.Errors), name)
+		ch <- prometheus.MustNewConstMetric(c.subscribersDesc, prometheus.GaugeValue, float64(w.Subscribers()), name)
+
+		if !status.LastEmitAt.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.lastEmitAgeDesc, prometheus.GaugeValue, now.Sub(status.LastEmitAt).Seconds(), name)
+		}
+
+		running := 0.0
+		if status.Running {
+			running = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.runningDesc, prometheus.GaugeValue, running, name)
+	}
+}
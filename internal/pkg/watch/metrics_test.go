@@ -0,0 +1,107 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+// gatherWatchMetrics returns the values of the watch metrics by name and
+// watch label.
+func gatherWatchMetrics(t *testing.T, c prometheus.Collector) map[string]map[string]float64 {
+	t.Helper()
+
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(c))
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	metrics := map[string]map[string]float64{}
+	for _, family := range families {
+		values := map[string]float64{}
+		for _, m := range family.GetMetric() {
+			require.Len(t, m.GetLabel(), 1)
+			require.Equal(t, "watch", m.GetLabel()[0].GetName())
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				values[m.GetLabel()[0].GetValue()] = m.GetCounter().GetValue()
+			default:
+				values[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+			}
+		}
+		metrics[family.GetName()] = values
+	}
+
+	return metrics
+}
+
+func TestMetricsCollector(t *testing.T) {
+	registry := newTestRegistry()
+
+	fast := NewTimerWatch(TimerWatchConf{Interval: time.Millisecond})
+	idle := NewTimerWatch(TimerWatchConf{Interval: time.Hour})
+	failing := newScriptedWatch(true, errors.New("boom"), "foo")
+	require.NoError(t, registry.Register(fast, idle, failing))
+
+	// a full subscriber drops the messages
+	ch := make(chan interface{})
+	require.NoError(t, registry.Start(ch))
+	t.Cleanup(func() {
+		registry.Stop()
+		registry.Wait()
+	})
+
+	require.Eventually(t, func() bool {
+		return fast.Status().EmitCount >= 3 && !failing.Status().Running
+	}, 5*time.Second, time.Millisecond)
+
+	metrics := gatherWatchMetrics(t, NewMetricsCollector(registry))
+
+	require.Len(t, metrics, 7)
+	for _, name := range []string{
+		"agent_watch_emitted_total",
+		"agent_watch_dropped_total",
+		"agent_watch_suppressed_total",
+		"agent_watch_errors_total",
+		"agent_watch_subscribers",
+		"agent_watch_running",
+	} {
+		require.Contains(t, metrics, name)
+		require.Len(t, metrics[name], 3, name)
+	}
+
+	// watchers sharing a name are told apart
+	emitted := metrics["agent_watch_emitted_total"]
+	require.GreaterOrEqual(t, emitted["timer"], float64(3))
+	require.Zero(t, emitted["timer-2"])
+	require.Equal(t, float64(1), emitted["*watch.scriptedWatch"])
+	require.GreaterOrEqual(t, metrics["agent_watch_dropped_total"]["timer"], float64(3))
+	require.Equal(t, float64(1), metrics["agent_watch_errors_total"]["*watch.scriptedWatch"])
+	require.Equal(t, float64(1), metrics["agent_watch_subscribers"]["timer"])
+
+	require.Equal(t, map[string]float64{"timer": 1, "timer-2": 1, "*watch.scriptedWatch": 0}, metrics["agent_watch_running"])
+
+	// absent until the watch emits
+	require.Contains(t, metrics["agent_watch_last_emit_age_seconds"], "timer")
+	require.NotContains(t, metrics["agent_watch_last_emit_age_seconds"], "timer-2")
+	require.Contains(t, metrics["agent_watch_last_emit_age_seconds"], "*watch.scriptedWatch")
+}
@@ -0,0 +1,299 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"agent/api/v1/model"
+	"agent/internal/pkg/global"
+	"agent/pkg/parse/openmetrics"
+	"agent/pkg/timesync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+const (
+	// NodeExporterSource source label value of data scraped from node_exporter.
+	NodeExporterSource = "node_exporter"
+
+	// InternalSource source label value of data gathered by the internal collectors.
+	InternalSource = "internal"
+)
+
+// nodeExporterSource the source currently producing the mapped families.
+var nodeExporterSource = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "agent_node_exporter_source",
+	Help: "Source of the node metrics mapped from node_exporter, 1 for the active one.",
+}, []string{"source"})
+
+// NodeExporterWatchConf NodeExporterWatch configuration.
+type NodeExporterWatchConf struct {
+	URL           string
+	Interval      time.Duration
+	Timeout       time.Duration
+	FallbackAfter int
+	Mappings      []global.NodeExporterMapping
+
+	// Fallback gatherers of the internal collectors replaced by the
+	// mappings, keyed by collector type.
+	Fallback map[global.WatchType]prometheus.Gatherer
+
+	// Client HTTP client used for scraping. Defaults to a client
+	// with the configured timeout.
+	Client *http.Client
+}
+
+// nodeExporterFamily where a scraped family is shipped.
+type nodeExporterFamily struct {
+	collector global.WatchType
+	name      string
+}
+
+// NodeExporterWatch scrapes an existing node_exporter and ships the mapped
+// families under the internal collectors they replace. Falls back to those
+// collectors after FallbackAfter consecutive failed scrapes and switches
+// back once a scrape succeeds.
+type NodeExporterWatch struct {
+	NodeExporterWatchConf
+	Watch
+
+	families map[string]nodeExporterFamily
+	failures int
+	fallback bool
+}
+
+// NewNodeExporterWatch NodeExporterWatch constructor.
+func NewNodeExporterWatch(conf NodeExporterWatchConf) (*NodeExporterWatch, error) {
+	if conf.URL == "" {
+		return nil, errors.New("node_exporter watch: url is required")
+	}
+
+	w := &NodeExporterWatch{
+		NodeExporterWatchConf: conf,
+		Watch:                 NewWatch(),
+		families:              map[string]nodeExporterFamily{},
+	}
+
+	w.Name = "node_exporter"
+
+	for _, m := range conf.Mappings {
+		if !global.WatchType(m.Collector).IsPrometheus() {
+			return nil, fmt.Errorf("node_exporter watch: %q is not an internal collector", m.Collector)
+		}
+
+		for _, family := range m.Families {
+			if prev, ok := w.families[family]; ok {
+				return nil, fmt.Errorf("node_exporter watch: family %q mapped to both %s and %s", family, prev.collector, m.Collector)
+			}
+
+			name := family
+			if renamed, ok := m.Rename[family]; ok {
+				name = renamed
+			}
+			w.families[family] = nodeExporterFamily{collector: global.WatchType(m.Collector), name: name}
+		}
+	}
+
+	if len(w.families) == 0 {
+		return nil, errors.New("node_exporter watch: no family mapped")
+	}
+
+	if w.FallbackAfter < 1 {
+		w.FallbackAfter = global.DefaultRuntimeNodeExporterFallbackAfter
+	}
+
+	if w.Client == nil {
+		w.Client = &http.Client{Timeout: w.Timeout}
+	}
+
+	w.Log = w.Log.With("watch", "node_exporter", "url", w.URL)
+
+	return w, nil
+}
+
+// Match implements parse.KeyMatcher, matching the mapped families.
+func (w *NodeExporterWatch) Match(name string) bool {
+	_, ok := w.families[name]
+
+	return ok
+}
+
+// StartUnsafe starts the goroutine scraping node_exporter.
+func (w *NodeExporterWatch) StartUnsafe() {
+	w.Watch.StartUnsafe()
+
+	w.setSource()
+
+	w.wg.Add(1)
+	go w.scrapeLoop()
+}
+
+func (w *NodeExporterWatch) scrapeLoop() {
+	defer w.wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-w.StopKey
+		cancel()
+	}()
+
+	for {
+		select {
+		case <-time.After(w.Interval):
+			w.collect(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// collect scrapes node_exporter, or gathers the internal collectors if
+// scrapes keep failing.
+func (w *NodeExporterWatch) collect(ctx context.Context) {
+	metricFamilies, err := w.scrape(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+
+		w.failures++
+		if !w.fallback && w.failures >= w.FallbackAfter {
+			w.Log.Warnw("node_exporter unreachable, falling back to the internal collectors",
+				"failures", w.failures, zap.Error(err))
+			w.fallback = true
+			w.setSource()
+		} else if !w.fallback {
+			w.Log.Warnw("failed to scrape node_exporter", "failures", w.failures, zap.Error(err))
+			w.ReportError(err)
+		}
+
+		if w.fallback {
+			w.gatherFallback()
+		}
+
+		return
+	}
+
+	w.failures = 0
+	if w.fallback {
+		w.Log.Info("node_exporter recovered, switching back from the internal collectors")
+		w.fallback = false
+		w.setSource()
+	}
+
+	w.emit(metricFamilies)
+}
+
+func (w *NodeExporterWatch) scrape(ctx context.Context) ([]*dto.MetricFamily, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/plain")
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return openmetrics.ParsePEF(resp.Body, w)
+}
+
+// emit ships the scraped families under the collector they are mapped to.
+func (w *NodeExporterWatch) emit(metricFamilies []*dto.MetricFamily) {
+	setDTOMetriFamilyTimestamp(timesync.Now(), metricFamilies...)
+
+	// map iteration order is random, keep emissions stable
+	sort.Slice(metricFamilies, func(i, j int) bool {
+		return metricFamilies[i].GetName() < metricFamilies[j].GetName()
+	})
+
+	for _, metricFam := range metricFamilies {
+		mapped := w.families[metricFam.GetName()]
+
+		openMetricFam, err := dtoToOpenMetrics(metricFam)
+		if err != nil {
+			w.Log.Errorw("failed to convert metric to openmetrics", "family", metricFam.GetName(), zap.Error(err))
+
+			continue
+		}
+		openMetricFam.Name = mapped.name
+
+		w.Emit(&model.Message{
+			Name:  string(mapped.collector),
+			Value: &model.Message_MetricFamily{MetricFamily: openMetricFam},
+		})
+	}
+}
+
+// gatherFallback gathers and ships the internal collectors replaced by
+// the mappings.
+func (w *NodeExporterWatch) gatherFallback() {
+	types := make([]string, 0, len(w.Fallback))
+	for wt := range w.Fallback {
+		types = append(types, string(wt))
+	}
+	sort.Strings(types)
+
+	for _, wt := range types {
+		metricFamilies, err := w.Fallback[global.WatchType(wt)].Gather()
+		if err != nil {
+			w.Log.Errorw("failed to gather fallback collector", "collector", wt, zap.Error(err))
+			w.ReportError(err)
+
+			continue
+		}
+		setDTOMetriFamilyTimestamp(timesync.Now(), metricFamilies...)
+
+		for _, metricFam := range metricFamilies {
+			openMetricFam, err := dtoToOpenMetrics(metricFam)
+			if err != nil {
+				w.Log.Errorw("failed to convert metric to openmetrics", "family", metricFam.GetName(), zap.Error(err))
+
+				continue
+			}
+
+			w.Emit(&model.Message{
+				Name:  wt,
+				Value: &model.Message_MetricFamily{MetricFamily: openMetricFam},
+			})
+		}
+	}
+}
+
+func (w *NodeExporterWatch) setSource() {
+	active, inactive := NodeExporterSource, InternalSource
+	if w.fallback {
+		active, inactive = inactive, active
+	}
+
+	nodeExporterSource.WithLabelValues(active).Set(1)
+	nodeExporterSource.WithLabelValues(inactive).Set(0)
+}
@@ -0,0 +1,155 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"agent/api/v1/model"
+	"agent/internal/pkg/global"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+const nodeExporterTestExposition = `# HELP node_load1 1m load average.
+# TYPE node_load1 gauge
+node_load1 0.21
+# HELP node_memory_MemAvailable_bytes Memory information field MemAvailable_bytes.
+# TYPE node_memory_MemAvailable_bytes gauge
+node_memory_MemAvailable_bytes 1.2e+09
+# HELP node_scrape_collector_success node_exporter: Whether a collector succeeded.
+# TYPE node_scrape_collector_success gauge
+node_scrape_collector_success{collector="cpu"} 1
+`
+
+func newTestNodeExporterWatch(t *testing.T, failing *int32) (*NodeExporterWatch, chan interface{}) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+		fmt.Fprint(w, nodeExporterTestExposition)
+	}))
+	t.Cleanup(ts.Close)
+
+	fallback := prometheus.NewPedanticRegistry()
+	load := prometheus.NewGauge(prometheus.GaugeOpts{Name: "node_load1", Help: "1m load average."})
+	load.Set(0.5)
+	fallback.MustRegister(load)
+
+	w, err := NewNodeExporterWatch(NodeExporterWatchConf{
+		URL:           ts.URL,
+		FallbackAfter: 2,
+		Mappings: []global.NodeExporterMapping{
+			{Collector: "prometheus.proc.loadavg", Families: []string{"node_load1"}},
+			{
+				Collector: "prometheus.proc.meminfo",
+				Families:  []string{"node_memory_MemAvailable_bytes"},
+				Rename:    map[string]string{"node_memory_MemAvailable_bytes": "node_memory_available_bytes"},
+			},
+		},
+		Fallback: map[global.WatchType]prometheus.Gatherer{"prometheus.proc.loadavg": fallback},
+	})
+	require.NoError(t, err)
+
+	emitch := make(chan interface{}, 10)
+	w.Subscribe(emitch)
+	w.setSource()
+
+	return w, emitch
+}
+
+// drainNodeExporterEmissions returns the emitted families by message name.
+func drainNodeExporterEmissions(t *testing.T, emitch chan interface{}) map[string][]*model.MetricFamily {
+	got := map[string][]*model.MetricFamily{}
+	for {
+		select {
+		case msg := <-emitch:
+			m, ok := msg.(*model.Message)
+			require.True(t, ok)
+			got[m.Name] = append(got[m.Name], m.GetMetricFamily())
+		default:
+			return got
+		}
+	}
+}
+
+func requireNodeExporterSource(t *testing.T, source string) {
+	for _, s := range []string{NodeExporterSource, InternalSource} {
+		exp := 0.0
+		if s == source {
+			exp = 1
+		}
+		require.Equal(t, exp, testutil.ToFloat64(nodeExporterSource.WithLabelValues(s)), s)
+	}
+}
+
+func TestNodeExporterWatch(t *testing.T) {
+	var failing int32
+	w, emitch := newTestNodeExporterWatch(t, &failing)
+	ctx := context.Background()
+
+	w.collect(ctx)
+	got := drainNodeExporterEmissions(t, emitch)
+	require.Len(t, got, 2)
+	require.Len(t, got["prometheus.proc.loadavg"], 1)
+	require.Equal(t, "node_load1", got["prometheus.proc.loadavg"][0].Name)
+	require.Equal(t, 0.21, got["prometheus.proc.loadavg"][0].Metrics[0].MetricPoints[0].GetGaugeValue().GetDoubleValue())
+	require.Len(t, got["prometheus.proc.meminfo"], 1)
+	require.Equal(t, "node_memory_available_bytes", got["prometheus.proc.meminfo"][0].Name)
+	requireNodeExporterSource(t, NodeExporterSource)
+
+	// below the fallback threshold nothing is shipped
+	atomic.StoreInt32(&failing, 1)
+	w.collect(ctx)
+	require.Empty(t, drainNodeExporterEmissions(t, emitch))
+	requireNodeExporterSource(t, NodeExporterSource)
+
+	// falls back to the internal collectors
+	w.collect(ctx)
+	got = drainNodeExporterEmissions(t, emitch)
+	require.Len(t, got, 1)
+	require.Equal(t, 0.5, got["prometheus.proc.loadavg"][0].Metrics[0].MetricPoints[0].GetGaugeValue().GetDoubleValue())
+	requireNodeExporterSource(t, InternalSource)
+
+	// and switches back once node_exporter recovers
+	atomic.StoreInt32(&failing, 0)
+	w.collect(ctx)
+	got = drainNodeExporterEmissions(t, emitch)
+	require.Len(t, got, 2)
+	require.Equal(t, 0.21, got["prometheus.proc.loadavg"][0].Metrics[0].MetricPoints[0].GetGaugeValue().GetDoubleValue())
+	requireNodeExporterSource(t, NodeExporterSource)
+}
+
+func TestNewNodeExporterWatch_Invalid(t *testing.T) {
+	for name, mappings := range map[string][]global.NodeExporterMapping{
+		"no mapping":      nil,
+		"not a collector": {{Collector: "influx", Families: []string{"node_load1"}}},
+		"mapped twice": {
+			{Collector: "prometheus.proc.loadavg", Families: []string{"node_load1"}},
+			{Collector: "prometheus.proc.cpu", Families: []string{"node_load1"}},
+		},
+	} {
+		_, err := NewNodeExporterWatch(NodeExporterWatchConf{URL: "http://127.0.0.1:9100/metrics", Mappings: mappings})
+		require.Error(t, err, name)
+	}
+}
@@ -16,11 +16,14 @@ package watch
 import (
 	"bytes"
 	"strings"
+	"time"
 
 	"agent/api/v1/model"
+	"agent/internal/pkg/global"
 	"agent/pkg/parse/openmetrics"
 	"agent/pkg/timesync"
 
+	dto "github.com/prometheus/client_model/go"
 	"go.uber.org/zap"
 )
 
@@ -38,8 +41,20 @@ type PEFWatch struct {
 type PEFWatchConf struct {
 	// Filter to fetch subset of metrics.
 	Filter *openmetrics.PEFFilter
+
+	// LastKnownGood optional cache of node gauges served while
+	// the node is down.
+	LastKnownGood *LastKnownGood
+
+	// Interval expected interval between two scrapes, required
+	// if LastKnownGood is set.
+	Interval time.Duration
 }
 
+// lastKnownGoodPersistInterval min interval between two writes
+// of the last-known-good cache.
+const lastKnownGoodPersistInterval = time.Minute
+
 // NewPEFWatch PEFWatch constructor.
 func NewPEFWatch(conf PEFWatchConf, httpWatch Watcher) *PEFWatch {
 	p := &PEFWatch{
@@ -49,6 +64,8 @@ func NewPEFWatch(conf PEFWatchConf, httpWatch Watcher) *PEFWatch {
 		httpDataCh:   make(chan interface{}, 10),
 	}
 
+	p.Name = "pef"
+
 	return p
 }
 
@@ -67,22 +84,42 @@ func (p *PEFWatch) StartUnsafe() {
 func (p *PEFWatch) parseAndEmit() {
 	defer p.wg.Done()
 
+	var staleTick <-chan time.Time
+	if p.LastKnownGood != nil {
+		ticker := time.NewTicker(p.Interval)
+		defer ticker.Stop()
+		staleTick = ticker.C
+	}
+	lastScrape, lastPersist := timesync.Now(), time.Time{}
+
 	for {
 		select {
 		case r := <-p.httpDataCh:
+			if _, ok := r.(*HTTPError); ok {
+				// already logged, the missed scrape is handled below
+				continue
+			}
+
 			pefData, ok := r.([]byte)
 			if !ok {
 				p.Log.Error("type assertion failed")
 				continue
 			}
 
-			pefReader := bytes.NewBuffer(pefData)
-			mf, err := openmetrics.ParsePEF(pefReader, p.Filter)
+			var (
+				mf  []*dto.MetricFamily
+				err error
+			)
+			global.AccountAllocations("pef", func() {
+				mf, err = openmetrics.ParsePEF(bytes.NewBuffer(pefData), p.Filter)
+			})
 			if err != nil {
 				p.Log.Errorw("failed to parse PEF metrics", zap.Error(err))
+				p.ReportError(err)
 				continue
 			}
-			setDTOMetriFamilyTimestamp(timesync.Now(), mf...)
+			lastScrape = timesync.Now()
+			setDTOMetriFamilyTimestamp(lastScrape, mf...)
 
 			for _, family := range mf {
 				openMetricFam, err := dtoToOpenMetrics(family)
@@ -92,18 +129,49 @@ func (p *PEFWatch) parseAndEmit() {
 					continue
 				}
 
-				msg := &model.Message{
-					Name:  "pef." + strings.ToLower(*family.Name),
-					Value: &model.Message_MetricFamily{MetricFamily: openMetricFam},
+				if p.LastKnownGood != nil {
+					p.LastKnownGood.Observe(openMetricFam, lastScrape)
+				}
+				p.emitMetricFamily(openMetricFam)
+			}
+		case <-staleTick:
+			now := timesync.Now()
+
+			// the node missed at least one scrape, serve the cached gauges
+			if now.Sub(lastScrape) > 2*p.Interval {
+				for _, family := range p.LastKnownGood.Stale(now) {
+					p.emitMetricFamily(family)
 				}
-				p.Emit(msg)
+			}
+
+			if now.Sub(lastPersist) >= lastKnownGoodPersistInterval {
+				p.persistLastKnownGood()
+				lastPersist = now
 			}
 		case <-p.StopKey:
+			if p.LastKnownGood != nil {
+				p.persistLastKnownGood()
+			}
+
 			return
 		}
 	}
 }
 
+func (p *PEFWatch) emitMetricFamily(family *model.MetricFamily) {
+	msg := &model.Message{
+		Name:  "pef." + strings.ToLower(family.Name),
+		Value: &model.Message_MetricFamily{MetricFamily: family},
+	}
+	p.Emit(msg)
+}
+
+func (p *PEFWatch) persistLastKnownGood() {
+	if err := p.LastKnownGood.Persist(); err != nil {
+		p.Log.Warnw("failed to persist last-known-good cache", zap.Error(err))
+	}
+}
+
 // Stop stops the watch
 func (p *PEFWatch) Stop() {
 	p.httpWatch.Stop()
@@ -0,0 +1,280 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"agent/api/v1/model"
+
+	"github.com/prometheus/procfs"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrPIDWatchConf pid watch configuration error.
+	ErrPIDWatchConf = errors.New("pid watch configuration error")
+
+	errProcessNotRunning = errors.New("process not running")
+	errProcessReplaced   = errors.New("process exited, its pid was reused")
+)
+
+const defaultPIDInterval = 5 * time.Second
+
+// ProcessStats the liveness and resource usage of a process, emitted by
+// PIDWatch.
+type ProcessStats struct {
+	// PID the process id, the last known one if the process isn't alive.
+	PID   int
+	Alive bool
+
+	// StartTime when the process started, zero if unknown.
+	StartTime time.Time
+
+	// RSS resident memory in bytes.
+	RSS uint64
+
+	// CPUTime user and system CPU time in seconds.
+	CPUTime float64
+
+	// FDs open file descriptors, -1 if they can't be listed, i.e. the
+	// process belongs to another user.
+	FDs     int
+	Threads int
+
+	Time time.Time
+}
+
+// PIDWatchConf PIDWatch configuration struct.
+type PIDWatchConf struct {
+	// PID the process to watch, if no Resolver is set.
+	PID int
+
+	// Resolver returns the pid of the process to watch, i.e. from the node
+	// discovery. It is called again while the process isn't alive.
+	Resolver func() (int, error)
+
+	// Interval how often the process is checked. Defaults to 5s.
+	Interval time.Duration
+
+	// ProcPath procfs mount point. Defaults to /proc.
+	ProcPath string
+}
+
+// PIDWatch implements Watcher interface.
+// Checks a process through procfs on an interval and emits its
+// ProcessStats. An agent.node.down event is emitted when the process dies,
+// an agent.node.up one when it is alive again, with its new pid if resolved
+// again.
+//
+// The process start time is checked along the pid, so that a process
+// reusing the pid of the dead one isn't mistaken for it.
+type PIDWatch struct {
+	PIDWatchConf
+	Watch
+
+	fs procfs.FS
+
+	// pid, start and status are owned by the poll goroutine while running.
+	// pid the alive process, 0 if none.
+	pid int
+	// start the start time of the last process, in clock ticks after boot.
+	start uint64
+	// lastPID the last alive process.
+	lastPID int
+	// status the last node event emitted, empty if none.
+	status string
+}
+
+// NewPIDWatch PIDWatch constructor, returns an error if neither a pid nor a
+// resolver is configured.
+func NewPIDWatch(conf PIDWatchConf) (*PIDWatch, error) {
+	if conf.PID < 1 && conf.Resolver == nil {
+		return nil, fmt.Errorf("%w: pid or resolver is required", ErrPIDWatchConf)
+	}
+
+	w := new(PIDWatch)
+	w.Watch = NewWatch()
+	w.Name = "pid"
+	w.PIDWatchConf = conf
+	w.lastPID = conf.PID
+
+	if w.Interval < 1 {
+		w.Interval = defaultPIDInterval
+	}
+
+	if w.ProcPath == "" {
+		w.ProcPath = procfs.DefaultMountPoint
+	}
+
+	fs, err := procfs.NewFS(w.ProcPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPIDWatchConf, err)
+	}
+	w.fs = fs
+
+	return w, nil
+}
+
+// StartUnsafe starts the goroutine checking the process.
+func (w *PIDWatch) StartUnsafe() {
+	w.Watch.StartUnsafe()
+
+	w.wg.Add(1)
+	go w.pollLoop(w.runContext(), w.StopKey)
+}
+
+// pollLoop checks the process right away and then on every interval, until
+// ctx is done or stop, the StopKey of the run it was started for, is closed.
+func (w *PIDWatch) pollLoop(ctx context.Context, stop <-chan bool) {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		w.poll()
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// poll emits the stats of the watched process, looking it up again if it
+// isn't alive.
+func (w *PIDWatch) poll() {
+	if w.pid != 0 {
+		proc, stat, err := w.stat(w.pid)
+		if err == nil && stat.Starttime != w.start {
+			err = errProcessReplaced
+		}
+
+		if err == nil {
+			w.Emit(w.processStats(proc, stat))
+
+			return
+		}
+
+		w.Log.Infow("watched process is gone", "pid", w.pid, zap.Error(err))
+		w.pid = 0
+		w.emitStatus(model.AgentNodeDownName)
+	}
+
+	proc, stat, err := w.find()
+	if err != nil {
+		w.Log.Debugw("watched process not found", zap.Error(err))
+		w.emitStatus(model.AgentNodeDownName)
+		w.Emit(ProcessStats{PID: w.lastPID, Time: time.Now()})
+
+		return
+	}
+
+	w.pid, w.start, w.lastPID = proc.PID, stat.Starttime, proc.PID
+	w.Log.Infow("watched process found", "pid", w.pid)
+	w.emitStatus(model.AgentNodeUpName)
+	w.Emit(w.processStats(proc, stat))
+}
+
+// find looks up the process to watch.
+func (w *PIDWatch) find() (procfs.Proc, procfs.ProcStat, error) {
+	pid := w.PID
+	if w.Resolver != nil {
+		var err error
+		if pid, err = w.Resolver(); err != nil {
+			return procfs.Proc{}, procfs.ProcStat{}, err
+		}
+	}
+
+	proc, stat, err := w.stat(pid)
+	if err != nil {
+		return procfs.Proc{}, procfs.ProcStat{}, err
+	}
+
+	// a configured pid is only ever the process first seen with it
+	if w.Resolver == nil && w.start != 0 && stat.Starttime != w.start {
+		return procfs.Proc{}, procfs.ProcStat{}, errProcessReplaced
+	}
+
+	return proc, stat, nil
+}
+
+// stat reads the stat of a running process.
+func (w *PIDWatch) stat(pid int) (procfs.Proc, procfs.ProcStat, error) {
+	proc, err := w.fs.Proc(pid)
+	if err != nil {
+		return procfs.Proc{}, procfs.ProcStat{}, err
+	}
+
+	stat, err := proc.Stat()
+	if err != nil {
+		return procfs.Proc{}, procfs.ProcStat{}, err
+	}
+
+	switch stat.State {
+	case "Z", "X":
+		// exited, not reaped yet
+		return procfs.Proc{}, procfs.ProcStat{}, errProcessNotRunning
+	}
+
+	return proc, stat, nil
+}
+
+func (w *PIDWatch) processStats(proc procfs.Proc, stat procfs.ProcStat) ProcessStats {
+	stats := ProcessStats{
+		PID:     proc.PID,
+		Alive:   true,
+		RSS:     uint64(stat.ResidentMemory()),
+		CPUTime: stat.CPUTime(),
+		Threads: stat.NumThreads,
+		Time:    time.Now(),
+	}
+
+	if start, err := stat.StartTime(); err == nil {
+		sec, frac := math.Modf(start)
+		stats.StartTime = time.Unix(int64(sec), int64(frac*1e9))
+	}
+
+	fds, err := proc.FileDescriptorsLen()
+	if err != nil {
+		w.Log.Debugw("failed to count process file descriptors", "pid", proc.PID, zap.Error(err))
+		fds = -1
+	}
+	stats.FDs = fds
+
+	return stats
+}
+
+// emitStatus emits the agent.node.up or agent.node.down event name, if it
+// isn't the last one emitted.
+func (w *PIDWatch) emitStatus(name string) {
+	if w.status == name {
+		return
+	}
+	w.status = name
+
+	ctx := map[string]interface{}{}
+	if w.lastPID != 0 {
+		ctx[model.PIDKey] = w.lastPID
+	}
+	w.emitAgentNodeEventWithCtx(name, ctx)
+}
@@ -0,0 +1,174 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"agent/api/v1/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testBootTime = 1664884800
+
+// writeProcStat writes the stat file of a fake procfs process, with fds
+// open file descriptors. The start time is in clock ticks after boot.
+func writeProcStat(t *testing.T, procPath string, pid int, state string, start, utime, stime uint64, threads, rss, fds int) {
+	t.Helper()
+
+	dir := filepath.Join(procPath, strconv.Itoa(pid))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "fd"), 0o755))
+	for i := 0; i < fds; i++ {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "fd", strconv.Itoa(i)), nil, 0o600))
+	}
+
+	stat := fmt.Sprintf("%d (algod) %s 1 %d %d 0 -1 0 0 0 0 0 %d %d 0 0 20 0 %d 0 %d 1000 %d%s\n",
+		pid, state, pid, pid, utime, stime, threads, start, rss, strings.Repeat(" 0", 19))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0o600))
+}
+
+func newProcPath(t *testing.T) string {
+	t.Helper()
+
+	procPath := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(procPath, "stat"), []byte(fmt.Sprintf("btime %d\n", testBootTime)), 0o600))
+
+	return procPath
+}
+
+func startPIDWatch(t *testing.T, conf PIDWatchConf) chan interface{} {
+	t.Helper()
+
+	conf.Interval = 10 * time.Millisecond
+	w, err := NewPIDWatch(conf)
+	require.NoError(t, err)
+
+	ch := make(chan interface{}, 1000)
+	w.Subscribe(ch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	StartWithContext(ctx, w)
+	t.Cleanup(func() {
+		cancel()
+		w.Wait()
+	})
+
+	return ch
+}
+
+// requirePIDEvent skips the stats until the node event name and returns the
+// stats following it.
+func requirePIDEvent(t *testing.T, ch chan interface{}, name string, pid int) ProcessStats {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-ch:
+			message, ok := msg.(*model.Message)
+			if !ok {
+				continue
+			}
+			require.Equal(t, name, message.Name)
+			require.Equal(t, float64(pid), message.GetEvent().Values.AsMap()[model.PIDKey])
+
+			stats, ok := (<-ch).(ProcessStats)
+			require.True(t, ok)
+
+			return stats
+		case <-timeout:
+			t.Fatalf("no %s event", name)
+		}
+	}
+}
+
+func TestNewPIDWatch_Invalid(t *testing.T) {
+	_, err := NewPIDWatch(PIDWatchConf{})
+	require.ErrorIs(t, err, ErrPIDWatchConf)
+}
+
+func TestPIDWatch_Resolver(t *testing.T) {
+	procPath := newProcPath(t)
+	writeProcStat(t, procPath, 100, "S", 500, 150, 50, 8, 256, 3)
+
+	var pid int64 = 100
+	ch := startPIDWatch(t, PIDWatchConf{
+		Resolver: func() (int, error) { return int(atomic.LoadInt64(&pid)), nil },
+		ProcPath: procPath,
+	})
+
+	stats := requirePIDEvent(t, ch, model.AgentNodeUpName, 100)
+	require.True(t, stats.Alive)
+	require.Equal(t, 100, stats.PID)
+	require.Equal(t, uint64(256*os.Getpagesize()), stats.RSS)
+	require.Equal(t, 2.0, stats.CPUTime)
+	require.Equal(t, 8, stats.Threads)
+	require.Equal(t, 3, stats.FDs)
+	require.Equal(t, time.Unix(testBootTime+5, 0), stats.StartTime)
+
+	// exited, not reaped yet
+	writeProcStat(t, procPath, 100, "Z", 500, 150, 50, 1, 0, 0)
+	stats = requirePIDEvent(t, ch, model.AgentNodeDownName, 100)
+	require.False(t, stats.Alive)
+	require.Equal(t, 100, stats.PID)
+
+	// restarted with a new pid
+	writeProcStat(t, procPath, 200, "S", 900, 0, 0, 4, 128, 1)
+	atomic.StoreInt64(&pid, 200)
+	stats = requirePIDEvent(t, ch, model.AgentNodeUpName, 200)
+	require.True(t, stats.Alive)
+	require.Equal(t, 200, stats.PID)
+	require.Equal(t, 4, stats.Threads)
+}
+
+func TestPIDWatch_Reused(t *testing.T) {
+	procPath := newProcPath(t)
+	writeProcStat(t, procPath, 300, "S", 500, 0, 0, 1, 1, 0)
+
+	ch := startPIDWatch(t, PIDWatchConf{PID: 300, ProcPath: procPath})
+	stats := requirePIDEvent(t, ch, model.AgentNodeUpName, 300)
+	require.True(t, stats.Alive)
+
+	// another process started with the same pid
+	writeProcStat(t, procPath, 300, "S", 900, 0, 0, 1, 1, 0)
+	stats = requirePIDEvent(t, ch, model.AgentNodeDownName, 300)
+	require.False(t, stats.Alive)
+
+	for i := 0; i < 5; i++ {
+		stats, ok := (<-ch).(ProcessStats)
+		require.True(t, ok)
+		require.False(t, stats.Alive)
+	}
+}
+
+func TestPIDWatch_Self(t *testing.T) {
+	ch := startPIDWatch(t, PIDWatchConf{PID: os.Getpid()})
+
+	stats := requirePIDEvent(t, ch, model.AgentNodeUpName, os.Getpid())
+	require.True(t, stats.Alive)
+	require.NotZero(t, stats.RSS)
+	require.NotZero(t, stats.Threads)
+	require.Positive(t, stats.FDs)
+	require.WithinDuration(t, time.Now(), stats.StartTime, time.Hour)
+}
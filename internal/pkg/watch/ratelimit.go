@@ -0,0 +1,140 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"sync"
+	"time"
+
+	"agent/api/v1/model"
+	"agent/pkg/timesync"
+
+	"go.uber.org/zap"
+)
+
+const defaultRateLimitSummaryInterval = time.Minute
+
+// RateLimit token bucket limiting the messages emitted, see
+// Watch.SetRateLimit and SubOptions.RateLimit. The messages over the limit
+// are suppressed.
+type RateLimit struct {
+	// Rate messages per second let through on average.
+	Rate float64
+
+	// Burst messages let through at once after a quiet period. Defaults to
+	// 1.
+	Burst int
+
+	// SummaryInterval how often, at most, the number of suppressed messages
+	// is emitted as an agent.watch.suppressed event. The event is emitted
+	// along with the following message, suppressed or not. Defaults to 1m.
+	SummaryInterval time.Duration
+}
+
+// rateLimiter a RateLimit bucket, implemented as a virtual scheduler: a
+// message is let through if it doesn't arrive more than Burst-1 emission
+// intervals ahead of its theoretical arrival time.
+type rateLimiter struct {
+	RateLimit
+
+	clock clock
+
+	// interval time a token takes to refill.
+	interval time.Duration
+
+	mu sync.Mutex
+
+	// tat theoretical arrival time of the next message.
+	tat time.Time
+
+	// suppressed messages suppressed since summaryAt, the time of the last
+	// summary or of the first message.
+	suppressed uint64
+	summaryAt  time.Time
+}
+
+// newRateLimiter returns nil if limit has no rate, the messages aren't
+// limited.
+func newRateLimiter(limit *RateLimit) *rateLimiter {
+	if limit == nil || limit.Rate <= 0 {
+		return nil
+	}
+
+	l := &rateLimiter{
+		RateLimit: *limit,
+		clock:     realClock{},
+		interval:  time.Duration(float64(time.Second) / limit.Rate),
+	}
+	if l.Burst < 1 {
+		l.Burst = 1
+	}
+	if l.SummaryInterval < 1 {
+		l.SummaryInterval = defaultRateLimitSummaryInterval
+	}
+
+	return l
+}
+
+// allow returns whether a message may be emitted, and the summary to emit
+// before it if one is due.
+func (l *rateLimiter) allow() (bool, *model.Message) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if l.summaryAt.IsZero() {
+		l.summaryAt = now
+	}
+
+	tat := l.tat
+	if tat.Before(now) {
+		tat = now
+	}
+
+	allowed := tat.Sub(now) <= time.Duration(l.Burst-1)*l.interval
+	if allowed {
+		l.tat = tat.Add(l.interval)
+	} else {
+		l.suppressed++
+	}
+
+	period := now.Sub(l.summaryAt)
+	if l.suppressed == 0 || period < l.SummaryInterval {
+		return allowed, nil
+	}
+
+	summary := l.summary(period)
+	l.suppressed, l.summaryAt = 0, now
+
+	return allowed, summary
+}
+
+func (l *rateLimiter) summary(period time.Duration) *model.Message {
+	ctx := map[string]interface{}{
+		model.SuppressedKey: l.suppressed,
+		model.PeriodKey:     period.String(),
+	}
+
+	ev, err := model.NewWithCtx(ctx, model.AgentWatchSuppressedName, timesync.Now())
+	if err != nil {
+		zap.S().Errorw("error creating event", zap.Error(err))
+
+		return nil
+	}
+
+	return &model.Message{
+		Name:  ev.Name,
+		Value: &model.Message_Event{Event: ev},
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"testing"
+	"time"
+
+	"agent/api/v1/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+// drainRateLimited splits the messages received into the emitted ones and
+// the suppressed counts of the summaries.
+func drainRateLimited(t *testing.T, ch chan interface{}) ([]interface{}, []uint64) {
+	t.Helper()
+
+	var (
+		msgs      []interface{}
+		summaries []uint64
+	)
+	for len(ch) > 0 {
+		msg := <-ch
+
+		m, ok := msg.(*model.Message)
+		if !ok {
+			msgs = append(msgs, msg)
+
+			continue
+		}
+
+		ev := m.GetEvent()
+		require.Equal(t, model.AgentWatchSuppressedName, ev.Name)
+		values := ev.Values.AsMap()
+
+		period, err := time.ParseDuration(values[model.PeriodKey].(string))
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, period, time.Second)
+
+		summaries = append(summaries, uint64(values[model.SuppressedKey].(float64)))
+	}
+
+	return msgs, summaries
+}
+
+func sumCounts(counts []uint64) uint64 {
+	var total uint64
+	for _, n := range counts {
+		total += n
+	}
+
+	return total
+}
+
+func TestWatch_RateLimit(t *testing.T) {
+	w := NewWatch()
+	w.SetRateLimit(RateLimit{Rate: 100, Burst: 10, SummaryInterval: time.Second})
+	clock := newFakeClock(time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC))
+	w.limiter.clock = clock
+
+	ch := make(chan interface{}, 20000)
+	w.Subscribe(ch)
+
+	// 10k emissions over 10s
+	for i := 0; i < 10000; i++ {
+		w.Emit(i)
+		clock.advance(time.Millisecond)
+	}
+	msgs, summaries := drainRateLimited(t, ch)
+
+	// the burst, then one every 10ms
+	require.Len(t, msgs, 10+999)
+	require.Equal(t, []interface{}{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 20}, msgs[:12])
+	require.Equal(t, uint64(10000-len(msgs)), w.Suppressed())
+
+	// one summary per second, the last one with the following message
+	require.Len(t, summaries, 9)
+	clock.advance(time.Second)
+	w.Emit("after")
+	after, last := drainRateLimited(t, ch)
+	require.Equal(t, []interface{}{"after"}, after)
+	require.Len(t, last, 1)
+	require.Equal(t, w.Suppressed(), sumCounts(summaries)+sumCounts(last))
+}
+
+func TestSubscription_RateLimit(t *testing.T) {
+	w := NewWatch()
+
+	limited := make(chan interface{}, 2000)
+	w.SubscribeWithOptions(limited, SubOptions{RateLimit: &RateLimit{Rate: 100, SummaryInterval: time.Second}})
+	clock := newFakeClock(time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC))
+	w.listeners[0].limiter.clock = clock
+
+	unlimited := make(chan interface{}, 2000)
+	w.Subscribe(unlimited)
+
+	for i := 0; i < 1000; i++ {
+		w.Emit(i)
+	}
+	clock.advance(time.Second)
+	w.Emit(1000)
+
+	// the other subscribers aren't limited
+	require.Len(t, unlimited, 1001)
+
+	msgs, summaries := drainRateLimited(t, limited)
+	require.Equal(t, []interface{}{0, 1000}, msgs)
+	require.Equal(t, []uint64{999}, summaries)
+	require.Equal(t, uint64(999), w.Suppressed())
+}
+
+func TestWatch_RateLimit_Unset(t *testing.T) {
+	w := NewWatch()
+	w.SetRateLimit(RateLimit{Rate: 10})
+	require.NotNil(t, w.limiter)
+
+	w.SetRateLimit(RateLimit{})
+	require.Nil(t, w.limiter)
+
+	ch := make(chan interface{}, 100)
+	w.Subscribe(ch)
+	for i := 0; i < 100; i++ {
+		w.Emit(i)
+	}
+	require.Len(t, ch, 100)
+	require.Zero(t, w.Suppressed())
+}
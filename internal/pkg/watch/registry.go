@@ -1,8 +1,12 @@
 package watch
 
 import (
+	"context"
 	"reflect"
 	"sync"
+	"time"
+
+	"agent/internal/pkg/global"
 
 	"go.uber.org/zap"
 )
@@ -10,8 +14,13 @@ import (
 // DefaultWatchRegistry is the default watcher registry used by the agent.
 var DefaultWatchRegistry WatchersRegisterer
 
+// DefaultWatcherStopTimeout how long Registry.Stop waits for a watcher to
+// stop by default.
+const DefaultWatcherStopTimeout = 5 * time.Second
+
 func init() {
 	defaultWatchRegistrar := &Registry{
+		ctx:        context.Background(),
 		watch:      []*WatcherInstance{},
 		Mutex:      &sync.Mutex{},
 		watcherMap: make(map[Watcher]struct{}),
@@ -23,7 +32,11 @@ func init() {
 // WatchersRegisterer is an interface for enabling agent watchers.
 type WatchersRegisterer interface {
 	Register(w ...Watcher) error
+	RegisterWithCadence(cadence time.Duration, w ...Watcher) error
+	Stalled(now time.Time) []StalledWatcher
+	Health() []WatcherHealth
 	Start(ch ...chan<- interface{}) error
+	StartWithContext(ctx context.Context, ch ...chan<- interface{}) error
 	RegisterAndStart(w Watcher, ch ...chan<- interface{}) error
 	Stop()
 	Wait()
@@ -32,33 +45,73 @@ type WatchersRegisterer interface {
 // Registry is an implementation of WatchersRegisterer.
 // It controls the agent watchers' life cycle.
 type Registry struct {
+	// ctx context the watchers are started with.
+	ctx   context.Context
 	watch []*WatcherInstance
 
 	// watcherMap is used to track registered watchers
 	// and ensure idempotency when calling register
 	watcherMap map[Watcher]struct{}
 	*sync.Mutex
+
+	// StopTimeout how long Stop waits for each watcher, defaults to
+	// DefaultWatcherStopTimeout.
+	StopTimeout time.Duration
 }
 
 // WatcherInstance describes a state of a single
 // watcher that's inside the registry.
 type WatcherInstance struct {
-	started bool
-	watcher Watcher
+	started   bool
+	startedAt time.Time
+	watcher   Watcher
+
+	// subscribed channels the watcher emits to, kept across restarts.
+	subscribed map[chan<- interface{}]struct{}
+
+	// cadence max expected period between two emissions of the watcher,
+	// NoCadence exempts it from the watchdog.
+	cadence time.Duration
+
+	// errors reported by the watcher, guarded by the mutex.
+	errors      uint64
+	lastError   error
+	lastErrorAt time.Time
 	*sync.Mutex
 }
 
+// onError records an error reported by the watcher.
+func (w *WatcherInstance) onError(err error) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.errors++
+	w.lastError = err
+	w.lastErrorAt = time.Now()
+}
+
 // Register registrers one or more watchers.
 // Register is idempotent - trying to register
 // an already registered watcher will be a no-op.
+// Watchers are registered with NoCadence.
 func (r *Registry) Register(w ...Watcher) error {
+	return r.RegisterWithCadence(NoCadence, w...)
+}
+
+// RegisterWithCadence registers one or more watchers that are expected to
+// emit at least once every cadence, see Watchdog.
+func (r *Registry) RegisterWithCadence(cadence time.Duration, w ...Watcher) error {
 	r.Lock()
 	defer r.Unlock()
 	for _, watcher := range w {
-		_, err := r.register(watcher)
+		instance, err := r.register(watcher)
 		if err != nil {
 			return err
 		}
+
+		if instance != nil {
+			instance.cadence = cadence
+		}
 	}
 
 	return nil
@@ -71,9 +124,11 @@ func (r *Registry) register(watcher Watcher) (*WatcherInstance, error) {
 		return nil, nil
 	}
 	instance := &WatcherInstance{
-		watcher: watcher,
-		Mutex:   &sync.Mutex{},
+		watcher:    watcher,
+		subscribed: map[chan<- interface{}]struct{}{},
+		Mutex:      &sync.Mutex{},
 	}
+	watcher.OnError(instance.onError)
 	r.watch = append(r.watch, instance)
 	r.watcherMap[watcher] = struct{}{}
 	return instance, nil
@@ -89,47 +144,154 @@ func (r *Registry) RegisterAndStart(w Watcher, ch ...chan<- interface{}) error {
 		return err
 	}
 
-	return start(ch, instance)
+	return r.start(ch, instance)
 }
 
 // Start starts a watch by subscribing to one or more channels
 // for emitting collected data.
 // Calling Start multiple times will start watchers that haven't
 // been started, and will act as a no-op for already running watchers, even
-// if ch parameter is different.
+// if ch parameter is different. Watchers stopped by Stop are started again.
 func (r *Registry) Start(ch ...chan<- interface{}) error {
 	r.Lock()
 	defer r.Unlock()
-	return start(ch, r.watch...)
+	return r.start(ch, r.watch...)
 }
 
-func start(ch []chan<- interface{}, instances ...*WatcherInstance) error {
+// StartWithContext is like Start, the watchers are stopped when ctx is done.
+// ctx also applies to the watchers started afterwards by RegisterAndStart.
+func (r *Registry) StartWithContext(ctx context.Context, ch ...chan<- interface{}) error {
+	r.Lock()
+	defer r.Unlock()
+	r.ctx = ctx
+	return r.start(ch, r.watch...)
+}
+
+func (r *Registry) start(ch []chan<- interface{}, instances ...*WatcherInstance) error {
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
 	for _, w := range instances {
 		if w.started {
 			continue
 		}
 
 		for _, c := range ch {
+			if _, ok := w.subscribed[c]; ok {
+				continue
+			}
 			w.watcher.Subscribe(c)
+			w.subscribed[c] = struct{}{}
 		}
 
 		go func(w Watcher) {
-			Start(w)
+			defer global.ReportPanic()
+			StartWithContext(ctx, w)
 		}(w.watcher)
 		w.started = true
+		w.startedAt = time.Now()
 	}
 
 	return nil
 }
 
-// Stop stops all registered watches
-func (r *Registry) Stop() {
+// StalledWatcher a started watcher that has not emitted within its cadence.
+type StalledWatcher struct {
+	Watcher  Watcher
+	Cadence  time.Duration
+	LastEmit time.Time
+}
+
+// Stalled returns the started watchers with a cadence that have not
+// emitted within it, counting from their start if they never emitted.
+func (r *Registry) Stalled(now time.Time) []StalledWatcher {
 	r.Lock()
 	defer r.Unlock()
+
+	stalled := []StalledWatcher{}
 	for _, w := range r.watch {
-		w.watcher.Stop()
+		if !w.started || w.cadence == NoCadence {
+			continue
+		}
+
+		lastEmit := w.watcher.LastEmit()
+		since := lastEmit
+		if since.Before(w.startedAt) {
+			since = w.startedAt
+		}
+
+		if now.Sub(since) > w.cadence {
+			stalled = append(stalled, StalledWatcher{Watcher: w.watcher, Cadence: w.cadence, LastEmit: lastEmit})
+		}
+	}
+
+	return stalled
+}
+
+// WatcherHealth the errors reported by a registered watcher.
+type WatcherHealth struct {
+	Watcher Watcher
+
+	// Errors number of errors reported since the watcher was registered.
+	Errors      uint64
+	LastError   error
+	LastErrorAt time.Time
+
+	LastEmit time.Time
+}
+
+// Healthy whether the watcher emitted since its last error, if any.
+func (h WatcherHealth) Healthy() bool {
+	return h.LastErrorAt.IsZero() || h.LastEmit.After(h.LastErrorAt)
+}
+
+// Health returns the health of the registered watchers, in registration
+// order.
+func (r *Registry) Health() []WatcherHealth {
+	r.Lock()
+	defer r.Unlock()
+
+	health := make([]WatcherHealth, 0, len(r.watch))
+	for _, w := range r.watch {
+		w.Lock()
+		health = append(health, WatcherHealth{
+			Watcher:     w.watcher,
+			Errors:      w.errors,
+			LastError:   w.lastError,
+			LastErrorAt: w.lastErrorAt,
+			LastEmit:    w.watcher.LastEmit(),
+		})
+		w.Unlock()
+	}
+
+	return health
+}
+
+// Stop stops all registered watches, waiting up to StopTimeout for each of
+// them. The registry isn't locked while waiting, and a watcher that
+// doesn't stop in time is logged and left behind, so that it can't hold
+// up shutdown.
+func (r *Registry) Stop() {
+	r.Lock()
+	timeout := r.StopTimeout
+	if timeout <= 0 {
+		timeout = DefaultWatcherStopTimeout
+	}
+	watchers := make([]Watcher, 0, len(r.watch))
+	for _, w := range r.watch {
+		watchers = append(watchers, w.watcher)
 		w.started = false
 	}
+	r.Unlock()
+
+	for _, w := range watchers {
+		if err := StopWithTimeout(w, timeout); err != nil {
+			zap.S().Errorw("watcher did not stop in time, leaving it behind",
+				"type", reflect.TypeOf(w).String(), "timeout", timeout, zap.Error(err))
+		}
+	}
 }
 
 // Wait for all registered watches to finish
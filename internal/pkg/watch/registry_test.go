@@ -1,6 +1,7 @@
 package watch
 
 import (
+	"errors"
 	"sync"
 	"testing"
 	"time"
@@ -28,8 +29,7 @@ func TestRegistry_RegisterAndStart_Success(t *testing.T) {
 
 func TestRegistry_Register_MultipleCalls(t *testing.T) {
 	w := NewWatch()
-	w.listeners = make([]chan<- interface{}, 0)
-	w.listeners = append(w.listeners, make(chan<- interface{}))
+	w.Subscribe(make(chan<- interface{}))
 	registry := &Registry{
 		watch:      []*WatcherInstance{},
 		Mutex:      &sync.Mutex{},
@@ -74,3 +74,71 @@ func TestRegistry_Register_Regression(t *testing.T) {
 	require.NoError(t, err)
 	require.Len(t, registry.watch, 4)
 }
+
+func TestRegistry_Health(t *testing.T) {
+	registry := &Registry{
+		watch:      []*WatcherInstance{},
+		Mutex:      &sync.Mutex{},
+		watcherMap: make(map[Watcher]struct{}),
+	}
+
+	failing, idle := NewWatch(), NewWatch()
+	require.NoError(t, registry.Register(&failing, &idle))
+
+	errRead := errors.New("read failed")
+	failing.ReportError(errors.New("dial failed"))
+	failing.ReportError(errRead)
+
+	health := registry.Health()
+	require.Len(t, health, 2)
+	require.Equal(t, Watcher(&failing), health[0].Watcher)
+	require.Equal(t, uint64(2), health[0].Errors)
+	require.Equal(t, errRead, health[0].LastError)
+	require.False(t, health[0].Healthy())
+
+	require.Equal(t, uint64(0), health[1].Errors)
+	require.True(t, health[1].Healthy())
+
+	// recovered once it emits again
+	time.Sleep(time.Millisecond)
+	failing.Emit("line")
+	require.True(t, registry.Health()[0].Healthy())
+}
+
+func TestRegistry_StopTimeout(t *testing.T) {
+	registry := &Registry{
+		watch:       []*WatcherInstance{},
+		Mutex:       &sync.Mutex{},
+		watcherMap:  make(map[Watcher]struct{}),
+		StopTimeout: 50 * time.Millisecond,
+	}
+
+	// stuck ignores its StopKey
+	stuck := NewWatch()
+	release := make(chan struct{})
+	defer close(release)
+	stuck.Go(func() { <-release })
+
+	w := NewWatch()
+	require.NoError(t, registry.Register(&stuck, &w))
+	require.NoError(t, registry.Start())
+	require.Eventually(t, func() bool {
+		return stuck.Status().Running && w.Status().Running
+	}, time.Second, 10*time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		registry.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("registry stop waited for a stuck watcher")
+	}
+	require.False(t, w.Status().Running)
+
+	// the registry isn't left locked
+	require.Len(t, registry.Health(), 2)
+}
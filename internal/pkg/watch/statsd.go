@@ -0,0 +1,452 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultStatsDAddr          = "127.0.0.1:8125"
+	defaultStatsDFlushInterval = 10 * time.Second
+	defaultStatsDRetryIntv     = time.Second
+
+	// maxStatsDPacketBytes largest datagram read, longer ones are
+	// truncated.
+	maxStatsDPacketBytes = 64 << 10
+)
+
+var errStatsDMalformed = errors.New("malformed statsd line")
+
+// StatsDMetricType type of a StatsD metric.
+type StatsDMetricType string
+
+const (
+	// StatsDCounter summed over the flush interval, scaled by the sample
+	// rate.
+	StatsDCounter StatsDMetricType = "c"
+
+	// StatsDGauge last value, kept across flush intervals. Values with a
+	// sign are applied as deltas.
+	StatsDGauge StatsDMetricType = "g"
+
+	// StatsDTimer distribution of the values over the flush interval,
+	// histograms and distributions included.
+	StatsDTimer StatsDMetricType = "ms"
+)
+
+// StatsDWatchConf StatsDWatch configuration struct.
+type StatsDWatchConf struct {
+	// Addr UDP address to listen on. Defaults to 127.0.0.1:8125 unless
+	// UnixPath is set.
+	Addr string
+
+	// UnixPath Unix datagram socket to listen on, if set.
+	UnixPath string
+
+	// FlushInterval how often the aggregated values are emitted. Defaults
+	// to 10s.
+	FlushInterval time.Duration
+
+	// ReadBufferBytes the sockets receive buffer size, to absorb the bursts
+	// of packets. The OS default is kept if 0.
+	ReadBufferBytes int
+}
+
+// StatsDTimerStats the values of a timer over a flush interval.
+type StatsDTimerStats struct {
+	Count         int
+	Sum, Min, Max float64
+	Mean          float64
+	P50, P95, P99 float64
+}
+
+// StatsDMetric a StatsD metric aggregated over a flush interval.
+type StatsDMetric struct {
+	Name string
+	Type StatsDMetricType
+
+	// Tags the DogStatsD tags, i.e. network:mainnet, sorted.
+	Tags []string
+
+	// Value the counter sum, the gauge value or the timer mean.
+	Value float64
+
+	// Timer set for timers only.
+	Timer *StatsDTimerStats
+}
+
+// StatsDFlush the metrics aggregated over a flush interval, emitted by
+// StatsDWatch.
+type StatsDFlush struct {
+	Metrics []StatsDMetric
+
+	// Malformed number of lines skipped since the previous flush.
+	Malformed int
+
+	Time time.Time
+}
+
+// StatsDWatch implements Watcher interface.
+// Listens for StatsD lines on UDP and/or a Unix datagram socket, aggregates
+// the counters and timers over the flush interval and emits them along
+// the gauges as a StatsDFlush.
+type StatsDWatch struct {
+	StatsDWatchConf
+	Watch
+
+	aggMu     sync.Mutex
+	series    map[string]*statsDSeries
+	malformed int
+
+	addrMu sync.Mutex
+	addr   net.Addr
+}
+
+// NewStatsDWatch StatsDWatch constructor.
+func NewStatsDWatch(conf StatsDWatchConf) *StatsDWatch {
+	w := new(StatsDWatch)
+	w.Watch = NewWatch()
+	w.Name = "statsd"
+	w.StatsDWatchConf = conf
+
+	if w.Addr == "" && w.UnixPath == "" {
+		w.Addr = defaultStatsDAddr
+	}
+	if w.FlushInterval < 1 {
+		w.FlushInterval = defaultStatsDFlushInterval
+	}
+	w.Log = w.Log.With("addr", w.Addr, "unix_path", w.UnixPath)
+	w.series = map[string]*statsDSeries{}
+
+	return w
+}
+
+// StartUnsafe starts the goroutines listening and flushing.
+func (w *StatsDWatch) StartUnsafe() {
+	w.Watch.StartUnsafe()
+
+	ctx, stop := w.runContext(), w.StopKey
+
+	w.wg.Add(1)
+	go w.flushLoop(ctx, stop)
+
+	if w.Addr != "" {
+		w.wg.Add(1)
+		go w.serve(ctx, stop, w.listenUDP)
+	}
+	if w.UnixPath != "" {
+		w.wg.Add(1)
+		go w.serve(ctx, stop, w.listenUnix)
+	}
+}
+
+// LocalAddr returns the address the UDP socket is bound to, nil until it
+// is.
+func (w *StatsDWatch) LocalAddr() net.Addr {
+	w.addrMu.Lock()
+	defer w.addrMu.Unlock()
+
+	return w.addr
+}
+
+func (w *StatsDWatch) listenUDP() (net.PacketConn, error) {
+	conn, err := net.ListenPacket("udp", w.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	w.addrMu.Lock()
+	w.addr = conn.LocalAddr()
+	w.addrMu.Unlock()
+
+	return conn, nil
+}
+
+func (w *StatsDWatch) listenUnix() (net.PacketConn, error) {
+	// left over by a previous run
+	if err := os.Remove(w.UnixPath); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return net.ListenUnixgram("unixgram", &net.UnixAddr{Name: w.UnixPath, Net: "unixgram"})
+}
+
+// serve binds the socket, retrying until it succeeds, and reads the
+// packets until ctx is done or stop, the StopKey of the run it was started
+// for, is closed.
+func (w *StatsDWatch) serve(ctx context.Context, stop <-chan bool, listen func() (net.PacketConn, error)) {
+	defer w.wg.Done()
+
+	var conn net.PacketConn
+	for {
+		var err error
+		if conn, err = listen(); err == nil {
+			break
+		}
+		w.Log.Errorw("failed to listen for statsd packets, retrying", zap.Error(err), "retry_timer", defaultStatsDRetryIntv)
+		w.ReportError(err)
+
+		select {
+		case <-time.After(defaultStatsDRetryIntv):
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	if w.ReadBufferBytes > 0 {
+		if c, ok := conn.(interface{ SetReadBuffer(int) error }); ok {
+			if err := c.SetReadBuffer(w.ReadBufferBytes); err != nil {
+				w.Log.Warnw("failed to set the statsd read buffer size", "read_buffer_bytes", w.ReadBufferBytes, zap.Error(err))
+			}
+		}
+	}
+
+	// closing the socket ends the read loop
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+	if _, ok := conn.(*net.UnixConn); ok {
+		defer os.Remove(w.UnixPath)
+	}
+
+	buf := make([]byte, maxStatsDPacketBytes)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				w.Log.Errorw("failed to read statsd packet", zap.Error(err))
+				w.ReportError(err)
+			}
+
+			return
+		}
+
+		w.handlePacket(buf[:n])
+	}
+}
+
+// handlePacket aggregates the lines of a packet.
+func (w *StatsDWatch) handlePacket(packet []byte) {
+	w.aggMu.Lock()
+	defer w.aggMu.Unlock()
+
+	for _, line := range bytes.Split(packet, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		if err := w.aggregate(string(line)); err != nil {
+			w.malformed++
+		}
+	}
+}
+
+// flushLoop emits the aggregated metrics on every interval, and once more
+// on stop.
+func (w *StatsDWatch) flushLoop(ctx context.Context, stop <-chan bool) {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.flush()
+		case <-stop:
+			w.flush()
+
+			return
+		case <-ctx.Done():
+			w.flush()
+
+			return
+		}
+	}
+}
+
+func (w *StatsDWatch) flush() {
+	w.aggMu.Lock()
+	keys := make([]string, 0, len(w.series))
+	for key := range w.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	msg := StatsDFlush{Malformed: w.malformed, Time: time.Now()}
+	for _, key := range keys {
+		s := w.series[key]
+		if s.metric.Type != StatsDGauge && s.samples == 0 {
+			// idle since the previous flush
+			delete(w.series, key)
+
+			continue
+		}
+
+		msg.Metrics = append(msg.Metrics, s.aggregated())
+		s.reset()
+	}
+	w.malformed = 0
+	w.aggMu.Unlock()
+
+	if msg.Malformed > 0 {
+		w.Log.Warnw("skipped malformed statsd lines", "count", msg.Malformed)
+		w.ReportError(fmt.Errorf("%w: %d skipped", errStatsDMalformed, msg.Malformed))
+	}
+
+	if len(msg.Metrics) > 0 || msg.Malformed > 0 {
+		w.Emit(msg)
+	}
+}
+
+// aggregate parses a line, i.e. algod.peers:12|g|#network:mainnet, and adds
+// it to its series.
+func (w *StatsDWatch) aggregate(line string) error {
+	sections := strings.Split(line, "|")
+	if len(sections) < 2 {
+		return errStatsDMalformed
+	}
+
+	i := strings.LastIndexByte(sections[0], ':')
+	if i < 1 {
+		return errStatsDMalformed
+	}
+	name, rawValue := sections[0][:i], sections[0][i+1:]
+
+	value, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return errStatsDMalformed
+	}
+
+	var typ StatsDMetricType
+	switch sections[1] {
+	case "c":
+		typ = StatsDCounter
+	case "g":
+		typ = StatsDGauge
+	case "ms", "h", "d":
+		typ = StatsDTimer
+	default:
+		return errStatsDMalformed
+	}
+
+	rate := 1.0
+	var tags []string
+	for _, section := range sections[2:] {
+		switch {
+		case strings.HasPrefix(section, "@"):
+			rate, err = strconv.ParseFloat(section[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return errStatsDMalformed
+			}
+		case strings.HasPrefix(section, "#"):
+			for _, tag := range strings.Split(section[1:], ",") {
+				if tag != "" {
+					tags = append(tags, tag)
+				}
+			}
+		}
+		// the other DogStatsD extensions, i.e. container ids, are ignored
+	}
+	sort.Strings(tags)
+
+	key := name + "|" + string(typ) + "|" + strings.Join(tags, ",")
+	s, ok := w.series[key]
+	if !ok {
+		s = &statsDSeries{metric: StatsDMetric{Name: name, Type: typ, Tags: tags}}
+		w.series[key] = s
+	}
+
+	switch typ {
+	case StatsDCounter:
+		s.metric.Value += value / rate
+	case StatsDGauge:
+		if rawValue[0] == '+' || rawValue[0] == '-' {
+			s.metric.Value += value
+		} else {
+			s.metric.Value = value
+		}
+	case StatsDTimer:
+		s.values = append(s.values, value)
+	}
+	s.samples++
+
+	return nil
+}
+
+// statsDSeries a metric aggregated since the previous flush.
+type statsDSeries struct {
+	metric  StatsDMetric
+	values  []float64
+	samples int
+}
+
+func (s *statsDSeries) aggregated() StatsDMetric {
+	m := s.metric
+	m.Tags = append([]string(nil), s.metric.Tags...)
+	if m.Type != StatsDTimer {
+		return m
+	}
+
+	sort.Float64s(s.values)
+	n := len(s.values)
+	stats := &StatsDTimerStats{Count: n, Min: s.values[0], Max: s.values[n-1]}
+	for _, v := range s.values {
+		stats.Sum += v
+	}
+	stats.Mean = stats.Sum / float64(n)
+
+	percentile := func(p float64) float64 {
+		return s.values[int(math.Ceil(p*float64(n)))-1]
+	}
+	stats.P50, stats.P95, stats.P99 = percentile(0.50), percentile(0.95), percentile(0.99)
+
+	m.Value = stats.Mean
+	m.Timer = stats
+
+	return m
+}
+
+// reset starts a new flush interval, the gauges keep their value.
+func (s *statsDSeries) reset() {
+	s.samples = 0
+	s.values = s.values[:0]
+	if s.metric.Type == StatsDCounter {
+		s.metric.Value = 0
+	}
+}
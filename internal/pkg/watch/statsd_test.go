@@ -0,0 +1,171 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func startStatsDWatch(t *testing.T, conf StatsDWatchConf) (*StatsDWatch, chan interface{}) {
+	t.Helper()
+
+	w := NewStatsDWatch(conf)
+
+	ch := make(chan interface{}, 100)
+	w.Subscribe(ch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	StartWithContext(ctx, w)
+	t.Cleanup(func() {
+		cancel()
+		w.Wait()
+	})
+
+	return w, ch
+}
+
+func requireStatsDFlush(t *testing.T, ch chan interface{}) StatsDFlush {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		flush, ok := msg.(StatsDFlush)
+		require.True(t, ok, "unexpected message %v", msg)
+
+		return flush
+	case <-time.After(5 * time.Second):
+		t.Fatal("no statsd flush")
+	}
+
+	return StatsDFlush{}
+}
+
+func dialStatsD(t *testing.T, w *StatsDWatch) net.Conn {
+	t.Helper()
+
+	require.Eventually(t, func() bool { return w.LocalAddr() != nil }, 5*time.Second, 10*time.Millisecond)
+
+	conn, err := net.Dial("udp", w.LocalAddr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func TestStatsDWatch_Aggregate(t *testing.T) {
+	w, ch := startStatsDWatch(t, StatsDWatchConf{
+		Addr:            "127.0.0.1:0",
+		FlushInterval:   time.Hour,
+		ReadBufferBytes: 1 << 20,
+	})
+	conn := dialStatsD(t, w)
+
+	for _, packet := range []string{
+		"sidecar.requests:1|c|#network:mainnet,role:relay\nsidecar.requests:2|c|#role:relay,network:mainnet",
+		"sidecar.requests:1|c|@0.5|#network:mainnet,role:relay",
+		"sidecar.requests:1|c",
+		"algod.peers:12|g\nalgod.peers:-2|g\nalgod.peers:+1|g",
+		"sidecar.latency:10|ms\nsidecar.latency:30|ms\nsidecar.latency:20|h|#network:mainnet|c:83f2a1",
+		"sidecar.latency:40|ms\n",
+		"no value|c\nsidecar.bad:x|c\nsidecar.set:a|s\nsidecar.rate:1|c|@2\nsidecar.empty:1",
+	} {
+		_, err := conn.Write([]byte(packet))
+		require.NoError(t, err)
+	}
+
+	// the packets are read before the flush on stop
+	time.Sleep(100 * time.Millisecond)
+	w.Stop()
+	w.Wait()
+
+	flush := requireStatsDFlush(t, ch)
+	require.Equal(t, 5, flush.Malformed)
+	require.Equal(t, []StatsDMetric{
+		{Name: "algod.peers", Type: StatsDGauge, Value: 11},
+		{Name: "sidecar.latency", Type: StatsDTimer, Value: 80.0 / 3, Timer: &StatsDTimerStats{
+			Count: 3, Sum: 80, Min: 10, Max: 40, Mean: 80.0 / 3, P50: 30, P95: 40, P99: 40,
+		}},
+		{Name: "sidecar.latency", Type: StatsDTimer, Tags: []string{"network:mainnet"}, Value: 20, Timer: &StatsDTimerStats{
+			Count: 1, Sum: 20, Min: 20, Max: 20, Mean: 20, P50: 20, P95: 20, P99: 20,
+		}},
+		{Name: "sidecar.requests", Type: StatsDCounter, Value: 1},
+		{Name: "sidecar.requests", Type: StatsDCounter, Tags: []string{"network:mainnet", "role:relay"}, Value: 5},
+	}, flush.Metrics)
+}
+
+func TestStatsDWatch_Flush(t *testing.T) {
+	w, ch := startStatsDWatch(t, StatsDWatchConf{Addr: "127.0.0.1:0", FlushInterval: 50 * time.Millisecond})
+	conn := dialStatsD(t, w)
+
+	send := func(packet string) {
+		_, err := conn.Write([]byte(packet))
+		require.NoError(t, err)
+	}
+
+	// the flushes until the packet is read
+	nextFlush := func(metrics int) StatsDFlush {
+		for {
+			if flush := requireStatsDFlush(t, ch); len(flush.Metrics) == metrics {
+				return flush
+			}
+		}
+	}
+
+	send("algod.peers:12|g\nsidecar.requests:3|c")
+	nextFlush(2)
+
+	// the counter is reset, the gauge is kept
+	flush := requireStatsDFlush(t, ch)
+	require.Equal(t, []StatsDMetric{{Name: "algod.peers", Type: StatsDGauge, Value: 12}}, flush.Metrics)
+
+	send("sidecar.requests:1|c")
+	flush = nextFlush(2)
+	require.Equal(t, StatsDMetric{Name: "sidecar.requests", Type: StatsDCounter, Value: 1}, flush.Metrics[1])
+}
+
+func TestStatsDWatch_Unix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "statsd.sock")
+
+	w, ch := startStatsDWatch(t, StatsDWatchConf{UnixPath: path, FlushInterval: time.Hour})
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	conn, err := net.Dial("unixgram", path)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("sidecar.requests:2|c"))
+	require.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+	w.Stop()
+	w.Wait()
+
+	flush := requireStatsDFlush(t, ch)
+	require.Equal(t, []StatsDMetric{{Name: "sidecar.requests", Type: StatsDCounter, Value: 2}}, flush.Metrics)
+
+	// removed on stop
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
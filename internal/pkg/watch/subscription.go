@@ -0,0 +1,214 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy what Emit does with a message when a subscription is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDropNewest discards the incoming message.
+	OverflowDropNewest OverflowPolicy = iota
+
+	// OverflowDropOldest evicts the oldest buffered message to make room.
+	OverflowDropOldest
+
+	// OverflowBlock waits for room in the subscription, holding back the
+	// watch and its other subscribers meanwhile, until the watch is stopped.
+	OverflowBlock
+)
+
+// SubOptions subscription options.
+type SubOptions struct {
+	// Buffer number of messages queued by the watch for the subscriber, on
+	// top of the capacity of the subscriber's channel. 0 sends straight to
+	// the channel, except for OverflowDropOldest which needs at least 1.
+	Buffer int
+
+	// Overflow policy applied when both the buffer and the subscriber's
+	// channel are full.
+	Overflow OverflowPolicy
+
+	// Filter the messages sent to the subscriber, all of them if nil. It
+	// is called by Emit and must not block. A panicking filter disables the
+	// subscription, nothing is sent to it anymore.
+	Filter func(interface{}) bool
+
+	// RateLimit of the messages sent to the subscriber, unlimited if nil.
+	// The messages over the limit are suppressed, a summary of them is sent
+	// periodically.
+	RateLimit *RateLimit
+}
+
+// subscription a subscriber channel and its buffer, if any.
+type subscription struct {
+	SubOptions
+
+	ch chan<- interface{}
+
+	// deliver called with the messages in place of sending them to ch, i.e.
+	// to fan them out to typed channels. It must not block, it returns the
+	// number of messages dropped.
+	deliver func(interface{}) int
+
+	// buf queues messages for ch, nil if Buffer is 0.
+	buf chan interface{}
+
+	// done closed by Unsubscribe, aborts blocked sends.
+	done       chan struct{}
+	cancelOnce sync.Once
+
+	// mu held for reading by send, removed is set once no send is in
+	// flight anymore.
+	mu      sync.RWMutex
+	removed bool
+
+	// forwarded closed once the forwarder exited, nil if Buffer is 0.
+	forwarded chan struct{}
+
+	// disabled set once Filter panicked, accessed atomically.
+	disabled int32
+
+	// limiter nil if RateLimit is.
+	limiter *rateLimiter
+}
+
+func newSubscription(ch chan<- interface{}, opts SubOptions) *subscription {
+	if opts.Overflow == OverflowDropOldest && opts.Buffer < 1 {
+		// messages can't be taken back from the subscriber's channel
+		opts.Buffer = 1
+	}
+
+	s := &subscription{SubOptions: opts, ch: ch, done: make(chan struct{}), limiter: newRateLimiter(opts.RateLimit)}
+	if opts.Buffer > 0 {
+		s.buf = make(chan interface{}, opts.Buffer)
+		s.forwarded = make(chan struct{})
+	}
+
+	return s
+}
+
+// forward sends the buffered messages to the subscriber's channel until the
+// subscription is cancelled. It outlives the watch's runs, the watch may be
+// started again after Stop.
+func (s *subscription) forward() {
+	defer close(s.forwarded)
+
+	for {
+		select {
+		case msg := <-s.buf:
+			select {
+			case s.ch <- msg:
+			case <-s.done:
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// cancel aborts the pending sends and waits for the forwarder to exit, no
+// message is sent to the subscriber's channel afterwards.
+func (s *subscription) cancel() {
+	s.cancelOnce.Do(func() { close(s.done) })
+
+	s.mu.Lock()
+	s.removed = true
+	s.mu.Unlock()
+
+	if s.forwarded != nil {
+		<-s.forwarded
+	}
+}
+
+// match returns whether msg passes the filter. If the filter panics, the
+// subscription is disabled and the panic returned as an error.
+func (s *subscription) match(msg interface{}) (match bool, err error) {
+	if s.Filter == nil {
+		return true, nil
+	}
+	if atomic.LoadInt32(&s.disabled) == 1 {
+		return false, nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			match = false
+			// reported once, by the emit disabling it
+			if atomic.CompareAndSwapInt32(&s.disabled, 0, 1) {
+				err = fmt.Errorf("subscription filter panicked: %v", r)
+			}
+		}
+	}()
+
+	return s.Filter(msg), nil
+}
+
+// send delivers msg according to the overflow policy, returns the number of
+// messages dropped to do so.
+func (s *subscription) send(msg interface{}, stop <-chan bool) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.removed {
+		return 0
+	}
+	if s.deliver != nil {
+		return s.deliver(msg)
+	}
+
+	queue := s.ch
+	if s.buf != nil {
+		queue = s.buf
+	}
+
+	select {
+	case queue <- msg:
+		return 0
+	default:
+	}
+
+	switch s.Overflow {
+	case OverflowDropOldest:
+		dropped := 0
+		for {
+			select {
+			case s.buf <- msg:
+				return dropped
+			default:
+			}
+
+			select {
+			case <-s.buf:
+				dropped++
+			default:
+			}
+		}
+	case OverflowBlock:
+		select {
+		case queue <- msg:
+			return 0
+		case <-stop:
+		case <-s.done:
+		}
+	}
+
+	return 1
+}
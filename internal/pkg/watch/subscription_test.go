@@ -0,0 +1,437 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fillSubscription emits 1 and waits for the forwarder to hold it, blocked
+// on the unread subscriber channel, then emits msgs.
+func fillSubscription(t *testing.T, w *Watch, msgs ...int) {
+	t.Helper()
+
+	w.Emit(1)
+	require.Eventually(t, func() bool { return len(w.listeners[0].buf) == 0 }, time.Second, time.Millisecond)
+
+	for _, msg := range msgs {
+		w.Emit(msg)
+	}
+}
+
+func receiveN(t *testing.T, ch <-chan interface{}, n int) []interface{} {
+	t.Helper()
+
+	got := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		select {
+		case msg := <-ch:
+			got = append(got, msg)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for message %d, got %v", i, got)
+		}
+	}
+
+	select {
+	case msg := <-ch:
+		t.Fatalf("unexpected message %v", msg)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	return got
+}
+
+func TestWatch_Subscribe_Default(t *testing.T) {
+	w := NewWatch()
+	ch := make(chan interface{}, 1)
+	w.Subscribe(ch)
+
+	w.Emit(1)
+	w.Emit(2)
+
+	require.Equal(t, []interface{}{1}, receiveN(t, ch, 1))
+	require.EqualValues(t, 1, w.Dropped())
+}
+
+func TestWatch_SubscribeWithOptions_DropNewest(t *testing.T) {
+	w := NewWatch()
+	defer w.Stop()
+	ch := make(chan interface{})
+	w.SubscribeWithOptions(ch, SubOptions{Buffer: 2, Overflow: OverflowDropNewest})
+
+	fillSubscription(t, &w, 2, 3, 4, 5)
+
+	require.EqualValues(t, 2, w.Dropped())
+	require.Equal(t, []interface{}{1, 2, 3}, receiveN(t, ch, 3))
+}
+
+func TestWatch_SubscribeWithOptions_DropOldest(t *testing.T) {
+	w := NewWatch()
+	defer w.Stop()
+	ch := make(chan interface{})
+	w.SubscribeWithOptions(ch, SubOptions{Buffer: 2, Overflow: OverflowDropOldest})
+
+	fillSubscription(t, &w, 2, 3, 4, 5)
+
+	require.EqualValues(t, 2, w.Dropped())
+	require.Equal(t, []interface{}{1, 4, 5}, receiveN(t, ch, 3))
+}
+
+func TestWatch_SubscribeWithOptions_DropOldestUnbuffered(t *testing.T) {
+	w := NewWatch()
+	defer w.Stop()
+	ch := make(chan interface{})
+	w.SubscribeWithOptions(ch, SubOptions{Overflow: OverflowDropOldest})
+
+	fillSubscription(t, &w, 2, 3)
+
+	require.EqualValues(t, 1, w.Dropped())
+	require.Equal(t, []interface{}{1, 3}, receiveN(t, ch, 2))
+}
+
+func TestWatch_SubscribeWithOptions_Block(t *testing.T) {
+	w := NewWatch()
+	defer w.Stop()
+	ch := make(chan interface{})
+	w.SubscribeWithOptions(ch, SubOptions{Buffer: 2, Overflow: OverflowBlock})
+
+	fillSubscription(t, &w, 2, 3)
+
+	emitted := make(chan struct{})
+	go func() {
+		w.Emit(4)
+		close(emitted)
+	}()
+
+	select {
+	case <-emitted:
+		t.Fatal("emit did not block on a full subscription")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.Equal(t, []interface{}{1, 2, 3, 4}, receiveN(t, ch, 4))
+	<-emitted
+	require.EqualValues(t, 0, w.Dropped())
+}
+
+func TestWatch_SubscribeWithOptions_BlockStop(t *testing.T) {
+	w := NewWatch()
+	w.StartUnsafe()
+	ch := make(chan interface{})
+	w.SubscribeWithOptions(ch, SubOptions{Overflow: OverflowBlock})
+
+	emitted := make(chan struct{})
+	go func() {
+		w.Emit(1)
+		close(emitted)
+	}()
+
+	w.Stop()
+	select {
+	case <-emitted:
+	case <-time.After(time.Second):
+		t.Fatal("emit still blocked after stop")
+	}
+	require.EqualValues(t, 1, w.Dropped())
+}
+
+func TestWatch_SubscribeWithOptions_MultipleSubscribers(t *testing.T) {
+	w := NewWatch()
+	defer w.Stop()
+	slow := make(chan interface{})
+	fast := make(chan interface{}, 10)
+	w.SubscribeWithOptions(slow, SubOptions{Buffer: 1, Overflow: OverflowDropNewest})
+	w.Subscribe(fast)
+
+	fillSubscription(t, &w, 2, 3)
+
+	// the slow subscriber does not hold back the fast one
+	require.Equal(t, []interface{}{1, 2, 3}, receiveN(t, fast, 3))
+	require.Equal(t, []interface{}{1, 2}, receiveN(t, slow, 2))
+	require.EqualValues(t, 1, w.Dropped())
+}
+
+func TestWatch_Unsubscribe(t *testing.T) {
+	w := NewWatch()
+	defer w.Stop()
+	kept := make(chan interface{}, 10)
+	removed := make(chan interface{}, 10)
+	w.Subscribe(kept)
+	w.Subscribe(removed)
+
+	w.Emit(1)
+	w.Unsubscribe(removed)
+	close(removed)
+	w.Emit(2)
+
+	require.Equal(t, []interface{}{1, 2}, receiveN(t, kept, 2))
+
+	got := []interface{}{}
+	for msg := range removed {
+		got = append(got, msg)
+	}
+	require.Equal(t, []interface{}{1}, got)
+
+	// unknown channels are ignored
+	w.Unsubscribe(make(chan interface{}))
+}
+
+func TestWatch_Unsubscribe_Blocked(t *testing.T) {
+	w := NewWatch()
+	defer w.Stop()
+	ch := make(chan interface{})
+	w.SubscribeWithOptions(ch, SubOptions{Buffer: 1, Overflow: OverflowBlock})
+
+	fillSubscription(t, &w, 2)
+
+	emitted := make(chan struct{})
+	go func() {
+		w.Emit(3)
+		close(emitted)
+	}()
+
+	select {
+	case <-emitted:
+		t.Fatal("emit did not block on a full subscription")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	w.Unsubscribe(ch)
+	close(ch)
+
+	select {
+	case <-emitted:
+	case <-time.After(time.Second):
+		t.Fatal("emit still blocked after unsubscribe")
+	}
+}
+
+func TestWatch_Unsubscribe_Concurrent(t *testing.T) {
+	w := NewWatch()
+	defer w.Stop()
+
+	stop := make(chan struct{})
+	emitting := sync.WaitGroup{}
+	emitting.Add(1)
+	go func() {
+		defer emitting.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				w.Emit(i)
+			}
+		}
+	}()
+
+	policies := []SubOptions{
+		{},
+		{Buffer: 4, Overflow: OverflowDropNewest},
+		{Buffer: 4, Overflow: OverflowDropOldest},
+		{Buffer: 4, Overflow: OverflowBlock},
+		{Overflow: OverflowBlock},
+	}
+
+	subscribers := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		subscribers.Add(1)
+		go func(opts SubOptions) {
+			defer subscribers.Done()
+			for j := 0; j < 50; j++ {
+				ch := make(chan interface{}, j%3)
+				w.SubscribeWithOptions(ch, opts)
+
+				select {
+				case <-ch:
+				case <-time.After(time.Millisecond):
+				}
+
+				w.Unsubscribe(ch)
+				// a send after Unsubscribe panics
+				close(ch)
+			}
+		}(policies[i%len(policies)])
+	}
+
+	subscribers.Wait()
+	close(stop)
+	emitting.Wait()
+
+	w.listenersMu.Lock()
+	defer w.listenersMu.Unlock()
+	require.Empty(t, w.listeners)
+}
+
+func TestWatch_Subscribe_Concurrent(t *testing.T) {
+	w := NewWatch()
+	defer w.Stop()
+
+	stop := make(chan struct{})
+	emitting := sync.WaitGroup{}
+	emitting.Add(1)
+	go func() {
+		defer emitting.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				w.Emit(i)
+			}
+		}
+	}()
+
+	const n = 50
+	chans := make([]chan interface{}, n)
+	subscribers := sync.WaitGroup{}
+	for i := range chans {
+		chans[i] = make(chan interface{}, 16)
+
+		subscribers.Add(1)
+		go func(ch chan interface{}) {
+			defer subscribers.Done()
+			w.SubscribeWithOptions(ch, SubOptions{Overflow: OverflowBlock})
+		}(chans[i])
+	}
+
+	// each subscriber gets, in order, the messages emitted from its
+	// subscription on
+	received := sync.WaitGroup{}
+	for _, ch := range chans {
+		received.Add(1)
+		go func(ch chan interface{}) {
+			defer received.Done()
+
+			last := -1
+			for msg := range ch {
+				if msg == "done" {
+					return
+				}
+				require.Greater(t, msg.(int), last)
+				last = msg.(int)
+			}
+		}(ch)
+	}
+
+	subscribers.Wait()
+	close(stop)
+	emitting.Wait()
+
+	w.listenersMu.Lock()
+	require.Len(t, w.listeners, n)
+	w.listenersMu.Unlock()
+
+	w.Emit("done")
+	received.Wait()
+}
+
+func TestWatch_SubscribeFiltered(t *testing.T) {
+	w := NewWatch()
+
+	errs := make(chan interface{}, 100)
+	w.SubscribeFiltered(errs, func(msg interface{}) bool {
+		return msg.(int)%2 == 1
+	})
+	all := make(chan interface{}, 100)
+	w.Subscribe(all)
+
+	for i := 0; i < 6; i++ {
+		w.Emit(i)
+	}
+
+	require.Equal(t, []interface{}{1, 3, 5}, receiveN(t, errs, 3))
+	require.Len(t, receiveN(t, all, 6), 6)
+}
+
+func TestWatch_SubscribeFiltered_Panic(t *testing.T) {
+	w := NewWatch()
+
+	var reported []error
+	w.OnError(func(err error) { reported = append(reported, err) })
+
+	// panics on the messages that aren't ints
+	ch := make(chan interface{}, 100)
+	w.SubscribeFiltered(ch, func(msg interface{}) bool {
+		return msg.(int) > 0
+	})
+	other := make(chan interface{}, 100)
+	w.Subscribe(other)
+
+	w.Emit(1)
+	w.Emit("not an int")
+	w.Emit(2)
+
+	// disabled, the other subscribers are served
+	require.Equal(t, []interface{}{1}, receiveN(t, ch, 1))
+	require.Equal(t, []interface{}{1, "not an int", 2}, receiveN(t, other, 3))
+	require.Equal(t, uint64(1), w.FilterPanics())
+	require.Len(t, reported, 1)
+}
+
+// benchmarkEmit emits b.N messages, one in filterRatio wanted by the
+// subscriber, filtered by the watch or by the subscriber.
+func benchmarkEmit(b *testing.B, filtered bool) {
+	const filterRatio = 100
+
+	want := func(msg interface{}) bool {
+		// the -1 ending the benchmark included
+		return msg.(int)%filterRatio == 0 || msg == -1
+	}
+
+	w := NewWatch()
+	ch := make(chan interface{}, 64)
+	opts := SubOptions{Overflow: OverflowBlock}
+	if filtered {
+		opts.Filter = want
+	}
+	w.SubscribeWithOptions(ch, opts)
+
+	done := make(chan int)
+	go func() {
+		received := 0
+		for msg := range ch {
+			if msg == -1 {
+				done <- received
+
+				return
+			}
+			if want(msg) {
+				received++
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.Emit(i + 1)
+	}
+	w.Emit(-1)
+	received := <-done
+	b.StopTimer()
+
+	require.Equal(b, b.N/filterRatio, received)
+}
+
+func BenchmarkEmit_SubscribeFiltered(b *testing.B) {
+	benchmarkEmit(b, true)
+}
+
+func BenchmarkEmit_ConsumerFiltered(b *testing.B) {
+	benchmarkEmit(b, false)
+}
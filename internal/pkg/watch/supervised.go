@@ -0,0 +1,279 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"agent/api/v1/model"
+	"agent/pkg/timesync"
+
+	"github.com/cenkalti/backoff"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrSupervisedWatchConf supervised watch configuration error.
+	ErrSupervisedWatchConf = errors.New("supervised watch configuration error")
+
+	// ErrWatchExited the supervised watch stopped by itself.
+	ErrWatchExited = errors.New("watch exited")
+
+	// ErrWatchStalled the supervised watch was found stalled by the
+	// watchdog.
+	ErrWatchStalled = errors.New("watch stalled")
+
+	// ErrSupervisorGaveUp the supervised watch kept failing past the
+	// retry limits, the supervisor stopped.
+	ErrSupervisorGaveUp = errors.New("supervised watch kept failing, giving up")
+)
+
+const (
+	defaultSupervisedRetryIntv  = time.Second
+	defaultSupervisedMaxBackoff = time.Minute
+)
+
+// SupervisedWatchConf SupervisedWatch configuration struct.
+type SupervisedWatchConf struct {
+	// Factory creates the supervised watch, it is called on every
+	// (re)start.
+	Factory func() (Watcher, error)
+
+	// Name identifies the supervised watch in the restart events. Defaults
+	// to the name of the watch created by Factory.
+	Name string
+
+	// Fatal whether an error reported by the supervised watch requires
+	// restarting it. If nil, it is restarted only when Factory fails or
+	// the watch stops by itself.
+	Fatal func(error) bool
+
+	// RetryIntv delay before the first restart, it grows on every
+	// consecutive failure up to MaxBackoff.
+	RetryIntv  time.Duration
+	MaxBackoff time.Duration
+
+	// MaxRetries consecutive failures after which the supervisor gives up
+	// and stops, 0 retries forever.
+	MaxRetries int
+
+	// MaxElapsedTime how long the supervisor keeps restarting a watch that
+	// doesn't emit before it gives up and stops, 0 retries forever.
+	MaxElapsedTime time.Duration
+}
+
+// SupervisedWatch implements Watcher interface.
+// Runs the watch created by Factory and restarts it with an exponential
+// backoff when it fails: when Factory returns an error, the watch stops by
+// itself, reports a Fatal error or is found stalled by the watchdog. The
+// messages of the successive watches are emitted to the subscribers of the
+// supervisor, along with an agent.watch.restart event on every failure. The
+// backoff is reset once a watch emits.
+type SupervisedWatch struct {
+	SupervisedWatchConf
+	Watch
+
+	clock clock
+
+	// stalled signals the watchdog found the watch stalled.
+	stalled chan struct{}
+}
+
+// NewSupervisedWatch SupervisedWatch constructor, returns an error if no
+// factory is set.
+func NewSupervisedWatch(conf SupervisedWatchConf) (*SupervisedWatch, error) {
+	if conf.Factory == nil {
+		return nil, fmt.Errorf("%w: factory is required", ErrSupervisedWatchConf)
+	}
+
+	w := new(SupervisedWatch)
+	w.Watch = NewWatch()
+	w.Watch.Name = "supervised"
+	if conf.Name != "" {
+		w.Watch.Name = conf.Name
+	}
+	w.SupervisedWatchConf = conf
+	w.clock = realClock{}
+	w.stalled = make(chan struct{}, 1)
+
+	if w.RetryIntv < 1 {
+		w.RetryIntv = defaultSupervisedRetryIntv
+	}
+	if w.MaxBackoff < 1 {
+		w.MaxBackoff = defaultSupervisedMaxBackoff
+	}
+	if w.MaxBackoff < w.RetryIntv {
+		w.MaxBackoff = w.RetryIntv
+	}
+
+	return w, nil
+}
+
+// StartUnsafe starts the goroutine supervising the watch.
+func (w *SupervisedWatch) StartUnsafe() {
+	w.Watch.StartUnsafe()
+
+	ctx, stop := w.runContext(), w.StopKey
+	w.Go(func() { w.superviseLoop(ctx, stop) })
+}
+
+// restartStalled implements stallRestarter, the watch is restarted as if it
+// failed.
+func (w *SupervisedWatch) restartStalled() {
+	select {
+	case w.stalled <- struct{}{}:
+	default:
+	}
+}
+
+// superviseLoop (re)starts the watch until ctx is done, stop, the StopKey
+// of the run it was started for, is closed or the retry limits are reached.
+func (w *SupervisedWatch) superviseLoop(ctx context.Context, stop <-chan bool) {
+	backof := backoff.NewExponentialBackOff()
+	backof.InitialInterval = w.RetryIntv
+	backof.MaxInterval = w.MaxBackoff
+	backof.MaxElapsedTime = w.MaxElapsedTime
+	backof.Clock = w.clock
+	backof.Reset()
+
+	failures := 0
+	for {
+		name, emitted, err := w.run(ctx, stop)
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		if emitted {
+			backof.Reset()
+			failures = 0
+		}
+		failures++
+
+		retry := backof.NextBackOff()
+		if retry == backoff.Stop || (w.MaxRetries > 0 && failures > w.MaxRetries) {
+			w.Log.Errorw("supervised watch kept failing, giving up", "watch", name, "failures", failures, zap.Error(err))
+			w.ReportError(fmt.Errorf("%w: %v", ErrSupervisorGaveUp, err))
+			w.signalStop()
+
+			return
+		}
+
+		w.Log.Warnw("supervised watch failed, restarting it", "watch", name, zap.Error(err), "retry_timer", retry)
+		w.emitRestart(name, err, failures, retry)
+
+		select {
+		case <-w.clock.After(retry):
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// run creates and starts the watch, forwarding its messages, until it fails
+// or the supervisor is stopped. It returns the name of the watch, whether it
+// emitted and why it failed.
+func (w *SupervisedWatch) run(ctx context.Context, stop <-chan bool) (string, bool, error) {
+	name := w.SupervisedWatchConf.Name
+	if name == "" {
+		name = "unknown"
+	}
+
+	inner, err := w.Factory()
+	if err != nil {
+		err = fmt.Errorf("failed to create watch: %w", err)
+		w.ReportError(err)
+
+		return name, false, err
+	}
+	if w.SupervisedWatchConf.Name == "" {
+		name = watcherName(inner)
+	}
+
+	var emitted int32
+	inner.base().subscribeFunc(func(msg interface{}) int {
+		atomic.StoreInt32(&emitted, 1)
+		w.Emit(msg)
+
+		return 0
+	})
+
+	fatal := make(chan error, 1)
+	inner.OnError(func(err error) {
+		w.ReportError(err)
+
+		if w.Fatal != nil && w.Fatal(err) {
+			select {
+			case fatal <- err:
+			default:
+			}
+		}
+	})
+
+	// drop a stall signalled for the previous watch
+	select {
+	case <-w.stalled:
+	default:
+	}
+
+	StartWithContext(ctx, inner)
+	exited := inner.stopKey()
+	defer inner.Stop()
+
+	select {
+	case <-stop:
+		err = nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-exited:
+		err = ErrWatchExited
+		w.ReportError(err)
+	case <-w.stalled:
+		err = ErrWatchStalled
+		w.ReportError(err)
+	case err = <-fatal:
+	}
+
+	return name, atomic.LoadInt32(&emitted) == 1, err
+}
+
+func (w *SupervisedWatch) emitRestart(name string, err error, attempt int, retry time.Duration) {
+	ctx := map[string]interface{}{
+		model.WatchKey:   name,
+		model.ErrorKey:   err.Error(),
+		model.AttemptKey: attempt,
+		model.BackoffKey: retry.String(),
+	}
+
+	ev, evErr := model.NewWithCtx(ctx, model.AgentWatchRestartName, timesync.Now())
+	if evErr != nil {
+		w.Log.Errorw("error creating event", zap.Error(evErr))
+
+		return
+	}
+
+	w.Emit(&model.Message{
+		Name:  ev.Name,
+		Value: &model.Message_Event{Event: ev},
+	})
+}
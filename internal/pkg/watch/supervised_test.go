@@ -0,0 +1,259 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"agent/api/v1/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedWatch emits its messages once started and reports err, if any,
+// then stops by itself if exit is set.
+type scriptedWatch struct {
+	Watch
+	msgs []interface{}
+	err  error
+	exit bool
+}
+
+func newScriptedWatch(exit bool, err error, msgs ...interface{}) *scriptedWatch {
+	return &scriptedWatch{Watch: NewWatch(), msgs: msgs, err: err, exit: exit}
+}
+
+func (w *scriptedWatch) StartUnsafe() {
+	w.Watch.StartUnsafe()
+
+	stop := w.StopKey
+	w.Go(func() {
+		for _, msg := range w.msgs {
+			w.Emit(msg)
+		}
+		w.ReportError(w.err)
+
+		if w.exit {
+			w.signalStop()
+
+			return
+		}
+		<-stop
+	})
+}
+
+// scriptedFactory returns the watchers in turn, errors are returned as the
+// factory error. The last one is returned once they are exhausted.
+func scriptedFactory(watchers ...interface{}) func() (Watcher, error) {
+	var (
+		mu sync.Mutex
+		n  int
+	)
+
+	return func() (Watcher, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		next := watchers[n]
+		if n < len(watchers)-1 {
+			n++
+		}
+
+		if err, ok := next.(error); ok {
+			return nil, err
+		}
+
+		return next.(Watcher), nil
+	}
+}
+
+func startSupervisedWatch(t *testing.T, conf SupervisedWatchConf) (*SupervisedWatch, *fakeClock, chan interface{}) {
+	t.Helper()
+
+	conf.Name = "scripted"
+	w, err := NewSupervisedWatch(conf)
+	require.NoError(t, err)
+
+	clock := newFakeClock(time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC))
+	w.clock = clock
+
+	ch := make(chan interface{}, 100)
+	w.Subscribe(ch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	StartWithContext(ctx, w)
+	t.Cleanup(func() {
+		cancel()
+		w.Wait()
+	})
+
+	return w, clock, ch
+}
+
+// requireBackoff waits for the supervisor to back off, checks the delay
+// against the jittered interval and lets it elapse.
+func requireBackoff(t *testing.T, clock *fakeClock, interval time.Duration) {
+	t.Helper()
+
+	select {
+	case d := <-clock.waiting:
+		require.GreaterOrEqual(t, d, interval/2)
+		require.LessOrEqual(t, d, interval*3/2)
+		clock.advance(d)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no backoff of %v", interval)
+	}
+}
+
+func requireRestartEvent(t *testing.T, ch chan interface{}, attempt int, errMsg string) {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		ev := msg.(*model.Message).GetEvent()
+		require.Equal(t, model.AgentWatchRestartName, ev.Name)
+
+		values := ev.Values.AsMap()
+		require.Equal(t, "scripted", values[model.WatchKey])
+		require.Equal(t, float64(attempt), values[model.AttemptKey])
+		require.Equal(t, errMsg, values[model.ErrorKey])
+		require.NotEmpty(t, values[model.BackoffKey])
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no restart event %d", attempt)
+	}
+}
+
+func TestNewSupervisedWatch_Invalid(t *testing.T) {
+	_, err := NewSupervisedWatch(SupervisedWatchConf{})
+	require.ErrorIs(t, err, ErrSupervisedWatchConf)
+}
+
+func TestSupervisedWatch_Restart(t *testing.T) {
+	w, clock, ch := startSupervisedWatch(t, SupervisedWatchConf{
+		Factory: scriptedFactory(
+			errors.New("boom"),
+			newScriptedWatch(true, nil),
+			newScriptedWatch(true, nil),
+			newScriptedWatch(false, nil, "foo", "bar"),
+		),
+		RetryIntv: time.Second,
+	})
+
+	// the interval grows by 1.5 on every failure
+	requireRestartEvent(t, ch, 1, "failed to create watch: boom")
+	requireBackoff(t, clock, time.Second)
+	requireRestartEvent(t, ch, 2, ErrWatchExited.Error())
+	requireBackoff(t, clock, 1500*time.Millisecond)
+	requireRestartEvent(t, ch, 3, ErrWatchExited.Error())
+	requireBackoff(t, clock, 2250*time.Millisecond)
+
+	// the messages of the last watch reach the subscribers of the supervisor
+	require.Equal(t, []interface{}{"foo", "bar"}, receiveN(t, ch, 2))
+	require.True(t, w.Status().Running)
+}
+
+func TestSupervisedWatch_FatalAndStalled(t *testing.T) {
+	fatal := errors.New("fatal")
+
+	w, clock, ch := startSupervisedWatch(t, SupervisedWatchConf{
+		Factory: scriptedFactory(
+			newScriptedWatch(false, fatal, "foo"),
+			newScriptedWatch(false, errors.New("transient"), "bar"),
+			newScriptedWatch(false, nil, "baz"),
+		),
+		Fatal:     func(err error) bool { return errors.Is(err, fatal) },
+		RetryIntv: time.Second,
+	})
+
+	require.Equal(t, "foo", <-ch)
+	requireRestartEvent(t, ch, 1, "fatal")
+	requireBackoff(t, clock, time.Second)
+
+	// transient errors keep the watch running
+	require.Equal(t, "bar", <-ch)
+	select {
+	case d := <-clock.waiting:
+		t.Fatalf("unexpected backoff %v", d)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the backoff was reset by the emissions
+	w.restartStalled()
+	requireRestartEvent(t, ch, 1, ErrWatchStalled.Error())
+	requireBackoff(t, clock, time.Second)
+	require.Equal(t, "baz", <-ch)
+}
+
+func TestSupervisedWatch_GiveUp(t *testing.T) {
+	tests := []struct {
+		name string
+		conf SupervisedWatchConf
+	}{
+		{
+			name: "max retries",
+			conf: SupervisedWatchConf{MaxRetries: 3},
+		},
+		{
+			name: "max elapsed time",
+			conf: SupervisedWatchConf{MaxElapsedTime: 5 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs []error
+			var mu sync.Mutex
+
+			tt.conf.Factory = scriptedFactory(errors.New("boom"))
+			tt.conf.RetryIntv = time.Second
+			w, clock, ch := startSupervisedWatch(t, tt.conf)
+			w.OnError(func(err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, err)
+			})
+
+			stop := w.stopKey()
+			restarts := 0
+		loop:
+			for {
+				select {
+				case d := <-clock.waiting:
+					restarts++
+					clock.advance(d)
+				case <-stop:
+					break loop
+				case <-time.After(5 * time.Second):
+					t.Fatal("supervisor didn't give up")
+				}
+			}
+
+			if tt.conf.MaxRetries > 0 {
+				require.Equal(t, tt.conf.MaxRetries, restarts)
+			}
+			require.Len(t, ch, restarts)
+
+			w.Wait()
+			require.False(t, w.Status().Running)
+
+			mu.Lock()
+			defer mu.Unlock()
+			require.ErrorIs(t, errs[len(errs)-1], ErrSupervisorGaveUp)
+		})
+	}
+}
@@ -11,6 +11,9 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !nosystemd
+// +build !nosystemd
+
 package watch
 
 import (
@@ -61,6 +64,7 @@ type SystemdServiceWatch struct {
 func NewSystemdServiceWatch(conf SystemdServiceWatchConf) (*SystemdServiceWatch, error) {
 	w := new(SystemdServiceWatch)
 	w.Watch = NewWatch()
+	w.Name = "systemd_service"
 	w.SystemdServiceWatchConf = conf
 	w.watchCh = make(chan interface{}, 1)
 
@@ -138,6 +142,7 @@ func (w *SystemdServiceWatch) StartUnsafe() {
 					emitNodeDown()
 					global.AgentRuntimeState.SetDiscoveryState(global.NodeDiscoveryError)
 					w.Log.Errorw("watch error detecting systemd service", zap.Error(err))
+					w.ReportError(err)
 
 					continue
 				}
@@ -155,6 +160,7 @@ func (w *SystemdServiceWatch) StartUnsafe() {
 						reader, err := w.JournalReaderFunc(svc.Name)
 						if err != nil {
 							w.Log.Errorw("error creating journal reader", zap.Error(err))
+							w.ReportError(err)
 							continue
 						}
 
@@ -163,6 +169,7 @@ func (w *SystemdServiceWatch) StartUnsafe() {
 								w.Log.Warnw("error closing journal reader", zap.Error(err))
 							}
 							w.Log.Errorw("error reconfiguring node from systemd service", zap.Error(err))
+							w.ReportError(err)
 							continue
 						}
 
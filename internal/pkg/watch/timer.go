@@ -14,6 +14,7 @@
 package watch
 
 import (
+	"context"
 	"time"
 )
 
@@ -22,6 +23,10 @@ import (
 // TimerWatchConf TimerWatch configuration struct.
 type TimerWatchConf struct {
 	Interval time.Duration
+
+	// EmitOnStart emit right away on every start instead of waiting for
+	// the first interval.
+	EmitOnStart bool
 }
 
 // TimerWatch implements Watcher interface.
@@ -29,12 +34,16 @@ type TimerWatchConf struct {
 type TimerWatch struct {
 	TimerWatchConf
 	Watch
+
+	// emitTime emit the tick time rather than 0.
+	emitTime bool
 }
 
 // NewTimerWatch timer watch constructor.
 func NewTimerWatch(conf TimerWatchConf) *TimerWatch {
 	w := new(TimerWatch)
 	w.Watch = NewWatch()
+	w.Name = "timer"
 	w.TimerWatchConf = conf
 
 	if w.Interval < 1 {
@@ -45,25 +54,73 @@ func NewTimerWatch(conf TimerWatchConf) *TimerWatch {
 	return w
 }
 
+// NewTypedTimerWatch typed timer watch constructor, emitting the time of
+// each tick.
+func NewTypedTimerWatch(conf TimerWatchConf) *TypedWatch[time.Time] {
+	w := NewTimerWatch(conf)
+	w.emitTime = true
+
+	return AdaptWatch[time.Time](w, MismatchError)
+}
+
 // StartUnsafe sets watch running state to true
 // and starts the timer goroutine.
 func (w *TimerWatch) StartUnsafe() {
 	w.Watch.StartUnsafe()
 
-	w.wg.Add(1)
-	go w.timerLoop()
+	ctx, stop := w.runContext(), w.StopKey
+	w.Go(func() { w.timerLoop(ctx, stop) })
 }
 
-func (w *TimerWatch) timerLoop() {
-	defer w.wg.Done()
+// timerLoop emits until ctx is done or stop, the StopKey of the run it was
+// started for, is closed.
+func (w *TimerWatch) timerLoop(ctx context.Context, stop <-chan bool) {
+	if w.EmitOnStart {
+		w.tick()
+	}
 
 	for {
 		select {
-		case <-time.After(w.Interval):
-			w.Emit(0)
+		case <-time.After(w.interval()):
+			w.tick()
 
-		case <-w.StopKey:
+		case <-stop:
+			return
+
+		case <-ctx.Done():
 			return
 		}
 	}
 }
+
+func (w *TimerWatch) tick() {
+	if w.emitTime {
+		w.Emit(time.Now())
+
+		return
+	}
+
+	w.Emit(0)
+}
+
+// SetInterval changes the interval of the ticks following the current one.
+// Intervals lower than 1 are ignored.
+func (w *TimerWatch) SetInterval(interval time.Duration) {
+	if interval < 1 {
+		w.Log.Warnw("ignoring invalid timer interval, keeping the current one", "interval", interval)
+
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	w.Interval = interval
+}
+
+func (w *TimerWatch) interval() time.Duration {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.Interval
+}
@@ -0,0 +1,247 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestTimerWatch_Restart(t *testing.T) {
+	w := NewTimerWatch(TimerWatchConf{Interval: 5 * time.Millisecond})
+	ch := make(chan interface{}, 100)
+	w.Subscribe(ch)
+
+	for i := 0; i < 3; i++ {
+		Start(w)
+
+		// emissions resume
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("run %d: no emission after start", i)
+		}
+
+		// starting a running watch is a no-op
+		Start(w)
+
+		w.Stop()
+		w.Wait()
+
+		w.Lock()
+		require.False(t, w.Running)
+		w.Unlock()
+
+		// nothing is emitted while stopped
+		for len(ch) > 0 {
+			<-ch
+		}
+		select {
+		case <-ch:
+			t.Fatalf("run %d: emission after stop", i)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func TestRegistry_Restart(t *testing.T) {
+	w := NewTimerWatch(TimerWatchConf{Interval: 5 * time.Millisecond})
+	registry := &Registry{
+		watch:      []*WatcherInstance{},
+		Mutex:      &sync.Mutex{},
+		watcherMap: make(map[Watcher]struct{}),
+	}
+	require.NoError(t, registry.Register(w))
+
+	ch := make(chan interface{}, 100)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, registry.Start(ch))
+
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("run %d: no emission after start", i)
+		}
+
+		registry.Stop()
+		registry.Wait()
+	}
+
+	// ch is subscribed once
+	w.listenersMu.Lock()
+	defer w.listenersMu.Unlock()
+	require.Len(t, w.listeners, 1)
+}
+
+func TestTimerWatch_StartWithContext(t *testing.T) {
+	w := NewTimerWatch(TimerWatchConf{Interval: 5 * time.Millisecond})
+	ch := make(chan interface{}, 100)
+	w.Subscribe(ch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	StartWithContext(ctx, w)
+
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("no emission after start")
+	}
+
+	cancel()
+
+	// the timer goroutine exits
+	exited := make(chan struct{})
+	go func() {
+		w.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("timer goroutine still running after cancel")
+	}
+
+	// and the watch is stopped
+	require.Eventually(t, func() bool {
+		w.Lock()
+		defer w.Unlock()
+
+		return !w.Running
+	}, time.Second, time.Millisecond)
+
+	// it can be started again with a new context
+	StartWithContext(context.Background(), w)
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("no emission after restart")
+	}
+	w.Stop()
+	w.Wait()
+}
+
+func TestRegistry_StartWithContext(t *testing.T) {
+	w := NewTimerWatch(TimerWatchConf{Interval: 5 * time.Millisecond})
+	registry := &Registry{
+		watch:      []*WatcherInstance{},
+		Mutex:      &sync.Mutex{},
+		watcherMap: make(map[Watcher]struct{}),
+	}
+	require.NoError(t, registry.Register(w))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan interface{}, 100)
+	require.NoError(t, registry.StartWithContext(ctx, ch))
+
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("no emission after start")
+	}
+
+	cancel()
+	require.Eventually(t, func() bool {
+		w.Lock()
+		defer w.Unlock()
+
+		return !w.Running
+	}, time.Second, time.Millisecond)
+	registry.Wait()
+}
+
+func TestTimerWatch_EmitOnStart(t *testing.T) {
+	w := NewTimerWatch(TimerWatchConf{Interval: time.Hour, EmitOnStart: true})
+	ch := make(chan interface{}, 10)
+	w.Subscribe(ch)
+
+	// on every start, not only the first
+	for i := 0; i < 3; i++ {
+		Start(w)
+
+		select {
+		case <-ch:
+		case <-time.After(50 * time.Millisecond):
+			t.Fatalf("run %d: no emission on start", i)
+		}
+
+		w.Stop()
+		w.Wait()
+	}
+
+	require.Empty(t, ch)
+}
+
+func TestTimerWatch_SetInterval(t *testing.T) {
+	w := NewTimerWatch(TimerWatchConf{Interval: 5 * time.Millisecond})
+	ch := make(chan interface{}, 100)
+	w.Subscribe(ch)
+	Start(w)
+	defer func() {
+		w.Stop()
+		w.Wait()
+	}()
+
+	// spacing between the last two of n ticks
+	spacing := func(n int) time.Duration {
+		var last, prev time.Time
+		for i := 0; i < n; i++ {
+			select {
+			case <-ch:
+				prev, last = last, time.Now()
+			case <-time.After(time.Second):
+				t.Fatal("no tick")
+			}
+		}
+
+		return last.Sub(prev)
+	}
+
+	require.Less(t, spacing(3), 50*time.Millisecond)
+
+	// the tick in flight keeps the previous interval
+	w.SetInterval(100 * time.Millisecond)
+	for len(ch) > 0 {
+		<-ch
+	}
+	spacing(1)
+	require.GreaterOrEqual(t, spacing(2), 90*time.Millisecond)
+
+	w.SetInterval(0)
+	w.SetInterval(-time.Second)
+	require.Equal(t, 100*time.Millisecond, w.interval())
+}
+
+func TestTimerWatch_StopNoLeak(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	w := NewTimerWatch(TimerWatchConf{Interval: time.Millisecond})
+	ch := make(chan interface{}, 1)
+	w.SubscribeWithOptions(ch, SubOptions{Overflow: OverflowBlock})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	StartWithContext(ctx, w)
+
+	// blocked emitting to the full channel
+	<-ch
+	<-ch
+
+	w.Stop()
+	w.Unsubscribe(ch)
+}
@@ -0,0 +1,145 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// ErrTypeMismatch a watch emitted a message that isn't of the type of its
+// TypedWatch.
+var ErrTypeMismatch = errors.New("message type mismatch")
+
+// MismatchPolicy what a TypedWatch does with the messages that aren't of
+// its type.
+type MismatchPolicy int
+
+const (
+	// MismatchDrop discards the message.
+	MismatchDrop MismatchPolicy = iota
+
+	// MismatchError discards the message and reports an ErrTypeMismatch
+	// error to the OnError hooks of the watch.
+	MismatchError
+)
+
+// TypedWatch a typed view of a Watcher, its subscribers receive the
+// messages of type T without asserting them. The wrapped watcher is
+// embedded: it is started and stopped as usual, i.e. with
+// StartWithContext(ctx, tw.Watcher), and keeps serving its untyped
+// subscribers.
+type TypedWatch[T any] struct {
+	Watcher
+
+	watch    *Watch
+	mismatch MismatchPolicy
+
+	// mu held for reading by deliver, Unsubscribe takes it for writing so
+	// that no send is in flight once it returns.
+	mu   sync.RWMutex
+	subs []chan<- T
+
+	// mismatched number of messages discarded for not being a T, accessed
+	// atomically.
+	mismatched uint64
+}
+
+// NewTypedWatch TypedWatch constructor, wrapping a base watch emitting
+// only what is passed to Emit.
+func NewTypedWatch[T any]() *TypedWatch[T] {
+	w := NewWatch()
+	w.Name = "typed"
+
+	return AdaptWatch[T](&w, MismatchError)
+}
+
+// AdaptWatch wraps an existing watcher, the messages it emits that aren't a
+// T are handled according to mismatch.
+func AdaptWatch[T any](w Watcher, mismatch MismatchPolicy) *TypedWatch[T] {
+	tw := &TypedWatch[T]{Watcher: w, watch: w.base(), mismatch: mismatch}
+	tw.watch.subscribeFunc(tw.deliver)
+
+	return tw
+}
+
+// Subscribe adds a channel to the typed subscribers. Messages are discarded
+// while the channel is full.
+func (tw *TypedWatch[T]) Subscribe(handler chan<- T) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.subs = append(tw.subs[:len(tw.subs):len(tw.subs)], handler)
+}
+
+// Unsubscribe removes a channel from the typed subscribers. Once it returns
+// nothing is sent to the channel anymore, it can be closed.
+func (tw *TypedWatch[T]) Unsubscribe(handler chan<- T) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	subs := make([]chan<- T, 0, len(tw.subs))
+	for _, ch := range tw.subs {
+		if ch != handler {
+			subs = append(subs, ch)
+		}
+	}
+	tw.subs = subs
+}
+
+// Emit sends a message to the typed and untyped subscribers of the watch.
+func (tw *TypedWatch[T]) Emit(message T) {
+	tw.watch.Emit(message)
+}
+
+// Mismatched returns the number of messages discarded since the watch was
+// wrapped because they weren't a T.
+func (tw *TypedWatch[T]) Mismatched() uint64 {
+	return atomic.LoadUint64(&tw.mismatched)
+}
+
+// deliver sends a message emitted by the watch to the typed subscribers,
+// returns the number of them it was discarded for.
+func (tw *TypedWatch[T]) deliver(msg interface{}) int {
+	v, ok := msg.(T)
+	if !ok {
+		atomic.AddUint64(&tw.mismatched, 1)
+		if tw.mismatch == MismatchError {
+			err := fmt.Errorf("%w: got %T, want %v", ErrTypeMismatch, msg, reflect.TypeOf((*T)(nil)).Elem())
+			tw.watch.Log.Warnw("discarding message of unexpected type", zap.Error(err))
+			tw.watch.ReportError(err)
+		}
+
+		return 0
+	}
+
+	tw.mu.RLock()
+	defer tw.mu.RUnlock()
+
+	dropped := 0
+	for _, ch := range tw.subs {
+		select {
+		case ch <- v:
+		default:
+			dropped++
+		}
+	}
+
+	return dropped
+}
@@ -0,0 +1,124 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypedWatch_Emit(t *testing.T) {
+	tw := NewTypedWatch[string]()
+
+	typed := make(chan string, 10)
+	tw.Subscribe(typed)
+	untyped := make(chan interface{}, 10)
+	tw.Watcher.Subscribe(untyped)
+
+	tw.Emit("foo")
+	tw.Emit("bar")
+
+	require.Equal(t, "foo", <-typed)
+	require.Equal(t, "bar", <-typed)
+	require.Equal(t, []interface{}{"foo", "bar"}, receiveN(t, untyped, 2))
+
+	// nothing is sent once unsubscribed
+	tw.Unsubscribe(typed)
+	tw.Emit("baz")
+	require.Equal(t, "baz", <-untyped)
+	require.Empty(t, typed)
+}
+
+func TestAdaptWatch_Mismatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		mismatch   MismatchPolicy
+		wantErrors int
+	}{
+		{name: "drop", mismatch: MismatchDrop},
+		{name: "error", mismatch: MismatchError, wantErrors: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWatch()
+
+			var errs []error
+			w.OnError(func(err error) { errs = append(errs, err) })
+
+			tw := AdaptWatch[int](&w, tt.mismatch)
+			ch := make(chan int, 10)
+			tw.Subscribe(ch)
+
+			w.Emit(1)
+			w.Emit("2")
+			w.Emit(nil)
+			w.Emit(3)
+
+			require.Equal(t, 1, <-ch)
+			require.Equal(t, 3, <-ch)
+			require.Empty(t, ch)
+			require.Equal(t, uint64(2), tw.Mismatched())
+
+			require.Len(t, errs, tt.wantErrors)
+			for _, err := range errs {
+				require.ErrorIs(t, err, ErrTypeMismatch)
+			}
+			if tt.wantErrors > 0 {
+				require.EqualError(t, errs[0], "message type mismatch: got string, want int")
+			}
+		})
+	}
+}
+
+func TestTypedWatch_Full(t *testing.T) {
+	tw := NewTypedWatch[int]()
+
+	ch := make(chan int, 1)
+	tw.Subscribe(ch)
+
+	tw.Emit(1)
+	tw.Emit(2)
+
+	require.Equal(t, 1, <-ch)
+	require.Equal(t, uint64(1), tw.watch.Dropped())
+}
+
+func TestNewTypedTimerWatch(t *testing.T) {
+	tw := NewTypedTimerWatch(TimerWatchConf{Interval: 5 * time.Millisecond, EmitOnStart: true})
+
+	ch := make(chan time.Time, 10)
+	tw.Subscribe(ch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	StartWithContext(ctx, tw.Watcher)
+	t.Cleanup(func() {
+		cancel()
+		tw.Wait()
+	})
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		select {
+		case tick := <-ch:
+			require.False(t, tick.Before(start.Add(-time.Second)))
+		case <-time.After(time.Second):
+			t.Fatal("no tick")
+		}
+	}
+	require.Zero(t, tw.Mismatched())
+}
@@ -14,8 +14,12 @@
 package watch
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"agent/api/v1/model"
 	"agent/internal/pkg/global"
@@ -24,6 +28,10 @@ import (
 	"go.uber.org/zap"
 )
 
+// ErrStopTimeout the goroutines of a watch didn't return in time, see
+// Watch.StopWithTimeout.
+var ErrStopTimeout = errors.New("watch goroutines still running after stop")
+
 // Watcher is an interface for implementing metric collection.
 type Watcher interface {
 	StartUnsafe()
@@ -31,13 +39,78 @@ type Watcher interface {
 	Wait()
 
 	Subscribe(chan<- interface{})
+	Unsubscribe(chan<- interface{})
+
+	// LastEmit returns the time of the watch's last emission, zero if
+	// it has not emitted yet.
+	LastEmit() time.Time
+
+	// OnError registers a hook called with the runtime errors of the
+	// watch, i.e. read failures, reconnections and parse errors.
+	OnError(hook func(error))
+
+	// Status returns the watch's running state and emission counters.
+	Status() WatchStatus
 
 	once() *sync.Once
+	setContext(ctx context.Context)
+	stopKey() <-chan bool
+	base() *Watch
+}
+
+// Prober is implemented by watchers able to check, before starting, that
+// they can produce data on this host.
+type Prober interface {
+	Probe() error
 }
 
-// Start starts a watcher once.
+// Start starts a watcher once per run, a stopped watcher can be started
+// again.
+//
+// Deprecated: use StartWithContext.
 func Start(watcher Watcher) {
-	watcher.once().Do(watcher.StartUnsafe)
+	StartWithContext(context.Background(), watcher)
+}
+
+// StartWithContext starts a watcher once per run, a stopped watcher can be
+// started again. The watcher is stopped when ctx is done.
+func StartWithContext(ctx context.Context, watcher Watcher) {
+	watcher.once().Do(func() {
+		watcher.setContext(ctx)
+		watcher.StartUnsafe()
+
+		if ctx.Done() == nil {
+			// never cancelled
+			return
+		}
+
+		stop := watcher.stopKey()
+		go func() {
+			select {
+			case <-ctx.Done():
+				watcher.Stop()
+			case <-stop:
+			}
+		}()
+	})
+}
+
+// StopWithTimeout stops a watcher like its Stop method, but returns
+// ErrStopTimeout if it hasn't returned within timeout. It is left to stop
+// on its own.
+func StopWithTimeout(watcher Watcher, timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		watcher.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return ErrStopTimeout
+	}
 }
 
 // Watch is the base Watch implementation used by all implemented
@@ -46,34 +119,120 @@ func Start(watcher Watcher) {
 type Watch struct {
 	Running bool
 
+	// Name identifies the watch in its self-metrics and events, set by the
+	// constructors to the watch kind, i.e. timer.
+	Name string
+
+	// StopKey closed by Stop, renewed when the watch is started again.
 	StopKey chan bool
 	wg      *sync.WaitGroup
 
+	// stopped whether StopKey is closed.
+	stopped bool
+
+	// stopWaiters number of Stop calls waiting for the goroutines, a new
+	// run doesn't start before they return. stopCond signals its changes.
+	stopWaiters int
+	stopCond    *sync.Cond
+
+	// ctx context the watch was started with, its goroutines return once
+	// it is done.
+	ctx context.Context
+
 	startOnce  *sync.Once
-	listeners  []chan<- interface{}
+	listeners  []*subscription
 	Log        *zap.SugaredLogger
 	blockchain global.Chain
 	*sync.Mutex
+
+	// errorHooks called by ReportError.
+	errorHooks []func(error)
+
+	// listenersMu guards listeners and errorHooks, the slices are replaced
+	// on change so Emit and ReportError iterate over a snapshot without
+	// holding it.
+	listenersMu *sync.Mutex
+
+	// lastEmitNanos unix time of the last emission, accessed atomically.
+	lastEmitNanos int64
+
+	// emitCount number of messages emitted, accessed atomically.
+	emitCount uint64
+
+	// startedAt time of the last start, lastError and lastErrorAt the last
+	// reported error, guarded by the mutex.
+	startedAt   time.Time
+	lastError   error
+	lastErrorAt time.Time
+
+	// dropped number of messages discarded by subscriptions, accessed
+	// atomically.
+	dropped uint64
+
+	// filterPanics number of subscriptions disabled by their panicking
+	// filter, accessed atomically.
+	filterPanics uint64
+
+	// limiter rate limit of Emit, nil if unlimited. suppressed number of
+	// messages it suppressed, accessed atomically.
+	limiter    *rateLimiter
+	suppressed uint64
 }
 
 // NewWatch base watch constructor
 func NewWatch() Watch {
+	mu := &sync.Mutex{}
+
 	return Watch{
-		Running:    false,
-		StopKey:    make(chan bool, 1),
-		startOnce:  &sync.Once{},
-		Log:        zap.S(),
-		wg:         &sync.WaitGroup{},
-		Mutex:      &sync.Mutex{},
-		blockchain: global.BlockchainNode(),
+		Running:     false,
+		StopKey:     make(chan bool, 1),
+		startOnce:   &sync.Once{},
+		Log:         zap.S(),
+		wg:          &sync.WaitGroup{},
+		Mutex:       mu,
+		stopCond:    sync.NewCond(mu),
+		ctx:         context.Background(),
+		listenersMu: &sync.Mutex{},
+		blockchain:  global.BlockchainNode(),
 	}
 }
 
-// StartUnsafe sets watch running state to true
+// StartUnsafe sets watch running state to true. If the watch was stopped,
+// it first waits for the goroutines of the previous run to exit and renews
+// StopKey.
 func (w *Watch) StartUnsafe() {
+	w.Lock()
+	stopped := w.stopped
+	w.Unlock()
+
+	if stopped {
+		w.wg.Wait()
+	}
+
 	w.Lock()
 	defer w.Unlock()
+
+	for w.stopWaiters > 0 {
+		w.stopCond.Wait()
+	}
+
+	if w.stopped {
+		w.StopKey = make(chan bool, 1)
+		w.stopped = false
+	}
 	w.Running = true
+	w.startedAt = time.Now()
+}
+
+// Go runs f in a goroutine of the watch, Stop and Wait wait for it to
+// return. f must return once the StopKey of the run is closed.
+func (w *Watch) Go(f func()) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer global.ReportPanic()
+		f()
+	}()
 }
 
 // Wait blocks waiting for watch goroutine to finish.
@@ -81,39 +240,345 @@ func (w *Watch) Wait() {
 	w.wg.Wait()
 }
 
-// Stop stops the watch
+// Stop stops the watch and waits for its goroutines to return. The
+// goroutines of the watch must not call it, it would wait for them.
 func (w *Watch) Stop() {
+	<-w.stopAndWait()
+}
+
+// StopWithTimeout is like Stop but returns ErrStopTimeout if the goroutines
+// haven't returned within timeout, they are left to return on their own.
+func (w *Watch) StopWithTimeout(timeout time.Duration) error {
+	select {
+	case <-w.stopAndWait():
+		return nil
+	case <-time.After(timeout):
+		return ErrStopTimeout
+	}
+}
+
+// stopAndWait stops the watch, the returned channel is closed once its
+// goroutines returned.
+func (w *Watch) stopAndWait() <-chan struct{} {
+	w.Lock()
+	w.stopUnsafe()
+	w.stopWaiters++
+	w.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+
+		w.Lock()
+		w.stopWaiters--
+		w.stopCond.Broadcast()
+		w.Unlock()
+
+		close(done)
+	}()
+
+	return done
+}
+
+// signalStop stops the watch without waiting for its goroutines to return,
+// for the goroutines of the watch to stop it.
+func (w *Watch) signalStop() {
 	w.Lock()
 	defer w.Unlock()
+
+	w.stopUnsafe()
+}
+
+func (w *Watch) stopUnsafe() {
 	if !w.Running {
 		return
 	}
 	w.Running = false
 
 	close(w.StopKey)
+	w.stopped = true
+
+	// let Start run the watch again
+	w.startOnce = &sync.Once{}
 }
 
 func (w *Watch) once() *sync.Once {
+	w.Lock()
+	defer w.Unlock()
+
 	return w.startOnce
 }
 
+func (w *Watch) setContext(ctx context.Context) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.ctx = ctx
+}
+
+// runContext returns the context the watch was started with.
+func (w *Watch) runContext() context.Context {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.ctx
+}
+
+// stopKey returns the StopKey of the current run.
+func (w *Watch) stopKey() <-chan bool {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.StopKey
+}
+
+// base returns the Watch embedded by a watcher.
+func (w *Watch) base() *Watch {
+	return w
+}
+
 // Subscription mechanism
 
-// Subscribe adds a channel to the subscribed listeners slice.
+// Subscribe adds a channel to the subscribed listeners. Messages are
+// discarded while the channel is full. It is safe to call while the watch
+// emits: a message emitted concurrently with Subscribe may or may not be
+// sent to the channel, the ones emitted after it returns are.
 func (w *Watch) Subscribe(handler chan<- interface{}) {
-	w.listeners = append(w.listeners, handler)
+	w.SubscribeWithOptions(handler, SubOptions{})
+}
+
+// SubscribeWithOptions adds a channel to the subscribed listeners, buffered
+// and handling overflows as set by opts.
+func (w *Watch) SubscribeWithOptions(handler chan<- interface{}, opts SubOptions) {
+	s := newSubscription(handler, opts)
+	if s.buf != nil {
+		go s.forward()
+	}
+
+	w.listenersMu.Lock()
+	defer w.listenersMu.Unlock()
+
+	w.listeners = append(w.listeners[:len(w.listeners):len(w.listeners)], s)
+}
+
+// subscribeFunc adds a listener calling deliver with the messages, in place
+// of sending them to a channel. It can't be unsubscribed.
+func (w *Watch) subscribeFunc(deliver func(interface{}) int) {
+	s := newSubscription(nil, SubOptions{})
+	s.deliver = deliver
+
+	w.listenersMu.Lock()
+	defer w.listenersMu.Unlock()
+
+	w.listeners = append(w.listeners[:len(w.listeners):len(w.listeners)], s)
+}
+
+// SubscribeFiltered adds a channel to the subscribed listeners, sent the
+// messages pred returns true for. See SubOptions.Filter.
+func (w *Watch) SubscribeFiltered(handler chan<- interface{}, pred func(interface{}) bool) {
+	w.SubscribeWithOptions(handler, SubOptions{Filter: pred})
+}
+
+// Unsubscribe removes a channel from the subscribed listeners. Once it
+// returns nothing is sent to the channel anymore, it can be closed.
+func (w *Watch) Unsubscribe(handler chan<- interface{}) {
+	w.listenersMu.Lock()
+	listeners := make([]*subscription, 0, len(w.listeners))
+	var removed []*subscription
+	for _, s := range w.listeners {
+		if s.ch == handler && s.deliver == nil {
+			removed = append(removed, s)
+
+			continue
+		}
+		listeners = append(listeners, s)
+	}
+	w.listeners = listeners
+	w.listenersMu.Unlock()
+
+	// wait for the sends in flight, if any
+	for _, s := range removed {
+		s.cancel()
+	}
+}
+
+// SetRateLimit limits the messages emitted by the watch, the ones over the
+// limit are suppressed and a summary of them is emitted periodically. A
+// zero Rate removes the limit. It must be called before the watch is
+// started.
+func (w *Watch) SetRateLimit(limit RateLimit) {
+	w.limiter = newRateLimiter(&limit)
 }
 
 // Emit sends a message to all subscribed channels (i.e publisher, exporter)
 func (w *Watch) Emit(message interface{}) {
-	for i, handler := range w.listeners {
-		select {
-		case handler <- message:
-		default:
-			zap.S().Warnw("handler channel blocked a metric, discarding it", "handler_no", i)
-			global.MetricsDropCnt.WithLabelValues("channel_blocked").Inc()
+	if w.limiter != nil {
+		allowed, summary := w.limiter.allow()
+		if summary != nil {
+			w.emit(summary)
 		}
+		if !allowed {
+			w.suppress()
+
+			return
+		}
+	}
+
+	w.emit(message)
+}
+
+// suppress accounts for a message suppressed by a rate limit.
+func (w *Watch) suppress() {
+	global.MetricsDropCnt.WithLabelValues("rate_limited").Inc()
+	atomic.AddUint64(&w.suppressed, 1)
+}
+
+// emit sends a message to the subscribed channels, bypassing the rate limit
+// of the watch.
+func (w *Watch) emit(message interface{}) {
+	atomic.StoreInt64(&w.lastEmitNanos, time.Now().UnixNano())
+	atomic.AddUint64(&w.emitCount, 1)
+
+	w.listenersMu.Lock()
+	listeners := w.listeners
+	w.listenersMu.Unlock()
+
+	stop := w.stopKey()
+	for i, s := range listeners {
+		match, err := s.match(message)
+		if err != nil {
+			w.Log.Errorw("disabling the subscription", "handler_no", i, zap.Error(err))
+			atomic.AddUint64(&w.filterPanics, 1)
+			w.ReportError(err)
+		}
+		if !match {
+			continue
+		}
+
+		if s.limiter != nil {
+			allowed, summary := s.limiter.allow()
+			if summary != nil {
+				w.countDropped(i, s.send(summary, stop))
+			}
+			if !allowed {
+				w.suppress()
+
+				continue
+			}
+		}
+
+		w.countDropped(i, s.send(message, stop))
+	}
+}
+
+// countDropped accounts for the messages a subscription dropped.
+func (w *Watch) countDropped(handlerNo, dropped int) {
+	if dropped == 0 {
+		return
 	}
+
+	zap.S().Warnw("handler channel blocked a metric, discarding it", "handler_no", handlerNo, "dropped", dropped)
+	global.MetricsDropCnt.WithLabelValues("channel_blocked").Add(float64(dropped))
+	atomic.AddUint64(&w.dropped, uint64(dropped))
+}
+
+// OnError registers a hook called by ReportError. Hooks run on the
+// reporting goroutine and must not block, they hold the watch back
+// meanwhile.
+func (w *Watch) OnError(hook func(error)) {
+	w.listenersMu.Lock()
+	defer w.listenersMu.Unlock()
+
+	w.errorHooks = append(w.errorHooks[:len(w.errorHooks):len(w.errorHooks)], hook)
+}
+
+// ReportError passes a runtime error of the watch to the OnError hooks, it
+// is a no-op if err is nil. The watch keeps running, errors are reported
+// alongside logging them.
+func (w *Watch) ReportError(err error) {
+	if err == nil {
+		return
+	}
+
+	w.Lock()
+	w.lastError, w.lastErrorAt = err, time.Now()
+	w.Unlock()
+
+	w.listenersMu.Lock()
+	hooks := w.errorHooks
+	w.listenersMu.Unlock()
+
+	for _, hook := range hooks {
+		hook(err)
+	}
+}
+
+// WatchStatus the running state and emission counters of a watch, see
+// Watch.Status. Times are zero if the watch never started, emitted or
+// failed.
+type WatchStatus struct {
+	Running     bool      `json:"running"`
+	StartedAt   time.Time `json:"started_at"`
+	LastEmitAt  time.Time `json:"last_emit_at"`
+	EmitCount   uint64    `json:"emit_count"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at"`
+}
+
+// Status returns the watch status, it is safe to call while the watch
+// emits.
+func (w *Watch) Status() WatchStatus {
+	w.Lock()
+	status := WatchStatus{
+		Running:     w.Running,
+		StartedAt:   w.startedAt,
+		LastErrorAt: w.lastErrorAt,
+	}
+	if w.lastError != nil {
+		status.LastError = w.lastError.Error()
+	}
+	w.Unlock()
+
+	status.LastEmitAt = w.LastEmit()
+	status.EmitCount = atomic.LoadUint64(&w.emitCount)
+
+	return status
+}
+
+// Subscribers returns the number of subscriptions of the watch.
+func (w *Watch) Subscribers() int {
+	w.listenersMu.Lock()
+	defer w.listenersMu.Unlock()
+
+	return len(w.listeners)
+}
+
+// Dropped returns the number of messages discarded by the subscriptions
+// since the watch was created.
+func (w *Watch) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// Suppressed returns the number of messages suppressed by the rate limits
+// of the watch and of its subscriptions since the watch was created.
+func (w *Watch) Suppressed() uint64 {
+	return atomic.LoadUint64(&w.suppressed)
+}
+
+// FilterPanics returns the number of subscriptions disabled since the
+// watch was created because their filter panicked.
+func (w *Watch) FilterPanics() uint64 {
+	return atomic.LoadUint64(&w.filterPanics)
+}
+
+// LastEmit returns the time of the last emission, zero if none.
+func (w *Watch) LastEmit() time.Time {
+	nanos := atomic.LoadInt64(&w.lastEmitNanos)
+	if nanos == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, nanos)
 }
 
 func (w *Watch) parseJSON(body []byte) (map[string]interface{}, error) {
@@ -127,6 +592,9 @@ func (w *Watch) parseJSON(body []byte) (map[string]interface{}, error) {
 }
 
 func (w *Watch) emitNodeLogEvents(evs map[string]model.FromContext, body map[string]interface{}) {
+	// node logs may carry local time, events are always in UTC
+	model.NormalizeTimeKeys(body, model.TimeKeys...)
+
 	// search for & emit events
 	for _, event := range evs {
 		ev, err := event.New(body, timesync.Now())
@@ -153,7 +621,16 @@ func (w *Watch) emitNodeLogEvents(evs map[string]model.FromContext, body map[str
 }
 
 func (w *Watch) emitAgentNodeEvent(name string) {
-	ctx := map[string]interface{}{}
+	w.emitAgentNodeEventWithCtx(name, map[string]interface{}{})
+}
+
+// emitAgentNodeEventWithCtx emits an agent node event, ctx is extended
+// with the node's details.
+func (w *Watch) emitAgentNodeEventWithCtx(name string, ctx map[string]interface{}) {
+	switch name {
+	case model.AgentNodeUpName, model.AgentNodeDownName, model.AgentNodeRestartName:
+		global.AgentRuntimeState.SetNodeStatus(name)
+	}
 
 	nodeID := w.blockchain.NodeID()
 	if nodeID != "" {
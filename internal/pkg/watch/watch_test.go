@@ -14,8 +14,13 @@
 package watch
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"io"
+	"sync"
 	"testing"
+	"time"
 
 	"agent/api/v1/model"
 	"agent/internal/pkg/global"
@@ -226,3 +231,161 @@ func TestWatch_EmitAgentNodeEvents(t *testing.T) {
 		})
 	}
 }
+
+func TestWatch_ReportError(t *testing.T) {
+	w := NewWatch()
+
+	var (
+		mu       sync.Mutex
+		reported []error
+	)
+	for i := 0; i < 2; i++ {
+		w.OnError(func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			reported = append(reported, err)
+		})
+	}
+
+	errRead := errors.New("read failed")
+	w.ReportError(errRead)
+	w.ReportError(nil)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []error{errRead, errRead}, reported)
+}
+
+func TestWatch_ReportErrorNotBlockingEmit(t *testing.T) {
+	w := NewWatch()
+
+	ch := make(chan interface{}, 1)
+	w.Subscribe(ch)
+
+	// a hook stuck on the reporting goroutine
+	release := make(chan struct{})
+	called := make(chan struct{})
+	w.OnError(func(error) {
+		close(called)
+		<-release
+	})
+	go w.ReportError(errors.New("parse error"))
+	<-called
+	defer close(release)
+
+	emitted := make(chan struct{})
+	go func() {
+		w.Emit("line")
+		w.OnError(func(error) {})
+		close(emitted)
+	}()
+
+	select {
+	case <-emitted:
+	case <-time.After(time.Second):
+		t.Fatal("emit blocked by the error hook")
+	}
+	require.Equal(t, "line", <-ch)
+}
+
+func TestWatch_Status(t *testing.T) {
+	w := NewWatch()
+	require.Equal(t, WatchStatus{}, w.Status())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	StartWithContext(ctx, &w)
+
+	status := w.Status()
+	require.True(t, status.Running)
+	require.False(t, status.StartedAt.IsZero())
+	require.Zero(t, status.EmitCount)
+	require.True(t, status.LastEmitAt.IsZero())
+
+	// counted under concurrent emits
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				w.Emit(j)
+				_ = w.Status()
+			}
+		}()
+	}
+	wg.Wait()
+
+	prev := w.Status()
+	require.Equal(t, uint64(1000), prev.EmitCount)
+	require.False(t, prev.LastEmitAt.Before(prev.StartedAt))
+
+	for i := 0; i < 3; i++ {
+		w.Emit(i)
+
+		status := w.Status()
+		require.Equal(t, prev.EmitCount+1, status.EmitCount)
+		require.False(t, status.LastEmitAt.Before(prev.LastEmitAt))
+		prev = status
+	}
+
+	w.ReportError(errors.New("read failed"))
+	status = w.Status()
+	require.Equal(t, "read failed", status.LastError)
+	require.False(t, status.LastErrorAt.Before(prev.LastEmitAt))
+
+	w.Stop()
+	status = w.Status()
+	require.False(t, status.Running)
+
+	b, err := json.Marshal(status)
+	require.NoError(t, err)
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	require.Equal(t, false, decoded["running"])
+	require.Equal(t, float64(1003), decoded["emit_count"])
+	require.Equal(t, "read failed", decoded["last_error"])
+	require.Contains(t, decoded, "started_at")
+	require.Contains(t, decoded, "last_emit_at")
+}
+
+func TestWatch_StopWaits(t *testing.T) {
+	w := NewWatch()
+	Start(&w)
+
+	// a slow exit path
+	exited := make(chan struct{})
+	stop := w.stopKey()
+	w.Go(func() {
+		<-stop
+		time.Sleep(50 * time.Millisecond)
+		close(exited)
+	})
+
+	w.Stop()
+	select {
+	case <-exited:
+	default:
+		t.Fatal("stop returned before the goroutine")
+	}
+}
+
+func TestWatch_StopWithTimeout(t *testing.T) {
+	w := NewWatch()
+	Start(&w)
+
+	release := make(chan struct{})
+	w.Go(func() { <-release })
+
+	require.ErrorIs(t, w.StopWithTimeout(20*time.Millisecond), ErrStopTimeout)
+
+	close(release)
+	w.Wait()
+
+	// nothing left to wait for
+	Start(&w)
+	require.NoError(t, w.StopWithTimeout(time.Second))
+}
@@ -0,0 +1,170 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"reflect"
+	"time"
+
+	"agent/api/v1/model"
+	"agent/pkg/timesync"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// NoCadence exempts a watcher from stall detection, used by watchers
+	// with an inherently irregular cadence (i.e. log tails, docker events).
+	NoCadence time.Duration = 0
+
+	// DefaultCadenceFactor number of intervals a periodic watcher may
+	// go without emitting before it is considered stalled.
+	DefaultCadenceFactor = 3
+
+	// defaultWatchdogInterval default period between two stall checks.
+	defaultWatchdogInterval = 15 * time.Second
+)
+
+// ExpectedCadence returns the cadence of a watcher emitting every interval.
+func ExpectedCadence(interval time.Duration) time.Duration {
+	return DefaultCadenceFactor * interval
+}
+
+// stallRestarter is implemented by watchers that can restart themselves
+// when the watchdog finds them stalled (i.e. supervised watchers).
+type stallRestarter interface {
+	restartStalled()
+}
+
+// WatchdogConf Watchdog configuration struct.
+type WatchdogConf struct {
+	// Registry the registry holding the watched watchers and their cadence.
+	Registry WatchersRegisterer
+
+	// Interval period between two stall checks.
+	Interval time.Duration
+}
+
+// Watchdog periodically checks the registered watchers against their
+// declared cadence and emits an agent.watch.stalled event for every
+// watcher that stopped emitting. Stalled watchers implementing
+// stallRestarter are restarted.
+type Watchdog struct {
+	WatchdogConf
+	Watch
+
+	// stalled watchers already reported, reported again only after they
+	// recover.
+	stalled map[Watcher]bool
+}
+
+// NewWatchdog Watchdog constructor.
+func NewWatchdog(conf WatchdogConf) *Watchdog {
+	w := &Watchdog{
+		WatchdogConf: conf,
+		Watch:        NewWatch(),
+		stalled:      map[Watcher]bool{},
+	}
+
+	w.Name = "watchdog"
+
+	if w.Interval <= 0 {
+		w.Interval = defaultWatchdogInterval
+	}
+
+	return w
+}
+
+// StartUnsafe starts the goroutine checking for stalled watchers.
+func (w *Watchdog) StartUnsafe() {
+	w.Watch.StartUnsafe()
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		for {
+			select {
+			case <-time.After(w.Interval):
+				w.check(time.Now())
+			case <-w.StopKey:
+				return
+			}
+		}
+	}()
+}
+
+func (w *Watchdog) check(now time.Time) {
+	stalled := map[Watcher]bool{}
+	for _, s := range w.Registry.Stalled(now) {
+		if s.Watcher == Watcher(w) {
+			continue
+		}
+
+		stalled[s.Watcher] = true
+		if w.stalled[s.Watcher] {
+			continue
+		}
+
+		name := watcherName(s.Watcher)
+		lastEmit := "never"
+		if !s.LastEmit.IsZero() {
+			lastEmit = s.LastEmit.UTC().Format(time.RFC3339)
+		}
+		w.Log.Warnw("watch stalled", "watch", name, "cadence", s.Cadence, "last_emit", lastEmit)
+
+		w.emitStalled(map[string]interface{}{
+			model.WatchKey:    name,
+			model.CadenceKey:  s.Cadence.String(),
+			model.LastEmitKey: lastEmit,
+		})
+
+		if r, ok := s.Watcher.(stallRestarter); ok {
+			w.Log.Infow("restarting stalled watch", "watch", name)
+			r.restartStalled()
+		}
+	}
+
+	w.stalled = stalled
+}
+
+func (w *Watchdog) emitStalled(ctx map[string]interface{}) {
+	ev, err := model.NewWithCtx(ctx, model.AgentWatchStalledName, timesync.Now())
+	if err != nil {
+		w.Log.Errorw("error creating event", zap.Error(err))
+
+		return
+	}
+
+	w.Emit(&model.Message{
+		Name:  ev.Name,
+		Value: &model.Message_Event{Event: ev},
+	})
+}
+
+// watcherName returns a name identifying w, its watch type if it has one,
+// else its Name.
+func watcherName(w Watcher) string {
+	v := reflect.Indirect(reflect.ValueOf(w))
+	if v.Kind() == reflect.Struct {
+		if t := v.FieldByName("Type"); t.IsValid() && t.Kind() == reflect.String && t.String() != "" {
+			return t.String()
+		}
+	}
+	if name := w.base().Name; name != "" {
+		return name
+	}
+
+	return reflect.TypeOf(w).String()
+}
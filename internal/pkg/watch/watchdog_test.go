@@ -0,0 +1,109 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"agent/api/v1/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+// restartableTimerWatch a TimerWatch recording watchdog restarts.
+type restartableTimerWatch struct {
+	*TimerWatch
+	restarts int
+}
+
+func (r *restartableTimerWatch) restartStalled() {
+	r.restarts++
+}
+
+func newTestRegistry() *Registry {
+	return &Registry{
+		watch:      []*WatcherInstance{},
+		Mutex:      &sync.Mutex{},
+		watcherMap: make(map[Watcher]struct{}),
+	}
+}
+
+func TestRegistry_Stalled(t *testing.T) {
+	registry := newTestRegistry()
+
+	periodic := NewTimerWatch(TimerWatchConf{Interval: time.Hour})
+	irregular := NewTimerWatch(TimerWatchConf{Interval: time.Hour})
+	require.NoError(t, registry.RegisterWithCadence(ExpectedCadence(time.Second), periodic))
+	require.NoError(t, registry.Register(irregular))
+
+	// not started yet
+	require.Empty(t, registry.Stalled(time.Now().Add(time.Hour)))
+
+	require.NoError(t, registry.Start(make(chan interface{}, 10)))
+	defer registry.Stop()
+
+	now := time.Now()
+	require.Empty(t, registry.Stalled(now))
+
+	stalled := registry.Stalled(now.Add(4 * time.Second))
+	require.Len(t, stalled, 1)
+	require.Equal(t, Watcher(periodic), stalled[0].Watcher)
+	require.Equal(t, 3*time.Second, stalled[0].Cadence)
+	require.True(t, stalled[0].LastEmit.IsZero())
+
+	// emitting resets the stall
+	periodic.Emit(0)
+	require.Empty(t, registry.Stalled(time.Now().Add(2*time.Second)))
+}
+
+func TestWatchdog(t *testing.T) {
+	registry := newTestRegistry()
+
+	supervised := &restartableTimerWatch{TimerWatch: NewTimerWatch(TimerWatchConf{Interval: time.Hour})}
+	require.NoError(t, registry.RegisterWithCadence(time.Second, supervised))
+	require.NoError(t, registry.Start(make(chan interface{}, 10)))
+	defer registry.Stop()
+
+	wd := NewWatchdog(WatchdogConf{Registry: registry})
+	ch := make(chan interface{}, 10)
+	wd.Subscribe(ch)
+
+	// stalls are reported once and restart supervised watchers
+	wd.check(time.Now().Add(2 * time.Second))
+	wd.check(time.Now().Add(3 * time.Second))
+	require.Len(t, ch, 1)
+	require.Equal(t, 1, supervised.restarts)
+
+	ev := (<-ch).(*model.Message).GetEvent()
+	require.NotNil(t, ev)
+	require.Equal(t, model.AgentWatchStalledName, ev.Name)
+	require.Equal(t, "timer", ev.Values.AsMap()[model.WatchKey])
+	require.Equal(t, "1s", ev.Values.AsMap()[model.CadenceKey])
+	require.Equal(t, "never", ev.Values.AsMap()[model.LastEmitKey])
+
+	// reported again after recovering
+	supervised.Emit(0)
+	wd.check(time.Now())
+	wd.check(time.Now().Add(2 * time.Second))
+	require.Len(t, ch, 1)
+	require.Equal(t, 2, supervised.restarts)
+}
+
+func TestWatcherName(t *testing.T) {
+	require.Equal(t, "prometheus.proc.cpu", watcherName(NewCollectorWatch(CollectorWatchConf{Type: "prometheus.proc.cpu"})))
+	require.Equal(t, "timer", watcherName(NewTimerWatch(TimerWatchConf{})))
+	require.Equal(t, "*watch.scriptedWatch", watcherName(newScriptedWatch(false, nil)))
+}
@@ -0,0 +1,295 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// ErrWebSocketWatchConf websocket watch configuration error.
+var ErrWebSocketWatchConf = errors.New("websocket watch configuration error")
+
+const (
+	defaultWebSocketPingInterval     = 30 * time.Second
+	defaultWebSocketHandshakeTimeout = 10 * time.Second
+	defaultWebSocketRetryIntv        = time.Second
+	defaultWebSocketMaxBackoff       = time.Minute
+
+	// webSocketWriteTimeout how long a control or subscribe message may
+	// take to be sent.
+	webSocketWriteTimeout = 5 * time.Second
+
+	// maxWebSocketMessageBytes longest message accepted, the connection is
+	// closed and reestablished on longer ones.
+	maxWebSocketMessageBytes = 16 << 20
+)
+
+// WebSocketWatchConf WebSocketWatch configuration struct.
+type WebSocketWatchConf struct {
+	// URL the ws:// or wss:// endpoint to dial.
+	URL     string
+	Headers map[string]string
+
+	// TLSConfig TLS configuration of the client, i.e. built with
+	// NewHTTPTLSConfig to trust a custom CA.
+	TLSConfig *tls.Config
+
+	// SubscribePayload sent as a text message after each connection.
+	SubscribePayload []byte
+
+	// OnConnect called after each connection, once SubscribePayload is
+	// sent, i.e. to resubscribe from the last message received. send writes
+	// a text message. The connection is reestablished if it returns an
+	// error.
+	OnConnect func(send func([]byte) error) error
+
+	// JSON decode the messages as JSON and emit the decoded value rather
+	// than the raw message bytes.
+	JSON bool
+
+	// PingInterval how often a ping is sent. Defaults to 30s.
+	PingInterval time.Duration
+
+	// ReadTimeout how long the connection may stay silent, pongs included,
+	// before it is reestablished. Defaults to twice PingInterval.
+	ReadTimeout time.Duration
+
+	// RetryIntv delay before the first reconnection, it doubles on every
+	// failed attempt up to MaxBackoff.
+	RetryIntv  time.Duration
+	MaxBackoff time.Duration
+}
+
+// WebSocketWatch implements Watcher interface.
+// Streams the messages pushed over a websocket connection, i.e. the new
+// blocks of a node, and emits each of them as []byte, or as its decoded
+// value if JSON is set. The connection is kept alive with pings and
+// reestablished with an exponential backoff when it fails.
+type WebSocketWatch struct {
+	WebSocketWatchConf
+	Watch
+
+	dialer *websocket.Dialer
+	header http.Header
+}
+
+// NewWebSocketWatch WebSocketWatch constructor, returns an error if the URL
+// is invalid.
+func NewWebSocketWatch(conf WebSocketWatchConf) (*WebSocketWatch, error) {
+	u, err := url.Parse(conf.URL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid url: %v", ErrWebSocketWatchConf, err)
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, fmt.Errorf("%w: unsupported url scheme %q", ErrWebSocketWatchConf, u.Scheme)
+	}
+
+	w := new(WebSocketWatch)
+	w.Watch = NewWatch()
+	w.Name = "websocket"
+	w.WebSocketWatchConf = conf
+	w.Log = w.Log.With("url", u.Redacted())
+
+	if w.PingInterval < 1 {
+		w.PingInterval = defaultWebSocketPingInterval
+	}
+	if w.ReadTimeout < 1 {
+		w.ReadTimeout = 2 * w.PingInterval
+	}
+	if w.RetryIntv < 1 {
+		w.RetryIntv = defaultWebSocketRetryIntv
+	}
+	if w.MaxBackoff < 1 {
+		w.MaxBackoff = defaultWebSocketMaxBackoff
+	}
+	if w.MaxBackoff < w.RetryIntv {
+		w.MaxBackoff = w.RetryIntv
+	}
+
+	w.dialer = &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: defaultWebSocketHandshakeTimeout,
+		TLSClientConfig:  w.TLSConfig,
+	}
+
+	w.header = http.Header{}
+	for k, v := range w.Headers {
+		w.header.Set(k, v)
+	}
+
+	return w, nil
+}
+
+// StartUnsafe starts the goroutine maintaining the connection.
+func (w *WebSocketWatch) StartUnsafe() {
+	w.Watch.StartUnsafe()
+
+	w.wg.Add(1)
+	go w.connLoop(w.runContext(), w.StopKey)
+}
+
+// connLoop (re)establishes the connection until ctx is done or stop, the
+// StopKey of the run it was started for, is closed.
+func (w *WebSocketWatch) connLoop(ctx context.Context, stop <-chan bool) {
+	defer w.wg.Done()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	backof := backoff.NewExponentialBackOff()
+	backof.InitialInterval = w.RetryIntv
+	backof.MaxInterval = w.MaxBackoff
+	backof.MaxElapsedTime = 0 // never expire
+	backof.Reset()
+
+	for {
+		err := w.session(ctx, backof)
+		if ctx.Err() != nil {
+			return
+		}
+
+		retry := backof.NextBackOff()
+		w.Log.Warnw("websocket connection failed, reconnecting", zap.Error(err), "retry_timer", retry)
+		w.ReportError(err)
+
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// session dials the endpoint and emits the messages received until the
+// connection fails or ctx is done.
+func (w *WebSocketWatch) session(ctx context.Context, backof backoff.BackOff) error {
+	conn, _, err := w.dialer.DialContext(ctx, w.URL, w.header)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	// closes the connection on stop, which ends the read loop
+	go func() {
+		select {
+		case <-ctx.Done():
+			msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+			_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	conn.SetReadLimit(maxWebSocketMessageBytes)
+	if err := conn.SetReadDeadline(time.Now().Add(w.ReadTimeout)); err != nil {
+		return err
+	}
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(w.ReadTimeout))
+	})
+
+	send := func(msg []byte) error {
+		if err := conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout)); err != nil {
+			return err
+		}
+
+		return conn.WriteMessage(websocket.TextMessage, msg)
+	}
+
+	if len(w.SubscribePayload) > 0 {
+		if err := send(w.SubscribePayload); err != nil {
+			return fmt.Errorf("failed to subscribe: %w", err)
+		}
+	}
+
+	if w.OnConnect != nil {
+		if err := w.OnConnect(send); err != nil {
+			return fmt.Errorf("on connect hook failed: %w", err)
+		}
+	}
+
+	w.Log.Infow("websocket connected")
+
+	go w.pingLoop(conn, done)
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		backof.Reset()
+
+		if err := conn.SetReadDeadline(time.Now().Add(w.ReadTimeout)); err != nil {
+			return err
+		}
+
+		w.emit(msg)
+	}
+}
+
+// pingLoop sends the keepalive pings until done is closed.
+func (w *WebSocketWatch) pingLoop(conn *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(w.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(webSocketWriteTimeout)); err != nil {
+				w.Log.Debugw("failed to send websocket ping", zap.Error(err))
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func (w *WebSocketWatch) emit(msg []byte) {
+	if !w.JSON {
+		w.Emit(msg)
+
+		return
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(msg, &v); err != nil {
+		w.Log.Warnw("failed to decode websocket message, dropping it", zap.Error(err))
+		w.ReportError(err)
+
+		return
+	}
+
+	w.Emit(v)
+}
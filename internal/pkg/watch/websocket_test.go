@@ -0,0 +1,209 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// newMockWebSocketServer serves each connection with the nth handler, the
+// last one serves the following connections.
+func newMockWebSocketServer(t *testing.T, handlers ...func(conn *websocket.Conn)) string {
+	var (
+		mu       sync.Mutex
+		n        int
+		upgrader websocket.Upgrader
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		mu.Lock()
+		i := n
+		n++
+		mu.Unlock()
+
+		if i >= len(handlers) {
+			i = len(handlers) - 1
+		}
+		handlers[i](conn)
+	}))
+	t.Cleanup(ts.Close)
+
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+func startWebSocketWatch(t *testing.T, conf WebSocketWatchConf) (*WebSocketWatch, chan interface{}) {
+	t.Helper()
+
+	conf.RetryIntv = 10 * time.Millisecond
+	w, err := NewWebSocketWatch(conf)
+	require.NoError(t, err)
+
+	ch := make(chan interface{}, 100)
+	w.Subscribe(ch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	StartWithContext(ctx, w)
+	t.Cleanup(func() {
+		cancel()
+		w.Wait()
+	})
+
+	return w, ch
+}
+
+func requireWebSocketMessage(t *testing.T, ch chan interface{}, want interface{}) {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		require.Equal(t, want, msg)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no message %v", want)
+	}
+}
+
+func TestNewWebSocketWatch_Invalid(t *testing.T) {
+	for _, url := range []string{"", "http://localhost:8080", "ws://[::1"} {
+		_, err := NewWebSocketWatch(WebSocketWatchConf{URL: url})
+		require.ErrorIs(t, err, ErrWebSocketWatchConf, url)
+	}
+}
+
+func TestWebSocketWatch_Reconnect(t *testing.T) {
+	var (
+		subscribes = make(chan string, 10)
+		pings      int32
+		closed     = make(chan struct{})
+	)
+
+	readSubscribe := func(conn *websocket.Conn) {
+		for i := 0; i < 2; i++ {
+			_, msg, err := conn.ReadMessage()
+			require.NoError(t, err)
+			subscribes <- string(msg)
+		}
+	}
+
+	url := newMockWebSocketServer(t,
+		func(conn *websocket.Conn) {
+			readSubscribe(conn)
+			require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"round": 1}`)))
+			require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`not json`)))
+			require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"round": 2}`)))
+
+			// dropped mid-stream, without a close frame
+			conn.UnderlyingConn().Close()
+		},
+		func(conn *websocket.Conn) {
+			readSubscribe(conn)
+			conn.SetPingHandler(func(data string) error {
+				atomic.AddInt32(&pings, 1)
+
+				return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+			})
+			require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"round": 3}`)))
+
+			// until the client closes the connection, answering the pings
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					close(closed)
+
+					return
+				}
+			}
+		},
+	)
+
+	var connects int32
+	w, ch := startWebSocketWatch(t, WebSocketWatchConf{
+		URL:              url,
+		SubscribePayload: []byte(`{"method": "subscribe", "params": ["blocks"]}`),
+		OnConnect: func(send func([]byte) error) error {
+			return send([]byte(fmt.Sprintf(`{"resubscribe": %d}`, atomic.AddInt32(&connects, 1))))
+		},
+		JSON:         true,
+		PingInterval: 10 * time.Millisecond,
+		ReadTimeout:  time.Second,
+	})
+
+	requireWebSocketMessage(t, ch, map[string]interface{}{"round": float64(1)})
+	requireWebSocketMessage(t, ch, map[string]interface{}{"round": float64(2)})
+	requireWebSocketMessage(t, ch, map[string]interface{}{"round": float64(3)})
+
+	for _, want := range []string{
+		`{"method": "subscribe", "params": ["blocks"]}`, `{"resubscribe": 1}`,
+		`{"method": "subscribe", "params": ["blocks"]}`, `{"resubscribe": 2}`,
+	} {
+		require.Equal(t, want, <-subscribes)
+	}
+
+	// the connection stays up with the pings answered
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&pings) >= 5 }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, int32(2), atomic.LoadInt32(&connects))
+
+	stopped := make(chan struct{})
+	go func() {
+		w.Stop()
+		w.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("watch not stopped")
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed")
+	}
+}
+
+func TestWebSocketWatch_ReadTimeout(t *testing.T) {
+	var connects int32
+
+	url := newMockWebSocketServer(t, func(conn *websocket.Conn) {
+		n := atomic.AddInt32(&connects, 1)
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte(fmt.Sprintf("hello %d", n))))
+
+		// silent, the pings are never answered without reading
+		time.Sleep(time.Second)
+	})
+
+	_, ch := startWebSocketWatch(t, WebSocketWatchConf{
+		URL:          url,
+		PingInterval: 10 * time.Millisecond,
+		ReadTimeout:  50 * time.Millisecond,
+	})
+
+	requireWebSocketMessage(t, ch, []byte("hello 1"))
+	requireWebSocketMessage(t, ch, []byte("hello 2"))
+}
@@ -17,14 +17,50 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"strconv"
 	"strings"
+	"syscall"
+	"unsafe"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// arpNetlink Use netlink to gather the neighbor table, which also
+	// includes NDP entries. Falls back to /proc/net/arp if the netlink
+	// socket cannot be opened.
+	// collector.arp.netlink
+	arpNetlink = true
+
+	// arpNetlinkRIB dumps the kernel neighbor table, overridden by tests.
+	arpNetlinkRIB = func() ([]byte, error) {
+		return syscall.NetlinkRIB(unix.RTM_GETNEIGH, unix.AF_UNSPEC)
+	}
+
+	// arpInterfaceNames maps interface indexes to names, overridden by tests.
+	arpInterfaceNames = func() (map[int]string, error) {
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return nil, err
+		}
+
+		names := make(map[int]string, len(ifaces))
+		for _, iface := range ifaces {
+			names[iface.Index] = iface.Name
+		}
+
+		return names, nil
+	}
 )
 
 type arpCollector struct {
-	entries *prometheus.Desc
+	entries    *prometheus.Desc
+	ndpEntries *prometheus.Desc
+	errorsDesc *prometheus.Desc
 }
 
 // NewARPCollector returns a new Collector exposing ARP stats.
@@ -35,6 +71,12 @@ func NewARPCollector() (prometheus.Collector, error) {
 			"ARP entries by device",
 			[]string{"device"}, nil,
 		),
+		ndpEntries: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "ndp", "entries"),
+			"NDP entries by device, only available through netlink",
+			[]string{"device"}, nil,
+		),
+		errorsDesc: newScrapeErrorsDesc("arp"),
 	}, nil
 }
 
@@ -77,25 +119,98 @@ func parseARPEntries(data io.Reader) (map[string]uint32, error) {
 	return entries, nil
 }
 
+// getNeighborEntries returns the number of IPv4 (ARP) and IPv6 (NDP)
+// neighbor entries per device from the kernel neighbor table.
+func getNeighborEntries() (arp, ndp map[string]uint32, err error) {
+	rib, err := arpNetlinkRIB()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse netlink messages: %w", err)
+	}
+
+	names, err := arpInterfaceNames()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return parseNeighborMessages(msgs, names)
+}
+
+func parseNeighborMessages(msgs []syscall.NetlinkMessage, names map[int]string) (arp, ndp map[string]uint32, err error) {
+	arp, ndp = make(map[string]uint32), make(map[string]uint32)
+
+	for _, m := range msgs {
+		switch m.Header.Type {
+		case unix.NLMSG_DONE:
+			return arp, ndp, nil
+		case unix.RTM_NEWNEIGH:
+		default:
+			continue
+		}
+
+		if len(m.Data) < unix.SizeofNdMsg {
+			return nil, nil, fmt.Errorf("unexpected neighbor message length %d", len(m.Data))
+		}
+		nd := (*unix.NdMsg)(unsafe.Pointer(&m.Data[0]))
+
+		// entries like multicast and loopback neighbors don't use ARP/NDP
+		if nd.State&unix.NUD_NOARP != 0 {
+			continue
+		}
+
+		device, ok := names[int(nd.Ifindex)]
+		if !ok {
+			device = strconv.Itoa(int(nd.Ifindex))
+		}
+
+		switch nd.Family {
+		case unix.AF_INET:
+			arp[device]++
+		case unix.AF_INET6:
+			ndp[device]++
+		}
+	}
+
+	return arp, ndp, nil
+}
+
 func (c *arpCollector) Collect(ch chan<- prometheus.Metric) {
+	if arpNetlink {
+		arp, ndp, err := getNeighborEntries()
+		if err == nil {
+			c.collectEntries(ch, c.entries, arp)
+			c.collectEntries(ch, c.ndpEntries, ndp)
+
+			return
+		}
+
+		zap.S().Debugw("could not get neighbor table through netlink, falling back to procfs", zap.Error(err))
+	}
+
 	entries, err := getARPEntries()
 	if err != nil {
-		err = fmt.Errorf("could not get ARP entries: %w", err)
+		collectErrors(ch, c.errorsDesc, fmt.Errorf("could not get ARP entries: %w", err))
 
 		return
 	}
 
+	c.collectEntries(ch, c.entries, entries)
+}
+
+func (c *arpCollector) collectEntries(ch chan<- prometheus.Metric, desc *prometheus.Desc, entries map[string]uint32) {
 	for device, entryCount := range entries {
 		ch <- prometheus.MustNewConstMetric(
-			c.entries, prometheus.GaugeValue, float64(entryCount), device)
+			desc, prometheus.GaugeValue, float64(entryCount), device)
 	}
-
-	return
 }
 
 // Describe exposes descriptors for this collector.
 func (c *arpCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.entries
-
-	return
+	ch <- c.ndpEntries
+	ch <- c.errorsDesc
 }
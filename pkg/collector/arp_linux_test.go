@@ -0,0 +1,110 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func newNeighborMessage(family uint8, index int32, state uint16) []byte {
+	nd := unix.NdMsg{Family: family, Ifindex: index, State: state}
+	data := (*[unix.SizeofNdMsg]byte)(unsafe.Pointer(&nd))[:]
+
+	hdr := syscall.NlMsghdr{
+		Len:  uint32(syscall.NLMSG_HDRLEN + len(data)),
+		Type: unix.RTM_NEWNEIGH,
+	}
+	b := (*[syscall.NLMSG_HDRLEN]byte)(unsafe.Pointer(&hdr))[:]
+
+	return append(append([]byte{}, b...), data...)
+}
+
+func TestARPCollector_Netlink(t *testing.T) {
+	ribWas, namesWas := arpNetlinkRIB, arpInterfaceNames
+	defer func() {
+		arpNetlinkRIB, arpInterfaceNames = ribWas, namesWas
+	}()
+
+	arpNetlinkRIB = func() ([]byte, error) {
+		var rib []byte
+		for _, m := range [][]byte{
+			newNeighborMessage(unix.AF_INET, 2, unix.NUD_REACHABLE),
+			newNeighborMessage(unix.AF_INET, 2, unix.NUD_STALE),
+			newNeighborMessage(unix.AF_INET, 3, unix.NUD_INCOMPLETE),
+			newNeighborMessage(unix.AF_INET6, 2, unix.NUD_REACHABLE),
+			// no ARP/NDP, not counted
+			newNeighborMessage(unix.AF_INET, 1, unix.NUD_NOARP),
+			// unknown interface
+			newNeighborMessage(unix.AF_INET6, 42, unix.NUD_DELAY),
+		} {
+			rib = append(rib, m...)
+		}
+
+		return rib, nil
+	}
+	arpInterfaceNames = func() (map[int]string, error) {
+		return map[int]string{1: "lo", 2: "eth0", 3: "eth1"}, nil
+	}
+
+	c, err := NewARPCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_arp_entries ARP entries by device
+# TYPE node_arp_entries gauge
+node_arp_entries{device="eth0"} 2
+node_arp_entries{device="eth1"} 1
+# HELP node_ndp_entries NDP entries by device, only available through netlink
+# TYPE node_ndp_entries gauge
+node_ndp_entries{device="42"} 1
+node_ndp_entries{device="eth0"} 1
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestARPCollector_ProcfsFallback(t *testing.T) {
+	procPathWas, ribWas := procPath, arpNetlinkRIB
+	defer func() {
+		procPath, arpNetlinkRIB = procPathWas, ribWas
+	}()
+	procPath = "fixtures/proc"
+	arpNetlinkRIB = func() ([]byte, error) {
+		return nil, syscall.EPROTONOSUPPORT
+	}
+
+	c, err := NewARPCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_arp_entries ARP entries by device
+# TYPE node_arp_entries gauge
+node_arp_entries{device="eth0"} 3
+node_arp_entries{device="eth1"} 3
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestParseNeighborMessages_Short(t *testing.T) {
+	_, _, err := parseNeighborMessages([]syscall.NetlinkMessage{
+		{Header: syscall.NlMsghdr{Type: unix.RTM_NEWNEIGH}, Data: []byte{unix.AF_INET}},
+	}, nil)
+	require.Error(t, err)
+	require.False(t, errors.Is(err, syscall.EPROTONOSUPPORT))
+}
@@ -0,0 +1,143 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nobonding
+// +build !nobonding
+
+package collector
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// bondingReadFile reads a sysfs attribute, overridden by tests.
+var bondingReadFile = func(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+type bondingCollector struct {
+	slaves     *prometheus.Desc
+	active     *prometheus.Desc
+	errorsDesc *prometheus.Desc
+}
+
+// NewBondingCollector returns a new Collector exposing the number of
+// configured and active slaves of bonding interfaces.
+func NewBondingCollector() (prometheus.Collector, error) {
+	return &bondingCollector{
+		slaves: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "bonding", "slaves"),
+			"Number of configured slaves per bonding interface.",
+			[]string{"master"}, nil,
+		),
+		active: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "bonding", "active"),
+			"Number of active slaves per bonding interface.",
+			[]string{"master"}, nil,
+		),
+		errorsDesc: newScrapeErrorsDesc("bonding"),
+	}, nil
+}
+
+func (c *bondingCollector) Collect(ch chan<- prometheus.Metric) {
+	netPath := sysFilePath("class/net")
+
+	masters, err := bondingReadFile(filepath.Join(netPath, "bonding_masters"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			zap.S().Debugw("bonding metrics are not available for this system")
+
+			return
+		}
+
+		collectErrors(ch, c.errorsDesc, err)
+
+		return
+	}
+
+	errs := &multiError{}
+	for _, master := range strings.Fields(masters) {
+		slaves, err := bondingReadFile(filepath.Join(netPath, master, "bonding", "slaves"))
+		if err != nil {
+			errs.Add(master, err)
+
+			continue
+		}
+
+		total, active := 0, 0
+		for _, slave := range strings.Fields(slaves) {
+			total++
+
+			up, err := bondingSlaveUp(filepath.Join(netPath, master), slave)
+			if err != nil {
+				errs.Add(master+"/"+slave, err)
+
+				continue
+			}
+
+			if up {
+				active++
+			}
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.slaves, prometheus.GaugeValue, float64(total), master)
+		ch <- prometheus.MustNewConstMetric(c.active, prometheus.GaugeValue, float64(active), master)
+	}
+
+	collectErrors(ch, c.errorsDesc, errs.ErrorOrNil())
+}
+
+func (c *bondingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.slaves
+	ch <- c.active
+	ch <- c.errorsDesc
+}
+
+// bondingSlaveUp returns true if the slave's MII status is up and, when
+// available, its operstate is up as well.
+func bondingSlaveUp(masterPath, slave string) (bool, error) {
+	// slaves are linked as lower_<slave> on newer kernels
+	slavePath := filepath.Join(masterPath, "lower_"+slave)
+	if _, err := os.Stat(slavePath); err != nil {
+		slavePath = filepath.Join(masterPath, "slave_"+slave)
+	}
+
+	mii, err := bondingReadFile(filepath.Join(slavePath, "bonding_slave", "mii_status"))
+	if err != nil {
+		return false, err
+	}
+
+	if mii != "up" {
+		return false, nil
+	}
+
+	operstate, err := bondingReadFile(filepath.Join(slavePath, "operstate"))
+	if err != nil {
+		// not all kernels link the slave's operstate
+		return true, nil
+	}
+
+	return operstate == "up", nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nobonding
+// +build !nobonding
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBondingCollector(t *testing.T) {
+	sysPathWas := sysPath
+	defer func() {
+		sysPath = sysPathWas
+	}()
+	sysPath = "fixtures/sys"
+
+	c, err := NewBondingCollector()
+	require.NoError(t, err)
+
+	// int has one failed slave (eth1), bond0 has no slaves
+	want := `# HELP node_bonding_active Number of active slaves per bonding interface.
+# TYPE node_bonding_active gauge
+node_bonding_active{master="bond0"} 0
+node_bonding_active{master="dmz"} 2
+node_bonding_active{master="int"} 1
+# HELP node_bonding_slaves Number of configured slaves per bonding interface.
+# TYPE node_bonding_slaves gauge
+node_bonding_slaves{master="bond0"} 0
+node_bonding_slaves{master="dmz"} 2
+node_bonding_slaves{master="int"} 2
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestBondingCollector_OperstateDown(t *testing.T) {
+	sysPathWas := sysPath
+	defer func() {
+		sysPath = sysPathWas
+	}()
+	sysPath = t.TempDir()
+
+	netPath := filepath.Join(sysPath, "class", "net")
+	files := map[string]string{
+		"bonding_masters":                           "bond1",
+		"bond1/bonding/slaves":                      "eth0 eth1 eth2",
+		"bond1/lower_eth0/bonding_slave/mii_status": "up",
+		"bond1/lower_eth0/operstate":                "up",
+		// MII reports the link up but the interface is down
+		"bond1/lower_eth1/bonding_slave/mii_status": "up",
+		"bond1/lower_eth1/operstate":                "down",
+		// no operstate, MII status only
+		"bond1/slave_eth2/bonding_slave/mii_status": "up",
+	}
+	for name, content := range files {
+		path := filepath.Join(netPath, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content+"\n"), 0o644))
+	}
+
+	c, err := NewBondingCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_bonding_active Number of active slaves per bonding interface.
+# TYPE node_bonding_active gauge
+node_bonding_active{master="bond1"} 2
+# HELP node_bonding_slaves Number of configured slaves per bonding interface.
+# TYPE node_bonding_slaves gauge
+node_bonding_slaves{master="bond1"} 3
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
@@ -0,0 +1,74 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nobuddyinfo
+// +build !nobuddyinfo
+
+package collector
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+type buddyinfoCollector struct {
+	fs         procfs.FS
+	blocksDesc *prometheus.Desc
+	errorsDesc *prometheus.Desc
+}
+
+// NewBuddyinfoCollector returns a new Collector exposing the free blocks
+// of each order per NUMA node and zone from /proc/buddyinfo, i.e. memory
+// fragmentation.
+func NewBuddyinfoCollector() (prometheus.Collector, error) {
+	fs, err := procfs.NewFS(procPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open procfs: %w", err)
+	}
+
+	return &buddyinfoCollector{
+		fs: fs,
+		blocksDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "buddyinfo", "blocks"),
+			"Number of free blocks of 2^order pages, by NUMA node and zone.",
+			[]string{"node", "zone", "order"}, nil,
+		),
+		errorsDesc: newScrapeErrorsDesc("buddyinfo"),
+	}, nil
+}
+
+// Collect exports a gauge per node, zone and order. Zones are exported as
+// listed, the set differs across architectures (i.e. no DMA32 on arm64).
+func (c *buddyinfoCollector) Collect(ch chan<- prometheus.Metric) {
+	buddyInfo, err := c.fs.BuddyInfo()
+	if err != nil {
+		collectErrors(ch, c.errorsDesc, fmt.Errorf("failed to get buddyinfo: %w", err))
+
+		return
+	}
+
+	for _, entry := range buddyInfo {
+		for order, blocks := range entry.Sizes {
+			ch <- prometheus.MustNewConstMetric(c.blocksDesc, prometheus.GaugeValue, blocks,
+				entry.Node, entry.Zone, strconv.Itoa(order))
+		}
+	}
+}
+
+func (c *buddyinfoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.blocksDesc
+	ch <- c.errorsDesc
+}
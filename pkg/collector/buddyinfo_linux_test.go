@@ -0,0 +1,129 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nobuddyinfo
+// +build !nobuddyinfo
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuddyinfoCollector(t *testing.T) {
+	procPathWas := procPath
+	defer func() {
+		procPath = procPathWas
+	}()
+
+	tests := []struct {
+		name      string
+		buddyinfo string
+		want      string
+	}{
+		{
+			name:      "fixtures",
+			buddyinfo: "",
+			want: `# HELP node_buddyinfo_blocks Number of free blocks of 2^order pages, by NUMA node and zone.
+# TYPE node_buddyinfo_blocks gauge
+node_buddyinfo_blocks{node="0",order="0",zone="DMA"} 1
+node_buddyinfo_blocks{node="0",order="0",zone="DMA32"} 759
+node_buddyinfo_blocks{node="0",order="0",zone="Normal"} 4381
+node_buddyinfo_blocks{node="0",order="1",zone="DMA"} 0
+node_buddyinfo_blocks{node="0",order="1",zone="DMA32"} 572
+node_buddyinfo_blocks{node="0",order="1",zone="Normal"} 1093
+node_buddyinfo_blocks{node="0",order="10",zone="DMA"} 3
+node_buddyinfo_blocks{node="0",order="10",zone="DMA32"} 0
+node_buddyinfo_blocks{node="0",order="10",zone="Normal"} 0
+node_buddyinfo_blocks{node="0",order="2",zone="DMA"} 1
+node_buddyinfo_blocks{node="0",order="2",zone="DMA32"} 791
+node_buddyinfo_blocks{node="0",order="2",zone="Normal"} 185
+node_buddyinfo_blocks{node="0",order="3",zone="DMA"} 0
+node_buddyinfo_blocks{node="0",order="3",zone="DMA32"} 475
+node_buddyinfo_blocks{node="0",order="3",zone="Normal"} 1530
+node_buddyinfo_blocks{node="0",order="4",zone="DMA"} 2
+node_buddyinfo_blocks{node="0",order="4",zone="DMA32"} 194
+node_buddyinfo_blocks{node="0",order="4",zone="Normal"} 567
+node_buddyinfo_blocks{node="0",order="5",zone="DMA"} 1
+node_buddyinfo_blocks{node="0",order="5",zone="DMA32"} 45
+node_buddyinfo_blocks{node="0",order="5",zone="Normal"} 102
+node_buddyinfo_blocks{node="0",order="6",zone="DMA"} 1
+node_buddyinfo_blocks{node="0",order="6",zone="DMA32"} 12
+node_buddyinfo_blocks{node="0",order="6",zone="Normal"} 4
+node_buddyinfo_blocks{node="0",order="7",zone="DMA"} 0
+node_buddyinfo_blocks{node="0",order="7",zone="DMA32"} 0
+node_buddyinfo_blocks{node="0",order="7",zone="Normal"} 0
+node_buddyinfo_blocks{node="0",order="8",zone="DMA"} 1
+node_buddyinfo_blocks{node="0",order="8",zone="DMA32"} 0
+node_buddyinfo_blocks{node="0",order="8",zone="Normal"} 0
+node_buddyinfo_blocks{node="0",order="9",zone="DMA"} 1
+node_buddyinfo_blocks{node="0",order="9",zone="DMA32"} 0
+node_buddyinfo_blocks{node="0",order="9",zone="Normal"} 0
+`,
+		},
+		{
+			// arm64, two NUMA nodes, no DMA32 zone
+			name: "numa",
+			buddyinfo: `Node 0, zone      DMA     12      8      4      2      1
+Node 0, zone   Normal   3120    811     97     12      0
+Node 1, zone   Normal   2944    702    130      9      1
+`,
+			want: `# HELP node_buddyinfo_blocks Number of free blocks of 2^order pages, by NUMA node and zone.
+# TYPE node_buddyinfo_blocks gauge
+node_buddyinfo_blocks{node="0",order="0",zone="DMA"} 12
+node_buddyinfo_blocks{node="0",order="0",zone="Normal"} 3120
+node_buddyinfo_blocks{node="0",order="1",zone="DMA"} 8
+node_buddyinfo_blocks{node="0",order="1",zone="Normal"} 811
+node_buddyinfo_blocks{node="0",order="2",zone="DMA"} 4
+node_buddyinfo_blocks{node="0",order="2",zone="Normal"} 97
+node_buddyinfo_blocks{node="0",order="3",zone="DMA"} 2
+node_buddyinfo_blocks{node="0",order="3",zone="Normal"} 12
+node_buddyinfo_blocks{node="0",order="4",zone="DMA"} 1
+node_buddyinfo_blocks{node="0",order="4",zone="Normal"} 0
+node_buddyinfo_blocks{node="1",order="0",zone="Normal"} 2944
+node_buddyinfo_blocks{node="1",order="1",zone="Normal"} 702
+node_buddyinfo_blocks{node="1",order="2",zone="Normal"} 130
+node_buddyinfo_blocks{node="1",order="3",zone="Normal"} 9
+node_buddyinfo_blocks{node="1",order="4",zone="Normal"} 1
+`,
+		},
+		{
+			name:      "malformed",
+			buddyinfo: "Node 0, zone Normal 1 2 3\nNode 1, zone Normal 1 2\n",
+			want: `# HELP node_scrape_collector_errors Number of errors encountered by a collector during the last scrape, by reason.
+# TYPE node_scrape_collector_errors gauge
+node_scrape_collector_errors{collector="buddyinfo",reason="other"} 1
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procPath = "fixtures/proc"
+			if tt.buddyinfo != "" {
+				procPath = t.TempDir()
+				require.NoError(t, os.WriteFile(filepath.Join(procPath, "buddyinfo"), []byte(tt.buddyinfo), 0o644))
+			}
+
+			c, err := NewBuddyinfoCollector()
+			require.NoError(t, err)
+			require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(tt.want)))
+		})
+	}
+}
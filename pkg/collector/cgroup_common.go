@@ -0,0 +1,55 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"flag"
+	"sync"
+)
+
+// cgroupPaths Comma-separated cgroups exported by the cgroup collector,
+// either as name=path or path, relative to the cgroup hierarchy root.
+// collector.cgroup.paths
+var cgroupPaths string
+
+// CgroupContainerResolver returns the IDs of the docker containers exported
+// by the cgroup collector, keyed by container name.
+type CgroupContainerResolver func() (map[string]string, error)
+
+var (
+	cgroupResolverMu sync.RWMutex
+	cgroupResolver   CgroupContainerResolver
+)
+
+// DefineCgroupFlags defines the flags of the cgroup collector.
+func DefineCgroupFlags(flags *flag.FlagSet) {
+	flags.StringVar(&cgroupPaths, "collector.cgroup.paths", "",
+		"Comma-separated cgroups exported by the cgroup collector, as name=path or path relative to the cgroup root (i.e. system.slice/algod.service).")
+}
+
+// SetCgroupContainerResolver sets the resolver of the docker containers
+// exported by the cgroup collector, along with collector.cgroup.paths.
+func SetCgroupContainerResolver(r CgroupContainerResolver) {
+	cgroupResolverMu.Lock()
+	defer cgroupResolverMu.Unlock()
+
+	cgroupResolver = r
+}
+
+func getCgroupContainerResolver() CgroupContainerResolver {
+	cgroupResolverMu.RLock()
+	defer cgroupResolverMu.RUnlock()
+
+	return cgroupResolver
+}
@@ -0,0 +1,477 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nocgroup
+// +build !nocgroup
+
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	cgroupSubsystem = "cgroup"
+
+	// cgroupV1Unlimited memory limits at or above are unlimited on cgroup v1,
+	// which reports the max page-aligned int64 instead of "max".
+	cgroupV1Unlimited = uint64(1) << 62
+)
+
+type cgroupCollector struct {
+	memoryUsage      *prometheus.Desc
+	memoryLimit      *prometheus.Desc
+	oomKills         *prometheus.Desc
+	cpuUsage         *prometheus.Desc
+	cpuPeriods       *prometheus.Desc
+	throttledPeriods *prometheus.Desc
+	throttledTime    *prometheus.Desc
+	ioBytes          *prometheus.Desc
+	ioOps            *prometheus.Desc
+	errorsDesc       *prometheus.Desc
+}
+
+// NewCgroupCollector returns a new Collector exposing the resource usage
+// of the cgroups listed by collector.cgroup.paths and of the docker
+// containers running the node. Reads cgroup v1 files if the unified
+// hierarchy is not mounted.
+func NewCgroupCollector() (prometheus.Collector, error) {
+	if _, err := parseCgroupPaths(cgroupPaths); err != nil {
+		return nil, err
+	}
+
+	return &cgroupCollector{
+		memoryUsage: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, cgroupSubsystem, "memory_usage_bytes"),
+			"Memory currently used by the cgroup, including page cache.",
+			[]string{"container"}, nil,
+		),
+		memoryLimit: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, cgroupSubsystem, "memory_limit_bytes"),
+			"Memory limit of the cgroup, absent if unlimited.",
+			[]string{"container"}, nil,
+		),
+		oomKills: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, cgroupSubsystem, "memory_oom_kills_total"),
+			"Number of processes of the cgroup killed by the OOM killer.",
+			[]string{"container"}, nil,
+		),
+		cpuUsage: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, cgroupSubsystem, "cpu_usage_seconds_total"),
+			"Total CPU time consumed by the cgroup in seconds.",
+			[]string{"container"}, nil,
+		),
+		cpuPeriods: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, cgroupSubsystem, "cpu_periods_total"),
+			"Number of enforcement periods elapsed for the cgroup CPU quota.",
+			[]string{"container"}, nil,
+		),
+		throttledPeriods: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, cgroupSubsystem, "cpu_throttled_periods_total"),
+			"Number of enforcement periods the cgroup was throttled in.",
+			[]string{"container"}, nil,
+		),
+		throttledTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, cgroupSubsystem, "cpu_throttled_seconds_total"),
+			"Total time the cgroup was throttled in seconds.",
+			[]string{"container"}, nil,
+		),
+		ioBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, cgroupSubsystem, "io_bytes_total"),
+			"Bytes transferred by the cgroup, by block device (major:minor) and direction.",
+			[]string{"container", "device", "direction"}, nil,
+		),
+		ioOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, cgroupSubsystem, "io_ops_total"),
+			"I/O operations issued by the cgroup, by block device (major:minor) and direction.",
+			[]string{"container", "device", "direction"}, nil,
+		),
+		errorsDesc: newScrapeErrorsDesc("cgroup"),
+	}, nil
+}
+
+// parseCgroupPaths parses collector.cgroup.paths into cgroup paths keyed
+// by container label, the path base name unless given as name=path.
+func parseCgroupPaths(s string) (map[string]string, error) {
+	paths := map[string]string{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, path := filepath.Base(entry), entry
+		if i := strings.Index(entry, "="); i >= 0 {
+			name, path = entry[:i], entry[i+1:]
+		}
+		path = strings.Trim(path, "/")
+		if name == "" || path == "" {
+			return nil, fmt.Errorf("invalid cgroup path %q, expected name=path or path", entry)
+		}
+		paths[name] = path
+	}
+
+	return paths, nil
+}
+
+// cgroupUnified returns true if the cgroup v2 unified hierarchy is mounted
+// at the cgroup root.
+func cgroupUnified(root string) bool {
+	_, err := os.Stat(filepath.Join(root, "cgroup.controllers"))
+
+	return err == nil
+}
+
+// dockerCgroupPath returns the path of the container's cgroup relative to
+// dir, for the systemd and cgroupfs cgroup drivers.
+func dockerCgroupPath(dir, id string) (string, bool) {
+	for _, path := range []string{
+		filepath.Join("system.slice", "docker-"+id+".scope"),
+		filepath.Join("docker", id),
+	} {
+		if _, err := os.Stat(filepath.Join(dir, path)); err == nil {
+			return path, true
+		}
+	}
+
+	return "", false
+}
+
+func (c *cgroupCollector) Collect(ch chan<- prometheus.Metric) {
+	root := sysFilePath("fs/cgroup")
+	if _, err := os.Stat(root); err != nil {
+		zap.S().Debugw("cgroup metrics are not available, cgroup hierarchy not mounted", "path", root)
+
+		return
+	}
+	unified := cgroupUnified(root)
+
+	errs := &multiError{}
+
+	// validated by NewCgroupCollector
+	paths, _ := parseCgroupPaths(cgroupPaths)
+
+	if resolve := getCgroupContainerResolver(); resolve != nil {
+		containers, err := resolve()
+		if err != nil {
+			errs.Add("containers", err)
+		}
+
+		// container paths are resolved against the memory controller on v1
+		dir := root
+		if !unified {
+			dir = filepath.Join(root, "memory")
+		}
+		for name, id := range containers {
+			path, ok := dockerCgroupPath(dir, id)
+			if !ok {
+				zap.S().Debugw("container cgroup not found", "container", name, "id", id)
+
+				continue
+			}
+			paths[name] = path
+		}
+	}
+
+	names := make([]string, 0, len(paths))
+	for name := range paths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if unified {
+			c.collectV2(ch, errs, name, filepath.Join(root, paths[name]))
+		} else {
+			c.collectV1(ch, errs, name, root, paths[name])
+		}
+	}
+
+	collectErrors(ch, c.errorsDesc, errs.ErrorOrNil())
+}
+
+// collectV2 collects the stats of the cgroup v2 at dir into ch, and its
+// errors into errs. Files of disabled controllers are skipped.
+func (c *cgroupCollector) collectV2(ch chan<- prometheus.Metric, errs *multiError, name, dir string) {
+	if _, err := os.Stat(dir); err != nil {
+		errs.Add(name, err)
+
+		return
+	}
+
+	if v, _, err := readCgroupValue(filepath.Join(dir, "memory.current")); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.memoryUsage, prometheus.GaugeValue, float64(v), name)
+	} else if !os.IsNotExist(err) {
+		errs.Add(name+"/memory.current", err)
+	}
+
+	if v, unlimited, err := readCgroupValue(filepath.Join(dir, "memory.max")); err == nil {
+		if !unlimited {
+			ch <- prometheus.MustNewConstMetric(c.memoryLimit, prometheus.GaugeValue, float64(v), name)
+		}
+	} else if !os.IsNotExist(err) {
+		errs.Add(name+"/memory.max", err)
+	}
+
+	if events, err := readCgroupKeyValues(filepath.Join(dir, "memory.events")); err == nil {
+		if v, ok := events["oom_kill"]; ok {
+			ch <- prometheus.MustNewConstMetric(c.oomKills, prometheus.CounterValue, float64(v), name)
+		}
+	} else if !os.IsNotExist(err) {
+		errs.Add(name+"/memory.events", err)
+	}
+
+	if stat, err := readCgroupKeyValues(filepath.Join(dir, "cpu.stat")); err == nil {
+		c.collectCPUStat(ch, name, stat, "usage_usec", "throttled_usec", 1e6)
+	} else if !os.IsNotExist(err) {
+		errs.Add(name+"/cpu.stat", err)
+	}
+
+	if err := c.collectIOStatV2(ch, name, filepath.Join(dir, "io.stat")); err != nil && !os.IsNotExist(err) {
+		errs.Add(name+"/io.stat", err)
+	}
+
+}
+
+// collectV1 collects the stats of the cgroup v1 at path under each of the
+// controllers mounted at root.
+func (c *cgroupCollector) collectV1(ch chan<- prometheus.Metric, errs *multiError, name, root, path string) {
+	memory := filepath.Join(root, "memory", path)
+	if _, err := os.Stat(memory); err != nil {
+		errs.Add(name, err)
+
+		return
+	}
+
+	if v, _, err := readCgroupValue(filepath.Join(memory, "memory.usage_in_bytes")); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.memoryUsage, prometheus.GaugeValue, float64(v), name)
+	} else if !os.IsNotExist(err) {
+		errs.Add(name+"/memory.usage_in_bytes", err)
+	}
+
+	if v, unlimited, err := readCgroupValue(filepath.Join(memory, "memory.limit_in_bytes")); err == nil {
+		if !unlimited && v < cgroupV1Unlimited {
+			ch <- prometheus.MustNewConstMetric(c.memoryLimit, prometheus.GaugeValue, float64(v), name)
+		}
+	} else if !os.IsNotExist(err) {
+		errs.Add(name+"/memory.limit_in_bytes", err)
+	}
+
+	if control, err := readCgroupKeyValues(filepath.Join(memory, "memory.oom_control")); err == nil {
+		// oom_kill is only reported since linux 4.13
+		if v, ok := control["oom_kill"]; ok {
+			ch <- prometheus.MustNewConstMetric(c.oomKills, prometheus.CounterValue, float64(v), name)
+		}
+	} else if !os.IsNotExist(err) {
+		errs.Add(name+"/memory.oom_control", err)
+	}
+
+	if v, _, err := readCgroupValue(filepath.Join(root, "cpuacct", path, "cpuacct.usage")); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.cpuUsage, prometheus.CounterValue, float64(v)/1e9, name)
+	} else if !os.IsNotExist(err) {
+		errs.Add(name+"/cpuacct.usage", err)
+	}
+
+	if stat, err := readCgroupKeyValues(filepath.Join(root, "cpu", path, "cpu.stat")); err == nil {
+		c.collectCPUStat(ch, name, stat, "", "throttled_time", 1e9)
+	} else if !os.IsNotExist(err) {
+		errs.Add(name+"/cpu.stat", err)
+	}
+
+	blkio := filepath.Join(root, "blkio", path)
+	for _, f := range []struct {
+		file string
+		desc *prometheus.Desc
+	}{
+		{"blkio.throttle.io_service_bytes", c.ioBytes},
+		{"blkio.throttle.io_serviced", c.ioOps},
+	} {
+		if err := c.collectBlkioV1(ch, name, filepath.Join(blkio, f.file), f.desc); err != nil && !os.IsNotExist(err) {
+			errs.Add(name+"/"+f.file, err)
+		}
+	}
+}
+
+// collectCPUStat collects the cpu.stat fields, usage is skipped if usageKey
+// is empty. Times are converted to seconds, unit being their units per second.
+func (c *cgroupCollector) collectCPUStat(ch chan<- prometheus.Metric, name string, stat map[string]uint64, usageKey, throttledKey string, unit float64) {
+	if v, ok := stat[usageKey]; ok && usageKey != "" {
+		ch <- prometheus.MustNewConstMetric(c.cpuUsage, prometheus.CounterValue, float64(v)/unit, name)
+	}
+	// absent unless the cpu controller is enabled
+	if v, ok := stat["nr_periods"]; ok {
+		ch <- prometheus.MustNewConstMetric(c.cpuPeriods, prometheus.CounterValue, float64(v), name)
+	}
+	if v, ok := stat["nr_throttled"]; ok {
+		ch <- prometheus.MustNewConstMetric(c.throttledPeriods, prometheus.CounterValue, float64(v), name)
+	}
+	if v, ok := stat[throttledKey]; ok {
+		ch <- prometheus.MustNewConstMetric(c.throttledTime, prometheus.CounterValue, float64(v)/unit, name)
+	}
+}
+
+// collectIOStatV2 collects io.stat lines, i.e.
+// 8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0
+func (c *cgroupCollector) collectIOStatV2(ch chan<- prometheus.Metric, name, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		device := fields[0]
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("invalid io.stat field %q: %w", field, ErrParse)
+			}
+
+			var desc *prometheus.Desc
+			var direction string
+			switch kv[0] {
+			case "rbytes":
+				desc, direction = c.ioBytes, "read"
+			case "wbytes":
+				desc, direction = c.ioBytes, "write"
+			case "rios":
+				desc, direction = c.ioOps, "read"
+			case "wios":
+				desc, direction = c.ioOps, "write"
+			default:
+				continue
+			}
+
+			v, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid io.stat value %q: %w", field, ErrParse)
+			}
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(v), name, device, direction)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// collectBlkioV1 collects blkio.throttle.* lines, i.e. "8:0 Read 1459200".
+// Totals and other operations are skipped.
+func (c *cgroupCollector) collectBlkioV1(ch chan<- prometheus.Metric, name, path string, desc *prometheus.Desc) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+
+		var direction string
+		switch fields[1] {
+		case "Read":
+			direction = "read"
+		case "Write":
+			direction = "write"
+		default:
+			continue
+		}
+
+		v, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s value %q: %w", filepath.Base(path), fields[2], ErrParse)
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(v), name, fields[0], direction)
+	}
+
+	return scanner.Err()
+}
+
+// readCgroupValue reads a single value cgroup file, returns true if the
+// value is "max".
+func readCgroupValue(path string) (uint64, bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, false, err
+	}
+
+	s := strings.TrimSpace(string(data))
+	if s == "max" {
+		return 0, true, nil
+	}
+
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid %s value %q: %w", filepath.Base(path), s, ErrParse)
+	}
+
+	return v, false, nil
+}
+
+// readCgroupKeyValues reads a flat keyed cgroup file, i.e. cpu.stat.
+func readCgroupKeyValues(path string) (map[string]uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]uint64{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid %s line %q: %w", filepath.Base(path), scanner.Text(), ErrParse)
+		}
+
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q: %w", filepath.Base(path), fields[1], ErrParse)
+		}
+		values[fields[0]] = v
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+func (c *cgroupCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.memoryUsage
+	ch <- c.memoryLimit
+	ch <- c.oomKills
+	ch <- c.cpuUsage
+	ch <- c.cpuPeriods
+	ch <- c.throttledPeriods
+	ch <- c.throttledTime
+	ch <- c.ioBytes
+	ch <- c.ioOps
+	ch <- c.errorsDesc
+}
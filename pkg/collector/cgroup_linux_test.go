@@ -0,0 +1,186 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nocgroup
+// +build !nocgroup
+
+package collector
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+const cgroupTestContainerID = "3f4e2b1a9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f"
+
+// withCgroupFiles points the collector to a cgroup hierarchy made of files,
+// keyed by their path relative to the cgroup root.
+func withCgroupFiles(t *testing.T, files map[string]string, paths string, resolver CgroupContainerResolver) {
+	t.Helper()
+
+	sysPathWas, pathsWas := sysPath, cgroupPaths
+	t.Cleanup(func() {
+		sysPath, cgroupPaths = sysPathWas, pathsWas
+		SetCgroupContainerResolver(nil)
+	})
+
+	sysPath = t.TempDir()
+	root := filepath.Join(sysPath, "fs", "cgroup")
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	cgroupPaths = paths
+	SetCgroupContainerResolver(resolver)
+}
+
+func TestCgroupCollector_V2(t *testing.T) {
+	scope := "system.slice/docker-" + cgroupTestContainerID + ".scope/"
+	withCgroupFiles(t, map[string]string{
+		"cgroup.controllers": "cpuset cpu io memory pids\n",
+
+		scope + "memory.current": "1073741824\n",
+		scope + "memory.max":     "4294967296\n",
+		scope + "memory.events":  "low 0\nhigh 0\nmax 12\noom 1\noom_kill 1\n",
+		scope + "cpu.stat":       "usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\nnr_periods 100\nnr_throttled 7\nthrottled_usec 350000\n",
+		scope + "io.stat":        "8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0\n",
+
+		// unlimited, io controller not enabled
+		"system.slice/algod.service/memory.current": "524288000\n",
+		"system.slice/algod.service/memory.max":     "max\n",
+		"system.slice/algod.service/memory.events":  "low 0\nhigh 0\nmax 0\noom 0\noom_kill 0\n",
+		"system.slice/algod.service/cpu.stat":       "usage_usec 1000000\nuser_usec 800000\nsystem_usec 200000\n",
+	}, "system.slice/algod.service", func() (map[string]string, error) {
+		return map[string]string{"flow-go": cgroupTestContainerID}, nil
+	})
+
+	c, err := NewCgroupCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_cgroup_cpu_periods_total Number of enforcement periods elapsed for the cgroup CPU quota.
+# TYPE node_cgroup_cpu_periods_total counter
+node_cgroup_cpu_periods_total{container="flow-go"} 100
+# HELP node_cgroup_cpu_throttled_periods_total Number of enforcement periods the cgroup was throttled in.
+# TYPE node_cgroup_cpu_throttled_periods_total counter
+node_cgroup_cpu_throttled_periods_total{container="flow-go"} 7
+# HELP node_cgroup_cpu_throttled_seconds_total Total time the cgroup was throttled in seconds.
+# TYPE node_cgroup_cpu_throttled_seconds_total counter
+node_cgroup_cpu_throttled_seconds_total{container="flow-go"} 0.35
+# HELP node_cgroup_cpu_usage_seconds_total Total CPU time consumed by the cgroup in seconds.
+# TYPE node_cgroup_cpu_usage_seconds_total counter
+node_cgroup_cpu_usage_seconds_total{container="algod.service"} 1
+node_cgroup_cpu_usage_seconds_total{container="flow-go"} 2.5
+# HELP node_cgroup_io_bytes_total Bytes transferred by the cgroup, by block device (major:minor) and direction.
+# TYPE node_cgroup_io_bytes_total counter
+node_cgroup_io_bytes_total{container="flow-go",device="8:0",direction="read"} 1.4592e+06
+node_cgroup_io_bytes_total{container="flow-go",device="8:0",direction="write"} 3.14773504e+08
+# HELP node_cgroup_io_ops_total I/O operations issued by the cgroup, by block device (major:minor) and direction.
+# TYPE node_cgroup_io_ops_total counter
+node_cgroup_io_ops_total{container="flow-go",device="8:0",direction="read"} 192
+node_cgroup_io_ops_total{container="flow-go",device="8:0",direction="write"} 353
+# HELP node_cgroup_memory_limit_bytes Memory limit of the cgroup, absent if unlimited.
+# TYPE node_cgroup_memory_limit_bytes gauge
+node_cgroup_memory_limit_bytes{container="flow-go"} 4.294967296e+09
+# HELP node_cgroup_memory_oom_kills_total Number of processes of the cgroup killed by the OOM killer.
+# TYPE node_cgroup_memory_oom_kills_total counter
+node_cgroup_memory_oom_kills_total{container="algod.service"} 0
+node_cgroup_memory_oom_kills_total{container="flow-go"} 1
+# HELP node_cgroup_memory_usage_bytes Memory currently used by the cgroup, including page cache.
+# TYPE node_cgroup_memory_usage_bytes gauge
+node_cgroup_memory_usage_bytes{container="algod.service"} 5.24288e+08
+node_cgroup_memory_usage_bytes{container="flow-go"} 1.073741824e+09
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestCgroupCollector_V1(t *testing.T) {
+	withCgroupFiles(t, map[string]string{
+		"memory/docker/" + cgroupTestContainerID + "/memory.usage_in_bytes":          "1073741824\n",
+		"memory/docker/" + cgroupTestContainerID + "/memory.limit_in_bytes":          "9223372036854771712\n",
+		"memory/docker/" + cgroupTestContainerID + "/memory.oom_control":             "oom_kill_disable 0\nunder_oom 0\noom_kill 2\n",
+		"cpuacct/docker/" + cgroupTestContainerID + "/cpuacct.usage":                 "2500000000\n",
+		"cpu/docker/" + cgroupTestContainerID + "/cpu.stat":                          "nr_periods 100\nnr_throttled 7\nthrottled_time 350000000\n",
+		"blkio/docker/" + cgroupTestContainerID + "/blkio.throttle.io_service_bytes": "8:0 Read 1459200\n8:0 Write 314773504\n8:0 Sync 314773504\n8:0 Async 1459200\n8:0 Total 316232704\nTotal 316232704\n",
+		"blkio/docker/" + cgroupTestContainerID + "/blkio.throttle.io_serviced":      "8:0 Read 192\n8:0 Write 353\n8:0 Total 545\nTotal 545\n",
+	}, "", func() (map[string]string, error) {
+		return map[string]string{"flow-go": cgroupTestContainerID}, nil
+	})
+
+	c, err := NewCgroupCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_cgroup_cpu_periods_total Number of enforcement periods elapsed for the cgroup CPU quota.
+# TYPE node_cgroup_cpu_periods_total counter
+node_cgroup_cpu_periods_total{container="flow-go"} 100
+# HELP node_cgroup_cpu_throttled_periods_total Number of enforcement periods the cgroup was throttled in.
+# TYPE node_cgroup_cpu_throttled_periods_total counter
+node_cgroup_cpu_throttled_periods_total{container="flow-go"} 7
+# HELP node_cgroup_cpu_throttled_seconds_total Total time the cgroup was throttled in seconds.
+# TYPE node_cgroup_cpu_throttled_seconds_total counter
+node_cgroup_cpu_throttled_seconds_total{container="flow-go"} 0.35
+# HELP node_cgroup_cpu_usage_seconds_total Total CPU time consumed by the cgroup in seconds.
+# TYPE node_cgroup_cpu_usage_seconds_total counter
+node_cgroup_cpu_usage_seconds_total{container="flow-go"} 2.5
+# HELP node_cgroup_io_bytes_total Bytes transferred by the cgroup, by block device (major:minor) and direction.
+# TYPE node_cgroup_io_bytes_total counter
+node_cgroup_io_bytes_total{container="flow-go",device="8:0",direction="read"} 1.4592e+06
+node_cgroup_io_bytes_total{container="flow-go",device="8:0",direction="write"} 3.14773504e+08
+# HELP node_cgroup_io_ops_total I/O operations issued by the cgroup, by block device (major:minor) and direction.
+# TYPE node_cgroup_io_ops_total counter
+node_cgroup_io_ops_total{container="flow-go",device="8:0",direction="read"} 192
+node_cgroup_io_ops_total{container="flow-go",device="8:0",direction="write"} 353
+# HELP node_cgroup_memory_oom_kills_total Number of processes of the cgroup killed by the OOM killer.
+# TYPE node_cgroup_memory_oom_kills_total counter
+node_cgroup_memory_oom_kills_total{container="flow-go"} 2
+# HELP node_cgroup_memory_usage_bytes Memory currently used by the cgroup, including page cache.
+# TYPE node_cgroup_memory_usage_bytes gauge
+node_cgroup_memory_usage_bytes{container="flow-go"} 1.073741824e+09
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestCgroupCollector_Errors(t *testing.T) {
+	withCgroupFiles(t, map[string]string{
+		"cgroup.controllers":                        "cpu io memory\n",
+		"system.slice/algod.service/memory.current": "garbage\n",
+	}, "algod=system.slice/algod.service,system.slice/missing.service", func() (map[string]string, error) {
+		return nil, errors.New("docker unavailable")
+	})
+
+	c, err := NewCgroupCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_scrape_collector_errors Number of errors encountered by a collector during the last scrape, by reason.
+# TYPE node_scrape_collector_errors gauge
+node_scrape_collector_errors{collector="cgroup",reason="not_found"} 1
+node_scrape_collector_errors{collector="cgroup",reason="other"} 1
+node_scrape_collector_errors{collector="cgroup",reason="parse"} 1
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want), "node_scrape_collector_errors"))
+}
+
+func TestNewCgroupCollector_InvalidPaths(t *testing.T) {
+	pathsWas := cgroupPaths
+	defer func() { cgroupPaths = pathsWas }()
+	cgroupPaths = "algod="
+
+	_, err := NewCgroupCollector()
+	require.Error(t, err)
+}
@@ -28,44 +28,84 @@ func (d *typedDesc) mustNewConstMetric(value float64, labels ...string) promethe
 type Name string
 
 var (
-	prometheusNetNetstat Name = "prometheus.proc.net.netstat_linux"
-	prometheusNetARP     Name = "prometheus.proc.net.arp_linux"
-	prometheusStat       Name = "prometheus.proc.stat_linux"
-	prometheusConntrack  Name = "prometheus.proc.conntrack_linux"
-	prometheusCPU        Name = "prometheus.proc.cpu"
-	prometheusDiskStats  Name = "prometheus.proc.diskstats"
-	prometheusEntropy    Name = "prometheus.proc.entropy"
-	prometheusFileFD     Name = "prometheus.proc.filefd"
-	prometheusFilesystem Name = "prometheus.proc.filesystem"
-	prometheusLoadAvg    Name = "prometheus.proc.loadavg"
-	prometheusMemInfo    Name = "prometheus.proc.meminfo"
-	prometheusNetClass   Name = "prometheus.proc.netclass"
-	prometheusNetDev     Name = "prometheus.proc.netdev"
-	prometheusSockStat   Name = "prometheus.proc.sockstat"
-	prometheusTextfile   Name = "prometheus.proc.textfile"
-	prometheusTime       Name = "prometheus.time"
-	prometheusUname      Name = "prometheus.uname"
-	prometheusVMStat     Name = "prometheus.vmstat"
+	prometheusNetNetstat  Name = "prometheus.proc.net.netstat_linux"
+	prometheusNetARP      Name = "prometheus.proc.net.arp_linux"
+	prometheusStat        Name = "prometheus.proc.stat_linux"
+	prometheusConntrack   Name = "prometheus.proc.conntrack_linux"
+	prometheusBonding     Name = "prometheus.proc.bonding"
+	prometheusBuddyinfo   Name = "prometheus.proc.buddyinfo"
+	prometheusCgroup      Name = "prometheus.proc.cgroup"
+	prometheusCPU         Name = "prometheus.proc.cpu"
+	prometheusDiskStats   Name = "prometheus.proc.diskstats"
+	prometheusEntropy     Name = "prometheus.proc.entropy"
+	prometheusFileFD      Name = "prometheus.proc.filefd"
+	prometheusFilesystem  Name = "prometheus.proc.filesystem"
+	prometheusHwmon       Name = "prometheus.proc.hwmon"
+	prometheusInfiniBand  Name = "prometheus.proc.infiniband"
+	prometheusInterrupts  Name = "prometheus.proc.interrupts"
+	prometheusIPVS        Name = "prometheus.proc.ipvs"
+	prometheusLoadAvg     Name = "prometheus.proc.loadavg"
+	prometheusMdadm       Name = "prometheus.proc.mdadm"
+	prometheusMemInfo     Name = "prometheus.proc.meminfo"
+	prometheusNetClass    Name = "prometheus.proc.netclass"
+	prometheusNetDev      Name = "prometheus.proc.netdev"
+	prometheusOSRelease   Name = "prometheus.os_release"
+	prometheusPowerSupply Name = "prometheus.proc.power_supply"
+	prometheusProcesses   Name = "prometheus.proc.processes"
+	prometheusRapl        Name = "prometheus.proc.rapl"
+	prometheusSchedstat   Name = "prometheus.proc.schedstat"
+	prometheusSoftirqs    Name = "prometheus.proc.softirqs"
+	prometheusSystemd     Name = "prometheus.systemd"
+	prometheusSockStat    Name = "prometheus.proc.sockstat"
+	prometheusTCPStat     Name = "prometheus.proc.tcpstat"
+	prometheusTextfile    Name = "prometheus.proc.textfile"
+	prometheusThermal     Name = "prometheus.proc.thermal_zone"
+	prometheusTime        Name = "prometheus.time"
+	prometheusTimex       Name = "prometheus.timex"
+	prometheusUDPQueues   Name = "prometheus.proc.udp_queues"
+	prometheusUname       Name = "prometheus.uname"
+	prometheusVMStat      Name = "prometheus.vmstat"
+	prometheusWifi        Name = "prometheus.proc.wifi"
 
 	// CollectorsFactory map of contrustors per node exporter collector
 	CollectorsFactory = map[Name]func() (prometheus.Collector, error){
-		prometheusNetNetstat: NewNetStatCollector,
-		prometheusNetARP:     NewARPCollector,
-		prometheusStat:       NewStatCollector,
-		prometheusConntrack:  NewConntrackCollector,
-		prometheusCPU:        NewCPUCollector,
-		prometheusDiskStats:  NewDiskstatsCollector,
-		prometheusEntropy:    NewEntropyCollector,
-		prometheusFileFD:     NewFileFDStatCollector,
-		prometheusFilesystem: NewFilesystemCollector,
-		prometheusLoadAvg:    NewLoadavgCollector,
-		prometheusMemInfo:    NewMeminfoCollector,
-		prometheusNetClass:   NewNetClassCollector,
-		prometheusNetDev:     NewNetDevCollector,
-		prometheusSockStat:   NewSockStatCollector,
-		prometheusTextfile:   NewTextFileCollector,
-		prometheusTime:       NewTimeCollector,
-		prometheusUname:      NewUnameCollector,
-		prometheusVMStat:     NewvmStatCollector,
+		prometheusNetNetstat:  NewNetStatCollector,
+		prometheusNetARP:      NewARPCollector,
+		prometheusStat:        NewStatCollector,
+		prometheusConntrack:   NewConntrackCollector,
+		prometheusBonding:     NewBondingCollector,
+		prometheusBuddyinfo:   NewBuddyinfoCollector,
+		prometheusCgroup:      NewCgroupCollector,
+		prometheusCPU:         NewCPUCollector,
+		prometheusDiskStats:   NewDiskstatsCollector,
+		prometheusEntropy:     NewEntropyCollector,
+		prometheusFileFD:      NewFileFDStatCollector,
+		prometheusFilesystem:  NewFilesystemCollector,
+		prometheusHwmon:       NewHwmonCollector,
+		prometheusInfiniBand:  NewInfiniBandCollector,
+		prometheusInterrupts:  NewInterruptsCollector,
+		prometheusIPVS:        NewIPVSCollector,
+		prometheusLoadAvg:     NewLoadavgCollector,
+		prometheusMdadm:       NewMdadmCollector,
+		prometheusMemInfo:     NewMeminfoCollector,
+		prometheusNetClass:    NewNetClassCollector,
+		prometheusNetDev:      NewNetDevCollector,
+		prometheusOSRelease:   NewOSCollector,
+		prometheusPowerSupply: NewPowerSupplyCollector,
+		prometheusProcesses:   NewProcessesCollector,
+		prometheusRapl:        NewRaplCollector,
+		prometheusSchedstat:   NewSchedstatCollector,
+		prometheusSoftirqs:    NewSoftirqsCollector,
+		prometheusSystemd:     NewSystemdCollector,
+		prometheusSockStat:    NewSockStatCollector,
+		prometheusTCPStat:     NewTCPStatCollector,
+		prometheusTextfile:    NewTextFileCollector,
+		prometheusThermal:     NewThermalZoneCollector,
+		prometheusTime:        NewTimeCollector,
+		prometheusTimex:       NewTimexCollector,
+		prometheusUDPQueues:   NewUDPQueuesCollector,
+		prometheusUname:       NewUnameCollector,
+		prometheusVMStat:      NewvmStatCollector,
+		prometheusWifi:        NewWifiCollector,
 	}
 )
@@ -17,10 +17,13 @@
 package collector
 
 import (
+	"errors"
 	"fmt"
+	"os"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/procfs"
+	"go.uber.org/zap"
 )
 
 type conntrackCollector struct {
@@ -34,6 +37,7 @@ type conntrackCollector struct {
 	drop          *prometheus.Desc
 	earlyDrop     *prometheus.Desc
 	searchRestart *prometheus.Desc
+	errorsDesc    *prometheus.Desc
 }
 
 type conntrackStatistics struct {
@@ -100,30 +104,32 @@ func NewConntrackCollector() (prometheus.Collector, error) {
 			"Number of conntrack table lookups which had to be restarted due to hashtable resizes.",
 			nil, nil,
 		),
+		errorsDesc: newScrapeErrorsDesc("conntrack"),
 	}, nil
 }
 
 func (c *conntrackCollector) Collect(ch chan<- prometheus.Metric) {
+	collectErrors(ch, c.errorsDesc, c.collect(ch))
+}
+
+func (c *conntrackCollector) collect(ch chan<- prometheus.Metric) error {
 	value, err := readUintFromFile(procFilePath("sys/net/netfilter/nf_conntrack_count"))
 	if err != nil {
-
-		return
+		return c.handleErr(err)
 	}
 	ch <- prometheus.MustNewConstMetric(
 		c.current, prometheus.GaugeValue, float64(value))
 
 	value, err = readUintFromFile(procFilePath("sys/net/netfilter/nf_conntrack_max"))
 	if err != nil {
-
-		return
+		return c.handleErr(err)
 	}
 	ch <- prometheus.MustNewConstMetric(
 		c.limit, prometheus.GaugeValue, float64(value))
 
 	conntrackStats, err := getConntrackStatistics()
 	if err != nil {
-
-		return
+		return c.handleErr(err)
 	}
 
 	ch <- prometheus.MustNewConstMetric(
@@ -142,6 +148,20 @@ func (c *conntrackCollector) Collect(ch chan<- prometheus.Metric) {
 		c.earlyDrop, prometheus.GaugeValue, float64(conntrackStats.earlyDrop))
 	ch <- prometheus.MustNewConstMetric(
 		c.searchRestart, prometheus.GaugeValue, float64(conntrackStats.searchRestart))
+
+	return nil
+}
+
+// handleErr conntrack files are missing when the nf_conntrack module is not
+// loaded, which is not an error worth reporting on every scrape.
+func (c *conntrackCollector) handleErr(err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		zap.S().Debugw("conntrack data not available, nf_conntrack module is probably not loaded", zap.Error(err))
+
+		return nil
+	}
+
+	return err
 }
 
 func getConntrackStatistics() (*conntrackStatistics, error) {
@@ -182,4 +202,5 @@ func (c *conntrackCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.drop
 	ch <- c.earlyDrop
 	ch <- c.searchRestart
+	ch <- c.errorsDesc
 }
@@ -0,0 +1,100 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noconntrack
+// +build !noconntrack
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConntrackCollector(t *testing.T) {
+	procPathWas := procPath
+	defer func() {
+		procPath = procPathWas
+	}()
+	procPath = "fixtures/proc"
+
+	c, err := NewConntrackCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_nf_conntrack_entries Number of currently allocated flow entries for connection tracking.
+# TYPE node_nf_conntrack_entries gauge
+node_nf_conntrack_entries 123
+# HELP node_nf_conntrack_entries_limit Maximum size of connection tracking table.
+# TYPE node_nf_conntrack_entries_limit gauge
+node_nf_conntrack_entries_limit 65536
+# HELP node_nf_conntrack_stat_ignore Number of packets seen which are already connected to a conntrack entry.
+# TYPE node_nf_conntrack_stat_ignore gauge
+node_nf_conntrack_stat_ignore 89738
+# HELP node_nf_conntrack_stat_invalid Number of packets seen which can not be tracked.
+# TYPE node_nf_conntrack_stat_invalid gauge
+node_nf_conntrack_stat_invalid 53
+# HELP node_nf_conntrack_stat_search_restart Number of conntrack table lookups which had to be restarted due to hashtable resizes.
+# TYPE node_nf_conntrack_stat_search_restart gauge
+node_nf_conntrack_stat_search_restart 7
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want),
+		"node_nf_conntrack_entries", "node_nf_conntrack_entries_limit", "node_nf_conntrack_stat_ignore",
+		"node_nf_conntrack_stat_invalid", "node_nf_conntrack_stat_search_restart", "node_scrape_collector_errors"))
+}
+
+func TestConntrackCollectorModuleNotLoaded(t *testing.T) {
+	procPathWas := procPath
+	defer func() {
+		procPath = procPathWas
+	}()
+	procPath = t.TempDir()
+
+	c, err := NewConntrackCollector()
+	require.NoError(t, err)
+
+	// no data and no error
+	require.Equal(t, 0, testutil.CollectAndCount(c))
+
+	// stats missing while the module is loaded
+	netfilter := filepath.Join(procPath, "sys", "net", "netfilter")
+	require.NoError(t, os.MkdirAll(netfilter, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(netfilter, "nf_conntrack_count"), []byte("1\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(netfilter, "nf_conntrack_max"), []byte("2\n"), 0o644))
+	require.Equal(t, 2, testutil.CollectAndCount(c))
+}
+
+func TestConntrackCollectorParseError(t *testing.T) {
+	procPathWas := procPath
+	defer func() {
+		procPath = procPathWas
+	}()
+	procPath = t.TempDir()
+
+	netfilter := filepath.Join(procPath, "sys", "net", "netfilter")
+	require.NoError(t, os.MkdirAll(netfilter, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(netfilter, "nf_conntrack_count"), []byte("invalid\n"), 0o644))
+
+	c, err := NewConntrackCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_scrape_collector_errors Number of errors encountered by a collector during the last scrape, by reason.
+# TYPE node_scrape_collector_errors gauge
+node_scrape_collector_errors{collector="conntrack",reason="parse"} 1
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
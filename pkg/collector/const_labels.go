@@ -0,0 +1,134 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// ErrInvalidConstLabel indicates a constant label that can't be added to the
+// collectors' series.
+var ErrInvalidConstLabel = errors.New("invalid constant label")
+
+// constLabels labels added to every series of the collectors registered
+// with Register, i.e. to attribute the metrics of one of several nodes
+// running on the same host.
+// collector.const-labels
+var constLabels prometheus.Labels
+
+// DefineConstLabelsFlag defines the flag setting the constant labels.
+func DefineConstLabelsFlag(flags *flag.FlagSet) {
+	flags.Var(constLabelsFlag{}, "collector.const-labels",
+		"Comma separated name=value labels added to every series exported by the Prometheus node exporter collectors, i.e. node_id=abc,network=mainnet.")
+}
+
+// SetConstLabels sets the labels added to every series of the collectors
+// registered from now on. The labels are validated against the Prometheus
+// label name rules, none of them is set if any is invalid.
+func SetConstLabels(labels prometheus.Labels) error {
+	for name := range labels {
+		if err := validateConstLabelName(name); err != nil {
+			return err
+		}
+	}
+
+	constLabels = make(prometheus.Labels, len(labels))
+	for name, value := range labels {
+		constLabels[name] = value
+	}
+
+	return nil
+}
+
+// ConstLabels returns a copy of the labels added to every series.
+func ConstLabels() prometheus.Labels {
+	labels := make(prometheus.Labels, len(constLabels))
+	for name, value := range constLabels {
+		labels[name] = value
+	}
+
+	return labels
+}
+
+func validateConstLabelName(name string) error {
+	if !model.LabelName(name).IsValid() {
+		return fmt.Errorf("%w: %q is not a valid label name", ErrInvalidConstLabel, name)
+	}
+	if strings.HasPrefix(name, model.ReservedLabelPrefix) {
+		return fmt.Errorf("%w: %q uses the reserved prefix %q", ErrInvalidConstLabel, name, model.ReservedLabelPrefix)
+	}
+	if name == SyntheticLabel {
+		return fmt.Errorf("%w: %q is set by the synthetic device", ErrInvalidConstLabel, name)
+	}
+
+	return nil
+}
+
+// wrapConstLabels returns reg adding the constant labels to the
+// descriptors of the collectors it registers.
+func wrapConstLabels(reg prometheus.Registerer) prometheus.Registerer {
+	if len(constLabels) == 0 {
+		return reg
+	}
+
+	return prometheus.WrapRegistererWith(constLabels, reg)
+}
+
+// constLabelsFlag flag.Value parsing the constant labels, validated as soon
+// as the flags are parsed.
+type constLabelsFlag struct{}
+
+func (constLabelsFlag) String() string {
+	names := make([]string, 0, len(constLabels))
+	for name := range constLabels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+constLabels[name])
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (constLabelsFlag) Set(s string) error {
+	labels := prometheus.Labels{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("%w: %q is not in name=value form", ErrInvalidConstLabel, pair)
+		}
+
+		name = strings.TrimSpace(name)
+		if _, ok := labels[name]; ok {
+			return fmt.Errorf("%w: %q is set more than once", ErrInvalidConstLabel, name)
+		}
+		labels[name] = strings.TrimSpace(value)
+	}
+
+	return SetConstLabels(labels)
+}
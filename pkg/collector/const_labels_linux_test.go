@@ -0,0 +1,54 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterConstLabels(t *testing.T) {
+	setupConstLabels(t)
+	procPathWas, sysPathWas, syntheticWas := procPath, sysPath, networkSyntheticDevice
+	defer func() {
+		procPath, sysPath, networkSyntheticDevice = procPathWas, sysPathWas, syntheticWas
+	}()
+	procPath, sysPath = "fixtures/proc", "fixtures/sys"
+
+	for _, synthetic := range []bool{false, true} {
+		networkSyntheticDevice = synthetic
+		require.NoError(t, SetConstLabels(prometheus.Labels{"node_id": "abc", "network": "mainnet"}))
+
+		reg := newSyntheticTestRegistry(t)
+		families, err := reg.Gather()
+		require.NoError(t, err)
+
+		names := map[string]bool{}
+		for _, family := range families {
+			names[family.GetName()] = true
+			for _, m := range family.GetMetric() {
+				labels := map[string]string{}
+				for _, pair := range m.GetLabel() {
+					labels[pair.GetName()] = pair.GetValue()
+				}
+				require.Equal(t, "abc", labels["node_id"], family.GetName())
+				require.Equal(t, "mainnet", labels["network"], family.GetName())
+			}
+		}
+		require.True(t, names["node_network_info"])
+		require.True(t, names["node_network_receive_bytes_total"])
+	}
+}
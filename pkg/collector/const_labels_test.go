@@ -0,0 +1,81 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func setupConstLabels(t *testing.T) {
+	t.Helper()
+
+	constLabelsWas := constLabels
+	t.Cleanup(func() {
+		constLabels = constLabelsWas
+	})
+}
+
+func TestSetConstLabels(t *testing.T) {
+	setupConstLabels(t)
+
+	tests := []struct {
+		name    string
+		labels  prometheus.Labels
+		wantErr bool
+	}{
+		{"valid", prometheus.Labels{"node_id": "abc", "network": "mainnet"}, false},
+		{"empty value", prometheus.Labels{"network": ""}, false},
+		{"leading digit", prometheus.Labels{"1node": "abc"}, true},
+		{"dash", prometheus.Labels{"node-id": "abc"}, true},
+		{"empty name", prometheus.Labels{"": "abc"}, true},
+		{"reserved prefix", prometheus.Labels{"__name__": "abc"}, true},
+		{"synthetic", prometheus.Labels{SyntheticLabel: "true"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			constLabels = prometheus.Labels{"previous": "value"}
+
+			err := SetConstLabels(tt.labels)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidConstLabel)
+				require.Equal(t, prometheus.Labels{"previous": "value"}, ConstLabels())
+
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.labels, ConstLabels())
+		})
+	}
+}
+
+func TestConstLabelsFlag(t *testing.T) {
+	setupConstLabels(t)
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	DefineConstLabelsFlag(flags)
+
+	require.NoError(t, flags.Parse([]string{"--collector.const-labels", "node_id=abc, network=main=net,"}))
+	require.Equal(t, prometheus.Labels{"node_id": "abc", "network": "main=net"}, ConstLabels())
+	require.Equal(t, "network=main=net,node_id=abc", flags.Lookup("collector.const-labels").Value.String())
+
+	for _, invalid := range []string{"node_id", "node-id=abc", "node_id=abc,node_id=def"} {
+		err := flags.Set("collector.const-labels", invalid)
+		require.ErrorIs(t, err, ErrInvalidConstLabel, invalid)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//go:build cgo && !nocpu
+// +build cgo,!nocpu
+
+package collector
+
+/*
+#include <mach/mach_init.h>
+#include <mach/mach_host.h>
+#include <mach/processor_info.h>
+#include <mach/vm_map.h>
+#include <time.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"strconv"
+	"unsafe"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// darwinCPUModes CPU states of processor_cpu_load_info, by mode label.
+var darwinCPUModes = []struct {
+	mode  string
+	state int
+}{
+	{"user", C.CPU_STATE_USER},
+	{"system", C.CPU_STATE_SYSTEM},
+	{"nice", C.CPU_STATE_NICE},
+	{"idle", C.CPU_STATE_IDLE},
+}
+
+type cpuCollector struct {
+	cpu        *prometheus.Desc
+	errorsDesc *prometheus.Desc
+}
+
+// NewCPUCollector returns a new Collector exposing the CPU times from
+// host_processor_info.
+func NewCPUCollector() (prometheus.Collector, error) {
+	return &cpuCollector{
+		cpu:        nodeCPUSecondsDesc,
+		errorsDesc: newScrapeErrorsDesc("cpu"),
+	}, nil
+}
+
+func (c *cpuCollector) Collect(ch chan<- prometheus.Metric) {
+	collectErrors(ch, c.errorsDesc, c.collect(ch))
+}
+
+func (c *cpuCollector) collect(ch chan<- prometheus.Metric) error {
+	var (
+		count   C.mach_msg_type_number_t
+		cpuLoad *C.processor_cpu_load_info_data_t
+		ncpu    C.natural_t
+	)
+
+	status := C.host_processor_info(C.host_t(C.mach_host_self()),
+		C.PROCESSOR_CPU_LOAD_INFO,
+		&ncpu,
+		(*C.processor_info_array_t)(unsafe.Pointer(&cpuLoad)),
+		&count)
+	if status != C.KERN_SUCCESS {
+		return fmt.Errorf("host_processor_info error=%d", status)
+	}
+
+	// the array is allocated in the task's address space by the kernel
+	defer C.vm_deallocate(C.vm_map_t(C.mach_task_self_),
+		C.vm_address_t(uintptr(unsafe.Pointer(cpuLoad))),
+		C.vm_size_t(uintptr(count)*unsafe.Sizeof(C.integer_t(0))))
+
+	loads := unsafe.Slice(cpuLoad, int(ncpu))
+	for i, load := range loads {
+		cpu := strconv.Itoa(i)
+		for _, m := range darwinCPUModes {
+			ticks := float64(load.cpu_ticks[m.state])
+			ch <- prometheus.MustNewConstMetric(c.cpu, prometheus.CounterValue, ticks/float64(C.CLK_TCK), cpu, m.mode)
+		}
+	}
+
+	return nil
+}
+
+func (c *cpuCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.cpu
+	ch <- c.errorsDesc
+}
@@ -333,56 +333,28 @@ func (c *cpuCollector) updateCPUStats(newStats []procfs.CPUStat) {
 			c.cpuStats[i] = procfs.CPUStat{}
 		}
 
-		if n.Idle >= c.cpuStats[i].Idle {
-			c.cpuStats[i].Idle = n.Idle
-		} else {
-		}
-
-		if n.User >= c.cpuStats[i].User {
-			c.cpuStats[i].User = n.User
-		} else {
-		}
-
-		if n.Nice >= c.cpuStats[i].Nice {
-			c.cpuStats[i].Nice = n.Nice
-		} else {
-		}
-
-		if n.System >= c.cpuStats[i].System {
-			c.cpuStats[i].System = n.System
-		} else {
-		}
-
-		if n.Iowait >= c.cpuStats[i].Iowait {
-			c.cpuStats[i].Iowait = n.Iowait
-		} else {
-		}
-
-		if n.IRQ >= c.cpuStats[i].IRQ {
-			c.cpuStats[i].IRQ = n.IRQ
-		} else {
-		}
-
-		if n.SoftIRQ >= c.cpuStats[i].SoftIRQ {
-			c.cpuStats[i].SoftIRQ = n.SoftIRQ
-		} else {
-		}
-
-		if n.Steal >= c.cpuStats[i].Steal {
-			c.cpuStats[i].Steal = n.Steal
-		} else {
-		}
-
-		if n.Guest >= c.cpuStats[i].Guest {
-			c.cpuStats[i].Guest = n.Guest
-		} else {
-		}
+		// Any other counter going backwards, by any amount, keeps its last
+		// value, so a decreasing counter is never exported.
+		c.cpuStats[i].Idle = clampCounter(c.cpuStats[i].Idle, n.Idle)
+		c.cpuStats[i].User = clampCounter(c.cpuStats[i].User, n.User)
+		c.cpuStats[i].Nice = clampCounter(c.cpuStats[i].Nice, n.Nice)
+		c.cpuStats[i].System = clampCounter(c.cpuStats[i].System, n.System)
+		c.cpuStats[i].Iowait = clampCounter(c.cpuStats[i].Iowait, n.Iowait)
+		c.cpuStats[i].IRQ = clampCounter(c.cpuStats[i].IRQ, n.IRQ)
+		c.cpuStats[i].SoftIRQ = clampCounter(c.cpuStats[i].SoftIRQ, n.SoftIRQ)
+		c.cpuStats[i].Steal = clampCounter(c.cpuStats[i].Steal, n.Steal)
+		c.cpuStats[i].Guest = clampCounter(c.cpuStats[i].Guest, n.Guest)
+		c.cpuStats[i].GuestNice = clampCounter(c.cpuStats[i].GuestNice, n.GuestNice)
+	}
+}
 
-		if n.GuestNice >= c.cpuStats[i].GuestNice {
-			c.cpuStats[i].GuestNice = n.GuestNice
-		} else {
-		}
+// clampCounter returns next, or prev if the counter went backwards.
+func clampCounter(prev, next float64) float64 {
+	if next < prev {
+		return prev
 	}
+
+	return next
 }
 
 // Describe exposes descriptors for this collector.
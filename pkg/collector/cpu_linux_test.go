@@ -99,3 +99,27 @@ func TestCPU(t *testing.T) {
 		t.Fatalf("should have %v CPU Stat: got %v", resetIdle, got)
 	}
 }
+
+func TestCPUClampsDecreasingCounters(t *testing.T) {
+	c := makeTestCPUCollector([]procfs.CPUStat{{User: 100.0, System: 100.0, Idle: 100.0}})
+
+	// System goes backwards while idle keeps increasing, not a hotplug event.
+	c.updateCPUStats([]procfs.CPUStat{{User: 101.0, System: 99.0, Idle: 101.0}})
+
+	want := []procfs.CPUStat{{User: 101.0, System: 100.0, Idle: 101.0}}
+	if got := c.cpuStats; !reflect.DeepEqual(want, got) {
+		t.Fatalf("should have %v CPU Stat: got %v", want, got)
+	}
+}
+
+func TestCPUHotplugResize(t *testing.T) {
+	c := makeTestCPUCollector([]procfs.CPUStat{{User: 100.0, Idle: 100.0}})
+
+	// A CPU coming online changes the number of CPUs and resets the cache.
+	want := []procfs.CPUStat{{User: 50.0, Idle: 50.0}, {User: 10.0, Idle: 10.0}}
+	c.updateCPUStats(want)
+
+	if got := c.cpuStats; !reflect.DeepEqual(want, got) {
+		t.Fatalf("should have %v CPU Stat: got %v", want, got)
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//go:build !cgo && !nocpu
+// +build !cgo,!nocpu
+
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewCPUCollector returns a Collector reporting no data, the CPU times are
+// read with host_processor_info which requires cgo.
+func NewCPUCollector() (prometheus.Collector, error) { return newUnsupportedCollector("cpu") }
@@ -27,6 +27,7 @@ type entropyCollector struct {
 	fs              procfs.FS
 	entropyAvail    *prometheus.Desc
 	entropyPoolSize *prometheus.Desc
+	errorsDesc      *prometheus.Desc
 }
 
 // NewEntropyCollector returns a new Collector exposing entropy stats.
@@ -48,33 +49,33 @@ func NewEntropyCollector() (prometheus.Collector, error) {
 			"Bits of entropy pool.",
 			nil, nil,
 		),
+		errorsDesc: newScrapeErrorsDesc("entropy"),
 	}, nil
 }
 
+// Collect exports the available entropy and pool size. Since kernel 5.18
+// both are pinned at 256 bits, the values are exported as read.
 func (c *entropyCollector) Collect(ch chan<- prometheus.Metric) {
 	stats, err := c.fs.KernelRandom()
 	if err != nil {
-		err = fmt.Errorf("failed to get kernel random stats: %w", err)
+		collectErrors(ch, c.errorsDesc, fmt.Errorf("failed to get kernel random stats: %w", err))
 
 		return
 	}
 
-	if stats.EntropyAvaliable == nil {
-
-		return
+	if stats.EntropyAvaliable != nil {
+		ch <- prometheus.MustNewConstMetric(
+			c.entropyAvail, prometheus.GaugeValue, float64(*stats.EntropyAvaliable))
 	}
-	ch <- prometheus.MustNewConstMetric(
-		c.entropyAvail, prometheus.GaugeValue, float64(*stats.EntropyAvaliable))
 
-	if stats.PoolSize == nil {
-
-		return
+	if stats.PoolSize != nil {
+		ch <- prometheus.MustNewConstMetric(
+			c.entropyPoolSize, prometheus.GaugeValue, float64(*stats.PoolSize))
 	}
-	ch <- prometheus.MustNewConstMetric(
-		c.entropyPoolSize, prometheus.GaugeValue, float64(*stats.PoolSize))
 }
 
 func (c *entropyCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.entropyAvail
 	ch <- c.entropyPoolSize
+	ch <- c.errorsDesc
 }
@@ -0,0 +1,99 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noentropy
+// +build !noentropy
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntropyCollector(t *testing.T) {
+	procPathWas := procPath
+	defer func() {
+		procPath = procPathWas
+	}()
+
+	writeRandom := func(t *testing.T, files map[string]string) {
+		procPath = t.TempDir()
+		dir := filepath.Join(procPath, "sys", "kernel", "random")
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		for name, content := range files {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+		}
+	}
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T)
+		want  string
+	}{
+		{
+			name:  "fixtures",
+			setup: func(t *testing.T) { procPath = "fixtures/proc" },
+			want: `# HELP node_entropy_available_bits Bits of available entropy.
+# TYPE node_entropy_available_bits gauge
+node_entropy_available_bits 1337
+# HELP node_entropy_pool_size_bits Bits of entropy pool.
+# TYPE node_entropy_pool_size_bits gauge
+node_entropy_pool_size_bits 4096
+`,
+		},
+		{
+			// kernel 5.18+ pins both values to 256 bits
+			name:  "pinned entropy",
+			setup: func(t *testing.T) { writeRandom(t, map[string]string{"entropy_avail": "256\n", "poolsize": "256\n"}) },
+			want: `# HELP node_entropy_available_bits Bits of available entropy.
+# TYPE node_entropy_available_bits gauge
+node_entropy_available_bits 256
+# HELP node_entropy_pool_size_bits Bits of entropy pool.
+# TYPE node_entropy_pool_size_bits gauge
+node_entropy_pool_size_bits 256
+`,
+		},
+		{
+			name:  "missing entropy_avail",
+			setup: func(t *testing.T) { writeRandom(t, map[string]string{"poolsize": "4096\n"}) },
+			want: `# HELP node_entropy_pool_size_bits Bits of entropy pool.
+# TYPE node_entropy_pool_size_bits gauge
+node_entropy_pool_size_bits 4096
+`,
+		},
+		{
+			name:  "malformed value",
+			setup: func(t *testing.T) { writeRandom(t, map[string]string{"entropy_avail": "foo\n", "poolsize": "4096\n"}) },
+			want: `# HELP node_scrape_collector_errors Number of errors encountered by a collector during the last scrape, by reason.
+# TYPE node_scrape_collector_errors gauge
+node_scrape_collector_errors{collector="entropy",reason="parse"} 1
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup(t)
+
+			c, err := NewEntropyCollector()
+			require.NoError(t, err)
+			require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(tt.want)))
+		})
+	}
+}
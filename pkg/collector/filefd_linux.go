@@ -18,49 +18,117 @@ package collector
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"strconv"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
 )
 
 const (
 	fileFDStatSubsystem = "filefd"
 )
 
+// fileFDSelf Export the agent's own open file descriptors and their limit
+// along with the system-wide ones.
+// collector.filefd.self
+var fileFDSelf = true
+
+// DefineFileFDFlags defines the flags of the filefd collector.
+func DefineFileFDFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&fileFDSelf, "collector.filefd.self", fileFDSelf,
+		"Export the agent's own open file descriptors and their limit, from /proc/self.")
+}
+
 type fileFDStatCollector struct {
+	allocated   *prometheus.Desc
+	maximum     *prometheus.Desc
+	selfOpen    *prometheus.Desc
+	selfMaximum *prometheus.Desc
+	errorsDesc  *prometheus.Desc
 }
 
-// NewFileFDStatCollector returns a new Collector exposing file-nr stats.
+// NewFileFDStatCollector returns a new Collector exposing file-nr stats,
+// and optionally the agent's own file descriptor usage.
 func NewFileFDStatCollector() (prometheus.Collector, error) {
-	return &fileFDStatCollector{}, nil
+	return &fileFDStatCollector{
+		allocated: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, fileFDStatSubsystem, "allocated"),
+			"File descriptor statistics: allocated.",
+			nil, nil,
+		),
+		maximum: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, fileFDStatSubsystem, "maximum"),
+			"File descriptor statistics: maximum.",
+			nil, nil,
+		),
+		selfOpen: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, fileFDStatSubsystem, "self_open"),
+			"Number of file descriptors opened by the agent.",
+			nil, nil,
+		),
+		selfMaximum: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, fileFDStatSubsystem, "self_maximum"),
+			"Maximum number of file descriptors the agent can open (soft limit).",
+			nil, nil,
+		),
+		errorsDesc: newScrapeErrorsDesc("filefd"),
+	}, nil
 }
 
 func (c *fileFDStatCollector) Collect(ch chan<- prometheus.Metric) {
+	errs := &multiError{}
+
 	fileFDStat, err := parseFileFDStats(procFilePath("sys/fs/file-nr"))
 	if err != nil {
-		err = fmt.Errorf("couldn't get file-nr: %w", err)
+		errs.Add("file-nr", fmt.Errorf("couldn't get file-nr: %w", err))
+	} else {
+		for name, desc := range map[string]*prometheus.Desc{"allocated": c.allocated, "maximum": c.maximum} {
+			v, err := strconv.ParseFloat(fileFDStat[name], 64)
+			if err != nil {
+				errs.Add("file-nr", fmt.Errorf("invalid value %s in file-nr: %v: %w", fileFDStat[name], err, ErrParse))
 
-		return
-	}
-	for name, value := range fileFDStat {
-		v, err := strconv.ParseFloat(value, 64)
-		if err != nil {
-			err = fmt.Errorf("invalid value %s in file-nr: %w", value, err)
-			return
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v)
 		}
-		ch <- prometheus.MustNewConstMetric(
-			prometheus.NewDesc(
-				prometheus.BuildFQName(namespace, fileFDStatSubsystem, name),
-				fmt.Sprintf("File descriptor statistics: %s.", name),
-				nil, nil,
-			),
-			prometheus.GaugeValue, v,
-		)
 	}
-	return
+
+	if fileFDSelf {
+		errs.Add("self", c.collectSelf(ch))
+	}
+
+	collectErrors(ch, c.errorsDesc, errs.ErrorOrNil())
+}
+
+// collectSelf exports the agent's open file descriptors and their limit.
+func (c *fileFDStatCollector) collectSelf(ch chan<- prometheus.Metric) error {
+	fs, err := procfs.NewFS(procPath)
+	if err != nil {
+		return fmt.Errorf("failed to open procfs: %w", err)
+	}
+
+	self, err := fs.Self()
+	if err != nil {
+		return fmt.Errorf("couldn't get self process: %w", err)
+	}
+
+	open, err := self.FileDescriptorsLen()
+	if err != nil {
+		return fmt.Errorf("couldn't count self file descriptors: %w", err)
+	}
+	ch <- prometheus.MustNewConstMetric(c.selfOpen, prometheus.GaugeValue, float64(open))
+
+	limits, err := self.Limits()
+	if err != nil {
+		return fmt.Errorf("couldn't get self limits: %w", err)
+	}
+	ch <- prometheus.MustNewConstMetric(c.selfMaximum, prometheus.GaugeValue, float64(limits.OpenFiles))
+
+	return nil
 }
 
 func parseFileFDStats(filename string) (map[string]string, error) {
@@ -76,7 +144,7 @@ func parseFileFDStats(filename string) (map[string]string, error) {
 	}
 	parts := bytes.Split(bytes.TrimSpace(content), []byte("\u0009"))
 	if len(parts) < 3 {
-		return nil, fmt.Errorf("unexpected number of file stats in %q", filename)
+		return nil, fmt.Errorf("unexpected number of file stats in %q: %w", filename, ErrParse)
 	}
 
 	var fileFDStat = map[string]string{}
@@ -89,17 +157,9 @@ func parseFileFDStats(filename string) (map[string]string, error) {
 }
 
 func (c *fileFDStatCollector) Describe(ch chan<- *prometheus.Desc) {
-	fileFDStat, err := parseFileFDStats(procFilePath("sys/fs/file-nr"))
-	if err != nil {
-		err = fmt.Errorf("couldn't get file-nr: %w", err)
-
-		return
-	}
-	for name := range fileFDStat {
-		ch <- prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, fileFDStatSubsystem, name),
-			fmt.Sprintf("File descriptor statistics: %s.", name),
-			nil, nil,
-		)
-	}
+	ch <- c.allocated
+	ch <- c.maximum
+	ch <- c.selfOpen
+	ch <- c.selfMaximum
+	ch <- c.errorsDesc
 }
@@ -13,7 +13,15 @@
 
 package collector
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
 
 func TestFileFDStats(t *testing.T) {
 	fileFDStats, err := parseFileFDStats("fixtures/proc/sys/fs/file-nr")
@@ -29,3 +37,55 @@ func TestFileFDStats(t *testing.T) {
 		t.Errorf("want filefd maximum %q, got %q", want, got)
 	}
 }
+
+func TestFileFDStatCollector(t *testing.T) {
+	procPathWas := procPath
+	defer func() {
+		procPath = procPathWas
+	}()
+	procPath = t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(procPath, "sys/fs"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(procPath, "sys/fs/file-nr"), []byte("1024\t0\t1631329\n"), 0o644))
+
+	// the agent's process
+	require.NoError(t, os.MkdirAll(filepath.Join(procPath, "42/fd"), 0o755))
+	for _, fd := range []string{"0", "1", "2"} {
+		require.NoError(t, os.WriteFile(filepath.Join(procPath, "42/fd", fd), nil, 0o644))
+	}
+	limits := `Limit                     Soft Limit           Hard Limit           Units
+Max open files            2048                 4096                 files
+`
+	require.NoError(t, os.WriteFile(filepath.Join(procPath, "42/limits"), []byte(limits), 0o644))
+	require.NoError(t, os.Symlink("42", filepath.Join(procPath, "self")))
+
+	c, err := NewFileFDStatCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_filefd_allocated File descriptor statistics: allocated.
+# TYPE node_filefd_allocated gauge
+node_filefd_allocated 1024
+# HELP node_filefd_maximum File descriptor statistics: maximum.
+# TYPE node_filefd_maximum gauge
+node_filefd_maximum 1.631329e+06
+# HELP node_filefd_self_maximum Maximum number of file descriptors the agent can open (soft limit).
+# TYPE node_filefd_self_maximum gauge
+node_filefd_self_maximum 2048
+# HELP node_filefd_self_open Number of file descriptors opened by the agent.
+# TYPE node_filefd_self_open gauge
+node_filefd_self_open 3
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+
+	// file-nr missing, the agent's usage is still exported
+	require.NoError(t, os.Remove(filepath.Join(procPath, "sys/fs/file-nr")))
+	want = `# HELP node_filefd_self_open Number of file descriptors opened by the agent.
+# TYPE node_filefd_self_open gauge
+node_filefd_self_open 3
+# HELP node_scrape_collector_errors Number of errors encountered by a collector during the last scrape, by reason.
+# TYPE node_scrape_collector_errors gauge
+node_scrape_collector_errors{collector="filefd",reason="not_found"} 1
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want),
+		"node_filefd_self_open", "node_scrape_collector_errors"))
+}
@@ -19,9 +19,9 @@ package collector
 
 import (
 	"errors"
-	"regexp"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
 )
 
 // Arch-dependent implementation must define:
@@ -56,11 +56,12 @@ var (
 )
 
 type filesystemCollector struct {
-	excludedMountPointsPattern    *regexp.Regexp
-	excludedFSTypesPattern        *regexp.Regexp
+	excludedMountPointsPattern    deviceMatcher
+	excludedFSTypesPattern        deviceMatcher
 	sizeDesc, freeDesc, availDesc *prometheus.Desc
 	filesDesc, filesFreeDesc      *prometheus.Desc
 	roDesc, deviceErrorDesc       *prometheus.Desc
+	errorsDesc                    *prometheus.Desc
 }
 
 type filesystemLabels struct {
@@ -93,8 +94,8 @@ func NewFilesystemCollector() (prometheus.Collector, error) {
 	}
 
 	subsystem := "filesystem"
-	mountPointPattern := regexp.MustCompile(mountPointsExclude)
-	filesystemsTypesPattern := regexp.MustCompile(fsTypesExclude)
+	mountPointPattern := mustNewDeviceMatcher(mountPointsExclude)
+	filesystemsTypesPattern := mustNewDeviceMatcher(fsTypesExclude)
 
 	sizeDesc := prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, subsystem, "size_bytes"),
@@ -148,12 +149,15 @@ func NewFilesystemCollector() (prometheus.Collector, error) {
 		filesFreeDesc:              filesFreeDesc,
 		roDesc:                     roDesc,
 		deviceErrorDesc:            deviceErrorDesc,
+		errorsDesc:                 newScrapeErrorsDesc("filesystem"),
 	}, nil
 }
 
 func (c *filesystemCollector) Collect(ch chan<- prometheus.Metric) {
 	stats, err := c.GetStats()
 	if err != nil {
+		zap.S().Debugw("could not get filesystem stats", zap.Error(err))
+		collectErrors(ch, c.errorsDesc, err)
 	}
 	// Make sure we expose a metric once, even if there are multiple mounts
 	seen := map[filesystemLabels]bool{}
@@ -199,9 +203,9 @@ func (c *filesystemCollector) Collect(ch chan<- prometheus.Metric) {
 }
 
 func (c *filesystemCollector) Describe(ch chan<- *prometheus.Desc) {
-	stats, err := c.GetStats()
-	if err != nil {
-	}
+	ch <- c.errorsDesc
+
+	stats, _ := c.GetStats()
 	// Make sure we expose a metric once, even if there are multiple mounts
 	seen := map[filesystemLabels]bool{}
 	for _, s := range stats {
@@ -0,0 +1,83 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//go:build !nofilesystem
+// +build !nofilesystem
+
+package collector
+
+import (
+	"bytes"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	defMountPointsExcluded = "^/(dev)($|/)"
+	defFSTypesExcluded     = "^(autofs|devfs)$"
+)
+
+// GetStats returns filesystem stats of the mounted filesystems, read with
+// getfsstat without waiting on unresponsive network mounts.
+func (c *filesystemCollector) GetStats() ([]filesystemStats, error) {
+	n, err := unix.Getfsstat(nil, unix.MNT_NOWAIT)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]unix.Statfs_t, n)
+	n, err = unix.Getfsstat(buf, unix.MNT_NOWAIT)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := []filesystemStats{}
+	for _, fs := range buf[:n] {
+		labels := filesystemLabels{
+			device:     cString(fs.Mntfromname[:]),
+			mountPoint: rootfsStripPrefix(cString(fs.Mntonname[:])),
+			fsType:     cString(fs.Fstypename[:]),
+		}
+		if c.excludedMountPointsPattern.MatchString(labels.mountPoint) {
+			continue
+		}
+		if c.excludedFSTypesPattern.MatchString(labels.fsType) {
+			continue
+		}
+
+		var ro float64
+		if fs.Flags&unix.MNT_RDONLY != 0 {
+			ro = 1
+		}
+
+		stats = append(stats, filesystemStats{
+			labels:    labels,
+			size:      float64(fs.Blocks) * float64(fs.Bsize),
+			free:      float64(fs.Bfree) * float64(fs.Bsize),
+			avail:     float64(fs.Bavail) * float64(fs.Bsize),
+			files:     float64(fs.Files),
+			filesFree: float64(fs.Ffree),
+			ro:        ro,
+		})
+	}
+
+	return stats, nil
+}
+
+// cString returns the NUL terminated string in b.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+
+	return string(b)
+}
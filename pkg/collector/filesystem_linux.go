@@ -40,12 +40,14 @@ var mountTimeout = 5 * time.Second
 var stuckMounts = make(map[string]struct{})
 var stuckMountsMtx = &sync.Mutex{}
 
-// GetStats returns filesystem stats.
+// GetStats returns filesystem stats. Mount points that could not be stat'ed
+// are flagged with deviceError and reported in the returned multiError.
 func (c *filesystemCollector) GetStats() ([]filesystemStats, error) {
 	mps, err := mountPointDetails()
 	if err != nil {
 		return nil, err
 	}
+	errs := &multiError{}
 	stats := []filesystemStats{}
 	for _, labels := range mps {
 		if c.excludedMountPointsPattern.MatchString(labels.mountPoint) {
@@ -81,6 +83,7 @@ func (c *filesystemCollector) GetStats() ([]filesystemStats, error) {
 		stuckMountsMtx.Unlock()
 
 		if err != nil {
+			errs.Add(labels.mountPoint, err)
 			stats = append(stats, filesystemStats{
 				labels:      labels,
 				deviceError: 1,
@@ -107,7 +110,7 @@ func (c *filesystemCollector) GetStats() ([]filesystemStats, error) {
 			ro:        ro,
 		})
 	}
-	return stats, nil
+	return stats, errs.ErrorOrNil()
 }
 
 // stuckMountWatcher listens on the given success channel and if the channel closes
@@ -154,7 +157,7 @@ func parseFilesystemLabels(r io.Reader) ([]filesystemLabels, error) {
 		parts := strings.Fields(scanner.Text())
 
 		if len(parts) < 4 {
-			return nil, fmt.Errorf("malformed mount point information: %q", scanner.Text())
+			return nil, fmt.Errorf("malformed mount point information %q: %w", scanner.Text(), ErrParse)
 		}
 
 		// Ensure we handle the translation of \040 and \011
@@ -14,8 +14,12 @@
 package collector
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func Test_parseFilesystemLabelsError(t *testing.T) {
@@ -142,3 +146,48 @@ func TestPathRootfs(t *testing.T) {
 		}
 	}
 }
+
+func TestFilesystemCollectorErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		mounts string
+		want   string
+	}{
+		{
+			name:   "not found",
+			mounts: "/dev/sda1 /nonexistent/mountpoint ext4 rw 0 0\n",
+			want:   `node_scrape_collector_errors{collector="filesystem",reason="not_found"} 1`,
+		},
+		{
+			name:   "parse",
+			mounts: "malformed\n",
+			want:   `node_scrape_collector_errors{collector="filesystem",reason="parse"} 1`,
+		},
+	}
+
+	procPathWas := procPath
+	defer func() {
+		procPath = procPathWas
+	}()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procPath = t.TempDir()
+			if err := os.WriteFile(filepath.Join(procPath, "mounts"), []byte(tt.mounts), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := NewFilesystemCollector()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := `# HELP node_scrape_collector_errors Number of errors encountered by a collector during the last scrape, by reason.
+# TYPE node_scrape_collector_errors gauge
+` + tt.want + "\n"
+			if err := testutil.CollectAndCompare(c, strings.NewReader(want), "node_scrape_collector_errors"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
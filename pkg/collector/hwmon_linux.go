@@ -0,0 +1,373 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nohwmon
+// +build !nohwmon
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// hwmonSensorUnit unit of a sensor type, the scale converting its sysfs
+// values to the unit and the unit's metric name suffix.
+type hwmonSensorUnit struct {
+	scale  float64
+	suffix string
+}
+
+var (
+	hwmonInvalidMetricChars = regexp.MustCompile("[^a-z0-9:_]")
+	hwmonFilenameFormat     = regexp.MustCompile(`^(?P<type>[a-z]+)(?P<id>[0-9]+)_(?P<property>.+)$`)
+
+	// hwmonSensorUnits exported sensor types, see
+	// https://www.kernel.org/doc/Documentation/hwmon/sysfs-interface
+	hwmonSensorUnits = map[string]hwmonSensorUnit{
+		"temp":  {0.001, "celsius"}, // millidegree Celsius
+		"fan":   {1, "rpm"},         // revolutions per minute
+		"in":    {0.001, "volts"},   // millivolts
+		"power": {0.000001, "watts"},
+		"curr":  {0.001, "amps"}, // milliamperes
+	}
+
+	// hwmonValueProperties properties exported with the sensor's unit,
+	// input being the reading itself and the others configured limits or
+	// historical values.
+	hwmonValueProperties = map[string]bool{
+		"input":     true,
+		"min":       true,
+		"max":       true,
+		"lcrit":     true,
+		"crit":      true,
+		"emergency": true,
+		"max_hyst":  true,
+		"crit_hyst": true,
+		"lowest":    true,
+		"highest":   true,
+		"average":   true,
+		"target":    true,
+	}
+
+	// hwmonStatusProperties unitless alarm and fault flags.
+	hwmonStatusProperties = map[string]bool{
+		"alarm":           true,
+		"min_alarm":       true,
+		"max_alarm":       true,
+		"lcrit_alarm":     true,
+		"crit_alarm":      true,
+		"emergency_alarm": true,
+		"fault":           true,
+	}
+
+	hwmonLabels = []string{"chip", "sensor"}
+)
+
+// hwmonReadFile reads a sysfs attribute, overridden by tests.
+var hwmonReadFile = func(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+type hwmonCollector struct {
+	chipNameDesc *prometheus.Desc
+	errorsDesc   *prometheus.Desc
+}
+
+// NewHwmonCollector returns a new Collector exposing temperature, fan,
+// voltage, power and current sensors from /sys/class/hwmon.
+func NewHwmonCollector() (prometheus.Collector, error) {
+	return &hwmonCollector{
+		chipNameDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "hwmon", "chip_names"),
+			"Annotation metric for human-readable chip names",
+			[]string{"chip", "chip_name"}, nil,
+		),
+		errorsDesc: newScrapeErrorsDesc("hwmon"),
+	}, nil
+}
+
+// hwmonSensor readings of a single sensor, keyed by property.
+type hwmonSensor struct {
+	sensorType string
+	attr       string
+	label      string
+	values     map[string]string
+}
+
+// cleanHwmonName returns name in lowercase with invalid characters replaced.
+func cleanHwmonName(name string) string {
+	return strings.Trim(hwmonInvalidMetricChars.ReplaceAllLiteralString(strings.ToLower(name), "_"), "_")
+}
+
+// hwmonSensorDesc returns the descriptor of a sensor property.
+func hwmonSensorDesc(sensorType, property string) *prometheus.Desc {
+	if hwmonStatusProperties[property] {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "hwmon", sensorType+"_"+property),
+			fmt.Sprintf("Hardware sensor %s status (%s).", strings.ReplaceAll(property, "_", " "), sensorType),
+			hwmonLabels, nil,
+		)
+	}
+
+	name := sensorType
+	if property != "input" {
+		name += "_" + property
+	}
+
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "hwmon", name+"_"+hwmonSensorUnits[sensorType].suffix),
+		fmt.Sprintf("Hardware monitor for %s (%s).", sensorType, property),
+		hwmonLabels, nil,
+	)
+}
+
+// collectHwmonSensors adds the sensors found in dir to sensors. Attributes
+// that cannot be read (i.e. write-only) are skipped.
+func collectHwmonSensors(dir string, sensors map[string]*hwmonSensor) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		matches := hwmonFilenameFormat.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+		sensorType, property := matches[1], matches[3]
+
+		if _, ok := hwmonSensorUnits[sensorType]; !ok {
+			continue
+		}
+
+		value, err := hwmonReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			zap.S().Debugw("skipping unreadable hwmon attribute", "path", filepath.Join(dir, entry.Name()), zap.Error(err))
+
+			continue
+		}
+
+		attr := sensorType + matches[2]
+		sensor, ok := sensors[attr]
+		if !ok {
+			sensor = &hwmonSensor{sensorType: sensorType, attr: attr, values: map[string]string{}}
+			sensors[attr] = sensor
+		}
+
+		if property == "label" {
+			sensor.label = value
+
+			continue
+		}
+		sensor.values[property] = value
+	}
+
+	return nil
+}
+
+// hwmonSensorNames returns the name of each sensor, its label if it has a
+// unique one on the chip, its sysfs attribute name otherwise.
+func hwmonSensorNames(sensors map[string]*hwmonSensor) map[string]string {
+	labelCount := map[string]int{}
+	for _, sensor := range sensors {
+		if label := cleanHwmonName(sensor.label); label != "" {
+			labelCount[label]++
+		}
+	}
+
+	names := make(map[string]string, len(sensors))
+	for attr, sensor := range sensors {
+		label := cleanHwmonName(sensor.label)
+		if label == "" || labelCount[label] > 1 {
+			names[attr] = attr
+
+			continue
+		}
+		names[attr] = label
+	}
+
+	return names
+}
+
+// hwmonChip returns a stable name for the chip in dir, based on its device
+// path, falling back to its name attribute and its hwmon directory. The
+// human-readable name attribute is returned along with it, if any.
+func hwmonChip(dir string) (string, string, error) {
+	chipName, _ := hwmonReadFile(filepath.Join(dir, "name"))
+
+	// hwmon numbering depends on the module loading order, prefer the
+	// device path which is stable.
+	if devicePath, err := filepath.EvalSymlinks(filepath.Join(dir, "device")); err == nil {
+		devPathPrefix, devName := filepath.Split(devicePath)
+		devType := filepath.Base(strings.TrimRight(devPathPrefix, "/"))
+
+		cleanDevName, cleanDevType := cleanHwmonName(devName), cleanHwmonName(devType)
+		if cleanDevType != "" && cleanDevName != "" {
+			return cleanDevType + "_" + cleanDevName, chipName, nil
+		}
+
+		if cleanDevName != "" {
+			return cleanDevName, chipName, nil
+		}
+	}
+
+	if name := cleanHwmonName(chipName); name != "" {
+		return name, chipName, nil
+	}
+
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", "", err
+	}
+
+	if name := cleanHwmonName(filepath.Base(realDir)); name != "" {
+		return name, chipName, nil
+	}
+
+	return "", "", fmt.Errorf("could not derive a chip name for %s", dir)
+}
+
+func (c *hwmonCollector) collectChip(ch chan<- prometheus.Metric, dir string) error {
+	chip, chipName, err := hwmonChip(dir)
+	if err != nil {
+		return err
+	}
+
+	sensors := map[string]*hwmonSensor{}
+	if err := collectHwmonSensors(dir, sensors); err != nil {
+		return err
+	}
+
+	// older drivers expose their attributes on the device
+	if _, err := os.Stat(filepath.Join(dir, "device")); err == nil {
+		if err := collectHwmonSensors(filepath.Join(dir, "device"), sensors); err != nil {
+			return err
+		}
+	}
+
+	if chipName != "" {
+		ch <- prometheus.MustNewConstMetric(c.chipNameDesc, prometheus.GaugeValue, 1, chip, chipName)
+	}
+
+	names := hwmonSensorNames(sensors)
+	errs := &multiError{}
+	for attr, sensor := range sensors {
+		properties := make([]string, 0, len(sensor.values))
+		for property := range sensor.values {
+			properties = append(properties, property)
+		}
+		sort.Strings(properties)
+
+		for _, property := range properties {
+			isStatus := hwmonStatusProperties[property]
+			if !isStatus && !hwmonValueProperties[property] {
+				continue
+			}
+
+			value, err := strconv.ParseFloat(sensor.values[property], 64)
+			if err != nil {
+				errs.Add(chip+"/"+attr+"_"+property, fmt.Errorf("%w: %v", ErrParse, err))
+
+				continue
+			}
+
+			if !isStatus {
+				value *= hwmonSensorUnits[sensor.sensorType].scale
+			}
+
+			ch <- prometheus.MustNewConstMetric(hwmonSensorDesc(sensor.sensorType, property), prometheus.GaugeValue, value, chip, names[attr])
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+func (c *hwmonCollector) Collect(ch chan<- prometheus.Metric) {
+	hwmonPath := sysFilePath("class/hwmon")
+	entries, err := ioutil.ReadDir(hwmonPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			zap.S().Debugw("hwmon metrics are not available for this system")
+
+			return
+		}
+
+		collectErrors(ch, c.errorsDesc, err)
+
+		return
+	}
+
+	errs := &multiError{}
+	for _, entry := range entries {
+		dir := filepath.Join(hwmonPath, entry.Name())
+
+		// hwmon entries are usually symlinks to the device
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
+		if err := c.collectChip(ch, dir); err != nil {
+			var m *multiError
+			if errors.As(err, &m) {
+				errs.errs = append(errs.errs, m.errs...)
+
+				continue
+			}
+			errs.Add(entry.Name(), err)
+		}
+	}
+
+	collectErrors(ch, c.errorsDesc, errs.ErrorOrNil())
+}
+
+func (c *hwmonCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.chipNameDesc
+
+	sensorTypes := make([]string, 0, len(hwmonSensorUnits))
+	for sensorType := range hwmonSensorUnits {
+		sensorTypes = append(sensorTypes, sensorType)
+	}
+	sort.Strings(sensorTypes)
+
+	for _, sensorType := range sensorTypes {
+		for property := range hwmonValueProperties {
+			ch <- hwmonSensorDesc(sensorType, property)
+		}
+		for property := range hwmonStatusProperties {
+			ch <- hwmonSensorDesc(sensorType, property)
+		}
+	}
+
+	ch <- c.errorsDesc
+}
@@ -0,0 +1,168 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nohwmon
+// +build !nohwmon
+
+package collector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHwmonCollector(t *testing.T) {
+	sysPathWas := sysPath
+	defer func() {
+		sysPath = sysPathWas
+	}()
+	sysPath = "fixtures/sys"
+
+	c, err := NewHwmonCollector()
+	require.NoError(t, err)
+
+	// hwmon2 sensors are read from its device, hwmon4 temp sensors share
+	// the same label and fall back to their attribute name.
+	want := `# HELP node_hwmon_fan_rpm Hardware monitor for fan (input).
+# TYPE node_hwmon_fan_rpm gauge
+node_hwmon_fan_rpm{chip="nct6779",sensor="fan2"} 1098
+node_hwmon_fan_rpm{chip="platform_applesmc_768",sensor="left_side"} 0
+node_hwmon_fan_rpm{chip="platform_applesmc_768",sensor="right_side"} 1998
+# HELP node_hwmon_in_alarm Hardware sensor alarm status (in).
+# TYPE node_hwmon_in_alarm gauge
+node_hwmon_in_alarm{chip="nct6779",sensor="in0"} 0
+node_hwmon_in_alarm{chip="nct6779",sensor="in1"} 1
+# HELP node_hwmon_in_volts Hardware monitor for in (input).
+# TYPE node_hwmon_in_volts gauge
+node_hwmon_in_volts{chip="nct6779",sensor="in0"} 0.792
+node_hwmon_in_volts{chip="nct6779",sensor="in1"} 1.024
+# HELP node_hwmon_temp_celsius Hardware monitor for temp (input).
+# TYPE node_hwmon_temp_celsius gauge
+node_hwmon_temp_celsius{chip="hwmon4",sensor="temp1"} 55
+node_hwmon_temp_celsius{chip="hwmon4",sensor="temp2"} 54
+node_hwmon_temp_celsius{chip="platform_coretemp_0",sensor="core_0"} 54
+node_hwmon_temp_celsius{chip="platform_coretemp_0",sensor="core_1"} 52
+node_hwmon_temp_celsius{chip="platform_coretemp_0",sensor="core_2"} 53
+node_hwmon_temp_celsius{chip="platform_coretemp_0",sensor="core_3"} 50
+node_hwmon_temp_celsius{chip="platform_coretemp_0",sensor="physical_id_0"} 55
+node_hwmon_temp_celsius{chip="platform_coretemp_1",sensor="core_0"} 54
+node_hwmon_temp_celsius{chip="platform_coretemp_1",sensor="core_1"} 52
+node_hwmon_temp_celsius{chip="platform_coretemp_1",sensor="core_2"} 53
+node_hwmon_temp_celsius{chip="platform_coretemp_1",sensor="core_3"} 50
+node_hwmon_temp_celsius{chip="platform_coretemp_1",sensor="physical_id_0"} 55
+# HELP node_hwmon_temp_crit_alarm Hardware sensor crit alarm status (temp).
+# TYPE node_hwmon_temp_crit_alarm gauge
+node_hwmon_temp_crit_alarm{chip="hwmon4",sensor="temp1"} 0
+node_hwmon_temp_crit_alarm{chip="hwmon4",sensor="temp2"} 0
+node_hwmon_temp_crit_alarm{chip="platform_coretemp_0",sensor="core_0"} 0
+node_hwmon_temp_crit_alarm{chip="platform_coretemp_0",sensor="core_1"} 0
+node_hwmon_temp_crit_alarm{chip="platform_coretemp_0",sensor="core_2"} 0
+node_hwmon_temp_crit_alarm{chip="platform_coretemp_0",sensor="core_3"} 0
+node_hwmon_temp_crit_alarm{chip="platform_coretemp_0",sensor="physical_id_0"} 0
+node_hwmon_temp_crit_alarm{chip="platform_coretemp_1",sensor="core_0"} 0
+node_hwmon_temp_crit_alarm{chip="platform_coretemp_1",sensor="core_1"} 0
+node_hwmon_temp_crit_alarm{chip="platform_coretemp_1",sensor="core_2"} 0
+node_hwmon_temp_crit_alarm{chip="platform_coretemp_1",sensor="core_3"} 0
+node_hwmon_temp_crit_alarm{chip="platform_coretemp_1",sensor="physical_id_0"} 0
+# HELP node_hwmon_temp_max_celsius Hardware monitor for temp (max).
+# TYPE node_hwmon_temp_max_celsius gauge
+node_hwmon_temp_max_celsius{chip="hwmon4",sensor="temp1"} 100
+node_hwmon_temp_max_celsius{chip="hwmon4",sensor="temp2"} 100
+node_hwmon_temp_max_celsius{chip="platform_coretemp_0",sensor="core_0"} 84
+node_hwmon_temp_max_celsius{chip="platform_coretemp_0",sensor="core_1"} 84
+node_hwmon_temp_max_celsius{chip="platform_coretemp_0",sensor="core_2"} 84
+node_hwmon_temp_max_celsius{chip="platform_coretemp_0",sensor="core_3"} 84
+node_hwmon_temp_max_celsius{chip="platform_coretemp_0",sensor="physical_id_0"} 84
+node_hwmon_temp_max_celsius{chip="platform_coretemp_1",sensor="core_0"} 84
+node_hwmon_temp_max_celsius{chip="platform_coretemp_1",sensor="core_1"} 84
+node_hwmon_temp_max_celsius{chip="platform_coretemp_1",sensor="core_2"} 84
+node_hwmon_temp_max_celsius{chip="platform_coretemp_1",sensor="core_3"} 84
+node_hwmon_temp_max_celsius{chip="platform_coretemp_1",sensor="physical_id_0"} 84
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want),
+		"node_hwmon_fan_rpm",
+		"node_hwmon_in_alarm",
+		"node_hwmon_in_volts",
+		"node_hwmon_temp_celsius",
+		"node_hwmon_temp_crit_alarm",
+		"node_hwmon_temp_max_celsius",
+		"node_scrape_collector_errors",
+	))
+}
+
+func TestHwmonCollector_UnreadableAttributes(t *testing.T) {
+	sysPathWas, readFileWas := sysPath, hwmonReadFile
+	defer func() {
+		sysPath, hwmonReadFile = sysPathWas, readFileWas
+	}()
+
+	sysPath = t.TempDir()
+	dir := filepath.Join(sysPath, "class", "hwmon", "hwmon0")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	for name, value := range map[string]string{
+		"name":            "acme",
+		"power1_input":    "12500000",
+		"power1_label":    "Package",
+		"power1_max":      "eperm",
+		"curr1_input":     "n/a",
+		"curr2_input":     "1500",
+		"curr2_crit":      "3000",
+		"curr2_label":     "VCore",
+		"pwm1_enable":     "1",
+		"temp1_reset":     "eperm",
+		"temp1_offset":    "0",
+		"intrusion0_beep": "0",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0o644))
+	}
+
+	// write-only attributes fail to read with EPERM
+	hwmonReadFile = func(path string) (string, error) {
+		v, err := readFileWas(path)
+		if v == "eperm" {
+			return "", fmt.Errorf("read %s: %w", path, syscall.EPERM)
+		}
+
+		return v, err
+	}
+
+	c, err := NewHwmonCollector()
+	require.NoError(t, err)
+
+	// unreadable attributes are skipped silently, curr1 is reported as a
+	// parse error
+	want := `# HELP node_hwmon_chip_names Annotation metric for human-readable chip names
+# TYPE node_hwmon_chip_names gauge
+node_hwmon_chip_names{chip="acme",chip_name="acme"} 1
+# HELP node_hwmon_curr_amps Hardware monitor for curr (input).
+# TYPE node_hwmon_curr_amps gauge
+node_hwmon_curr_amps{chip="acme",sensor="vcore"} 1.5
+# HELP node_hwmon_curr_crit_amps Hardware monitor for curr (crit).
+# TYPE node_hwmon_curr_crit_amps gauge
+node_hwmon_curr_crit_amps{chip="acme",sensor="vcore"} 3
+# HELP node_hwmon_power_watts Hardware monitor for power (input).
+# TYPE node_hwmon_power_watts gauge
+node_hwmon_power_watts{chip="acme",sensor="package"} 12.5
+# HELP node_scrape_collector_errors Number of errors encountered by a collector during the last scrape, by reason.
+# TYPE node_scrape_collector_errors gauge
+node_scrape_collector_errors{collector="hwmon",reason="parse"} 1
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
@@ -0,0 +1,297 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noinfiniband
+// +build !noinfiniband
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs/sysfs"
+	"go.uber.org/zap"
+)
+
+// infinibandHWCounters hw_counters files read for each port, preferred over
+// the MAD counters when present. Drivers without a performance management
+// agent (i.e. EFA, bnxt_re) only report traffic there.
+var infinibandHWCounters = []string{"rx_bytes", "tx_bytes", "rx_pkts", "tx_pkts"}
+
+// infinibandMetric a port counter, value returns nil when the port does not
+// report it.
+type infinibandMetric struct {
+	desc  *prometheus.Desc
+	value func(c sysfs.InfiniBandCounters, hw map[string]*uint64) *uint64
+}
+
+type infinibandCollector struct {
+	fs                sysfs.FS
+	metrics           []infinibandMetric
+	infoDesc          *prometheus.Desc
+	stateDesc         *prometheus.Desc
+	physicalStateDesc *prometheus.Desc
+	rateDesc          *prometheus.Desc
+	errorsDesc        *prometheus.Desc
+}
+
+// NewInfiniBandCollector returns a new Collector exposing InfiniBand and
+// RDMA port state and counters from /sys/class/infiniband.
+func NewInfiniBandCollector() (prometheus.Collector, error) {
+	fs, err := sysfs.NewFS(sysPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sysfs: %w", err)
+	}
+
+	portLabels := []string{"device", "port"}
+	newDesc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "infiniband", name),
+			help, portLabels, nil,
+		)
+	}
+
+	// 32-bit counters wrap within seconds on fast links, prefer the 64-bit
+	// counters_ext and hw_counters variants.
+	return &infinibandCollector{
+		fs: fs,
+		metrics: []infinibandMetric{
+			{
+				desc: newDesc("port_data_received_bytes_total", "Number of data octets received on all links."),
+				value: func(c sysfs.InfiniBandCounters, hw map[string]*uint64) *uint64 {
+					return firstUint64(hw["rx_bytes"], c.LegacyPortRcvData64, c.PortRcvData)
+				},
+			},
+			{
+				desc: newDesc("port_data_transmitted_bytes_total", "Number of data octets transmitted on all links."),
+				value: func(c sysfs.InfiniBandCounters, hw map[string]*uint64) *uint64 {
+					return firstUint64(hw["tx_bytes"], c.LegacyPortXmitData64, c.PortXmitData)
+				},
+			},
+			{
+				desc: newDesc("port_packets_received_total", "Number of packets received on all links."),
+				value: func(c sysfs.InfiniBandCounters, hw map[string]*uint64) *uint64 {
+					return firstUint64(hw["rx_pkts"], c.LegacyPortRcvPackets64, c.PortRcvPackets)
+				},
+			},
+			{
+				desc: newDesc("port_packets_transmitted_total", "Number of packets transmitted on all links."),
+				value: func(c sysfs.InfiniBandCounters, hw map[string]*uint64) *uint64 {
+					return firstUint64(hw["tx_pkts"], c.LegacyPortXmitPackets64, c.PortXmitPackets)
+				},
+			},
+			{
+				desc: newDesc("unicast_packets_received_total", "Number of unicast packets received."),
+				value: func(c sysfs.InfiniBandCounters, _ map[string]*uint64) *uint64 {
+					return firstUint64(c.LegacyPortUnicastRcvPackets, c.UnicastRcvPackets)
+				},
+			},
+			{
+				desc: newDesc("unicast_packets_transmitted_total", "Number of unicast packets transmitted."),
+				value: func(c sysfs.InfiniBandCounters, _ map[string]*uint64) *uint64 {
+					return firstUint64(c.LegacyPortUnicastXmitPackets, c.UnicastXmitPackets)
+				},
+			},
+			{
+				desc: newDesc("multicast_packets_received_total", "Number of multicast packets received."),
+				value: func(c sysfs.InfiniBandCounters, _ map[string]*uint64) *uint64 {
+					return firstUint64(c.LegacyPortMulticastRcvPackets, c.MulticastRcvPackets)
+				},
+			},
+			{
+				desc: newDesc("multicast_packets_transmitted_total", "Number of multicast packets transmitted."),
+				value: func(c sysfs.InfiniBandCounters, _ map[string]*uint64) *uint64 {
+					return firstUint64(c.LegacyPortMulticastXmitPackets, c.MulticastXmitPackets)
+				},
+			},
+			{
+				desc: newDesc("link_error_recovery_total", "Number of times the link successfully recovered from an error state."),
+				value: func(c sysfs.InfiniBandCounters, _ map[string]*uint64) *uint64 {
+					return c.LinkErrorRecovery
+				},
+			},
+			{
+				desc: newDesc("link_downed_total", "Number of times the link failed to recover from an error state and went down."),
+				value: func(c sysfs.InfiniBandCounters, _ map[string]*uint64) *uint64 {
+					return c.LinkDowned
+				},
+			},
+			{
+				desc: newDesc("symbol_error_total", "Number of minor link errors detected on one or more physical lanes."),
+				value: func(c sysfs.InfiniBandCounters, _ map[string]*uint64) *uint64 {
+					return c.SymbolError
+				},
+			},
+			{
+				desc: newDesc("port_errors_received_total", "Number of packets containing an error that were received on the port."),
+				value: func(c sysfs.InfiniBandCounters, _ map[string]*uint64) *uint64 {
+					return c.PortRcvErrors
+				},
+			},
+			{
+				desc: newDesc("port_discards_received_total", "Number of inbound packets discarded by the port because the port is down or congested."),
+				value: func(c sysfs.InfiniBandCounters, _ map[string]*uint64) *uint64 {
+					return c.PortRcvDiscards
+				},
+			},
+			{
+				desc: newDesc("port_discards_transmitted_total", "Number of outbound packets discarded by the port because the port is down or congested."),
+				value: func(c sysfs.InfiniBandCounters, _ map[string]*uint64) *uint64 {
+					return c.PortXmitDiscards
+				},
+			},
+			{
+				desc: newDesc("port_transmit_wait_total", "Number of ticks during which the port had data to transmit but no data was sent."),
+				value: func(c sysfs.InfiniBandCounters, _ map[string]*uint64) *uint64 {
+					return c.PortXmitWait
+				},
+			},
+			{
+				desc: newDesc("local_link_integrity_errors_total", "Number of times the count of local physical errors exceeded the threshold."),
+				value: func(c sysfs.InfiniBandCounters, _ map[string]*uint64) *uint64 {
+					return c.LocalLinkIntegrityErrors
+				},
+			},
+			{
+				desc: newDesc("excessive_buffer_overrun_errors_total", "Number of times consecutive flow control update periods had at least one overrun error."),
+				value: func(c sysfs.InfiniBandCounters, _ map[string]*uint64) *uint64 {
+					return c.ExcessiveBufferOverrunErrors
+				},
+			},
+		},
+		infoDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "infiniband", "info"),
+			"Non-numeric data from /sys/class/infiniband/<device>, value is always 1.",
+			[]string{"device", "board_id", "firmware_version", "hca_type"}, nil,
+		),
+		stateDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "infiniband", "state_id"),
+			"State of the InfiniBand port (0: no change, 1: down, 2: init, 3: armed, 4: active, 5: act defer).",
+			portLabels, nil,
+		),
+		physicalStateDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "infiniband", "physical_state_id"),
+			"Physical state of the InfiniBand port (0: no change, 1: sleep, 2: polling, 3: disable, 4: shift, 5: link up, 6: link error recover, 7: phytest).",
+			portLabels, nil,
+		),
+		rateDesc:   newDesc("rate_bytes_per_second", "Maximum signal transfer rate of the port in bytes per second."),
+		errorsDesc: newScrapeErrorsDesc("infiniband"),
+	}, nil
+}
+
+func (c *infinibandCollector) Collect(ch chan<- prometheus.Metric) {
+	devices, err := c.fs.InfiniBandClass()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			zap.S().Debugw("infiniband metrics are not available for this system")
+
+			return
+		}
+
+		collectErrors(ch, c.errorsDesc, err)
+
+		return
+	}
+
+	names := make([]string, 0, len(devices))
+	for name := range devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	errs := &multiError{}
+	for _, name := range names {
+		device := devices[name]
+
+		ch <- prometheus.MustNewConstMetric(c.infoDesc, prometheus.GaugeValue, 1,
+			name, device.BoardID, device.FirmwareVersion, device.HCAType)
+
+		for _, port := range device.Ports {
+			portNumber := strconv.FormatUint(uint64(port.Port), 10)
+
+			ch <- prometheus.MustNewConstMetric(c.stateDesc, prometheus.GaugeValue, float64(port.StateID), name, portNumber)
+			ch <- prometheus.MustNewConstMetric(c.physicalStateDesc, prometheus.GaugeValue, float64(port.PhysStateID), name, portNumber)
+			ch <- prometheus.MustNewConstMetric(c.rateDesc, prometheus.GaugeValue, float64(port.Rate), name, portNumber)
+
+			hw := readInfiniBandHWCounters(name, portNumber, errs)
+			for _, m := range c.metrics {
+				v := m.value(port.Counters, hw)
+				if v == nil {
+					continue
+				}
+
+				ch <- prometheus.MustNewConstMetric(m.desc, prometheus.CounterValue, float64(*v), name, portNumber)
+			}
+		}
+	}
+
+	if err := errs.ErrorOrNil(); err != nil {
+		collectErrors(ch, c.errorsDesc, err)
+	}
+}
+
+func (c *infinibandCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		ch <- m.desc
+	}
+	ch <- c.infoDesc
+	ch <- c.stateDesc
+	ch <- c.physicalStateDesc
+	ch <- c.rateDesc
+	ch <- c.errorsDesc
+}
+
+// readInfiniBandHWCounters reads the infinibandHWCounters the port's driver
+// exposes. Missing or unparsable counters are skipped, other errors are
+// added to errs.
+func readInfiniBandHWCounters(device, port string, errs *multiError) map[string]*uint64 {
+	dir := sysFilePath(filepath.Join("class", "infiniband", device, "ports", port, "hw_counters"))
+
+	hw := make(map[string]*uint64, len(infinibandHWCounters))
+	for _, name := range infinibandHWCounters {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				errs.Add(device, err)
+			}
+
+			continue
+		}
+
+		v, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil {
+			continue
+		}
+		hw[name] = &v
+	}
+
+	return hw
+}
+
+// firstUint64 returns the first non-nil value.
+func firstUint64(values ...*uint64) *uint64 {
+	for _, v := range values {
+		if v != nil {
+			return v
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noinfiniband
+// +build !noinfiniband
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfiniBandCollector(t *testing.T) {
+	sysPathWas := sysPath
+	defer func() {
+		sysPath = sysPathWas
+	}()
+	sysPath = "fixtures/sys"
+
+	c, err := NewInfiniBandCollector()
+	require.NoError(t, err)
+
+	// port 2 legacy data counters are 0, counters_ext must win
+	want := `# HELP node_infiniband_info Non-numeric data from /sys/class/infiniband/<device>, value is always 1.
+# TYPE node_infiniband_info gauge
+node_infiniband_info{board_id="I40IW Board ID",device="i40iw0",firmware_version="0.2",hca_type="I40IW"} 1
+node_infiniband_info{board_id="SM_1141000001000",device="mlx4_0",firmware_version="2.31.5050",hca_type="MT4099"} 1
+# HELP node_infiniband_link_error_recovery_total Number of times the link successfully recovered from an error state.
+# TYPE node_infiniband_link_error_recovery_total counter
+node_infiniband_link_error_recovery_total{device="mlx4_0",port="1"} 0
+node_infiniband_link_error_recovery_total{device="mlx4_0",port="2"} 0
+# HELP node_infiniband_port_data_received_bytes_total Number of data octets received on all links.
+# TYPE node_infiniband_port_data_received_bytes_total counter
+node_infiniband_port_data_received_bytes_total{device="mlx4_0",port="1"} 1.8527668e+07
+node_infiniband_port_data_received_bytes_total{device="mlx4_0",port="2"} 1.8527668e+07
+# HELP node_infiniband_port_data_transmitted_bytes_total Number of data octets transmitted on all links.
+# TYPE node_infiniband_port_data_transmitted_bytes_total counter
+node_infiniband_port_data_transmitted_bytes_total{device="mlx4_0",port="1"} 1.493376e+07
+node_infiniband_port_data_transmitted_bytes_total{device="mlx4_0",port="2"} 1.493376e+07
+# HELP node_infiniband_rate_bytes_per_second Maximum signal transfer rate of the port in bytes per second.
+# TYPE node_infiniband_rate_bytes_per_second gauge
+node_infiniband_rate_bytes_per_second{device="i40iw0",port="1"} 1.25e+09
+node_infiniband_rate_bytes_per_second{device="mlx4_0",port="1"} 5e+09
+node_infiniband_rate_bytes_per_second{device="mlx4_0",port="2"} 5e+09
+# HELP node_infiniband_state_id State of the InfiniBand port (0: no change, 1: down, 2: init, 3: armed, 4: active, 5: act defer).
+# TYPE node_infiniband_state_id gauge
+node_infiniband_state_id{device="i40iw0",port="1"} 4
+node_infiniband_state_id{device="mlx4_0",port="1"} 4
+node_infiniband_state_id{device="mlx4_0",port="2"} 4
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want),
+		"node_infiniband_info",
+		"node_infiniband_link_error_recovery_total",
+		"node_infiniband_port_data_received_bytes_total",
+		"node_infiniband_port_data_transmitted_bytes_total",
+		"node_infiniband_rate_bytes_per_second",
+		"node_infiniband_state_id",
+	))
+}
+
+func TestInfiniBandCollector_HWCounters(t *testing.T) {
+	sysPathWas := sysPath
+	defer func() {
+		sysPath = sysPathWas
+	}()
+	sysPath = t.TempDir()
+
+	files := map[string]string{
+		"board_id":                       "EFA",
+		"fw_ver":                         "0.0.0.0",
+		"ports/1/state":                  "4: ACTIVE",
+		"ports/1/phys_state":             "5: LinkUp",
+		"ports/1/rate":                   "100 Gb/sec (4X EDR)",
+		"ports/1/counters/port_rcv_data": "4294967295",
+		"ports/1/hw_counters/rx_bytes":   "68719476736",
+		"ports/1/hw_counters/tx_bytes":   "34359738368",
+		"ports/1/hw_counters/rx_pkts":    "1000",
+		"ports/1/hw_counters/tx_pkts":    "junk",
+	}
+	for name, content := range files {
+		path := filepath.Join(sysPath, "class", "infiniband", "efa_0", name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content+"\n"), 0o644))
+	}
+
+	c, err := NewInfiniBandCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_infiniband_port_data_received_bytes_total Number of data octets received on all links.
+# TYPE node_infiniband_port_data_received_bytes_total counter
+node_infiniband_port_data_received_bytes_total{device="efa_0",port="1"} 6.8719476736e+10
+# HELP node_infiniband_port_data_transmitted_bytes_total Number of data octets transmitted on all links.
+# TYPE node_infiniband_port_data_transmitted_bytes_total counter
+node_infiniband_port_data_transmitted_bytes_total{device="efa_0",port="1"} 3.4359738368e+10
+# HELP node_infiniband_port_packets_received_total Number of packets received on all links.
+# TYPE node_infiniband_port_packets_received_total counter
+node_infiniband_port_packets_received_total{device="efa_0",port="1"} 1000
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want),
+		"node_infiniband_port_data_received_bytes_total",
+		"node_infiniband_port_data_transmitted_bytes_total",
+		"node_infiniband_port_packets_received_total",
+		"node_infiniband_port_packets_transmitted_total",
+		"node_scrape_collector_errors",
+	))
+}
+
+func TestInfiniBandCollector_NoDevices(t *testing.T) {
+	sysPathWas := sysPath
+	defer func() {
+		sysPath = sysPathWas
+	}()
+	sysPath = t.TempDir()
+
+	c, err := NewInfiniBandCollector()
+	require.NoError(t, err)
+	require.Equal(t, 0, testutil.CollectAndCount(c))
+}
@@ -0,0 +1,234 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nointerrupts
+// +build !nointerrupts
+
+package collector
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// softirqsPerCPU Export softirq counters per CPU instead of summed
+	// across CPUs.
+	// collector.softirqs.per-cpu
+	softirqsPerCPU = false
+
+	// interruptsPerCPU Export interrupt counters per CPU instead of summed
+	// across CPUs. The number of series is the number of IRQs times the
+	// number of CPUs.
+	// collector.interrupts.per-cpu
+	interruptsPerCPU = false
+)
+
+// DefineInterruptsFlags defines the flags of the softirqs and interrupts collectors.
+func DefineInterruptsFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&softirqsPerCPU, "collector.softirqs.per-cpu", false,
+		"Export softirq counters per CPU instead of summed across CPUs.")
+	flags.BoolVar(&interruptsPerCPU, "collector.interrupts.per-cpu", false,
+		"Export interrupt counters per CPU instead of summed across CPUs, the number of series can be very large.")
+}
+
+// irqLine a /proc/interrupts or /proc/softirqs line.
+type irqLine struct {
+	name   string
+	counts []uint64
+	// info interrupt type, or description for non-numbered interrupts.
+	info    string
+	devices string
+}
+
+// parseIRQFile parses /proc/interrupts or /proc/softirqs content, which
+// both list a counter per CPU for each interrupt, streaming it line by line.
+func parseIRQFile(r io.Reader, fn func(irqLine)) error {
+	scanner := bufio.NewScanner(r)
+	// lines grow with the number of CPUs
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+
+		return fmt.Errorf("missing CPUs header: %w", ErrParse)
+	}
+	cpus := len(strings.Fields(scanner.Text()))
+
+	counts := make([]uint64, cpus)
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) < 2 || !strings.HasSuffix(parts[0], ":") {
+			return fmt.Errorf("invalid line %q: %w", scanner.Text(), ErrParse)
+		}
+
+		line := irqLine{name: strings.TrimSuffix(parts[0], ":")}
+
+		// some interrupts, i.e. ERR and MIS, are not reported per CPU
+		n := 0
+		for ; n < cpus && n+1 < len(parts); n++ {
+			v, err := strconv.ParseUint(parts[n+1], 10, 64)
+			if err != nil {
+				break
+			}
+			counts[n] = v
+		}
+		if n == 0 {
+			return fmt.Errorf("invalid line %q: %w", scanner.Text(), ErrParse)
+		}
+		line.counts = counts[:n]
+
+		if rest := parts[n+1:]; len(rest) > 0 {
+			if _, err := strconv.Atoi(line.name); err == nil {
+				line.info = rest[0]
+				line.devices = strings.Join(rest[1:], " ")
+			} else {
+				line.info = strings.Join(rest, " ")
+			}
+		}
+
+		fn(line)
+	}
+
+	return scanner.Err()
+}
+
+func readIRQFile(path string, fn func(irqLine)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return parseIRQFile(f, fn)
+}
+
+func sumCounts(counts []uint64) uint64 {
+	var sum uint64
+	for _, v := range counts {
+		sum += v
+	}
+
+	return sum
+}
+
+type softirqsCollector struct {
+	desc       *prometheus.Desc
+	perCPU     bool
+	errorsDesc *prometheus.Desc
+}
+
+// NewSoftirqsCollector returns a new Collector exposing /proc/softirqs
+// counters, per CPU if collector.softirqs.per-cpu is set.
+func NewSoftirqsCollector() (prometheus.Collector, error) {
+	labels := []string{"type"}
+	if softirqsPerCPU {
+		labels = []string{"cpu", "type"}
+	}
+
+	return &softirqsCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "softirqs", "functions_total"),
+			"Softirq counts by type.",
+			labels,
+			nil,
+		),
+		perCPU:     softirqsPerCPU,
+		errorsDesc: newScrapeErrorsDesc("softirqs"),
+	}, nil
+}
+
+func (c *softirqsCollector) Collect(ch chan<- prometheus.Metric) {
+	collectErrors(ch, c.errorsDesc, c.collect(ch))
+}
+
+func (c *softirqsCollector) collect(ch chan<- prometheus.Metric) error {
+	return readIRQFile(procFilePath("softirqs"), func(line irqLine) {
+		typ := strings.ToLower(line.name)
+
+		if !c.perCPU {
+			ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, float64(sumCounts(line.counts)), typ)
+
+			return
+		}
+
+		for cpu, v := range line.counts {
+			ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, float64(v), strconv.Itoa(cpu), typ)
+		}
+	})
+}
+
+func (c *softirqsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+	ch <- c.errorsDesc
+}
+
+type interruptsCollector struct {
+	desc       *prometheus.Desc
+	perCPU     bool
+	errorsDesc *prometheus.Desc
+}
+
+// NewInterruptsCollector returns a new Collector exposing /proc/interrupts
+// counters, per CPU if collector.interrupts.per-cpu is set.
+func NewInterruptsCollector() (prometheus.Collector, error) {
+	labels := []string{"type", "info", "devices"}
+	if interruptsPerCPU {
+		labels = []string{"cpu", "type", "info", "devices"}
+	}
+
+	return &interruptsCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "interrupts", "total"),
+			"Interrupt counts by IRQ.",
+			labels,
+			nil,
+		),
+		perCPU:     interruptsPerCPU,
+		errorsDesc: newScrapeErrorsDesc("interrupts"),
+	}, nil
+}
+
+func (c *interruptsCollector) Collect(ch chan<- prometheus.Metric) {
+	collectErrors(ch, c.errorsDesc, c.collect(ch))
+}
+
+func (c *interruptsCollector) collect(ch chan<- prometheus.Metric) error {
+	return readIRQFile(procFilePath("interrupts"), func(line irqLine) {
+		if !c.perCPU {
+			ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, float64(sumCounts(line.counts)),
+				line.name, line.info, line.devices)
+
+			return
+		}
+
+		for cpu, v := range line.counts {
+			ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, float64(v),
+				strconv.Itoa(cpu), line.name, line.info, line.devices)
+		}
+	})
+}
+
+func (c *interruptsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+	ch <- c.errorsDesc
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nointerrupts
+// +build !nointerrupts
+
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSoftirqsCollector(t *testing.T) {
+	procPathWas, perCPUWas := procPath, softirqsPerCPU
+	defer func() {
+		procPath, softirqsPerCPU = procPathWas, perCPUWas
+	}()
+	procPath = "fixtures/proc"
+
+	c, err := NewSoftirqsCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_softirqs_functions_total Softirq counts by type.
+# TYPE node_softirqs_functions_total counter
+node_softirqs_functions_total{type="block"} 93860
+node_softirqs_functions_total{type="hi"} 11
+node_softirqs_functions_total{type="hrtimer"} 160
+node_softirqs_functions_total{type="irq_poll"} 0
+node_softirqs_functions_total{type="net_rx"} 249971
+node_softirqs_functions_total{type="net_tx"} 9422
+node_softirqs_functions_total{type="rcu"} 580210
+node_softirqs_functions_total{type="sched"} 1.328553e+06
+node_softirqs_functions_total{type="tasklet"} 3059
+node_softirqs_functions_total{type="timer"} 884477
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+
+	softirqsPerCPU = true
+	c, err = NewSoftirqsCollector()
+	require.NoError(t, err)
+
+	require.Equal(t, 40, testutil.CollectAndCount(c, "node_softirqs_functions_total"))
+}
+
+func TestInterruptsCollector(t *testing.T) {
+	procPathWas, perCPUWas := procPath, interruptsPerCPU
+	defer func() {
+		procPath, interruptsPerCPU = procPathWas, perCPUWas
+	}()
+	procPath = "fixtures/proc"
+
+	c, err := NewInterruptsCollector()
+	require.NoError(t, err)
+	require.Equal(t, 30, testutil.CollectAndCount(c, "node_interrupts_total"))
+
+	lines := map[string]irqLine{}
+	require.NoError(t, readIRQFile("fixtures/proc/interrupts", func(line irqLine) {
+		line.counts = append([]uint64{}, line.counts...)
+		lines[line.name] = line
+	}))
+	require.Equal(t, irqLine{name: "ERR", counts: []uint64{0}}, lines["ERR"])
+	require.Equal(t, irqLine{
+		name:   "LOC",
+		counts: []uint64{174326351, 135776678, 168393257, 130980079},
+		info:   "Local timer interrupts",
+	}, lines["LOC"])
+	require.Equal(t, irqLine{
+		name:    "16",
+		counts:  []uint64{328511, 322879, 293782, 351412},
+		info:    "IR-IO-APIC-fasteoi",
+		devices: "ehci_hcd:usb1, mmc0",
+	}, lines["16"])
+
+	interruptsPerCPU = true
+	c, err = NewInterruptsCollector()
+	require.NoError(t, err)
+
+	// ERR and MIS are not reported per CPU
+	require.Equal(t, 28*4+2, testutil.CollectAndCount(c, "node_interrupts_total"))
+}
+
+func TestParseIRQFile_Malformed(t *testing.T) {
+	for _, content := range []string{
+		"",
+		"  CPU0 CPU1\n  HI: foo 1\n",
+		"  CPU0 CPU1\n  HI 1 1\n",
+	} {
+		err := parseIRQFile(strings.NewReader(content), func(irqLine) {})
+		require.ErrorIs(t, err, ErrParse, content)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noipvs
+// +build !noipvs
+
+package collector
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+	"go.uber.org/zap"
+)
+
+var (
+	// ipvsPerService Aggregate the backend metrics per virtual service,
+	// dropping the remote address and port labels. Bounds the number of
+	// series on balancers with many real servers.
+	// collector.ipvs.per-service
+	ipvsPerService = false
+)
+
+// DefineIPVSFlags defines the flags of the ipvs collector.
+func DefineIPVSFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&ipvsPerService, "collector.ipvs.per-service", false,
+		"Sum the IPVS backend connections and weights per virtual service instead of exporting a series per real server.")
+}
+
+var (
+	ipvsServiceLabels = []string{"proto", "local_address", "local_port", "local_mark"}
+	ipvsBackendLabels = append(append([]string{}, ipvsServiceLabels...), "remote_address", "remote_port")
+)
+
+type ipvsCollector struct {
+	fs         procfs.FS
+	perService bool
+
+	connections     *prometheus.Desc
+	incomingPackets *prometheus.Desc
+	outgoingPackets *prometheus.Desc
+	incomingBytes   *prometheus.Desc
+	outgoingBytes   *prometheus.Desc
+	activeConns     *prometheus.Desc
+	inactiveConns   *prometheus.Desc
+	weight          *prometheus.Desc
+	errorsDesc      *prometheus.Desc
+}
+
+// NewIPVSCollector returns a new Collector exposing the IPVS totals from
+// /proc/net/ip_vs_stats and the state of each real server from
+// /proc/net/ip_vs.
+func NewIPVSCollector() (prometheus.Collector, error) {
+	fs, err := procfs.NewFS(procPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open procfs: %w", err)
+	}
+
+	backendLabels := ipvsBackendLabels
+	if ipvsPerService {
+		backendLabels = ipvsServiceLabels
+	}
+
+	newDesc := func(name, help string, labels []string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "ipvs", name),
+			help, labels, nil,
+		)
+	}
+
+	return &ipvsCollector{
+		fs:              fs,
+		perService:      ipvsPerService,
+		connections:     newDesc("connections_total", "The total number of connections made.", nil),
+		incomingPackets: newDesc("incoming_packets_total", "The total number of incoming packets.", nil),
+		outgoingPackets: newDesc("outgoing_packets_total", "The total number of outgoing packets.", nil),
+		incomingBytes:   newDesc("incoming_bytes_total", "The total amount of incoming data.", nil),
+		outgoingBytes:   newDesc("outgoing_bytes_total", "The total amount of outgoing data.", nil),
+		activeConns:     newDesc("backend_connections_active", "The current active connections by local and remote address.", backendLabels),
+		inactiveConns:   newDesc("backend_connections_inactive", "The current inactive connections by local and remote address.", backendLabels),
+		weight:          newDesc("backend_weight", "The current backend weight by local and remote address.", backendLabels),
+		errorsDesc:      newScrapeErrorsDesc("ipvs"),
+	}, nil
+}
+
+func (c *ipvsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := c.fs.IPVSStats()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			zap.S().Debugw("ipvs metrics are not available for this system, the ip_vs module is not loaded")
+
+			return
+		}
+
+		collectErrors(ch, c.errorsDesc, fmt.Errorf("failed to read ipvs stats: %w", err))
+
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.connections, prometheus.CounterValue, float64(stats.Connections))
+	ch <- prometheus.MustNewConstMetric(c.incomingPackets, prometheus.CounterValue, float64(stats.IncomingPackets))
+	ch <- prometheus.MustNewConstMetric(c.outgoingPackets, prometheus.CounterValue, float64(stats.OutgoingPackets))
+	ch <- prometheus.MustNewConstMetric(c.incomingBytes, prometheus.CounterValue, float64(stats.IncomingBytes))
+	ch <- prometheus.MustNewConstMetric(c.outgoingBytes, prometheus.CounterValue, float64(stats.OutgoingBytes))
+
+	backends, err := c.fs.IPVSBackendStatus()
+	if err != nil {
+		collectErrors(ch, c.errorsDesc, fmt.Errorf("failed to read ipvs backend status: %w", err))
+
+		return
+	}
+
+	// backends sharing a label set are summed, i.e. all the real servers
+	// of a virtual service when aggregating per service
+	type backendKey [6]string
+	type backendSums struct {
+		active, inactive, weight uint64
+	}
+
+	var keys []backendKey
+	sums := make(map[backendKey]*backendSums)
+	for _, b := range backends {
+		key := backendKey{
+			b.Proto,
+			ipvsAddress(b.LocalAddress),
+			strconv.FormatUint(uint64(b.LocalPort), 10),
+			b.LocalMark,
+		}
+		if !c.perService {
+			key[4] = ipvsAddress(b.RemoteAddress)
+			key[5] = strconv.FormatUint(uint64(b.RemotePort), 10)
+		}
+
+		s, ok := sums[key]
+		if !ok {
+			s = &backendSums{}
+			sums[key] = s
+			keys = append(keys, key)
+		}
+		s.active += b.ActiveConn
+		s.inactive += b.InactConn
+		s.weight += b.Weight
+	}
+
+	for _, key := range keys {
+		labels := key[:len(ipvsServiceLabels)]
+		if !c.perService {
+			labels = key[:]
+		}
+		s := sums[key]
+
+		ch <- prometheus.MustNewConstMetric(c.activeConns, prometheus.GaugeValue, float64(s.active), labels...)
+		ch <- prometheus.MustNewConstMetric(c.inactiveConns, prometheus.GaugeValue, float64(s.inactive), labels...)
+		ch <- prometheus.MustNewConstMetric(c.weight, prometheus.GaugeValue, float64(s.weight), labels...)
+	}
+}
+
+func (c *ipvsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.connections
+	ch <- c.incomingPackets
+	ch <- c.outgoingPackets
+	ch <- c.incomingBytes
+	ch <- c.outgoingBytes
+	ch <- c.activeConns
+	ch <- c.inactiveConns
+	ch <- c.weight
+	ch <- c.errorsDesc
+}
+
+// ipvsAddress formats ip, firewall mark services have no local address.
+func ipvsAddress(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+
+	return ip.String()
+}
@@ -0,0 +1,228 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxMatcherLiterals caps the number of literals a pattern may expand to
+// before it is left to the regexp engine.
+const maxMatcherLiterals = 256
+
+// deviceMatcher matches device names, mount points or filesystem types
+// against a configured filter pattern.
+type deviceMatcher interface {
+	MatchString(s string) bool
+}
+
+// newDeviceMatcher compiles pattern into a deviceMatcher. Patterns that
+// expand to a finite set of literals, i.e. ^(eth|en|bond) or
+// ^(tmpfs|proc)$, are matched with map and prefix lookups. Anything else
+// falls back to regexp. Both give the same result for every input.
+func newDeviceMatcher(pattern string) (deviceMatcher, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	if m := compileLiteralMatcher(pattern); m != nil {
+		return m, nil
+	}
+
+	return re, nil
+}
+
+// mustNewDeviceMatcher is like newDeviceMatcher but panics if the pattern
+// cannot be compiled.
+func mustNewDeviceMatcher(pattern string) deviceMatcher {
+	m, err := newDeviceMatcher(pattern)
+	if err != nil {
+		panic(`collector: newDeviceMatcher(` + pattern + `): ` + err.Error())
+	}
+
+	return m
+}
+
+// literalMatcher matches a finite set of literals, each of which may be
+// anchored at the start and/or end of the input.
+type literalMatcher struct {
+	matchAll bool
+	exact    map[string]struct{}
+	prefixes []string
+	suffixes []string
+	contains []string
+}
+
+func (m *literalMatcher) MatchString(s string) bool {
+	if m.matchAll {
+		return true
+	}
+
+	if _, ok := m.exact[s]; ok {
+		return true
+	}
+
+	for _, p := range m.prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+
+	for _, p := range m.suffixes {
+		if strings.HasSuffix(s, p) {
+			return true
+		}
+	}
+
+	for _, p := range m.contains {
+		if strings.Contains(s, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matcherLiteral is a single expansion of a pattern.
+type matcherLiteral struct {
+	lit           string
+	startAnchored bool
+	endAnchored   bool
+}
+
+// compileLiteralMatcher returns nil if pattern is not a finite set of
+// literals.
+func compileLiteralMatcher(pattern string) *literalMatcher {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+
+	lits, ok := expandLiterals(re.Simplify())
+	if !ok || len(lits) == 0 {
+		return nil
+	}
+
+	m := &literalMatcher{exact: map[string]struct{}{}}
+	for _, l := range lits {
+		// regexp reads invalid UTF-8 as utf8.RuneError, byte lookups don't
+		if strings.ContainsRune(l.lit, utf8.RuneError) {
+			return nil
+		}
+
+		switch {
+		case l.startAnchored && l.endAnchored:
+			m.exact[l.lit] = struct{}{}
+		case l.lit == "":
+			m.matchAll = true
+		case l.startAnchored:
+			m.prefixes = append(m.prefixes, l.lit)
+		case l.endAnchored:
+			m.suffixes = append(m.suffixes, l.lit)
+		default:
+			m.contains = append(m.contains, l.lit)
+		}
+	}
+
+	return m
+}
+
+// expandLiterals expands re into the literals it matches. It returns false
+// if re matches an unbounded or too large set of strings.
+func expandLiterals(re *syntax.Regexp) ([]matcherLiteral, bool) {
+	switch re.Op {
+	case syntax.OpEmptyMatch:
+		return []matcherLiteral{{}}, true
+	case syntax.OpBeginText:
+		return []matcherLiteral{{startAnchored: true}}, true
+	case syntax.OpEndText:
+		return []matcherLiteral{{endAnchored: true}}, true
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return nil, false
+		}
+
+		return []matcherLiteral{{lit: string(re.Rune)}}, true
+	case syntax.OpCharClass:
+		var lits []matcherLiteral
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			if int(re.Rune[i+1]-re.Rune[i])+len(lits) >= maxMatcherLiterals {
+				return nil, false
+			}
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				lits = append(lits, matcherLiteral{lit: string(r)})
+			}
+		}
+
+		return lits, true
+	case syntax.OpCapture:
+		return expandLiterals(re.Sub[0])
+	case syntax.OpQuest:
+		lits, ok := expandLiterals(re.Sub[0])
+		if !ok {
+			return nil, false
+		}
+
+		return append(lits, matcherLiteral{}), true
+	case syntax.OpAlternate:
+		var lits []matcherLiteral
+		for _, sub := range re.Sub {
+			subLits, ok := expandLiterals(sub)
+			if !ok || len(lits)+len(subLits) > maxMatcherLiterals {
+				return nil, false
+			}
+			lits = append(lits, subLits...)
+		}
+
+		return lits, true
+	case syntax.OpConcat:
+		lits := []matcherLiteral{{}}
+		for _, sub := range re.Sub {
+			subLits, ok := expandLiterals(sub)
+			if !ok || len(lits)*len(subLits) > maxMatcherLiterals {
+				return nil, false
+			}
+			lits = concatLiterals(lits, subLits)
+		}
+
+		return lits, true
+	}
+
+	return nil, false
+}
+
+// concatLiterals returns every a+b, dropping combinations that can never
+// match such as text after $ or before ^.
+func concatLiterals(a, b []matcherLiteral) []matcherLiteral {
+	res := make([]matcherLiteral, 0, len(a)*len(b))
+	for _, x := range a {
+		for _, y := range b {
+			if x.endAnchored && y.lit != "" || y.startAnchored && x.lit != "" {
+				continue
+			}
+
+			res = append(res, matcherLiteral{
+				lit:           x.lit + y.lit,
+				startAnchored: x.startAnchored || y.startAnchored,
+				endAnchored:   x.endAnchored || y.endAnchored,
+			})
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,126 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"math/rand"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var matcherPatterns = []string{
+	"",
+	"^$",
+	"$^",
+	"^lo$",
+	"^(eth|en|bond)",
+	"^(?:eth|en|bond)[0-9]",
+	"^(lo|docker0)$",
+	"^(?:lo$|veth)",
+	"^veth|^br-|lo",
+	"(eth|wlan)0$",
+	"vlan",
+	"a$b",
+	"^eth[0-3]$",
+	"^e(th)?0",
+	"^💩",
+	"^💩0$",
+	"(?i)^eth",
+	"^eth.*",
+	"^[^e]",
+	`^/(dev|proc|run/credentials/.+|sys|var/lib/docker/.+)($|/)`,
+	"^(autofs|binfmt_misc|bpf|cgroup2?|configfs|debugfs|devpts|devtmpfs|fusectl|hugetlbfs|iso9660|mqueue|nsfs|overlay|proc|procfs|pstore|rpc_pipefs|securityfs|selinuxfs|squashfs|sysfs|tracefs)$",
+	"^(dev|proc|sys|var/lib/docker/.+)($|/)",
+}
+
+var matcherAlphabet = []string{
+	"", "e", "t", "h", "n", "0", "1", "4", "b", "o", "d", "lo", "eth", "en",
+	"bond", "veth", "br-", "vlan", "wlan", "docker", "/", "dev", "proc",
+	"sys", "cgroup", "2", "tmpfs", "fs", "$", "^", "💩", "E", "\n", "\xff",
+	"\xf0\x9f", "\xa9",
+}
+
+func TestDeviceMatcher_LiteralFastPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		literal bool
+	}{
+		{"^$", true},
+		{"^(eth|en|bond)", true},
+		{"^(lo|docker0)$", true},
+		{"^(?:lo$|veth)", true},
+		{"^eth[0-3]$", true},
+		{"^(autofs|bpf|cgroup2?|proc)$", true},
+		{"(?i)^eth", false},
+		{"^eth.*", false},
+		{"^[^e]", false},
+		{`^/(dev|proc|run/credentials/.+)($|/)`, false},
+	}
+
+	for _, tt := range tests {
+		m, err := newDeviceMatcher(tt.pattern)
+		require.NoError(t, err)
+
+		_, ok := m.(*literalMatcher)
+		require.Equal(t, tt.literal, ok, tt.pattern)
+	}
+}
+
+func TestDeviceMatcher_InvalidPattern(t *testing.T) {
+	_, err := newDeviceMatcher("^(eth")
+	require.Error(t, err)
+}
+
+// TestDeviceMatcher_Differential matches random device names against both
+// the matcher and the regexp it replaces.
+func TestDeviceMatcher_Differential(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	for _, pattern := range matcherPatterns {
+		re := regexp.MustCompile(pattern)
+		m := mustNewDeviceMatcher(pattern)
+
+		for i := 0; i < 5000; i++ {
+			var name string
+			for n := rnd.Intn(6); n > 0; n-- {
+				name += matcherAlphabet[rnd.Intn(len(matcherAlphabet))]
+			}
+
+			require.Equal(t, re.MatchString(name), m.MatchString(name), "pattern %q name %q", pattern, name)
+		}
+	}
+}
+
+func FuzzDeviceMatcher(f *testing.F) {
+	for _, name := range []string{"", "lo", "eth0", "en1", "bond0", "veth1234", "/dev/shm", "/sys", "cgroup2", "💩0"} {
+		f.Add(name)
+	}
+
+	res := make([]*regexp.Regexp, 0, len(matcherPatterns))
+	matchers := make([]deviceMatcher, 0, len(matcherPatterns))
+	for _, pattern := range matcherPatterns {
+		res = append(res, regexp.MustCompile(pattern))
+		matchers = append(matchers, mustNewDeviceMatcher(pattern))
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		for i, re := range res {
+			if re.MatchString(name) != matchers[i].MatchString(name) {
+				t.Fatalf("pattern %q name %q: regexp %v, matcher %v", re, name, re.MatchString(name), matchers[i].MatchString(name))
+			}
+		}
+	})
+}
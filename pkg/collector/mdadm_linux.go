@@ -0,0 +1,139 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nomdadm
+// +build !nomdadm
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+// mdStates states an md array is reported in, exactly one is set per array.
+var mdStates = []string{"active", "inactive", "degraded", "recovering", "resync", "check"}
+
+type mdadmCollector struct {
+	fs            procfs.FS
+	stateDesc     *prometheus.Desc
+	disksRequired *prometheus.Desc
+	disksDesc     *prometheus.Desc
+	blocksDesc    *prometheus.Desc
+	blocksSynced  *prometheus.Desc
+	errorsDesc    *prometheus.Desc
+}
+
+// NewMdadmCollector returns a new Collector exposing the state of md RAID
+// arrays from /proc/mdstat.
+func NewMdadmCollector() (prometheus.Collector, error) {
+	fs, err := procfs.NewFS(procPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open procfs: %w", err)
+	}
+
+	return &mdadmCollector{
+		fs: fs,
+		stateDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "md", "state"),
+			"Indicates the state of md-device.",
+			[]string{"device", "state"}, nil,
+		),
+		disksRequired: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "md", "disks_required"),
+			"Total number of disks of device.",
+			[]string{"device"}, nil,
+		),
+		disksDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "md", "disks"),
+			"Number of active/failed/spare disks of device.",
+			[]string{"device", "state"}, nil,
+		),
+		blocksDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "md", "blocks"),
+			"Total number of blocks on device.",
+			[]string{"device"}, nil,
+		),
+		blocksSynced: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "md", "blocks_synced"),
+			"Number of blocks synced on device.",
+			[]string{"device"}, nil,
+		),
+		errorsDesc: newScrapeErrorsDesc("mdadm"),
+	}, nil
+}
+
+// mdState returns the state an array is reported in. A running sync takes
+// precedence over the array being degraded.
+func mdState(md procfs.MDStat) string {
+	switch md.ActivityState {
+	case "inactive":
+		return "inactive"
+	case "recovering":
+		return "recovering"
+	case "resyncing":
+		return "resync"
+	case "checking":
+		return "check"
+	}
+
+	// active, possibly read-only
+	if md.DisksDown > 0 || md.DisksActive < md.DisksTotal {
+		return "degraded"
+	}
+
+	return "active"
+}
+
+func (c *mdadmCollector) Collect(ch chan<- prometheus.Metric) {
+	mdStats, err := c.fs.MDStat()
+	if errors.Is(err, fs.ErrNotExist) {
+		// no md driver loaded
+		return
+	} else if err != nil {
+		collectErrors(ch, c.errorsDesc, fmt.Errorf("failed to get mdstat: %w", err))
+
+		return
+	}
+
+	for _, md := range mdStats {
+		state := mdState(md)
+		for _, s := range mdStates {
+			v := 0.0
+			if s == state {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(c.stateDesc, prometheus.GaugeValue, v, md.Name, s)
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.disksRequired, prometheus.GaugeValue, float64(md.DisksTotal), md.Name)
+		ch <- prometheus.MustNewConstMetric(c.disksDesc, prometheus.GaugeValue, float64(md.DisksActive), md.Name, "active")
+		ch <- prometheus.MustNewConstMetric(c.disksDesc, prometheus.GaugeValue, float64(md.DisksFailed), md.Name, "failed")
+		ch <- prometheus.MustNewConstMetric(c.disksDesc, prometheus.GaugeValue, float64(md.DisksSpare), md.Name, "spare")
+		ch <- prometheus.MustNewConstMetric(c.blocksDesc, prometheus.GaugeValue, float64(md.BlocksTotal), md.Name)
+		ch <- prometheus.MustNewConstMetric(c.blocksSynced, prometheus.GaugeValue, float64(md.BlocksSynced), md.Name)
+	}
+}
+
+func (c *mdadmCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.stateDesc
+	ch <- c.disksRequired
+	ch <- c.disksDesc
+	ch <- c.blocksDesc
+	ch <- c.blocksSynced
+	ch <- c.errorsDesc
+}
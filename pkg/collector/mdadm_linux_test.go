@@ -0,0 +1,165 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nomdadm
+// +build !nomdadm
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/procfs"
+	"github.com/stretchr/testify/require"
+)
+
+const mdstatTestContent = `Personalities : [raid1] [raid6] [raid5] [raid4]
+md4 : inactive raid1 sda3[0](F) sdb3[1](S)
+      4883648 blocks [2/2] [UU]
+
+md6 : active raid1 sdb2[2](F) sdc[1](S) sda2[0]
+      195310144 blocks [2/1] [U_]
+      [=>...................]  recovery =  8.5% (16775552/195310144) finish=17.0min speed=259783K/sec
+
+md7 : active raid6 sdb1[0] sde1[3] sdd1[2] sdc1[1](F)
+      7813735424 blocks super 1.2 level 6, 512k chunk, algorithm 2 [4/3] [U_UU]
+      bitmap: 0/30 pages [0KB], 65536KB chunk
+
+md9 : active raid1 sdc2[2] sdd2[3] sdb2[1] sda2[0] sde[4](F) sdf[5](F) sdg[6](S)
+      523968 blocks super 1.2 [4/4] [UUUU]
+      resync=DELAYED
+
+unused devices: <none>
+`
+
+func TestMdadmCollector(t *testing.T) {
+	procPathWas := procPath
+	defer func() {
+		procPath = procPathWas
+	}()
+	procPath = t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(procPath, "mdstat"), []byte(mdstatTestContent), 0o644))
+
+	c, err := NewMdadmCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_md_blocks Total number of blocks on device.
+# TYPE node_md_blocks gauge
+node_md_blocks{device="md4"} 4.883648e+06
+node_md_blocks{device="md6"} 1.95310144e+08
+node_md_blocks{device="md7"} 7.813735424e+09
+node_md_blocks{device="md9"} 523968
+# HELP node_md_blocks_synced Number of blocks synced on device.
+# TYPE node_md_blocks_synced gauge
+node_md_blocks_synced{device="md4"} 4.883648e+06
+node_md_blocks_synced{device="md6"} 1.6775552e+07
+node_md_blocks_synced{device="md7"} 7.813735424e+09
+node_md_blocks_synced{device="md9"} 0
+# HELP node_md_disks Number of active/failed/spare disks of device.
+# TYPE node_md_disks gauge
+node_md_disks{device="md4",state="active"} 0
+node_md_disks{device="md4",state="failed"} 1
+node_md_disks{device="md4",state="spare"} 1
+node_md_disks{device="md6",state="active"} 1
+node_md_disks{device="md6",state="failed"} 1
+node_md_disks{device="md6",state="spare"} 1
+node_md_disks{device="md7",state="active"} 3
+node_md_disks{device="md7",state="failed"} 1
+node_md_disks{device="md7",state="spare"} 0
+node_md_disks{device="md9",state="active"} 4
+node_md_disks{device="md9",state="failed"} 2
+node_md_disks{device="md9",state="spare"} 1
+# HELP node_md_disks_required Total number of disks of device.
+# TYPE node_md_disks_required gauge
+node_md_disks_required{device="md4"} 0
+node_md_disks_required{device="md6"} 2
+node_md_disks_required{device="md7"} 4
+node_md_disks_required{device="md9"} 4
+# HELP node_md_state Indicates the state of md-device.
+# TYPE node_md_state gauge
+node_md_state{device="md4",state="active"} 0
+node_md_state{device="md4",state="check"} 0
+node_md_state{device="md4",state="degraded"} 0
+node_md_state{device="md4",state="inactive"} 1
+node_md_state{device="md4",state="recovering"} 0
+node_md_state{device="md4",state="resync"} 0
+node_md_state{device="md6",state="active"} 0
+node_md_state{device="md6",state="check"} 0
+node_md_state{device="md6",state="degraded"} 0
+node_md_state{device="md6",state="inactive"} 0
+node_md_state{device="md6",state="recovering"} 1
+node_md_state{device="md6",state="resync"} 0
+node_md_state{device="md7",state="active"} 0
+node_md_state{device="md7",state="check"} 0
+node_md_state{device="md7",state="degraded"} 1
+node_md_state{device="md7",state="inactive"} 0
+node_md_state{device="md7",state="recovering"} 0
+node_md_state{device="md7",state="resync"} 0
+node_md_state{device="md9",state="active"} 0
+node_md_state{device="md9",state="check"} 0
+node_md_state{device="md9",state="degraded"} 0
+node_md_state{device="md9",state="inactive"} 0
+node_md_state{device="md9",state="recovering"} 0
+node_md_state{device="md9",state="resync"} 1
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestMdState(t *testing.T) {
+	fs, err := procfs.NewFS("fixtures/proc")
+	require.NoError(t, err)
+
+	mdStats, err := fs.MDStat()
+	require.NoError(t, err)
+
+	got := map[string]string{}
+	for _, md := range mdStats {
+		got[md.Name] = mdState(md)
+	}
+
+	require.Equal(t, map[string]string{
+		"md0":   "active",
+		"md00":  "active",
+		"md10":  "active",
+		"md101": "active",
+		"md11":  "resync",
+		"md12":  "active",
+		"md120": "active",
+		"md126": "active",
+		"md127": "active",
+		"md201": "check",
+		"md219": "inactive",
+		"md3":   "active",
+		"md4":   "inactive",
+		"md6":   "recovering",
+		"md7":   "degraded",
+		"md8":   "resync",
+		"md9":   "resync",
+	}, got)
+}
+
+func TestMdadmCollector_NoMD(t *testing.T) {
+	procPathWas := procPath
+	defer func() {
+		procPath = procPathWas
+	}()
+	procPath = t.TempDir()
+
+	c, err := NewMdadmCollector()
+	require.NoError(t, err)
+	require.Zero(t, testutil.CollectAndCount(c))
+}
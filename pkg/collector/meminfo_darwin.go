@@ -0,0 +1,84 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//go:build !nomeminfo
+// +build !nomeminfo
+
+package collector
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// xswUsage struct xsw_usage of sys/sysctl.h.
+type xswUsage struct {
+	total     uint64
+	avail     uint64
+	used      uint64
+	pageSize  uint32
+	encrypted uint32
+}
+
+// getMemInfo reads the memory stats from sysctl, exported under the
+// /proc/meminfo names of the linux collector.
+func (c *meminfoCollector) getMemInfo() (map[string]float64, error) {
+	total, err := unix.SysctlUint64("hw.memsize")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hw.memsize: %w", err)
+	}
+
+	pageSize, err := unix.SysctlUint32("vm.pagesize")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vm.pagesize: %w", err)
+	}
+
+	pages := make(map[string]float64)
+	for _, name := range []string{
+		"vm.page_free_count",
+		"vm.page_speculative_count",
+		"vm.page_purgeable_count",
+		"vm.page_pageable_external_count",
+		"vm.page_pageable_internal_count",
+	} {
+		v, err := unix.SysctlUint32(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		pages[name] = float64(v) * float64(pageSize)
+	}
+
+	b, err := unix.SysctlRaw("vm.swapusage")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vm.swapusage: %w", err)
+	}
+	if len(b) < int(unsafe.Sizeof(xswUsage{})) {
+		return nil, fmt.Errorf("unexpected vm.swapusage length %d", len(b))
+	}
+	swap := *(*xswUsage)(unsafe.Pointer(&b[0]))
+
+	free := pages["vm.page_free_count"]
+	cached := pages["vm.page_pageable_external_count"]
+
+	// file backed, speculative and purgeable pages are reclaimed on demand
+	return map[string]float64{
+		"MemTotal_bytes":     float64(total),
+		"MemFree_bytes":      free,
+		"MemAvailable_bytes": free + pages["vm.page_speculative_count"] + pages["vm.page_purgeable_count"] + cached,
+		"Cached_bytes":       cached,
+		"AnonPages_bytes":    pages["vm.page_pageable_internal_count"],
+		"SwapTotal_bytes":    float64(swap.total),
+		"SwapFree_bytes":     float64(swap.avail),
+	}, nil
+}
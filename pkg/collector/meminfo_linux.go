@@ -53,6 +53,9 @@ func parseMemInfo(r io.Reader) (map[string]float64, error) {
 		if len(parts) == 0 {
 			continue
 		}
+		if len(parts) < 2 || !strings.HasSuffix(parts[0], ":") {
+			return nil, fmt.Errorf("invalid line in meminfo: %s", line)
+		}
 		fv, err := strconv.ParseFloat(parts[1], 64)
 		if err != nil {
 			return nil, fmt.Errorf("invalid value in meminfo: %w", err)
@@ -60,9 +63,14 @@ func parseMemInfo(r io.Reader) (map[string]float64, error) {
 		key := parts[0][:len(parts[0])-1] // remove trailing : from key
 		// Active(anon) -> Active_anon
 		key = reParens.ReplaceAllString(key, "_${1}")
+		// Unknown fields are exported as-is, make sure they are valid metric names.
+		key = SanitizeMetricName(key)
 		switch len(parts) {
-		case 2: // no unit
-		case 3: // has unit, we presume kB
+		case 2: // no unit (i.e. HugePages_Total)
+		case 3: // has unit
+			if parts[2] != "kB" {
+				return nil, fmt.Errorf("unexpected unit %q in meminfo line: %s", parts[2], line)
+			}
 			fv *= 1024
 			key = key + "_bytes"
 		default:
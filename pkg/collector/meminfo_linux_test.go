@@ -15,6 +15,7 @@ package collector
 
 import (
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -37,4 +38,46 @@ func TestMemInfo(t *testing.T) {
 	if want, got := 3787456512.0, memInfo["DirectMap2M_bytes"]; want != got {
 		t.Errorf("want memory directMap2M %f, got %f", want, got)
 	}
+
+	if want, got := 2068484096.0, memInfo["Active_anon_bytes"]; want != got {
+		t.Errorf("want memory Active_anon %f, got %f", want, got)
+	}
+
+	// Unitless fields must not be suffixed with _bytes.
+	if got, ok := memInfo["HugePages_Total"]; !ok || got != 0 {
+		t.Errorf("want HugePages_Total 0, got %f (present: %v)", got, ok)
+	}
+
+	if _, ok := memInfo["HugePages_Total_bytes"]; ok {
+		t.Error("unexpected HugePages_Total_bytes field")
+	}
+}
+
+func TestMemInfoUnknownFields(t *testing.T) {
+	in := "MemTotal:        3742148 kB\nFutureField(new):     12 kB\nFuture.Count:       7\n"
+
+	memInfo, err := parseMemInfo(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 12288.0, memInfo["FutureField_new_bytes"]; want != got {
+		t.Errorf("want FutureField_new_bytes %f, got %f", want, got)
+	}
+
+	if want, got := 7.0, memInfo["Future_Count"]; want != got {
+		t.Errorf("want Future_Count %f, got %f", want, got)
+	}
+}
+
+func TestMemInfoInvalid(t *testing.T) {
+	for _, in := range []string{
+		"MemTotal:\n",
+		"MemTotal:        3742148 MB\n",
+		"MemTotal:        foo kB\n",
+	} {
+		if _, err := parseMemInfo(strings.NewReader(in)); err == nil {
+			t.Errorf("expected error parsing %q", in)
+		}
+	}
 }
@@ -0,0 +1,156 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// maxErrorSummary number of item errors included in a multiError summary.
+	maxErrorSummary = 5
+
+	errReasonPermission = "permission"
+	errReasonNotFound   = "not_found"
+	errReasonParse      = "parse"
+	errReasonOther      = "other"
+)
+
+var (
+	// ErrParse indicates that malformed data was read while collecting.
+	ErrParse = errors.New("parse error")
+)
+
+// newScrapeErrorsDesc returns the descriptor for the per-collector error metric.
+func newScrapeErrorsDesc(collector string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_errors"),
+		"Number of errors encountered by a collector during the last scrape, by reason.",
+		[]string{"reason"},
+		prometheus.Labels{"collector": collector},
+	)
+}
+
+// itemError an error encountered while collecting a single item (i.e. a device).
+type itemError struct {
+	item string
+	err  error
+}
+
+// multiError accumulates per-item errors, so collectors iterating many
+// devices can keep collecting the remaining items after a failure.
+type multiError struct {
+	errs []itemError
+}
+
+// Add records err for the given item. Nil errors are ignored.
+func (m *multiError) Add(item string, err error) {
+	if err == nil {
+		return
+	}
+
+	m.errs = append(m.errs, itemError{item: item, err: err})
+}
+
+// Len returns the number of errors recorded.
+func (m *multiError) Len() int {
+	return len(m.errs)
+}
+
+// ErrorOrNil returns m if any error was recorded, nil otherwise.
+func (m *multiError) ErrorOrNil() error {
+	if m == nil || len(m.errs) == 0 {
+		return nil
+	}
+
+	return m
+}
+
+// Error returns a summary capped to the first maxErrorSummary errors.
+func (m *multiError) Error() string {
+	n := len(m.errs)
+	if n > maxErrorSummary {
+		n = maxErrorSummary
+	}
+
+	msgs := make([]string, 0, n)
+	for _, e := range m.errs[:n] {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", e.item, e.err))
+	}
+
+	summary := strings.Join(msgs, "; ")
+	if rest := len(m.errs) - n; rest > 0 {
+		summary = fmt.Sprintf("%s (and %d more errors)", summary, rest)
+	}
+
+	return summary
+}
+
+// reasons returns the number of recorded errors by reason.
+func (m *multiError) reasons() map[string]int {
+	reasons := make(map[string]int)
+	for _, e := range m.errs {
+		reasons[errorReason(e.err)]++
+	}
+
+	return reasons
+}
+
+// errorReason maps err to a reason label value.
+func errorReason(err error) string {
+	var numErr *strconv.NumError
+
+	switch {
+	case errors.Is(err, os.ErrPermission):
+		return errReasonPermission
+	case errors.Is(err, os.ErrNotExist):
+		return errReasonNotFound
+	case errors.Is(err, ErrParse), errors.As(err, &numErr):
+		return errReasonParse
+	default:
+		return errReasonOther
+	}
+}
+
+// collectErrors exports the errors encountered by a collector during the
+// last scrape. If err is not a multiError, it is accounted as a single error.
+func collectErrors(ch chan<- prometheus.Metric, desc *prometheus.Desc, err error) {
+	if err == nil {
+		return
+	}
+
+	var m *multiError
+	if !errors.As(err, &m) {
+		m = &multiError{}
+		m.Add("", err)
+	}
+
+	reasons := m.reasons()
+	keys := make([]string, 0, len(reasons))
+	for reason := range reasons {
+		keys = append(keys, reason)
+	}
+	sort.Strings(keys)
+
+	for _, reason := range keys {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(reasons[reason]), reason)
+	}
+}
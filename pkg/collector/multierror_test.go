@@ -0,0 +1,114 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+type errorsCollector struct {
+	desc *prometheus.Desc
+	err  error
+}
+
+func (c errorsCollector) Collect(ch chan<- prometheus.Metric) {
+	collectErrors(ch, c.desc, c.err)
+}
+
+func (c errorsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func TestMultiErrorSummary(t *testing.T) {
+	errs := &multiError{}
+	require.Nil(t, errs.ErrorOrNil())
+
+	errs.Add("ignored", nil)
+	require.Equal(t, 0, errs.Len())
+
+	for i := 0; i < 8; i++ {
+		errs.Add(fmt.Sprintf("dev%d", i), errors.New("failed"))
+	}
+	require.Equal(t, 8, errs.Len())
+	require.Error(t, errs.ErrorOrNil())
+
+	summary := errs.Error()
+	require.True(t, strings.HasPrefix(summary, "dev0: failed; dev1: failed"))
+	require.Contains(t, summary, "dev4: failed")
+	require.NotContains(t, summary, "dev5")
+	require.True(t, strings.HasSuffix(summary, "(and 3 more errors)"))
+}
+
+func TestErrorReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "permission", err: fs.ErrPermission, want: errReasonPermission},
+		{name: "wrapped permission", err: fmt.Errorf("reading carrier: %w", fs.ErrPermission), want: errReasonPermission},
+		{name: "permission path error", err: &os.PathError{Op: "open", Path: "/sys/class/net/eth0", Err: syscall.EACCES}, want: errReasonPermission},
+		{name: "wrapped permission path error", err: fmt.Errorf("eth0: %w", &fs.PathError{Op: "open", Path: "/sys/class/net/eth0/carrier", Err: syscall.EPERM}), want: errReasonPermission},
+		{name: "not found", err: fs.ErrNotExist, want: errReasonNotFound},
+		{name: "wrapped not found", err: fmt.Errorf("wrapped: %w", os.ErrNotExist), want: errReasonNotFound},
+		{name: "errno not found", err: syscall.ENOENT, want: errReasonNotFound},
+		{name: "parse", err: ErrParse, want: errReasonParse},
+		{name: "wrapped parse", err: fmt.Errorf("bad line: %w", ErrParse), want: errReasonParse},
+		{name: "num error", err: &strconv.NumError{Func: "ParseInt", Num: "x", Err: strconv.ErrSyntax}, want: errReasonParse},
+		{name: "wrapped num error", err: fmt.Errorf("eth0 mtu: %w", &strconv.NumError{Func: "ParseUint", Num: "-1", Err: strconv.ErrSyntax}), want: errReasonParse},
+		{name: "other", err: errors.New("boom"), want: errReasonOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, errorReason(tt.err), tt.err.Error())
+		})
+	}
+}
+
+func TestCollectErrors(t *testing.T) {
+	errs := &multiError{}
+	errs.Add("eth0", &os.PathError{Op: "open", Path: "eth0", Err: syscall.EACCES})
+	errs.Add("eth1", &os.PathError{Op: "open", Path: "eth1", Err: syscall.EPERM})
+	errs.Add("eth2", &os.PathError{Op: "open", Path: "eth2", Err: syscall.ENOENT})
+	errs.Add("eth3", fmt.Errorf("invalid value: %w", ErrParse))
+
+	c := errorsCollector{desc: newScrapeErrorsDesc("test"), err: errs}
+	want := `# HELP node_scrape_collector_errors Number of errors encountered by a collector during the last scrape, by reason.
+# TYPE node_scrape_collector_errors gauge
+node_scrape_collector_errors{collector="test",reason="not_found"} 1
+node_scrape_collector_errors{collector="test",reason="parse"} 1
+node_scrape_collector_errors{collector="test",reason="permission"} 2
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+
+	// plain errors are accounted as a single error
+	c.err = errors.New("boom")
+	want = `# HELP node_scrape_collector_errors Number of errors encountered by a collector during the last scrape, by reason.
+# TYPE node_scrape_collector_errors gauge
+node_scrape_collector_errors{collector="test",reason="other"} 1
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
@@ -17,13 +17,14 @@
 package collector
 
 import (
-	"errors"
+	"flag"
 	"fmt"
-	"os"
-	"regexp"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/procfs/sysfs"
+	"go.uber.org/zap"
 )
 
 var (
@@ -35,218 +36,362 @@ var (
 	// the default behavior in 2.x.
 	// collector.netclass.ignore-invalid-speed
 	netclassInvalidSpeed = false
+
+	// netclassFlagUnknownSpeed Export devices where the speed is unknown as
+	// speed_unknown instead of a negative speed_bytes.
+	// collector.netclass.flag-unknown-speed
+	netclassFlagUnknownSpeed = false
+
+	// netclassCacheTTL How long the parsed sysfs net class tree is reused by
+	// adjacent scrapes, 0 disables the cache.
+	// collector.netclass.cache-ttl
+	netclassCacheTTL time.Duration
+
+	// netclassNetlink Gather the interface attributes with a single rtnetlink
+	// dump instead of walking sysfs, falls back to sysfs if the netlink
+	// socket cannot be opened.
+	// collector.netclass.netlink
+	netclassNetlink = false
+)
+
+// DefineNetClassFlags defines the flags of the netclass collector.
+func DefineNetClassFlags(flags *flag.FlagSet) {
+	flags.DurationVar(&netclassCacheTTL, "collector.netclass.cache-ttl", 0,
+		"How long the sysfs net class tree read by a scrape is reused by the following ones (i.e. 1s), 0 disables the cache. Helps hosts with thousands of interfaces.")
+	flags.BoolVar(&netclassNetlink, "collector.netclass.netlink", false,
+		"Gather network class info through rtnetlink, only reading from sysfs the attributes netlink does not provide.")
+	flags.BoolVar(&netclassInvalidSpeed, "collector.netclass.ignore-invalid-speed", false,
+		"Omit speed_bytes for devices where the speed is unknown, instead of exporting it as -125000.")
+	flags.BoolVar(&netclassFlagUnknownSpeed, "collector.netclass.flag-unknown-speed", false,
+		"Export node_network_speed_unknown for devices where the speed is unknown, omitting their speed_bytes. Takes precedence over -collector.netclass.ignore-invalid-speed.")
+}
+
+// unknownSpeedMode how the speed of devices reporting -1 (unknown) is exported.
+type unknownSpeedMode int
+
+const (
+	// unknownSpeedNegative exports speed_bytes as -125000, the historical
+	// behavior.
+	unknownSpeedNegative unknownSpeedMode = iota
+	// unknownSpeedDrop omits speed_bytes.
+	unknownSpeedDrop
+	// unknownSpeedFlag omits speed_bytes and exports speed_unknown instead.
+	unknownSpeedFlag
 )
 
+// netclassUnknownSpeedMode returns the unknown speed mode selected by the flags.
+func netclassUnknownSpeedMode() unknownSpeedMode {
+	switch {
+	case netclassFlagUnknownSpeed:
+		return unknownSpeedFlag
+	case netclassInvalidSpeed:
+		return unknownSpeedDrop
+	default:
+		return unknownSpeedNegative
+	}
+}
+
+// netClassFields metrics exported from the /sys/class/net/<iface> files,
+// in addition to up and info.
+var netClassFields = []string{
+	"address_assign_type",
+	"carrier",
+	"carrier_changes_total",
+	"carrier_up_changes_total",
+	"carrier_down_changes_total",
+	"device_id",
+	"dormant",
+	"flags",
+	"iface_id",
+	"iface_link",
+	"iface_link_mode",
+	"mtu_bytes",
+	"name_assign_type",
+	"net_dev_group",
+	"speed_bytes",
+	"transmit_queue_length",
+	"protocol_type",
+}
+
 type netClassCollector struct {
 	fs                    sysfs.FS
 	subsystem             string
-	ignoredDevicesPattern *regexp.Regexp
-	metricDescs           map[string]*prometheus.Desc
+	ignoredDevicesPattern deviceMatcher
+	netlink               bool
+	unknownSpeed          unknownSpeedMode
+	// metricDescs built once by the constructor, read-only afterwards so
+	// concurrent Collects share it without locking.
+	metricDescs map[string]*prometheus.Desc
+	errorsDesc  *prometheus.Desc
+
+	// cacheMu guards the cached net class, held while reading sysfs so
+	// concurrent scrapes wait for and reuse a single read.
+	cacheMu     sync.Mutex
+	cacheTTL    time.Duration
+	cached      sysfs.NetClass
+	cachedErr   error
+	cachedUntil time.Time
+}
+
+// netClassOption configures a netClassCollector.
+type netClassOption func(*netClassCollector)
+
+// withNetlink selects reading the interfaces through rtnetlink instead of
+// sysfs. Metric names and labels are the same in both modes.
+func withNetlink(enabled bool) netClassOption {
+	return func(c *netClassCollector) {
+		c.netlink = enabled
+	}
+}
+
+// withUnknownSpeed selects how the speed of devices reporting an unknown
+// speed is exported.
+func withUnknownSpeed(mode unknownSpeedMode) netClassOption {
+	return func(c *netClassCollector) {
+		c.unknownSpeed = mode
+	}
 }
 
 // NewNetClassCollector returns a new Collector exposing network class stats.
 func NewNetClassCollector() (prometheus.Collector, error) {
+	c, err := newNetClassCollector(withNetlink(netclassNetlink), withUnknownSpeed(netclassUnknownSpeedMode()))
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func newNetClassCollector(opts ...netClassOption) (*netClassCollector, error) {
 	fs, err := sysfs.NewFS(sysPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open sysfs: %w", err)
 	}
-	pattern := regexp.MustCompile(netclassIgnoredDevices)
-	return &netClassCollector{
+	pattern := mustNewDeviceMatcher(netclassIgnoredDevices)
+	c := &netClassCollector{
 		fs:                    fs,
 		subsystem:             "network",
 		ignoredDevicesPattern: pattern,
-		metricDescs:           map[string]*prometheus.Desc{},
-	}, nil
+		metricDescs:           newNetClassDescs("network"),
+		errorsDesc:            newScrapeErrorsDesc("netclass"),
+		cacheTTL:              netclassCacheTTL,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+// newNetClassDescs returns the descriptors of the netclass metrics. They are
+// static, Describe does not depend on the devices present.
+func newNetClassDescs(subsystem string) map[string]*prometheus.Desc {
+	descs := map[string]*prometheus.Desc{
+		"up": prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "up"),
+			"Value is 1 if operstate is 'up', 0 otherwise.",
+			[]string{"device"},
+			nil,
+		),
+		"info": prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "info"),
+			"Non-numeric data from /sys/class/net/<iface>, value is always 1.",
+			[]string{"device", "address", "broadcast", "duplex", "operstate", "ifalias"},
+			nil,
+		),
+		"speed_unknown": prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "speed_unknown"),
+			"Value is 1 if the speed of /sys/class/net/<iface> is unknown.",
+			[]string{"device"},
+			nil,
+		),
+	}
+	for _, name := range netClassFields {
+		descs[name] = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, name),
+			fmt.Sprintf("%s value of /sys/class/net/<iface>.", name),
+			[]string{"device"},
+			nil,
+		)
+	}
+
+	return descs
 }
 
 func (c *netClassCollector) Collect(ch chan<- prometheus.Metric) {
 	netClass, err := c.getNetClassInfo()
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
-
-			return
-		}
-		err = fmt.Errorf("could not get net class info: %w", err)
-
-		return
+		zap.S().Debugw("could not get net class info", zap.Error(err))
+		collectErrors(ch, c.errorsDesc, err)
 	}
+	c.collectIfaces(ch, netClass)
+}
+
+func (c *netClassCollector) collectIfaces(ch chan<- prometheus.Metric, netClass sysfs.NetClass) {
 	for _, ifaceInfo := range netClass {
-		upDesc := prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, c.subsystem, "up"),
-			"Value is 1 if operstate is 'up', 0 otherwise.",
-			[]string{"device"},
-			nil,
-		)
 		upValue := 0.0
 		if ifaceInfo.OperState == "up" {
 			upValue = 1.0
 		}
 
-		ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, upValue, ifaceInfo.Name)
+		ch <- prometheus.MustNewConstMetric(c.metricDescs["up"], prometheus.GaugeValue, upValue, ifaceInfo.Name)
 
-		infoDesc := prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, c.subsystem, "info"),
-			"Non-numeric data from /sys/class/net/<iface>, value is always 1.",
-			[]string{"device", "address", "broadcast", "duplex", "operstate", "ifalias"},
-			nil,
-		)
 		infoValue := 1.0
 
-		ch <- prometheus.MustNewConstMetric(infoDesc, prometheus.GaugeValue, infoValue, ifaceInfo.Name, ifaceInfo.Address, ifaceInfo.Broadcast, ifaceInfo.Duplex, ifaceInfo.OperState, ifaceInfo.IfAlias)
+		ch <- prometheus.MustNewConstMetric(c.metricDescs["info"], prometheus.GaugeValue, infoValue, ifaceInfo.Name, ifaceInfo.Address, ifaceInfo.Broadcast, ifaceInfo.Duplex, ifaceInfo.OperState, ifaceInfo.IfAlias)
 
 		if ifaceInfo.AddrAssignType != nil {
-			pushMetric(ch, c.subsystem, "address_assign_type", *ifaceInfo.AddrAssignType, ifaceInfo.Name, prometheus.GaugeValue)
+			pushMetric(ch, c.metricDescs["address_assign_type"], *ifaceInfo.AddrAssignType, ifaceInfo.Name, prometheus.GaugeValue)
 		}
 
 		if ifaceInfo.Carrier != nil {
-			pushMetric(ch, c.subsystem, "carrier", *ifaceInfo.Carrier, ifaceInfo.Name, prometheus.GaugeValue)
+			pushMetric(ch, c.metricDescs["carrier"], *ifaceInfo.Carrier, ifaceInfo.Name, prometheus.GaugeValue)
 		}
 
 		if ifaceInfo.CarrierChanges != nil {
-			pushMetric(ch, c.subsystem, "carrier_changes_total", *ifaceInfo.CarrierChanges, ifaceInfo.Name, prometheus.CounterValue)
+			pushMetric(ch, c.metricDescs["carrier_changes_total"], *ifaceInfo.CarrierChanges, ifaceInfo.Name, prometheus.CounterValue)
 		}
 
 		if ifaceInfo.CarrierUpCount != nil {
-			pushMetric(ch, c.subsystem, "carrier_up_changes_total", *ifaceInfo.CarrierUpCount, ifaceInfo.Name, prometheus.CounterValue)
+			pushMetric(ch, c.metricDescs["carrier_up_changes_total"], *ifaceInfo.CarrierUpCount, ifaceInfo.Name, prometheus.CounterValue)
 		}
 
 		if ifaceInfo.CarrierDownCount != nil {
-			pushMetric(ch, c.subsystem, "carrier_down_changes_total", *ifaceInfo.CarrierDownCount, ifaceInfo.Name, prometheus.CounterValue)
+			pushMetric(ch, c.metricDescs["carrier_down_changes_total"], *ifaceInfo.CarrierDownCount, ifaceInfo.Name, prometheus.CounterValue)
 		}
 
 		if ifaceInfo.DevID != nil {
-			pushMetric(ch, c.subsystem, "device_id", *ifaceInfo.DevID, ifaceInfo.Name, prometheus.GaugeValue)
+			pushMetric(ch, c.metricDescs["device_id"], *ifaceInfo.DevID, ifaceInfo.Name, prometheus.GaugeValue)
 		}
 
 		if ifaceInfo.Dormant != nil {
-			pushMetric(ch, c.subsystem, "dormant", *ifaceInfo.Dormant, ifaceInfo.Name, prometheus.GaugeValue)
+			pushMetric(ch, c.metricDescs["dormant"], *ifaceInfo.Dormant, ifaceInfo.Name, prometheus.GaugeValue)
 		}
 
 		if ifaceInfo.Flags != nil {
-			pushMetric(ch, c.subsystem, "flags", *ifaceInfo.Flags, ifaceInfo.Name, prometheus.GaugeValue)
+			pushMetric(ch, c.metricDescs["flags"], *ifaceInfo.Flags, ifaceInfo.Name, prometheus.GaugeValue)
 		}
 
 		if ifaceInfo.IfIndex != nil {
-			pushMetric(ch, c.subsystem, "iface_id", *ifaceInfo.IfIndex, ifaceInfo.Name, prometheus.GaugeValue)
+			pushMetric(ch, c.metricDescs["iface_id"], *ifaceInfo.IfIndex, ifaceInfo.Name, prometheus.GaugeValue)
 		}
 
 		if ifaceInfo.IfLink != nil {
-			pushMetric(ch, c.subsystem, "iface_link", *ifaceInfo.IfLink, ifaceInfo.Name, prometheus.GaugeValue)
+			pushMetric(ch, c.metricDescs["iface_link"], *ifaceInfo.IfLink, ifaceInfo.Name, prometheus.GaugeValue)
 		}
 
 		if ifaceInfo.LinkMode != nil {
-			pushMetric(ch, c.subsystem, "iface_link_mode", *ifaceInfo.LinkMode, ifaceInfo.Name, prometheus.GaugeValue)
+			pushMetric(ch, c.metricDescs["iface_link_mode"], *ifaceInfo.LinkMode, ifaceInfo.Name, prometheus.GaugeValue)
 		}
 
 		if ifaceInfo.MTU != nil {
-			pushMetric(ch, c.subsystem, "mtu_bytes", *ifaceInfo.MTU, ifaceInfo.Name, prometheus.GaugeValue)
+			pushMetric(ch, c.metricDescs["mtu_bytes"], *ifaceInfo.MTU, ifaceInfo.Name, prometheus.GaugeValue)
 		}
 
 		if ifaceInfo.NameAssignType != nil {
-			pushMetric(ch, c.subsystem, "name_assign_type", *ifaceInfo.NameAssignType, ifaceInfo.Name, prometheus.GaugeValue)
+			pushMetric(ch, c.metricDescs["name_assign_type"], *ifaceInfo.NameAssignType, ifaceInfo.Name, prometheus.GaugeValue)
 		}
 
 		if ifaceInfo.NetDevGroup != nil {
-			pushMetric(ch, c.subsystem, "net_dev_group", *ifaceInfo.NetDevGroup, ifaceInfo.Name, prometheus.GaugeValue)
+			pushMetric(ch, c.metricDescs["net_dev_group"], *ifaceInfo.NetDevGroup, ifaceInfo.Name, prometheus.GaugeValue)
 		}
 
 		if ifaceInfo.Speed != nil {
-			// Some devices return -1 if the speed is unknown.
-			if *ifaceInfo.Speed >= 0 || !netclassInvalidSpeed {
-				speedBytes := int64(*ifaceInfo.Speed * 1000 * 1000 / 8)
-				pushMetric(ch, c.subsystem, "speed_bytes", speedBytes, ifaceInfo.Name, prometheus.GaugeValue)
-			}
+			c.collectSpeed(ch, ifaceInfo.Name, *ifaceInfo.Speed)
 		}
 
 		if ifaceInfo.TxQueueLen != nil {
-			pushMetric(ch, c.subsystem, "transmit_queue_length", *ifaceInfo.TxQueueLen, ifaceInfo.Name, prometheus.GaugeValue)
+			pushMetric(ch, c.metricDescs["transmit_queue_length"], *ifaceInfo.TxQueueLen, ifaceInfo.Name, prometheus.GaugeValue)
 		}
 
 		if ifaceInfo.Type != nil {
-			pushMetric(ch, c.subsystem, "protocol_type", *ifaceInfo.Type, ifaceInfo.Name, prometheus.GaugeValue)
+			pushMetric(ch, c.metricDescs["protocol_type"], *ifaceInfo.Type, ifaceInfo.Name, prometheus.GaugeValue)
 		}
 	}
-
-	return
 }
 
-func pushDesc(ch chan<- *prometheus.Desc, subsystem string, name string) {
-	fieldDesc := prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, subsystem, name),
-		fmt.Sprintf("%s value of /sys/class/net/<iface>.", name),
-		[]string{"device"},
-		nil,
-	)
+// collectSpeed exports the speed of a device, some devices return -1 if the
+// speed is unknown.
+func (c *netClassCollector) collectSpeed(ch chan<- prometheus.Metric, ifaceName string, speed int64) {
+	if speed < 0 {
+		switch c.unknownSpeed {
+		case unknownSpeedDrop:
+			return
+		case unknownSpeedFlag:
+			pushMetric(ch, c.metricDescs["speed_unknown"], 1, ifaceName, prometheus.GaugeValue)
 
-	ch <- fieldDesc
-}
+			return
+		}
+	}
 
-func pushMetric(ch chan<- prometheus.Metric, subsystem string, name string, value int64, ifaceName string, valueType prometheus.ValueType) {
-	fieldDesc := prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, subsystem, name),
-		fmt.Sprintf("%s value of /sys/class/net/<iface>.", name),
-		[]string{"device"},
-		nil,
-	)
+	pushMetric(ch, c.metricDescs["speed_bytes"], speed*1000*1000/8, ifaceName, prometheus.GaugeValue)
+}
 
-	ch <- prometheus.MustNewConstMetric(fieldDesc, valueType, float64(value), ifaceName)
+func pushMetric(ch chan<- prometheus.Metric, desc *prometheus.Desc, value int64, ifaceName string, valueType prometheus.ValueType) {
+	ch <- prometheus.MustNewConstMetric(desc, valueType, float64(value), ifaceName)
 }
 
+// getNetClassInfo returns the class info of all non-ignored devices, read
+// at most once per cache TTL. The returned NetClass must not be modified.
 func (c *netClassCollector) getNetClassInfo() (sysfs.NetClass, error) {
+	if c.cacheTTL <= 0 {
+		return c.readNetClassInfo()
+	}
+
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+
+	if time.Now().Before(c.cachedUntil) {
+		return c.cached, c.cachedErr
+	}
+
+	c.cached, c.cachedErr = c.readNetClassInfo()
+	c.cachedUntil = time.Now().Add(c.cacheTTL)
+
+	return c.cached, c.cachedErr
+}
+
+// readNetClassInfo reads the class info of all non-ignored devices. Devices
+// that could not be read are skipped and reported in the returned multiError.
+func (c *netClassCollector) readNetClassInfo() (sysfs.NetClass, error) {
+	if c.netlink {
+		netClass, err := c.readNetClassNetlink()
+		if netClass != nil {
+			return netClass, err
+		}
+
+		zap.S().Debugw("could not get net class info through netlink, falling back to sysfs", zap.Error(err))
+	}
+
 	netClass := sysfs.NetClass{}
 	netDevices, err := c.fs.NetClassDevices()
 	if err != nil {
 		return netClass, err
 	}
 
+	errs := &multiError{}
 	for _, device := range netDevices {
 		if c.ignoredDevicesPattern.MatchString(device) {
 			continue
 		}
 		interfaceClass, err := c.fs.NetClassByIface(device)
 		if err != nil {
-			return netClass, err
+			errs.Add(device, err)
+			continue
 		}
 		netClass[device] = *interfaceClass
 	}
 
-	return netClass, nil
+	return netClass, errs.ErrorOrNil()
 }
 
 func (c *netClassCollector) Describe(ch chan<- *prometheus.Desc) {
-	upDesc := prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, c.subsystem, "up"),
-		"Value is 1 if operstate is 'up', 0 otherwise.",
-		[]string{"device"},
-		nil,
-	)
-
-	ch <- upDesc
-
-	infoDesc := prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, c.subsystem, "info"),
-		"Non-numeric data from /sys/class/net/<iface>, value is always 1.",
-		[]string{"device", "address", "broadcast", "duplex", "operstate", "ifalias"},
-		nil,
-	)
-
-	ch <- infoDesc
-
-	pushDesc(ch, c.subsystem, "address_assign_type")
-	pushDesc(ch, c.subsystem, "carrier")
-	pushDesc(ch, c.subsystem, "carrier_changes_total")
-	pushDesc(ch, c.subsystem, "carrier_up_changes_total")
-	pushDesc(ch, c.subsystem, "carrier_down_changes_total")
-	pushDesc(ch, c.subsystem, "device_id")
-	pushDesc(ch, c.subsystem, "dormant")
-	pushDesc(ch, c.subsystem, "flags")
-	pushDesc(ch, c.subsystem, "iface_id")
-	pushDesc(ch, c.subsystem, "iface_link")
-	pushDesc(ch, c.subsystem, "iface_link_mode")
-	pushDesc(ch, c.subsystem, "mtu_bytes")
-	pushDesc(ch, c.subsystem, "name_assign_type")
-	pushDesc(ch, c.subsystem, "net_dev_group")
-	pushDesc(ch, c.subsystem, "speed_bytes")
-	pushDesc(ch, c.subsystem, "transmit_queue_length")
-	pushDesc(ch, c.subsystem, "protocol_type")
+	ch <- c.metricDescs["up"]
+	ch <- c.metricDescs["info"]
+	ch <- c.metricDescs["speed_unknown"]
+	for _, name := range netClassFields {
+		ch <- c.metricDescs[name]
+	}
+
+	ch <- c.errorsDesc
 }
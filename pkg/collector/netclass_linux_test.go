@@ -0,0 +1,641 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nonetclass && linux
+// +build !nonetclass,linux
+
+package collector
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/procfs/sysfs"
+	"golang.org/x/sys/unix"
+)
+
+func TestNetClassCollectorErrors(t *testing.T) {
+	sysPathWas := sysPath
+	defer func() {
+		sysPath = sysPathWas
+	}()
+
+	sysPath = t.TempDir()
+	netPath := filepath.Join(sysPath, "class", "net")
+	if err := os.MkdirAll(filepath.Join(netPath, "eth0"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(netPath, "eth0", "mtu"), []byte("1500\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// a device vanishing between listing and reading it
+	if err := os.Symlink(filepath.Join(sysPath, "devices", "gone"), filepath.Join(netPath, "eth1")); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewNetClassCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP node_network_mtu_bytes mtu_bytes value of /sys/class/net/<iface>.
+# TYPE node_network_mtu_bytes gauge
+node_network_mtu_bytes{device="eth0"} 1500
+# HELP node_scrape_collector_errors Number of errors encountered by a collector during the last scrape, by reason.
+# TYPE node_scrape_collector_errors gauge
+node_scrape_collector_errors{collector="netclass",reason="not_found"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "node_network_mtu_bytes", "node_scrape_collector_errors"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNetClassCollectorDescribe(t *testing.T) {
+	sysPathWas := sysPath
+	defer func() {
+		sysPath = sysPathWas
+	}()
+
+	sysPath = t.TempDir()
+	netPath := filepath.Join(sysPath, "class", "net", "eth0")
+	if err := os.MkdirAll(netPath, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(netPath, "mtu"), []byte("1500\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewNetClassCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Describe must not read sysfs, any access would now fail
+	if err := os.RemoveAll(sysPath); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan *prometheus.Desc, 32)
+	c.Describe(ch)
+	close(ch)
+	if got, want := len(ch), len(netClassFields)+4; got != want {
+		t.Fatalf("got %d descriptors, want %d", got, want)
+	}
+
+	if err := prometheus.NewPedanticRegistry().Register(c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNetClassCollectorFixtures(t *testing.T) {
+	sysPathWas := sysPath
+	defer func() {
+		sysPath = sysPathWas
+	}()
+	sysPath = "fixtures/sys"
+
+	c, err := NewNetClassCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP node_network_address_assign_type address_assign_type value of /sys/class/net/<iface>.
+# TYPE node_network_address_assign_type gauge
+node_network_address_assign_type{device="bond0"} 3
+node_network_address_assign_type{device="dmz"} 3
+node_network_address_assign_type{device="eth0"} 3
+node_network_address_assign_type{device="int"} 3
+# HELP node_network_carrier carrier value of /sys/class/net/<iface>.
+# TYPE node_network_carrier gauge
+node_network_carrier{device="bond0"} 1
+node_network_carrier{device="dmz"} 1
+node_network_carrier{device="eth0"} 1
+node_network_carrier{device="int"} 1
+# HELP node_network_carrier_changes_total carrier_changes_total value of /sys/class/net/<iface>.
+# TYPE node_network_carrier_changes_total counter
+node_network_carrier_changes_total{device="bond0"} 2
+node_network_carrier_changes_total{device="dmz"} 2
+node_network_carrier_changes_total{device="eth0"} 2
+node_network_carrier_changes_total{device="int"} 2
+# HELP node_network_carrier_down_changes_total carrier_down_changes_total value of /sys/class/net/<iface>.
+# TYPE node_network_carrier_down_changes_total counter
+node_network_carrier_down_changes_total{device="bond0"} 1
+node_network_carrier_down_changes_total{device="dmz"} 1
+node_network_carrier_down_changes_total{device="eth0"} 1
+node_network_carrier_down_changes_total{device="int"} 1
+# HELP node_network_carrier_up_changes_total carrier_up_changes_total value of /sys/class/net/<iface>.
+# TYPE node_network_carrier_up_changes_total counter
+node_network_carrier_up_changes_total{device="bond0"} 1
+node_network_carrier_up_changes_total{device="dmz"} 1
+node_network_carrier_up_changes_total{device="eth0"} 1
+node_network_carrier_up_changes_total{device="int"} 1
+# HELP node_network_device_id device_id value of /sys/class/net/<iface>.
+# TYPE node_network_device_id gauge
+node_network_device_id{device="bond0"} 32
+node_network_device_id{device="dmz"} 32
+node_network_device_id{device="eth0"} 32
+node_network_device_id{device="int"} 32
+# HELP node_network_dormant dormant value of /sys/class/net/<iface>.
+# TYPE node_network_dormant gauge
+node_network_dormant{device="bond0"} 1
+node_network_dormant{device="dmz"} 1
+node_network_dormant{device="eth0"} 1
+node_network_dormant{device="int"} 1
+# HELP node_network_flags flags value of /sys/class/net/<iface>.
+# TYPE node_network_flags gauge
+node_network_flags{device="bond0"} 4867
+node_network_flags{device="dmz"} 4867
+node_network_flags{device="eth0"} 4867
+node_network_flags{device="int"} 4867
+# HELP node_network_iface_id iface_id value of /sys/class/net/<iface>.
+# TYPE node_network_iface_id gauge
+node_network_iface_id{device="bond0"} 2
+node_network_iface_id{device="dmz"} 2
+node_network_iface_id{device="eth0"} 2
+node_network_iface_id{device="int"} 2
+# HELP node_network_iface_link iface_link value of /sys/class/net/<iface>.
+# TYPE node_network_iface_link gauge
+node_network_iface_link{device="bond0"} 2
+node_network_iface_link{device="dmz"} 2
+node_network_iface_link{device="eth0"} 2
+node_network_iface_link{device="int"} 2
+# HELP node_network_iface_link_mode iface_link_mode value of /sys/class/net/<iface>.
+# TYPE node_network_iface_link_mode gauge
+node_network_iface_link_mode{device="bond0"} 1
+node_network_iface_link_mode{device="dmz"} 1
+node_network_iface_link_mode{device="eth0"} 1
+node_network_iface_link_mode{device="int"} 1
+# HELP node_network_info Non-numeric data from /sys/class/net/<iface>, value is always 1.
+# TYPE node_network_info gauge
+node_network_info{address="01:01:01:01:01:01",broadcast="ff:ff:ff:ff:ff:ff",device="bond0",duplex="full",ifalias="",operstate="up"} 1
+node_network_info{address="01:01:01:01:01:01",broadcast="ff:ff:ff:ff:ff:ff",device="dmz",duplex="full",ifalias="",operstate="up"} 1
+node_network_info{address="01:01:01:01:01:01",broadcast="ff:ff:ff:ff:ff:ff",device="eth0",duplex="full",ifalias="",operstate="up"} 1
+node_network_info{address="01:01:01:01:01:01",broadcast="ff:ff:ff:ff:ff:ff",device="int",duplex="full",ifalias="",operstate="up"} 1
+# HELP node_network_mtu_bytes mtu_bytes value of /sys/class/net/<iface>.
+# TYPE node_network_mtu_bytes gauge
+node_network_mtu_bytes{device="bond0"} 1500
+node_network_mtu_bytes{device="dmz"} 1500
+node_network_mtu_bytes{device="eth0"} 1500
+node_network_mtu_bytes{device="int"} 1500
+# HELP node_network_name_assign_type name_assign_type value of /sys/class/net/<iface>.
+# TYPE node_network_name_assign_type gauge
+node_network_name_assign_type{device="bond0"} 2
+node_network_name_assign_type{device="dmz"} 2
+node_network_name_assign_type{device="eth0"} 2
+node_network_name_assign_type{device="int"} 2
+# HELP node_network_net_dev_group net_dev_group value of /sys/class/net/<iface>.
+# TYPE node_network_net_dev_group gauge
+node_network_net_dev_group{device="bond0"} 0
+node_network_net_dev_group{device="dmz"} 0
+node_network_net_dev_group{device="eth0"} 0
+node_network_net_dev_group{device="int"} 0
+# HELP node_network_protocol_type protocol_type value of /sys/class/net/<iface>.
+# TYPE node_network_protocol_type gauge
+node_network_protocol_type{device="bond0"} 1
+node_network_protocol_type{device="dmz"} 1
+node_network_protocol_type{device="eth0"} 1
+node_network_protocol_type{device="int"} 1
+# HELP node_network_speed_bytes speed_bytes value of /sys/class/net/<iface>.
+# TYPE node_network_speed_bytes gauge
+node_network_speed_bytes{device="bond0"} -125000
+node_network_speed_bytes{device="dmz"} 1.25e+08
+node_network_speed_bytes{device="eth0"} 1.25e+08
+node_network_speed_bytes{device="int"} 1.25e+08
+# HELP node_network_transmit_queue_length transmit_queue_length value of /sys/class/net/<iface>.
+# TYPE node_network_transmit_queue_length gauge
+node_network_transmit_queue_length{device="bond0"} 1000
+node_network_transmit_queue_length{device="dmz"} 1000
+node_network_transmit_queue_length{device="eth0"} 1000
+node_network_transmit_queue_length{device="int"} 1000
+# HELP node_network_up Value is 1 if operstate is 'up', 0 otherwise.
+# TYPE node_network_up gauge
+node_network_up{device="bond0"} 1
+node_network_up{device="dmz"} 1
+node_network_up{device="eth0"} 1
+node_network_up{device="int"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkNetClassCollectIfaces(b *testing.B) {
+	int64p := func(v int64) *int64 { return &v }
+
+	netClass := sysfs.NetClass{}
+	for i := 0; i < 300; i++ {
+		name := fmt.Sprintf("veth%d", i)
+		netClass[name] = sysfs.NetClassIface{
+			Name:             name,
+			Address:          "02:42:ac:11:00:02",
+			Broadcast:        "ff:ff:ff:ff:ff:ff",
+			Duplex:           "full",
+			OperState:        "up",
+			AddrAssignType:   int64p(3),
+			Carrier:          int64p(1),
+			CarrierChanges:   int64p(2),
+			CarrierUpCount:   int64p(1),
+			CarrierDownCount: int64p(1),
+			DevID:            int64p(0),
+			Dormant:          int64p(0),
+			Flags:            int64p(4867),
+			IfIndex:          int64p(int64(i)),
+			IfLink:           int64p(int64(i)),
+			LinkMode:         int64p(0),
+			MTU:              int64p(1500),
+			NameAssignType:   int64p(3),
+			NetDevGroup:      int64p(0),
+			Speed:            int64p(10000),
+			TxQueueLen:       int64p(0),
+			Type:             int64p(1),
+		}
+	}
+
+	c := &netClassCollector{subsystem: "network", metricDescs: newNetClassDescs("network")}
+	ch := make(chan prometheus.Metric, 300*(len(netClassFields)+2))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.collectIfaces(ch, netClass)
+		for len(ch) > 0 {
+			<-ch
+		}
+	}
+}
+
+// writeNetClassFixture writes n interfaces under a fake sysfs at dir.
+func writeNetClassFixture(tb testing.TB, dir string, n int) {
+	tb.Helper()
+
+	for i := 0; i < n; i++ {
+		iface := filepath.Join(dir, "class", "net", fmt.Sprintf("veth%d", i))
+		if err := os.MkdirAll(iface, 0o755); err != nil {
+			tb.Fatal(err)
+		}
+		for file, content := range map[string]string{
+			"address":   "02:42:ac:11:00:02\n",
+			"carrier":   "1\n",
+			"mtu":       "1500\n",
+			"operstate": "up\n",
+			"speed":     "10000\n",
+		} {
+			if err := os.WriteFile(filepath.Join(iface, file), []byte(content), 0o644); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestNetClassCollectorCache(t *testing.T) {
+	sysPathWas, ttlWas := sysPath, netclassCacheTTL
+	defer func() {
+		sysPath, netclassCacheTTL = sysPathWas, ttlWas
+	}()
+
+	sysPath = t.TempDir()
+	writeNetClassFixture(t, sysPath, 2)
+
+	for _, tt := range []struct {
+		name string
+		ttl  time.Duration
+		want int
+	}{
+		{name: "disabled", ttl: 0, want: 3},
+		{name: "within ttl", ttl: time.Hour, want: 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			netclassCacheTTL = tt.ttl
+			c, err := NewNetClassCollector()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n := testutil.CollectAndCount(c, "node_network_up"); n != 2 {
+				t.Fatalf("got %d interfaces, want 2", n)
+			}
+
+			writeNetClassFixture(t, sysPath, 3)
+			defer os.RemoveAll(filepath.Join(sysPath, "class", "net", "veth2"))
+
+			if n := testutil.CollectAndCount(c, "node_network_up"); n != tt.want {
+				t.Fatalf("got %d interfaces, want %d", n, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkNetClassGetNetClassInfo(b *testing.B) {
+	sysPathWas, ttlWas := sysPath, netclassCacheTTL
+	defer func() {
+		sysPath, netclassCacheTTL = sysPathWas, ttlWas
+	}()
+
+	sysPath = b.TempDir()
+	writeNetClassFixture(b, sysPath, 1000)
+
+	for _, ttl := range []time.Duration{0, time.Second} {
+		b.Run(fmt.Sprintf("ttl=%s", ttl), func(b *testing.B) {
+			netclassCacheTTL = ttl
+			c, err := NewNetClassCollector()
+			if err != nil {
+				b.Fatal(err)
+			}
+			nc := c.(*netClassCollector)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := nc.getNetClassInfo(); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// newLinkMessage encodes iface as an RTM_NEWLINK message, leaving out the
+// attributes netlink does not carry.
+func newLinkMessage(iface sysfs.NetClassIface) []byte {
+	flags := uint32(*iface.Flags)
+	if iface.Dormant != nil && *iface.Dormant == 1 {
+		flags |= unix.IFF_DORMANT
+	}
+	ifi := unix.IfInfomsg{
+		Type:  uint16(*iface.Type),
+		Index: int32(*iface.IfIndex),
+		Flags: flags,
+	}
+	data := append([]byte{}, (*[unix.SizeofIfInfomsg]byte)(unsafe.Pointer(&ifi))[:]...)
+
+	addAttr := func(typ uint16, value []byte) {
+		attr := syscall.RtAttr{Len: uint16(syscall.SizeofRtAttr + len(value)), Type: typ}
+		data = append(data, (*[syscall.SizeofRtAttr]byte)(unsafe.Pointer(&attr))[:]...)
+		data = append(data, value...)
+		for len(data)%syscall.RTA_ALIGNTO != 0 {
+			data = append(data, 0)
+		}
+	}
+	addUint32 := func(typ uint16, v *int64) {
+		u := uint32(*v)
+		addAttr(typ, (*[4]byte)(unsafe.Pointer(&u))[:])
+	}
+	mac := func(s string) []byte {
+		hw, err := net.ParseMAC(s)
+		if err != nil {
+			panic(err)
+		}
+		return hw
+	}
+
+	addAttr(unix.IFLA_IFNAME, append([]byte(iface.Name), 0))
+	addAttr(unix.IFLA_ADDRESS, mac(iface.Address))
+	addAttr(unix.IFLA_BROADCAST, mac(iface.Broadcast))
+	addAttr(unix.IFLA_OPERSTATE, []byte{6}) // IF_OPER_UP
+	addAttr(unix.IFLA_LINKMODE, []byte{byte(*iface.LinkMode)})
+	addAttr(unix.IFLA_CARRIER, []byte{byte(*iface.Carrier)})
+	addUint32(unix.IFLA_LINK, iface.IfLink)
+	addUint32(unix.IFLA_MTU, iface.MTU)
+	addUint32(unix.IFLA_TXQLEN, iface.TxQueueLen)
+	addUint32(unix.IFLA_GROUP, iface.NetDevGroup)
+	addUint32(unix.IFLA_CARRIER_CHANGES, iface.CarrierChanges)
+	addUint32(unix.IFLA_CARRIER_UP_COUNT, iface.CarrierUpCount)
+	addUint32(unix.IFLA_CARRIER_DOWN_COUNT, iface.CarrierDownCount)
+
+	hdr := syscall.NlMsghdr{
+		Len:  uint32(syscall.NLMSG_HDRLEN + len(data)),
+		Type: unix.RTM_NEWLINK,
+	}
+	b := (*[syscall.NLMSG_HDRLEN]byte)(unsafe.Pointer(&hdr))[:]
+
+	return append(append([]byte{}, b...), data...)
+}
+
+// netClassSeries returns the series exposed by c, one "name{labels} value"
+// entry per series.
+func netClassSeries(t *testing.T, c prometheus.Collector) []string {
+	t.Helper()
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatal(err)
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var series []string
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%q", l.GetName(), l.GetValue()))
+			}
+			value := m.GetGauge().GetValue()
+			if mf.GetType() == dto.MetricType_COUNTER {
+				value = m.GetCounter().GetValue()
+			}
+			series = append(series, fmt.Sprintf("%s{%s} %v", mf.GetName(), strings.Join(labels, ","), value))
+		}
+	}
+	sort.Strings(series)
+
+	return series
+}
+
+func TestNetClassCollectorNetlinkMatchesSysfs(t *testing.T) {
+	sysPathWas, ribWas := sysPath, netclassNetlinkRIB
+	defer func() {
+		sysPath, netclassNetlinkRIB = sysPathWas, ribWas
+	}()
+	sysPath = "fixtures/sys"
+
+	fs, err := sysfs.NewFS(sysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	netClass, err := fs.NetClass()
+	if err != nil {
+		t.Fatal(err)
+	}
+	netclassNetlinkRIB = func() ([]byte, error) {
+		var rib []byte
+		for _, iface := range netClass {
+			rib = append(rib, newLinkMessage(iface)...)
+		}
+
+		return rib, nil
+	}
+
+	sysfsCollector, err := newNetClassCollector(withNetlink(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	netlinkCollector, err := newNetClassCollector(withNetlink(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := netClassSeries(t, sysfsCollector)
+	if len(want) == 0 {
+		t.Fatal("no series read from sysfs")
+	}
+	if got := netClassSeries(t, netlinkCollector); !reflect.DeepEqual(got, want) {
+		t.Fatalf("netlink series differ from sysfs:\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestNetClassCollectorNetlinkVanishedDevice(t *testing.T) {
+	sysPathWas, ribWas := sysPath, netclassNetlinkRIB
+	defer func() {
+		sysPath, netclassNetlinkRIB = sysPathWas, ribWas
+	}()
+	sysPath = t.TempDir()
+
+	int64p := func(v int64) *int64 { return &v }
+	netclassNetlinkRIB = func() ([]byte, error) {
+		// gone from sysfs by the time its sysfs-only attributes are read
+		return newLinkMessage(sysfs.NetClassIface{
+			Name:             "veth0",
+			Address:          "02:42:ac:11:00:02",
+			Broadcast:        "ff:ff:ff:ff:ff:ff",
+			Carrier:          int64p(1),
+			CarrierChanges:   int64p(2),
+			CarrierUpCount:   int64p(1),
+			CarrierDownCount: int64p(1),
+			Flags:            int64p(4099),
+			IfIndex:          int64p(7),
+			IfLink:           int64p(7),
+			LinkMode:         int64p(0),
+			MTU:              int64p(1500),
+			NetDevGroup:      int64p(0),
+			TxQueueLen:       int64p(1000),
+			Type:             int64p(1),
+		}), nil
+	}
+
+	c, err := newNetClassCollector(withNetlink(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP node_network_mtu_bytes mtu_bytes value of /sys/class/net/<iface>.
+# TYPE node_network_mtu_bytes gauge
+node_network_mtu_bytes{device="veth0"} 1500
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "node_network_mtu_bytes", "node_network_speed_bytes", "node_scrape_collector_errors"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNetClassCollectorUnknownSpeed(t *testing.T) {
+	sysPathWas := sysPath
+	defer func() {
+		sysPath = sysPathWas
+	}()
+	sysPath = "fixtures/sys"
+
+	unknown, known := int64(-1), int64(1000)
+	netClass := sysfs.NetClass{
+		"eth0": sysfs.NetClassIface{Name: "eth0", OperState: "up", Speed: &unknown},
+		"eth1": sysfs.NetClassIface{Name: "eth1", OperState: "up", Speed: &known},
+	}
+
+	tests := []struct {
+		name string
+		mode unknownSpeedMode
+		want string
+	}{
+		{
+			name: "negative",
+			mode: unknownSpeedNegative,
+			want: `# HELP node_network_speed_bytes speed_bytes value of /sys/class/net/<iface>.
+# TYPE node_network_speed_bytes gauge
+node_network_speed_bytes{device="eth0"} -125000
+node_network_speed_bytes{device="eth1"} 1.25e+08
+`,
+		},
+		{
+			name: "drop",
+			mode: unknownSpeedDrop,
+			want: `# HELP node_network_speed_bytes speed_bytes value of /sys/class/net/<iface>.
+# TYPE node_network_speed_bytes gauge
+node_network_speed_bytes{device="eth1"} 1.25e+08
+`,
+		},
+		{
+			name: "flag",
+			mode: unknownSpeedFlag,
+			want: `# HELP node_network_speed_bytes speed_bytes value of /sys/class/net/<iface>.
+# TYPE node_network_speed_bytes gauge
+node_network_speed_bytes{device="eth1"} 1.25e+08
+# HELP node_network_speed_unknown Value is 1 if the speed of /sys/class/net/<iface> is unknown.
+# TYPE node_network_speed_unknown gauge
+node_network_speed_unknown{device="eth0"} 1
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := newNetClassCollector(withUnknownSpeed(tt.mode))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// serve the fake interfaces from the cache
+			c.cacheTTL, c.cached, c.cachedUntil = time.Hour, netClass, time.Now().Add(time.Hour)
+
+			if err := testutil.CollectAndCompare(c, strings.NewReader(tt.want), "node_network_speed_bytes", "node_network_speed_unknown"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestNetClassUnknownSpeedMode(t *testing.T) {
+	invalidWas, flagWas := netclassInvalidSpeed, netclassFlagUnknownSpeed
+	defer func() {
+		netclassInvalidSpeed, netclassFlagUnknownSpeed = invalidWas, flagWas
+	}()
+
+	for _, tt := range []struct {
+		invalid, flag bool
+		want          unknownSpeedMode
+	}{
+		{false, false, unknownSpeedNegative},
+		{true, false, unknownSpeedDrop},
+		{false, true, unknownSpeedFlag},
+		{true, true, unknownSpeedFlag},
+	} {
+		netclassInvalidSpeed, netclassFlagUnknownSpeed = tt.invalid, tt.flag
+		if got := netclassUnknownSpeedMode(); got != tt.want {
+			t.Errorf("ignore-invalid-speed=%v flag-unknown-speed=%v: got mode %d, want %d", tt.invalid, tt.flag, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nonetclass && linux
+// +build !nonetclass,linux
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/prometheus/procfs/sysfs"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// netclassNetlinkRIB dumps the kernel link table, overridden by tests.
+	netclassNetlinkRIB = func() ([]byte, error) {
+		return syscall.NetlinkRIB(unix.RTM_GETLINK, unix.AF_UNSPEC)
+	}
+
+	// netlinkOperStates operstate names as shown in sysfs, indexed by
+	// IF_OPER_* value.
+	netlinkOperStates = []string{
+		"unknown",
+		"notpresent",
+		"down",
+		"lowerlayerdown",
+		"testing",
+		"dormant",
+		"up",
+	}
+)
+
+// netlinkComputedFlags link flags computed by the kernel for netlink
+// messages, not part of the device flags shown in sysfs.
+const netlinkComputedFlags = unix.IFF_RUNNING | unix.IFF_LOWER_UP | unix.IFF_DORMANT
+
+// readNetClassNetlink reads the class info of all non-ignored devices from a
+// single RTM_GETLINK dump. Attributes netlink does not carry are read from
+// sysfs per device; devices vanishing meanwhile keep their netlink info.
+func (c *netClassCollector) readNetClassNetlink() (sysfs.NetClass, error) {
+	rib, err := netclassNetlinkRIB()
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse netlink messages: %w", err)
+	}
+
+	links, err := parseLinkMessages(msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	netClass := sysfs.NetClass{}
+	errs := &multiError{}
+	for name, iface := range links {
+		if c.ignoredDevicesPattern.MatchString(name) {
+			continue
+		}
+		readNetClassSysfsOnly(&iface, errs)
+		netClass[name] = iface
+	}
+
+	return netClass, errs.ErrorOrNil()
+}
+
+// parseLinkMessages returns the class info of the links in msgs, keyed by
+// interface name.
+func parseLinkMessages(msgs []syscall.NetlinkMessage) (sysfs.NetClass, error) {
+	netClass := sysfs.NetClass{}
+
+	for i := range msgs {
+		m := &msgs[i]
+		switch m.Header.Type {
+		case unix.NLMSG_DONE:
+			return netClass, nil
+		case unix.RTM_NEWLINK:
+		default:
+			continue
+		}
+
+		if len(m.Data) < unix.SizeofIfInfomsg {
+			return nil, fmt.Errorf("unexpected link message length %d", len(m.Data))
+		}
+		ifi := (*unix.IfInfomsg)(unsafe.Pointer(&m.Data[0]))
+
+		attrs, err := syscall.ParseNetlinkRouteAttr(m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse link attributes: %w", err)
+		}
+
+		iface := parseLinkAttrs(ifi, attrs)
+		if iface.Name == "" {
+			continue
+		}
+		netClass[iface.Name] = iface
+	}
+
+	return netClass, nil
+}
+
+func parseLinkAttrs(ifi *unix.IfInfomsg, attrs []syscall.NetlinkRouteAttr) sysfs.NetClassIface {
+	int64p := func(v int64) *int64 { return &v }
+
+	iface := sysfs.NetClassIface{
+		Flags:   int64p(int64(ifi.Flags &^ netlinkComputedFlags)),
+		IfIndex: int64p(int64(ifi.Index)),
+		IfLink:  int64p(int64(ifi.Index)),
+		Type:    int64p(int64(ifi.Type)),
+	}
+
+	// sysfs fails reading carrier and dormant on devices that are not up
+	running := ifi.Flags&unix.IFF_UP != 0
+	if running {
+		dormant := int64(0)
+		if ifi.Flags&unix.IFF_DORMANT != 0 {
+			dormant = 1
+		}
+		iface.Dormant = int64p(dormant)
+	}
+
+	for _, attr := range attrs {
+		v := attr.Value
+		switch attr.Attr.Type {
+		case unix.IFLA_IFNAME:
+			iface.Name = strings.TrimRight(string(v), "\x00")
+		case unix.IFLA_IFALIAS:
+			iface.IfAlias = strings.TrimRight(string(v), "\x00")
+		case unix.IFLA_ADDRESS:
+			iface.Address = net.HardwareAddr(v).String()
+		case unix.IFLA_BROADCAST:
+			iface.Broadcast = net.HardwareAddr(v).String()
+		case unix.IFLA_OPERSTATE:
+			if len(v) > 0 && int(v[0]) < len(netlinkOperStates) {
+				iface.OperState = netlinkOperStates[v[0]]
+			}
+		case unix.IFLA_LINKMODE:
+			if len(v) > 0 {
+				iface.LinkMode = int64p(int64(v[0]))
+			}
+		case unix.IFLA_CARRIER:
+			if len(v) > 0 && running {
+				iface.Carrier = int64p(int64(v[0]))
+			}
+		case unix.IFLA_LINK:
+			if len(v) >= 4 {
+				iface.IfLink = int64p(int64(nativeUint32(v)))
+			}
+		case unix.IFLA_MTU:
+			if len(v) >= 4 {
+				iface.MTU = int64p(int64(nativeUint32(v)))
+			}
+		case unix.IFLA_TXQLEN:
+			if len(v) >= 4 {
+				iface.TxQueueLen = int64p(int64(nativeUint32(v)))
+			}
+		case unix.IFLA_GROUP:
+			if len(v) >= 4 {
+				iface.NetDevGroup = int64p(int64(nativeUint32(v)))
+			}
+		case unix.IFLA_CARRIER_CHANGES:
+			if len(v) >= 4 {
+				iface.CarrierChanges = int64p(int64(nativeUint32(v)))
+			}
+		case unix.IFLA_CARRIER_UP_COUNT:
+			if len(v) >= 4 {
+				iface.CarrierUpCount = int64p(int64(nativeUint32(v)))
+			}
+		case unix.IFLA_CARRIER_DOWN_COUNT:
+			if len(v) >= 4 {
+				iface.CarrierDownCount = int64p(int64(nativeUint32(v)))
+			}
+		}
+	}
+
+	return iface
+}
+
+// readNetClassSysfsOnly fills the attributes of iface that are not available
+// over netlink from its sysfs directory. Missing or unsupported attributes
+// or unparsable attributes are skipped, as done by sysfs.FS.NetClassByIface,
+// other errors are added to errs.
+func readNetClassSysfsOnly(iface *sysfs.NetClassIface, errs *multiError) {
+	dir := sysFilePath(filepath.Join("class", "net", iface.Name))
+
+	read := func(name string) (string, bool) {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		switch {
+		case err == nil:
+			return strings.TrimSpace(string(b)), true
+		case errors.Is(err, os.ErrNotExist), errors.Is(err, os.ErrPermission),
+			errors.Is(err, syscall.EINVAL), errors.Is(err, syscall.EOPNOTSUPP):
+		default:
+			errs.Add(iface.Name, err)
+		}
+
+		return "", false
+	}
+
+	for _, attr := range []struct {
+		name string
+		dst  **int64
+	}{
+		{"addr_assign_type", &iface.AddrAssignType},
+		{"dev_id", &iface.DevID},
+		{"name_assign_type", &iface.NameAssignType},
+		{"speed", &iface.Speed},
+	} {
+		value, ok := read(attr.name)
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseInt(value, 0, 64)
+		if err != nil {
+			continue
+		}
+		*attr.dst = &v
+	}
+
+	iface.Duplex, _ = read("duplex")
+
+	// older kernels don't send IFLA_IFALIAS
+	if iface.IfAlias == "" {
+		iface.IfAlias, _ = read("ifalias")
+	}
+}
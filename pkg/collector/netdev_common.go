@@ -101,21 +101,7 @@ func (c *netDevCollector) Collect(ch chan<- prometheus.Metric) {
 
 		return
 	}
-	for dev, devStats := range netDev {
-		for key, value := range devStats {
-			desc, ok := c.metricDescs[key]
-			if !ok {
-				desc = prometheus.NewDesc(
-					prometheus.BuildFQName(namespace, c.subsystem, key+"_total"),
-					fmt.Sprintf("Network device statistic %s.", key),
-					[]string{"device"},
-					nil,
-				)
-				c.metricDescs[key] = desc
-			}
-			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value), dev)
-		}
-	}
+	c.collectStats(ch, netDev)
 	if netdevAddressInfo {
 		interfaces, err := net.Interfaces()
 		if err != nil {
@@ -190,20 +176,7 @@ func (c *netDevCollector) Describe(ch chan<- *prometheus.Desc) {
 
 		return
 	}
-	for _, devStats := range netDev {
-		for key := range devStats {
-			desc, ok := c.metricDescs[key]
-			if !ok {
-				desc = prometheus.NewDesc(
-					prometheus.BuildFQName(namespace, c.subsystem, key+"_total"),
-					fmt.Sprintf("Network device statistic %s.", key),
-					[]string{"device"},
-					nil,
-				)
-			}
-			ch <- desc
-		}
-	}
+	c.describeStats(ch, netDev)
 	if netdevAddressInfo {
 		desc := prometheus.NewDesc(prometheus.BuildFQName(namespace, "network_address",
 			"info"), "node network address by device",
@@ -212,3 +185,34 @@ func (c *netDevCollector) Describe(ch chan<- *prometheus.Desc) {
 		ch <- desc
 	}
 }
+
+func (c *netDevCollector) collectStats(ch chan<- prometheus.Metric, netDev netDevStats) {
+	for dev, devStats := range netDev {
+		for key, value := range devStats {
+			ch <- prometheus.MustNewConstMetric(c.statDesc(key), prometheus.CounterValue, float64(value), dev)
+		}
+	}
+}
+
+func (c *netDevCollector) describeStats(ch chan<- *prometheus.Desc, netDev netDevStats) {
+	for _, devStats := range netDev {
+		for key := range devStats {
+			ch <- c.statDesc(key)
+		}
+	}
+}
+
+func (c *netDevCollector) statDesc(key string) *prometheus.Desc {
+	desc, ok := c.metricDescs[key]
+	if !ok {
+		desc = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, c.subsystem, key+"_total"),
+			fmt.Sprintf("Network device statistic %s.", key),
+			[]string{"device"},
+			nil,
+		)
+		c.metricDescs[key] = desc
+	}
+
+	return desc
+}
@@ -0,0 +1,110 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//go:build !nonetdev
+// +build !nonetdev
+
+package collector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ifMsghdr2Len size of struct if_msghdr2 of net/if.h, with its
+	// struct if_data64 starting at ifMsghdr2DataOffset.
+	ifMsghdr2Len        = 136
+	ifMsghdr2DataOffset = 32
+)
+
+// ifData64Counters offsets in struct if_data64 of the counters exported,
+// under the /proc/net/dev names of the linux collector.
+var ifData64Counters = []struct {
+	key    string
+	offset int
+}{
+	{"receive_packets", 24},
+	{"receive_errs", 32},
+	{"transmit_packets", 40},
+	{"transmit_errs", 48},
+	{"transmit_colls", 56},
+	{"receive_bytes", 64},
+	{"transmit_bytes", 72},
+	{"receive_multicast", 80},
+	{"receive_drop", 96},
+}
+
+// getNetDevStats reads the interface counters from the NET_RT_IFLIST2
+// routing sysctl, the 64-bit counters netstat -ib shows.
+func getNetDevStats(filter *netDevFilter) (netDevStats, error) {
+	rib, err := syscall.RouteRIB(unix.NET_RT_IFLIST2, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read interface list: %w", err)
+	}
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
+	}
+	names := make(map[int]string, len(interfaces))
+	for _, iface := range interfaces {
+		names[iface.Index] = iface.Name
+	}
+
+	return parseIfList2(rib, names, filter)
+}
+
+// parseIfList2 parses the RTM_IFINFO2 messages of rib, interfaces are named
+// after names by index. Messages are in host byte order, little endian on
+// all darwin architectures.
+func parseIfList2(rib []byte, names map[int]string, filter *netDevFilter) (netDevStats, error) {
+	netDev := netDevStats{}
+
+	for len(rib) >= 4 {
+		msgLen := int(binary.LittleEndian.Uint16(rib))
+		if msgLen < 4 || msgLen > len(rib) {
+			return nil, fmt.Errorf("invalid routing message length %d", msgLen)
+		}
+		msg := rib[:msgLen]
+		rib = rib[msgLen:]
+
+		if msg[3] != unix.RTM_IFINFO2 {
+			continue
+		}
+		if msgLen < ifMsghdr2Len {
+			return nil, fmt.Errorf("unexpected if_msghdr2 length %d", msgLen)
+		}
+
+		dev, ok := names[int(binary.LittleEndian.Uint16(msg[12:]))]
+		if !ok || filter.ignored(dev) {
+			continue
+		}
+
+		data := msg[ifMsghdr2DataOffset:]
+		devStats := map[string]uint64{
+			// ifm_snd_drops
+			"transmit_drop": uint64(binary.LittleEndian.Uint32(msg[24:])),
+		}
+		for _, counter := range ifData64Counters {
+			devStats[counter.key] = binary.LittleEndian.Uint64(data[counter.offset:])
+		}
+
+		netDev[dev] = devStats
+	}
+
+	return netDev, nil
+}
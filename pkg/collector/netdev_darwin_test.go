@@ -0,0 +1,62 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//go:build !nonetdev
+// +build !nonetdev
+
+package collector
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func newIfMsghdr2(index uint16, rxBytes, txBytes uint64) []byte {
+	msg := make([]byte, ifMsghdr2Len)
+	binary.LittleEndian.PutUint16(msg, ifMsghdr2Len)
+	msg[3] = unix.RTM_IFINFO2
+	binary.LittleEndian.PutUint16(msg[12:], index)
+	binary.LittleEndian.PutUint32(msg[24:], 3)
+	binary.LittleEndian.PutUint64(msg[ifMsghdr2DataOffset+64:], rxBytes)
+	binary.LittleEndian.PutUint64(msg[ifMsghdr2DataOffset+72:], txBytes)
+
+	return msg
+}
+
+func TestParseIfList2(t *testing.T) {
+	// RTM_NEWADDR messages follow each interface's RTM_IFINFO2
+	newAddr := make([]byte, 20)
+	binary.LittleEndian.PutUint16(newAddr, 20)
+	newAddr[3] = unix.RTM_NEWADDR
+
+	var rib []byte
+	rib = append(rib, newIfMsghdr2(1, 1<<33, 42)...)
+	rib = append(rib, newAddr...)
+	rib = append(rib, newIfMsghdr2(4, 10, 20)...)
+	rib = append(rib, newIfMsghdr2(9, 0, 0)...)
+
+	filter := newNetDevFilter("^utun", "")
+	netDev, err := parseIfList2(rib, map[int]string{1: "lo0", 4: "en0", 7: "utun0"}, &filter)
+	require.NoError(t, err)
+
+	require.Len(t, netDev, 2)
+	require.Equal(t, uint64(1<<33), netDev["lo0"]["receive_bytes"])
+	require.Equal(t, uint64(42), netDev["lo0"]["transmit_bytes"])
+	require.Equal(t, uint64(3), netDev["en0"]["transmit_drop"])
+	require.Equal(t, uint64(20), netDev["en0"]["transmit_bytes"])
+
+	_, err = parseIfList2(newIfMsghdr2(1, 0, 0)[:ifMsghdr2Len-8], nil, &filter)
+	require.Error(t, err)
+}
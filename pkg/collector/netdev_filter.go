@@ -13,22 +13,18 @@
 
 package collector
 
-import (
-	"regexp"
-)
-
 type netDevFilter struct {
-	ignorePattern *regexp.Regexp
-	acceptPattern *regexp.Regexp
+	ignorePattern deviceMatcher
+	acceptPattern deviceMatcher
 }
 
 func newNetDevFilter(ignoredPattern, acceptPattern string) (f netDevFilter) {
 	if ignoredPattern != "" {
-		f.ignorePattern = regexp.MustCompile(ignoredPattern)
+		f.ignorePattern = mustNewDeviceMatcher(ignoredPattern)
 	}
 
 	if acceptPattern != "" {
-		f.acceptPattern = regexp.MustCompile(acceptPattern)
+		f.acceptPattern = mustNewDeviceMatcher(acceptPattern)
 	}
 
 	return
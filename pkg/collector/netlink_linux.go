@@ -0,0 +1,201 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// genlHeaderLen size of the generic netlink header following the
+	// netlink message header.
+	genlHeaderLen = int(unsafe.Sizeof(unix.Genlmsghdr{}))
+
+	// nlaTypeMask strips the nested and byte order flags of an attribute type.
+	nlaTypeMask = ^uint16(unix.NLA_F_NESTED | unix.NLA_F_NET_BYTEORDER)
+)
+
+func nativeUint16(b []byte) uint16 {
+	return *(*uint16)(unsafe.Pointer(&b[0]))
+}
+
+func nativeUint32(b []byte) uint32 {
+	return *(*uint32)(unsafe.Pointer(&b[0]))
+}
+
+func nativeUint64(b []byte) uint64 {
+	return *(*uint64)(unsafe.Pointer(&b[0]))
+}
+
+func nativeUint32Bytes(v uint32) []byte {
+	return append([]byte{}, (*[4]byte)(unsafe.Pointer(&v))[:]...)
+}
+
+// netlinkAlign rounds n up to the netlink attribute alignment.
+func netlinkAlign(n int) int {
+	return (n + unix.NLA_ALIGNTO - 1) &^ (unix.NLA_ALIGNTO - 1)
+}
+
+// parseNetlinkAttrs returns the values of the netlink attributes in b, keyed
+// by type. Nested attributes are returned as is, to be parsed again.
+func parseNetlinkAttrs(b []byte) (map[uint16][]byte, error) {
+	attrs := make(map[uint16][]byte)
+
+	for len(b) >= unix.SizeofNlAttr {
+		attr := (*unix.NlAttr)(unsafe.Pointer(&b[0]))
+		if int(attr.Len) < unix.SizeofNlAttr || int(attr.Len) > len(b) {
+			return nil, fmt.Errorf("invalid netlink attribute length %d", attr.Len)
+		}
+		attrs[attr.Type&nlaTypeMask] = b[unix.SizeofNlAttr:attr.Len]
+
+		next := netlinkAlign(int(attr.Len))
+		if next >= len(b) {
+			break
+		}
+		b = b[next:]
+	}
+
+	return attrs, nil
+}
+
+// newNetlinkAttr encodes a netlink attribute, padded to the attribute alignment.
+func newNetlinkAttr(typ uint16, value []byte) []byte {
+	attr := unix.NlAttr{Len: uint16(unix.SizeofNlAttr + len(value)), Type: typ}
+	b := append([]byte{}, (*[unix.SizeofNlAttr]byte)(unsafe.Pointer(&attr))[:]...)
+	b = append(b, value...)
+
+	return append(b, make([]byte, netlinkAlign(len(b))-len(b))...)
+}
+
+// genlConn is a generic netlink socket.
+type genlConn struct {
+	fd  int
+	seq uint32
+}
+
+func dialGenl() (*genlConn, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_GENERIC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open generic netlink socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+
+		return nil, fmt.Errorf("failed to bind generic netlink socket: %w", err)
+	}
+
+	return &genlConn{fd: fd}, nil
+}
+
+func (c *genlConn) Close() error {
+	return unix.Close(c.fd)
+}
+
+// family resolves the id of the generic netlink family name.
+func (c *genlConn) family(name string) (uint16, error) {
+	payloads, err := c.execute(unix.GENL_ID_CTRL, unix.CTRL_CMD_GETFAMILY, 0,
+		newNetlinkAttr(unix.CTRL_ATTR_FAMILY_NAME, append([]byte(name), 0)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve generic netlink family %q: %w", name, err)
+	}
+
+	for _, payload := range payloads {
+		attrs, err := parseNetlinkAttrs(payload)
+		if err != nil {
+			return 0, err
+		}
+		if id, ok := attrs[unix.CTRL_ATTR_FAMILY_ID]; ok && len(id) >= 2 {
+			return nativeUint16(id), nil
+		}
+	}
+
+	return 0, fmt.Errorf("generic netlink family %q not found", name)
+}
+
+// execute sends the cmd request with the encoded attrs to family and returns
+// the attributes of the replies, all parts of a dump if flags has
+// NLM_F_DUMP.
+func (c *genlConn) execute(family uint16, cmd uint8, flags uint16, attrs []byte) ([][]byte, error) {
+	c.seq++
+
+	hdr := unix.NlMsghdr{
+		Len:   uint32(unix.NLMSG_HDRLEN + genlHeaderLen + len(attrs)),
+		Type:  family,
+		Flags: unix.NLM_F_REQUEST | flags,
+		Seq:   c.seq,
+	}
+	genl := unix.Genlmsghdr{Cmd: cmd, Version: 1}
+
+	req := append([]byte{}, (*[unix.NLMSG_HDRLEN]byte)(unsafe.Pointer(&hdr))[:]...)
+	req = append(req, (*[genlHeaderLen]byte)(unsafe.Pointer(&genl))[:]...)
+	req = append(req, attrs...)
+
+	if err := unix.Sendto(c.fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	return c.receive(c.seq)
+}
+
+func (c *genlConn) receive(seq uint32) ([][]byte, error) {
+	buf := make([]byte, 16*os.Getpagesize())
+
+	var payloads [][]byte
+	for {
+		n, _, err := unix.Recvfrom(c.fd, buf, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse netlink messages: %w", err)
+		}
+
+		for _, m := range msgs {
+			if m.Header.Seq != seq {
+				continue
+			}
+
+			switch m.Header.Type {
+			case unix.NLMSG_DONE:
+				return payloads, nil
+			case unix.NLMSG_ERROR:
+				if len(m.Data) < 4 {
+					return nil, fmt.Errorf("unexpected netlink error message length %d", len(m.Data))
+				}
+				if errno := -int32(nativeUint32(m.Data)); errno != 0 {
+					return nil, syscall.Errno(errno)
+				}
+
+				return payloads, nil
+			}
+
+			if len(m.Data) < genlHeaderLen {
+				return nil, fmt.Errorf("unexpected generic netlink message length %d", len(m.Data))
+			}
+			// buf is reused by the next read
+			payloads = append(payloads, append([]byte{}, m.Data[genlHeaderLen:]...))
+
+			if m.Header.Flags&unix.NLM_F_MULTI == 0 {
+				return payloads, nil
+			}
+		}
+	}
+}
@@ -31,11 +31,20 @@ const (
 )
 
 var (
-	netStatFields = "^(.*_(InErrors|InErrs)|Ip_Forwarding|Ip(6|Ext)_(InOctets|OutOctets)|Icmp6?_(InMsgs|OutMsgs)|TcpExt_(Listen.*|Syncookies.*|TCPSynRetrans|TCPTimeouts)|Tcp_(ActiveOpens|InSegs|OutSegs|OutRsts|PassiveOpens|RetransSegs|CurrEstab)|Udp6?_(InDatagrams|OutDatagrams|NoPorts|RcvbufErrors|SndbufErrors))$"
+	// netStatFields Regexp of fields to return for netstat collector.
+	// collector.netstat.fields
+	netStatFields = "^(.*_(InErrors|InErrs)|Ip_Forwarding|Ip_(ForwDatagrams|InDiscards|OutDiscards)|Ip(6|Ext)_(InOctets|OutOctets)|Icmp6?_(InMsgs|OutMsgs)|TcpExt_(Listen.*|Syncookies.*|TCPSynRetrans|TCPTimeouts)|Tcp_(ActiveOpens|AttemptFails|EstabResets|InSegs|OutSegs|OutRsts|PassiveOpens|RetransSegs|CurrEstab)|Udp6?_(InDatagrams|OutDatagrams|NoPorts|RcvbufErrors|SndbufErrors))$"
+
+	// netStatExtraFields Regexp of fields to return in addition to
+	// netStatFields, i.e. "^TcpExt_TCPLoss.*$".
+	// collector.netstat.extra-fields
+	netStatExtraFields = ""
 )
 
 type netStatCollector struct {
-	fieldPattern *regexp.Regexp
+	fieldPattern      *regexp.Regexp
+	extraFieldPattern *regexp.Regexp
+	errorsDesc        *prometheus.Desc
 }
 
 // func init() {
@@ -45,51 +54,71 @@ type netStatCollector struct {
 // NewNetStatCollector takes and returns
 // a new Collector exposing network stats.
 func NewNetStatCollector() (prometheus.Collector, error) {
-	pattern := regexp.MustCompile(netStatFields)
-	return &netStatCollector{
+	pattern, err := regexp.Compile(netStatFields)
+	if err != nil {
+		return nil, fmt.Errorf("invalid netstat fields: %w", err)
+	}
+
+	c := &netStatCollector{
 		fieldPattern: pattern,
-	}, nil
+		errorsDesc:   newScrapeErrorsDesc(netStatsSubsystem),
+	}
+
+	if netStatExtraFields != "" {
+		c.extraFieldPattern, err = regexp.Compile(netStatExtraFields)
+		if err != nil {
+			return nil, fmt.Errorf("invalid netstat extra fields: %w", err)
+		}
+	}
+
+	return c, nil
 }
 
-func (c *netStatCollector) Collect(ch chan<- prometheus.Metric) {
-	netStats, err := getNetStats(procFilePath("net/netstat"))
-	if err != nil {
-		err = fmt.Errorf("couldn't get netstats: %w", err)
+// exported returns true if the field named key should be exported.
+func (c *netStatCollector) exported(key string) bool {
+	return c.fieldPattern.MatchString(key) ||
+		(c.extraFieldPattern != nil && c.extraFieldPattern.MatchString(key))
+}
 
-		return
+// parseNetStatValue parses a netstat value. Counters are unsigned 64-bit
+// integers, while a few fields like Tcp MaxConn can be negative. Values
+// above 2^53 are exported with the precision loss of a float64.
+func parseNetStatValue(value string) (float64, error) {
+	if v, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return float64(v), nil
 	}
-	snmpStats, err := getNetStats(procFilePath("net/snmp"))
-	if err != nil {
-		err = fmt.Errorf("couldn't get SNMP stats: %w", err)
 
-		return
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %s: %w", value, ErrParse)
 	}
-	snmp6Stats, err := getSNMP6Stats(procFilePath("net/snmp6"))
+
+	return float64(v), nil
+}
+
+func (c *netStatCollector) Collect(ch chan<- prometheus.Metric) {
+	netStats, err := getAllNetStats()
 	if err != nil {
-		err = fmt.Errorf("couldn't get SNMP6 stats: %w", err)
+		collectErrors(ch, c.errorsDesc, err)
 
 		return
 	}
-	// Merge the results of snmpStats into netStats (collisions are possible, but
-	// we know that the keys are always unique for the given use case).
-	for k, v := range snmpStats {
-		netStats[k] = v
-	}
-	for k, v := range snmp6Stats {
-		netStats[k] = v
-	}
+
+	errs := &multiError{}
 	for protocol, protocolStats := range netStats {
 		for name, value := range protocolStats {
 			key := protocol + "_" + name
-			v, err := strconv.ParseFloat(value, 64)
+			if !c.exported(key) {
+				continue
+			}
+
+			v, err := parseNetStatValue(value)
 			if err != nil {
-				err = fmt.Errorf("invalid value %s in netstats: %w", value, err)
+				errs.Add(key, err)
 
-				return
-			}
-			if !c.fieldPattern.MatchString(key) {
 				continue
 			}
+
 			ch <- prometheus.MustNewConstMetric(
 				prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, netStatsSubsystem, key),
@@ -100,7 +129,35 @@ func (c *netStatCollector) Collect(ch chan<- prometheus.Metric) {
 			)
 		}
 	}
-	return
+
+	collectErrors(ch, c.errorsDesc, errs.ErrorOrNil())
+}
+
+// getAllNetStats returns the stats of /proc/net/netstat, /proc/net/snmp
+// and /proc/net/snmp6 by protocol.
+func getAllNetStats() (map[string]map[string]string, error) {
+	netStats, err := getNetStats(procFilePath("net/netstat"))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get netstats: %w", err)
+	}
+	snmpStats, err := getNetStats(procFilePath("net/snmp"))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get SNMP stats: %w", err)
+	}
+	snmp6Stats, err := getSNMP6Stats(procFilePath("net/snmp6"))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get SNMP6 stats: %w", err)
+	}
+	// Merge the results of snmpStats into netStats (collisions are possible, but
+	// we know that the keys are always unique for the given use case).
+	for k, v := range snmpStats {
+		netStats[k] = v
+	}
+	for k, v := range snmp6Stats {
+		netStats[k] = v
+	}
+
+	return netStats, nil
 }
 
 func getNetStats(fileName string) (map[string]map[string]string, error) {
@@ -181,36 +238,17 @@ func parseSNMP6Stats(r io.Reader) (map[string]map[string]string, error) {
 
 // Describe exposes descriptors for this collector.
 func (c *netStatCollector) Describe(ch chan<- *prometheus.Desc) {
-	netStats, err := getNetStats(procFilePath("net/netstat"))
-	if err != nil {
-		err = fmt.Errorf("couldn't get netstats: %w", err)
+	ch <- c.errorsDesc
 
-		return
-	}
-	snmpStats, err := getNetStats(procFilePath("net/snmp"))
+	netStats, err := getAllNetStats()
 	if err != nil {
-		err = fmt.Errorf("couldn't get SNMP stats: %w", err)
-
 		return
 	}
-	snmp6Stats, err := getSNMP6Stats(procFilePath("net/snmp6"))
-	if err != nil {
-		err = fmt.Errorf("couldn't get SNMP6 stats: %w", err)
 
-		return
-	}
-	// Merge the results of snmpStats into netStats (collisions are possible, but
-	// we know that the keys are always unique for the given use case).
-	for k, v := range snmpStats {
-		netStats[k] = v
-	}
-	for k, v := range snmp6Stats {
-		netStats[k] = v
-	}
 	for protocol, protocolStats := range netStats {
 		for name := range protocolStats {
 			key := protocol + "_" + name
-			if !c.fieldPattern.MatchString(key) {
+			if !c.exported(key) {
 				continue
 			}
 			ch <- prometheus.NewDesc(
@@ -14,8 +14,13 @@
 package collector
 
 import (
+	"math"
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNetStats(t *testing.T) {
@@ -94,3 +99,91 @@ func testSNMP6Stats(t *testing.T, fileName string) {
 		t.Errorf("want netstat Udp6 SndbufErrors %s, got %s", want, got)
 	}
 }
+
+func TestNetStatCollector(t *testing.T) {
+	procPathWas, extraWas := procPath, netStatExtraFields
+	defer func() {
+		procPath, netStatExtraFields = procPathWas, extraWas
+	}()
+	procPath = "fixtures/proc"
+
+	c, err := NewNetStatCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_netstat_Ip_ForwDatagrams Statistic IpForwDatagrams.
+# TYPE node_netstat_Ip_ForwDatagrams untyped
+node_netstat_Ip_ForwDatagrams 397750
+# HELP node_netstat_Ip_InDiscards Statistic IpInDiscards.
+# TYPE node_netstat_Ip_InDiscards untyped
+node_netstat_Ip_InDiscards 0
+# HELP node_netstat_TcpExt_ListenOverflows Statistic TcpExtListenOverflows.
+# TYPE node_netstat_TcpExt_ListenOverflows untyped
+node_netstat_TcpExt_ListenOverflows 0
+# HELP node_netstat_Tcp_ActiveOpens Statistic TcpActiveOpens.
+# TYPE node_netstat_Tcp_ActiveOpens untyped
+node_netstat_Tcp_ActiveOpens 3556
+# HELP node_netstat_Tcp_EstabResets Statistic TcpEstabResets.
+# TYPE node_netstat_Tcp_EstabResets untyped
+node_netstat_Tcp_EstabResets 161
+# HELP node_netstat_Tcp_RetransSegs Statistic TcpRetransSegs.
+# TYPE node_netstat_Tcp_RetransSegs untyped
+node_netstat_Tcp_RetransSegs 227
+# HELP node_netstat_Udp_InErrors Statistic UdpInErrors.
+# TYPE node_netstat_Udp_InErrors untyped
+node_netstat_Udp_InErrors 0
+`
+	names := []string{
+		"node_netstat_Ip_ForwDatagrams",
+		"node_netstat_Ip_InDiscards",
+		"node_netstat_TcpExt_ListenOverflows",
+		"node_netstat_Tcp_ActiveOpens",
+		"node_netstat_Tcp_EstabResets",
+		"node_netstat_Tcp_RetransSegs",
+		"node_netstat_Udp_InErrors",
+		// not part of the default set
+		"node_netstat_TcpExt_DelayedACKs",
+		"node_netstat_Tcp_MaxConn",
+	}
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want), names...))
+
+	netStatExtraFields = "^(TcpExt_DelayedACKs|Tcp_MaxConn)$"
+	c, err = NewNetStatCollector()
+	require.NoError(t, err)
+
+	want = `# HELP node_netstat_TcpExt_DelayedACKs Statistic TcpExtDelayedACKs.
+# TYPE node_netstat_TcpExt_DelayedACKs untyped
+node_netstat_TcpExt_DelayedACKs 102471
+# HELP node_netstat_Tcp_MaxConn Statistic TcpMaxConn.
+# TYPE node_netstat_Tcp_MaxConn untyped
+node_netstat_Tcp_MaxConn -1
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want), "node_netstat_TcpExt_DelayedACKs", "node_netstat_Tcp_MaxConn"))
+
+	netStatExtraFields = "("
+	_, err = NewNetStatCollector()
+	require.Error(t, err)
+}
+
+func TestParseNetStatValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+	}{
+		{"0", 0},
+		{"-1", -1},
+		{"9007199254740993", 9007199254740993},
+		{"18446744073709551615", math.MaxUint64},
+	}
+
+	for _, tt := range tests {
+		got, err := parseNetStatValue(tt.value)
+		require.NoError(t, err, tt.value)
+		require.Equal(t, tt.want, got, tt.value)
+	}
+
+	_, err := parseNetStatValue("18446744073709551616")
+	require.ErrorIs(t, err, ErrParse)
+
+	_, err = parseNetStatValue("1.5")
+	require.ErrorIs(t, err, ErrParse)
+}
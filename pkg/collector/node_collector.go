@@ -0,0 +1,180 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// NodeCollector aggregates collectors so that a single gather runs them
+// concurrently, with at most a bounded number of them in flight. Each child
+// is reported with its scrape duration and success.
+type NodeCollector struct {
+	collectors  map[Name]prometheus.Collector
+	concurrency int
+	timeout     time.Duration
+
+	durationDesc *prometheus.Desc
+	successDesc  *prometheus.Desc
+}
+
+// NodeCollectorOption configures a NodeCollector.
+type NodeCollectorOption func(*NodeCollector)
+
+// WithConcurrency sets the maximum number of child collectors running at
+// the same time. Values lower than 1 are ignored.
+func WithConcurrency(n int) NodeCollectorOption {
+	return func(c *NodeCollector) {
+		if n > 0 {
+			c.concurrency = n
+		}
+	}
+}
+
+// WithCollectorTimeout sets how long a child collector may run before its
+// remaining metrics are discarded and it is reported as failed. Zero, the
+// default, waits for every child.
+func WithCollectorTimeout(timeout time.Duration) NodeCollectorOption {
+	return func(c *NodeCollector) {
+		c.timeout = timeout
+	}
+}
+
+// NewNodeCollector returns a Collector running collectors concurrently,
+// by default with up to runtime.NumCPU() of them at a time.
+func NewNodeCollector(collectors map[Name]prometheus.Collector, opts ...NodeCollectorOption) *NodeCollector {
+	c := &NodeCollector{
+		collectors:  collectors,
+		concurrency: runtime.NumCPU(),
+		durationDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "scrape", "collector_duration_seconds"),
+			"Duration of a collector scrape.",
+			[]string{"collector"}, nil,
+		),
+		successDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "scrape", "collector_success"),
+			"Whether a collector succeeded.",
+			[]string{"collector"}, nil,
+		),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Describe implements the prometheus.Collector interface.
+func (c *NodeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.durationDesc
+	ch <- c.successDesc
+	for _, name := range c.names() {
+		c.collectors[name].Describe(ch)
+	}
+}
+
+// Collect implements the prometheus.Collector interface. It returns once
+// every child collector has completed or timed out.
+func (c *NodeCollector) Collect(ch chan<- prometheus.Metric) {
+	names := c.names()
+	jobs := make(chan Name)
+
+	workers := c.concurrency
+	if workers > len(names) {
+		workers = len(names)
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				c.execute(name, ch)
+			}
+		}()
+	}
+
+	for _, name := range names {
+		jobs <- name
+	}
+	close(jobs)
+
+	wg.Wait()
+}
+
+// execute forwards the metrics of a child collector to ch, followed by its
+// duration and success. ch is safe for concurrent sends, but the child
+// writes to its own channel so that a timed out child can't send to ch
+// after Collect returned.
+func (c *NodeCollector) execute(name Name, ch chan<- prometheus.Metric) {
+	start := time.Now()
+
+	out := make(chan prometheus.Metric)
+	go func() {
+		c.collectors[name].Collect(out)
+		close(out)
+	}()
+
+	var timeout <-chan time.Time
+	if c.timeout > 0 {
+		timer := time.NewTimer(c.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	success := 1.0
+forward:
+	for {
+		select {
+		case m, ok := <-out:
+			if !ok {
+				break forward
+			}
+			ch <- m
+		case <-timeout:
+			zap.S().Warnw("collector timed out, discarding its metrics", "collector", name, "timeout", c.timeout)
+			success = 0
+
+			// let the child run to completion
+			go func() {
+				for range out {
+				}
+			}()
+
+			break forward
+		}
+	}
+
+	duration := time.Since(start).Seconds()
+	ch <- prometheus.MustNewConstMetric(c.durationDesc, prometheus.GaugeValue, duration, string(name))
+	ch <- prometheus.MustNewConstMetric(c.successDesc, prometheus.GaugeValue, success, string(name))
+}
+
+// names the child collector names, sorted for a stable scheduling order.
+func (c *NodeCollector) names() []Name {
+	names := make([]Name, 0, len(c.collectors))
+	for name := range c.collectors {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+
+	return names
+}
@@ -0,0 +1,141 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// slowCollector sleeps for delay before exporting a single gauge, keeping
+// track of the number of slowCollectors collecting at the same time.
+type slowCollector struct {
+	desc     *prometheus.Desc
+	delay    time.Duration
+	inFlight *int32
+	maxSeen  *int32
+}
+
+func newSlowCollector(name string, delay time.Duration, inFlight, maxSeen *int32) *slowCollector {
+	return &slowCollector{
+		desc:     prometheus.NewDesc("node_slow_"+name, "Slow fake collector.", nil, nil),
+		delay:    delay,
+		inFlight: inFlight,
+		maxSeen:  maxSeen,
+	}
+}
+
+func (c *slowCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *slowCollector) Collect(ch chan<- prometheus.Metric) {
+	n := atomic.AddInt32(c.inFlight, 1)
+	for {
+		seen := atomic.LoadInt32(c.maxSeen)
+		if n <= seen || atomic.CompareAndSwapInt32(c.maxSeen, seen, n) {
+			break
+		}
+	}
+
+	time.Sleep(c.delay)
+	atomic.AddInt32(c.inFlight, -1)
+
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1)
+}
+
+func TestNodeCollector_Concurrent(t *testing.T) {
+	var inFlight, maxSeen int32
+	delay := 200 * time.Millisecond
+	collectors := map[Name]prometheus.Collector{
+		"a": newSlowCollector("a", delay, &inFlight, &maxSeen),
+		"b": newSlowCollector("b", delay, &inFlight, &maxSeen),
+		"c": newSlowCollector("c", delay, &inFlight, &maxSeen),
+		"d": newSlowCollector("d", delay/2, &inFlight, &maxSeen),
+	}
+	c := NewNodeCollector(collectors, WithConcurrency(len(collectors)))
+
+	start := time.Now()
+	want := `# HELP node_slow_a Slow fake collector.
+# TYPE node_slow_a gauge
+node_slow_a 1
+# HELP node_slow_b Slow fake collector.
+# TYPE node_slow_b gauge
+node_slow_b 1
+# HELP node_slow_c Slow fake collector.
+# TYPE node_slow_c gauge
+node_slow_c 1
+# HELP node_slow_d Slow fake collector.
+# TYPE node_slow_d gauge
+node_slow_d 1
+# HELP node_scrape_collector_success Whether a collector succeeded.
+# TYPE node_scrape_collector_success gauge
+node_scrape_collector_success{collector="a"} 1
+node_scrape_collector_success{collector="b"} 1
+node_scrape_collector_success{collector="c"} 1
+node_scrape_collector_success{collector="d"} 1
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want),
+		"node_slow_a", "node_slow_b", "node_slow_c", "node_slow_d", "node_scrape_collector_success"))
+
+	// bounded by the slowest collector, not the sum of the delays
+	elapsed := time.Since(start)
+	require.GreaterOrEqual(t, elapsed, delay)
+	require.Less(t, elapsed, 2*delay)
+	require.EqualValues(t, len(collectors), maxSeen)
+}
+
+func TestNodeCollector_Bounded(t *testing.T) {
+	var inFlight, maxSeen int32
+	delay := 50 * time.Millisecond
+	collectors := map[Name]prometheus.Collector{}
+	for _, name := range []Name{"a", "b", "c", "d", "e", "f"} {
+		collectors[name] = newSlowCollector(string(name), delay, &inFlight, &maxSeen)
+	}
+	c := NewNodeCollector(collectors, WithConcurrency(2))
+
+	start := time.Now()
+	require.Equal(t, 2*len(collectors), testutil.CollectAndCount(c, "node_scrape_collector_duration_seconds", "node_scrape_collector_success"))
+
+	require.EqualValues(t, 2, maxSeen)
+	require.GreaterOrEqual(t, time.Since(start), 3*delay)
+}
+
+func TestNodeCollector_Timeout(t *testing.T) {
+	var inFlight, maxSeen int32
+	collectors := map[Name]prometheus.Collector{
+		"fast": newSlowCollector("fast", 0, &inFlight, &maxSeen),
+		"slow": newSlowCollector("slow", time.Second, &inFlight, &maxSeen),
+	}
+	c := NewNodeCollector(collectors, WithCollectorTimeout(100*time.Millisecond))
+
+	start := time.Now()
+	want := `# HELP node_scrape_collector_success Whether a collector succeeded.
+# TYPE node_scrape_collector_success gauge
+node_scrape_collector_success{collector="fast"} 1
+node_scrape_collector_success{collector="slow"} 0
+# HELP node_slow_fast Slow fake collector.
+# TYPE node_slow_fast gauge
+node_slow_fast 1
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want),
+		"node_slow_fast", "node_slow_slow", "node_scrape_collector_success"))
+	require.Less(t, time.Since(start), time.Second)
+}
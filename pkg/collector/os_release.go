@@ -0,0 +1,106 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noosrelease
+// +build !noosrelease
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// osReleaseFilenames os-release locations relative to the rootfs, in order
+// of precedence (see os-release(5)).
+var osReleaseFilenames = []string{"etc/os-release", "usr/lib/os-release"}
+
+type osReleaseCollector struct {
+	infoDesc   *prometheus.Desc
+	errorsDesc *prometheus.Desc
+}
+
+// NewOSCollector returns a new Collector exposing os-release information.
+func NewOSCollector() (prometheus.Collector, error) {
+	return &osReleaseCollector{
+		infoDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "os", "info"),
+			"A metric with a constant '1' value labeled by id, version_id and pretty_name from os-release.",
+			[]string{"id", "version_id", "pretty_name"}, nil,
+		),
+		errorsDesc: newScrapeErrorsDesc("os_release"),
+	}, nil
+}
+
+// parseOSRelease parses the os-release key/value pairs of r. Values may
+// be unquoted, single or double quoted.
+func parseOSRelease(r io.Reader) (map[string]string, error) {
+	return godotenv.Parse(r)
+}
+
+// readOSRelease reads the first os-release file found under the rootfs.
+// Returns os.ErrNotExist if there is none, i.e. in minimal containers.
+func readOSRelease() (map[string]string, error) {
+	for _, name := range osReleaseFilenames {
+		f, err := os.Open(rootfsFilePath(name))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		release, err := parseOSRelease(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+
+		return release, nil
+	}
+
+	return nil, os.ErrNotExist
+}
+
+func (c *osReleaseCollector) Collect(ch chan<- prometheus.Metric) {
+	release, err := readOSRelease()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			zap.S().Debugw("os-release not found, skipping os info", "rootfs", rootfsPath)
+
+			return
+		}
+
+		collectErrors(ch, c.errorsDesc, err)
+
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.infoDesc, prometheus.GaugeValue, 1,
+		release["ID"],
+		release["VERSION_ID"],
+		release["PRETTY_NAME"],
+	)
+}
+
+func (c *osReleaseCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.infoDesc
+	ch <- c.errorsDesc
+}
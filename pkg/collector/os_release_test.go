@@ -0,0 +1,74 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noosrelease
+// +build !noosrelease
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOSRelease(t *testing.T) {
+	got, err := parseOSRelease(strings.NewReader(`# comment
+ID=debian
+VERSION_ID="11"
+PRETTY_NAME='Debian GNU/Linux 11 (bullseye)'
+NAME="Debian \"GNU\" Linux"
+`))
+	require.NoError(t, err)
+	require.Equal(t, "debian", got["ID"])
+	require.Equal(t, "11", got["VERSION_ID"])
+	require.Equal(t, "Debian GNU/Linux 11 (bullseye)", got["PRETTY_NAME"])
+	require.Equal(t, `Debian "GNU" Linux`, got["NAME"])
+}
+
+func TestOSReleaseCollector(t *testing.T) {
+	rootfsPathWas := rootfsPath
+	defer func() {
+		rootfsPath = rootfsPathWas
+	}()
+
+	c, err := NewOSCollector()
+	require.NoError(t, err)
+
+	// falls back to usr/lib/os-release
+	rootfsPath = "fixtures"
+	want := `# HELP node_os_info A metric with a constant '1' value labeled by id, version_id and pretty_name from os-release.
+# TYPE node_os_info gauge
+node_os_info{id="ubuntu",pretty_name="Ubuntu 20.04.2 LTS",version_id="20.04"} 1
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want), "node_os_info"))
+
+	// etc/os-release takes precedence
+	rootfsPath = t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(rootfsPath, "etc"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(rootfsPath, "etc", "os-release"),
+		[]byte("ID=alpine\nVERSION_ID=3.16.2\nPRETTY_NAME=\"Alpine Linux v3.16\"\n"), 0o644))
+	want = `# HELP node_os_info A metric with a constant '1' value labeled by id, version_id and pretty_name from os-release.
+# TYPE node_os_info gauge
+node_os_info{id="alpine",pretty_name="Alpine Linux v3.16",version_id="3.16.2"} 1
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want), "node_os_info"))
+
+	// missing files yield no data and no errors
+	rootfsPath = t.TempDir()
+	require.Equal(t, 0, testutil.CollectAndCount(c))
+}
@@ -19,13 +19,16 @@ import (
 	"strings"
 
 	"github.com/prometheus/procfs"
-	"github.com/prometheus/procfs/sysfs"
 )
 
+// defaultSysPath sysfs mount point, the procfs/sysfs package only builds on
+// linux.
+const defaultSysPath = "/sys"
+
 var (
 	// The path of the proc filesystem.
 	procPath   = procfs.DefaultMountPoint
-	sysPath    = sysfs.DefaultMountPoint
+	sysPath    = defaultSysPath
 	rootfsPath = "/"
 )
 
@@ -34,7 +37,7 @@ var (
 // where the host filesystem is mounted as ro.
 func DefineFsPathFlags(flags *flag.FlagSet) {
 	flags.StringVar(&procPath, "procfs", procfs.DefaultMountPoint, "procfs mountpoint used by Prometheus node exporter collectors.")
-	flags.StringVar(&sysPath, "sysfs", sysfs.DefaultMountPoint, "sysfs mountpoint used by Prometheus node exporter collectors.")
+	flags.StringVar(&sysPath, "sysfs", defaultSysPath, "sysfs mountpoint used by Prometheus node exporter collectors.")
 	flags.StringVar(&rootfsPath, "rootfs", "/", "rootfs mountpoint used by Prometheus node exporter collectors.")
 }
 
@@ -19,7 +19,6 @@ import (
 	"testing"
 
 	"github.com/prometheus/procfs"
-	"github.com/prometheus/procfs/sysfs"
 	"github.com/stretchr/testify/require"
 )
 
@@ -55,7 +54,7 @@ func TestOverrideFSPaths(t *testing.T) {
 			flag.NewFlagSet("metrikad", flag.ContinueOnError),
 			[]string{},
 			procfs.DefaultMountPoint,
-			sysfs.DefaultMountPoint,
+			defaultSysPath,
 			"/",
 		},
 		{
@@ -0,0 +1,159 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nopowersupply
+// +build !nopowersupply
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs/sysfs"
+	"go.uber.org/zap"
+)
+
+// powerSupplyMetric a numeric power supply attribute, exported scaled
+// from its sysfs unit.
+type powerSupplyMetric struct {
+	desc  *prometheus.Desc
+	value func(ps sysfs.PowerSupply) *int64
+	scale float64
+}
+
+type powerSupplyCollector struct {
+	fs         sysfs.FS
+	metrics    []powerSupplyMetric
+	statusDesc *prometheus.Desc
+	errorsDesc *prometheus.Desc
+}
+
+// NewPowerSupplyCollector returns a new Collector exposing battery and AC
+// adapter status from /sys/class/power_supply.
+func NewPowerSupplyCollector() (prometheus.Collector, error) {
+	fs, err := sysfs.NewFS(sysPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sysfs: %w", err)
+	}
+
+	newDesc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "power_supply", name),
+			help,
+			[]string{"power_supply"}, nil,
+		)
+	}
+
+	// sysfs reports µAh, µWh, µV and µW
+	return &powerSupplyCollector{
+		fs: fs,
+		metrics: []powerSupplyMetric{
+			{
+				desc:  newDesc("online", "Value is 1 if the power supply is online (i.e. AC connected), 0 otherwise."),
+				value: func(ps sysfs.PowerSupply) *int64 { return ps.Online },
+				scale: 1,
+			},
+			{
+				desc:  newDesc("capacity_percent", "Remaining capacity in percent."),
+				value: func(ps sysfs.PowerSupply) *int64 { return ps.Capacity },
+				scale: 1,
+			},
+			{
+				desc:  newDesc("charge_ampere_hours", "Current charge in ampere hours."),
+				value: func(ps sysfs.PowerSupply) *int64 { return ps.ChargeNow },
+				scale: 1e-6,
+			},
+			{
+				desc:  newDesc("charge_full_ampere_hours", "Charge when full in ampere hours."),
+				value: func(ps sysfs.PowerSupply) *int64 { return ps.ChargeFull },
+				scale: 1e-6,
+			},
+			{
+				desc:  newDesc("energy_watt_hours", "Current energy in watt hours."),
+				value: func(ps sysfs.PowerSupply) *int64 { return ps.EnergyNow },
+				scale: 1e-6,
+			},
+			{
+				desc:  newDesc("energy_full_watt_hours", "Energy when full in watt hours."),
+				value: func(ps sysfs.PowerSupply) *int64 { return ps.EnergyFull },
+				scale: 1e-6,
+			},
+			{
+				desc:  newDesc("voltage_volts", "Current voltage in volts."),
+				value: func(ps sysfs.PowerSupply) *int64 { return ps.VoltageNow },
+				scale: 1e-6,
+			},
+			{
+				desc:  newDesc("power_watts", "Current power draw in watts."),
+				value: func(ps sysfs.PowerSupply) *int64 { return ps.PowerNow },
+				scale: 1e-6,
+			},
+		},
+		statusDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "power_supply", "status_info"),
+			"Power supply status (i.e. Charging, Discharging, Full), value is always 1.",
+			[]string{"power_supply", "status"}, nil,
+		),
+		errorsDesc: newScrapeErrorsDesc("power_supply"),
+	}, nil
+}
+
+func (c *powerSupplyCollector) Collect(ch chan<- prometheus.Metric) {
+	supplies, err := c.fs.PowerSupplyClass()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			zap.S().Debugw("power supply metrics are not available for this system")
+
+			return
+		}
+
+		collectErrors(ch, c.errorsDesc, err)
+
+		return
+	}
+
+	names := make([]string, 0, len(supplies))
+	for name := range supplies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ps := supplies[name]
+
+		for _, m := range c.metrics {
+			v := m.value(ps)
+			if v == nil {
+				continue
+			}
+
+			ch <- prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, float64(*v)*m.scale, name)
+		}
+
+		if ps.Status != "" {
+			ch <- prometheus.MustNewConstMetric(c.statusDesc, prometheus.GaugeValue, 1, name, ps.Status)
+		}
+	}
+}
+
+func (c *powerSupplyCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		ch <- m.desc
+	}
+	ch <- c.statusDesc
+	ch <- c.errorsDesc
+}
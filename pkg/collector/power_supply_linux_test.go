@@ -0,0 +1,72 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nopowersupply
+// +build !nopowersupply
+
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPowerSupplyCollector(t *testing.T) {
+	sysPathWas := sysPath
+	defer func() {
+		sysPath = sysPathWas
+	}()
+	sysPath = "fixtures/sys"
+
+	c, err := NewPowerSupplyCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_power_supply_capacity_percent Remaining capacity in percent.
+# TYPE node_power_supply_capacity_percent gauge
+node_power_supply_capacity_percent{power_supply="BAT0"} 81
+# HELP node_power_supply_energy_full_watt_hours Energy when full in watt hours.
+# TYPE node_power_supply_energy_full_watt_hours gauge
+node_power_supply_energy_full_watt_hours{power_supply="BAT0"} 45.07
+# HELP node_power_supply_energy_watt_hours Current energy in watt hours.
+# TYPE node_power_supply_energy_watt_hours gauge
+node_power_supply_energy_watt_hours{power_supply="BAT0"} 36.58
+# HELP node_power_supply_online Value is 1 if the power supply is online (i.e. AC connected), 0 otherwise.
+# TYPE node_power_supply_online gauge
+node_power_supply_online{power_supply="AC"} 0
+# HELP node_power_supply_power_watts Current power draw in watts.
+# TYPE node_power_supply_power_watts gauge
+node_power_supply_power_watts{power_supply="BAT0"} 5.002
+# HELP node_power_supply_status_info Power supply status (i.e. Charging, Discharging, Full), value is always 1.
+# TYPE node_power_supply_status_info gauge
+node_power_supply_status_info{power_supply="BAT0",status="Discharging"} 1
+# HELP node_power_supply_voltage_volts Current voltage in volts.
+# TYPE node_power_supply_voltage_volts gauge
+node_power_supply_voltage_volts{power_supply="BAT0"} 11.66
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestPowerSupplyCollector_NoSupplies(t *testing.T) {
+	sysPathWas := sysPath
+	defer func() {
+		sysPath = sysPathWas
+	}()
+	sysPath = t.TempDir()
+
+	c, err := NewPowerSupplyCollector()
+	require.NoError(t, err)
+	require.Equal(t, 0, testutil.CollectAndCount(c))
+}
@@ -0,0 +1,294 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noprocesses
+// +build !noprocesses
+
+package collector
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+// processStates process states always exported, even if no process is in
+// them, so alerts on i.e. zombies have a series to evaluate.
+var processStates = []string{"R", "S", "D", "Z", "T"}
+
+// processesMaxProcs Max number of processes whose state and threads are
+// read per scrape, the remaining ones are only counted.
+// collector.processes.max-procs
+var processesMaxProcs = 20000
+
+// DefineProcessesFlags defines the flags of the processes collector.
+func DefineProcessesFlags(flags *flag.FlagSet) {
+	flags.IntVar(&processesMaxProcs, "collector.processes.max-procs", processesMaxProcs,
+		"Max number of processes whose state and threads are read per scrape, the remaining ones are only counted.")
+}
+
+type processesCollector struct {
+	fs procfs.FS
+
+	// buf reused for reading every /proc/[pid]/stat file.
+	mu  sync.Mutex
+	buf []byte
+
+	running    *prometheus.Desc
+	blocked    *prometheus.Desc
+	pids       *prometheus.Desc
+	state      *prometheus.Desc
+	threads    *prometheus.Desc
+	zombies    *prometheus.Desc
+	truncated  *prometheus.Desc
+	errorsDesc *prometheus.Desc
+}
+
+// processStats results of a /proc walk.
+type processStats struct {
+	pids      int
+	states    map[string]int
+	threads   int
+	truncated bool
+}
+
+// NewProcessesCollector returns a new Collector exposing process counts
+// from /proc/stat and a breakdown of process states from /proc/[pid]/stat.
+func NewProcessesCollector() (prometheus.Collector, error) {
+	fs, err := procfs.NewFS(procPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open procfs: %w", err)
+	}
+
+	return &processesCollector{
+		fs:  fs,
+		buf: make([]byte, 4096),
+		running: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "processes", "procs_running"),
+			"Number of processes in runnable state.",
+			nil, nil,
+		),
+		blocked: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "processes", "procs_blocked"),
+			"Number of processes blocked waiting for I/O to complete.",
+			nil, nil,
+		),
+		pids: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "processes", "pids"),
+			"Number of processes.",
+			nil, nil,
+		),
+		state: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "processes", "state"),
+			"Number of processes in each state.",
+			[]string{"state"}, nil,
+		),
+		threads: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "processes", "threads"),
+			"Number of threads of the processes whose state was read.",
+			nil, nil,
+		),
+		zombies: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "processes", "zombies"),
+			"Number of zombie processes.",
+			nil, nil,
+		),
+		truncated: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "processes", "state_truncated"),
+			"1 if there are more processes than collector.processes.max-procs, states and threads only cover the first ones.",
+			nil, nil,
+		),
+		errorsDesc: newScrapeErrorsDesc("processes"),
+	}, nil
+}
+
+func (c *processesCollector) Collect(ch chan<- prometheus.Metric) {
+	errs := &multiError{}
+
+	stats, err := c.fs.Stat()
+	if err != nil {
+		errs.Add("stat", fmt.Errorf("failed to get stat: %w", err))
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.running, prometheus.GaugeValue, float64(stats.ProcessesRunning))
+		ch <- prometheus.MustNewConstMetric(c.blocked, prometheus.GaugeValue, float64(stats.ProcessesBlocked))
+	}
+
+	procs, err := c.walk(processesMaxProcs)
+	if err != nil {
+		errs.Add("processes", err)
+		collectErrors(ch, c.errorsDesc, errs.ErrorOrNil())
+
+		return
+	}
+
+	states := make([]string, 0, len(procs.states))
+	for state := range procs.states {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+
+	for _, state := range states {
+		ch <- prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, float64(procs.states[state]), state)
+	}
+
+	truncated := 0.0
+	if procs.truncated {
+		truncated = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.pids, prometheus.GaugeValue, float64(procs.pids))
+	ch <- prometheus.MustNewConstMetric(c.threads, prometheus.GaugeValue, float64(procs.threads))
+	ch <- prometheus.MustNewConstMetric(c.zombies, prometheus.GaugeValue, float64(procs.states["Z"]))
+	ch <- prometheus.MustNewConstMetric(c.truncated, prometheus.GaugeValue, truncated)
+
+	collectErrors(ch, c.errorsDesc, errs.ErrorOrNil())
+}
+
+// walk counts the processes in /proc, reading the state and threads of at
+// most max of them.
+func (c *processesCollector) walk(max int) (processStats, error) {
+	stats := processStats{states: make(map[string]int, len(processStates))}
+	for _, state := range processStates {
+		stats.states[state] = 0
+	}
+
+	d, err := os.Open(procPath)
+	if err != nil {
+		return stats, err
+	}
+	defer d.Close()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// read the directory in batches, bounding memory on hosts with many
+	// processes
+	for {
+		names, err := d.Readdirnames(1024)
+		for _, name := range names {
+			if !isPid(name) {
+				continue
+			}
+			stats.pids++
+
+			if stats.pids > max {
+				stats.truncated = true
+
+				continue
+			}
+
+			state, threads, err := c.readProcStat(filepath.Join(procPath, name, "stat"))
+			if err != nil {
+				// exited meanwhile
+				continue
+			}
+			stats.states[state]++
+			stats.threads += threads
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, nil
+}
+
+func isPid(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '0' || name[i] > '9' {
+			return false
+		}
+	}
+
+	return name != ""
+}
+
+// readProcStat returns the state and number of threads of a process, read
+// into the collector's buffer.
+func (c *processesCollector) readProcStat(path string) (string, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	n := 0
+	for n < len(c.buf) {
+		m, err := f.Read(c.buf[n:])
+		n += m
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return "", 0, err
+		}
+		if m == 0 {
+			break
+		}
+	}
+
+	return parseProcStat(c.buf[:n])
+}
+
+// parseProcStat parses the state (3rd field) and number of threads (20th
+// field) of /proc/[pid]/stat. The command name (2nd field) may contain
+// spaces and parentheses, fields are counted from its closing one.
+func parseProcStat(b []byte) (string, int, error) {
+	i := bytes.LastIndexByte(b, ')')
+	if i < 0 || i+2 >= len(b) {
+		return "", 0, fmt.Errorf("malformed process stat: %w", ErrParse)
+	}
+	fields := b[i+2:]
+	state := string(fields[:1])
+
+	// skip to the 20th field, 17 fields after the state
+	for skip := 0; skip < 17; skip++ {
+		j := bytes.IndexByte(fields, ' ')
+		if j < 0 {
+			return "", 0, fmt.Errorf("malformed process stat: %w", ErrParse)
+		}
+		fields = fields[j+1:]
+	}
+
+	threads := 0
+	for _, b := range fields {
+		if b < '0' || b > '9' {
+			break
+		}
+		threads = threads*10 + int(b-'0')
+	}
+
+	return state, threads, nil
+}
+
+func (c *processesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.running
+	ch <- c.blocked
+	ch <- c.pids
+	ch <- c.state
+	ch <- c.threads
+	ch <- c.zombies
+	ch <- c.truncated
+	ch <- c.errorsDesc
+}
@@ -0,0 +1,110 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noprocesses
+// +build !noprocesses
+
+package collector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessesCollector(t *testing.T) {
+	procPathWas := procPath
+	defer func() {
+		procPath = procPathWas
+	}()
+	procPath = "fixtures/proc"
+
+	c, err := NewProcessesCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_processes_pids Number of processes.
+# TYPE node_processes_pids gauge
+node_processes_pids 3
+# HELP node_processes_procs_blocked Number of processes blocked waiting for I/O to complete.
+# TYPE node_processes_procs_blocked gauge
+node_processes_procs_blocked 0
+# HELP node_processes_procs_running Number of processes in runnable state.
+# TYPE node_processes_procs_running gauge
+node_processes_procs_running 2
+# HELP node_processes_state Number of processes in each state.
+# TYPE node_processes_state gauge
+node_processes_state{state="D"} 0
+node_processes_state{state="I"} 1
+node_processes_state{state="R"} 0
+node_processes_state{state="S"} 2
+node_processes_state{state="T"} 0
+node_processes_state{state="Z"} 0
+# HELP node_processes_state_truncated 1 if there are more processes than collector.processes.max-procs, states and threads only cover the first ones.
+# TYPE node_processes_state_truncated gauge
+node_processes_state_truncated 0
+# HELP node_processes_threads Number of threads of the processes whose state was read.
+# TYPE node_processes_threads gauge
+node_processes_threads 3
+# HELP node_processes_zombies Number of zombie processes.
+# TYPE node_processes_zombies gauge
+node_processes_zombies 0
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestProcessesCollector_MaxProcs(t *testing.T) {
+	procPathWas, maxProcsWas := procPath, processesMaxProcs
+	defer func() {
+		procPath, processesMaxProcs = procPathWas, maxProcsWas
+	}()
+	procPath = t.TempDir()
+	processesMaxProcs = 3
+
+	for pid := 1; pid <= 5; pid++ {
+		require.NoError(t, os.Mkdir(filepath.Join(procPath, fmt.Sprint(pid)), 0o755))
+		stat := fmt.Sprintf("%d (sidecar (x)) Z 1 1 1 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 %d 0 29 0 0", pid, pid)
+		require.NoError(t, os.WriteFile(filepath.Join(procPath, fmt.Sprint(pid), "stat"), []byte(stat), 0o644))
+	}
+
+	c, err := NewProcessesCollector()
+	require.NoError(t, err)
+	procs, err := c.(*processesCollector).walk(processesMaxProcs)
+	require.NoError(t, err)
+
+	require.Equal(t, 5, procs.pids)
+	require.True(t, procs.truncated)
+	require.Equal(t, 3, procs.states["Z"])
+	require.Equal(t, 0, procs.states["S"])
+
+	// /proc/stat is missing, the walk is still exported
+	want := `# HELP node_processes_zombies Number of zombie processes.
+# TYPE node_processes_zombies gauge
+node_processes_zombies 3
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want), "node_processes_zombies"))
+}
+
+func TestParseProcStat(t *testing.T) {
+	state, threads, err := parseProcStat([]byte("42 (a b) c)) D 1 1 1 0 -1 0 0 0 0 0 0 0 0 0 20 0 12 0 29 0 0\n"))
+	require.NoError(t, err)
+	require.Equal(t, "D", state)
+	require.Equal(t, 12, threads)
+
+	_, _, err = parseProcStat([]byte("42 (a) S 1 1"))
+	require.ErrorIs(t, err, ErrParse)
+}
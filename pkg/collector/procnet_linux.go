@@ -0,0 +1,134 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// tcpStateListen TCP_LISTEN as numbered in include/net/tcp_states.h.
+const tcpStateListen = 10
+
+// procNetSocket the fields of a /proc/net/{tcp,udp}{,6} socket line read by
+// the collectors.
+type procNetSocket struct {
+	state   uint64
+	txQueue uint64
+	rxQueue uint64
+}
+
+// scanProcNetSockets calls fn for each socket listed in r, in
+// /proc/net/{tcp,udp}{,6} format. The input is streamed line by line, busy
+// hosts can list hundreds of thousands of sockets.
+func scanProcNetSockets(r io.Reader, fn func(procNetSocket) error) error {
+	scanner := bufio.NewScanner(r)
+
+	// skip header
+	if !scanner.Scan() {
+		return scanner.Err()
+	}
+
+	for scanner.Scan() {
+		socket, err := parseProcNetSocketLine(scanner.Bytes())
+		if err != nil {
+			return err
+		}
+		if err := fn(socket); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// parseProcNetSocketLine parses a socket line, i.e.
+//
+//	0: 0F02000A:0016 0202000A:8B6B 01 00000015:00000001 02:000AC99B ...
+//
+// Only the state and the tx/rx queues are read.
+func parseProcNetSocketLine(line []byte) (procNetSocket, error) {
+	// skip sl, local_address and rem_address
+	rest := line
+	for i := 0; i < 3; i++ {
+		_, rest = nextField(rest)
+	}
+
+	st, rest := nextField(rest)
+	queues, _ := nextField(rest)
+
+	state, ok := parseHexUint(st)
+	if !ok {
+		return procNetSocket{}, fmt.Errorf("invalid socket state %q: %w", st, ErrParse)
+	}
+
+	sep := bytes.IndexByte(queues, ':')
+	if sep < 0 {
+		return procNetSocket{}, fmt.Errorf("invalid socket queues %q: %w", queues, ErrParse)
+	}
+
+	tx, ok := parseHexUint(queues[:sep])
+	if !ok {
+		return procNetSocket{}, fmt.Errorf("invalid socket tx_queue %q: %w", queues, ErrParse)
+	}
+
+	rx, ok := parseHexUint(queues[sep+1:])
+	if !ok {
+		return procNetSocket{}, fmt.Errorf("invalid socket rx_queue %q: %w", queues, ErrParse)
+	}
+
+	return procNetSocket{state: state, txQueue: tx, rxQueue: rx}, nil
+}
+
+// nextField returns the first space separated field of b and what follows it.
+func nextField(b []byte) (field, rest []byte) {
+	start := 0
+	for start < len(b) && b[start] == ' ' {
+		start++
+	}
+
+	end := start
+	for end < len(b) && b[end] != ' ' {
+		end++
+	}
+
+	return b[start:end], b[end:]
+}
+
+// parseHexUint parses a non-empty hexadecimal number of at most 16 digits
+// without allocating.
+func parseHexUint(b []byte) (uint64, bool) {
+	if len(b) == 0 || len(b) > 16 {
+		return 0, false
+	}
+
+	var n uint64
+	for _, c := range b {
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c -= 'a' - 10
+		case 'A' <= c && c <= 'F':
+			c -= 'A' - 10
+		default:
+			return 0, false
+		}
+		n = n<<4 | uint64(c)
+	}
+
+	return n, true
+}
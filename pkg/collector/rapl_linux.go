@@ -0,0 +1,142 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !norapl
+// +build !norapl
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs/sysfs"
+	"go.uber.org/zap"
+)
+
+// raplReadEnergy reads the energy counter of a zone, overridden by tests.
+var raplReadEnergy = func(zone sysfs.RaplZone) (uint64, error) {
+	return zone.GetEnergyMicrojoules()
+}
+
+// raplCounter keeps a zone's energy counter monotonic across wraps of the
+// hardware counter at max_energy_range_uj.
+type raplCounter struct {
+	last  uint64
+	total uint64
+}
+
+type raplCollector struct {
+	fs         sysfs.FS
+	energyDesc *prometheus.Desc
+	errorsDesc *prometheus.Desc
+
+	mu       sync.Mutex
+	counters map[string]*raplCounter
+}
+
+// NewRaplCollector returns a new Collector exposing the energy consumed by
+// Intel RAPL domains, from /sys/class/powercap/intel-rapl*.
+func NewRaplCollector() (prometheus.Collector, error) {
+	fs, err := sysfs.NewFS(sysPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sysfs: %w", err)
+	}
+
+	return &raplCollector{
+		fs: fs,
+		energyDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "rapl", "energy_microjoules_total"),
+			"Energy consumed by the RAPL domain in microjoules.",
+			[]string{"zone", "domain"}, nil,
+		),
+		errorsDesc: newScrapeErrorsDesc("rapl"),
+		counters:   make(map[string]*raplCounter),
+	}, nil
+}
+
+func (c *raplCollector) Collect(ch chan<- prometheus.Metric) {
+	zones, err := sysfs.GetRaplZones(c.fs)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			zap.S().Debugw("rapl metrics are not available for this system")
+
+			return
+		}
+
+		collectErrors(ch, c.errorsDesc, err)
+
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counters := make(map[string]*raplCounter, len(zones))
+	errs := &multiError{}
+	for _, zone := range zones {
+		zoneName := filepath.Base(zone.Path)
+
+		energy, err := raplReadEnergy(zone)
+		if err != nil {
+			// energy_uj is only readable by root since the PLATYPUS
+			// side-channel mitigation (CVE-2020-8694).
+			if errors.Is(err, os.ErrPermission) {
+				zap.S().Debugw("rapl energy counters are not readable, the agent must run as root", zap.Error(err))
+
+				return
+			}
+
+			errs.Add(zoneName, err)
+
+			continue
+		}
+
+		counter, ok := c.counters[zone.Path]
+		if !ok {
+			counter = &raplCounter{last: energy, total: energy}
+		} else {
+			counter.add(energy, zone.MaxMicrojoules)
+		}
+		counters[zone.Path] = counter
+
+		ch <- prometheus.MustNewConstMetric(c.energyDesc, prometheus.CounterValue, float64(counter.total), zoneName, zone.Name)
+	}
+
+	// zones gone since the last scrape are dropped
+	c.counters = counters
+
+	if err := errs.ErrorOrNil(); err != nil {
+		collectErrors(ch, c.errorsDesc, err)
+	}
+}
+
+func (c *raplCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.energyDesc
+	ch <- c.errorsDesc
+}
+
+// add accounts the energy read since the last one, the hardware counter
+// restarting from 0 once it reaches maxEnergy.
+func (r *raplCounter) add(energy, maxEnergy uint64) {
+	if energy >= r.last {
+		r.total += energy - r.last
+	} else {
+		r.total += maxEnergy - r.last + energy
+	}
+	r.last = energy
+}
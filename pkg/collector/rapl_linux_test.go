@@ -0,0 +1,111 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !norapl
+// +build !norapl
+
+package collector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/procfs/sysfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRaplCollector(t *testing.T) {
+	sysPathWas := sysPath
+	defer func() {
+		sysPath = sysPathWas
+	}()
+	sysPath = "fixtures/sys"
+
+	c, err := NewRaplCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_rapl_energy_microjoules_total Energy consumed by the RAPL domain in microjoules.
+# TYPE node_rapl_energy_microjoules_total counter
+node_rapl_energy_microjoules_total{domain="core",zone="intel-rapl:0:0"} 1.18821284256e+11
+node_rapl_energy_microjoules_total{domain="package",zone="intel-rapl:0"} 2.40422366267e+11
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestRaplCollector_Wrap(t *testing.T) {
+	sysPathWas := sysPath
+	readEnergyWas := raplReadEnergy
+	defer func() {
+		sysPath = sysPathWas
+		raplReadEnergy = readEnergyWas
+	}()
+	sysPath = t.TempDir()
+
+	zone := filepath.Join(sysPath, "class", "powercap", "intel-rapl:0")
+	require.NoError(t, os.MkdirAll(zone, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(zone, "name"), []byte("package-0\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(zone, "max_energy_range_uj"), []byte("1000\n"), 0o644))
+
+	// 900 -> 950 -> wraps to 50 -> 100
+	readings := []uint64{900, 950, 50, 100}
+	raplReadEnergy = func(sysfs.RaplZone) (uint64, error) {
+		energy := readings[0]
+		readings = readings[1:]
+
+		return energy, nil
+	}
+
+	c, err := NewRaplCollector()
+	require.NoError(t, err)
+
+	for _, total := range []int{900, 950, 1050, 1100} {
+		want := fmt.Sprintf(`# HELP node_rapl_energy_microjoules_total Energy consumed by the RAPL domain in microjoules.
+# TYPE node_rapl_energy_microjoules_total counter
+node_rapl_energy_microjoules_total{domain="package",zone="intel-rapl:0"} %d
+`, total)
+		require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+	}
+}
+
+func TestRaplCollector_PermissionDenied(t *testing.T) {
+	sysPathWas := sysPath
+	readEnergyWas := raplReadEnergy
+	defer func() {
+		sysPath = sysPathWas
+		raplReadEnergy = readEnergyWas
+	}()
+	sysPath = "fixtures/sys"
+	raplReadEnergy = func(zone sysfs.RaplZone) (uint64, error) {
+		return 0, &os.PathError{Op: "open", Path: filepath.Join(zone.Path, "energy_uj"), Err: os.ErrPermission}
+	}
+
+	c, err := NewRaplCollector()
+	require.NoError(t, err)
+	require.Equal(t, 0, testutil.CollectAndCount(c))
+}
+
+func TestRaplCollector_NoZones(t *testing.T) {
+	sysPathWas := sysPath
+	defer func() {
+		sysPath = sysPathWas
+	}()
+	sysPath = t.TempDir()
+
+	c, err := NewRaplCollector()
+	require.NoError(t, err)
+	require.Equal(t, 0, testutil.CollectAndCount(c))
+}
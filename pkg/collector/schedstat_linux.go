@@ -0,0 +1,95 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noschedstat
+// +build !noschedstat
+
+package collector
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+type schedstatCollector struct {
+	fs         procfs.FS
+	running    *prometheus.Desc
+	waiting    *prometheus.Desc
+	timeslices *prometheus.Desc
+	errorsDesc *prometheus.Desc
+}
+
+// NewSchedstatCollector returns a new Collector exposing per-CPU scheduler
+// statistics from /proc/schedstat.
+func NewSchedstatCollector() (prometheus.Collector, error) {
+	fs, err := procfs.NewFS(procPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open procfs: %w", err)
+	}
+
+	return &schedstatCollector{
+		fs: fs,
+		running: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "schedstat", "running_seconds_total"),
+			"Number of seconds CPU spent running a process.",
+			[]string{"cpu"}, nil,
+		),
+		waiting: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "schedstat", "waiting_seconds_total"),
+			"Number of seconds spent by processes waiting for this CPU.",
+			[]string{"cpu"}, nil,
+		),
+		timeslices: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "schedstat", "timeslices_total"),
+			"Number of timeslices executed by CPU.",
+			[]string{"cpu"}, nil,
+		),
+		errorsDesc: newScrapeErrorsDesc("schedstat"),
+	}, nil
+}
+
+// Collect exports the per-CPU running, waiting and timeslice counters.
+// Running and waiting times are reported in nanoseconds since CFS was
+// introduced (2.6.23), although the kernel documentation states jiffies.
+func (c *schedstatCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := c.fs.Schedstat()
+	if err != nil {
+		collectErrors(ch, c.errorsDesc, fmt.Errorf("failed to get schedstat: %w", err))
+
+		return
+	}
+
+	if len(stats.CPUs) == 0 {
+		collectErrors(ch, c.errorsDesc, fmt.Errorf("no CPU in schedstat: %w", ErrNoData))
+
+		return
+	}
+
+	for _, cpu := range stats.CPUs {
+		ch <- prometheus.MustNewConstMetric(c.running, prometheus.CounterValue,
+			float64(cpu.RunningNanoseconds)/1e9, cpu.CPUNum)
+		ch <- prometheus.MustNewConstMetric(c.waiting, prometheus.CounterValue,
+			float64(cpu.WaitingNanoseconds)/1e9, cpu.CPUNum)
+		ch <- prometheus.MustNewConstMetric(c.timeslices, prometheus.CounterValue,
+			float64(cpu.RunTimeslices), cpu.CPUNum)
+	}
+}
+
+func (c *schedstatCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.running
+	ch <- c.waiting
+	ch <- c.timeslices
+	ch <- c.errorsDesc
+}
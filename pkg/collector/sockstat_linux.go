@@ -20,6 +20,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/procfs"
@@ -32,125 +34,113 @@ const (
 // Used for calculating the total memory bytes on TCP and UDP.
 var pageSize = os.Getpagesize()
 
-type sockStatCollector struct{}
+// sockStatHelp help of the per-protocol metrics, keyed by metric name. IPv4
+// and IPv6 values are exported under the same name with a protocol label.
+var sockStatHelp = map[string]string{
+	"tcp_inuse":         "Number of TCP sockets in use.",
+	"tcp_orphan":        "Number of orphaned TCP sockets.",
+	"tcp_tw":            "Number of TCP sockets in TIME_WAIT state.",
+	"tcp_alloc":         "Number of allocated TCP sockets.",
+	"tcp_mem_bytes":     "Memory used by TCP sockets in bytes.",
+	"udp_inuse":         "Number of UDP sockets in use.",
+	"udp_mem_bytes":     "Memory used by UDP sockets in bytes.",
+	"udplite_inuse":     "Number of UDP-Lite sockets in use.",
+	"raw_inuse":         "Number of raw sockets in use.",
+	"frag_inuse":        "Number of IP fragment reassembly queues in use.",
+	"frag_memory_bytes": "Memory used by IP fragment reassembly queues in bytes.",
+}
+
+type sockStatCollector struct {
+	usedDesc   *prometheus.Desc
+	descs      map[string]*prometheus.Desc
+	errorsDesc *prometheus.Desc
+}
 
 // NewSockStatCollector returns a new Collector exposing socket stats.
 func NewSockStatCollector() (prometheus.Collector, error) {
-	return &sockStatCollector{}, nil
+	c := &sockStatCollector{
+		usedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, sockStatSubsystem, "sockets_used"),
+			"Number of sockets in use.",
+			nil,
+			nil,
+		),
+		descs:      make(map[string]*prometheus.Desc, len(sockStatHelp)),
+		errorsDesc: newScrapeErrorsDesc("sockstat"),
+	}
+
+	for name, help := range sockStatHelp {
+		c.descs[name] = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, sockStatSubsystem, name),
+			help,
+			[]string{"protocol"},
+			nil,
+		)
+	}
+
+	return c, nil
 }
 
 func (c *sockStatCollector) Collect(ch chan<- prometheus.Metric) {
+	collectErrors(ch, c.errorsDesc, c.collect(ch))
+}
+
+func (c *sockStatCollector) collect(ch chan<- prometheus.Metric) error {
 	fs, err := procfs.NewFS(procPath)
 	if err != nil {
-		err = fmt.Errorf("failed to open procfs: %w", err)
-
-		return
+		return fmt.Errorf("failed to open procfs: %w", err)
 	}
 
+	errs := &multiError{}
+
 	// If IPv4 and/or IPv6 are disabled on this kernel, handle it gracefully.
 	stat4, err := fs.NetSockstat()
-	switch {
-	case err == nil:
-	case errors.Is(err, os.ErrNotExist):
-	default:
-		err = fmt.Errorf("failed to get IPv4 sockstat data: %w", err)
-
-		return
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		errs.Add("sockstat", fmt.Errorf("failed to get IPv4 sockstat data: %w", err))
 	}
 
 	stat6, err := fs.NetSockstat6()
-	switch {
-	case err == nil:
-	case errors.Is(err, os.ErrNotExist):
-	default:
-		err = fmt.Errorf("failed to get IPv6 sockstat data: %w", err)
-
-		return
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		errs.Add("sockstat6", fmt.Errorf("failed to get IPv6 sockstat data: %w", err))
 	}
 
-	stats := []struct {
-		isIPv6 bool
-		stat   *procfs.NetSockstat
-	}{
-		{
-			stat: stat4,
-		},
-		{
-			isIPv6: true,
-			stat:   stat6,
-		},
-	}
+	c.update(ch, "ipv4", stat4)
+	c.update(ch, "ipv6", stat6)
 
-	for _, s := range stats {
-		c.update(ch, s.isIPv6, s.stat)
-	}
+	return errs.ErrorOrNil()
 }
 
-func (c *sockStatCollector) update(ch chan<- prometheus.Metric, isIPv6 bool, s *procfs.NetSockstat) {
+func (c *sockStatCollector) update(ch chan<- prometheus.Metric, family string, s *procfs.NetSockstat) {
 	if s == nil {
 		// IPv6 disabled or similar; nothing to do.
 		return
 	}
 
-	// If sockstat contains the number of used sockets, export it.
-	if !isIPv6 && s.Used != nil {
-		ch <- prometheus.MustNewConstMetric(
-			prometheus.NewDesc(
-				prometheus.BuildFQName(namespace, sockStatSubsystem, "sockets_used"),
-				"Number of IPv4 sockets in use.",
-				nil,
-				nil,
-			),
-			prometheus.GaugeValue,
-			float64(*s.Used),
-		)
+	// Only the IPv4 file reports the number of used sockets, which
+	// accounts for all socket families.
+	if s.Used != nil {
+		ch <- prometheus.MustNewConstMetric(c.usedDesc, prometheus.GaugeValue, float64(*s.Used))
 	}
 
 	// A name and optional value for a sockstat metric.
 	type ssPair struct {
-		name string
-		v    *int
+		name  string
+		v     *int
+		scale int
 	}
 
-	// Previously these metric names were generated directly from the file output.
-	// In order to keep the same level of compatibility, we must map the fields
-	// to their correct names.
 	for _, p := range s.Protocols {
-		pairs := []ssPair{
-			{
-				name: "inuse",
-				v:    &p.InUse,
-			},
-			{
-				name: "orphan",
-				v:    p.Orphan,
-			},
-			{
-				name: "tw",
-				v:    p.TW,
-			},
-			{
-				name: "alloc",
-				v:    p.Alloc,
-			},
-			{
-				name: "mem",
-				v:    p.Mem,
-			},
-			{
-				name: "memory",
-				v:    p.Memory,
-			},
-		}
+		// sockstat6 protocols are suffixed with 6, i.e. TCP6
+		proto := strings.ToLower(strings.TrimSuffix(p.Protocol, "6"))
 
-		// Also export mem_bytes values for sockets which have a mem value
-		// stored in pages.
-		if p.Mem != nil {
-			v := *p.Mem * pageSize
-			pairs = append(pairs, ssPair{
-				name: "mem_bytes",
-				v:    &v,
-			})
+		pairs := []ssPair{
+			{name: "inuse", v: &p.InUse, scale: 1},
+			{name: "orphan", v: p.Orphan, scale: 1},
+			{name: "tw", v: p.TW, scale: 1},
+			{name: "alloc", v: p.Alloc, scale: 1},
+			// mem is reported in pages, memory in bytes
+			{name: "mem_bytes", v: p.Mem, scale: pageSize},
+			{name: "memory_bytes", v: p.Memory, scale: 1},
 		}
 
 		for _, pair := range pairs {
@@ -159,150 +149,28 @@ func (c *sockStatCollector) update(ch chan<- prometheus.Metric, isIPv6 bool, s *
 				continue
 			}
 
-			ch <- prometheus.MustNewConstMetric(
-				prometheus.NewDesc(
-					prometheus.BuildFQName(
-						namespace,
-						sockStatSubsystem,
-						fmt.Sprintf("%s_%s", p.Protocol, pair.name),
-					),
-					fmt.Sprintf("Number of %s sockets in state %s.", p.Protocol, pair.name),
-					nil,
-					nil,
-				),
-				prometheus.GaugeValue,
-				float64(*pair.v),
-			)
-		}
-	}
-}
-
-func (c *sockStatCollector) updateDesc(ch chan<- *prometheus.Desc, isIPv6 bool, s *procfs.NetSockstat) {
-	if s == nil {
-		// IPv6 disabled or similar; nothing to do.
-		return
-	}
-
-	// If sockstat contains the number of used sockets, export it.
-	if !isIPv6 && s.Used != nil {
-		ch <- prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, sockStatSubsystem, "sockets_used"),
-			"Number of IPv4 sockets in use.",
-			nil,
-			nil,
-		)
-	}
-
-	// A name and optional value for a sockstat metric.
-	type ssPair struct {
-		name string
-		v    *int
-	}
-
-	// Previously these metric names were generated directly from the file output.
-	// In order to keep the same level of compatibility, we must map the fields
-	// to their correct names.
-	for _, p := range s.Protocols {
-		pairs := []ssPair{
-			{
-				name: "inuse",
-				v:    &p.InUse,
-			},
-			{
-				name: "orphan",
-				v:    p.Orphan,
-			},
-			{
-				name: "tw",
-				v:    p.TW,
-			},
-			{
-				name: "alloc",
-				v:    p.Alloc,
-			},
-			{
-				name: "mem",
-				v:    p.Mem,
-			},
-			{
-				name: "memory",
-				v:    p.Memory,
-			},
-		}
-
-		// Also export mem_bytes values for sockets which have a mem value
-		// stored in pages.
-		if p.Mem != nil {
-			v := *p.Mem * pageSize
-			pairs = append(pairs, ssPair{
-				name: "mem_bytes",
-				v:    &v,
-			})
-		}
-
-		for _, pair := range pairs {
-			if pair.v == nil {
-				// This value is not set for this protocol; nothing to do.
+			desc, ok := c.descs[proto+"_"+pair.name]
+			if !ok {
 				continue
 			}
 
-			ch <- prometheus.NewDesc(
-				prometheus.BuildFQName(
-					namespace,
-					sockStatSubsystem,
-					fmt.Sprintf("%s_%s", p.Protocol, pair.name),
-				),
-				fmt.Sprintf("Number of %s sockets in state %s.", p.Protocol, pair.name),
-				nil,
-				nil,
-			)
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(*pair.v*pair.scale), family)
 		}
 	}
 }
 
 func (c *sockStatCollector) Describe(ch chan<- *prometheus.Desc) {
-	fs, err := procfs.NewFS(procPath)
-	if err != nil {
-		err = fmt.Errorf("failed to open procfs: %w", err)
+	ch <- c.usedDesc
 
-		return
+	names := make([]string, 0, len(c.descs))
+	for name := range c.descs {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	// If IPv4 and/or IPv6 are disabled on this kernel, handle it gracefully.
-	stat4, err := fs.NetSockstat()
-	switch {
-	case err == nil:
-	case errors.Is(err, os.ErrNotExist):
-	default:
-		err = fmt.Errorf("failed to get IPv4 sockstat data: %w", err)
-
-		return
+	for _, name := range names {
+		ch <- c.descs[name]
 	}
 
-	stat6, err := fs.NetSockstat6()
-	switch {
-	case err == nil:
-	case errors.Is(err, os.ErrNotExist):
-	default:
-		err = fmt.Errorf("failed to get IPv6 sockstat data: %w", err)
-
-		return
-	}
-
-	stats := []struct {
-		isIPv6 bool
-		stat   *procfs.NetSockstat
-	}{
-		{
-			stat: stat4,
-		},
-		{
-			isIPv6: true,
-			stat:   stat6,
-		},
-	}
-
-	for _, s := range stats {
-		c.updateDesc(ch, s.isIPv6, s.stat)
-	}
+	ch <- c.errorsDesc
 }
@@ -0,0 +1,109 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nosockstat
+// +build !nosockstat
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSockStatCollector(t *testing.T) {
+	procPathWas, pageSizeWas := procPath, pageSize
+	defer func() {
+		procPath, pageSize = procPathWas, pageSizeWas
+	}()
+	procPath = "fixtures/proc"
+	pageSize = 4096
+
+	c, err := NewSockStatCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_sockstat_frag_inuse Number of IP fragment reassembly queues in use.
+# TYPE node_sockstat_frag_inuse gauge
+node_sockstat_frag_inuse{protocol="ipv4"} 0
+node_sockstat_frag_inuse{protocol="ipv6"} 0
+# HELP node_sockstat_frag_memory_bytes Memory used by IP fragment reassembly queues in bytes.
+# TYPE node_sockstat_frag_memory_bytes gauge
+node_sockstat_frag_memory_bytes{protocol="ipv4"} 0
+node_sockstat_frag_memory_bytes{protocol="ipv6"} 0
+# HELP node_sockstat_raw_inuse Number of raw sockets in use.
+# TYPE node_sockstat_raw_inuse gauge
+node_sockstat_raw_inuse{protocol="ipv4"} 0
+node_sockstat_raw_inuse{protocol="ipv6"} 1
+# HELP node_sockstat_sockets_used Number of sockets in use.
+# TYPE node_sockstat_sockets_used gauge
+node_sockstat_sockets_used 229
+# HELP node_sockstat_tcp_alloc Number of allocated TCP sockets.
+# TYPE node_sockstat_tcp_alloc gauge
+node_sockstat_tcp_alloc{protocol="ipv4"} 17
+# HELP node_sockstat_tcp_inuse Number of TCP sockets in use.
+# TYPE node_sockstat_tcp_inuse gauge
+node_sockstat_tcp_inuse{protocol="ipv4"} 4
+node_sockstat_tcp_inuse{protocol="ipv6"} 17
+# HELP node_sockstat_tcp_mem_bytes Memory used by TCP sockets in bytes.
+# TYPE node_sockstat_tcp_mem_bytes gauge
+node_sockstat_tcp_mem_bytes{protocol="ipv4"} 4096
+# HELP node_sockstat_tcp_orphan Number of orphaned TCP sockets.
+# TYPE node_sockstat_tcp_orphan gauge
+node_sockstat_tcp_orphan{protocol="ipv4"} 0
+# HELP node_sockstat_tcp_tw Number of TCP sockets in TIME_WAIT state.
+# TYPE node_sockstat_tcp_tw gauge
+node_sockstat_tcp_tw{protocol="ipv4"} 4
+# HELP node_sockstat_udp_inuse Number of UDP sockets in use.
+# TYPE node_sockstat_udp_inuse gauge
+node_sockstat_udp_inuse{protocol="ipv4"} 0
+node_sockstat_udp_inuse{protocol="ipv6"} 9
+# HELP node_sockstat_udp_mem_bytes Memory used by UDP sockets in bytes.
+# TYPE node_sockstat_udp_mem_bytes gauge
+node_sockstat_udp_mem_bytes{protocol="ipv4"} 0
+# HELP node_sockstat_udplite_inuse Number of UDP-Lite sockets in use.
+# TYPE node_sockstat_udplite_inuse gauge
+node_sockstat_udplite_inuse{protocol="ipv4"} 0
+node_sockstat_udplite_inuse{protocol="ipv6"} 0
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestSockStatCollectorIPv6Disabled(t *testing.T) {
+	procPathWas := procPath
+	defer func() {
+		procPath = procPathWas
+	}()
+	procPath = t.TempDir()
+
+	netPath := filepath.Join(procPath, "net")
+	require.NoError(t, os.MkdirAll(netPath, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(netPath, "sockstat"), []byte("sockets: used 3\nTCP: inuse 1 orphan 0 tw 0 alloc 1 mem 0\n"), 0o644))
+
+	c, err := NewSockStatCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_sockstat_sockets_used Number of sockets in use.
+# TYPE node_sockstat_sockets_used gauge
+node_sockstat_sockets_used 3
+# HELP node_sockstat_tcp_inuse Number of TCP sockets in use.
+# TYPE node_sockstat_tcp_inuse gauge
+node_sockstat_tcp_inuse{protocol="ipv4"} 1
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want),
+		"node_sockstat_sockets_used", "node_sockstat_tcp_inuse", "node_scrape_collector_errors"))
+}
@@ -122,13 +122,9 @@ func (c *statCollector) Collect(ch chan<- prometheus.Metric) {
 	}
 }
 
+// Describe implements Collector. Descriptors are static and do not
+// require reading procfs.
 func (c *statCollector) Describe(ch chan<- *prometheus.Desc) {
-	stats, err := c.fs.Stat()
-	if err != nil {
-
-		return
-	}
-
 	ch <- c.intr
 	ch <- c.ctxt
 	ch <- c.forks
@@ -137,24 +133,6 @@ func (c *statCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.procsBlocked
 
 	if statSoftirqFlag {
-		si := stats.SoftIRQ
-
-		for range []struct {
-			name  string
-			value uint64
-		}{
-			{name: "hi", value: si.Hi},
-			{name: "timer", value: si.Timer},
-			{name: "net_tx", value: si.NetTx},
-			{name: "net_rx", value: si.NetRx},
-			{name: "block", value: si.Block},
-			{name: "block_iopoll", value: si.BlockIoPoll},
-			{name: "tasklet", value: si.Tasklet},
-			{name: "sched", value: si.Sched},
-			{name: "hrtimer", value: si.Hrtimer},
-			{name: "rcu", value: si.Rcu},
-		} {
-			ch <- c.softIRQ
-		}
+		ch <- c.softIRQ
 	}
 }
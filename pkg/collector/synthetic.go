@@ -0,0 +1,124 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"flag"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// SyntheticDeviceName name of the synthetic network device.
+	SyntheticDeviceName = "metrika_synthetic"
+
+	// SyntheticLabel label added to all series of the network collectors
+	// when the synthetic device is enabled, "true" for the synthetic device's
+	// series and "false" for real devices.
+	SyntheticLabel = "synthetic"
+)
+
+var (
+	// networkSyntheticDevice Emit a synthetic network device with deterministic
+	// values, used for verifying the metrics path end to end without exposing
+	// host data.
+	// collector.network.synthetic-device
+	networkSyntheticDevice = false
+
+	// syntheticEpoch reference time for the synthetic device's counters.
+	syntheticEpoch = time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// syntheticNow clock used for the synthetic device's counters.
+	syntheticNow = time.Now
+
+	// syntheticCollectorsFactory constructors of the synthetic device
+	// collectors, keyed by the collector they complement.
+	syntheticCollectorsFactory = map[Name]func() prometheus.Collector{
+		prometheusNetClass: newSyntheticNetClassCollector,
+		prometheusNetDev:   newSyntheticNetDevCollector,
+	}
+)
+
+// DefineSyntheticDeviceFlag defines the flag enabling the synthetic network device.
+func DefineSyntheticDeviceFlag(flags *flag.FlagSet) {
+	flags.BoolVar(&networkSyntheticDevice, "collector.network.synthetic-device", false,
+		"Emit a synthetic network device (device=\""+SyntheticDeviceName+"\") with fake values, for verifying metrics delivery.")
+}
+
+// SyntheticDeviceEnabled returns true if the synthetic network device is enabled.
+func SyntheticDeviceEnabled() bool {
+	return networkSyntheticDevice
+}
+
+// Register registers the collector c of the given name with reg, adding the
+// constant labels to its series. If the synthetic device is enabled and c
+// supports it, the synthetic device collector is registered along with it
+// and both are labeled accordingly.
+func Register(reg prometheus.Registerer, name Name, c prometheus.Collector) error {
+	reg = wrapConstLabels(reg)
+
+	newSynthetic, ok := syntheticCollectorsFactory[name]
+	if !networkSyntheticDevice || !ok {
+		return reg.Register(c)
+	}
+
+	if err := prometheus.WrapRegistererWith(prometheus.Labels{SyntheticLabel: "false"}, reg).Register(c); err != nil {
+		return err
+	}
+
+	return prometheus.WrapRegistererWith(prometheus.Labels{SyntheticLabel: "true"}, reg).Register(newSynthetic())
+}
+
+// syntheticCounter returns a counter increasing by perSecond since syntheticEpoch.
+func syntheticCounter(perSecond uint64) uint64 {
+	return uint64(syntheticNow().Sub(syntheticEpoch)/time.Second) * perSecond
+}
+
+// syntheticNetDevCollector emits the synthetic device's network statistics.
+type syntheticNetDevCollector struct {
+	*netDevCollector
+}
+
+func newSyntheticNetDevCollector() prometheus.Collector {
+	return syntheticNetDevCollector{
+		netDevCollector: &netDevCollector{
+			subsystem:   "network",
+			metricDescs: map[string]*prometheus.Desc{},
+		},
+	}
+}
+
+func (c syntheticNetDevCollector) stats() netDevStats {
+	return netDevStats{
+		SyntheticDeviceName: {
+			"receive_bytes":    syntheticCounter(1024),
+			"receive_packets":  syntheticCounter(2),
+			"receive_errs":     0,
+			"receive_drop":     0,
+			"transmit_bytes":   syntheticCounter(512),
+			"transmit_packets": syntheticCounter(1),
+			"transmit_errs":    0,
+			"transmit_drop":    0,
+		},
+	}
+}
+
+func (c syntheticNetDevCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collectStats(ch, c.stats())
+}
+
+func (c syntheticNetDevCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.describeStats(ch, c.stats())
+}
@@ -0,0 +1,55 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs/sysfs"
+)
+
+// syntheticNetClassCollector emits the synthetic device's network class info.
+type syntheticNetClassCollector struct {
+	*netClassCollector
+}
+
+func newSyntheticNetClassCollector() prometheus.Collector {
+	return syntheticNetClassCollector{
+		netClassCollector: &netClassCollector{
+			subsystem:   "network",
+			metricDescs: newNetClassDescs("network"),
+			errorsDesc:  newScrapeErrorsDesc("netclass"),
+		},
+	}
+}
+
+func (c syntheticNetClassCollector) Collect(ch chan<- prometheus.Metric) {
+	int64p := func(v int64) *int64 { return &v }
+
+	c.collectIfaces(ch, sysfs.NetClass{
+		SyntheticDeviceName: sysfs.NetClassIface{
+			Name:           SyntheticDeviceName,
+			Address:        "02:00:00:00:00:00",
+			Broadcast:      "ff:ff:ff:ff:ff:ff",
+			Duplex:         "full",
+			OperState:      "up",
+			IfAlias:        "metrika synthetic device",
+			Carrier:        int64p(1),
+			CarrierChanges: int64p(1),
+			MTU:            int64p(1500),
+			Speed:          int64p(1000),
+			TxQueueLen:     int64p(1000),
+			Type:           int64p(1),
+		},
+	})
+}
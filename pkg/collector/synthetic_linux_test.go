@@ -0,0 +1,105 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func setupSyntheticDevice(t *testing.T) {
+	t.Helper()
+
+	enabledWas, nowWas := networkSyntheticDevice, syntheticNow
+	procPathWas, sysPathWas := procPath, sysPath
+	excludeWas, ignoredWas, addressInfoWas := netdevDeviceExclude, netclassIgnoredDevices, netdevAddressInfo
+	t.Cleanup(func() {
+		networkSyntheticDevice, syntheticNow = enabledWas, nowWas
+		procPath, sysPath = procPathWas, sysPathWas
+		netdevDeviceExclude, netclassIgnoredDevices, netdevAddressInfo = excludeWas, ignoredWas, addressInfoWas
+	})
+
+	networkSyntheticDevice = true
+	syntheticNow = func() time.Time { return syntheticEpoch.Add(time.Hour) }
+	procPath, sysPath = "fixtures/proc", "fixtures/sys"
+	netdevAddressInfo = false
+}
+
+func newSyntheticTestRegistry(t *testing.T) *prometheus.Registry {
+	t.Helper()
+
+	reg := prometheus.NewPedanticRegistry()
+	for _, name := range []Name{prometheusNetDev, prometheusNetClass} {
+		c, err := CollectorsFactory[name]()
+		require.NoError(t, err)
+		require.NoError(t, Register(reg, name, c))
+	}
+
+	return reg
+}
+
+func TestSyntheticDevice(t *testing.T) {
+	setupSyntheticDevice(t)
+
+	// all real devices filtered out
+	netdevDeviceExclude, netclassIgnoredDevices = ".*", ".*"
+
+	want, err := os.ReadFile("fixtures/synthetic/network.out")
+	require.NoError(t, err)
+	require.NoError(t, testutil.GatherAndCompare(newSyntheticTestRegistry(t), strings.NewReader(string(want))))
+}
+
+func TestSyntheticDeviceWithRealDevices(t *testing.T) {
+	setupSyntheticDevice(t)
+	// only keep devices starting with "et", i.e. eth0
+	netdevDeviceExclude, netclassIgnoredDevices = "^(?:[^e]|e[^t])", "^(?:[^e]|e[^t])"
+
+	want := `# HELP node_network_mtu_bytes mtu_bytes value of /sys/class/net/<iface>.
+# TYPE node_network_mtu_bytes gauge
+node_network_mtu_bytes{device="eth0",synthetic="false"} 1500
+node_network_mtu_bytes{device="metrika_synthetic",synthetic="true"} 1500
+# HELP node_network_receive_packets_total Network device statistic receive_packets.
+# TYPE node_network_receive_packets_total counter
+node_network_receive_packets_total{device="eth0",synthetic="false"} 5.20993275e+08
+node_network_receive_packets_total{device="metrika_synthetic",synthetic="true"} 7200
+`
+	require.NoError(t, testutil.GatherAndCompare(newSyntheticTestRegistry(t), strings.NewReader(want),
+		"node_network_mtu_bytes", "node_network_receive_packets_total"))
+}
+
+func TestSyntheticDeviceDisabled(t *testing.T) {
+	require.False(t, SyntheticDeviceEnabled())
+
+	reg := prometheus.NewPedanticRegistry()
+	c, err := NewNetDevCollector()
+	require.NoError(t, err)
+	require.NoError(t, Register(reg, prometheusNetDev, c))
+
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				require.NotEqual(t, SyntheticLabel, l.GetName())
+				require.NotEqual(t, SyntheticDeviceName, l.GetValue())
+			}
+		}
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import "flag"
+
+// defaultSystemdUnitInclude default units exported by the systemd
+// collector: the blockchain nodes and the agent itself.
+const defaultSystemdUnitInclude = `^(algod|flow.*|solana.*|metrikad)\.service$`
+
+// systemdUnitInclude Regexp of systemd units exported by the systemd
+// collector.
+// collector.systemd.unit-include
+var systemdUnitInclude = defaultSystemdUnitInclude
+
+// DefineSystemdFlags defines the flags of the systemd collector.
+func DefineSystemdFlags(flags *flag.FlagSet) {
+	flags.StringVar(&systemdUnitInclude, "collector.systemd.unit-include", defaultSystemdUnitInclude,
+		"Regexp of systemd units exported by the systemd collector.")
+}
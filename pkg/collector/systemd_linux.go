@@ -0,0 +1,209 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nosystemd
+// +build !nosystemd
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// systemdTimeout max time spent querying systemd per scrape.
+const systemdTimeout = 5 * time.Second
+
+// systemdUnitStates unit active states, exactly one is set per unit.
+var systemdUnitStates = []string{"active", "activating", "deactivating", "inactive", "failed"}
+
+var (
+	// systemdBusSocket socket of the system D-Bus, the collector reports
+	// no data if it is missing (i.e. in containers).
+	systemdBusSocket = "/run/dbus/system_bus_socket"
+
+	// newSystemdConn opens a connection to systemd, replaced in tests.
+	newSystemdConn = func(ctx context.Context) (systemdConn, error) {
+		return dbus.NewWithContext(ctx)
+	}
+)
+
+// systemdConn the subset of the systemd D-Bus API used by the collector.
+type systemdConn interface {
+	ListUnitsContext(ctx context.Context) ([]dbus.UnitStatus, error)
+	GetUnitPropertyContext(ctx context.Context, unit string, propertyName string) (*dbus.Property, error)
+	GetServicePropertyContext(ctx context.Context, service string, propertyName string) (*dbus.Property, error)
+	GetManagerProperty(prop string) (string, error)
+	Close()
+}
+
+type systemdCollector struct {
+	unitPattern *regexp.Regexp
+
+	unitStateDesc     *prometheus.Desc
+	unitStartTimeDesc *prometheus.Desc
+	restartsDesc      *prometheus.Desc
+	versionDesc       *prometheus.Desc
+	errorsDesc        *prometheus.Desc
+}
+
+// NewSystemdCollector returns a new Collector exposing the state of the
+// systemd units matching collector.systemd.unit-include, read over D-Bus.
+func NewSystemdCollector() (prometheus.Collector, error) {
+	pattern, err := regexp.Compile(systemdUnitInclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid systemd unit include regexp %q: %w", systemdUnitInclude, err)
+	}
+
+	return &systemdCollector{
+		unitPattern: pattern,
+		unitStateDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "systemd", "unit_state"),
+			"Systemd unit active state.",
+			[]string{"name", "state", "type"}, nil,
+		),
+		unitStartTimeDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "systemd", "unit_start_time_seconds"),
+			"Start time of the unit since unix epoch in seconds, 0 if it never entered the active state.",
+			[]string{"name", "type"}, nil,
+		),
+		restartsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "systemd", "service_restart_total"),
+			"Number of automatic restarts of the service.",
+			[]string{"name"}, nil,
+		),
+		versionDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "systemd", "version"),
+			"Systemd version, value is always 1.",
+			[]string{"version"}, nil,
+		),
+		errorsDesc: newScrapeErrorsDesc("systemd"),
+	}, nil
+}
+
+// systemdBusAvailable returns false if the system D-Bus is unreachable.
+func systemdBusAvailable() bool {
+	if os.Getenv("DBUS_SYSTEM_BUS_ADDRESS") != "" {
+		return true
+	}
+
+	_, err := os.Stat(systemdBusSocket)
+
+	return err == nil
+}
+
+func (c *systemdCollector) Collect(ch chan<- prometheus.Metric) {
+	if !systemdBusAvailable() {
+		zap.S().Debugw("systemd metrics are not available, system D-Bus socket not found", "socket", systemdBusSocket)
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), systemdTimeout)
+	defer cancel()
+
+	conn, err := newSystemdConn(ctx)
+	if err != nil {
+		zap.S().Debugw("systemd metrics are not available, could not connect to D-Bus", zap.Error(err))
+
+		return
+	}
+	defer conn.Close()
+
+	errs := &multiError{}
+
+	if version, err := conn.GetManagerProperty("Version"); err != nil {
+		errs.Add("version", err)
+	} else {
+		// quoted D-Bus string, i.e. "249.11-0ubuntu3"
+		ch <- prometheus.MustNewConstMetric(c.versionDesc, prometheus.GaugeValue, 1, strings.Trim(version, `"`))
+	}
+
+	units, err := conn.ListUnitsContext(ctx)
+	if err != nil {
+		errs.Add("units", err)
+		collectErrors(ch, c.errorsDesc, errs.ErrorOrNil())
+
+		return
+	}
+
+	for _, unit := range units {
+		if !c.unitPattern.MatchString(unit.Name) {
+			continue
+		}
+
+		unitType := unit.Name[strings.LastIndex(unit.Name, ".")+1:]
+		for _, state := range systemdUnitStates {
+			v := 0.0
+			if state == unit.ActiveState {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(c.unitStateDesc, prometheus.GaugeValue, v, unit.Name, state, unitType)
+		}
+
+		started, err := c.uint64Property(conn.GetUnitPropertyContext(ctx, unit.Name, "ActiveEnterTimestamp"))
+		if err != nil {
+			errs.Add(unit.Name, err)
+		} else {
+			// microseconds since epoch
+			ch <- prometheus.MustNewConstMetric(c.unitStartTimeDesc, prometheus.GaugeValue, float64(started)/1e6, unit.Name, unitType)
+		}
+
+		if unitType != "service" {
+			continue
+		}
+
+		restarts, err := c.uint64Property(conn.GetServicePropertyContext(ctx, unit.Name, "NRestarts"))
+		if err != nil {
+			// not tracked before systemd 235
+			zap.S().Debugw("could not get service restarts", "unit", unit.Name, zap.Error(err))
+
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.restartsDesc, prometheus.CounterValue, float64(restarts), unit.Name)
+	}
+
+	collectErrors(ch, c.errorsDesc, errs.ErrorOrNil())
+}
+
+// uint64Property returns the value of an unsigned integer D-Bus property.
+func (c *systemdCollector) uint64Property(p *dbus.Property, err error) (uint64, error) {
+	if err != nil {
+		return 0, err
+	}
+
+	switch v := p.Value.Value().(type) {
+	case uint64:
+		return v, nil
+	case uint32:
+		return uint64(v), nil
+	default:
+		return 0, fmt.Errorf("unexpected %s property type %T: %w", p.Name, v, ErrParse)
+	}
+}
+
+func (c *systemdCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.unitStateDesc
+	ch <- c.unitStartTimeDesc
+	ch <- c.restartsDesc
+	ch <- c.versionDesc
+	ch <- c.errorsDesc
+}
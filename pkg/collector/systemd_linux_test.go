@@ -0,0 +1,140 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nosystemd
+// +build !nosystemd
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSystemdConn struct {
+	units      []dbus.UnitStatus
+	properties map[string]interface{}
+}
+
+func (f *fakeSystemdConn) ListUnitsContext(context.Context) ([]dbus.UnitStatus, error) {
+	return f.units, nil
+}
+
+func (f *fakeSystemdConn) property(unit, name string) (*dbus.Property, error) {
+	v, ok := f.properties[unit+"/"+name]
+	if !ok {
+		return nil, errors.New("unknown property")
+	}
+
+	return &dbus.Property{Name: name, Value: godbus.MakeVariant(v)}, nil
+}
+
+func (f *fakeSystemdConn) GetUnitPropertyContext(_ context.Context, unit, name string) (*dbus.Property, error) {
+	return f.property(unit, name)
+}
+
+func (f *fakeSystemdConn) GetServicePropertyContext(_ context.Context, unit, name string) (*dbus.Property, error) {
+	return f.property(unit, name)
+}
+
+func (f *fakeSystemdConn) GetManagerProperty(string) (string, error) {
+	return `"249.11-0ubuntu3"`, nil
+}
+
+func (f *fakeSystemdConn) Close() {}
+
+// withSystemdConn points the collector to conn for the duration of the test.
+func withSystemdConn(t *testing.T, conn systemdConn) {
+	t.Helper()
+
+	socketWas, newConnWas := systemdBusSocket, newSystemdConn
+	t.Cleanup(func() {
+		systemdBusSocket, newSystemdConn = socketWas, newConnWas
+	})
+
+	systemdBusSocket = filepath.Join(t.TempDir(), "system_bus_socket")
+	require.NoError(t, os.WriteFile(systemdBusSocket, nil, 0o644))
+	newSystemdConn = func(context.Context) (systemdConn, error) { return conn, nil }
+}
+
+func TestSystemdCollector(t *testing.T) {
+	withSystemdConn(t, &fakeSystemdConn{
+		units: []dbus.UnitStatus{
+			{Name: "algod.service", ActiveState: "active"},
+			{Name: "metrikad.service", ActiveState: "failed"},
+			{Name: "cron.service", ActiveState: "active"},
+		},
+		properties: map[string]interface{}{
+			"algod.service/ActiveEnterTimestamp":    uint64(1654077600000000),
+			"algod.service/NRestarts":               uint32(3),
+			"metrikad.service/ActiveEnterTimestamp": uint64(0),
+		},
+	})
+
+	c, err := NewSystemdCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_systemd_service_restart_total Number of automatic restarts of the service.
+# TYPE node_systemd_service_restart_total counter
+node_systemd_service_restart_total{name="algod.service"} 3
+# HELP node_systemd_unit_start_time_seconds Start time of the unit since unix epoch in seconds, 0 if it never entered the active state.
+# TYPE node_systemd_unit_start_time_seconds gauge
+node_systemd_unit_start_time_seconds{name="algod.service",type="service"} 1.6540776e+09
+node_systemd_unit_start_time_seconds{name="metrikad.service",type="service"} 0
+# HELP node_systemd_unit_state Systemd unit active state.
+# TYPE node_systemd_unit_state gauge
+node_systemd_unit_state{name="algod.service",state="activating",type="service"} 0
+node_systemd_unit_state{name="algod.service",state="active",type="service"} 1
+node_systemd_unit_state{name="algod.service",state="deactivating",type="service"} 0
+node_systemd_unit_state{name="algod.service",state="failed",type="service"} 0
+node_systemd_unit_state{name="algod.service",state="inactive",type="service"} 0
+node_systemd_unit_state{name="metrikad.service",state="activating",type="service"} 0
+node_systemd_unit_state{name="metrikad.service",state="active",type="service"} 0
+node_systemd_unit_state{name="metrikad.service",state="deactivating",type="service"} 0
+node_systemd_unit_state{name="metrikad.service",state="failed",type="service"} 1
+node_systemd_unit_state{name="metrikad.service",state="inactive",type="service"} 0
+# HELP node_systemd_version Systemd version, value is always 1.
+# TYPE node_systemd_version gauge
+node_systemd_version{version="249.11-0ubuntu3"} 1
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestSystemdCollector_NoBus(t *testing.T) {
+	socketWas := systemdBusSocket
+	defer func() { systemdBusSocket = socketWas }()
+	systemdBusSocket = filepath.Join(t.TempDir(), "missing")
+	t.Setenv("DBUS_SYSTEM_BUS_ADDRESS", "")
+
+	c, err := NewSystemdCollector()
+	require.NoError(t, err)
+	require.Equal(t, 0, testutil.CollectAndCount(c))
+}
+
+func TestNewSystemdCollector_InvalidInclude(t *testing.T) {
+	includeWas := systemdUnitInclude
+	defer func() { systemdUnitInclude = includeWas }()
+	systemdUnitInclude = "("
+
+	_, err := NewSystemdCollector()
+	require.Error(t, err)
+}
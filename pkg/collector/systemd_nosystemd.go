@@ -0,0 +1,39 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build nosystemd || !linux
+// +build nosystemd !linux
+
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// unsupportedSystemdCollector systemd collector used when the agent is
+// built without systemd support or for another OS than linux, it reports
+// no data.
+type unsupportedSystemdCollector struct{}
+
+// NewSystemdCollector returns a Collector reporting no data, the agent was
+// built without systemd support.
+func NewSystemdCollector() (prometheus.Collector, error) {
+	return unsupportedSystemdCollector{}, nil
+}
+
+func (unsupportedSystemdCollector) Collect(chan<- prometheus.Metric) {
+	zap.S().Debugw("systemd metrics are not available, systemd support not compiled in")
+}
+
+func (unsupportedSystemdCollector) Describe(chan<- *prometheus.Desc) {}
@@ -0,0 +1,152 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !notcpstat
+// +build !notcpstat
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const tcpStatSubsystem = "tcp"
+
+// tcpStates TCP states as numbered in include/net/tcp_states.h.
+var tcpStates = [...]string{
+	1:  "established",
+	2:  "syn_sent",
+	3:  "syn_recv",
+	4:  "fin_wait1",
+	5:  "fin_wait2",
+	6:  "time_wait",
+	7:  "close",
+	8:  "close_wait",
+	9:  "last_ack",
+	10: "listen",
+	11: "closing",
+	12: "new_syn_recv",
+}
+
+// tcpStats connection counts by state and queued bytes summed over the
+// connections of a /proc/net/tcp{,6} file.
+type tcpStats struct {
+	states   [len(tcpStates)]uint64
+	txQueued uint64
+	rxQueued uint64
+}
+
+type tcpStatCollector struct {
+	statesDesc   *prometheus.Desc
+	txQueuedDesc *prometheus.Desc
+	rxQueuedDesc *prometheus.Desc
+	errorsDesc   *prometheus.Desc
+}
+
+// NewTCPStatCollector returns a new Collector exposing TCP connection stats.
+func NewTCPStatCollector() (prometheus.Collector, error) {
+	return &tcpStatCollector{
+		statesDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, tcpStatSubsystem, "connection_states"),
+			"Number of TCP connections by state.",
+			[]string{"state"},
+			nil,
+		),
+		txQueuedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, tcpStatSubsystem, "tx_queued_bytes"),
+			"Bytes queued for transmission across TCP connections.",
+			nil,
+			nil,
+		),
+		rxQueuedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, tcpStatSubsystem, "rx_queued_bytes"),
+			"Bytes received but not yet read across TCP connections, listening sockets excluded.",
+			nil,
+			nil,
+		),
+		errorsDesc: newScrapeErrorsDesc("tcpstat"),
+	}, nil
+}
+
+func (c *tcpStatCollector) Collect(ch chan<- prometheus.Metric) {
+	collectErrors(ch, c.errorsDesc, c.collect(ch))
+}
+
+func (c *tcpStatCollector) collect(ch chan<- prometheus.Metric) error {
+	stats := &tcpStats{}
+	errs := &multiError{}
+
+	for _, name := range []string{"net/tcp", "net/tcp6"} {
+		// If IPv6 is disabled on this kernel, handle it gracefully.
+		if err := readTCPStatsFile(procFilePath(name), stats); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs.Add(name, err)
+		}
+	}
+
+	for state, name := range tcpStates {
+		if name == "" {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.statesDesc, prometheus.GaugeValue, float64(stats.states[state]), name)
+	}
+	ch <- prometheus.MustNewConstMetric(c.txQueuedDesc, prometheus.GaugeValue, float64(stats.txQueued))
+	ch <- prometheus.MustNewConstMetric(c.rxQueuedDesc, prometheus.GaugeValue, float64(stats.rxQueued))
+
+	return errs.ErrorOrNil()
+}
+
+func (c *tcpStatCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.statesDesc
+	ch <- c.txQueuedDesc
+	ch <- c.rxQueuedDesc
+	ch <- c.errorsDesc
+}
+
+func readTCPStatsFile(path string, stats *tcpStats) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return parseTCPStats(f, stats)
+}
+
+// parseTCPStats adds the connections listed in r, in /proc/net/tcp format,
+// to stats.
+func parseTCPStats(r io.Reader, stats *tcpStats) error {
+	return scanProcNetSockets(r, func(socket procNetSocket) error {
+		return stats.add(socket)
+	})
+}
+
+func (s *tcpStats) add(socket procNetSocket) error {
+	if socket.state == 0 || socket.state >= uint64(len(tcpStates)) {
+		return fmt.Errorf("invalid TCP state %d: %w", socket.state, ErrParse)
+	}
+
+	s.states[socket.state]++
+	s.txQueued += socket.txQueue
+
+	// the rx_queue of a listening socket is its accept backlog length
+	if socket.state != tcpStateListen {
+		s.rxQueued += socket.rxQueue
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !notcpstat
+// +build !notcpstat
+
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTCPStatCollector(t *testing.T) {
+	procPathWas := procPath
+	defer func() {
+		procPath = procPathWas
+	}()
+	procPath = "fixtures/proc"
+
+	c, err := NewTCPStatCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_tcp_connection_states Number of TCP connections by state.
+# TYPE node_tcp_connection_states gauge
+node_tcp_connection_states{state="close"} 0
+node_tcp_connection_states{state="close_wait"} 0
+node_tcp_connection_states{state="closing"} 0
+node_tcp_connection_states{state="established"} 2
+node_tcp_connection_states{state="fin_wait1"} 0
+node_tcp_connection_states{state="fin_wait2"} 0
+node_tcp_connection_states{state="last_ack"} 0
+node_tcp_connection_states{state="listen"} 2
+node_tcp_connection_states{state="new_syn_recv"} 0
+node_tcp_connection_states{state="syn_recv"} 0
+node_tcp_connection_states{state="syn_sent"} 0
+node_tcp_connection_states{state="time_wait"} 1
+# HELP node_tcp_rx_queued_bytes Bytes received but not yet read across TCP connections, listening sockets excluded.
+# TYPE node_tcp_rx_queued_bytes gauge
+node_tcp_rx_queued_bytes 1
+# HELP node_tcp_tx_queued_bytes Bytes queued for transmission across TCP connections.
+# TYPE node_tcp_tx_queued_bytes gauge
+node_tcp_tx_queued_bytes 298
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestParseTCPStats_Malformed(t *testing.T) {
+	header := "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+
+	for _, line := range []string{
+		"   0: 00000000:0016 00000000:0000 ZZ 00000000:00000000 00:00000000 00000000     0        0 2740",
+		"   0: 00000000:0016 00000000:0000 0D 00000000:00000000 00:00000000 00000000     0        0 2740",
+		"   0: 00000000:0016 00000000:0000 01 0000000000000000 00:00000000 00000000     0        0 2740",
+		"   0: 00000000:0016 00000000:0000 01 00000000:",
+		"   0: 00000000:0016",
+	} {
+		err := parseTCPStats(strings.NewReader(header+line+"\n"), &tcpStats{})
+		require.ErrorIs(t, err, ErrParse, line)
+	}
+}
+
+func BenchmarkParseTCPStats(b *testing.B) {
+	var buf bytes.Buffer
+	buf.WriteString("  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n")
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(&buf, "%4d: 0F02000A:0016 0202000A:%04X %02X 00000015:00000001 02:000AC99B 00000000     0        0 3652 4 ffff88003d3ae040 21 4 31 47 46\n",
+			i, i%0xffff, i%11+1)
+	}
+	content := buf.Bytes()
+	r := bytes.NewReader(content)
+
+	b.SetBytes(int64(len(content)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		r.Reset(content)
+		if err := parseTCPStats(r, &tcpStats{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
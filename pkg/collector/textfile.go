@@ -17,14 +17,18 @@
 package collector
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
 
+	"agent/internal/pkg/labels"
+
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
@@ -40,23 +44,231 @@ var (
 		[]string{"file"},
 		nil,
 	)
+	fileErrorDesc = prometheus.NewDesc(
+		"node_textfile_file_error",
+		"1 if samples of the file were dropped because they conflict with samples read from another file.",
+		[]string{"file"},
+		nil,
+	)
+
+	// textFileRenameRules Ordered list of regexp to replacement rules applied to
+	// metric names read from text files (i.e. node_exporter migration).
+	// collector.textfile.rename
+	textFileRenameRules = []textFileRenameRule{}
+
+	// textFilePassthrough Keep metric names read from text files untouched
+	// and only apply the rename rules to the streamed metrics.
+	// collector.textfile.passthrough
+	textFilePassthrough = false
 )
 
+// DefineTextFileFlags defines the flags of the textfile collector.
+func DefineTextFileFlags(flags *flag.FlagSet) {
+	flags.StringVar(&textFileDirectory, "collector.textfile.directory", textFileDirectory,
+		"Directory to read *.prom text files with metrics from, glob patterns are supported.")
+	flags.Var(textFileRenameFlag{}, "collector.textfile.rename",
+		"Rename rule pattern=replacement applied to the metric names read from text files, i.e. '^node_(.*)$=${1}'. Can be repeated, the rules are applied in order.")
+	flags.BoolVar(&textFilePassthrough, "collector.textfile.passthrough", textFilePassthrough,
+		"Keep the metric names read from text files untouched on the local endpoint and only apply the rename rules to the streamed metrics.")
+}
+
+// textFileRenameFlag flag.Value appending a rename rule each time it is
+// set, validated as soon as the flags are parsed.
+type textFileRenameFlag struct{}
+
+func (textFileRenameFlag) String() string {
+	rules := make([]string, 0, len(textFileRenameRules))
+	for _, rule := range textFileRenameRules {
+		rules = append(rules, rule.Pattern+"="+rule.Replacement)
+	}
+
+	return strings.Join(rules, ",")
+}
+
+func (textFileRenameFlag) Set(s string) error {
+	// metric names can't contain '=', split on the last one so that the
+	// pattern can.
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return fmt.Errorf("textfile rename rule %q is not in pattern=replacement form", s)
+	}
+
+	rule := textFileRenameRule{Pattern: s[:i], Replacement: s[i+1:]}
+	if _, err := compileTextFileRenameRules([]textFileRenameRule{rule}); err != nil {
+		return err
+	}
+	textFileRenameRules = append(textFileRenameRules, rule)
+
+	return nil
+}
+
+// textFileRenameRule rewrites metric names matching Pattern using
+// Replacement, which supports regexp.Expand syntax (i.e. ${1}).
+type textFileRenameRule struct {
+	Pattern     string
+	Replacement string
+}
+
+type textFileRename struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
 type textFileCollector struct {
 	path string
 	// Only set for testing to get predictable output.
 	mtime *float64
+
+	renames     []textFileRename
+	passthrough bool
 }
 
 // NewTextFileCollector returns a new Collector exposing metrics read from files
 // in the given textfile directory.
 func NewTextFileCollector() (prometheus.Collector, error) {
+	renames, err := compileTextFileRenameRules(textFileRenameRules)
+	if err != nil {
+		return nil, err
+	}
+
 	c := &textFileCollector{
-		path: textFileDirectory,
+		path:        textFileDirectory,
+		renames:     renames,
+		passthrough: textFilePassthrough,
 	}
 	return c, nil
 }
 
+func compileTextFileRenameRules(rules []textFileRenameRule) ([]textFileRename, error) {
+	renames := make([]textFileRename, 0, len(rules))
+	for _, rule := range rules {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid textfile rename pattern %q: %w", rule.Pattern, err)
+		}
+		renames = append(renames, textFileRename{pattern: pattern, replacement: rule.Replacement})
+	}
+
+	return renames, nil
+}
+
+// renameMetric applies all configured rename rules in order.
+func (c *textFileCollector) renameMetric(name string) string {
+	for _, r := range c.renames {
+		if r.pattern.MatchString(name) {
+			name = r.pattern.ReplaceAllString(name, r.replacement)
+		}
+	}
+
+	return name
+}
+
+// exportedName returns the metric name exposed by Collect. Names are
+// kept as-is in passthrough mode.
+func (c *textFileCollector) exportedName(name string) string {
+	if c.passthrough {
+		return name
+	}
+
+	return c.renameMetric(name)
+}
+
+// StreamMetricName returns the name a metric exported by the collector
+// must be streamed with. In passthrough mode the rename rules are
+// applied here instead of Collect, so the local /metrics endpoint keeps
+// the original names.
+func (c *textFileCollector) StreamMetricName(name string) string {
+	if !c.passthrough {
+		return name
+	}
+
+	return c.renameMetric(name)
+}
+
+// textFileSeen metrics already read from the files of a scrape.
+type textFileSeen struct {
+	// samples maps sample identities to the file they were read from.
+	samples map[string]string
+
+	// families maps final metric names to their first declaration.
+	families map[string]textFileFamily
+}
+
+// textFileFamily a metric family declaration.
+type textFileFamily struct {
+	path string
+	typ  dto.MetricType
+	help string
+}
+
+func newTextFileSeen() *textFileSeen {
+	return &textFileSeen{samples: map[string]string{}, families: map[string]textFileFamily{}}
+}
+
+// dropConflicts removes samples whose final name and labels were
+// already read from a different file and returns the number of samples
+// dropped. Families whose name was declared by a different file with
+// another type or help are rejected entirely, they would make the whole
+// scrape inconsistent. Families without help inherit the declared one.
+func (c *textFileCollector) dropConflicts(path string, families map[string]*dto.MetricFamily, seen *textFileSeen) int {
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dropped := 0
+	for _, name := range names {
+		mf := families[name]
+		finalName := c.renameMetric(mf.GetName())
+
+		if prev, ok := seen.families[finalName]; ok && prev.path != path {
+			if mf.GetType() != prev.typ || (mf.Help != nil && mf.GetHelp() != prev.help) {
+				dropped += len(mf.Metric)
+				mf.Metric = nil
+
+				continue
+			}
+
+			help := prev.help
+			mf.Help = &help
+		}
+
+		if mf.Help == nil {
+			help := fmt.Sprintf("Metric read from %s", path)
+			mf.Help = &help
+		}
+
+		if _, ok := seen.families[finalName]; !ok {
+			seen.families[finalName] = textFileFamily{path: path, typ: mf.GetType(), help: mf.GetHelp()}
+		}
+
+		kept := mf.Metric[:0]
+		for _, m := range mf.Metric {
+			key := sampleKey(finalName, m.GetLabel())
+			if prev, ok := seen.samples[key]; ok && prev != path {
+				dropped++
+				continue
+			}
+			seen.samples[key] = path
+			kept = append(kept, m)
+		}
+		mf.Metric = kept
+	}
+
+	return dropped
+}
+
+func sampleKey(name string, lbls []*dto.LabelPair) string {
+	b := labels.NewBuilder(labels.Labels{})
+	for _, l := range lbls {
+		b.Set(labels.Label{Name: l.GetName(), Value: l.GetValue()})
+	}
+	b.Set(labels.Label{Name: labels.MetricName, Value: name})
+
+	return b.Labels().String()
+}
+
 func convertMetricFamily(metricFamily *dto.MetricFamily, ch chan<- prometheus.Metric) {
 	var valType prometheus.ValueType
 	var val float64
@@ -285,6 +497,8 @@ func (c *textFileCollector) Collect(ch chan<- prometheus.Metric) {
 	}
 
 	mtimes := make(map[string]time.Time)
+	seen := newTextFileSeen()
+	conflicts := []string{}
 	for _, path := range paths {
 		files, err := ioutil.ReadDir(path)
 		if err != nil && path != "" {
@@ -296,17 +510,25 @@ func (c *textFileCollector) Collect(ch chan<- prometheus.Metric) {
 				continue
 			}
 
-			mtime, err := c.processFile(path, f.Name(), ch)
+			mtime, dropped, err := c.processFile(path, f.Name(), seen, ch)
 			if err != nil {
 				errored = true
 				continue
 			}
 
+			if dropped > 0 {
+				conflicts = append(conflicts, filepath.Join(path, f.Name()))
+			}
+
 			mtimes[filepath.Join(path, f.Name())] = *mtime
 		}
 	}
 	c.exportMTimes(mtimes, ch)
 
+	for _, path := range conflicts {
+		ch <- prometheus.MustNewConstMetric(fileErrorDesc, prometheus.GaugeValue, 1.0, path)
+	}
+
 	// Export if there were errors.
 	var errVal float64
 	if errored {
@@ -323,33 +545,34 @@ func (c *textFileCollector) Collect(ch chan<- prometheus.Metric) {
 	)
 }
 
-// processFile processes a single file, returning its modification time on success.
-func (c *textFileCollector) processFile(dir, name string, ch chan<- prometheus.Metric) (*time.Time, error) {
+// processFile processes a single file, returning its modification time and
+// the number of samples dropped due to conflicts on success.
+func (c *textFileCollector) processFile(dir, name string, seen *textFileSeen, ch chan<- prometheus.Metric) (*time.Time, int, error) {
 	path := filepath.Join(dir, name)
 	f, err := os.Open(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open textfile data file %q: %w", path, err)
+		return nil, 0, fmt.Errorf("failed to open textfile data file %q: %w", path, err)
 	}
 	defer f.Close()
 
 	var parser expfmt.TextParser
 	families, err := parser.TextToMetricFamilies(f)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse textfile data from %q: %w", path, err)
+		return nil, 0, fmt.Errorf("failed to parse textfile data from %q: %w", path, err)
 	}
 
 	if hasTimestamps(families) {
-		return nil, fmt.Errorf("textfile %q contains unsupported client-side timestamps, skipping entire file", path)
+		return nil, 0, fmt.Errorf("textfile %q contains unsupported client-side timestamps, skipping entire file", path)
 	}
 
-	for _, mf := range families {
-		if mf.Help == nil {
-			help := fmt.Sprintf("Metric read from %s", path)
-			mf.Help = &help
-		}
-	}
+	dropped := c.dropConflicts(path, families, seen)
 
 	for _, mf := range families {
+		if len(mf.Metric) == 0 {
+			continue
+		}
+		fqName := c.exportedName(mf.GetName())
+		mf.Name = &fqName
 		convertMetricFamily(mf, ch)
 	}
 
@@ -357,14 +580,14 @@ func (c *textFileCollector) processFile(dir, name string, ch chan<- prometheus.M
 	// a failure does not appear fresh.
 	stat, err := f.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("failed to stat %q: %w", path, err)
+		return nil, 0, fmt.Errorf("failed to stat %q: %w", path, err)
 	}
 
 	t := stat.ModTime()
-	return &t, nil
+	return &t, dropped, nil
 }
 
-func (c *textFileCollector) processFileDesc(dir, name string, ch chan<- *prometheus.Desc) (*time.Time, error) {
+func (c *textFileCollector) processFileDesc(dir, name string, seen *textFileSeen, ch chan<- *prometheus.Desc) (*time.Time, error) {
 	path := filepath.Join(dir, name)
 	f, err := os.Open(path)
 	if err != nil {
@@ -382,14 +605,14 @@ func (c *textFileCollector) processFileDesc(dir, name string, ch chan<- *prometh
 		return nil, fmt.Errorf("textfile %q contains unsupported client-side timestamps, skipping entire file", path)
 	}
 
-	for _, mf := range families {
-		if mf.Help == nil {
-			help := fmt.Sprintf("Metric read from %s", path)
-			mf.Help = &help
-		}
-	}
+	c.dropConflicts(path, families, seen)
 
 	for _, mf := range families {
+		if len(mf.Metric) == 0 {
+			continue
+		}
+		fqName := c.exportedName(mf.GetName())
+		mf.Name = &fqName
 		convertMetricFamilyDesc(mf, ch)
 	}
 
@@ -425,17 +648,16 @@ func (c *textFileCollector) Describe(ch chan<- *prometheus.Desc) {
 	}
 
 	mtimes := make(map[string]time.Time)
+	seen := newTextFileSeen()
 	for _, path := range paths {
-		files, err := ioutil.ReadDir(path)
-		if err != nil && path != "" {
-		}
+		files, _ := ioutil.ReadDir(path)
 
 		for _, f := range files {
 			if !strings.HasSuffix(f.Name(), ".prom") {
 				continue
 			}
 
-			mtime, err := c.processFileDesc(path, f.Name(), ch)
+			mtime, err := c.processFileDesc(path, f.Name(), seen, ch)
 			if err != nil {
 				continue
 			}
@@ -444,6 +666,7 @@ func (c *textFileCollector) Describe(ch chan<- *prometheus.Desc) {
 		}
 	}
 	c.exportMTimesDesc(mtimes, ch)
+	ch <- fileErrorDesc
 
 	ch <- prometheus.NewDesc(
 		"node_textfile_scrape_error",
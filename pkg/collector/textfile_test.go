@@ -14,6 +14,7 @@
 package collector
 
 import (
+	"flag"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -68,6 +69,10 @@ func TestTextfileCollector(t *testing.T) {
 			path: "fixtures/textfile/summary_extra_dimension",
 			out:  "fixtures/textfile/summary_extra_dimension.out",
 		},
+		{
+			path: "fixtures/textfile/duplicate_metric_names",
+			out:  "fixtures/textfile/duplicate_metric_names.out",
+		},
 		{
 			path: "fixtures/textfile/*_extra_dimension",
 			out:  "fixtures/textfile/glob_extra_dimension.out",
@@ -98,3 +103,111 @@ func TestTextfileCollector(t *testing.T) {
 		}
 	}
 }
+
+func TestTextfileCollectorMigration(t *testing.T) {
+	rules := []textFileRenameRule{{Pattern: "^node_(.*)$", Replacement: "${1}"}}
+
+	tests := []struct {
+		name        string
+		path        string
+		passthrough bool
+		out         string
+	}{
+		{
+			name: "rename",
+			path: "fixtures/textfile/node_exporter_migration",
+			out:  "fixtures/textfile/node_exporter_migration_rename.out",
+		},
+		{
+			name:        "passthrough",
+			path:        "fixtures/textfile/node_exporter_migration",
+			passthrough: true,
+			out:         "fixtures/textfile/node_exporter_migration_passthrough.out",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renames, err := compileTextFileRenameRules(rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mtime := 1.0
+			c := &textFileCollector{
+				path:        tt.path,
+				mtime:       &mtime,
+				renames:     renames,
+				passthrough: tt.passthrough,
+			}
+
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(c)
+
+			rw := httptest.NewRecorder()
+			promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(rw, &http.Request{})
+			got := rw.Body.String()
+
+			want, err := ioutil.ReadFile(tt.out)
+			if err != nil {
+				t.Fatalf("error reading fixture file %s: %s", tt.out, err)
+			}
+
+			if string(want) != got {
+				t.Fatalf("%q want:\n\n%s\n\ngot:\n\n%s", tt.path, string(want), got)
+			}
+
+			if want, got := "backup_age_seconds", c.StreamMetricName("node_backup_age_seconds"); tt.passthrough && want != got {
+				t.Fatalf("want stream name %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestTextfileCollectorInvalidRenameRule(t *testing.T) {
+	_, err := compileTextFileRenameRules([]textFileRenameRule{{Pattern: "(", Replacement: ""}})
+	if err == nil {
+		t.Fatal("expected error for invalid rename pattern")
+	}
+}
+
+func TestTextfileCollectorFlags(t *testing.T) {
+	t.Cleanup(func() {
+		textFileRenameRules = []textFileRenameRule{}
+		textFilePassthrough = false
+	})
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	DefineTextFileFlags(flags)
+
+	err := flags.Parse([]string{
+		"--collector.textfile.rename", "^node_(.*)$=${1}",
+		"--collector.textfile.rename", "^backup_(.*)$=db_backup_${1}",
+		"--collector.textfile.passthrough",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collector, err := NewTextFileCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := collector.(*textFileCollector)
+
+	if !c.passthrough {
+		t.Fatal("want passthrough collector")
+	}
+	if want, got := "db_backup_age_seconds", c.StreamMetricName("node_backup_age_seconds"); want != got {
+		t.Fatalf("want stream name %q, got %q", want, got)
+	}
+
+	for _, invalid := range []string{"^node_", "(=x"} {
+		if err := flags.Set("collector.textfile.rename", invalid); err == nil {
+			t.Fatalf("expected error for rename rule %q", invalid)
+		}
+	}
+	if want, got := 2, len(textFileRenameRules); want != got {
+		t.Fatalf("want %d rename rules, got %d", want, got)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nothermalzone
+// +build !nothermalzone
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// thermalReadFile reads a sysfs attribute, overridden by tests.
+var thermalReadFile = func(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+type thermalZoneCollector struct {
+	zoneTemp              *prometheus.Desc
+	coolingDeviceCurState *prometheus.Desc
+	coolingDeviceMaxState *prometheus.Desc
+	errorsDesc            *prometheus.Desc
+}
+
+// NewThermalZoneCollector returns a new Collector exposing thermal zone
+// temperatures and cooling device states from /sys/class/thermal.
+func NewThermalZoneCollector() (prometheus.Collector, error) {
+	return &thermalZoneCollector{
+		zoneTemp: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "thermal_zone", "temp"),
+			"Zone temperature in Celsius",
+			[]string{"zone", "type"}, nil,
+		),
+		coolingDeviceCurState: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "cooling_device", "cur_state"),
+			"Current throttle state of the cooling device",
+			[]string{"name", "type"}, nil,
+		),
+		coolingDeviceMaxState: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "cooling_device", "max_state"),
+			"Maximum throttle state of the cooling device",
+			[]string{"name", "type"}, nil,
+		),
+		errorsDesc: newScrapeErrorsDesc("thermal_zone"),
+	}, nil
+}
+
+// skipThermalErr returns true for errors of zones or devices that cannot
+// be read by design. Some ACPI zones return EINVAL (or ENODATA) on read.
+func skipThermalErr(err error) bool {
+	return errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENODATA)
+}
+
+func (c *thermalZoneCollector) Collect(ch chan<- prometheus.Metric) {
+	errs := &multiError{}
+
+	zones, err := filepath.Glob(sysFilePath("class/thermal/thermal_zone[0-9]*"))
+	if err != nil {
+		collectErrors(ch, c.errorsDesc, err)
+
+		return
+	}
+
+	for _, zone := range zones {
+		name := strings.TrimPrefix(filepath.Base(zone), "thermal_zone")
+
+		zoneType, temp, err := readThermalZone(zone)
+		if err != nil {
+			if skipThermalErr(err) {
+				zap.S().Debugw("skipping unreadable thermal zone", "zone", name, zap.Error(err))
+
+				continue
+			}
+			errs.Add("thermal_zone"+name, err)
+
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.zoneTemp, prometheus.GaugeValue, float64(temp)/1000.0, name, zoneType)
+	}
+
+	devices, err := filepath.Glob(sysFilePath("class/thermal/cooling_device[0-9]*"))
+	if err != nil {
+		errs.Add("cooling_device", err)
+	}
+
+	for _, device := range devices {
+		name := strings.TrimPrefix(filepath.Base(device), "cooling_device")
+
+		deviceType, curState, maxState, err := readCoolingDevice(device)
+		if err != nil {
+			if skipThermalErr(err) {
+				zap.S().Debugw("skipping unreadable cooling device", "name", name, zap.Error(err))
+
+				continue
+			}
+			errs.Add("cooling_device"+name, err)
+
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.coolingDeviceCurState, prometheus.GaugeValue, float64(curState), name, deviceType)
+		ch <- prometheus.MustNewConstMetric(c.coolingDeviceMaxState, prometheus.GaugeValue, float64(maxState), name, deviceType)
+	}
+
+	collectErrors(ch, c.errorsDesc, errs.ErrorOrNil())
+}
+
+func (c *thermalZoneCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.zoneTemp
+	ch <- c.coolingDeviceCurState
+	ch <- c.coolingDeviceMaxState
+	ch <- c.errorsDesc
+}
+
+// readThermalZone returns the type and temperature in millidegree Celsius of zone.
+func readThermalZone(zone string) (string, int64, error) {
+	zoneType, err := thermalReadFile(filepath.Join(zone, "type"))
+	if err != nil {
+		return "", 0, err
+	}
+
+	temp, err := readThermalInt(filepath.Join(zone, "temp"))
+	if err != nil {
+		return "", 0, err
+	}
+
+	return zoneType, temp, nil
+}
+
+// readCoolingDevice returns the type, current and max state of device.
+func readCoolingDevice(device string) (string, int64, int64, error) {
+	deviceType, err := thermalReadFile(filepath.Join(device, "type"))
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	// cur_state can be -1, i.e. intel powerclamp
+	curState, err := readThermalInt(filepath.Join(device, "cur_state"))
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	maxState, err := readThermalInt(filepath.Join(device, "max_state"))
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	return deviceType, curState, maxState, nil
+}
+
+func readThermalInt(path string) (int64, error) {
+	s, err := thermalReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s: %v", ErrParse, filepath.Base(path), err)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nothermalzone
+// +build !nothermalzone
+
+package collector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThermalZoneCollector(t *testing.T) {
+	sysPathWas := sysPath
+	defer func() {
+		sysPath = sysPathWas
+	}()
+	sysPath = "fixtures/sys"
+
+	c, err := NewThermalZoneCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_cooling_device_cur_state Current throttle state of the cooling device
+# TYPE node_cooling_device_cur_state gauge
+node_cooling_device_cur_state{name="0",type="Processor"} 0
+# HELP node_cooling_device_max_state Maximum throttle state of the cooling device
+# TYPE node_cooling_device_max_state gauge
+node_cooling_device_max_state{name="0",type="Processor"} 3
+# HELP node_thermal_zone_temp Zone temperature in Celsius
+# TYPE node_thermal_zone_temp gauge
+node_thermal_zone_temp{type="cpu-thermal",zone="0"} 12.376
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestThermalZoneCollector_SkipZones(t *testing.T) {
+	sysPathWas, readFileWas := sysPath, thermalReadFile
+	defer func() {
+		sysPath, thermalReadFile = sysPathWas, readFileWas
+	}()
+
+	sysPath = t.TempDir()
+	for zone, temp := range map[string]string{"0": "45000", "1": "einval", "2": "n/a"} {
+		dir := filepath.Join(sysPath, "class", "thermal", "thermal_zone"+zone)
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "type"), []byte("acpitz\n"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "temp"), []byte(temp+"\n"), 0o644))
+	}
+
+	thermalReadFile = func(path string) (string, error) {
+		v, err := readFileWas(path)
+		if v == "einval" {
+			return "", fmt.Errorf("read %s: %w", path, syscall.EINVAL)
+		}
+
+		return v, err
+	}
+
+	c, err := NewThermalZoneCollector()
+	require.NoError(t, err)
+
+	// zone 1 is skipped silently, zone 2 is reported as a parse error
+	want := `# HELP node_scrape_collector_errors Number of errors encountered by a collector during the last scrape, by reason.
+# TYPE node_scrape_collector_errors gauge
+node_scrape_collector_errors{collector="thermal_zone",reason="parse"} 1
+# HELP node_thermal_zone_temp Zone temperature in Celsius
+# TYPE node_thermal_zone_temp gauge
+node_thermal_zone_temp{type="acpitz",zone="0"} 45
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
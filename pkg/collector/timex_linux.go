@@ -0,0 +1,128 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !notimex
+// +build !notimex
+
+package collector
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// The kernel reports offset and time fractions in microseconds, or in
+	// nanoseconds when STA_NANO is set.
+	microSeconds = 1e6
+	nanoSeconds  = 1e9
+
+	// freq is in ppm with a 16-bit fractional part (see adjtimex(2)).
+	ppm16frac = 1e6 * 65536
+)
+
+// adjtimex reads the kernel clock state, overridden by tests.
+var adjtimex = func(tx *unix.Timex) (int, error) {
+	return unix.Adjtimex(tx)
+}
+
+type timexCollector struct {
+	offset,
+	freq,
+	maxerror,
+	esterror,
+	syncStatus,
+	time typedDesc
+	errorsDesc *prometheus.Desc
+}
+
+// NewTimexCollector returns a new Collector exposing the kernel clock
+// synchronization state as reported by adjtimex(2).
+func NewTimexCollector() (prometheus.Collector, error) {
+	const subsystem = "timex"
+
+	return &timexCollector{
+		offset: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "offset_seconds"),
+			"Time offset in between local system and reference clock.",
+			nil, nil,
+		), prometheus.GaugeValue},
+		freq: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "frequency_adjustment_ratio"),
+			"Local clock frequency adjustment.",
+			nil, nil,
+		), prometheus.GaugeValue},
+		maxerror: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "maxerror_seconds"),
+			"Maximum error in seconds.",
+			nil, nil,
+		), prometheus.GaugeValue},
+		esterror: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "estimated_error_seconds"),
+			"Estimated error in seconds.",
+			nil, nil,
+		), prometheus.GaugeValue},
+		syncStatus: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "sync_status"),
+			"Is clock synchronized to a reliable server (1 = yes, 0 = no).",
+			nil, nil,
+		), prometheus.GaugeValue},
+		time: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "time_seconds"),
+			"Kernel clock time in seconds since epoch (1970), as reported by adjtimex.",
+			nil, nil,
+		), prometheus.GaugeValue},
+		errorsDesc: newScrapeErrorsDesc("timex"),
+	}, nil
+}
+
+func (c *timexCollector) Collect(ch chan<- prometheus.Metric) {
+	// Modes 0 only reads the current state, no privileges required.
+	var tx unix.Timex
+	status, err := adjtimex(&tx)
+	if err != nil {
+		collectErrors(ch, c.errorsDesc, fmt.Errorf("failed to retrieve adjtimex stats: %w", err))
+
+		return
+	}
+
+	syncStatus := 1.0
+	if status == unix.TIME_ERROR || tx.Status&unix.STA_UNSYNC != 0 {
+		syncStatus = 0
+	}
+
+	divisor := microSeconds
+	if tx.Status&unix.STA_NANO != 0 {
+		divisor = nanoSeconds
+	}
+
+	ch <- c.syncStatus.mustNewConstMetric(syncStatus)
+	ch <- c.offset.mustNewConstMetric(float64(tx.Offset) / divisor)
+	ch <- c.freq.mustNewConstMetric(1 + float64(tx.Freq)/ppm16frac)
+	// maxerror and esterror are always in microseconds.
+	ch <- c.maxerror.mustNewConstMetric(float64(tx.Maxerror) / microSeconds)
+	ch <- c.esterror.mustNewConstMetric(float64(tx.Esterror) / microSeconds)
+	ch <- c.time.mustNewConstMetric(float64(tx.Time.Sec) + float64(tx.Time.Usec)/divisor)
+}
+
+func (c *timexCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.offset.desc
+	ch <- c.freq.desc
+	ch <- c.maxerror.desc
+	ch <- c.esterror.desc
+	ch <- c.syncStatus.desc
+	ch <- c.time.desc
+	ch <- c.errorsDesc
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !notimex
+// +build !notimex
+
+package collector
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestTimexCollector(t *testing.T) {
+	adjtimexWas := adjtimex
+	defer func() {
+		adjtimex = adjtimexWas
+	}()
+
+	metrics := []string{
+		"node_timex_offset_seconds",
+		"node_timex_frequency_adjustment_ratio",
+		"node_timex_maxerror_seconds",
+		"node_timex_estimated_error_seconds",
+		"node_timex_sync_status",
+		"node_timex_time_seconds",
+	}
+
+	tests := []struct {
+		name string
+		tx   unix.Timex
+		want string
+	}{
+		{
+			name: "synchronized microseconds",
+			tx: unix.Timex{
+				Offset:   -1500,
+				Freq:     -655360,
+				Maxerror: 250000,
+				Esterror: 1000,
+				Status:   unix.STA_PLL,
+				Time:     unix.Timeval{Sec: 1660000000, Usec: 500000},
+			},
+			want: `# HELP node_timex_estimated_error_seconds Estimated error in seconds.
+# TYPE node_timex_estimated_error_seconds gauge
+node_timex_estimated_error_seconds 0.001
+# HELP node_timex_frequency_adjustment_ratio Local clock frequency adjustment.
+# TYPE node_timex_frequency_adjustment_ratio gauge
+node_timex_frequency_adjustment_ratio 0.99999
+# HELP node_timex_maxerror_seconds Maximum error in seconds.
+# TYPE node_timex_maxerror_seconds gauge
+node_timex_maxerror_seconds 0.25
+# HELP node_timex_offset_seconds Time offset in between local system and reference clock.
+# TYPE node_timex_offset_seconds gauge
+node_timex_offset_seconds -0.0015
+# HELP node_timex_sync_status Is clock synchronized to a reliable server (1 = yes, 0 = no).
+# TYPE node_timex_sync_status gauge
+node_timex_sync_status 1
+# HELP node_timex_time_seconds Kernel clock time in seconds since epoch (1970), as reported by adjtimex.
+# TYPE node_timex_time_seconds gauge
+node_timex_time_seconds 1.6600000005e+09
+`,
+		},
+		{
+			name: "unsynchronized nanoseconds",
+			tx: unix.Timex{
+				Offset:   2000000,
+				Maxerror: 16000000,
+				Esterror: 16000000,
+				Status:   unix.STA_UNSYNC | unix.STA_NANO,
+				Time:     unix.Timeval{Sec: 1660000000, Usec: 250000000},
+			},
+			want: `# HELP node_timex_estimated_error_seconds Estimated error in seconds.
+# TYPE node_timex_estimated_error_seconds gauge
+node_timex_estimated_error_seconds 16
+# HELP node_timex_frequency_adjustment_ratio Local clock frequency adjustment.
+# TYPE node_timex_frequency_adjustment_ratio gauge
+node_timex_frequency_adjustment_ratio 1
+# HELP node_timex_maxerror_seconds Maximum error in seconds.
+# TYPE node_timex_maxerror_seconds gauge
+node_timex_maxerror_seconds 16
+# HELP node_timex_offset_seconds Time offset in between local system and reference clock.
+# TYPE node_timex_offset_seconds gauge
+node_timex_offset_seconds 0.002
+# HELP node_timex_sync_status Is clock synchronized to a reliable server (1 = yes, 0 = no).
+# TYPE node_timex_sync_status gauge
+node_timex_sync_status 0
+# HELP node_timex_time_seconds Kernel clock time in seconds since epoch (1970), as reported by adjtimex.
+# TYPE node_timex_time_seconds gauge
+node_timex_time_seconds 1.66000000025e+09
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adjtimex = func(tx *unix.Timex) (int, error) {
+				*tx = tt.tx
+
+				return unix.TIME_OK, nil
+			}
+
+			c, err := NewTimexCollector()
+			require.NoError(t, err)
+			require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(tt.want), metrics...))
+		})
+	}
+}
+
+func TestTimexCollector_Error(t *testing.T) {
+	adjtimexWas := adjtimex
+	defer func() {
+		adjtimex = adjtimexWas
+	}()
+
+	adjtimex = func(tx *unix.Timex) (int, error) {
+		return 0, errors.New("operation not permitted")
+	}
+
+	c, err := NewTimexCollector()
+	require.NoError(t, err)
+	require.Equal(t, 1, testutil.CollectAndCount(c))
+}
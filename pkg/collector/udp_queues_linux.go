@@ -0,0 +1,126 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noudpqueues
+// +build !noudpqueues
+
+package collector
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// socketQueues bytes queued summed over the sockets of a
+// /proc/net/{tcp,udp}{,6} file.
+type socketQueues struct {
+	tx uint64
+	rx uint64
+}
+
+type udpQueuesCollector struct {
+	udpDesc    *prometheus.Desc
+	tcpDesc    *prometheus.Desc
+	errorsDesc *prometheus.Desc
+}
+
+// NewUDPQueuesCollector returns a new Collector exposing the bytes queued
+// in UDP and TCP socket buffers, by IP version.
+func NewUDPQueuesCollector() (prometheus.Collector, error) {
+	return &udpQueuesCollector{
+		udpDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "udp", "queues"),
+			"Number of bytes queued in UDP socket buffers, by IP version and queue.",
+			[]string{"ip", "queue"}, nil,
+		),
+		tcpDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "tcp", "queues"),
+			"Number of bytes queued in TCP socket buffers, by IP version and queue. Listening sockets are excluded from the rx queue.",
+			[]string{"ip", "queue"}, nil,
+		),
+		errorsDesc: newScrapeErrorsDesc("udp_queues"),
+	}, nil
+}
+
+func (c *udpQueuesCollector) Collect(ch chan<- prometheus.Metric) {
+	errs := &multiError{}
+
+	for _, f := range []struct {
+		name string
+		ip   string
+		desc *prometheus.Desc
+	}{
+		{"net/udp", "v4", c.udpDesc},
+		{"net/udp6", "v6", c.udpDesc},
+		{"net/tcp", "v4", c.tcpDesc},
+		{"net/tcp6", "v6", c.tcpDesc},
+	} {
+		queues, err := readSocketQueuesFile(procFilePath(f.name))
+		if err != nil {
+			// If IPv6 is disabled on this kernel, handle it gracefully.
+			if !errors.Is(err, os.ErrNotExist) {
+				errs.Add(f.name, err)
+			}
+
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(f.desc, prometheus.GaugeValue, float64(queues.tx), f.ip, "tx")
+		ch <- prometheus.MustNewConstMetric(f.desc, prometheus.GaugeValue, float64(queues.rx), f.ip, "rx")
+	}
+
+	collectErrors(ch, c.errorsDesc, errs.ErrorOrNil())
+}
+
+func (c *udpQueuesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.udpDesc
+	ch <- c.tcpDesc
+	ch <- c.errorsDesc
+}
+
+func readSocketQueuesFile(path string) (socketQueues, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return socketQueues{}, err
+	}
+	defer f.Close()
+
+	return parseSocketQueues(f)
+}
+
+// parseSocketQueues sums the queues of the sockets listed in r, in
+// /proc/net/{tcp,udp}{,6} format.
+func parseSocketQueues(r io.Reader) (socketQueues, error) {
+	var queues socketQueues
+
+	err := scanProcNetSockets(r, func(socket procNetSocket) error {
+		queues.add(socket)
+
+		return nil
+	})
+
+	return queues, err
+}
+
+func (q *socketQueues) add(socket procNetSocket) {
+	q.tx += socket.txQueue
+
+	// the rx_queue of a listening TCP socket is its accept backlog length,
+	// UDP sockets are never in that state
+	if socket.state != tcpStateListen {
+		q.rx += socket.rxQueue
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noudpqueues
+// +build !noudpqueues
+
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUDPQueuesCollector(t *testing.T) {
+	procPathWas := procPath
+	defer func() {
+		procPath = procPathWas
+	}()
+	procPath = "fixtures/proc"
+
+	c, err := NewUDPQueuesCollector()
+	require.NoError(t, err)
+
+	// no udp6 fixture, v6 UDP series are skipped
+	want := `# HELP node_tcp_queues Number of bytes queued in TCP socket buffers, by IP version and queue. Listening sockets are excluded from the rx queue.
+# TYPE node_tcp_queues gauge
+node_tcp_queues{ip="v4",queue="rx"} 1
+node_tcp_queues{ip="v4",queue="tx"} 42
+node_tcp_queues{ip="v6",queue="rx"} 0
+node_tcp_queues{ip="v6",queue="tx"} 256
+# HELP node_udp_queues Number of bytes queued in UDP socket buffers, by IP version and queue.
+# TYPE node_udp_queues gauge
+node_udp_queues{ip="v4",queue="rx"} 0
+node_udp_queues{ip="v4",queue="tx"} 21
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestParseSocketQueues(t *testing.T) {
+	content := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  123: 00000000:0202 00000000:0000 07 00000000:00000A00 00:00000000 00000000     0        0 18306 2 ffff88007ce0d000 0
+  456: 0100007F:0035 00000000:0000 07 00000010:00000100 00:00000000 00000000   101        0 21346 2 ffff88007b7a5c00 12
+`
+	queues, err := parseSocketQueues(strings.NewReader(content))
+	require.NoError(t, err)
+	require.Equal(t, socketQueues{tx: 0x10, rx: 0xa00 + 0x100}, queues)
+
+	_, err = parseSocketQueues(strings.NewReader(content + "  789: 0100007F:0035 00000000:0000 07 00000010\n"))
+	require.ErrorIs(t, err, ErrParse)
+}
+
+func BenchmarkParseSocketQueues(b *testing.B) {
+	var buf bytes.Buffer
+	buf.WriteString("  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops\n")
+	for i := 0; i < 50000; i++ {
+		fmt.Fprintf(&buf, "%5d: 0100007F:%04X 00000000:0000 07 %08X:%08X 00:00000000 00000000   101        0 21346 2 ffff88007b7a5c00 %d\n",
+			i, i%0xffff, i%512, i%4096, i%7)
+	}
+	content := buf.Bytes()
+	r := bytes.NewReader(content)
+
+	b.SetBytes(int64(len(content)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		r.Reset(content)
+		if _, err := parseSocketQueues(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -35,7 +35,9 @@ var unameDesc = prometheus.NewDesc(
 	nil,
 )
 
-type unameCollector struct{}
+type unameCollector struct {
+	errorsDesc *prometheus.Desc
+}
 type uname struct {
 	SysName    string
 	Release    string
@@ -47,12 +49,13 @@ type uname struct {
 
 // NewUnameCollector returns new unameCollector.
 func NewUnameCollector() (prometheus.Collector, error) {
-	return &unameCollector{}, nil
+	return &unameCollector{errorsDesc: newScrapeErrorsDesc("uname")}, nil
 }
 
 func (c *unameCollector) Collect(ch chan<- prometheus.Metric) {
 	uname, err := getUname()
 	if err != nil {
+		collectErrors(ch, c.errorsDesc, err)
 
 		return
 	}
@@ -69,4 +72,5 @@ func (c *unameCollector) Collect(ch chan<- prometheus.Metric) {
 
 func (c *unameCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- unameDesc
+	ch <- c.errorsDesc
 }
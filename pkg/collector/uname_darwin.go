@@ -0,0 +1,49 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nouname
+// +build !nouname
+
+package collector
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+func getUname() (uname, error) {
+	var utsname unix.Utsname
+	if err := unix.Uname(&utsname); err != nil {
+		return uname{}, err
+	}
+
+	// darwin has no domain name in utsname, nodename is the FQDN
+	nodeName := string(utsname.Nodename[:bytes.IndexByte(utsname.Nodename[:], 0)])
+	domainName := "(none)"
+	if i := strings.IndexByte(nodeName, '.'); i >= 0 {
+		nodeName, domainName = nodeName[:i], nodeName[i+1:]
+	}
+
+	output := uname{
+		SysName:    string(utsname.Sysname[:bytes.IndexByte(utsname.Sysname[:], 0)]),
+		Release:    string(utsname.Release[:bytes.IndexByte(utsname.Release[:], 0)]),
+		Version:    string(utsname.Version[:bytes.IndexByte(utsname.Version[:], 0)]),
+		Machine:    string(utsname.Machine[:bytes.IndexByte(utsname.Machine[:], 0)]),
+		NodeName:   nodeName,
+		DomainName: domainName,
+	}
+
+	return output, nil
+}
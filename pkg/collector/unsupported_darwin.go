@@ -0,0 +1,169 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"flag"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// unsupportedCollector collector without a darwin implementation, it
+// reports no data so that the default watchers still start.
+type unsupportedCollector struct {
+	name string
+}
+
+func newUnsupportedCollector(name string) (prometheus.Collector, error) {
+	return unsupportedCollector{name: name}, nil
+}
+
+func (c unsupportedCollector) Collect(chan<- prometheus.Metric) {
+	zap.S().Debugw("metrics are not available on darwin", "collector", c.name)
+}
+
+func (unsupportedCollector) Describe(chan<- *prometheus.Desc) {}
+
+// NewARPCollector returns a Collector reporting no data.
+func NewARPCollector() (prometheus.Collector, error) { return newUnsupportedCollector("arp") }
+
+// NewBondingCollector returns a Collector reporting no data.
+func NewBondingCollector() (prometheus.Collector, error) { return newUnsupportedCollector("bonding") }
+
+// NewBuddyinfoCollector returns a Collector reporting no data.
+func NewBuddyinfoCollector() (prometheus.Collector, error) {
+	return newUnsupportedCollector("buddyinfo")
+}
+
+// NewCgroupCollector returns a Collector reporting no data.
+func NewCgroupCollector() (prometheus.Collector, error) { return newUnsupportedCollector("cgroup") }
+
+// NewConntrackCollector returns a Collector reporting no data.
+func NewConntrackCollector() (prometheus.Collector, error) {
+	return newUnsupportedCollector("conntrack")
+}
+
+// NewDiskstatsCollector returns a Collector reporting no data.
+func NewDiskstatsCollector() (prometheus.Collector, error) {
+	return newUnsupportedCollector("diskstats")
+}
+
+// NewEntropyCollector returns a Collector reporting no data.
+func NewEntropyCollector() (prometheus.Collector, error) { return newUnsupportedCollector("entropy") }
+
+// NewFileFDStatCollector returns a Collector reporting no data.
+func NewFileFDStatCollector() (prometheus.Collector, error) { return newUnsupportedCollector("filefd") }
+
+// NewHwmonCollector returns a Collector reporting no data.
+func NewHwmonCollector() (prometheus.Collector, error) { return newUnsupportedCollector("hwmon") }
+
+// NewInfiniBandCollector returns a Collector reporting no data.
+func NewInfiniBandCollector() (prometheus.Collector, error) {
+	return newUnsupportedCollector("infiniband")
+}
+
+// NewInterruptsCollector returns a Collector reporting no data.
+func NewInterruptsCollector() (prometheus.Collector, error) {
+	return newUnsupportedCollector("interrupts")
+}
+
+// NewSoftirqsCollector returns a Collector reporting no data.
+func NewSoftirqsCollector() (prometheus.Collector, error) { return newUnsupportedCollector("softirqs") }
+
+// NewIPVSCollector returns a Collector reporting no data.
+func NewIPVSCollector() (prometheus.Collector, error) { return newUnsupportedCollector("ipvs") }
+
+// NewMdadmCollector returns a Collector reporting no data.
+func NewMdadmCollector() (prometheus.Collector, error) { return newUnsupportedCollector("mdadm") }
+
+// NewNetClassCollector returns a Collector reporting no data.
+func NewNetClassCollector() (prometheus.Collector, error) { return newUnsupportedCollector("netclass") }
+
+// NewNetStatCollector returns a Collector reporting no data.
+func NewNetStatCollector() (prometheus.Collector, error) { return newUnsupportedCollector("netstat") }
+
+// NewPowerSupplyCollector returns a Collector reporting no data.
+func NewPowerSupplyCollector() (prometheus.Collector, error) {
+	return newUnsupportedCollector("power_supply")
+}
+
+// NewProcessesCollector returns a Collector reporting no data.
+func NewProcessesCollector() (prometheus.Collector, error) {
+	return newUnsupportedCollector("processes")
+}
+
+// NewRaplCollector returns a Collector reporting no data.
+func NewRaplCollector() (prometheus.Collector, error) { return newUnsupportedCollector("rapl") }
+
+// NewSchedstatCollector returns a Collector reporting no data.
+func NewSchedstatCollector() (prometheus.Collector, error) {
+	return newUnsupportedCollector("schedstat")
+}
+
+// NewSockStatCollector returns a Collector reporting no data.
+func NewSockStatCollector() (prometheus.Collector, error) { return newUnsupportedCollector("sockstat") }
+
+// NewStatCollector returns a Collector reporting no data.
+func NewStatCollector() (prometheus.Collector, error) { return newUnsupportedCollector("stat") }
+
+// NewTCPStatCollector returns a Collector reporting no data.
+func NewTCPStatCollector() (prometheus.Collector, error) { return newUnsupportedCollector("tcpstat") }
+
+// NewThermalZoneCollector returns a Collector reporting no data.
+func NewThermalZoneCollector() (prometheus.Collector, error) {
+	return newUnsupportedCollector("thermal_zone")
+}
+
+// NewTimexCollector returns a Collector reporting no data.
+func NewTimexCollector() (prometheus.Collector, error) { return newUnsupportedCollector("timex") }
+
+// NewUDPQueuesCollector returns a Collector reporting no data.
+func NewUDPQueuesCollector() (prometheus.Collector, error) {
+	return newUnsupportedCollector("udp_queues")
+}
+
+// NewvmStatCollector returns a Collector reporting no data.
+func NewvmStatCollector() (prometheus.Collector, error) { return newUnsupportedCollector("vmstat") }
+
+// NewWifiCollector returns a Collector reporting no data.
+func NewWifiCollector() (prometheus.Collector, error) { return newUnsupportedCollector("wifi") }
+
+// newSyntheticNetClassCollector the synthetic device has no network class
+// info on darwin, like the host's devices.
+func newSyntheticNetClassCollector() prometheus.Collector {
+	c, _ := newUnsupportedCollector("netclass")
+
+	return c
+}
+
+// The flags of the linux only collectors are not defined on darwin.
+
+// DefineFileFDFlags does nothing, the filefd collector is linux only.
+func DefineFileFDFlags(*flag.FlagSet) {}
+
+// DefineInterruptsFlags does nothing, the interrupts collectors are linux only.
+func DefineInterruptsFlags(*flag.FlagSet) {}
+
+// DefineIPVSFlags does nothing, the ipvs collector is linux only.
+func DefineIPVSFlags(*flag.FlagSet) {}
+
+// DefineNetClassFlags does nothing, the netclass collector is linux only.
+func DefineNetClassFlags(*flag.FlagSet) {}
+
+// DefineProcessesFlags does nothing, the processes collector is linux only.
+func DefineProcessesFlags(*flag.FlagSet) {}
+
+// DefineVMStatFlags does nothing, the vmstat collector is linux only.
+func DefineVMStatFlags(*flag.FlagSet) {}
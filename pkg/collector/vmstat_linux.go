@@ -18,7 +18,9 @@ package collector
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"regexp"
 	"strconv"
@@ -29,87 +31,116 @@ import (
 
 const (
 	vmStatSubsystem = "vmstat"
+
+	// defaultVMStatFields default fields exported by the vmstat collector.
+	defaultVMStatFields = "^(pgpgin|pgpgout|pswpin|pswpout|pgfault|pgmajfault|oom_kill)$"
 )
 
 var (
 	// vmStatFields Regexp of fields to return for vmstat collector.
-	vmStatFields = "^(oom_kill|pgpg|pswp|pg.*fault).*"
+	// collector.vmstat.fields
+	vmStatFields = defaultVMStatFields
 )
 
+// DefineVMStatFlags defines the flags of the vmstat collector.
+func DefineVMStatFlags(flags *flag.FlagSet) {
+	flags.StringVar(&vmStatFields, "collector.vmstat.fields", defaultVMStatFields,
+		"Regexp of /proc/vmstat fields exported by the vmstat collector.")
+}
+
 type vmStatCollector struct {
 	fieldPattern *regexp.Regexp
+	errorsDesc   *prometheus.Desc
+}
+
+// vmStatField a single /proc/vmstat field.
+type vmStatField struct {
+	name  string
+	value float64
 }
 
 // NewvmStatCollector returns a new Collector exposing vmstat stats.
 func NewvmStatCollector() (prometheus.Collector, error) {
-	pattern := regexp.MustCompile(vmStatFields)
+	pattern, err := regexp.Compile(vmStatFields)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vmstat fields regexp %q: %w", vmStatFields, err)
+	}
+
 	return &vmStatCollector{
 		fieldPattern: pattern,
+		errorsDesc:   newScrapeErrorsDesc("vmstat"),
 	}, nil
 }
 
 func (c *vmStatCollector) Collect(ch chan<- prometheus.Metric) {
-	file, err := os.Open(procFilePath("vmstat"))
-	if err != nil {
+	fields, err := c.getFields()
+	collectErrors(ch, c.errorsDesc, err)
 
-		return
+	for _, field := range fields {
+		ch <- prometheus.MustNewConstMetric(vmStatDesc(field.name), prometheus.UntypedValue, field.value)
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		parts := strings.Fields(scanner.Text())
-		value, err := strconv.ParseFloat(parts[1], 64)
-		if err != nil {
-
-			return
-		}
-		if !c.fieldPattern.MatchString(parts[0]) {
-			continue
-		}
+}
 
-		ch <- prometheus.MustNewConstMetric(
-			prometheus.NewDesc(
-				prometheus.BuildFQName(namespace, vmStatSubsystem, parts[0]),
-				fmt.Sprintf("/proc/vmstat information field %s.", parts[0]),
-				nil, nil),
-			prometheus.UntypedValue,
-			value,
-		)
+func (c *vmStatCollector) Describe(ch chan<- *prometheus.Desc) {
+	fields, _ := c.getFields()
+	for _, field := range fields {
+		ch <- vmStatDesc(field.name)
 	}
-	if err := scanner.Err(); err != nil {
 
-		return
-	}
+	ch <- c.errorsDesc
 }
 
-func (c *vmStatCollector) Describe(ch chan<- *prometheus.Desc) {
+func vmStatDesc(name string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, vmStatSubsystem, name),
+		fmt.Sprintf("/proc/vmstat information field %s.", name),
+		nil, nil)
+}
+
+func (c *vmStatCollector) getFields() ([]vmStatField, error) {
 	file, err := os.Open(procFilePath("vmstat"))
 	if err != nil {
-
-		return
+		return nil, err
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	return parseVMStat(file, c.fieldPattern)
+}
+
+// parseVMStat returns the fields matching pattern. Malformed lines are
+// skipped and reported in the returned multiError.
+func parseVMStat(r io.Reader, pattern *regexp.Regexp) ([]vmStatField, error) {
+	var (
+		fields []vmStatField
+		errs   = &multiError{}
+	)
+
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		parts := strings.Fields(scanner.Text())
-		_, err := strconv.ParseFloat(parts[1], 64)
-		if err != nil {
+		if len(parts) != 2 {
+			errs.Add("vmstat", fmt.Errorf("invalid line %q: %w", scanner.Text(), ErrParse))
 
-			return
+			continue
 		}
-		if !c.fieldPattern.MatchString(parts[0]) {
+
+		if !pattern.MatchString(parts[0]) {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			errs.Add(parts[0], err)
+
 			continue
 		}
 
-		ch <- prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, vmStatSubsystem, parts[0]),
-			fmt.Sprintf("/proc/vmstat information field %s.", parts[0]),
-			nil, nil)
+		fields = append(fields, vmStatField{name: parts[0], value: value})
 	}
-	if err := scanner.Err(); err != nil {
 
-		return
+	if err := scanner.Err(); err != nil {
+		errs.Add("vmstat", err)
 	}
+
+	return fields, errs.ErrorOrNil()
 }
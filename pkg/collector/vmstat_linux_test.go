@@ -0,0 +1,78 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !novmstat
+// +build !novmstat
+
+package collector
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVMStatCollector(t *testing.T) {
+	procPathWas := procPath
+	defer func() {
+		procPath = procPathWas
+	}()
+	procPath = "fixtures/proc"
+
+	c, err := NewvmStatCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_vmstat_oom_kill /proc/vmstat information field oom_kill.
+# TYPE node_vmstat_oom_kill untyped
+node_vmstat_oom_kill 0
+# HELP node_vmstat_pgfault /proc/vmstat information field pgfault.
+# TYPE node_vmstat_pgfault untyped
+node_vmstat_pgfault 2.320168809e+09
+# HELP node_vmstat_pgmajfault /proc/vmstat information field pgmajfault.
+# TYPE node_vmstat_pgmajfault untyped
+node_vmstat_pgmajfault 507162
+# HELP node_vmstat_pgpgin /proc/vmstat information field pgpgin.
+# TYPE node_vmstat_pgpgin untyped
+node_vmstat_pgpgin 7.344136e+06
+# HELP node_vmstat_pgpgout /proc/vmstat information field pgpgout.
+# TYPE node_vmstat_pgpgout untyped
+node_vmstat_pgpgout 1.541180581e+09
+# HELP node_vmstat_pswpin /proc/vmstat information field pswpin.
+# TYPE node_vmstat_pswpin untyped
+node_vmstat_pswpin 1476
+# HELP node_vmstat_pswpout /proc/vmstat information field pswpout.
+# TYPE node_vmstat_pswpout untyped
+node_vmstat_pswpout 35045
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestVMStatCollectorFields(t *testing.T) {
+	fieldsWas := vmStatFields
+	defer func() {
+		vmStatFields = fieldsWas
+	}()
+
+	vmStatFields = "["
+	_, err := NewvmStatCollector()
+	require.Error(t, err)
+
+	in := "nr_free_pages 10\noom_kill 2\nmalformed\nnr_dirty x\n"
+	fields, err := parseVMStat(strings.NewReader(in), regexp.MustCompile("^(nr_.*|oom_kill)$"))
+	require.Equal(t, []vmStatField{{name: "nr_free_pages", value: 10}, {name: "oom_kill", value: 2}}, fields)
+	require.Error(t, err)
+	require.Equal(t, map[string]int{errReasonParse: 2}, err.(*multiError).reasons())
+}
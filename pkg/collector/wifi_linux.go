@@ -0,0 +1,500 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nowifi
+// +build !nowifi
+
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// wifiNL80211Interfaces returns the wireless interfaces and their
+	// stations through nl80211, overridden by tests.
+	wifiNL80211Interfaces = nl80211Interfaces
+
+	// wifiInterfaceModes names of the nl80211 interface types.
+	wifiInterfaceModes = map[uint32]string{
+		unix.NL80211_IFTYPE_ADHOC:      "ad-hoc",
+		unix.NL80211_IFTYPE_STATION:    "station",
+		unix.NL80211_IFTYPE_AP:         "access point",
+		unix.NL80211_IFTYPE_AP_VLAN:    "access point vlan",
+		unix.NL80211_IFTYPE_WDS:        "wds",
+		unix.NL80211_IFTYPE_MONITOR:    "monitor",
+		unix.NL80211_IFTYPE_MESH_POINT: "mesh point",
+	}
+)
+
+// wifiInterface a wireless interface as reported by nl80211.
+type wifiInterface struct {
+	Name      string
+	Index     uint32
+	Type      uint32
+	Frequency uint32 // MHz
+	Stations  []wifiStation
+}
+
+// wifiStation a station the interface is connected to, i.e. the access
+// point in station mode or the clients in access point mode.
+type wifiStation struct {
+	MAC             net.HardwareAddr
+	Signal          *int8 // dBm
+	ReceiveBitrate  uint64
+	TransmitBitrate uint64
+	ConnectedTime   time.Duration
+	InactiveTime    time.Duration
+	BeaconLoss      uint32
+	TransmitRetries uint32
+	TransmitFailed  uint32
+	ReceiveBytes    uint64
+	TransmitBytes   uint64
+}
+
+// wirelessStats an interface entry of /proc/net/wireless.
+type wirelessStats struct {
+	Name          string
+	LinkQuality   float64
+	Level         float64 // dBm
+	Noise         *float64
+	MissedBeacons uint64
+}
+
+type wifiCollector struct {
+	interfaceFrequency *prometheus.Desc
+	stationInfo        *prometheus.Desc
+	signal             *prometheus.Desc
+	receiveBitrate     *prometheus.Desc
+	transmitBitrate    *prometheus.Desc
+	connectedSeconds   *prometheus.Desc
+	inactiveSeconds    *prometheus.Desc
+	beaconLoss         *prometheus.Desc
+	transmitRetries    *prometheus.Desc
+	transmitFailed     *prometheus.Desc
+	receiveBytes       *prometheus.Desc
+	transmitBytes      *prometheus.Desc
+
+	// from /proc/net/wireless, when nl80211 is not available
+	linkQuality     *prometheus.Desc
+	interfaceSignal *prometheus.Desc
+	interfaceNoise  *prometheus.Desc
+	missedBeacons   *prometheus.Desc
+
+	errorsDesc *prometheus.Desc
+}
+
+// NewWifiCollector returns a new Collector exposing the signal, bitrates and
+// beacon loss of the stations of wireless interfaces, read through nl80211 or
+// /proc/net/wireless. Hosts without wireless interfaces expose no series.
+func NewWifiCollector() (prometheus.Collector, error) {
+	const subsystem = "wifi"
+
+	stationDesc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "station_"+name),
+			help, []string{"device", "mac_address"}, nil,
+		)
+	}
+	interfaceDesc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "interface_"+name),
+			help, []string{"device"}, nil,
+		)
+	}
+
+	return &wifiCollector{
+		interfaceFrequency: interfaceDesc("frequency_hertz", "The current frequency the interface is operating at, in hertz."),
+		stationInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "station_info"),
+			"Stations the interface is connected to, value is always 1.",
+			[]string{"device", "mac_address", "mode"}, nil,
+		),
+		signal:           stationDesc("signal_dbm", "The current signal strength of the station, in dBm."),
+		receiveBitrate:   stationDesc("receive_bitrate_bits_per_second", "The current receive bitrate of the station, in bits per second."),
+		transmitBitrate:  stationDesc("transmit_bitrate_bits_per_second", "The current transmit bitrate of the station, in bits per second."),
+		connectedSeconds: stationDesc("connected_seconds_total", "The total number of seconds the station has been connected."),
+		inactiveSeconds:  stationDesc("inactive_seconds", "The number of seconds since any wireless activity has occurred on the station."),
+		beaconLoss:       stationDesc("beacon_loss_total", "The total number of times the beacon of the station was lost."),
+		transmitRetries:  stationDesc("transmit_retries_total", "The total number of times the station has retried sending a packet."),
+		transmitFailed:   stationDesc("transmit_failed_total", "The total number of times the station failed to send a packet."),
+		receiveBytes:     stationDesc("receive_bytes_total", "The total number of bytes received from the station."),
+		transmitBytes:    stationDesc("transmit_bytes_total", "The total number of bytes transmitted to the station."),
+
+		linkQuality:     interfaceDesc("link_quality", "Link quality of the interface from /proc/net/wireless, only exposed when nl80211 is not available."),
+		interfaceSignal: interfaceDesc("signal_dbm", "Signal level of the interface from /proc/net/wireless in dBm, only exposed when nl80211 is not available."),
+		interfaceNoise:  interfaceDesc("noise_dbm", "Noise level of the interface from /proc/net/wireless in dBm, only exposed when nl80211 is not available."),
+		missedBeacons:   interfaceDesc("beacon_loss_total", "Missed beacons of the interface from /proc/net/wireless, only exposed when nl80211 is not available."),
+
+		errorsDesc: newScrapeErrorsDesc("wifi"),
+	}, nil
+}
+
+func (c *wifiCollector) Collect(ch chan<- prometheus.Metric) {
+	devices, err := wirelessDevices()
+	if err != nil {
+		collectErrors(ch, c.errorsDesc, fmt.Errorf("failed to list wireless interfaces: %w", err))
+
+		return
+	}
+	if len(devices) == 0 {
+		return
+	}
+
+	ifaces, err := wifiNL80211Interfaces()
+	if err == nil {
+		c.collectInterfaces(ch, ifaces)
+
+		return
+	}
+	zap.S().Debugw("could not get wireless stations through nl80211, falling back to /proc/net/wireless", zap.Error(err))
+
+	stats, err := getWirelessStats()
+	if err != nil {
+		collectErrors(ch, c.errorsDesc, fmt.Errorf("failed to get wireless stats: %w", err))
+
+		return
+	}
+	c.collectWirelessStats(ch, stats)
+}
+
+func (c *wifiCollector) collectInterfaces(ch chan<- prometheus.Metric, ifaces []wifiInterface) {
+	for _, iface := range ifaces {
+		if iface.Frequency != 0 {
+			ch <- prometheus.MustNewConstMetric(c.interfaceFrequency, prometheus.GaugeValue,
+				float64(iface.Frequency)*1e6, iface.Name)
+		}
+
+		mode, ok := wifiInterfaceModes[iface.Type]
+		if !ok {
+			mode = "unknown"
+		}
+
+		for _, sta := range iface.Stations {
+			mac := sta.MAC.String()
+
+			ch <- prometheus.MustNewConstMetric(c.stationInfo, prometheus.GaugeValue, 1, iface.Name, mac, mode)
+			if sta.Signal != nil {
+				ch <- prometheus.MustNewConstMetric(c.signal, prometheus.GaugeValue, float64(*sta.Signal), iface.Name, mac)
+			}
+			ch <- prometheus.MustNewConstMetric(c.receiveBitrate, prometheus.GaugeValue, float64(sta.ReceiveBitrate), iface.Name, mac)
+			ch <- prometheus.MustNewConstMetric(c.transmitBitrate, prometheus.GaugeValue, float64(sta.TransmitBitrate), iface.Name, mac)
+			ch <- prometheus.MustNewConstMetric(c.connectedSeconds, prometheus.CounterValue, sta.ConnectedTime.Seconds(), iface.Name, mac)
+			ch <- prometheus.MustNewConstMetric(c.inactiveSeconds, prometheus.GaugeValue, sta.InactiveTime.Seconds(), iface.Name, mac)
+			ch <- prometheus.MustNewConstMetric(c.beaconLoss, prometheus.CounterValue, float64(sta.BeaconLoss), iface.Name, mac)
+			ch <- prometheus.MustNewConstMetric(c.transmitRetries, prometheus.CounterValue, float64(sta.TransmitRetries), iface.Name, mac)
+			ch <- prometheus.MustNewConstMetric(c.transmitFailed, prometheus.CounterValue, float64(sta.TransmitFailed), iface.Name, mac)
+			ch <- prometheus.MustNewConstMetric(c.receiveBytes, prometheus.CounterValue, float64(sta.ReceiveBytes), iface.Name, mac)
+			ch <- prometheus.MustNewConstMetric(c.transmitBytes, prometheus.CounterValue, float64(sta.TransmitBytes), iface.Name, mac)
+		}
+	}
+}
+
+func (c *wifiCollector) collectWirelessStats(ch chan<- prometheus.Metric, stats []wirelessStats) {
+	for _, s := range stats {
+		ch <- prometheus.MustNewConstMetric(c.linkQuality, prometheus.GaugeValue, s.LinkQuality, s.Name)
+		ch <- prometheus.MustNewConstMetric(c.interfaceSignal, prometheus.GaugeValue, s.Level, s.Name)
+		if s.Noise != nil {
+			ch <- prometheus.MustNewConstMetric(c.interfaceNoise, prometheus.GaugeValue, *s.Noise, s.Name)
+		}
+		ch <- prometheus.MustNewConstMetric(c.missedBeacons, prometheus.CounterValue, float64(s.MissedBeacons), s.Name)
+	}
+}
+
+func (c *wifiCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.interfaceFrequency
+	ch <- c.stationInfo
+	ch <- c.signal
+	ch <- c.receiveBitrate
+	ch <- c.transmitBitrate
+	ch <- c.connectedSeconds
+	ch <- c.inactiveSeconds
+	ch <- c.beaconLoss
+	ch <- c.transmitRetries
+	ch <- c.transmitFailed
+	ch <- c.receiveBytes
+	ch <- c.transmitBytes
+	ch <- c.linkQuality
+	ch <- c.interfaceSignal
+	ch <- c.interfaceNoise
+	ch <- c.missedBeacons
+	ch <- c.errorsDesc
+}
+
+// wirelessDevices returns the names of the wireless network interfaces, the
+// ones with a wireless or phy80211 entry in sysfs.
+func wirelessDevices() ([]string, error) {
+	dir := sysFilePath("class/net")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []string
+	for _, entry := range entries {
+		for _, marker := range []string{"wireless", "phy80211"} {
+			if _, err := os.Stat(filepath.Join(dir, entry.Name(), marker)); err == nil {
+				devices = append(devices, entry.Name())
+
+				break
+			}
+		}
+	}
+
+	return devices, nil
+}
+
+func getWirelessStats() ([]wirelessStats, error) {
+	file, err := os.Open(procFilePath("net/wireless"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseWirelessStats(file)
+}
+
+// parseWirelessStats parses /proc/net/wireless:
+//
+//	Inter-| sta-|   Quality        |   Discarded packets               | Missed | WE
+//	 face | tus | link level noise |  nwid  crypt   frag  retry   misc | beacon | 22
+//	 wlan0: 0000   54.  -56.  -256        0      0      0      0      0        0
+func parseWirelessStats(r io.Reader) ([]wirelessStats, error) {
+	var stats []wirelessStats
+
+	scanner := bufio.NewScanner(r)
+	for line := 0; scanner.Scan(); line++ {
+		// two header lines
+		if line < 2 {
+			continue
+		}
+
+		name, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid wireless line %q", ErrParse, scanner.Text())
+		}
+		fields := strings.Fields(rest)
+		if len(fields) < 10 {
+			return nil, fmt.Errorf("%w: invalid wireless line %q", ErrParse, scanner.Text())
+		}
+
+		// quality values end with '.' when updated since the last read
+		values := make([]float64, 3)
+		for i := range values {
+			v, err := strconv.ParseFloat(strings.TrimSuffix(fields[i+1], "."), 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid wireless line %q: %v", ErrParse, scanner.Text(), err)
+			}
+			values[i] = v
+		}
+		missed, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid wireless line %q: %v", ErrParse, scanner.Text(), err)
+		}
+
+		s := wirelessStats{
+			Name:          strings.TrimSpace(name),
+			LinkQuality:   values[0],
+			Level:         values[1],
+			MissedBeacons: missed,
+		}
+		// -256 means the driver doesn't report noise
+		if values[2] != -256 {
+			s.Noise = &values[2]
+		}
+		stats = append(stats, s)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read wireless stats: %w", err)
+	}
+
+	return stats, nil
+}
+
+// nl80211Interfaces returns the wireless interfaces and their stations
+// through the nl80211 generic netlink family.
+func nl80211Interfaces() ([]wifiInterface, error) {
+	conn, err := dialGenl()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	family, err := conn.family("nl80211")
+	if err != nil {
+		return nil, err
+	}
+
+	payloads, err := conn.execute(family, unix.NL80211_CMD_GET_INTERFACE, unix.NLM_F_DUMP, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump nl80211 interfaces: %w", err)
+	}
+	ifaces, err := parseNL80211Interfaces(payloads)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range ifaces {
+		payloads, err := conn.execute(family, unix.NL80211_CMD_GET_STATION, unix.NLM_F_DUMP,
+			newNetlinkAttr(unix.NL80211_ATTR_IFINDEX, nativeUint32Bytes(ifaces[i].Index)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to dump nl80211 stations of %s: %w", ifaces[i].Name, err)
+		}
+		if ifaces[i].Stations, err = parseNL80211Stations(payloads); err != nil {
+			return nil, err
+		}
+	}
+
+	return ifaces, nil
+}
+
+// parseNL80211Interfaces parses the replies of NL80211_CMD_GET_INTERFACE,
+// skipping the wireless devices without a network interface (i.e. P2P).
+func parseNL80211Interfaces(payloads [][]byte) ([]wifiInterface, error) {
+	var ifaces []wifiInterface
+
+	for _, payload := range payloads {
+		attrs, err := parseNetlinkAttrs(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		var iface wifiInterface
+		if v := attrs[unix.NL80211_ATTR_IFNAME]; len(v) > 0 {
+			iface.Name = strings.TrimRight(string(v), "\x00")
+		}
+		if v := attrs[unix.NL80211_ATTR_IFINDEX]; len(v) >= 4 {
+			iface.Index = nativeUint32(v)
+		}
+		if v := attrs[unix.NL80211_ATTR_IFTYPE]; len(v) >= 4 {
+			iface.Type = nativeUint32(v)
+		}
+		if v := attrs[unix.NL80211_ATTR_WIPHY_FREQ]; len(v) >= 4 {
+			iface.Frequency = nativeUint32(v)
+		}
+
+		if iface.Name == "" || iface.Index == 0 {
+			continue
+		}
+		ifaces = append(ifaces, iface)
+	}
+
+	return ifaces, nil
+}
+
+// parseNL80211Stations parses the replies of NL80211_CMD_GET_STATION.
+func parseNL80211Stations(payloads [][]byte) ([]wifiStation, error) {
+	var stations []wifiStation
+
+	for _, payload := range payloads {
+		attrs, err := parseNetlinkAttrs(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		sta := wifiStation{MAC: net.HardwareAddr(attrs[unix.NL80211_ATTR_MAC])}
+
+		info, err := parseNetlinkAttrs(attrs[unix.NL80211_ATTR_STA_INFO])
+		if err != nil {
+			return nil, err
+		}
+		for typ, v := range info {
+			switch typ {
+			case unix.NL80211_STA_INFO_SIGNAL:
+				if len(v) >= 1 {
+					signal := int8(v[0])
+					sta.Signal = &signal
+				}
+			case unix.NL80211_STA_INFO_RX_BITRATE:
+				if sta.ReceiveBitrate, err = parseNL80211Bitrate(v); err != nil {
+					return nil, err
+				}
+			case unix.NL80211_STA_INFO_TX_BITRATE:
+				if sta.TransmitBitrate, err = parseNL80211Bitrate(v); err != nil {
+					return nil, err
+				}
+			case unix.NL80211_STA_INFO_CONNECTED_TIME:
+				if len(v) >= 4 {
+					sta.ConnectedTime = time.Duration(nativeUint32(v)) * time.Second
+				}
+			case unix.NL80211_STA_INFO_INACTIVE_TIME:
+				if len(v) >= 4 {
+					sta.InactiveTime = time.Duration(nativeUint32(v)) * time.Millisecond
+				}
+			case unix.NL80211_STA_INFO_BEACON_LOSS:
+				if len(v) >= 4 {
+					sta.BeaconLoss = nativeUint32(v)
+				}
+			case unix.NL80211_STA_INFO_TX_RETRIES:
+				if len(v) >= 4 {
+					sta.TransmitRetries = nativeUint32(v)
+				}
+			case unix.NL80211_STA_INFO_TX_FAILED:
+				if len(v) >= 4 {
+					sta.TransmitFailed = nativeUint32(v)
+				}
+			}
+		}
+
+		// the 64 bit byte counters are preferred, the 32 bit ones wrap at 4GiB
+		sta.ReceiveBytes = parseNL80211Bytes(info, unix.NL80211_STA_INFO_RX_BYTES64, unix.NL80211_STA_INFO_RX_BYTES)
+		sta.TransmitBytes = parseNL80211Bytes(info, unix.NL80211_STA_INFO_TX_BYTES64, unix.NL80211_STA_INFO_TX_BYTES)
+
+		stations = append(stations, sta)
+	}
+
+	return stations, nil
+}
+
+// parseNL80211Bitrate returns the bitrate in bits per second of the nested
+// rate info attributes b.
+func parseNL80211Bitrate(b []byte) (uint64, error) {
+	rate, err := parseNetlinkAttrs(b)
+	if err != nil {
+		return 0, err
+	}
+
+	// in units of 100kbit/s
+	if v := rate[unix.NL80211_RATE_INFO_BITRATE32]; len(v) >= 4 {
+		return uint64(nativeUint32(v)) * 100 * 1000, nil
+	}
+	if v := rate[unix.NL80211_RATE_INFO_BITRATE]; len(v) >= 2 {
+		return uint64(nativeUint16(v)) * 100 * 1000, nil
+	}
+
+	return 0, nil
+}
+
+func parseNL80211Bytes(info map[uint16][]byte, attr64, attr32 uint16) uint64 {
+	if v := info[attr64]; len(v) >= 8 {
+		return nativeUint64(v)
+	}
+	if v := info[attr32]; len(v) >= 4 {
+		return uint64(nativeUint32(v))
+	}
+
+	return 0
+}
@@ -0,0 +1,260 @@
+// Copyright 2022 Metrika Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nowifi
+// +build !nowifi
+
+package collector
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func nativeUint64Bytes(v uint64) []byte {
+	return append([]byte{}, (*[8]byte)(unsafe.Pointer(&v))[:]...)
+}
+
+// setupWifiFixture creates a sysfs with the given wireless and wired
+// interfaces and a procfs with the given /proc/net/wireless content.
+func setupWifiFixture(t *testing.T, wireless []string, wired []string, procWireless string) {
+	t.Helper()
+
+	sysPathWas, procPathWas := sysPath, procPath
+	t.Cleanup(func() {
+		sysPath, procPath = sysPathWas, procPathWas
+	})
+	sysPath, procPath = t.TempDir(), t.TempDir()
+
+	for _, name := range wireless {
+		require.NoError(t, os.MkdirAll(filepath.Join(sysPath, "class", "net", name, "wireless"), 0o755))
+	}
+	for _, name := range wired {
+		require.NoError(t, os.MkdirAll(filepath.Join(sysPath, "class", "net", name), 0o755))
+	}
+	if procWireless != "" {
+		require.NoError(t, os.MkdirAll(filepath.Join(procPath, "net"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(procPath, "net", "wireless"), []byte(procWireless), 0o644))
+	}
+}
+
+func TestWifiCollector_NL80211(t *testing.T) {
+	setupWifiFixture(t, []string{"wlan0"}, []string{"eth0"}, "")
+
+	nl80211Was := wifiNL80211Interfaces
+	defer func() {
+		wifiNL80211Interfaces = nl80211Was
+	}()
+	signal := int8(-52)
+	wifiNL80211Interfaces = func() ([]wifiInterface, error) {
+		return []wifiInterface{{
+			Name:      "wlan0",
+			Index:     3,
+			Type:      unix.NL80211_IFTYPE_STATION,
+			Frequency: 5180,
+			Stations: []wifiStation{{
+				MAC:             []byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+				Signal:          &signal,
+				ReceiveBitrate:  866700000,
+				TransmitBitrate: 780000000,
+				ConnectedTime:   2 * time.Hour,
+				InactiveTime:    500 * time.Millisecond,
+				BeaconLoss:      3,
+				TransmitRetries: 12,
+				TransmitFailed:  1,
+				ReceiveBytes:    1 << 33,
+				TransmitBytes:   1024,
+			}},
+		}}, nil
+	}
+
+	c, err := NewWifiCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_wifi_interface_frequency_hertz The current frequency the interface is operating at, in hertz.
+# TYPE node_wifi_interface_frequency_hertz gauge
+node_wifi_interface_frequency_hertz{device="wlan0"} 5.18e+09
+# HELP node_wifi_station_beacon_loss_total The total number of times the beacon of the station was lost.
+# TYPE node_wifi_station_beacon_loss_total counter
+node_wifi_station_beacon_loss_total{device="wlan0",mac_address="aa:bb:cc:dd:ee:ff"} 3
+# HELP node_wifi_station_connected_seconds_total The total number of seconds the station has been connected.
+# TYPE node_wifi_station_connected_seconds_total counter
+node_wifi_station_connected_seconds_total{device="wlan0",mac_address="aa:bb:cc:dd:ee:ff"} 7200
+# HELP node_wifi_station_inactive_seconds The number of seconds since any wireless activity has occurred on the station.
+# TYPE node_wifi_station_inactive_seconds gauge
+node_wifi_station_inactive_seconds{device="wlan0",mac_address="aa:bb:cc:dd:ee:ff"} 0.5
+# HELP node_wifi_station_info Stations the interface is connected to, value is always 1.
+# TYPE node_wifi_station_info gauge
+node_wifi_station_info{device="wlan0",mac_address="aa:bb:cc:dd:ee:ff",mode="station"} 1
+# HELP node_wifi_station_receive_bitrate_bits_per_second The current receive bitrate of the station, in bits per second.
+# TYPE node_wifi_station_receive_bitrate_bits_per_second gauge
+node_wifi_station_receive_bitrate_bits_per_second{device="wlan0",mac_address="aa:bb:cc:dd:ee:ff"} 8.667e+08
+# HELP node_wifi_station_receive_bytes_total The total number of bytes received from the station.
+# TYPE node_wifi_station_receive_bytes_total counter
+node_wifi_station_receive_bytes_total{device="wlan0",mac_address="aa:bb:cc:dd:ee:ff"} 8.589934592e+09
+# HELP node_wifi_station_signal_dbm The current signal strength of the station, in dBm.
+# TYPE node_wifi_station_signal_dbm gauge
+node_wifi_station_signal_dbm{device="wlan0",mac_address="aa:bb:cc:dd:ee:ff"} -52
+# HELP node_wifi_station_transmit_bitrate_bits_per_second The current transmit bitrate of the station, in bits per second.
+# TYPE node_wifi_station_transmit_bitrate_bits_per_second gauge
+node_wifi_station_transmit_bitrate_bits_per_second{device="wlan0",mac_address="aa:bb:cc:dd:ee:ff"} 7.8e+08
+# HELP node_wifi_station_transmit_bytes_total The total number of bytes transmitted to the station.
+# TYPE node_wifi_station_transmit_bytes_total counter
+node_wifi_station_transmit_bytes_total{device="wlan0",mac_address="aa:bb:cc:dd:ee:ff"} 1024
+# HELP node_wifi_station_transmit_failed_total The total number of times the station failed to send a packet.
+# TYPE node_wifi_station_transmit_failed_total counter
+node_wifi_station_transmit_failed_total{device="wlan0",mac_address="aa:bb:cc:dd:ee:ff"} 1
+# HELP node_wifi_station_transmit_retries_total The total number of times the station has retried sending a packet.
+# TYPE node_wifi_station_transmit_retries_total counter
+node_wifi_station_transmit_retries_total{device="wlan0",mac_address="aa:bb:cc:dd:ee:ff"} 12
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestWifiCollector_ProcFallback(t *testing.T) {
+	setupWifiFixture(t, []string{"wlan0", "wlan1"}, nil, `Inter-| sta-|   Quality        |   Discarded packets               | Missed | WE
+ face | tus | link level noise |  nwid  crypt   frag  retry   misc | beacon | 22
+ wlan0: 0000   54.  -56.  -256        0      0      0      0      0        7
+ wlan1: 0000   70   -40   -95         0      0      0      0      0        0
+`)
+
+	nl80211Was := wifiNL80211Interfaces
+	defer func() {
+		wifiNL80211Interfaces = nl80211Was
+	}()
+	wifiNL80211Interfaces = func() ([]wifiInterface, error) {
+		return nil, errors.New("generic netlink family \"nl80211\" not found")
+	}
+
+	c, err := NewWifiCollector()
+	require.NoError(t, err)
+
+	want := `# HELP node_wifi_interface_beacon_loss_total Missed beacons of the interface from /proc/net/wireless, only exposed when nl80211 is not available.
+# TYPE node_wifi_interface_beacon_loss_total counter
+node_wifi_interface_beacon_loss_total{device="wlan0"} 7
+node_wifi_interface_beacon_loss_total{device="wlan1"} 0
+# HELP node_wifi_interface_link_quality Link quality of the interface from /proc/net/wireless, only exposed when nl80211 is not available.
+# TYPE node_wifi_interface_link_quality gauge
+node_wifi_interface_link_quality{device="wlan0"} 54
+node_wifi_interface_link_quality{device="wlan1"} 70
+# HELP node_wifi_interface_noise_dbm Noise level of the interface from /proc/net/wireless in dBm, only exposed when nl80211 is not available.
+# TYPE node_wifi_interface_noise_dbm gauge
+node_wifi_interface_noise_dbm{device="wlan1"} -95
+# HELP node_wifi_interface_signal_dbm Signal level of the interface from /proc/net/wireless in dBm, only exposed when nl80211 is not available.
+# TYPE node_wifi_interface_signal_dbm gauge
+node_wifi_interface_signal_dbm{device="wlan0"} -56
+node_wifi_interface_signal_dbm{device="wlan1"} -40
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestWifiCollector_NoWirelessInterface(t *testing.T) {
+	setupWifiFixture(t, nil, []string{"eth0", "lo"}, "")
+
+	nl80211Was := wifiNL80211Interfaces
+	defer func() {
+		wifiNL80211Interfaces = nl80211Was
+	}()
+	wifiNL80211Interfaces = func() ([]wifiInterface, error) {
+		t.Fatal("nl80211 queried without wireless interfaces")
+
+		return nil, nil
+	}
+
+	c, err := NewWifiCollector()
+	require.NoError(t, err)
+	require.Equal(t, 0, testutil.CollectAndCount(c))
+}
+
+func TestParseNL80211Stations(t *testing.T) {
+	rate := func(bitrate32 uint32) []byte {
+		return newNetlinkAttr(unix.NL80211_RATE_INFO_BITRATE32, nativeUint32Bytes(bitrate32))
+	}
+
+	var info []byte
+	for _, attr := range [][]byte{
+		newNetlinkAttr(unix.NL80211_STA_INFO_INACTIVE_TIME, nativeUint32Bytes(1500)),
+		newNetlinkAttr(unix.NL80211_STA_INFO_RX_BYTES, nativeUint32Bytes(10)),
+		newNetlinkAttr(unix.NL80211_STA_INFO_RX_BYTES64, nativeUint64Bytes(1<<40)),
+		newNetlinkAttr(unix.NL80211_STA_INFO_TX_BYTES, nativeUint32Bytes(20)),
+		newNetlinkAttr(unix.NL80211_STA_INFO_SIGNAL, []byte{0xc4}), // -60
+		newNetlinkAttr(unix.NL80211_STA_INFO_RX_BITRATE|unix.NLA_F_NESTED, rate(8667)),
+		newNetlinkAttr(unix.NL80211_STA_INFO_TX_BITRATE|unix.NLA_F_NESTED, rate(1300)),
+		newNetlinkAttr(unix.NL80211_STA_INFO_CONNECTED_TIME, nativeUint32Bytes(60)),
+		newNetlinkAttr(unix.NL80211_STA_INFO_BEACON_LOSS, nativeUint32Bytes(2)),
+		newNetlinkAttr(unix.NL80211_STA_INFO_TX_RETRIES, nativeUint32Bytes(5)),
+		newNetlinkAttr(unix.NL80211_STA_INFO_TX_FAILED, nativeUint32Bytes(4)),
+	} {
+		info = append(info, attr...)
+	}
+
+	payload := append(
+		newNetlinkAttr(unix.NL80211_ATTR_MAC, []byte{0x02, 0, 0, 0, 0, 0x01}),
+		newNetlinkAttr(unix.NL80211_ATTR_STA_INFO|unix.NLA_F_NESTED, info)...,
+	)
+
+	stations, err := parseNL80211Stations([][]byte{payload})
+	require.NoError(t, err)
+	require.Len(t, stations, 1)
+
+	sta := stations[0]
+	require.Equal(t, "02:00:00:00:00:01", sta.MAC.String())
+	require.NotNil(t, sta.Signal)
+	require.Equal(t, int8(-60), *sta.Signal)
+	require.Equal(t, uint64(866700000), sta.ReceiveBitrate)
+	require.Equal(t, uint64(130000000), sta.TransmitBitrate)
+	require.Equal(t, time.Minute, sta.ConnectedTime)
+	require.Equal(t, 1500*time.Millisecond, sta.InactiveTime)
+	require.Equal(t, uint32(2), sta.BeaconLoss)
+	require.Equal(t, uint32(5), sta.TransmitRetries)
+	require.Equal(t, uint32(4), sta.TransmitFailed)
+	require.Equal(t, uint64(1<<40), sta.ReceiveBytes)
+	require.Equal(t, uint64(20), sta.TransmitBytes)
+}
+
+func TestParseNL80211Interfaces(t *testing.T) {
+	var wlan, p2p []byte
+	for _, attr := range [][]byte{
+		newNetlinkAttr(unix.NL80211_ATTR_IFINDEX, nativeUint32Bytes(3)),
+		newNetlinkAttr(unix.NL80211_ATTR_IFNAME, []byte("wlan0\x00")),
+		newNetlinkAttr(unix.NL80211_ATTR_IFTYPE, nativeUint32Bytes(unix.NL80211_IFTYPE_AP)),
+		newNetlinkAttr(unix.NL80211_ATTR_WIPHY_FREQ, nativeUint32Bytes(2412)),
+	} {
+		wlan = append(wlan, attr...)
+	}
+	// P2P devices have no network interface
+	p2p = newNetlinkAttr(unix.NL80211_ATTR_IFTYPE, nativeUint32Bytes(10))
+
+	ifaces, err := parseNL80211Interfaces([][]byte{wlan, p2p})
+	require.NoError(t, err)
+	require.Equal(t, []wifiInterface{{
+		Name:      "wlan0",
+		Index:     3,
+		Type:      unix.NL80211_IFTYPE_AP,
+		Frequency: 2412,
+	}}, ifaces)
+}
+
+func TestParseWirelessStats_Invalid(t *testing.T) {
+	_, err := parseWirelessStats(strings.NewReader("header\nheader\n wlan0: 0000 bad\n"))
+	require.ErrorIs(t, err, ErrParse)
+}